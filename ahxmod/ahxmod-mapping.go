@@ -0,0 +1,111 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package ahxmod
+
+import (
+	"go.mukunda.com/modlib/common"
+	"go.mukunda.com/modlib/modmod"
+)
+
+const effectB = 2
+
+// Amiga channel panning, left-right-right-left, softened for headphones.
+var channelPan = [AhxChannels]int16{16, 48, 48, 16}
+
+// Convert to the common model. Each position becomes a pattern. Instruments get a sample
+// with an approximation of their sound; the full generator settings are in
+// Instrument.Synth. Subsongs and the restart position are not converted.
+func (ahx *AhxModule) ToCommon() *common.Module {
+	m := new(common.Module)
+	m.Source = common.AhxSource
+	h := &ahx.Header
+
+	m.Title = ahx.Title
+	m.StereoMixing = true
+	m.UseInstruments = true
+	m.LinearSlides = false
+	m.GlobalVolume = 128
+	m.MixingVolume = 48
+	m.InitialSpeed = 6
+	// Tempo 125 is one tick per 50 Hz frame.
+	m.InitialTempo = int16(125 * h.SpeedMultiplier())
+	m.PanSeparation = 128
+
+	m.Channels = AhxChannels
+	for _, pan := range channelPan {
+		m.ChannelSettings = append(m.ChannelSettings, common.ChannelSetting{InitialVolume: 64, InitialPan: pan})
+	}
+
+	for i := range ahx.Instruments {
+		ins := &ahx.Instruments[i]
+		name := ""
+		if i < len(ahx.InstrumentNames) {
+			name = ahx.InstrumentNames[i]
+		}
+		m.Samples = append(m.Samples, ins.approximateSample(name))
+
+		ci := common.Instrument{
+			Name:         name,
+			GlobalVolume: 128,
+			DefaultPan:   32,
+			Envelopes:    []common.Envelope{ins.volumeEnvelope()},
+			Synth:        ins.synthPatch(),
+		}
+		for note := range ci.Notemap {
+			ci.Notemap[note] = common.NotemapEntry{Note: int16(note), Sample: int16(i + 1)}
+		}
+		m.Instruments = append(m.Instruments, ci)
+	}
+
+	for i := range ahx.Positions {
+		m.Order = append(m.Order, int16(i))
+		m.Patterns = append(m.Patterns, ahx.positionPattern(&ahx.Positions[i]))
+	}
+
+	return m
+}
+
+// Build the pattern for a position from its tracks.
+func (ahx *AhxModule) positionPattern(pos *AhxPosition) common.Pattern {
+	p := common.Pattern{Channels: AhxChannels, Rows: make([]common.PatternRow, ahx.Header.TrackLength)}
+	for ch := range AhxChannels {
+		if int(pos.Track[ch]) >= len(ahx.Tracks) {
+			continue
+		}
+		for row, step := range ahx.Tracks[pos.Track[ch]] {
+			entry := common.PatternEntry{Channel: uint8(ch), Instrument: int16(step.Instrument)}
+			if step.Note != 0 {
+				entry.Note = translateNote(int(step.Note) + int(pos.Transpose[ch]))
+			}
+			translateEffect(&entry, step.Effect, step.Param)
+			p.Rows[row].Set(entry)
+		}
+	}
+	return p
+}
+
+// AHX notes are 1-60 starting at C-1.
+func translateNote(note int) uint8 {
+	return uint8(min(max(note, 1), 60) + 12)
+}
+
+// AHX effects mostly follow ProTracker. The filter and square wave effects (4xx, 9xx) have
+// no equivalent and are dropped.
+func translateEffect(entry *common.PatternEntry, effect, param uint8) {
+	switch effect {
+	case 0x0, 0x4, 0x9:
+		return
+	case 0xB:
+		// The position is decimal.
+		entry.Effect, entry.EffectParam = effectB, param>>4*10+param&15
+		return
+	case 0xC:
+		// Higher values set the instrument and master volumes.
+		if param > 0x40 {
+			return
+		}
+	}
+	modmod.TranslateEffect(entry, effect, param)
+}
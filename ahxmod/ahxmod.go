@@ -0,0 +1,254 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+/*
+This package is for working with AHX (Abyss' Highest eXperience) files directly. AHX songs
+have no samples; instruments are waveform generators.
+*/
+package ahxmod
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// This is used to read AHX files.
+type AhxReader struct {
+	// Enable extra checks that will cause loading errors if incorrect or corrupted data is
+	// detected.
+	Strict bool
+}
+
+// Holds all components of an AHX file.
+type AhxModule struct {
+	Header AhxModuleHeader
+
+	Subsongs    []uint16 // Starting positions
+	Positions   []AhxPosition
+	Tracks      [][]AhxStep // [track][row]
+	Instruments []AhxInstrument
+
+	Title           string
+	InstrumentNames []string
+}
+
+// The direct structure of the main AHX file header.
+type AhxModuleHeader struct {
+	FileCode        [3]byte // "THX"
+	Version         uint8   // 0 or 1
+	NamesOffset     uint16
+	Flags           uint16 // Bit 15: track 0 isn't stored, bits 13-14: speed multiplier - 1, bits 0-11: position count
+	Restart         uint16
+	TrackLength     uint8 // Rows in each track
+	HighestTrack    uint8
+	InstrumentCount uint8
+	SubsongCount    uint8
+}
+
+// Number of positions in the song.
+func (h *AhxModuleHeader) PositionCount() int {
+	return int(h.Flags & 0xFFF)
+}
+
+// Ticks per 50 Hz frame.
+func (h *AhxModuleHeader) SpeedMultiplier() int {
+	return int(h.Flags>>13&3) + 1
+}
+
+// True if track 0 is left out of the file and is empty.
+func (h *AhxModuleHeader) Track0Empty() bool {
+	return h.Flags&0x8000 != 0
+}
+
+// AHX songs always have four channels.
+const AhxChannels = 4
+
+// A position plays one track on each channel, with a transpose.
+type AhxPosition struct {
+	Track     [AhxChannels]uint8
+	Transpose [AhxChannels]int8
+}
+
+// One row of a track, unpacked from 3 bytes.
+type AhxStep struct {
+	Note       uint8 // 1-60, 0 = none
+	Instrument uint8
+	Effect     uint8
+	Param      uint8
+}
+
+// The direct structure of an instrument header. The performance list follows it in the
+// file.
+type AhxInstrumentHeader struct {
+	Volume        uint8
+	FilterWave    uint8 // Bits 3-7: filter speed (low bits), bits 0-2: wave length
+	AttackFrames  uint8
+	AttackVolume  uint8
+	DecayFrames   uint8
+	DecayVolume   uint8
+	SustainFrames uint8
+	ReleaseFrames uint8
+	ReleaseVolume uint8
+	_             [3]byte
+	FilterLower   uint8 // Bit 7: filter speed bit 5
+	VibratoDelay  uint8
+	HardCut       uint8 // Bit 7: hard cut release, bits 4-6: frames, bits 0-3: vibrato depth
+	VibratoSpeed  uint8
+	SquareLower   uint8
+	SquareUpper   uint8
+	SquareSpeed   uint8
+	FilterUpper   uint8
+	PListSpeed    uint8
+	PListLength   uint8
+}
+
+// An instrument and its performance list.
+type AhxInstrument struct {
+	Header AhxInstrumentHeader
+	PList  []AhxPListEntry
+}
+
+// A performance list entry, unpacked from 4 bytes.
+type AhxPListEntry struct {
+	Effects  [2]uint8
+	Params   [2]uint8
+	Waveform uint8 // 0 = keep, 1 = triangle, 2 = sawtooth, 3 = square, 4 = noise
+	Fixed    bool
+	Note     uint8
+}
+
+var ErrInvalidSource = errors.New("invalid/corrupted source")
+var ErrUnsupportedSource = errors.New("unsupported source")
+
+// Load an AHX file into memory.
+func LoadAhxFile(filename string) (*AhxModule, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	reader := AhxReader{}
+
+	return reader.ReadAhxModule(f)
+}
+
+// Load an AHX file into memory from the given stream.
+func (reader *AhxReader) ReadAhxModule(r io.Reader) (*AhxModule, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	br := bytes.NewReader(data)
+
+	ahx := new(AhxModule)
+	h := &ahx.Header
+	if err := binary.Read(br, binary.BigEndian, h); err != nil {
+		return nil, err
+	}
+
+	if string(h.FileCode[:]) == "HVL" {
+		return nil, fmt.Errorf("%w: HivelyTracker modules aren't supported", ErrUnsupportedSource)
+	}
+	if string(h.FileCode[:]) != "THX" {
+		return nil, fmt.Errorf("%w: expected 'THX' header", ErrInvalidSource)
+	}
+	if h.Version > 1 {
+		return nil, fmt.Errorf("%w: AHX version %d", ErrUnsupportedSource, h.Version)
+	}
+
+	ahx.Subsongs = make([]uint16, h.SubsongCount)
+	if err := binary.Read(br, binary.BigEndian, ahx.Subsongs); err != nil {
+		return ahx, err
+	}
+
+	ahx.Positions = make([]AhxPosition, h.PositionCount())
+	for i := range ahx.Positions {
+		var raw [AhxChannels * 2]byte
+		if _, err := io.ReadFull(br, raw[:]); err != nil {
+			return ahx, err
+		}
+		for ch := range AhxChannels {
+			ahx.Positions[i].Track[ch] = raw[ch*2]
+			ahx.Positions[i].Transpose[ch] = int8(raw[ch*2+1])
+		}
+	}
+
+	for i := 0; i <= int(h.HighestTrack); i++ {
+		track := make([]AhxStep, h.TrackLength)
+		if i == 0 && h.Track0Empty() {
+			ahx.Tracks = append(ahx.Tracks, track)
+			continue
+		}
+		for row := range track {
+			var raw [3]byte
+			if _, err := io.ReadFull(br, raw[:]); err != nil {
+				return ahx, err
+			}
+			track[row] = AhxStep{
+				Note:       raw[0] >> 2 & 0x3F,
+				Instrument: (raw[0]&3)<<4 | raw[1]>>4,
+				Effect:     raw[1] & 15,
+				Param:      raw[2],
+			}
+		}
+		ahx.Tracks = append(ahx.Tracks, track)
+	}
+
+	for range int(h.InstrumentCount) {
+		var ins AhxInstrument
+		if err := binary.Read(br, binary.BigEndian, &ins.Header); err != nil {
+			return ahx, err
+		}
+		for range int(ins.Header.PListLength) {
+			var raw [4]byte
+			if _, err := io.ReadFull(br, raw[:]); err != nil {
+				return ahx, err
+			}
+			ins.PList = append(ins.PList, AhxPListEntry{
+				Effects:  [2]uint8{plistEffect(raw[0] >> 2 & 7), plistEffect(raw[0] >> 5 & 7)},
+				Params:   [2]uint8{raw[2], raw[3]},
+				Waveform: (raw[0]<<1)&6 | raw[1]>>7,
+				Fixed:    raw[1]>>6&1 != 0,
+				Note:     raw[1] & 0x3F,
+			})
+		}
+		ahx.Instruments = append(ahx.Instruments, ins)
+	}
+
+	if int(h.NamesOffset) < len(data) {
+		names := bytes.Split(data[h.NamesOffset:], []byte{0})
+		if len(names) > 0 {
+			ahx.Title = string(names[0])
+			names = names[1:]
+		}
+		for i := range ahx.Instruments {
+			name := ""
+			if i < len(names) {
+				name = string(names[i])
+			}
+			ahx.InstrumentNames = append(ahx.InstrumentNames, name)
+		}
+	} else if reader.Strict {
+		return ahx, fmt.Errorf("%w: strict - name table is past the end of the file", ErrInvalidSource)
+	}
+
+	return ahx, nil
+}
+
+// Performance list effects are stored in 3 bits, with 6 and 7 standing for C and F.
+func plistEffect(code uint8) uint8 {
+	switch code {
+	case 6:
+		return 0xC
+	case 7:
+		return 0xF
+	}
+	return code
+}
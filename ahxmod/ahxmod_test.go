@@ -0,0 +1,116 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package ahxmod
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mukunda.com/modlib/common"
+)
+
+// Build a small AHX with two positions, two tracks, and one instrument.
+func buildTestAhx() []byte {
+	var buf bytes.Buffer
+	header := AhxModuleHeader{
+		FileCode:        [3]byte{'T', 'H', 'X'},
+		Flags:           0x8000 | 1<<13 | 2, // Track 0 empty, 2x speed, 2 positions
+		TrackLength:     4,
+		HighestTrack:    1,
+		InstrumentCount: 1,
+		SubsongCount:    1,
+	}
+	binary.Write(&buf, binary.BigEndian, header)
+	binary.Write(&buf, binary.BigEndian, uint16(1)) // Subsong
+
+	buf.Write([]byte{1, 0, 0, 0, 1, 0xFE, 0, 0}) // Position 0: track 1, track 1 down 2
+	buf.Write([]byte{0, 0, 0, 0, 0, 0, 0, 0})
+
+	// Track 1: C-2 with instrument 1 and C20, then a break to row 2 (D02).
+	buf.Write([]byte{13<<2 | 0, 1<<4 | 0xC, 0x20})
+	buf.Write([]byte{0, 0xD, 0x02})
+	buf.Write([]byte{0, 0, 0})
+	buf.Write([]byte{0, 0, 0})
+
+	ins := AhxInstrumentHeader{
+		Volume:        48,
+		FilterWave:    3,
+		AttackFrames:  2,
+		AttackVolume:  64,
+		DecayFrames:   4,
+		DecayVolume:   32,
+		SustainFrames: 10,
+		ReleaseFrames: 8,
+		ReleaseVolume: 0,
+		SquareLower:   16,
+		PListSpeed:    3,
+		PListLength:   2,
+	}
+	binary.Write(&buf, binary.BigEndian, ins)
+	// Keep waveform with effect F, then a fixed note 24 with the square wave.
+	buf.Write([]byte{7 << 5, 0, 0x12, 0})
+	buf.Write([]byte{3 >> 1, 1<<7 | 1<<6 | 24, 0, 0})
+
+	data := buf.Bytes()
+	binary.BigEndian.PutUint16(data[4:], uint16(len(data)))
+	data = append(data, "ahx test\x00square\x00"...)
+	return data
+}
+
+func TestLoading(t *testing.T) {
+	reader := AhxReader{Strict: true}
+	ahx, err := reader.ReadAhxModule(bytes.NewReader(buildTestAhx()))
+	assert.NoError(t, err)
+
+	assert.Equal(t, 2, ahx.Header.SpeedMultiplier())
+	assert.Equal(t, []uint16{1}, ahx.Subsongs)
+	assert.Len(t, ahx.Tracks, 2)
+	assert.Equal(t, AhxStep{Note: 13, Instrument: 1, Effect: 0xC, Param: 0x20}, ahx.Tracks[1][0])
+	assert.Equal(t, AhxPListEntry{Effects: [2]uint8{0, 0xF}, Params: [2]uint8{0x12, 0}}, ahx.Instruments[0].PList[0])
+	assert.Equal(t, AhxPListEntry{Waveform: 3, Fixed: true, Note: 24}, ahx.Instruments[0].PList[1])
+	assert.Equal(t, "ahx test", ahx.Title)
+	assert.Equal(t, []string{"square"}, ahx.InstrumentNames)
+
+	mod := ahx.ToCommon()
+	assert.Equal(t, common.AhxSource, mod.Source)
+	assert.EqualValues(t, 250, mod.InitialTempo)
+	assert.Equal(t, []int16{0, 1}, mod.Order)
+
+	assert.Equal(t, []common.PatternEntry{
+		{Channel: 0, Note: 25, Instrument: 1, VolumeCommand: common.VcmdSetVolume, VolumeParam: 0x20},
+		{Channel: 2, Note: 23, Instrument: 1, VolumeCommand: common.VcmdSetVolume, VolumeParam: 0x20},
+	}, mod.Patterns[0].Rows[0].Entries)
+	assert.EqualValues(t, 3, mod.Patterns[0].Rows[1].Entries[0].Effect)
+	assert.Empty(t, mod.Patterns[1].Rows[0].Entries)
+
+	ins := mod.Instruments[0]
+	assert.Equal(t, "square", ins.Name)
+	assert.EqualValues(t, 1, ins.Notemap[60].Sample)
+	assert.Equal(t, []common.EnvelopeNode{
+		{X: 0, Y: 0}, {X: 2, Y: 64}, {X: 6, Y: 32}, {X: 16, Y: 32}, {X: 24, Y: 0},
+	}, ins.Envelopes[0].Nodes)
+	assert.EqualValues(t, 3, ins.Synth.PerformanceSpeed)
+	assert.Len(t, ins.Synth.Performance, 2)
+	assert.EqualValues(t, 36, ins.Synth.Performance[1].Note, "fixed notes are converted like pattern notes")
+
+	// The sample is the first tick: one cycle of the square wave at wave length 3, with a
+	// 16/64 duty cycle, through the lowpass filter set by the first step (012 = filter 18).
+	s := mod.Samples[0]
+	assert.EqualValues(t, 48, s.GlobalVolume)
+	assert.True(t, s.Loop)
+	pcm := s.Data.Data[0].([]int8)
+	assert.Len(t, pcm, 32)
+	assert.EqualValues(t, -128, pcm[7])
+	assert.EqualValues(t, -59, pcm[8], "the edge is smoothed")
+	assert.EqualValues(t, 127, pcm[11])
+}
+
+func TestHvlRejected(t *testing.T) {
+	reader := AhxReader{}
+	_, err := reader.ReadAhxModule(bytes.NewReader(append([]byte("HVL\x00"), make([]byte, 20)...)))
+	assert.ErrorIs(t, err, ErrUnsupportedSource)
+}
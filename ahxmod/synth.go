@@ -0,0 +1,93 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package ahxmod
+
+import "go.mukunda.com/modlib/common"
+
+// Sample rate of a waveform at C-5. Paula plays C-1 with period 3424 on a PAL Amiga, and
+// C-5 is 4 octaves higher.
+const c5Speed = 3546895 * 16 / 3424
+
+// A sample that approximates the instrument: the waveform of its first tick, looped.
+// Players that support Instrument.Synth run the whole performance list instead.
+func (ins *AhxInstrument) approximateSample(name string) common.Sample {
+	h := &ins.Header
+	synth := common.NewSynthVoice(ins.synthPatch(), 60, int(min(h.Volume, 64)))
+	synth.Tick()
+	pcm := synth.Waveform()
+
+	return common.Sample{
+		Name:          name,
+		GlobalVolume:  int16(min(h.Volume, 64)),
+		DefaultVolume: 64,
+		Loop:          true,
+		LoopEnd:       len(pcm),
+		C5:            c5Speed,
+		Data:          common.SampleData{Channels: 1, Bits: 8, Data: []any{pcm}},
+	}
+}
+
+// Convert the ADSR envelope to a volume envelope. It runs without waiting for a note-off,
+// like AHX.
+func (ins *AhxInstrument) volumeEnvelope() common.Envelope {
+	h := &ins.Header
+	env := common.Envelope{Enabled: true, Type: common.EnvelopeTypeVolume}
+	tick := int16(0)
+	add := func(ticks uint8, volume uint8) {
+		tick += int16(ticks)
+		env.Nodes = append(env.Nodes, common.EnvelopeNode{X: tick, Y: int16(min(volume, 64))})
+	}
+	add(0, 0)
+	add(max(h.AttackFrames, 1), h.AttackVolume)
+	add(max(h.DecayFrames, 1), h.DecayVolume)
+	add(max(h.SustainFrames, 1), h.DecayVolume)
+	add(max(h.ReleaseFrames, 1), h.ReleaseVolume)
+	return env
+}
+
+// The generator settings for the common model.
+func (ins *AhxInstrument) synthPatch() *common.SynthPatch {
+	h := &ins.Header
+	patch := &common.SynthPatch{
+		WaveLength:          int16(h.FilterWave & 7),
+		AttackTicks:         int16(h.AttackFrames),
+		AttackVolume:        int16(h.AttackVolume),
+		DecayTicks:          int16(h.DecayFrames),
+		DecayVolume:         int16(h.DecayVolume),
+		SustainTicks:        int16(h.SustainFrames),
+		ReleaseTicks:        int16(h.ReleaseFrames),
+		ReleaseVolume:       int16(h.ReleaseVolume),
+		FilterLower:         int16(h.FilterLower & 0x7F),
+		FilterUpper:         int16(h.FilterUpper & 0x3F),
+		FilterSpeed:         int16(h.FilterWave>>3&0x1F | h.FilterLower>>2&0x20),
+		SquareLower:         int16(h.SquareLower),
+		SquareUpper:         int16(h.SquareUpper),
+		SquareSpeed:         int16(h.SquareSpeed),
+		VibratoDelay:        int16(h.VibratoDelay),
+		VibratoDepth:        int16(h.HardCut & 15),
+		VibratoSpeed:        int16(h.VibratoSpeed),
+		HardCutRelease:      h.HardCut&0x80 != 0,
+		HardCutReleaseTicks: int16(h.HardCut >> 4 & 7),
+		PerformanceSpeed:    int16(h.PListSpeed),
+	}
+	for _, entry := range ins.PList {
+		// Relative notes start at 1 for the played note.
+		note := int16(entry.Note) - 1
+		if entry.Fixed {
+			note = int16(translateNote(int(entry.Note)))
+		}
+		if entry.Note == 0 {
+			note = 0
+		}
+		patch.Performance = append(patch.Performance, common.SynthStep{
+			Note:     note,
+			Fixed:    entry.Fixed,
+			Waveform: int16(entry.Waveform),
+			Effects:  entry.Effects,
+			Params:   entry.Params,
+		})
+	}
+	return patch
+}
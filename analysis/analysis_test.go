@@ -0,0 +1,397 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package analysis
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mukunda.com/modlib/common"
+	"go.mukunda.com/modlib/itmod"
+	"go.mukunda.com/modlib/render"
+)
+
+// A mono 16-bit sine wave sample.
+func sineSample(length int, period float64) common.Sample {
+	data := make([]float64, length)
+	for i := range data {
+		data[i] = 0.5 * math.Sin(2*math.Pi*float64(i)/period)
+	}
+	sd, _ := common.FromFloat64([][]float64{data}, 16)
+	return common.Sample{C5: 8363, Data: sd, S16: true}
+}
+
+func TestFindLoop(t *testing.T) {
+	s := sineSample(1000, 50)
+
+	candidates := FindLoop(&s)
+	assert.NotEmpty(t, candidates)
+
+	best := candidates[0]
+	assert.Equal(t, 0, (best.End-best.Start)%50, "loop should be a multiple of the period")
+	assert.Less(t, best.Score, 1e-6)
+
+	best.Apply(&s)
+	assert.True(t, s.Loop)
+	assert.Equal(t, best.Start, s.LoopStart)
+	assert.Equal(t, best.End, s.LoopEnd)
+
+	short := sineSample(10, 50)
+	assert.Empty(t, FindLoop(&short))
+}
+
+func TestExtractAutomation(t *testing.T) {
+	rows := make([]common.PatternRow, 4)
+	rows[1].Entries = []common.PatternEntry{{Channel: 0, Effect: 20, EffectParam: 0x60}} // T60
+	rows[2].Entries = []common.PatternEntry{{Channel: 0, Effect: 22, EffectParam: 0x40}} // V40
+	m := &common.Module{
+		GlobalVolume: 128,
+		MixingVolume: 128,
+		InitialSpeed: 6,
+		InitialTempo: 125,
+		Channels:     1,
+		Order:        []int16{0},
+		Patterns:     []common.Pattern{{Channels: 1, Rows: rows}},
+	}
+
+	auto := ExtractAutomation(m)
+	assert.Equal(t, []AutomationPoint{{0, 1}, {276250 * time.Microsecond, 0.5}}, auto.GlobalVolume)
+	assert.Equal(t, []AutomationPoint{{0, 125}, {120 * time.Millisecond, 96}}, auto.Tempo)
+	assert.Equal(t, 588750*time.Microsecond, auto.Duration)
+}
+
+func TestExtractFeatures(t *testing.T) {
+	rows := make([]common.PatternRow, 4)
+	rows[0].Entries = []common.PatternEntry{
+		{Channel: 0, Note: 61, Instrument: 1, Effect: 8, EffectParam: 0x44}, // Hxx
+		{Channel: 1, Note: 65, Instrument: 1, VolumeCommand: common.VcmdSetVolume, VolumeParam: 32},
+	}
+	rows[2].Entries = []common.PatternEntry{{Channel: 0, Note: 255}}
+	m := &common.Module{
+		GlobalVolume: 128,
+		MixingVolume: 128,
+		InitialSpeed: 6,
+		InitialTempo: 125,
+		Channels:     2,
+		Order:        []int16{0, 0},
+		Patterns:     []common.Pattern{{Channels: 2, Rows: rows}},
+		Samples:      []common.Sample{sineSample(8363, 50), {}},
+	}
+
+	f := ExtractFeatures(m)
+	assert.Equal(t, FeaturesVersion, f.Version)
+	assert.InDelta(t, 0.96, f.Duration, 1e-9) // 8 rows of 6 ticks at 20ms.
+	assert.InDelta(t, 125, f.Tempo, 1e-9)
+	assert.InDelta(t, 6, f.Speed, 1e-9)
+	assert.InDelta(t, 8/0.96, f.RowsPerSecond, 1e-9)
+	assert.InDelta(t, 4/0.96, f.NoteDensity, 1e-9)
+	assert.InDelta(t, 0.5, f.NotesPerRow, 1e-9)
+	assert.Equal(t, 2, f.ChannelsUsed)
+	assert.InDelta(t, 62, f.PitchMean, 1e-9)
+	assert.InDelta(t, 2, f.PitchSpread, 1e-9)
+	assert.InDelta(t, 1/3.0, f.EffectUsage[7], 1e-9)
+	assert.InDelta(t, 1/3.0, f.VolumeCommandUsage[0], 1e-9)
+
+	assert.Equal(t, 1, f.Samples)
+	assert.InDelta(t, 1, f.SampleSeconds, 1e-9)
+	freq := 8363 / 50.0
+	assert.InDelta(t, freq, f.SpectralCentroid, 10)
+	assert.InDelta(t, freq, f.SpectralRolloff, 10)
+	assert.Less(t, f.SpectralFlatness, 0.01)
+	assert.InDelta(t, freq*2, f.ZeroCrossingRate, 2)
+
+	assert.Equal(t, Features{Version: FeaturesVersion}, ExtractFeatures(&common.Module{InitialSpeed: 6, InitialTempo: 125}))
+}
+
+func TestFingerprint(t *testing.T) {
+	rows := make([]common.PatternRow, 2)
+	rows[0].Entries = []common.PatternEntry{{Channel: 0, Note: 61, Instrument: 1}}
+	sd, _ := common.FromFloat64([][]float64{{0, 0.5, -0.5, 0.25}}, 8)
+	m := &common.Module{
+		Title:    "song",
+		Order:    []int16{1, 254, 1, 255, 0},
+		Patterns: []common.Pattern{{}, {Rows: rows}},
+		Samples:  []common.Sample{{Name: "a", Data: sd}, {Name: "empty"}},
+	}
+	f := ComputeFingerprint(m)
+	assert.Len(t, f.Samples, 1)
+
+	// Renumbered patterns, new names and 16-bit samples don't matter.
+	dup := m.Clone()
+	dup.Title = "renamed"
+	dup.Order = []int16{0, 0}
+	dup.Patterns = dup.Patterns[1:]
+	dup.Patterns[0].Rows[0].Entries[0].Instrument = 2
+	dup.Samples = []common.Sample{{}, {Name: "b"}}
+	dup.Samples[1].Data, _ = common.FromFloat64(sd.Float64(), 16)
+	other := ComputeFingerprint(dup)
+	assert.Equal(t, f, other)
+	assert.Equal(t, 1.0, f.Similarity(&other))
+
+	dup.Patterns[0].Rows[1].Entries = []common.PatternEntry{{Channel: 1, Effect: 1, EffectParam: 3}}
+	other = ComputeFingerprint(dup)
+	assert.NotEqual(t, f.Song, other.Song)
+	assert.Equal(t, 0.5, f.Similarity(&other))
+
+	dup.Samples = append(dup.Samples, sineSample(100, 10))
+	other = ComputeFingerprint(dup)
+	assert.Equal(t, 0.25, f.Similarity(&other))
+}
+
+func TestExtractText(t *testing.T) {
+	m := &common.Module{
+		Source:      common.S3mSource,
+		Title:       "Na\x8Bve Song\x00\x00",
+		Message:     "\xC9\xCD\xBB\rby Someone\r\x00",
+		Samples:     []common.Sample{{Name: "  "}, {Name: "Bass Drum"}},
+		Instruments: []common.Instrument{{Name: "\xB0\xB1 Lead 2 \xB1\xB0"}},
+	}
+	text := ExtractText(m)
+	assert.Equal(t, ModuleText{
+		Title:       "Naïve Song",
+		Samples:     []string{"Bass Drum"},
+		Instruments: []string{"░▒ Lead 2 ▒░"},
+		Message:     "╔═╗\nby Someone",
+		Charset:     "CP437",
+	}, text)
+	assert.Equal(t, []string{"2", "bass", "by", "drum", "lead", "naïve", "someone", "song"}, text.Terms())
+}
+
+func TestCompareAudio(t *testing.T) {
+	itm, err := itmod.LoadITFile("../itmod/test/reflection.it")
+	assert.NoError(t, err)
+	m := itm.ToCommon()
+
+	reference := ProfileModule(m, render.DefaultSampleRate)
+	assert.Greater(t, reference.Duration, 1.0)
+	assert.Len(t, reference.Bands, len(reference.RMS))
+
+	result, err := CompareAudio(&reference, &reference, DefaultTolerance)
+	assert.NoError(t, err)
+	assert.Equal(t, AudioComparison{Pass: true}, result)
+
+	// A reference rendered at another rate and stored as a 16-bit WAV.
+	path := filepath.Join(t.TempDir(), "reference.wav")
+	f, err := os.Create(path)
+	assert.NoError(t, err)
+	wav, err := render.NewWavWriter(f, 48000, 2)
+	assert.NoError(t, err)
+	p := render.NewPlayer(m, 48000)
+	buffer := make([]int16, 4096*2)
+	for n := p.RenderInt16(buffer); n > 0; n = p.RenderInt16(buffer) {
+		assert.NoError(t, wav.WriteInt16(buffer[:n*2]))
+	}
+	assert.NoError(t, wav.Close())
+	f.Close()
+
+	f, err = os.Open(path)
+	assert.NoError(t, err)
+	defer f.Close()
+	wavProfile, err := ProfileWav(f)
+	assert.NoError(t, err)
+	result, err = CompareAudio(&wavProfile, &reference, DefaultTolerance)
+	assert.NoError(t, err)
+	assert.True(t, result.Pass, "%+v", result)
+	assert.InDelta(t, 0, result.DurationDifference, 0.01)
+
+	// Half volume is about 6 dB quieter.
+	m.GlobalVolume /= 2
+	quiet := ProfileModule(m, render.DefaultSampleRate)
+	result, err = CompareAudio(&quiet, &reference, DefaultTolerance)
+	assert.NoError(t, err)
+	assert.False(t, result.Pass)
+	assert.InDelta(t, 6, result.RMSError, 1.5)
+
+	quiet.Version = 0
+	_, err = CompareAudio(&quiet, &reference, DefaultTolerance)
+	assert.ErrorIs(t, err, ErrProfileMismatch)
+}
+
+func TestUsageReport(t *testing.T) {
+	m := &common.Module{
+		UseInstruments: true,
+		Samples:        make([]common.Sample, 3),
+		Instruments:    make([]common.Instrument, 2),
+		Order:          []int16{1, 254, 1, 255},
+		Patterns: []common.Pattern{
+			{Rows: []common.PatternRow{{Entries: []common.PatternEntry{{Channel: 0, Note: 61, Instrument: 2}}}}},
+			{Rows: []common.PatternRow{
+				{Entries: []common.PatternEntry{{Channel: 1, Note: 61, Instrument: 1}}},
+				{Entries: []common.PatternEntry{{Channel: 1, Note: 73}}},
+			}},
+		},
+	}
+	m.Instruments[0].Notemap[60].Sample = 1
+	m.Instruments[0].Notemap[72].Sample = 2
+	m.Instruments[1].Notemap[60].Sample = 3
+
+	u := UsageReport(m)
+	assert.Equal(t, []UsageRef{
+		{Order: 0, Pattern: 1, Row: 0, Channel: 1},
+		{Order: 0, Pattern: 1, Row: 1, Channel: 1},
+		{Order: 2, Pattern: 1, Row: 0, Channel: 1},
+		{Order: 2, Pattern: 1, Row: 1, Channel: 1},
+	}, u.Instruments[0])
+	assert.Equal(t, []UsageRef{{Order: 0, Pattern: 1, Row: 1, Channel: 1}, {Order: 2, Pattern: 1, Row: 1, Channel: 1}}, u.Samples[1])
+	assert.Equal(t, []UsageRef{{Order: -1, Pattern: 0, Row: 0, Channel: 0}}, u.Samples[2])
+	assert.Equal(t, []int{2}, u.UnusedSamples())
+	assert.Equal(t, []int{1}, u.UnusedInstruments())
+
+	// Without instruments, the instrument column is the sample.
+	m.UseInstruments = false
+	u = UsageReport(m)
+	assert.Len(t, u.Samples[0], 4)
+	assert.Len(t, u.Samples[1], 1)
+	assert.Empty(t, u.Instruments[0])
+}
+
+func TestWaveform(t *testing.T) {
+	itm, err := itmod.LoadITFile("../itmod/test/reflection.it")
+	assert.NoError(t, err)
+	m := itm.ToCommon()
+
+	peaks := Waveform(m, 100)
+	assert.Len(t, peaks, 100)
+	loud := 0
+	for _, p := range peaks {
+		assert.LessOrEqual(t, p.Min, p.Max)
+		if p.Max-p.Min > 0.01 {
+			loud++
+		}
+	}
+	assert.Greater(t, loud, 50)
+	assert.Nil(t, Waveform(m, 0))
+
+	// A module without patterns shows its samples.
+	pack := &common.Module{Samples: []common.Sample{sineSample(100, 8), sineSample(100, 8)}}
+	peaks = Waveform(pack, 4)
+	assert.Len(t, peaks, 4)
+	for _, p := range peaks {
+		assert.InDelta(t, -0.5, p.Min, 0.01)
+		assert.InDelta(t, 0.5, p.Max, 0.01)
+	}
+	assert.Equal(t, make([]Peak, 3), Waveform(&common.Module{}, 3))
+}
+
+func TestResolveEffectMemory(t *testing.T) {
+	rows := make([]common.PatternRow, 3)
+	rows[0].Entries = []common.PatternEntry{
+		{Channel: 0, Effect: 4, EffectParam: 0x02}, // D02
+		{Channel: 1, Effect: 8, EffectParam: 0x48}, // H48
+		{Channel: 2, Effect: 5, EffectParam: 0x10}, // E10
+		{Channel: 3, VolumeCommand: common.VcmdVolSlideUp, VolumeParam: 3},
+	}
+	rows[1].Entries = []common.PatternEntry{
+		{Channel: 0, Effect: 11},                   // K00 shares D's memory
+		{Channel: 1, Effect: 8, EffectParam: 0x20}, // H20 keeps the depth
+		{Channel: 2, Effect: 6},                    // F00 shares E's memory in IT
+		{Channel: 3, VolumeCommand: common.VcmdFineVolDown},
+	}
+	rows[2].Entries = []common.PatternEntry{{Channel: 0, Effect: 4}}
+	m := &common.Module{
+		Order:    []int16{0, 254, 0, 255, 0},
+		Patterns: []common.Pattern{{Rows: rows}},
+	}
+
+	resolved := ResolveEffectMemory(m, render.DefaultEffectBehavior(m))
+	assert.Len(t, resolved, 2)
+	assert.Equal(t, 2, resolved[1].Order)
+	second := resolved[0].Rows[1].Entries
+	assert.Equal(t, uint8(0x02), second[0].EffectParam)
+	assert.Equal(t, uint8(0x28), second[1].EffectParam)
+	assert.Equal(t, uint8(0x10), second[2].EffectParam)
+	assert.Equal(t, uint8(3), second[3].VolumeParam)
+	assert.Equal(t, uint8(0), m.Patterns[0].Rows[1].Entries[0].EffectParam)
+
+	// XM remembers E and F separately, and ProTracker has no slide memory.
+	xm := ResolveEffectMemory(m, render.CompatFastTracker2.Behavior(m))
+	assert.Equal(t, uint8(0), xm[0].Rows[1].Entries[2].EffectParam)
+	pt := ResolveEffectMemory(m, render.CompatProTracker.Behavior(m))
+	assert.Equal(t, uint8(0), pt[0].Rows[1].Entries[0].EffectParam)
+}
+
+func TestRowTimes(t *testing.T) {
+	rows := make([]common.PatternRow, 4)
+	rows[1].Entries = []common.PatternEntry{{Channel: 0, Effect: 1, EffectParam: 3}}     // A03
+	rows[2].Entries = []common.PatternEntry{{Channel: 0, Effect: 19, EffectParam: 0xE1}} // SE1
+	m := &common.Module{
+		InitialSpeed: 6,
+		InitialTempo: 125,
+		Channels:     1,
+		Order:        []int16{0, 0},
+		Patterns:     []common.Pattern{{Rows: rows}},
+	}
+
+	// Each tick is 20 ms at 125 BPM.
+	times := ExtractRowTimes(m)
+	assert.Len(t, times.Rows, 8)
+	d, ok := times.RowDuration(0, 0)
+	assert.True(t, ok)
+	assert.Equal(t, 120*time.Millisecond, d)
+	d, _ = times.RowDuration(0, 1)
+	assert.Equal(t, 60*time.Millisecond, d)
+	d, _ = times.RowDuration(0, 2)
+	assert.Equal(t, 120*time.Millisecond, d)
+	start, ok := times.RowStart(1, 0)
+	assert.True(t, ok)
+	assert.Equal(t, 360*time.Millisecond, start)
+	_, ok = times.RowDuration(2, 0)
+	assert.False(t, ok)
+
+	row, ok := times.At(200 * time.Millisecond)
+	assert.True(t, ok)
+	assert.Equal(t, 2, row.Row)
+	_, ok = times.At(times.Duration())
+	assert.False(t, ok)
+	assert.Equal(t, 660*time.Millisecond, times.Duration())
+}
+
+func TestTags(t *testing.T) {
+	m := &common.Module{
+		Title:        "Song",
+		InitialSpeed: 6,
+		InitialTempo: 125,
+		Channels:     1,
+		Order:        []int16{0},
+		Patterns:     []common.Pattern{{Rows: make([]common.PatternRow, 4)}},
+	}
+	assert.Equal(t, map[string]string{
+		common.TagTitle:  "Song",
+		common.TagLength: "480",
+	}, Tags(m))
+}
+
+func TestGuessCredits(t *testing.T) {
+	m := &common.Module{
+		Title:   "Space Debris",
+		Message: "Space Debris\n\nComposer: Captain/Image\nGreets to everyone!\n(c) 1990 Captain",
+		Samples: []common.Sample{{Name: "composed by"}, {Name: "  Captain  "}, {Name: "bye bye"}},
+	}
+	credits := GuessCredits(m)
+	assert.Len(t, credits, 1)
+	assert.Equal(t, "Captain", credits[0].Name)
+	assert.Equal(t, "Image", credits[0].Group)
+	assert.Equal(t, 1990, credits[0].Year)
+	assert.Equal(t, "message", credits[0].Source)
+	assert.Greater(t, credits[0].Confidence, 0.9)
+	assert.LessOrEqual(t, credits[0].Confidence, 1.0)
+
+	m = &common.Module{
+		Message: "a tune by Someone Else in 1997, enjoy",
+		Samples: []common.Sample{{Name: "music by DJ Foo"}},
+	}
+	credits = GuessCredits(m)
+	assert.Len(t, credits, 2)
+	assert.Equal(t, "DJ Foo", credits[0].Name)
+	assert.Equal(t, "samples", credits[0].Source)
+	assert.Zero(t, credits[0].Year)
+	assert.Equal(t, Credit{Name: "Someone Else", Year: 1997, Source: "message", Confidence: 0.65}, credits[1])
+
+	assert.Empty(t, GuessCredits(&common.Module{Message: "Greets to all my friends"}))
+}
@@ -0,0 +1,56 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package analysis
+
+import (
+	"math"
+	"time"
+
+	"go.mukunda.com/modlib/common"
+	"go.mukunda.com/modlib/render"
+)
+
+// A point on an automation curve. The value holds until the next point.
+type AutomationPoint struct {
+	Time  time.Duration
+	Value float64
+}
+
+// Song-level automation over one pass through the song.
+type Automation struct {
+	// Global volume, 0-1, from the initial volume and Vxx/Wxx.
+	GlobalVolume []AutomationPoint
+
+	// Tempo in BPM, from the initial tempo and Txx.
+	Tempo []AutomationPoint
+
+	// Length of the song.
+	Duration time.Duration
+}
+
+// Add a point if the value changed.
+func addPoint(points []AutomationPoint, t time.Duration, value float64) []AutomationPoint {
+	if len(points) > 0 && points[len(points)-1].Value == value {
+		return points
+	}
+	return append(points, AutomationPoint{Time: t, Value: value})
+}
+
+// Play through a module and record how its global volume and tempo change over time.
+// Changes are sampled per tick, so slides become a series of steps.
+func ExtractAutomation(m *common.Module) Automation {
+	var result Automation
+
+	seconds := 0.0
+	player := render.NewPlayer(m, render.DefaultSampleRate)
+	for tick := range player.Ticks() {
+		result.GlobalVolume = addPoint(result.GlobalVolume, tick.Time, float64(tick.GlobalVolume)/128)
+		result.Tempo = addPoint(result.Tempo, tick.Time, float64(tick.Tempo))
+		seconds += 2.5 / float64(tick.Tempo)
+	}
+	result.Duration = time.Duration(math.Round(seconds * float64(time.Second)))
+
+	return result
+}
@@ -0,0 +1,169 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package analysis
+
+import (
+	"cmp"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"go.mukunda.com/modlib/common"
+)
+
+// A guess at who made a module, from a credit in its text.
+type Credit struct {
+	Name       string
+	Group      string  // Demo group or label given with the name, like "Name/Group".
+	Year       int     // Year given near the credit, 0 if none.
+	Source     string  // Where it was found: "message", "title", "samples" or "instruments".
+	Confidence float64 // 0 to 1.
+}
+
+// Credit patterns, from most to least reliable. Each captures the text after the marker.
+var creditPatterns = []struct {
+	re         *regexp.Regexp
+	confidence float64
+}{
+	// "Composer: Name", "music by: Name"
+	{regexp.MustCompile(`(?i)^\W*(?:composer|composed|author|artist|musician|music|written|tracked|made)(?:\s+by)?\s*[:=]\s*(.*)$`), 0.9},
+	// "composed by Name"
+	{regexp.MustCompile(`(?i)\b(?:composed|written|made|music|tracked|done|arranged|remixed|converted)\s+by\b\s*:?\s*(.*)$`), 0.8},
+	// "by Name"
+	{regexp.MustCompile(`(?i)(?:^|[^\p{L}\p{N}'])by\b\s*:?\s*(.*)$`), 0.65},
+	// "(c) 1996 Name"
+	{regexp.MustCompile(`(?i)(?:\(c\)|©|copyright)\s*(?:(?:19|20)\d\d\s*)?(?:by\s+)?(.*)$`), 0.55},
+}
+
+var (
+	yearPattern = regexp.MustCompile(`\b(19[789]\d|20[0-4]\d)\b`)
+	// Text after a name that isn't part of it, like "in 1996" or " - enjoy!"
+	creditTail = regexp.MustCompile(`(?i)(?:\s+(?:in|on|at|for|from|using|with|and|feat|ft)\b|\s+-\s|[,;:!?"<>{}|]|\s{3,}).*$`)
+	// "Name/Group", "Name^Group", "Name of Group", "Name (Group)", "Name [Group]"
+	groupPattern = regexp.MustCompile(`(?i)^(.+?)\s*(?:[/^\\]\s*|\s+of\s+|\s*[(\[]\s*)(.+?)$`)
+)
+
+// How much a credit is trusted by where it was found. Sample names are often used for
+// notes that aren't credits, and long names are cut off between slots.
+var creditSources = []struct {
+	name   string
+	weight float64
+}{
+	{"message", 1},
+	{"title", 0.9},
+	{"samples", 0.85},
+	{"instruments", 0.85},
+}
+
+// Guess who made a module from the credits in its text: "by Name" and "Composer: Name"
+// lines, copyright notices, "Name/Group" handles and years. The same name found in
+// several places is merged and gains confidence. Guesses are sorted by confidence, so
+// the first is the best; there are none when the text has no credits.
+func GuessCredits(m *common.Module) []Credit {
+	text := ExtractText(m)
+	sources := [][]string{
+		strings.Split(text.Message, "\n"),
+		{text.Title},
+		text.Samples,
+		text.Instruments,
+	}
+
+	var credits []Credit
+	merged := make(map[string]int)
+	for i, lines := range sources {
+		sourceYear := 0
+		if year := yearPattern.FindString(strings.Join(lines, "\n")); year != "" {
+			sourceYear, _ = strconv.Atoi(year)
+		}
+
+		for j := range lines {
+			c, ok := parseCredit(lines, j)
+			if !ok {
+				continue
+			}
+			c.Source = creditSources[i].name
+			c.Confidence *= creditSources[i].weight
+			if c.Year == 0 {
+				c.Year = sourceYear
+			}
+
+			key := strings.ToLower(c.Name)
+			k, seen := merged[key]
+			if !seen {
+				merged[key] = len(credits)
+				credits = append(credits, c)
+				continue
+			}
+			prev := &credits[k]
+			if c.Confidence > prev.Confidence {
+				prev.Source = c.Source
+			}
+			prev.Confidence = 1 - (1-prev.Confidence)*(1-c.Confidence)
+			prev.Group = cmp.Or(prev.Group, c.Group)
+			prev.Year = cmp.Or(prev.Year, c.Year)
+		}
+	}
+
+	slices.SortStableFunc(credits, func(a, b Credit) int {
+		return cmp.Compare(b.Confidence, a.Confidence)
+	})
+	return credits
+}
+
+// Parse a credit on line i. When the line ends at the marker, like sample names split
+// over two slots ("composed by" / "Name"), the name is taken from the next line.
+func parseCredit(lines []string, i int) (Credit, bool) {
+	for _, p := range creditPatterns {
+		match := p.re.FindStringSubmatch(lines[i])
+		if match == nil {
+			continue
+		}
+		rest, context := match[1], lines[i]
+		if strings.TrimSpace(rest) == "" && i+1 < len(lines) {
+			rest = lines[i+1]
+			context += " " + rest
+		}
+
+		c := Credit{Confidence: p.confidence}
+		if year := yearPattern.FindString(context); year != "" {
+			c.Year, _ = strconv.Atoi(year)
+		}
+		rest = yearPattern.ReplaceAllString(rest, "")
+		rest = trimCredit(creditTail.ReplaceAllString(rest, ""))
+		if g := groupPattern.FindStringSubmatch(rest); g != nil {
+			rest, c.Group = trimCredit(g[1]), trimCredit(g[2])
+		}
+		c.Name = rest
+		if !plausibleName(c.Name) {
+			continue
+		}
+		if !plausibleName(c.Group) {
+			c.Group = ""
+		}
+		return c, true
+	}
+	return Credit{}, false
+}
+
+// Remove decoration around a name.
+func trimCredit(s string) string {
+	return strings.TrimFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Whether text looks like a name rather than the rest of a sentence.
+func plausibleName(s string) bool {
+	if s == "" || len(s) > 32 || len(strings.Fields(s)) > 4 {
+		return false
+	}
+	switch strings.ToLower(s) {
+	case "the", "a", "an", "me", "myself", "the way", "unknown":
+		return false
+	}
+	return strings.IndexFunc(s, unicode.IsLetter) >= 0
+}
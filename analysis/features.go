@@ -0,0 +1,285 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package analysis
+
+import (
+	"math"
+	"math/cmplx"
+
+	"go.mukunda.com/modlib/common"
+	"go.mukunda.com/modlib/render"
+)
+
+// Version of the Features layout and calculations. It changes whenever a field is added
+// or computed differently, so stored features can be recomputed when they are stale.
+const FeaturesVersion = 1
+
+const (
+	spectrumWindow  = 1024 // Frames per FFT window.
+	maxWindows      = 32   // Windows analyzed per sample, spread over its length.
+	spectralRolloff = 0.85 // Fraction of energy below the rolloff frequency.
+)
+
+// Descriptors of a module for cataloging and classification. Every field is a plain
+// number so the struct can be flattened into a feature vector. Fields that can't be
+// computed (e.g. pitch statistics for a song without notes) are 0.
+type Features struct {
+	Version int // FeaturesVersion
+
+	// Timing over one pass through the song.
+	Duration      float64 // Seconds
+	Tempo         float64 // Time-weighted mean BPM
+	Speed         float64 // Time-weighted mean ticks per row
+	RowsPerSecond float64
+
+	// Note events in the rows that were played.
+	NoteDensity  float64 // Notes per second
+	NotesPerRow  float64
+	ChannelsUsed int     // Channels with at least one note
+	PitchMean    float64 // Mean note, 0-119
+	PitchSpread  float64 // Standard deviation of the notes
+
+	// Contents.
+	Patterns      int
+	Instruments   int
+	Samples       int     // Samples with data
+	SampleSeconds float64 // Total sample length at the C5 speed
+	StereoSamples float64 // Fraction of samples that are stereo
+	LoopedSamples float64 // Fraction of samples with a loop
+
+	// Spectral features of the samples, played at their C5 speed. Each is averaged over
+	// the samples, weighted by the amount of audio analyzed.
+	SpectralCentroid float64 // Hz
+	SpectralRolloff  float64 // Hz below which 85% of the energy lies
+	SpectralFlatness float64 // 0 = tonal, 1 = noise
+	ZeroCrossingRate float64 // Crossings per second
+
+	// Fraction of the played, non-empty pattern entries using each effect (index 0 = A)
+	// and volume command (index 0 = VcmdSetVolume).
+	EffectUsage        [26]float64
+	VolumeCommandUsage [10]float64
+}
+
+// Spectral features of one sample, plus the number of frames they were measured on.
+type spectrum struct {
+	centroid, rolloff, flatness, crossings float64
+	frames                                 int
+}
+
+// In-place radix-2 FFT. len(x) must be a power of two.
+func fft(x []complex128) {
+	n := len(x)
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j |= bit
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+	for size := 2; size <= n; size <<= 1 {
+		step := cmplx.Exp(complex(0, -2*math.Pi/float64(size)))
+		for start := 0; start < n; start += size {
+			w := complex(1, 0)
+			for k := 0; k < size/2; k++ {
+				a, b := x[start+k], x[start+k+size/2]*w
+				x[start+k], x[start+k+size/2] = a+b, a-b
+				w *= step
+			}
+		}
+	}
+}
+
+// Measure a sample's spectrum over windows spread across its length.
+func sampleSpectrum(s *common.Sample) spectrum {
+	var result spectrum
+	mono := monoSignal(s)
+	rate := float64(s.C5)
+	if len(mono) == 0 || rate <= 0 {
+		return result
+	}
+
+	for i := 1; i < len(mono); i++ {
+		if (mono[i-1] < 0) != (mono[i] < 0) {
+			result.crossings++
+		}
+	}
+	result.crossings *= rate / float64(len(mono))
+
+	// Short samples are zero-padded into a single window.
+	windows := min(maxWindows, max(1, len(mono)/spectrumWindow))
+	stride := 0
+	if windows > 1 {
+		stride = (len(mono) - spectrumWindow) / (windows - 1)
+	}
+
+	buffer := make([]complex128, spectrumWindow)
+	binHz := rate / spectrumWindow
+	analyzed := 0
+	for w := range windows {
+		start := w * stride
+		clear(buffer)
+		for i := 0; i < spectrumWindow && start+i < len(mono); i++ {
+			hann := 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/spectrumWindow)
+			buffer[i] = complex(mono[start+i]*hann, 0)
+		}
+		fft(buffer)
+
+		power := make([]float64, spectrumWindow/2)
+		total, weighted, logSum := 0.0, 0.0, 0.0
+		for k := range power {
+			power[k] = real(buffer[k])*real(buffer[k]) + imag(buffer[k])*imag(buffer[k])
+			total += power[k]
+			weighted += power[k] * float64(k) * binHz
+			logSum += math.Log(power[k] + 1e-20)
+		}
+		if total < 1e-12 {
+			continue // Silence says nothing about the timbre.
+		}
+
+		rolloff := 0.0
+		for k, sum := 0, 0.0; k < len(power); k++ {
+			if sum += power[k]; sum >= spectralRolloff*total {
+				rolloff = float64(k) * binHz
+				break
+			}
+		}
+
+		result.centroid += weighted / total
+		result.rolloff += rolloff
+		result.flatness += math.Exp(logSum/float64(len(power))) / (total / float64(len(power)))
+		analyzed++
+	}
+
+	if analyzed > 0 {
+		result.centroid /= float64(analyzed)
+		result.rolloff /= float64(analyzed)
+		result.flatness /= float64(analyzed)
+		result.frames = min(len(mono), analyzed*spectrumWindow)
+	}
+	return result
+}
+
+// Compute the features of a module. This plays through the song once without mixing, so
+// it takes a fraction of the time of a render.
+func ExtractFeatures(m *common.Module) Features {
+	if loaded, err := m.WithLoadedStreams(); err == nil {
+		m = loaded
+	}
+	f := Features{
+		Version:     FeaturesVersion,
+		Patterns:    len(m.Patterns),
+		Instruments: len(m.Instruments),
+	}
+
+	// Timing and note events.
+	var rows, notes, entries int
+	var pitchSum, pitchSquares float64
+	var effects [26]int
+	var volumeCommands [10]int
+	channels := make(map[uint8]bool)
+	lastOrder, lastRow := -1, -1
+	for tick := range render.NewPlayer(m, render.DefaultSampleRate).Ticks() {
+		seconds := 2.5 / float64(tick.Tempo)
+		f.Duration += seconds
+		f.Tempo += float64(tick.Tempo) * seconds
+		f.Speed += float64(tick.Speed) * seconds
+
+		if tick.Tick != 0 || (tick.Order == lastOrder && tick.Row == lastRow) {
+			continue
+		}
+		lastOrder, lastRow = tick.Order, tick.Row
+		rows++
+
+		pattern := int(m.Order[tick.Order])
+		if pattern >= len(m.Patterns) || tick.Row >= len(m.Patterns[pattern].Rows) {
+			continue
+		}
+		for _, e := range m.Patterns[pattern].Rows[tick.Row].Entries {
+			if e.IsEmpty() {
+				continue
+			}
+			entries++
+			if e.Note >= 1 && e.Note <= 120 {
+				notes++
+				channels[e.Channel] = true
+				pitch := float64(e.Note - 1)
+				pitchSum += pitch
+				pitchSquares += pitch * pitch
+			}
+			if e.Effect >= 1 && int(e.Effect) <= len(effects) {
+				effects[e.Effect-1]++
+			}
+			if e.VolumeCommand >= 1 && int(e.VolumeCommand) <= len(volumeCommands) {
+				volumeCommands[e.VolumeCommand-1]++
+			}
+		}
+	}
+
+	if f.Duration > 0 {
+		f.Tempo /= f.Duration
+		f.Speed /= f.Duration
+		f.RowsPerSecond = float64(rows) / f.Duration
+		f.NoteDensity = float64(notes) / f.Duration
+	}
+	if rows > 0 {
+		f.NotesPerRow = float64(notes) / float64(rows)
+	}
+	if notes > 0 {
+		f.PitchMean = pitchSum / float64(notes)
+		f.PitchSpread = math.Sqrt(max(pitchSquares/float64(notes)-f.PitchMean*f.PitchMean, 0))
+	}
+	f.ChannelsUsed = len(channels)
+	if entries > 0 {
+		for i, n := range effects {
+			f.EffectUsage[i] = float64(n) / float64(entries)
+		}
+		for i, n := range volumeCommands {
+			f.VolumeCommandUsage[i] = float64(n) / float64(entries)
+		}
+	}
+
+	// Samples.
+	analyzed := 0
+	for i := range m.Samples {
+		s := &m.Samples[i]
+		length := s.Data.Len()
+		if length == 0 {
+			continue
+		}
+		f.Samples++
+		if s.C5 > 0 {
+			f.SampleSeconds += float64(length) / float64(s.C5)
+		}
+		if len(s.Data.Data) > 1 {
+			f.StereoSamples++
+		}
+		if s.Loop || s.Sustain {
+			f.LoopedSamples++
+		}
+
+		spec := sampleSpectrum(s)
+		weight := float64(spec.frames)
+		f.SpectralCentroid += spec.centroid * weight
+		f.SpectralRolloff += spec.rolloff * weight
+		f.SpectralFlatness += spec.flatness * weight
+		f.ZeroCrossingRate += spec.crossings * weight
+		analyzed += spec.frames
+	}
+	if f.Samples > 0 {
+		f.StereoSamples /= float64(f.Samples)
+		f.LoopedSamples /= float64(f.Samples)
+	}
+	if analyzed > 0 {
+		f.SpectralCentroid /= float64(analyzed)
+		f.SpectralRolloff /= float64(analyzed)
+		f.SpectralFlatness /= float64(analyzed)
+		f.ZeroCrossingRate /= float64(analyzed)
+	}
+
+	return f
+}
@@ -0,0 +1,105 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package analysis
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"slices"
+
+	"go.mukunda.com/modlib/common"
+)
+
+// Content hashes of a module that ignore metadata, so copies of a song that were renamed,
+// retitled or repacked into another format can be matched.
+type Fingerprint struct {
+	// Hash of the pattern data in play order. Pattern numbering, instrument numbers and
+	// unused patterns don't affect it.
+	Song uint64
+
+	// Hashes of the PCM of each sample with data, sorted. 8-bit data hashes the same as
+	// the equivalent 16-bit data.
+	Samples []uint64
+}
+
+// Compute the fingerprint of a module.
+func ComputeFingerprint(m *common.Module) Fingerprint {
+	if loaded, err := m.WithLoadedStreams(); err == nil {
+		m = loaded
+	}
+	var f Fingerprint
+
+	h := fnv.New64a()
+	var buf [8]byte
+	for _, pattern := range m.Order {
+		if pattern == common.OrderEnd {
+			break
+		}
+		if pattern == common.OrderSkip {
+			continue
+		}
+		if int(pattern) >= len(m.Patterns) {
+			h.Write([]byte{0xFE}) // Missing pattern
+			continue
+		}
+		for _, row := range m.Patterns[pattern].Rows {
+			for _, e := range row.Entries {
+				if e.IsEmpty() {
+					continue
+				}
+				buf = [8]byte{e.Channel, e.Note, e.VolumeCommand, e.VolumeParam, e.Effect, e.EffectParam}
+				h.Write(buf[:6])
+			}
+			h.Write([]byte{0xFF})
+		}
+	}
+	f.Song = h.Sum64()
+
+	for i := range m.Samples {
+		data := &m.Samples[i].Data
+		if data.Len() == 0 {
+			continue
+		}
+		h.Reset()
+		for _, frame := range data.Frames() {
+			for _, v := range frame {
+				binary.LittleEndian.PutUint16(buf[:], uint16(int16(v*32768)))
+				h.Write(buf[:2])
+			}
+		}
+		f.Samples = append(f.Samples, h.Sum64())
+	}
+	slices.Sort(f.Samples)
+	return f
+}
+
+// How alike two fingerprints are, from 0 to 1. Matching pattern data counts for half, and
+// the overlap between the sample sets counts for the other half.
+func (f *Fingerprint) Similarity(other *Fingerprint) float64 {
+	score := 0.0
+	if f.Song == other.Song {
+		score += 0.5
+	}
+
+	if len(f.Samples) == 0 && len(other.Samples) == 0 {
+		return score + 0.5
+	}
+	// Both lists are sorted, so count the shared hashes with a merge.
+	shared := 0
+	for i, j := 0, 0; i < len(f.Samples) && j < len(other.Samples); {
+		switch {
+		case f.Samples[i] == other.Samples[j]:
+			shared++
+			i++
+			j++
+		case f.Samples[i] < other.Samples[j]:
+			i++
+		default:
+			j++
+		}
+	}
+	union := len(f.Samples) + len(other.Samples) - shared
+	return score + 0.5*float64(shared)/float64(union)
+}
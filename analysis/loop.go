@@ -0,0 +1,156 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+/*
+This package contains tools for inspecting modules and their samples.
+*/
+package analysis
+
+import (
+	"slices"
+
+	"go.mukunda.com/modlib/common"
+)
+
+// A possible loop region found by FindLoop.
+type LoopCandidate struct {
+	Start int
+	End   int
+
+	// Mean squared difference of the waveform around the loop seam. Lower is better; 0 is a
+	// perfect match.
+	Score float64
+}
+
+const (
+	minLoopLength      = 32  // Shortest loop that will be considered.
+	loopWindow         = 32  // Frames compared on each side of the seam.
+	maxLoopEnds        = 64  // Limit for end candidates (taken from the end of the sample).
+	maxLoopStarts      = 256 // Limit for start candidates.
+	maxLoopCandidates  = 8   // Number of results returned.
+	loopSlopeWeighting = 4.0 // How much slope mismatch counts against a seam.
+)
+
+// Mix all channels down to mono.
+func monoSignal(s *common.Sample) []float64 {
+	mono := make([]float64, s.Data.Len())
+	for i, frame := range s.Data.Frames() {
+		sum := 0.0
+		for _, v := range frame {
+			sum += v
+		}
+		mono[i] = sum / float64(len(frame))
+	}
+	return mono
+}
+
+// Positions where the signal crosses zero going upward.
+func risingZeroCrossings(x []float64) []int {
+	var result []int
+	for i := 1; i < len(x); i++ {
+		if x[i-1] < 0 && x[i] >= 0 {
+			result = append(result, i)
+		}
+	}
+	return result
+}
+
+// Compare the audio leading into `end` with the audio leading into `start`, and the audio
+// following `start` with what would have followed `end`. When the loop wraps, playback
+// jumps from end-1 to start, so similar surroundings mean an inaudible seam.
+func seamScore(x []float64, start, end int) float64 {
+	diff := 0.0
+	count := 0
+	for k := 1; k <= loopWindow; k++ {
+		if start-k < 0 {
+			break
+		}
+		d := x[end-k] - x[start-k]
+		diff += d * d
+		count++
+	}
+	for k := 0; k < loopWindow; k++ {
+		if end+k >= len(x) {
+			break
+		}
+		d := x[end+k] - x[start+k]
+		diff += d * d
+		count++
+	}
+
+	// Slope across the seam versus the slope at the loop start.
+	slope := (x[start] - x[end-1]) - (x[start] - x[max(start-1, 0)])
+	diff += slope * slope * loopSlopeWeighting
+	count++
+
+	return diff / float64(count)
+}
+
+// Pick up to n items evenly from a list.
+func spread(list []int, n int) []int {
+	if len(list) <= n {
+		return list
+	}
+	result := make([]int, n)
+	for i := range result {
+		result[i] = list[i*len(list)/n]
+	}
+	return result
+}
+
+// Search for loop points with a low discontinuity. Candidates are placed on rising zero
+// crossings and scored by comparing the waveform on both sides of the seam, which favors
+// loop lengths that are whole multiples of the waveform period. The best candidates are
+// returned first. Nothing is returned if the sample is too short or has no zero
+// crossings. The sample isn't modified; see LoopCandidate.Apply.
+func FindLoop(s *common.Sample) []LoopCandidate {
+	if loaded, err := s.WithLoadedStream(); err == nil {
+		s = loaded
+	}
+	x := monoSignal(s)
+	crossings := risingZeroCrossings(x)
+	if len(crossings) < 2 {
+		return nil
+	}
+
+	// Allow loop ends to reach the end of the sample.
+	ends := append(slices.Clone(crossings), len(x))
+	ends = ends[max(0, len(ends)-maxLoopEnds):]
+
+	starts := spread(crossings, maxLoopStarts)
+
+	var candidates []LoopCandidate
+	for _, end := range ends {
+		for _, start := range starts {
+			if end-start < minLoopLength {
+				break
+			}
+			candidates = append(candidates, LoopCandidate{
+				Start: start,
+				End:   end,
+				Score: seamScore(x, start, end),
+			})
+		}
+	}
+
+	slices.SortStableFunc(candidates, func(a, b LoopCandidate) int {
+		if a.Score < b.Score {
+			return -1
+		} else if a.Score > b.Score {
+			return 1
+		}
+		// Prefer longer loops when scores are equal.
+		return (b.End - b.Start) - (a.End - a.Start)
+	})
+
+	return candidates[:min(len(candidates), maxLoopCandidates)]
+}
+
+// Set the loop points of a sample to this candidate and enable a forward loop.
+func (c LoopCandidate) Apply(s *common.Sample) {
+	s.Loop = true
+	s.PingPong = false
+	s.LoopStart = c.Start
+	s.LoopEnd = c.End
+}
@@ -0,0 +1,179 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package analysis
+
+import (
+	"go.mukunda.com/modlib/common"
+	"go.mukunda.com/modlib/render"
+)
+
+// Effect numbers, A = 1.
+const (
+	effectD = 4
+	effectE = 5
+	effectF = 6
+	effectG = 7
+	effectH = 8
+	effectI = 9
+	effectJ = 10
+	effectK = 11
+	effectL = 12
+	effectN = 14
+	effectO = 15
+	effectP = 16
+	effectQ = 17
+	effectR = 18
+	effectS = 19
+	effectT = 20
+	effectU = 21
+	effectW = 23
+	effectY = 25
+)
+
+// A pattern as it plays at one position in the order list, with effect parameters that
+// reuse a remembered value replaced by the value that takes effect.
+type ResolvedPattern struct {
+	Order   int
+	Pattern int
+	Rows    []common.PatternRow
+}
+
+// Parameter memory of one channel, like the player keeps it.
+type channelMemory struct {
+	volumeSlide, pitchSlide, pitchSlideUp, portamento    uint8
+	vibrato, tremolo, offset, tremor, arpeggio           uint8
+	channelVolumeSlide, panSlide, retrigger, globalSlide uint8
+	panbrello, tempo, special, volumeColumn              uint8
+}
+
+// Resolve effect memory through the order list, for analysis and export that need the
+// parameter each effect plays with. Parameters of 00 (or a 0 nibble, for vibrato-like
+// effects) take the remembered value, following the memory rules of the behavior, e.g.
+// render.DefaultEffectBehavior(m). Memory is kept per channel and carries from one order
+// to the next; jumps aren't followed. The volume column's volume slides are resolved too.
+func ResolveEffectMemory(m *common.Module, behavior render.EffectBehavior) []ResolvedPattern {
+	var result []ResolvedPattern
+	memory := map[uint8]*channelMemory{}
+
+	for order, pattern := range m.Order {
+		if pattern == common.OrderEnd {
+			break
+		}
+		if pattern == common.OrderSkip || int(pattern) >= len(m.Patterns) {
+			continue
+		}
+
+		rows := m.Patterns[pattern].Rows
+		resolved := ResolvedPattern{Order: order, Pattern: int(pattern), Rows: make([]common.PatternRow, len(rows))}
+		for r, row := range rows {
+			entries := make([]common.PatternEntry, len(row.Entries))
+			for i, e := range row.Entries {
+				mem := memory[e.Channel]
+				if mem == nil {
+					mem = &channelMemory{}
+					memory[e.Channel] = mem
+				}
+				mem.resolve(&e, behavior)
+				entries[i] = e
+			}
+			resolved.Rows[r].Entries = entries
+		}
+		result = append(result, resolved)
+	}
+	return result
+}
+
+// Use the parameter if it's nonzero, otherwise the remembered one.
+func remember(mem *uint8, param uint8) uint8 {
+	if param != 0 {
+		*mem = param
+	}
+	return *mem
+}
+
+// Remember each nibble of the parameter separately.
+func rememberNibbles(mem *uint8, param uint8) uint8 {
+	if param&0xF0 != 0 {
+		*mem = (*mem & 0x0F) | (param & 0xF0)
+	}
+	if param&0x0F != 0 {
+		*mem = (*mem & 0xF0) | (param & 0x0F)
+	}
+	return *mem
+}
+
+// Replace the parameters of an entry with the values they take effect with, updating the
+// memory.
+func (mem *channelMemory) resolve(e *common.PatternEntry, b render.EffectBehavior) {
+	slide := func(slot *uint8, param uint8) uint8 {
+		if !b.SlideMemory {
+			*slot = param
+			return param
+		}
+		return remember(slot, param)
+	}
+
+	param := e.EffectParam
+	switch e.Effect {
+	case effectD, effectK, effectL:
+		param = slide(&mem.volumeSlide, param)
+	case effectE, effectF:
+		slot := &mem.pitchSlide
+		if e.Effect == effectF && !b.SharedPitchSlideMemory {
+			slot = &mem.pitchSlideUp
+		}
+		param = slide(slot, param)
+		if b.LinkEFG {
+			mem.portamento = param
+		}
+	case effectG:
+		param = remember(&mem.portamento, param)
+		if b.LinkEFG {
+			mem.pitchSlide = param
+			mem.pitchSlideUp = param
+		}
+	case effectH, effectU:
+		param = rememberNibbles(&mem.vibrato, param)
+	case effectI:
+		param = remember(&mem.tremor, param)
+	case effectJ:
+		param = remember(&mem.arpeggio, param)
+	case effectN:
+		param = slide(&mem.channelVolumeSlide, param)
+	case effectO:
+		param = remember(&mem.offset, param)
+	case effectP:
+		param = slide(&mem.panSlide, param)
+	case effectQ:
+		param = remember(&mem.retrigger, param)
+	case effectR:
+		param = rememberNibbles(&mem.tremolo, param)
+	case effectS:
+		param = remember(&mem.special, param)
+	case effectT:
+		if param < 0x20 {
+			param = remember(&mem.tempo, param)
+		}
+	case effectW:
+		param = slide(&mem.globalSlide, param)
+	case effectY:
+		param = rememberNibbles(&mem.panbrello, param)
+	}
+	e.EffectParam = param
+
+	switch e.VolumeCommand {
+	case common.VcmdFineVolUp, common.VcmdFineVolDown, common.VcmdVolSlideUp, common.VcmdVolSlideDown:
+		e.VolumeParam = remember(&mem.volumeColumn, e.VolumeParam)
+	case common.VcmdPitchSlideDown, common.VcmdPitchSlideUp:
+		// Shares the memory of E and F, in units of 4.
+		if e.VolumeParam != 0 {
+			mem.pitchSlide = e.VolumeParam * 4
+		}
+	case common.VcmdPortaToNote:
+		if int(e.VolumeParam) < len(common.VolumeColumnPortaTable) && e.VolumeParam != 0 {
+			mem.portamento = common.VolumeColumnPortaTable[e.VolumeParam]
+		}
+	}
+}
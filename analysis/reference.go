@@ -0,0 +1,244 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package analysis
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"go.mukunda.com/modlib/common"
+	"go.mukunda.com/modlib/render"
+)
+
+// Version of the AudioProfile layout and calculations. Profiles of different versions
+// can't be compared.
+const AudioProfileVersion = 1
+
+const (
+	profileWindow  = 0.05 // Seconds per window.
+	profileBands   = 24   // Log-spaced spectrum bands per window.
+	profileLowHz   = 50.0
+	profileHighHz  = 16000.0
+	profileFloorDb = -80.0 // Quieter levels are clamped, so silence compares as equal.
+)
+
+// Returned when comparing profiles that weren't measured the same way.
+var ErrProfileMismatch = errors.New("audio profiles aren't comparable")
+
+// A compact summary of a render: loudness and a coarse spectrum for each 50ms window.
+// Profiles are small enough to store next to tests as references, e.g. as JSON, and they
+// don't depend on the sample rate, so a render can be checked against one made by another
+// player such as libopenmpt.
+type AudioProfile struct {
+	Version  int     // AudioProfileVersion
+	Duration float64 // Seconds
+
+	RMS   [][2]float64 // Left and right level of each window, in dB
+	Bands [][]float64  // Level of each band of each window, in dB
+}
+
+// Differences allowed between a render and its reference.
+type Tolerance struct {
+	Duration float64 // Seconds
+	RMS      float64 // Mean dB difference of the window levels
+	Spectrum float64 // Mean dB difference of the spectrum bands
+}
+
+// Loose enough to allow for different interpolation and resampling, but not for missing
+// notes or wrong volumes.
+var DefaultTolerance = Tolerance{Duration: 0.1, RMS: 1.5, Spectrum: 4}
+
+// Result of comparing a render against a reference.
+type AudioComparison struct {
+	DurationDifference float64 // Seconds, positive if the render is longer
+
+	RMSError      float64 // Mean absolute dB difference of the window levels
+	SpectrumError float64 // Mean absolute dB difference of the spectrum bands
+
+	// Start of the window with the largest level difference, in seconds, and the
+	// difference there.
+	WorstTime     float64
+	WorstRMSError float64
+
+	Pass bool // All errors are within the tolerance
+}
+
+// Measures windows of interleaved stereo audio as it's fed in.
+type profiler struct {
+	profile AudioProfile
+	rate    int
+	window  []float32 // Frames of the window being filled.
+	frames  int
+	fftSize int
+	bands   []int // First FFT bin of each band, plus the end of the last band.
+}
+
+func newProfiler(rate int) *profiler {
+	p := &profiler{rate: rate, profile: AudioProfile{Version: AudioProfileVersion}}
+	size := max(int(profileWindow*float64(rate)), 1)
+	p.window = make([]float32, 0, size*2)
+	p.fftSize = 1
+	for p.fftSize < size {
+		p.fftSize <<= 1
+	}
+
+	binHz := float64(rate) / float64(p.fftSize)
+	for b := 0; b <= profileBands; b++ {
+		hz := profileLowHz * math.Pow(profileHighHz/profileLowHz, float64(b)/profileBands)
+		p.bands = append(p.bands, min(int(math.Round(hz/binHz)), p.fftSize/2))
+	}
+	return p
+}
+
+func decibels(power float64) float64 {
+	if power <= 0 {
+		return profileFloorDb
+	}
+	return max(10*math.Log10(power), profileFloorDb)
+}
+
+func (p *profiler) write(audio []float32) {
+	for len(audio) > 0 {
+		n := min(len(audio), cap(p.window)-len(p.window))
+		p.window = append(p.window, audio[:n]...)
+		audio = audio[n:]
+		if len(p.window) == cap(p.window) {
+			p.measure()
+		}
+	}
+}
+
+// Measure the buffered window. A partial window at the end is zero-padded.
+func (p *profiler) measure() {
+	frames := len(p.window) / 2
+	if frames == 0 {
+		return
+	}
+	size := cap(p.window) / 2
+
+	var power [2]float64
+	buffer := make([]complex128, p.fftSize)
+	for i := range frames {
+		left, right := float64(p.window[i*2]), float64(p.window[i*2+1])
+		power[0] += left * left
+		power[1] += right * right
+		hann := 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(size))
+		buffer[i] = complex((left+right)/2*hann, 0)
+	}
+	p.profile.RMS = append(p.profile.RMS, [2]float64{
+		decibels(power[0] / float64(size)),
+		decibels(power[1] / float64(size)),
+	})
+
+	fft(buffer)
+	bands := make([]float64, profileBands)
+	for b := range bands {
+		sum := 0.0
+		for k := p.bands[b]; k < max(p.bands[b+1], p.bands[b]+1) && k < p.fftSize/2; k++ {
+			sum += real(buffer[k])*real(buffer[k]) + imag(buffer[k])*imag(buffer[k])
+		}
+		// Normalize so a full scale sine reads near 0 dB regardless of the window size.
+		bands[b] = decibels(sum / float64(size*size) * 4)
+	}
+	p.profile.Bands = append(p.profile.Bands, bands)
+
+	p.frames += frames
+	p.window = p.window[:0]
+}
+
+func (p *profiler) finish() AudioProfile {
+	p.measure()
+	p.profile.Duration = float64(p.frames) / float64(p.rate)
+	return p.profile
+}
+
+// Profile interleaved stereo audio.
+func ProfileAudio(audio []float32, rate int) AudioProfile {
+	p := newProfiler(rate)
+	p.write(audio)
+	return p.finish()
+}
+
+// Render a module once through and profile the result.
+func ProfileModule(m *common.Module, rate int) AudioProfile {
+	p := newProfiler(rate)
+	render.NewPlayer(m, rate).RenderChunks(4096, func(audio []float32) bool {
+		p.write(audio)
+		return true
+	})
+	return p.finish()
+}
+
+// Profile a WAV file, e.g. a reference render from another player. Mono files are
+// treated as centered.
+func ProfileWav(r io.Reader) (AudioProfile, error) {
+	sample, err := common.ReadWav(r)
+	if err != nil {
+		return AudioProfile{}, err
+	}
+
+	channels := sample.Data.Float64()
+	if len(channels) == 0 {
+		return ProfileAudio(nil, max(sample.C5, 1)), nil
+	}
+	left, right := channels[0], channels[len(channels)-1]
+	audio := make([]float32, len(left)*2)
+	for i := range left {
+		audio[i*2] = float32(left[i])
+		audio[i*2+1] = float32(right[i])
+	}
+	return ProfileAudio(audio, max(sample.C5, 1)), nil
+}
+
+// Compare a render against a reference. Windows past the end of the shorter profile are
+// compared against silence.
+func CompareAudio(profile, reference *AudioProfile, tolerance Tolerance) (AudioComparison, error) {
+	var result AudioComparison
+	if profile.Version != AudioProfileVersion || reference.Version != AudioProfileVersion {
+		return result, fmt.Errorf("%w: version %d and %d, expected %d", ErrProfileMismatch,
+			profile.Version, reference.Version, AudioProfileVersion)
+	}
+
+	result.DurationDifference = profile.Duration - reference.Duration
+	windows := max(len(profile.RMS), len(reference.RMS))
+	silence := make([]float64, profileBands)
+	for i := range silence {
+		silence[i] = profileFloorDb
+	}
+	window := func(p *AudioProfile, i int) ([2]float64, []float64) {
+		if i < len(p.RMS) && i < len(p.Bands) {
+			return p.RMS[i], p.Bands[i]
+		}
+		return [2]float64{profileFloorDb, profileFloorDb}, silence
+	}
+
+	for i := range windows {
+		rms, bands := window(profile, i)
+		refRms, refBands := window(reference, i)
+		if len(bands) != len(refBands) {
+			return result, fmt.Errorf("%w: %d and %d bands", ErrProfileMismatch, len(bands), len(refBands))
+		}
+
+		e := (math.Abs(rms[0]-refRms[0]) + math.Abs(rms[1]-refRms[1])) / 2
+		result.RMSError += e
+		if e > result.WorstRMSError {
+			result.WorstRMSError = e
+			result.WorstTime = float64(i) * profileWindow
+		}
+		for b := range bands {
+			result.SpectrumError += math.Abs(bands[b] - refBands[b])
+		}
+	}
+
+	if windows > 0 {
+		result.RMSError /= float64(windows)
+		result.SpectrumError /= float64(windows * profileBands)
+	}
+	result.Pass = math.Abs(result.DurationDifference) <= tolerance.Duration &&
+		result.RMSError <= tolerance.RMS && result.SpectrumError <= tolerance.Spectrum
+	return result, nil
+}
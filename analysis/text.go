@@ -0,0 +1,97 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package analysis
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"go.mukunda.com/modlib/charset"
+	"go.mukunda.com/modlib/common"
+)
+
+// The searchable text of a module, decoded to UTF-8.
+type ModuleText struct {
+	Title       string
+	Samples     []string // Non-empty sample names
+	Instruments []string // Non-empty instrument names
+	Message     string   // Lines are separated by '\n'
+
+	// Name of the code page the text was decoded from.
+	Charset string
+}
+
+// Collect the text of a module for indexing. The code page is detected from all of the
+// text together, falling back to the format's usual code page when the guess is unclear.
+func ExtractText(m *common.Module) ModuleText {
+	raw := []string{m.Title, m.Message}
+	var samples, instruments []string
+	for i := range m.Samples {
+		samples = append(samples, m.Samples[i].Name)
+	}
+	for i := range m.Instruments {
+		instruments = append(instruments, m.Instruments[i].Name)
+	}
+	raw = append(raw, samples...)
+	raw = append(raw, instruments...)
+
+	cs := charset.Detect(charset.ForSource(m.Source), raw...)
+	clean := func(s string) string {
+		return strings.TrimSpace(cs.Decode(strings.TrimRight(s, "\x00")))
+	}
+	names := func(list []string) []string {
+		var result []string
+		for _, s := range list {
+			if s = clean(s); s != "" {
+				result = append(result, s)
+			}
+		}
+		return result
+	}
+
+	message := strings.NewReplacer("\r\n", "\n", "\r", "\n").Replace(m.Message)
+	return ModuleText{
+		Title:       clean(m.Title),
+		Samples:     names(samples),
+		Instruments: names(instruments),
+		Message:     strings.TrimRight(cs.Decode(message), "\x00\n "),
+		Charset:     cs.Name,
+	}
+}
+
+// The distinct lowercase words in the text, sorted, for building a search index. Words
+// are runs of letters and digits; decorative characters like box drawing are dropped.
+func (t *ModuleText) Terms() []string {
+	var terms []string
+	add := func(s string) {
+		for _, word := range strings.FieldsFunc(s, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		}) {
+			terms = append(terms, strings.ToLower(word))
+		}
+	}
+
+	add(t.Title)
+	add(t.Message)
+	for _, s := range t.Samples {
+		add(s)
+	}
+	for _, s := range t.Instruments {
+		add(s)
+	}
+	slices.Sort(terms)
+	return slices.Compact(terms)
+}
+
+// The module's tags (see Module.Tags) with LENGTH added from the song's play time.
+func Tags(m *common.Module) map[string]string {
+	tags := m.Tags()
+	if d := ExtractRowTimes(m).Duration(); d > 0 {
+		tags[common.TagLength] = strconv.FormatInt(d.Milliseconds(), 10)
+	}
+	return tags
+}
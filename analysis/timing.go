@@ -0,0 +1,99 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package analysis
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"go.mukunda.com/modlib/common"
+	"go.mukunda.com/modlib/render"
+)
+
+// When one row of the song plays.
+type RowTime struct {
+	Order    int
+	Row      int
+	Start    time.Duration // From the start of the song.
+	Duration time.Duration // Including repeats from row delays (SEx).
+}
+
+// The wall-clock time of each row over one pass through the song, from its speed, tempo
+// and row delays. Rows that play more than once, e.g. in a pattern loop, have an entry for
+// each time.
+type RowTimeline struct {
+	Rows []RowTime
+
+	first map[[2]int]int // First entry for each order and row.
+}
+
+// Compute the time of every row played in a module.
+func ExtractRowTimes(m *common.Module) *RowTimeline {
+	t := &RowTimeline{first: map[[2]int]int{}}
+
+	seconds := 0.0
+	at := func() time.Duration {
+		return time.Duration(math.Round(seconds * float64(time.Second)))
+	}
+	for tick := range render.NewPlayer(m, render.DefaultSampleRate).Ticks() {
+		if tick.Tick == 0 && tick.Repeat == 0 {
+			t.closeRow(at())
+			key := [2]int{tick.Order, tick.Row}
+			if _, ok := t.first[key]; !ok {
+				t.first[key] = len(t.Rows)
+			}
+			t.Rows = append(t.Rows, RowTime{Order: tick.Order, Row: tick.Row, Start: at()})
+		}
+		seconds += 2.5 / float64(tick.Tempo)
+	}
+	t.closeRow(at())
+	return t
+}
+
+// Set the duration of the last row, which ends at the given time.
+func (t *RowTimeline) closeRow(end time.Duration) {
+	if n := len(t.Rows); n > 0 {
+		t.Rows[n-1].Duration = end - t.Rows[n-1].Start
+	}
+}
+
+// How long a row plays the first time it's reached. Returns false if the row isn't
+// played.
+func (t *RowTimeline) RowDuration(order, row int) (time.Duration, bool) {
+	i, ok := t.first[[2]int{order, row}]
+	if !ok {
+		return 0, false
+	}
+	return t.Rows[i].Duration, true
+}
+
+// When a row first starts playing. Returns false if the row isn't played.
+func (t *RowTimeline) RowStart(order, row int) (time.Duration, bool) {
+	i, ok := t.first[[2]int{order, row}]
+	if !ok {
+		return 0, false
+	}
+	return t.Rows[i].Start, true
+}
+
+// The row playing at a point in time. Returns false before the start or after the end of
+// the song.
+func (t *RowTimeline) At(d time.Duration) (RowTime, bool) {
+	i := sort.Search(len(t.Rows), func(i int) bool { return t.Rows[i].Start > d }) - 1
+	if i < 0 || d >= t.Rows[i].Start+t.Rows[i].Duration {
+		return RowTime{}, false
+	}
+	return t.Rows[i], true
+}
+
+// Length of the song.
+func (t *RowTimeline) Duration() time.Duration {
+	if len(t.Rows) == 0 {
+		return 0
+	}
+	last := t.Rows[len(t.Rows)-1]
+	return last.Start + last.Duration
+}
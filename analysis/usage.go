@@ -0,0 +1,108 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package analysis
+
+import "go.mukunda.com/modlib/common"
+
+// A pattern cell that uses a sample or instrument.
+type UsageRef struct {
+	Order   int // Position in the order list, -1 for patterns that aren't in it.
+	Pattern int
+	Row     int
+	Channel int
+}
+
+// Where each sample and instrument is used, indexed like Module.Samples and
+// Module.Instruments.
+type Usage struct {
+	Samples     [][]UsageRef
+	Instruments [][]UsageRef
+}
+
+// Find every cell that uses each sample and instrument. A note uses the instrument in its
+// cell, or the last one given in its channel, and the sample that the instrument's notemap
+// picks for it. In modules without instruments, the instrument column picks the sample
+// directly. Patterns are visited in order list order, once for each time they appear, and
+// then patterns missing from the order list are added with Order -1.
+func UsageReport(m *common.Module) Usage {
+	u := Usage{
+		Samples:     make([][]UsageRef, len(m.Samples)),
+		Instruments: make([][]UsageRef, len(m.Instruments)),
+	}
+
+	current := make(map[uint8]int16) // Last instrument of each channel.
+	ordered := make(map[int]bool)
+	for order, index := range m.Order {
+		pattern := int(index)
+		if pattern >= len(m.Patterns) {
+			continue
+		}
+		ordered[pattern] = true
+		u.addPattern(m, order, pattern, current)
+	}
+	for pattern := range m.Patterns {
+		if !ordered[pattern] {
+			u.addPattern(m, -1, pattern, make(map[uint8]int16))
+		}
+	}
+	return u
+}
+
+func (u *Usage) addPattern(m *common.Module, order, pattern int, current map[uint8]int16) {
+	for row, r := range m.Patterns[pattern].Rows {
+		for _, e := range r.Entries {
+			ref := UsageRef{Order: order, Pattern: pattern, Row: row, Channel: int(e.Channel)}
+			if e.Instrument != 0 {
+				current[e.Channel] = e.Instrument
+			}
+			hasNote := e.Note > 0 && e.Note <= 120
+			if e.Instrument == 0 && !hasNote {
+				continue
+			}
+
+			number := current[e.Channel]
+			if !m.UseInstruments {
+				addRef(u.Samples, number, ref)
+				continue
+			}
+
+			addRef(u.Instruments, number, ref)
+			if hasNote && number >= 1 && int(number) <= len(m.Instruments) {
+				addRef(u.Samples, m.Instruments[number-1].Notemap[e.Note-1].Sample, ref)
+			}
+		}
+	}
+}
+
+// Record a reference to a 1-based sample or instrument number.
+func addRef(refs [][]UsageRef, number int16, ref UsageRef) {
+	if number >= 1 && int(number) <= len(refs) {
+		refs[number-1] = append(refs[number-1], ref)
+	}
+}
+
+// Indexes of the samples that aren't used by any pattern in the order list.
+func (u *Usage) UnusedSamples() []int {
+	return unused(u.Samples)
+}
+
+// Indexes of the instruments that aren't used by any pattern in the order list.
+func (u *Usage) UnusedInstruments() []int {
+	return unused(u.Instruments)
+}
+
+func unused(refs [][]UsageRef) []int {
+	var result []int
+	for i, list := range refs {
+		used := false
+		for _, ref := range list {
+			used = used || ref.Order >= 0
+		}
+		if !used {
+			result = append(result, i)
+		}
+	}
+	return result
+}
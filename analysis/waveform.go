@@ -0,0 +1,81 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package analysis
+
+import (
+	"time"
+
+	"go.mukunda.com/modlib/common"
+	"go.mukunda.com/modlib/render"
+)
+
+const (
+	waveformRate        = 8000             // Sample rate of thumbnail renders.
+	waveformMaxDuration = 20 * time.Minute // Longest render used for a thumbnail.
+)
+
+// The lowest and highest level in one column of a waveform thumbnail, -1 to 1.
+type Peak = common.Peak
+
+// Compute a peak envelope of a module for drawing a waveform thumbnail widthPx columns
+// wide. The song is rendered once through at a low sample rate and mixed to mono. Modules
+// that render to silence, like sample packs without patterns, show their samples played
+// back to back instead.
+func Waveform(m *common.Module, widthPx int) []Peak {
+	if widthPx <= 0 {
+		return nil
+	}
+
+	var audio []float32
+	player := render.NewPlayer(m, waveformRate)
+	player.SetPlaybackOptions(render.PlaybackOptions{MaxDuration: waveformMaxDuration})
+	player.RenderChunks(4096, func(chunk []float32) bool {
+		for i := 0; i+1 < len(chunk); i += 2 {
+			audio = append(audio, (chunk[i]+chunk[i+1])/2)
+		}
+		return true
+	})
+
+	if silent(audio) {
+		audio = audio[:0]
+		for i := range m.Samples {
+			for _, v := range monoSignal(&m.Samples[i]) {
+				audio = append(audio, float32(v))
+			}
+		}
+	}
+
+	return peaks(audio, widthPx)
+}
+
+// True if all of the audio is 0.
+func silent(audio []float32) bool {
+	for _, v := range audio {
+		if v != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Split audio into columns and find the peaks of each. When there are fewer frames than
+// columns, frames are repeated.
+func peaks(audio []float32, columns int) []Peak {
+	result := make([]Peak, columns)
+	if len(audio) == 0 {
+		return result
+	}
+	for i := range result {
+		start := i * len(audio) / columns
+		end := max((i+1)*len(audio)/columns, start+1)
+		peak := Peak{Min: audio[start], Max: audio[start]}
+		for _, v := range audio[start+1 : end] {
+			peak.Min = min(peak.Min, v)
+			peak.Max = max(peak.Max, v)
+		}
+		result[i] = peak
+	}
+	return result
+}
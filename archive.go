@@ -0,0 +1,157 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package modlib
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Largest module that is read into memory from an archive or other unbuffered source.
+const MaxModuleSize = 256 << 20
+
+// Returned when a module is larger than MaxModuleSize.
+var ErrModuleTooLarge = errors.New("module is too large")
+
+// Returned when the requested entry isn't in the archive.
+var ErrEntryNotFound = errors.New("archive entry not found")
+
+// Read all of a stream into memory, up to MaxModuleSize.
+func readLimited(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, MaxModuleSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > MaxModuleSize {
+		return nil, ErrModuleTooLarge
+	}
+	return data, nil
+}
+
+// Load a module from a .zip or gzip file. For zip files, entry selects the file to load;
+// if it's empty, the first file that is a recognizable module is loaded. Files that aren't
+// archives are loaded directly.
+func LoadModuleFromArchive(path string, entry string) (*Module, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	var magic [4]byte
+	n, _ := io.ReadFull(file, magic[:])
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case n == 4 && string(magic[:]) == "PK\x03\x04":
+		archive, err := zip.NewReader(file, info.Size())
+		if err != nil {
+			return nil, err
+		}
+		return loadFromZip(archive, entry, rawOptions)
+	case n >= 2 && magic[0] == 0x1F && magic[1] == 0x8B:
+		return loadFromGzip(file, rawOptions)
+	}
+
+	return LoadModuleFromStream(file)
+}
+
+// Load a module from a byte slice, which may be a zip or gzip archive. This doesn't need
+// a filesystem, e.g. for WebAssembly builds that receive files from JavaScript.
+func LoadModuleFromBytes(data []byte) (*Module, error) {
+	return loadFromMemory(data, rawOptions)
+}
+
+// Options of the loaders without options, which keep text byte for byte.
+var rawOptions = LoadOptions{RawText: true}
+
+// Load a module held in memory, which may be a zip or gzip archive.
+func loadFromMemory(data []byte, options LoadOptions) (*Module, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte("PK\x03\x04")):
+		archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return nil, err
+		}
+		return loadFromZip(archive, "", options)
+	case bytes.HasPrefix(data, []byte{0x1F, 0x8B}):
+		return loadFromGzip(bytes.NewReader(data), options)
+	}
+	return LoadModuleFromStreamWithOptions(bytes.NewReader(data), options)
+}
+
+// Load a module from a gzip stream.
+func loadFromGzip(r io.Reader, options LoadOptions) (*Module, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	data, err := readLimited(gz)
+	if err != nil {
+		return nil, err
+	}
+	return LoadModuleFromStreamWithOptions(bytes.NewReader(data), options)
+}
+
+// Load the named entry from a zip archive, or the first module found if the name is empty.
+func loadFromZip(archive *zip.Reader, entry string, options LoadOptions) (*Module, error) {
+	var unsupported error
+	for _, f := range archive.File {
+		if f.FileInfo().IsDir() || (entry != "" && f.Name != entry) {
+			continue
+		}
+
+		mod, err := loadZipEntry(f, options)
+		if entry == "" && errors.Is(err, ErrUnknownModuleFormat) {
+			continue
+		}
+		if entry == "" && errors.Is(err, ErrUnsupportedSource) {
+			// Keep looking for a module that can be loaded.
+			unsupported = err
+			continue
+		}
+		return mod, err
+	}
+
+	if entry != "" {
+		return nil, fmt.Errorf("%w: %s", ErrEntryNotFound, entry)
+	}
+	if unsupported != nil {
+		return nil, unsupported
+	}
+	return nil, ErrUnknownModuleFormat
+}
+
+func loadZipEntry(f *zip.File, options LoadOptions) (*Module, error) {
+	if f.UncompressedSize64 > MaxModuleSize {
+		return nil, fmt.Errorf("%w: %s", ErrModuleTooLarge, f.Name)
+	}
+
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	data, err := readLimited(rc)
+	if err != nil {
+		return nil, err
+	}
+	return LoadModuleFromStreamWithOptions(bytes.NewReader(data), options)
+}
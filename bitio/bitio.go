@@ -0,0 +1,97 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+/*
+This package reads and writes LSB-first bit streams, where values are packed starting
+from the lowest bit of each byte, as in IT sample compression and other formats from
+x86 trackers.
+*/
+package bitio
+
+import "errors"
+
+var ErrEndOfStream = errors.New("end of stream")
+var ErrBadWidth = errors.New("bad bit width")
+
+// Reads bits from a byte slice.
+type Reader struct {
+	source  []byte
+	readPos int
+
+	// A buffer of 64 bits.
+	buffer uint64
+
+	// Number of bits in the buffer.
+	buffered int
+}
+
+// Read bits from source.
+func NewReader(source []byte) *Reader {
+	return &Reader{source: source}
+}
+
+// Read a value of width bits, 0 to 32.
+func (r *Reader) Read(width int) (uint32, error) {
+	if width < 0 || width > 32 {
+		return 0, ErrBadWidth
+	}
+
+	for r.buffered < width {
+		if r.readPos >= len(r.source) {
+			return 0, ErrEndOfStream
+		}
+		r.buffer |= uint64(r.source[r.readPos]) << r.buffered
+		r.readPos++
+		r.buffered += 8
+	}
+
+	result := uint32(r.buffer & (1<<width - 1))
+	r.buffer >>= width
+	r.buffered -= width
+	return result, nil
+}
+
+// Skip to the start of the next byte, if the stream isn't already at one.
+func (r *Reader) Align() {
+	r.buffer >>= r.buffered % 8
+	r.buffered -= r.buffered % 8
+}
+
+// Number of bits left in the stream.
+func (r *Reader) Remaining() int {
+	return (len(r.source)-r.readPos)*8 + r.buffered
+}
+
+// Writes bits to a byte slice. The zero value is ready to use.
+type Writer struct {
+	data     []byte
+	buffer   uint64
+	buffered int
+}
+
+// Write the low width bits of a value. width is 0 to 32.
+func (w *Writer) Write(width int, value uint32) {
+	w.buffer |= (uint64(value) & (1<<width - 1)) << w.buffered
+	w.buffered += width
+	for w.buffered >= 8 {
+		w.data = append(w.data, byte(w.buffer))
+		w.buffer >>= 8
+		w.buffered -= 8
+	}
+}
+
+// Pad with zeros to the start of the next byte.
+func (w *Writer) Align() {
+	if w.buffered > 0 {
+		w.Write(8-w.buffered, 0)
+	}
+}
+
+// The written bytes, with the last partial byte padded with zeros.
+func (w *Writer) Bytes() []byte {
+	if w.buffered > 0 {
+		return append(w.data, byte(w.buffer))
+	}
+	return w.data
+}
@@ -0,0 +1,41 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package bitio
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadWrite(t *testing.T) {
+	var w Writer
+	w.Write(3, 5)
+	w.Write(7, 0x1FF) // Only the low 7 bits are written.
+	w.Write(32, 0xDEADBEEF)
+	w.Align()
+	w.Write(4, 0xA)
+	data := w.Bytes()
+	assert.Equal(t, []byte{0xFD, 0xBF, 0xFB, 0xB6, 0x7A, 0x03, 0x0A}, data)
+
+	r := NewReader(data)
+	assert.Equal(t, 56, r.Remaining())
+	v, err := r.Read(3)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 5, v)
+	v, _ = r.Read(7)
+	assert.EqualValues(t, 0x7F, v)
+	v, _ = r.Read(32)
+	assert.EqualValues(t, uint32(0xDEADBEEF), v)
+	r.Align()
+	assert.Equal(t, 8, r.Remaining())
+	v, _ = r.Read(4)
+	assert.EqualValues(t, 0xA, v)
+
+	_, err = r.Read(5)
+	assert.ErrorIs(t, err, ErrEndOfStream)
+	_, err = r.Read(33)
+	assert.ErrorIs(t, err, ErrBadWidth)
+}
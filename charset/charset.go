@@ -0,0 +1,170 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+/*
+This package converts module text between the 8-bit code pages used by trackers and UTF-8.
+Module strings are loaded byte for byte, so names written on DOS or the Amiga need to be
+decoded before they can be displayed.
+*/
+package charset
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"go.mukunda.com/modlib/common"
+)
+
+// An 8-bit code page. Bytes below 0x80 are ASCII in every supported code page.
+type Charset struct {
+	Name string
+
+	high []rune // Characters for 0x80-0xFF, nil for UTF-8.
+	utf8 bool
+}
+
+// The upper half of IBM code page 437, used by DOS.
+const cp437High = "ÇüéâäàåçêëèïîìÄÅÉæÆôöòûùÿÖÜ¢£¥₧ƒáíóúñÑªº¿⌐¬½¼¡«»" +
+	"░▒▓│┤╡╢╖╕╣║╗╝╜╛┐└┴┬├─┼╞╟╚╔╩╦╠═╬╧╨╤╥╙╘╒╓╫╪┘┌█▄▌▐▀" +
+	"αßΓπΣσµτΦΘΩδ∞φε∩≡±≥≤⌠⌡÷≈°∙·√ⁿ²■ "
+
+var (
+	// DOS code page 437. Default for DOS-era formats like S3M and IT.
+	CP437 = &Charset{Name: "CP437", high: []rune(cp437High)}
+
+	// ISO-8859-1, which matches the Amiga character set. Default for Amiga formats like
+	// MOD and AHX.
+	Latin1 = &Charset{Name: "ISO-8859-1", high: latin1High()}
+
+	// Text that is already UTF-8. Invalid sequences decode as U+FFFD.
+	UTF8 = &Charset{Name: "UTF-8", utf8: true}
+)
+
+func latin1High() []rune {
+	high := make([]rune, 128)
+	for i := range high {
+		high[i] = rune(0x80 + i)
+	}
+	return high
+}
+
+// The code page that a format's text is assumed to use.
+func ForSource(source common.ModuleSourceFormat) *Charset {
+	switch source {
+	case common.ModSource, common.AhxSource:
+		return Latin1
+	}
+	return CP437
+}
+
+// Convert text in this code page to UTF-8.
+func (c *Charset) Decode(s string) string {
+	if c.utf8 {
+		return strings.ToValidUTF8(s, "�")
+	}
+	var b strings.Builder
+	b.Grow(len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] < 0x80 {
+			b.WriteByte(s[i])
+		} else {
+			b.WriteRune(c.high[s[i]-0x80])
+		}
+	}
+	return b.String()
+}
+
+// Convert UTF-8 text to this code page. Characters that the code page doesn't have
+// become '?'.
+func (c *Charset) Encode(s string) string {
+	if c.utf8 {
+		return s
+	}
+	var b strings.Builder
+	b.Grow(len(s))
+	for _, r := range s {
+		if r < 0x80 {
+			b.WriteByte(byte(r))
+		} else {
+			b.WriteByte(c.encodeRune(r))
+		}
+	}
+	return b.String()
+}
+
+func (c *Charset) encodeRune(r rune) byte {
+	for i, h := range c.high {
+		if h == r {
+			return byte(0x80 + i)
+		}
+	}
+	return '?'
+}
+
+// Guess the code page of a set of strings. Text that is valid UTF-8 (including plain
+// ASCII) is reported as UTF-8. Otherwise, the high bytes are scored: CP437 is favored by
+// its accented letters at 0x80-0x9F (control codes in ISO-8859-1) and the box drawing
+// characters used in ASCII art, while ISO-8859-1 is favored by lowercase accented
+// letters at 0xE0-0xFF (Greek and math symbols in CP437). Ties go to the fallback.
+func Detect(fallback *Charset, texts ...string) *Charset {
+	cp437, latin1 := 0, 0
+	valid := true
+	for _, text := range texts {
+		if !utf8.ValidString(text) {
+			valid = false
+		}
+		for i := 0; i < len(text); i++ {
+			switch b := text[i]; {
+			case b >= 0x80 && b <= 0x9F:
+				cp437 += 2
+			case b >= 0xB0 && b <= 0xDF:
+				cp437++
+			case b >= 0xE0:
+				latin1++
+			}
+		}
+	}
+
+	switch {
+	case valid:
+		return UTF8
+	case cp437 > latin1:
+		return CP437
+	case latin1 > cp437:
+		return Latin1
+	}
+	return fallback
+}
+
+// Apply a conversion to every text field of a module.
+func convertModule(m *common.Module, convert func(string) string) {
+	m.Title = convert(m.Title)
+	m.Message = convert(m.Message)
+	for i := range m.ChannelSettings {
+		m.ChannelSettings[i].Name = convert(m.ChannelSettings[i].Name)
+	}
+	for i := range m.Instruments {
+		ins := &m.Instruments[i]
+		ins.Name = convert(ins.Name)
+		ins.DosFilename = convert(ins.DosFilename)
+	}
+	for i := range m.Samples {
+		s := &m.Samples[i]
+		s.Name = convert(s.Name)
+		s.DosFilename = convert(s.DosFilename)
+	}
+	for i := range m.Patterns {
+		m.Patterns[i].Name = convert(m.Patterns[i].Name)
+	}
+}
+
+// Convert all text in a loaded module from this code page to UTF-8.
+func (c *Charset) DecodeModule(m *common.Module) {
+	convertModule(m, c.Decode)
+}
+
+// Convert all text in a module from UTF-8 to this code page, e.g. before saving it.
+func (c *Charset) EncodeModule(m *common.Module) {
+	convertModule(m, c.Encode)
+}
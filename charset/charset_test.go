@@ -0,0 +1,39 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package charset
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mukunda.com/modlib/common"
+)
+
+func TestDecodeEncode(t *testing.T) {
+	assert.Equal(t, "Café ░▒▓", CP437.Decode("Caf\x82 \xB0\xB1\xB2"))
+	assert.Equal(t, "Caf\x82 \xB0\xB1\xB2", CP437.Encode("Café ░▒▓"))
+	assert.Equal(t, "Café", Latin1.Decode("Caf\xE9"))
+	assert.Equal(t, "Caf\xE9 ?", Latin1.Encode("Café ░"))
+	assert.Equal(t, "a�b", UTF8.Decode("a\xFFb"))
+
+	for _, cs := range []*Charset{CP437, Latin1} {
+		all := make([]byte, 256)
+		for i := range all {
+			all[i] = byte(i)
+		}
+		assert.Equal(t, string(all), cs.Encode(cs.Decode(string(all))), cs.Name)
+	}
+}
+
+func TestDetect(t *testing.T) {
+	assert.Equal(t, UTF8, Detect(CP437, "plain", "text"))
+	assert.Equal(t, UTF8, Detect(CP437, "Café"))
+	assert.Equal(t, CP437, Detect(Latin1, "Caf\x82", "\xC9\xCD\xCD\xBB"))
+	assert.Equal(t, Latin1, Detect(CP437, "Caf\xE9 cr\xE8me"))
+	assert.Equal(t, Latin1, Detect(Latin1, "\xA9 1992"))
+
+	assert.Equal(t, Latin1, ForSource(common.ModSource))
+	assert.Equal(t, CP437, ForSource(common.ItSource))
+}
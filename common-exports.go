@@ -12,6 +12,9 @@ type Module = common.Module
 type ChannelSetting = common.ChannelSetting
 type Instrument = common.Instrument
 type NotemapEntry = common.NotemapEntry
+type KeyZone = common.KeyZone
+type FMPatch = common.FMPatch
+type FMOperator = common.FMOperator
 type EnvelopeType = common.EnvelopeType
 type Envelope = common.Envelope
 type EnvelopeNode = common.EnvelopeNode
@@ -20,6 +23,13 @@ type SampleData = common.SampleData
 type Pattern = common.Pattern
 type PatternRow = common.PatternRow
 type PatternEntry = common.PatternEntry
+type SynthPatch = common.SynthPatch
+type SynthStep = common.SynthStep
+type SourceDetails = common.SourceDetails
+type ItDetails = common.ItDetails
+type S3mDetails = common.S3mDetails
+type ProgressFunc = common.ProgressFunc
+type Arena = common.Arena
 
 const (
 	UnknownSource = common.UnknownSource
@@ -43,3 +53,10 @@ const (
 	DctInstrument = common.DctInstrument
 	DctPlugin     = common.DctPlugin
 )
+
+const (
+	StageInstruments = common.StageInstruments
+	StageSamples     = common.StageSamples
+	StagePatterns    = common.StagePatterns
+	StageRender      = common.StageRender
+)
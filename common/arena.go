@@ -0,0 +1,55 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package common
+
+// Entries per arena block. 16384 entries is 128 KiB.
+const arenaBlockSize = 16384
+
+// Allocates the entries of pattern rows from large blocks, so loading a module doesn't
+// make an allocation for every row. For bulk loads, like scanning an archive of modules,
+// Reset lets the next load reuse the blocks.
+//
+// The zero value is ready to use. An Arena must not be used by several goroutines at once.
+type Arena struct {
+	blocks  [][]PatternEntry
+	current int // Block being allocated from.
+	used    int // Entries used in the current block.
+}
+
+// Copy row entries into the arena. The result has no spare capacity, so appending to it
+// makes a new slice instead of overwriting the next row. Empty input returns nil. A nil
+// arena makes a normal allocation.
+func (a *Arena) Entries(entries []PatternEntry) []PatternEntry {
+	n := len(entries)
+	if n == 0 {
+		return nil
+	}
+	if a == nil || n > arenaBlockSize {
+		return append([]PatternEntry(nil), entries...)
+	}
+
+	if len(a.blocks) == 0 || a.used+n > arenaBlockSize {
+		if len(a.blocks) > 0 {
+			a.current++
+		}
+		if a.current == len(a.blocks) {
+			a.blocks = append(a.blocks, make([]PatternEntry, arenaBlockSize))
+		}
+		a.used = 0
+	}
+
+	block := a.blocks[a.current]
+	result := block[a.used : a.used+n : a.used+n]
+	copy(result, entries)
+	a.used += n
+	return result
+}
+
+// Make the arena's memory available for reuse. Modules loaded with the arena must not be
+// used after this, since their pattern entries will be overwritten.
+func (a *Arena) Reset() {
+	a.current = 0
+	a.used = 0
+}
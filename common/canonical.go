@@ -0,0 +1,149 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package common
+
+import (
+	"slices"
+	"strings"
+)
+
+// Rewrite a module into one canonical form, so modules holding the same music compare
+// equal no matter which format they were loaded from. Playback isn't changed:
+//
+//   - Row entries are sorted by channel, and empty or repeated entries are removed.
+//   - Empty rows after a pattern break or jump are removed when no Cxx can land past the
+//     break, like the padding of fixed-length S3M patterns.
+//   - Names lose their NUL and space padding, and the message uses \n line endings.
+//   - Fields that are ignored because of another setting are zeroed, e.g. loop points of
+//     samples without a loop.
+//   - The order list ends with one end marker.
+func Canonicalize(m *Module) {
+	m.Title = trimPadding(m.Title)
+	m.Message = strings.ReplaceAll(m.Message, "\r\n", "\n")
+	m.Message = strings.TrimRight(strings.ReplaceAll(m.Message, "\r", "\n"), "\x00 \n")
+
+	for i := range m.ChannelSettings {
+		m.ChannelSettings[i].Name = trimPadding(m.ChannelSettings[i].Name)
+	}
+
+	if end := slices.Index(m.Order, 255); end >= 0 {
+		m.Order = m.Order[:end]
+	}
+	m.Order = append(m.Order, 255)
+
+	for i := range m.Instruments {
+		canonicalInstrument(&m.Instruments[i])
+	}
+	for i := range m.Samples {
+		canonicalSample(&m.Samples[i])
+	}
+
+	breakTarget := 0
+	for i := range m.Patterns {
+		for _, row := range m.Patterns[i].Rows {
+			for _, e := range row.Entries {
+				if e.Effect == effectBreak {
+					breakTarget = max(breakTarget, int(e.EffectParam))
+				}
+			}
+		}
+	}
+	for i := range m.Patterns {
+		canonicalPattern(&m.Patterns[i], breakTarget)
+	}
+}
+
+const (
+	effectJump  = 2 // Bxx
+	effectBreak = 3 // Cxx
+)
+
+// Remove the NUL and space padding of fixed-length name fields.
+func trimPadding(s string) string {
+	if i := strings.IndexByte(s, 0); i >= 0 {
+		s = s[:i]
+	}
+	return strings.TrimRight(s, " ")
+}
+
+func canonicalInstrument(ins *Instrument) {
+	ins.Name = trimPadding(ins.Name)
+	ins.DosFilename = trimPadding(ins.DosFilename)
+	if !ins.DefaultPanEnabled {
+		ins.DefaultPan = 0
+	}
+	if ins.FilterCutoff&128 == 0 {
+		ins.FilterCutoff = 0
+	}
+	if ins.FilterResonance&128 == 0 {
+		ins.FilterResonance = 0
+	}
+	if len(ins.Envelopes) == 0 {
+		ins.Envelopes = nil
+	}
+	for i := range ins.Envelopes {
+		env := &ins.Envelopes[i]
+		if !env.Loop {
+			env.LoopStart, env.LoopEnd = 0, 0
+		}
+		if !env.Sustain {
+			env.SustainStart, env.SustainEnd = 0, 0
+		}
+		if len(env.Nodes) == 0 {
+			env.Nodes = nil
+		}
+	}
+}
+
+func canonicalSample(s *Sample) {
+	s.Name = trimPadding(s.Name)
+	s.DosFilename = trimPadding(s.DosFilename)
+	if s.DefaultPanning&128 == 0 {
+		s.DefaultPanning = 0
+	}
+	if !s.Loop {
+		s.LoopStart, s.LoopEnd, s.PingPong = 0, 0, false
+	}
+	if !s.Sustain {
+		s.SustainLoopStart, s.SustainLoopEnd, s.PingPongSustain = 0, 0, false
+	}
+	if s.VibratoDepth == 0 {
+		s.VibratoSpeed, s.VibratoSweep, s.VibratoWaveform = 0, 0, 0
+	}
+}
+
+// Canonicalize a pattern's rows. breakTarget is the highest row a Cxx can start a pattern
+// at. Rows after the last break are only played when a Cxx lands after it.
+func canonicalPattern(p *Pattern, breakTarget int) {
+	p.Name = trimPadding(p.Name)
+
+	lastBreak := -1
+	for i := range p.Rows {
+		row := &p.Rows[i]
+		for j := range row.Entries {
+			if row.Entries[j].VolumeCommand == 0 {
+				row.Entries[j].VolumeParam = 0
+			}
+		}
+		row.Entries = row.normalized()
+		if len(row.Entries) == 0 {
+			row.Entries = nil
+		}
+		for _, e := range row.Entries {
+			if e.Effect == effectJump || e.Effect == effectBreak {
+				lastBreak = i
+			}
+		}
+	}
+	if lastBreak < 0 || breakTarget > lastBreak {
+		return
+	}
+
+	end := len(p.Rows)
+	for end > lastBreak+1 && len(p.Rows[end-1].Entries) == 0 {
+		end--
+	}
+	p.Rows = p.Rows[:end]
+}
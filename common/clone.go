@@ -0,0 +1,101 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package common
+
+import "slices"
+
+// Make a deep copy of the module. The copy shares no slices with the original, so either
+// can be edited while the other is in use, e.g., for undo history or saving in the
+// background.
+func (m *Module) Clone() *Module {
+	return m.clone((*SampleData).Clone)
+}
+
+// Copy the module, using data to copy the sample data.
+func (m *Module) clone(data func(*SampleData) SampleData) *Module {
+	c := *m
+	c.ChannelSettings = slices.Clone(m.ChannelSettings)
+	c.Order = slices.Clone(m.Order)
+
+	c.Instruments = slices.Clone(m.Instruments)
+	for i := range c.Instruments {
+		c.Instruments[i] = m.Instruments[i].Clone()
+	}
+
+	c.Samples = slices.Clone(m.Samples)
+	for i := range c.Samples {
+		c.Samples[i].Data = data(&m.Samples[i].Data)
+	}
+
+	c.Patterns = slices.Clone(m.Patterns)
+	for i := range c.Patterns {
+		c.Patterns[i] = m.Patterns[i].Clone()
+	}
+
+	c.Details = cloneDetails(m.Details)
+	return &c
+}
+
+// Make a deep copy of the instrument.
+func (ins *Instrument) Clone() Instrument {
+	c := *ins
+	c.Envelopes = slices.Clone(ins.Envelopes)
+	for i := range c.Envelopes {
+		c.Envelopes[i].Nodes = slices.Clone(ins.Envelopes[i].Nodes)
+	}
+	if ins.FM != nil {
+		fm := *ins.FM
+		c.FM = &fm
+	}
+	if ins.Synth != nil {
+		synth := *ins.Synth
+		synth.Performance = slices.Clone(ins.Synth.Performance)
+		c.Synth = &synth
+	}
+	return c
+}
+
+// Make a deep copy of the sample data.
+func (sd *SampleData) Clone() SampleData {
+	c := *sd
+	c.Data = slices.Clone(sd.Data)
+	for i, channel := range c.Data {
+		switch data := channel.(type) {
+		case []int8:
+			c.Data[i] = slices.Clone(data)
+		case []int16:
+			c.Data[i] = slices.Clone(data)
+		}
+	}
+	return c
+}
+
+// Make a deep copy of the pattern.
+func (p *Pattern) Clone() Pattern {
+	c := *p
+	c.Rows = slices.Clone(p.Rows)
+	for i := range c.Rows {
+		c.Rows[i].Entries = slices.Clone(p.Rows[i].Entries)
+	}
+	return c
+}
+
+func cloneDetails(details SourceDetails) SourceDetails {
+	switch d := details.(type) {
+	case *ItDetails:
+		c := *d
+		c.Quirks = slices.Clone(d.Quirks)
+		c.Warnings = slices.Clone(d.Warnings)
+		if d.Macros != nil {
+			macros := *d.Macros
+			c.Macros = &macros
+		}
+		return &c
+	case *S3mDetails:
+		c := *d
+		return &c
+	}
+	return details
+}
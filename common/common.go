@@ -4,6 +4,10 @@
 
 /*
 This package provides a medium for all supported sources. All submodules can convert into this structure which is based on IT.
+
+A Module can be read from several goroutines at once, but it must not be modified while
+anything else is using it. To keep playing or saving a module while editing it, take a
+Snapshot with Module.Freeze and give that to the readers.
 */
 package common
 
@@ -15,6 +19,9 @@ const (
 	S3mSource
 	XmSource
 	ItSource
+	DsmSource
+	StxSource
+	AhxSource
 )
 
 type Module struct {
@@ -24,7 +31,7 @@ type Module struct {
 	MixingVolume    int16  // Mixing volume of the song. 0 = 0%, 128 = 100%
 	InitialSpeed    int16  // Initial ticks per row (Axx)
 	InitialTempo    int16  // Initial BPM.
-	PanSeparation   int16  // TODO: how does it work
+	PanSeparation   int16  // Stereo separation. 0 = mono, 128 = full
 	PitchWheelDepth int16  // TODO: what is it for
 	StereoMixing    bool   // Enable stereo audio mixing.
 	UseInstruments  bool   // Enable use of instruments.
@@ -45,6 +52,10 @@ type Module struct {
 	Instruments     []Instrument
 	Samples         []Sample
 	Patterns        []Pattern
+
+	// Fields from the source format, e.g. *ItDetails. nil if the loader doesn't provide
+	// them.
+	Details SourceDetails
 }
 
 type ChannelSetting struct {
@@ -55,6 +66,12 @@ type ChannelSetting struct {
 	Surround      bool
 }
 
+// Special Module.Order entries.
+const (
+	OrderSkip = 254 // "+++", skipped during playback
+	OrderEnd  = 255 // "---", end of song
+)
+
 const (
 	NnaNoteCut  = 0
 	NnaContinue = 1
@@ -70,6 +87,12 @@ const (
 	DctPlugin     = 4
 )
 
+const (
+	DcaNoteCut = 0
+	DcaNoteOff = 1
+	DcaFade    = 2
+)
+
 type Instrument struct {
 	Name                 string
 	DosFilename          string
@@ -80,7 +103,9 @@ type Instrument struct {
 
 	// Controls changing pan according to pitch, for example, lower notes coming from one
 	// side, and higher notes coming from the other.
-	PitchPanSeparation int16
+	// Pitch-pan separation pans notes by how far they are from the center note:
+	// pan += (note - PitchPanCenter) * PitchPanSeparation / 8, on the 0-64 pan scale.
+	PitchPanSeparation int16 // -32 to 32
 	PitchPanCenter     int16 // (0-119)
 
 	GlobalVolume int16
@@ -89,10 +114,10 @@ type Instrument struct {
 	DefaultPanEnabled bool
 
 	RandomVolumeVariation int16 // percentage (0-100)
-	RandomPanVariation    int16 // percentage (0-100)
+	RandomPanVariation    int16 // pan units (0-64)
 
-	FilterCutoff    int16
-	FilterResonance int16
+	FilterCutoff    int16 // 0-127, used if bit 7 (128) is set
+	FilterResonance int16 // 0-127, used if bit 7 (128) is set
 
 	MidiChannel int16
 	MidiProgram int16
@@ -101,6 +126,99 @@ type Instrument struct {
 	Notemap [120]NotemapEntry
 
 	Envelopes []Envelope
+
+	// AdLib/OPL patch for FM instruments, nil for sample-based instruments.
+	FM *FMPatch
+
+	// Waveform generator settings for synthesized instruments, nil for sample-based
+	// instruments. The notemap still points at a sample with a pre-rendered approximation
+	// so synthesized instruments can be played like any other. See SynthVoice for playing
+	// them properly.
+	Synth *SynthPatch
+}
+
+// Waveforms of a synthesized instrument.
+const (
+	SynthWaveKeep     = 0 // Keep the current waveform
+	SynthWaveTriangle = 1
+	SynthWaveSawtooth = 2
+	SynthWaveSquare   = 3
+	SynthWaveNoise    = 4
+)
+
+// AHX-style waveform generator instrument.
+type SynthPatch struct {
+	WaveLength int16 // Cycle length is 4<<WaveLength samples (0-5)
+
+	// Volume envelope, in ticks and 0-64 volumes. It starts at 0 and doesn't wait for a
+	// note-off.
+	AttackTicks   int16
+	AttackVolume  int16
+	DecayTicks    int16
+	DecayVolume   int16
+	SustainTicks  int16
+	ReleaseTicks  int16
+	ReleaseVolume int16
+
+	FilterLower int16
+	FilterUpper int16
+	FilterSpeed int16
+
+	SquareLower int16
+	SquareUpper int16
+	SquareSpeed int16
+
+	VibratoDelay int16
+	VibratoDepth int16
+	VibratoSpeed int16
+
+	HardCutRelease      bool
+	HardCutReleaseTicks int16
+
+	// Steps run every PerformanceSpeed ticks after a note starts.
+	PerformanceSpeed int16
+	Performance      []SynthStep
+}
+
+// A step in a synthesized instrument's performance list.
+type SynthStep struct {
+	Note     int16 // Semitones from the played note, or a note (0-119) if Fixed. 0 = no change.
+	Fixed    bool
+	Waveform int16 // SynthWave*
+	Effects  [2]uint8
+	Params   [2]uint8
+}
+
+// FM instrument kinds. S3M AdLib instruments can be melodic or one of the OPL rhythm
+// mode drums.
+const (
+	FMMelodic   = 0
+	FMBassDrum  = 1
+	FMSnareDrum = 2
+	FMTom       = 3
+	FMCymbal    = 4
+	FMHiHat     = 5
+)
+
+// Register settings for a 2-operator OPL2 (AdLib) voice.
+type FMPatch struct {
+	Kind int16 // FM*
+
+	Modulator FMOperator
+	Carrier   FMOperator
+
+	// Register 0xC0: bits 1-3 are the modulator feedback, bit 0 selects additive
+	// synthesis instead of frequency modulation.
+	FeedbackConnection uint8
+}
+
+// Register settings for one OPL2 operator.
+type FMOperator struct {
+	Characteristic uint8 // 0x20: tremolo, vibrato, sustain, KSR, frequency multiplier
+	ScalingLevel   uint8 // 0x40: key scale level, output attenuation
+	AttackDecay    uint8 // 0x60
+	SustainRelease uint8 // 0x80
+	Waveform       uint8 // 0xE0
 }
 
 type NotemapEntry struct {
@@ -123,6 +241,10 @@ type Envelope struct {
 	Sustain bool
 	Type    EnvelopeType
 
+	// Continue from the previous note's position when a note is played with the same
+	// instrument, instead of restarting the envelope.
+	Carry bool
+
 	LoopStart    int16
 	LoopEnd      int16
 	SustainStart int16
@@ -147,6 +269,11 @@ type Sample struct {
 	Name        string
 	DosFilename string
 
+	// Path of the file holding the sample data, relative to the module, for samples that
+	// aren't stored in the module (OpenMPT external samples). Data is empty unless the
+	// file was found when loading.
+	ExternalPath string
+
 	GlobalVolume   int16 // 0-64
 	DefaultVolume  int16 // 0-64
 	DefaultPanning int16 // 0-32, |128 = Enabled
@@ -173,19 +300,43 @@ type Sample struct {
 	// This will be int16 if S16 is set, int8 otherwise
 	// Stereo samples have left,right interleaved
 	Data SampleData
+
+	// For large samples that are read from their file during playback instead of being
+	// loaded, where to read the PCM. Data is empty until LoadStream is called; exporters
+	// read the stream themselves (see Module.WithLoadedStreams).
+	Stream *SampleStream
 }
 
 type SampleData struct {
 	Channels int8
 	Bits     int8
 
-	// This will contain []int8 or []int16 depending on Bits
+	// This will contain []int8 or []int16 depending on Bits, one slice per channel.
 	Data []any
 }
 
 type Pattern struct {
+	Name     string
 	Channels int16
 	Rows     []PatternRow
+
+	// Highlight overrides for this pattern, like OpenMPT's per-pattern time signatures.
+	// 0 = use the module's highlight.
+	RowsPerBeat    int16
+	RowsPerMeasure int16
+}
+
+// The row highlight for a pattern: its own override if it has one, otherwise the module's
+// highlight.
+func (p *Pattern) Highlight(m *Module) (rowsPerBeat int16, rowsPerMeasure int16) {
+	rowsPerBeat, rowsPerMeasure = m.PatternHighlight_Beat, m.PatternHighlight_Measure
+	if p.RowsPerBeat > 0 {
+		rowsPerBeat = p.RowsPerBeat
+	}
+	if p.RowsPerMeasure > 0 {
+		rowsPerMeasure = p.RowsPerMeasure
+	}
+	return
 }
 
 type PatternRow struct {
@@ -205,11 +356,28 @@ const (
 	VcmdVibratoDepth   = 10
 )
 
+// Speeds of the volume column portamento, indexed by the VcmdPortaToNote parameter.
+var VolumeColumnPortaTable = [10]uint8{0, 1, 4, 8, 16, 32, 64, 96, 128, 255}
+
+// Pattern notes that stop or release the playing note instead of starting one. Formats
+// map their own markers onto these when loading:
+//
+//   - IT: 255 is NoteOff and 254 is NoteCut. Every other value from 120 to 253 fades
+//     the note, as Impulse Tracker plays them, and becomes NoteFade.
+//   - S3M and STX: ^^^ (254) is NoteCut. There's no note-off or fade.
+//   - XM: key-off (97) is NoteOff. FastTracker 2 has no cut or fade notes.
+//   - MOD, DSM and AHX have none; notes are only stopped by effects.
+const (
+	NoteFade = 253 // Start the instrument fadeout, keeping envelope sustain.
+	NoteCut  = 254 // Stop the note immediately.
+	NoteOff  = 255 // Release the note: end sustain loops, and fade if the envelope allows.
+)
+
 type PatternEntry struct {
 	// Zero-based index of the channel.
 	Channel uint8
 
-	// 0 = Empty, 1 = C-0, 120 = B-9, 253 = NoteFade, 254 = NoteCut, 255 = NoteOff
+	// 0 = Empty, 1 = C-0, 120 = B-9, or NoteFade, NoteCut, NoteOff
 	Note uint8
 
 	// Set instrument index (0 = empty)
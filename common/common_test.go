@@ -0,0 +1,482 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package common
+
+import (
+	"encoding/json"
+	"math/rand"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPatternHighlight(t *testing.T) {
+	m := &Module{PatternHighlight_Beat: 4, PatternHighlight_Measure: 16}
+
+	beat, measure := (&Pattern{}).Highlight(m)
+	assert.EqualValues(t, 4, beat)
+	assert.EqualValues(t, 16, measure)
+
+	beat, measure = (&Pattern{RowsPerBeat: 3, RowsPerMeasure: 12}).Highlight(m)
+	assert.EqualValues(t, 3, beat)
+	assert.EqualValues(t, 12, measure)
+}
+
+func TestClone(t *testing.T) {
+	m := &Module{
+		Title:           "clone",
+		ChannelSettings: []ChannelSetting{{InitialVolume: 64}},
+		Order:           []int16{0, 255},
+		Instruments: []Instrument{{
+			Envelopes: []Envelope{{Nodes: []EnvelopeNode{{0, 64}, {10, 0}}}},
+			FM:        &FMPatch{Kind: FMMelodic},
+		}},
+		Samples:  []Sample{{Data: SampleData{Channels: 2, Bits: 16, Data: []any{[]int16{1, 2}, []int16{3, 4}}}}},
+		Patterns: []Pattern{{Rows: []PatternRow{{Entries: []PatternEntry{{Note: 61}}}}}},
+		Details:  &ItDetails{Cwtv: 0x214, Quirks: []string{"quirk"}},
+	}
+
+	c := m.Clone()
+	assert.Equal(t, m, c)
+
+	// Nothing in the copy may share memory with the original.
+	c.ChannelSettings[0].InitialVolume = 0
+	c.Order[0] = 1
+	c.Instruments[0].Envelopes[0].Nodes[0].Y = 0
+	c.Instruments[0].FM.Kind = FMHiHat
+	c.Samples[0].Data.Data[1].([]int16)[0] = 0
+	c.Patterns[0].Rows[0].Entries[0].Note = 0
+	c.Details.(*ItDetails).Quirks[0] = ""
+
+	assert.EqualValues(t, 64, m.ChannelSettings[0].InitialVolume)
+	assert.EqualValues(t, 0, m.Order[0])
+	assert.EqualValues(t, 64, m.Instruments[0].Envelopes[0].Nodes[0].Y)
+	assert.EqualValues(t, FMMelodic, m.Instruments[0].FM.Kind)
+	assert.Equal(t, []int16{3, 4}, m.Samples[0].Data.Data[1])
+	assert.EqualValues(t, 61, m.Patterns[0].Rows[0].Entries[0].Note)
+	assert.Equal(t, []string{"quirk"}, m.Details.(*ItDetails).Quirks)
+}
+
+func TestPatternRowSet(t *testing.T) {
+	var row PatternRow
+	row.Set(PatternEntry{Channel: 2, Note: 61})
+	row.Set(PatternEntry{Channel: 0, Effect: 1, EffectParam: 6})
+	assert.Len(t, row.Entries, 2)
+	assert.EqualValues(t, 61, row.Get(2).Note)
+	assert.Equal(t, PatternEntry{Channel: 1}, row.Get(1))
+
+	row.Set(PatternEntry{Channel: 2})
+	assert.Equal(t, []PatternEntry{{Channel: 0, Effect: 1, EffectParam: 6}}, row.Entries)
+}
+
+func TestPatternEqual(t *testing.T) {
+	a := PatternRow{Entries: []PatternEntry{{Channel: 0, Note: 61}, {Channel: 3, Effect: 1, EffectParam: 6}}}
+	b := PatternRow{Entries: []PatternEntry{{Channel: 3, Effect: 1, EffectParam: 6}, {Channel: 1}, {Channel: 0, Note: 61}}}
+	assert.True(t, a.Equal(&b))
+	assert.Equal(t, a.Hash(), b.Hash())
+	assert.True(t, (&PatternRow{}).Equal(&PatternRow{Entries: []PatternEntry{}}))
+	assert.Equal(t, (&PatternRow{}).Hash(), (&PatternRow{Entries: []PatternEntry{{Channel: 5}}}).Hash())
+
+	b.Entries[0].EffectParam = 7
+	assert.False(t, a.Equal(&b))
+	assert.NotEqual(t, a.Hash(), b.Hash())
+	assert.False(t, a.Entries[1].Equal(&b.Entries[0]))
+	assert.NotEqual(t, a.Entries[1].Hash(), b.Entries[0].Hash())
+
+	p1 := Pattern{Channels: 4, Rows: []PatternRow{a, {}}}
+	p2 := Pattern{Channels: 4, Rows: []PatternRow{{Entries: slices.Clone(a.Entries)}, {Entries: []PatternEntry{{Channel: 2}}}}}
+	slices.Reverse(p2.Rows[0].Entries)
+	assert.True(t, p1.Equal(&p2))
+	assert.Equal(t, p1.Hash(), p2.Hash())
+
+	// Rows split differently don't hash the same.
+	p3 := Pattern{Rows: []PatternRow{{}, a}}
+	assert.NotEqual(t, p1.Hash(), p3.Hash())
+	p2.Name = "renamed"
+	assert.False(t, p1.Equal(&p2))
+	assert.Equal(t, p1.Hash(), p2.Hash())
+}
+
+func TestTransaction(t *testing.T) {
+	m := &Module{
+		Order:    []int16{0, 255},
+		Samples:  []Sample{{Name: "a"}},
+		Patterns: []Pattern{{Rows: make([]PatternRow, 4)}},
+	}
+	original := m.Clone()
+
+	tx := m.Begin("edit")
+	assert.NoError(t, tx.SetEntry(0, 1, PatternEntry{Channel: 0, Note: 61}))
+	assert.NoError(t, tx.SetEntry(0, 1, PatternEntry{Channel: 0, Note: 62}))
+	assert.NoError(t, tx.SetSample(0, Sample{Name: "b"}))
+	assert.NoError(t, tx.SetOrder([]int16{0, 0, 255}))
+	assert.ErrorIs(t, tx.SetEntry(0, 4, PatternEntry{}), ErrOutOfRange)
+	assert.NoError(t, tx.Rollback())
+	assert.Equal(t, original, m)
+	assert.ErrorIs(t, tx.SetOrder(nil), ErrTransactionClosed)
+
+	history := NewHistory(m)
+	tx = m.Begin("note")
+	assert.NoError(t, tx.SetEntry(0, 2, PatternEntry{Channel: 1, Note: 50}))
+	assert.NoError(t, tx.SetSample(0, Sample{Name: "b"}))
+	edit, err := tx.Commit()
+	assert.NoError(t, err)
+	history.Push(edit)
+	edited := m.Clone()
+
+	assert.Equal(t, edit, history.Undo())
+	assert.Equal(t, original, m)
+	assert.Nil(t, history.Undo())
+
+	assert.Equal(t, edit, history.Redo())
+	assert.Equal(t, edited, m)
+	assert.Nil(t, history.Redo())
+}
+
+func TestPatternExpandShrink(t *testing.T) {
+	p := Pattern{Rows: []PatternRow{
+		{Entries: []PatternEntry{{Channel: 0, Note: 61, Instrument: 1}}},
+		{Entries: []PatternEntry{{Channel: 1, Note: 62}}},
+		{},
+	}}
+
+	p.Expand(2)
+	assert.Len(t, p.Rows, 6)
+	assert.EqualValues(t, 61, p.Rows[0].Get(0).Note)
+	assert.EqualValues(t, 62, p.Rows[2].Get(1).Note)
+	assert.Empty(t, p.Rows[1].Entries)
+
+	warnings := p.Shrink(2)
+	assert.Empty(t, warnings)
+	assert.Equal(t, []PatternRow{
+		{Entries: []PatternEntry{{Channel: 0, Note: 61, Instrument: 1}}},
+		{Entries: []PatternEntry{{Channel: 1, Note: 62}}},
+		{},
+	}, p.Rows)
+
+	// Fields from the removed rows are merged where there's room.
+	p = Pattern{Rows: []PatternRow{
+		{Entries: []PatternEntry{{Channel: 0, Note: 61, Effect: 4, EffectParam: 1}}},
+		{Entries: []PatternEntry{{Channel: 0, VolumeCommand: VcmdSetVolume, VolumeParam: 32, Effect: 8, EffectParam: 0x44}}},
+		{Entries: []PatternEntry{{Channel: 2, Note: 50}}},
+	}}
+	warnings = p.Shrink(2)
+	assert.Equal(t, []string{"row 1 channel 1: effect dropped"}, warnings)
+	assert.Equal(t, []PatternRow{
+		{Entries: []PatternEntry{{Channel: 0, Note: 61, VolumeCommand: VcmdSetVolume, VolumeParam: 32, Effect: 4, EffectParam: 1}}},
+		{Entries: []PatternEntry{{Channel: 2, Note: 50}}},
+	}, p.Rows)
+}
+
+func TestPatternOps(t *testing.T) {
+	m := &Module{Patterns: []Pattern{{Rows: make([]PatternRow, 4)}}}
+	original := m.Clone()
+
+	ops := []PatternOp{
+		SetCell(0, 1, PatternEntry{Channel: 2, Note: 61}),
+		InsertRows(0, 0, 2),
+		SetCell(0, 3, PatternEntry{Channel: 2, Note: 62}),
+		DeleteRows(0, 4, 2),
+		ClearCell(0, 3, 2),
+	}
+	for i := range ops {
+		assert.NoError(t, ops[i].Apply(m))
+	}
+	assert.Len(t, m.Patterns[0].Rows, 4)
+	assert.Equal(t, PatternEntry{Channel: 2, Note: 61}, ops[2].Old)
+	assert.Equal(t, original, m)
+
+	// Operations survive serialization.
+	data, err := json.Marshal(ops)
+	assert.NoError(t, err)
+	var decoded []PatternOp
+	assert.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, ops, decoded)
+
+	for i := len(ops) - 1; i >= 0; i-- {
+		inverse := ops[i].Invert()
+		assert.NoError(t, inverse.Apply(m))
+	}
+	assert.Equal(t, original.Patterns[0].Rows, m.Patterns[0].Rows)
+
+	merged := MergeOps([]PatternOp{
+		{Kind: OpSetCell, Row: 1, Entry: PatternEntry{Note: 1}},
+		{Kind: OpSetCell, Row: 1, Entry: PatternEntry{Note: 2}, Old: PatternEntry{Note: 1}},
+		{Kind: OpSetCell, Row: 2, Entry: PatternEntry{Note: 3}},
+		{Kind: OpSetCell, Row: 2, Entry: PatternEntry{}, Old: PatternEntry{Note: 3}},
+	})
+	assert.Equal(t, []PatternOp{{Kind: OpSetCell, Row: 1, Entry: PatternEntry{Note: 2}}}, merged)
+
+	bad := SetCell(0, 4, PatternEntry{})
+	assert.ErrorIs(t, bad.Apply(m), ErrOutOfRange)
+}
+
+// Concurrent operations converge no matter which side applies first.
+func TestPatternOpRebase(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	randomOp := func(rows int) PatternOp {
+		row := rng.Intn(rows)
+		switch rng.Intn(3) {
+		case 0:
+			return SetCell(0, row, PatternEntry{Channel: uint8(rng.Intn(2)), Note: uint8(1 + rng.Intn(3))})
+		case 1:
+			return InsertRows(0, rng.Intn(rows+1), 1+rng.Intn(2))
+		}
+		return DeleteRows(0, row, 1+rng.Intn(rows-row))
+	}
+	normalize := func(m *Module) []PatternRow {
+		rows := m.Patterns[0].Rows
+		for i := range rows {
+			slices.SortFunc(rows[i].Entries, func(a, b PatternEntry) int { return int(a.Channel) - int(b.Channel) })
+		}
+		return rows
+	}
+
+	for range 2000 {
+		base := &Module{Patterns: []Pattern{{Rows: make([]PatternRow, 6)}}}
+		for row := range 6 {
+			base.Patterns[0].Rows[row].Set(PatternEntry{Channel: 0, Note: uint8(100 + row)})
+		}
+		a, b := randomOp(6), randomOp(6)
+
+		apply := func(first, second PatternOp) *Module {
+			m := base.Clone()
+			assert.NoError(t, first.Apply(m))
+			if rebased, ok := second.Rebase(first); ok {
+				assert.NoError(t, rebased.Apply(m), "%+v after %+v", second, first)
+			}
+			return m
+		}
+		assert.Equal(t, normalize(apply(a, b)), normalize(apply(b, a)), "%+v, %+v", a, b)
+	}
+}
+
+func TestFreeze(t *testing.T) {
+	m := &Module{
+		Title:    "freeze",
+		Order:    []int16{0},
+		Samples:  []Sample{{Loop: true, LoopStart: 2, LoopEnd: 4, C5: 1000, Data: SampleData{Channels: 1, Bits: 8, Data: []any{[]int8{0, 10, 20, 30}}}}},
+		Patterns: []Pattern{{Rows: []PatternRow{{Entries: []PatternEntry{{Note: 61}}}}}},
+	}
+
+	s := m.Freeze()
+	assert.Equal(t, m, s.Module())
+
+	// The PCM is shared, everything else is copied.
+	assert.Same(t, &m.Samples[0].Data.Data[0].([]int8)[0], &s.Module().Samples[0].Data.Data[0].([]int8)[0])
+	m.Title = ""
+	m.Order[0] = 1
+	m.Patterns[0].Rows[0].Entries[0].Note = 62
+	assert.NoError(t, m.Samples[0].CrossfadeLoop(2))
+
+	assert.Equal(t, "freeze", s.Title())
+	assert.Equal(t, []int16{0}, s.Order())
+	assert.EqualValues(t, 61, s.Entry(0, 0, 0).Note)
+	assert.Equal(t, []int8{0, 10, 20, 30}, s.Sample(0).Data.Data[0])
+	assert.NotEqual(t, []int8{0, 10, 20, 30}, m.Samples[0].Data.Data[0])
+
+	thawed := s.Thaw()
+	thawed.Title = "thawed"
+	assert.Equal(t, "freeze", s.Title())
+}
+
+func TestCanonicalize(t *testing.T) {
+	// The same song, as an S3M loader with padded 64-row patterns and an IT loader might
+	// produce it.
+	s3m := &Module{
+		Title: "song\x00\x00", Message: "line 1\r\nline 2\r",
+		Order:    []int16{0},
+		Samples:  []Sample{{Name: "kick   ", LoopStart: 10, LoopEnd: 20, C5: 8363}},
+		Patterns: []Pattern{{Rows: make([]PatternRow, 64)}},
+	}
+	s3m.Patterns[0].Rows[0].Entries = []PatternEntry{{Channel: 2, Effect: 1, EffectParam: 6}, {Channel: 0, Note: 61, VolumeParam: 10}}
+	s3m.Patterns[0].Rows[31].Entries = []PatternEntry{{Channel: 1}, {Channel: 0, Effect: 3}}
+
+	it := &Module{
+		Title: "song", Message: "line 1\nline 2",
+		Order:    []int16{0, 255, 255},
+		Samples:  []Sample{{Name: "kick", C5: 8363}},
+		Patterns: []Pattern{{Rows: make([]PatternRow, 32)}},
+	}
+	it.Patterns[0].Rows[0].Entries = []PatternEntry{{Channel: 0, Note: 61}, {Channel: 2, Effect: 1, EffectParam: 6}}
+	it.Patterns[0].Rows[31].Entries = []PatternEntry{{Channel: 0, Effect: 3}}
+
+	Canonicalize(s3m)
+	Canonicalize(it)
+	assert.Equal(t, it, s3m)
+	assert.Equal(t, []int16{0, 255}, it.Order)
+	assert.Len(t, it.Patterns[0].Rows, 32)
+	assert.Nil(t, it.Patterns[0].Rows[1].Entries)
+
+	// Rows past the break are kept when a Cxx can land on them.
+	s3m.Patterns = append(s3m.Patterns, Pattern{Rows: make([]PatternRow, 64)})
+	s3m.Patterns[1].Rows[0].Entries = []PatternEntry{{Effect: 3, EffectParam: 40}}
+	Canonicalize(s3m)
+	assert.Len(t, s3m.Patterns[1].Rows, 64)
+}
+
+func TestArena(t *testing.T) {
+	var arena Arena
+	row := []PatternEntry{{Channel: 0, Note: 61}, {Channel: 1, Effect: 1}}
+	a := arena.Entries(row)
+	b := arena.Entries(row[:1])
+	assert.Equal(t, row, a)
+	assert.Equal(t, 2, cap(a))
+	assert.Nil(t, arena.Entries(nil))
+
+	// Appending to a row doesn't overwrite the next one.
+	_ = append(a, PatternEntry{Channel: 2})
+	assert.Equal(t, row[:1], b)
+
+	// Rows that don't fit in the current block start a new one.
+	big := arena.Entries(make([]PatternEntry, arenaBlockSize-2))
+	assert.Len(t, big, arenaBlockSize-2)
+	assert.Len(t, arena.blocks, 2)
+
+	arena.Reset()
+	c := arena.Entries([]PatternEntry{{Channel: 5}})
+	assert.Same(t, &a[0], &c[0])
+	assert.Len(t, arena.blocks, 2)
+
+	var none *Arena
+	assert.Equal(t, row, none.Entries(row))
+}
+
+func TestTags(t *testing.T) {
+	m := &Module{
+		Title:       "  Song  ",
+		Message:     "My song\r\r(c) 1996 by Some One, all rights reserved\r",
+		Samples:     []Sample{{Name: "kick"}, {Name: " "}, {Name: "snare"}},
+		Instruments: []Instrument{{Name: "drums"}},
+		Details:     &ItDetails{Tracker: "Impulse Tracker 2.14"},
+	}
+	assert.Equal(t, map[string]string{
+		TagTitle:       "Song",
+		TagArtist:      "Some One",
+		TagComment:     "My song\n\n(c) 1996 by Some One, all rights reserved",
+		TagDescription: "drums\nkick\nsnare",
+		TagEncoder:     "Impulse Tracker 2.14",
+	}, m.Tags())
+
+	// Without a message, credits are found in the sample names.
+	m.Message = ""
+	m.Samples[1].Name = "music by DJ Foo in 1997"
+	m.Details = &S3mDetails{Tracker: "Unknown"}
+	tags := m.Tags()
+	assert.Equal(t, "DJ Foo", tags[TagArtist])
+	assert.NotContains(t, tags, TagComment)
+	assert.NotContains(t, tags, TagEncoder)
+
+	assert.Empty(t, (&Module{}).Tags())
+}
+
+func TestGraft(t *testing.T) {
+	m := &Module{
+		Channels:        2,
+		ChannelSettings: make([]ChannelSetting, 2),
+		Patterns:        []Pattern{{Rows: make([]PatternRow, 4)}},
+		Samples:         []Sample{{Name: "kick"}},
+	}
+	template := Pattern{Rows: make([]PatternRow, 2)}
+	template.Rows[1].Set(PatternEntry{Channel: 3, Note: 60, Instrument: 1})
+	assert.Equal(t, 1, m.GraftPatterns([]Pattern{template}))
+	assert.Equal(t, template, m.Patterns[1])
+	assert.EqualValues(t, 4, m.Channels)
+	assert.Equal(t, ChannelSetting{InitialVolume: 64, InitialPan: 32}, m.ChannelSettings[3])
+
+	// The grafted pattern is a copy.
+	m.Patterns[1].Rows[1].Entries[0].Note = 61
+	assert.EqualValues(t, 60, template.Rows[1].Entries[0].Note)
+
+	ins := Instrument{Name: "lead"}
+	ins.Notemap[60] = NotemapEntry{Note: 60, Sample: 2}
+	firstInstrument, firstSample := m.GraftSamples([]Instrument{ins}, []Sample{{Name: "a"}, {Name: "b"}})
+	assert.Equal(t, 1, firstInstrument)
+	assert.Equal(t, 2, firstSample)
+	assert.EqualValues(t, 3, m.Instruments[0].Notemap[60].Sample)
+	assert.EqualValues(t, 0, m.Instruments[0].Notemap[59].Sample)
+	assert.Equal(t, "b", m.Samples[2].Name)
+	assert.EqualValues(t, 2, ins.Notemap[60].Sample)
+}
+
+func TestTemplate(t *testing.T) {
+	kit := &Module{
+		Title:           "{{title}}",
+		Message:         "by {{ artist }}\n{{unused}}",
+		Channels:        2,
+		ChannelSettings: []ChannelSetting{{Name: "drums"}, {Name: "bass"}},
+		Samples:         []Sample{{Name: "{{artist}}'s kick", Data: SampleData{Channels: 1, Bits: 8, Data: []any{[]int8{1, 2}}}}},
+	}
+	tmpl := Template{Module: kit, Rows: 32}
+	assert.Equal(t, []string{"artist", "title", "unused"}, tmpl.Placeholders())
+
+	m := tmpl.New(map[string]string{"title": "Song", "artist": "Someone"})
+	assert.Equal(t, "Song", m.Title)
+	assert.Equal(t, "by Someone\n{{unused}}", m.Message)
+	assert.Equal(t, "Someone's kick", m.Samples[0].Name)
+	assert.Equal(t, []int16{0}, m.Order)
+	assert.Len(t, m.Patterns, 1)
+	assert.Len(t, m.Patterns[0].Rows, 32)
+	assert.EqualValues(t, 2, m.Patterns[0].Channels)
+
+	// The template isn't changed, and PCM is shared.
+	assert.Equal(t, "{{title}}", kit.Title)
+	assert.Empty(t, kit.Patterns)
+	m.ChannelSettings[0].Name = "lead"
+	assert.Equal(t, "drums", kit.ChannelSettings[0].Name)
+	assert.Same(t, &kit.Samples[0].Data.Data[0].([]int8)[0], &m.Samples[0].Data.Data[0].([]int8)[0])
+}
+
+func TestDosFilenames(t *testing.T) {
+	assert.True(t, ValidDosFilename("KICK.WAV"))
+	assert.True(t, ValidDosFilename("snare_01"))
+	assert.False(t, ValidDosFilename("longfilename.wav"))
+	assert.False(t, ValidDosFilename("kick.wave"))
+	assert.False(t, ValidDosFilename("a.b.c"))
+	assert.False(t, ValidDosFilename("kick drm.wav"))
+	assert.False(t, ValidDosFilename("caf\x82.wav"))
+	assert.False(t, ValidDosFilename(".wav"))
+	assert.False(t, ValidDosFilename("kick."))
+	for _, name := range []string{"CON", "prn.wav", "Aux.raw", "NUL", "COM1", "com9.iti", "LPT1.S3M", "lpt9"} {
+		assert.False(t, ValidDosFilename(name), name)
+	}
+	assert.True(t, ValidDosFilename("COM0"))
+	assert.True(t, ValidDosFilename("COM10"))
+	assert.True(t, ValidDosFilename("CONSOLE.WAV"))
+
+	assert.Equal(t, "kick.wav", NormalizeDosFilename("kick.wav\x00\x00"))
+	assert.Equal(t, "Bass_Dru.wav", NormalizeDosFilename(`C:\samples\Bass Drum 2.wav`))
+	assert.Equal(t, "my_song_.s3m", NormalizeDosFilename("my.song.v2.s3m"))
+	assert.Equal(t, "caf_.aif", NormalizeDosFilename("café.aiff"))
+	assert.Equal(t, "hidden", NormalizeDosFilename(".hidden"))
+	assert.Equal(t, "", NormalizeDosFilename("  "))
+	assert.Equal(t, "CON_", NormalizeDosFilename("CON"))
+	assert.Equal(t, "nul_.wav", NormalizeDosFilename("nul.wav"))
+	assert.Equal(t, "Com1_.raw", NormalizeDosFilename(`samples/Com1.raw`))
+	assert.Equal(t, "lpt9_.its", NormalizeDosFilename("lpt9.its"))
+	assert.Equal(t, "console.wav", NormalizeDosFilename("console.wav"))
+	for _, name := range []string{"kick.wav", "Bass Drum 2.wav", "x", "über.raw", "AUX", "prn.txt"} {
+		assert.True(t, ValidDosFilename(NormalizeDosFilename(name)), name)
+	}
+
+	m := &Module{
+		Instruments: []Instrument{{DosFilename: "lead.iti"}},
+		Samples: []Sample{
+			{DosFilename: "LEAD.ITI"},
+			{DosFilename: "drumloop1.wav"},
+			{DosFilename: "drumloop2.wav"},
+			{},
+			{DosFilename: "con.wav"},
+		},
+	}
+	m.NormalizeDosFilenames()
+	assert.Equal(t, "lead.iti", m.Instruments[0].DosFilename)
+	assert.Equal(t, "LEAD~1.ITI", m.Samples[0].DosFilename)
+	assert.Equal(t, "drumloop.wav", m.Samples[1].DosFilename)
+	assert.Equal(t, "drumlo~1.wav", m.Samples[2].DosFilename)
+	assert.Equal(t, "", m.Samples[3].DosFilename)
+	assert.Equal(t, "con_.wav", m.Samples[4].DosFilename)
+}
@@ -0,0 +1,60 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package common
+
+// Format-specific header fields that have no place in the common model, so writers can
+// restore them and tools can inspect them. Use a type switch to get the concrete type.
+type SourceDetails interface {
+	SourceFormat() ModuleSourceFormat
+}
+
+// Original header fields of an IT file.
+type ItDetails struct {
+	Cwtv    uint16 // Created with tracker version
+	Cmwt    uint16 // Compatible with tracker version
+	Flags   uint16
+	Special uint16
+
+	// Name of the tracker that wrote the file, identified from cwtv and cmwt.
+	Tracker string
+
+	// Load fixups that were applied for the tracker that wrote the file.
+	Quirks []string
+
+	// Problems found in the data while loading.
+	Warnings []string
+
+	// MIDI macros embedded in the file, nil if it uses the default configuration.
+	Macros *MidiMacros
+}
+
+// MIDI macro configuration for Zxx effects. Macros are strings of hex digits and
+// variables, using the IT conventions, e.g. "F0F000z" sets the filter cutoff to the Zxx
+// parameter.
+type MidiMacros struct {
+	Parametric [16]string  // Selected with SF0-SFF, used by Z00-Z7F.
+	Fixed      [128]string // Z80-ZFF
+}
+
+func (*ItDetails) SourceFormat() ModuleSourceFormat {
+	return ItSource
+}
+
+// Original header fields of an S3M file.
+type S3mDetails struct {
+	Cwtv       uint16 // Created with tracker version
+	Ffi        uint16 // Sample format, 1 = signed, 2 = unsigned
+	Flags      uint16
+	Special    uint16
+	UltraClick uint8
+	DefaultPan uint8
+
+	// Name of the tracker that wrote the file, identified from cwtv.
+	Tracker string
+}
+
+func (*S3mDetails) SourceFormat() ModuleSourceFormat {
+	return S3mSource
+}
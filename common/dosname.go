@@ -0,0 +1,123 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package common
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Punctuation allowed in DOS filenames, besides letters and digits.
+const dosPunctuation = "!#$%&'()-@^_`{}~"
+
+// True if base (a name without its extension) is a DOS device, like CON or LPT1. Files
+// can't have these names, with any extension.
+func reservedDosName(base string) bool {
+	switch base = strings.ToUpper(base); base {
+	case "CON", "PRN", "AUX", "NUL":
+		return true
+	}
+	return len(base) == 4 && (strings.HasPrefix(base, "COM") || strings.HasPrefix(base, "LPT")) &&
+		base[3] >= '1' && base[3] <= '9'
+}
+
+func validDosChar(c byte) bool {
+	return c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' ||
+		strings.IndexByte(dosPunctuation, c) >= 0
+}
+
+// True if name is a valid DOS 8.3 filename: one to eight characters, optionally followed
+// by a dot and one to three more, using only characters that DOS allows. Text in other
+// code pages isn't allowed, since it depends on the system that reads it. Device names
+// like CON and LPT1 aren't allowed either.
+func ValidDosFilename(name string) bool {
+	base, ext, hasExt := strings.Cut(name, ".")
+	if len(base) < 1 || len(base) > 8 || hasExt && (len(ext) < 1 || len(ext) > 3) {
+		return false
+	}
+	if reservedDosName(base) {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if i != len(base) && !validDosChar(name[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Make a valid DOS 8.3 filename from any text, e.g. a path or a long file name. Directories
+// and padding are removed, invalid characters become '_', and the name and extension are
+// shortened to 8 and 3 characters. Device names like CON get a '_' added. Case is kept.
+// Returns "" if nothing is left.
+func NormalizeDosFilename(name string) string {
+	name = trimPadding(name)
+	if i := strings.LastIndexAny(name, `/\:`); i >= 0 {
+		name = name[i+1:]
+	}
+	name = strings.TrimSpace(name)
+
+	base, ext := name, ""
+	if i := strings.LastIndexByte(name, '.'); i > 0 {
+		base, ext = name[:i], name[i+1:]
+	}
+	clean := func(s string, limit int) string {
+		var b strings.Builder
+		for _, r := range s {
+			if b.Len() == limit {
+				break
+			}
+			if r < 128 && validDosChar(byte(r)) {
+				b.WriteRune(r)
+			} else {
+				b.WriteByte('_')
+			}
+		}
+		return b.String()
+	}
+
+	base = clean(strings.TrimLeft(base, "."), 8)
+	ext = clean(ext, 3)
+	if reservedDosName(base) {
+		base += "_"
+	}
+	switch {
+	case base == "":
+		return ""
+	case ext == "":
+		return base
+	}
+	return base + "." + ext
+}
+
+// Normalize the DosFilename of every instrument and sample (see NormalizeDosFilename), and
+// make them unique within the module, ignoring case, so they can be exported to one
+// directory. Repeated names get a "~N" suffix, like Windows short names. Empty names are
+// left empty.
+func (m *Module) NormalizeDosFilenames() {
+	used := make(map[string]bool)
+	unique := func(name *string) {
+		*name = NormalizeDosFilename(*name)
+		if *name == "" {
+			return
+		}
+		base, ext, _ := strings.Cut(*name, ".")
+		if ext != "" {
+			ext = "." + ext
+		}
+		for n := 1; used[strings.ToUpper(*name)]; n++ {
+			suffix := fmt.Sprintf("~%d", n)
+			*name = base[:min(len(base), 8-len(suffix))] + suffix + ext
+		}
+		used[strings.ToUpper(*name)] = true
+	}
+
+	for i := range m.Instruments {
+		unique(&m.Instruments[i].DosFilename)
+	}
+	for i := range m.Samples {
+		unique(&m.Samples[i].DosFilename)
+	}
+}
@@ -0,0 +1,47 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package common
+
+// Append copies of patterns, e.g. from a pattern template, and return the index of the
+// first one. The module's channel count grows to fit entries in higher channels. The
+// order list isn't changed, and instrument numbers in the patterns are kept as they are.
+func (m *Module) GraftPatterns(patterns []Pattern) int {
+	first := len(m.Patterns)
+	for i := range patterns {
+		p := patterns[i].Clone()
+		for _, row := range p.Rows {
+			for _, e := range row.Entries {
+				m.Channels = max(m.Channels, int16(e.Channel)+1)
+			}
+		}
+		m.Patterns = append(m.Patterns, p)
+	}
+	for len(m.ChannelSettings) > 0 && len(m.ChannelSettings) < int(m.Channels) {
+		m.ChannelSettings = append(m.ChannelSettings, ChannelSetting{InitialVolume: 64, InitialPan: 32})
+	}
+	return first
+}
+
+// Append copies of instruments and samples from a sample bank. Instrument notemaps
+// number samples within the bank, and are renumbered to the slots the samples end up in.
+// Returns the 1-based numbers of the first added instrument and sample. Sample data is
+// shared with the bank, since PCM isn't written once it's in use.
+func (m *Module) GraftSamples(instruments []Instrument, samples []Sample) (firstInstrument, firstSample int) {
+	firstInstrument = len(m.Instruments) + 1
+	firstSample = len(m.Samples) + 1
+	offset := int16(len(m.Samples))
+
+	for i := range instruments {
+		ins := instruments[i].Clone()
+		for key := range ins.Notemap {
+			if ins.Notemap[key].Sample > 0 {
+				ins.Notemap[key].Sample += offset
+			}
+		}
+		m.Instruments = append(m.Instruments, ins)
+	}
+	m.Samples = append(m.Samples, samples...)
+	return firstInstrument, firstSample
+}
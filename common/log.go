@@ -0,0 +1,19 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package common
+
+import (
+	"context"
+	"log/slog"
+)
+
+// Log a debug-level trace if the logger isn't nil. Loaders and the renderer trace file
+// offsets, chunk sizes and the decisions they make, so a file that loads or plays wrong
+// can be debugged without a custom build.
+func Debug(logger *slog.Logger, msg string, args ...any) {
+	if logger != nil && logger.Enabled(context.Background(), slog.LevelDebug) {
+		logger.Debug(msg, args...)
+	}
+}
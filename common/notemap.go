@@ -0,0 +1,32 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package common
+
+// A range of keys in a notemap that play the same sample with the same transposition.
+type KeyZone struct {
+	Low, High int // Keys, 0-119
+	Sample    int // 1-based sample number
+	Transpose int // Semitones added to the key
+}
+
+// Group the notemap into contiguous key zones. Keys without a sample are skipped.
+func (ins *Instrument) KeyZones() []KeyZone {
+	var zones []KeyZone
+	for key, entry := range ins.Notemap {
+		if entry.Sample <= 0 {
+			continue
+		}
+		transpose := int(entry.Note) - key
+		if n := len(zones); n > 0 {
+			last := &zones[n-1]
+			if last.High == key-1 && last.Sample == int(entry.Sample) && last.Transpose == transpose {
+				last.High = key
+				continue
+			}
+		}
+		zones = append(zones, KeyZone{Low: key, High: key, Sample: int(entry.Sample), Transpose: transpose})
+	}
+	return zones
+}
@@ -0,0 +1,196 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package common
+
+import (
+	"cmp"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"slices"
+)
+
+// True if the entry has no note, instrument, volume command, or effect.
+func (e *PatternEntry) IsEmpty() bool {
+	return e.Note == 0 && e.Instrument == 0 && e.VolumeCommand == 0 && e.Effect == 0 &&
+		e.VolumeParam == 0 && e.EffectParam == 0
+}
+
+// True if the entries have the same channel and fields.
+func (e *PatternEntry) Equal(other *PatternEntry) bool {
+	return *e == *other
+}
+
+// Hash of the entry's channel and fields. Equal entries have the same hash.
+func (e *PatternEntry) Hash() uint64 {
+	h := fnv.New64a()
+	e.hashTo(h)
+	return h.Sum64()
+}
+
+func (e *PatternEntry) hashTo(h io.Writer) {
+	buf := [8]byte{e.Channel, e.Note, 0, 0, e.VolumeCommand, e.VolumeParam, e.Effect, e.EffectParam}
+	binary.LittleEndian.PutUint16(buf[2:], uint16(e.Instrument))
+	h.Write(buf[:])
+}
+
+// The row's entries as Get sees them: empty entries and repeated channels dropped,
+// sorted by channel.
+func (r *PatternRow) normalized() []PatternEntry {
+	entries := make([]PatternEntry, 0, len(r.Entries))
+	for _, e := range r.Entries {
+		if e.IsEmpty() || slices.ContainsFunc(entries, func(x PatternEntry) bool { return x.Channel == e.Channel }) {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	slices.SortFunc(entries, func(a, b PatternEntry) int { return cmp.Compare(a.Channel, b.Channel) })
+	return entries
+}
+
+// True if Get returns the same entry for every channel of both rows. The order of the
+// entries and empty entries don't matter.
+func (r *PatternRow) Equal(other *PatternRow) bool {
+	return slices.Equal(r.normalized(), other.normalized())
+}
+
+// Hash of the row's content. Equal rows have the same hash.
+func (r *PatternRow) Hash() uint64 {
+	h := fnv.New64a()
+	r.hashTo(h)
+	return h.Sum64()
+}
+
+func (r *PatternRow) hashTo(h io.Writer) {
+	for _, e := range r.normalized() {
+		e.hashTo(h)
+	}
+	h.Write([]byte{0xFF}) // Channels are below 0xFF, so this ends the row.
+}
+
+// True if the patterns have the same name, channels, highlight and rows, compared with
+// PatternRow.Equal.
+func (p *Pattern) Equal(other *Pattern) bool {
+	if p.Name != other.Name || p.Channels != other.Channels || len(p.Rows) != len(other.Rows) ||
+		p.RowsPerBeat != other.RowsPerBeat || p.RowsPerMeasure != other.RowsPerMeasure {
+		return false
+	}
+	for i := range p.Rows {
+		if !p.Rows[i].Equal(&other.Rows[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Hash of the pattern's rows, for finding duplicate pattern data. The name, channel count
+// and highlight aren't included, so patterns with equal hashes may not be Equal.
+func (p *Pattern) Hash() uint64 {
+	h := fnv.New64a()
+	for i := range p.Rows {
+		p.Rows[i].hashTo(h)
+	}
+	return h.Sum64()
+}
+
+// The entry for a channel, or an empty entry if the row doesn't have one.
+func (r *PatternRow) Get(channel int) PatternEntry {
+	for _, e := range r.Entries {
+		if int(e.Channel) == channel {
+			return e
+		}
+	}
+	return PatternEntry{Channel: uint8(channel)}
+}
+
+// Replace the entry for entry.Channel. Empty entries are removed from the row.
+func (r *PatternRow) Set(entry PatternEntry) {
+	for i, e := range r.Entries {
+		if e.Channel == entry.Channel {
+			if entry.IsEmpty() {
+				r.Entries = append(r.Entries[:i:i], r.Entries[i+1:]...)
+				if len(r.Entries) == 0 {
+					r.Entries = nil
+				}
+			} else {
+				r.Entries[i] = entry
+			}
+			return
+		}
+	}
+	if !entry.IsEmpty() {
+		r.Entries = append(r.Entries, entry)
+	}
+}
+
+// Spread the rows out so each row is followed by factor-1 empty rows, like OpenMPT's
+// "Expand Pattern". The pattern grows to factor times its length.
+func (p *Pattern) Expand(factor int) {
+	if factor <= 1 {
+		return
+	}
+	rows := make([]PatternRow, len(p.Rows)*factor)
+	for i, row := range p.Rows {
+		rows[i*factor] = row
+	}
+	p.Rows = rows
+}
+
+// Keep every factor'th row, like OpenMPT's "Shrink Pattern". The pattern shrinks to
+// 1/factor of its length, rounded up. Fields of cells in the removed rows are moved into
+// the kept row when its field is empty; otherwise they are dropped and a warning is
+// returned for each.
+func (p *Pattern) Shrink(factor int) []string {
+	if factor <= 1 {
+		return nil
+	}
+
+	var warnings []string
+	rows := make([]PatternRow, (len(p.Rows)+factor-1)/factor)
+	for i := range rows {
+		rows[i] = PatternRow{Entries: slices.Clone(p.Rows[i*factor].Entries)}
+		for src := i*factor + 1; src < min((i+1)*factor, len(p.Rows)); src++ {
+			for _, e := range p.Rows[src].Entries {
+				dest := rows[i].Get(int(e.Channel))
+				dropped := mergeEntry(&dest, e)
+				rows[i].Set(dest)
+				for _, field := range dropped {
+					warnings = append(warnings, fmt.Sprintf("row %d channel %d: %s dropped", src, e.Channel+1, field))
+				}
+			}
+		}
+	}
+	p.Rows = rows
+	return warnings
+}
+
+// Copy the fields of src into the empty fields of dest. Returns the names of fields that
+// didn't fit.
+func mergeEntry(dest *PatternEntry, src PatternEntry) []string {
+	var dropped []string
+	if src.Note != 0 || src.Instrument != 0 {
+		if dest.Note == 0 && dest.Instrument == 0 {
+			dest.Note, dest.Instrument = src.Note, src.Instrument
+		} else {
+			dropped = append(dropped, "note")
+		}
+	}
+	if src.VolumeCommand != 0 {
+		if dest.VolumeCommand == 0 {
+			dest.VolumeCommand, dest.VolumeParam = src.VolumeCommand, src.VolumeParam
+		} else {
+			dropped = append(dropped, "volume")
+		}
+	}
+	if src.Effect != 0 || src.EffectParam != 0 {
+		if dest.Effect == 0 && dest.EffectParam == 0 {
+			dest.Effect, dest.EffectParam = src.Effect, src.EffectParam
+		} else {
+			dropped = append(dropped, "effect")
+		}
+	}
+	return dropped
+}
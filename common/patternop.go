@@ -0,0 +1,199 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package common
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Kinds of pattern operations.
+type PatternOpKind string
+
+const (
+	OpSetCell    PatternOpKind = "set"    // Replace the entry in a cell. An empty entry clears it.
+	OpInsertRows PatternOpKind = "insert" // Insert empty rows, growing the pattern.
+	OpDeleteRows PatternOpKind = "delete" // Remove rows, shrinking the pattern.
+)
+
+// A single edit to pattern data, for syncing editors by exchanging small operations
+// instead of whole patterns. Operations are plain data and can be serialized with
+// encoding/json or similar. Apply fills in the previous state (Old, Removed), which makes
+// the operation invertible.
+type PatternOp struct {
+	Kind    PatternOpKind
+	Pattern int
+	Row     int
+
+	// OpSetCell: the new entry, which also selects the channel, and the entry it replaced.
+	Entry PatternEntry
+	Old   PatternEntry
+
+	// OpInsertRows, OpDeleteRows: the number of rows, and the rows that were deleted.
+	Count   int
+	Removed []PatternRow
+}
+
+// Set a cell. The channel is entry.Channel.
+func SetCell(pattern, row int, entry PatternEntry) PatternOp {
+	return PatternOp{Kind: OpSetCell, Pattern: pattern, Row: row, Entry: entry}
+}
+
+// Clear a cell.
+func ClearCell(pattern, row, channel int) PatternOp {
+	return SetCell(pattern, row, PatternEntry{Channel: uint8(channel)})
+}
+
+// Insert empty rows before a row. row can be the pattern length to append.
+func InsertRows(pattern, row, count int) PatternOp {
+	return PatternOp{Kind: OpInsertRows, Pattern: pattern, Row: row, Count: count}
+}
+
+// Delete rows starting at a row.
+func DeleteRows(pattern, row, count int) PatternOp {
+	return PatternOp{Kind: OpDeleteRows, Pattern: pattern, Row: row, Count: count}
+}
+
+// Apply the operation to a module, recording what it replaced.
+func (op *PatternOp) Apply(m *Module) error {
+	if op.Pattern < 0 || op.Pattern >= len(m.Patterns) {
+		return fmt.Errorf("%w: pattern %d", ErrOutOfRange, op.Pattern)
+	}
+	p := &m.Patterns[op.Pattern]
+
+	switch op.Kind {
+	case OpSetCell:
+		if op.Row < 0 || op.Row >= len(p.Rows) {
+			return fmt.Errorf("%w: row %d", ErrOutOfRange, op.Row)
+		}
+		op.Old = p.Rows[op.Row].Get(int(op.Entry.Channel))
+		p.Rows[op.Row].Set(op.Entry)
+	case OpInsertRows:
+		if op.Row < 0 || op.Row > len(p.Rows) || op.Count < 0 {
+			return fmt.Errorf("%w: row %d", ErrOutOfRange, op.Row)
+		}
+		inserted := op.Removed
+		if len(inserted) != op.Count {
+			inserted = make([]PatternRow, op.Count)
+		}
+		p.Rows = slices.Insert(p.Rows, op.Row, inserted...)
+		op.Removed = nil
+	case OpDeleteRows:
+		if op.Row < 0 || op.Count < 0 || op.Row+op.Count > len(p.Rows) {
+			return fmt.Errorf("%w: rows %d-%d", ErrOutOfRange, op.Row, op.Row+op.Count-1)
+		}
+		op.Removed = slices.Clone(p.Rows[op.Row : op.Row+op.Count])
+		p.Rows = slices.Delete(p.Rows, op.Row, op.Row+op.Count)
+	default:
+		return fmt.Errorf("unknown pattern operation %q", op.Kind)
+	}
+	return nil
+}
+
+// The operation that undoes this one. The operation must have been applied so that the
+// previous state is known.
+func (op PatternOp) Invert() PatternOp {
+	switch op.Kind {
+	case OpSetCell:
+		op.Entry, op.Old = op.Old, op.Entry
+	case OpInsertRows:
+		op.Kind = OpDeleteRows
+	case OpDeleteRows:
+		// Inserting the removed rows puts them back.
+		op.Kind = OpInsertRows
+	}
+	return op
+}
+
+// Adjust an operation that was made concurrently with `applied`, so it can be applied
+// after it. This is the transform step of operational transformation: two peers that
+// apply each other's operations after rebasing them end up with the same pattern. Row
+// positions shift around inserted and deleted rows. Returns false if the operation no
+// longer applies, e.g. a cell in a deleted row, or a set of the same cell that lost to the
+// other set. Rows inserted inside of a range that the other peer deleted are deleted too.
+func (op PatternOp) Rebase(applied PatternOp) (PatternOp, bool) {
+	if op.Pattern != applied.Pattern {
+		return op, true
+	}
+
+	switch applied.Kind {
+	case OpSetCell:
+		if op.Kind == OpSetCell && op.Row == applied.Row && op.Entry.Channel == applied.Entry.Channel {
+			if entryLess(op.Entry, applied.Entry) {
+				return op, false
+			}
+			op.Old = applied.Entry
+		}
+	case OpInsertRows:
+		switch {
+		case op.Kind == OpDeleteRows && op.Row < applied.Row && applied.Row < op.Row+op.Count:
+			op.Count += applied.Count
+			op.Removed = nil
+		case op.Row > applied.Row,
+			op.Row == applied.Row && (op.Kind != OpInsertRows || insertsBefore(applied, op)):
+			op.Row += applied.Count
+		}
+	case OpDeleteRows:
+		start, end := applied.Row, applied.Row+applied.Count
+		switch op.Kind {
+		case OpSetCell, OpInsertRows:
+			if op.Row >= end {
+				op.Row -= applied.Count
+			} else if op.Row > start || op.Row == start && op.Kind == OpSetCell {
+				return op, false
+			}
+		case OpDeleteRows:
+			// Only the rows that weren't deleted yet remain.
+			overlap := max(0, min(op.Row+op.Count, end)-max(op.Row, start))
+			op.Count -= overlap
+			if op.Row >= end {
+				op.Row -= applied.Count
+			} else if op.Row > start {
+				op.Row = start
+			}
+			op.Removed = nil
+			return op, op.Count > 0
+		}
+	}
+	return op, true
+}
+
+// Order for concurrent sets of the same cell; the greater entry wins.
+func entryLess(a, b PatternEntry) bool {
+	ka := [...]int{int(a.Note), int(a.Instrument), int(a.VolumeCommand), int(a.VolumeParam), int(a.Effect), int(a.EffectParam)}
+	kb := [...]int{int(b.Note), int(b.Instrument), int(b.VolumeCommand), int(b.VolumeParam), int(b.Effect), int(b.EffectParam)}
+	return slices.Compare(ka[:], kb[:]) < 0
+}
+
+// Order for concurrent inserts at the same row. Inserts with the same contents can go in
+// either order.
+func insertsBefore(a, b PatternOp) bool {
+	if a.Count != b.Count {
+		return a.Count < b.Count
+	}
+	return fmt.Sprint(a.Removed) < fmt.Sprint(b.Removed)
+}
+
+// Combine a sequence of applied operations into a shorter equivalent one. Consecutive
+// sets of the same cell collapse into one, and sets that restore a cell's original
+// value are dropped.
+func MergeOps(ops []PatternOp) []PatternOp {
+	var result []PatternOp
+	for _, op := range ops {
+		if n := len(result); n > 0 && op.Kind == OpSetCell {
+			last := &result[n-1]
+			if last.Kind == OpSetCell && last.Pattern == op.Pattern && last.Row == op.Row &&
+				last.Entry.Channel == op.Entry.Channel {
+				last.Entry = op.Entry
+				if last.Entry == last.Old {
+					result = result[:n-1]
+				}
+				continue
+			}
+		}
+		result = append(result, op)
+	}
+	return result
+}
@@ -0,0 +1,96 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package common
+
+import "sync"
+
+// The lowest and highest level in one column of a waveform, -1 to 1.
+type Peak struct {
+	Min float32
+	Max float32
+}
+
+// Number of Peaks results kept for repeated calls.
+const peakCacheSize = 64
+
+// Results of SampleData.Peaks. PCM slices aren't written once they're in use (see
+// Module.Freeze), so the first element of each channel identifies the data. The keys hold
+// the data in memory, so only the most recent results are kept.
+var peakCache struct {
+	sync.Mutex
+	entries map[peakKey][]Peak
+	order   []peakKey
+}
+
+type peakKey struct {
+	data    [2]any // Pointer to the first point of each channel.
+	length  int
+	buckets int
+}
+
+// Split the sample data into buckets and find the lowest and highest point of each, over
+// all channels, for drawing a waveform. When there are fewer frames than buckets, frames
+// are repeated. Results are cached, so a UI can call this on every paint; the returned
+// slice is shared and must not be modified.
+func (sd *SampleData) Peaks(buckets int) []Peak {
+	length := sd.Len()
+	if buckets <= 0 || length == 0 {
+		return make([]Peak, max(buckets, 0))
+	}
+	if len(sd.Data) > len(peakKey{}.data) {
+		return sd.computePeaks(length, buckets)
+	}
+
+	key := peakKey{length: length, buckets: buckets}
+	for ch, channel := range sd.Data {
+		switch d := channel.(type) {
+		case []int8:
+			key.data[ch] = &d[0]
+		case []int16:
+			key.data[ch] = &d[0]
+		}
+	}
+
+	peakCache.Lock()
+	result, ok := peakCache.entries[key]
+	peakCache.Unlock()
+	if ok {
+		return result
+	}
+
+	result = sd.computePeaks(length, buckets)
+	peakCache.Lock()
+	defer peakCache.Unlock()
+	if peakCache.entries == nil {
+		peakCache.entries = make(map[peakKey][]Peak)
+	}
+	if _, ok := peakCache.entries[key]; !ok {
+		if len(peakCache.order) >= peakCacheSize {
+			delete(peakCache.entries, peakCache.order[0])
+			peakCache.order = peakCache.order[1:]
+		}
+		peakCache.order = append(peakCache.order, key)
+		peakCache.entries[key] = result
+	}
+	return result
+}
+
+func (sd *SampleData) computePeaks(length, buckets int) []Peak {
+	result := make([]Peak, buckets)
+	for i := range result {
+		start := i * length / buckets
+		end := max((i+1)*length/buckets, start+1)
+		peak := Peak{Min: 1, Max: -1}
+		for ch := range sd.Data {
+			for f := start; f < end; f++ {
+				v := float32(sd.at(ch, f))
+				peak.Min = min(peak.Min, v)
+				peak.Max = max(peak.Max, v)
+			}
+		}
+		result[i] = peak
+	}
+	return result
+}
@@ -0,0 +1,25 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package common
+
+// Receives the progress of a long operation, e.g. to update a progress bar. stage names
+// the part being worked on, and done counts up to total in the stage's own units, like
+// samples or frames. total is 0 if it isn't known.
+type ProgressFunc func(stage string, done, total int)
+
+// Stages reported by loaders, converters and the renderer.
+const (
+	StageInstruments = "instruments"
+	StageSamples     = "samples"
+	StagePatterns    = "patterns"
+	StageRender      = "render"
+)
+
+// Call the function if it isn't nil.
+func (fn ProgressFunc) Report(stage string, done, total int) {
+	if fn != nil {
+		fn(stage, done, total)
+	}
+}
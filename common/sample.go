@@ -0,0 +1,184 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package common
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// Returned when an operation needs a sample loop and the sample doesn't have one.
+var ErrNoLoop = errors.New("sample has no loop")
+
+// True if the sample has PCM data. Samples without it are empty slots, or external samples
+// whose file wasn't found, and play nothing; a sample of silence has data.
+func (s *Sample) HasData() bool {
+	return s.Data.Len() > 0 || s.Stream != nil && s.Stream.Frames > 0
+}
+
+// Copy a region of PCM from each channel. The result does not share memory with the
+// source.
+func (sd *SampleData) slice(start, end int) SampleData {
+	result := SampleData{
+		Channels: sd.Channels,
+		Bits:     sd.Bits,
+	}
+
+	for _, ch := range sd.Data {
+		switch d := ch.(type) {
+		case []int8:
+			result.Data = append(result.Data, slices.Clone(d[start:end]))
+		case []int16:
+			result.Data = append(result.Data, slices.Clone(d[start:end]))
+		}
+	}
+
+	return result
+}
+
+// A copy of a streamed sample with its PCM in Data, for the edits that return warnings
+// instead of errors. If the stream can't be read, the copy is empty and the error is
+// returned as a warning.
+func (s *Sample) loadedCopy() (*Sample, []string) {
+	loaded, err := s.WithLoadedStream()
+	if err != nil {
+		empty := *s
+		empty.Stream = nil
+		empty.Loop, empty.Sustain = false, false
+		empty.Data = SampleData{Channels: s.Stream.Channels, Bits: s.Stream.Bits}
+		return &empty, []string{fmt.Sprintf("sample stream not loaded: %v", err)}
+	}
+	return loaded, nil
+}
+
+// Move a loop region by -offset. If the loop doesn't fit entirely in the new length, it's
+// dropped and false is returned.
+func shiftLoop(loopStart, loopEnd *int, offset, length int) bool {
+	start := *loopStart - offset
+	end := *loopEnd - offset
+	if start < 0 || end > length || start >= end {
+		*loopStart = 0
+		*loopEnd = 0
+		return false
+	}
+	*loopStart = start
+	*loopEnd = end
+	return true
+}
+
+// Create a new sample from the frames in [start, end). The range is clamped to the sample
+// length. Loop and sustain points are moved to stay on the same audio. Loops that don't
+// fit entirely inside of the region are dropped (Loop/Sustain is cleared and the points
+// are zeroed), and a warning is returned for each. Streamed samples are read into memory
+// first, and the result is empty with a warning if that fails.
+func (s *Sample) Slice(start, end int) (Sample, []string) {
+	if s.Stream != nil {
+		loaded, warnings := s.loadedCopy()
+		if warnings != nil {
+			return *loaded, warnings
+		}
+		return loaded.Slice(start, end)
+	}
+	length := s.Data.Len()
+	start = max(0, min(start, length))
+	end = max(start, min(end, length))
+
+	result := *s
+	result.Data = s.Data.slice(start, end)
+
+	var warnings []string
+	dropped := func(what string, loopStart, loopEnd int) {
+		warnings = append(warnings, fmt.Sprintf("%s %d-%d doesn't fit in frames %d-%d and was dropped",
+			what, loopStart, loopEnd, start, end))
+	}
+
+	newLength := end - start
+	if s.Loop {
+		result.Loop = shiftLoop(&result.LoopStart, &result.LoopEnd, start, newLength)
+		if !result.Loop {
+			result.PingPong = false
+			dropped("loop", s.LoopStart, s.LoopEnd)
+		}
+	}
+
+	if s.Sustain {
+		result.Sustain = shiftLoop(&result.SustainLoopStart, &result.SustainLoopEnd, start, newLength)
+		if !result.Sustain {
+			result.PingPongSustain = false
+			dropped("sustain loop", s.SustainLoopStart, s.SustainLoopEnd)
+		}
+	}
+
+	return result, warnings
+}
+
+// Cut the sample into several samples at the given frame positions. Points outside of the
+// sample are ignored and the rest are sorted, so N usable points produce N+1 samples. Loop
+// points are handled the same way as Slice, and the warnings of all parts are returned.
+func (s *Sample) Split(points []int) ([]Sample, []string) {
+	if s.Stream != nil {
+		loaded, warnings := s.loadedCopy()
+		if warnings != nil {
+			return []Sample{*loaded}, warnings
+		}
+		return loaded.Split(points)
+	}
+	length := s.Data.Len()
+
+	cuts := []int{0}
+	sorted := slices.Clone(points)
+	slices.Sort(sorted)
+	for _, p := range slices.Compact(sorted) {
+		if p > 0 && p < length {
+			cuts = append(cuts, p)
+		}
+	}
+	cuts = append(cuts, length)
+
+	var result []Sample
+	var warnings []string
+	for i := 0; i < len(cuts)-1; i++ {
+		part, w := s.Slice(cuts[i], cuts[i+1])
+		result = append(result, part)
+		warnings = append(warnings, w...)
+	}
+
+	return result, warnings
+}
+
+// Smooth the loop seam by blending the audio before LoopStart into the end of the loop.
+// Playback reaching LoopEnd will then flow naturally into LoopStart. ms is the length of
+// the crossfade, converted to frames with the C5 speed, and it's limited by the loop
+// length and the data available before LoopStart. The PCM is replaced with a modified
+// copy, so snapshots sharing it aren't affected. Loops starting at 0 have nothing to blend
+// with and are left unchanged. Streamed samples are read into memory first.
+func (s *Sample) CrossfadeLoop(ms int) error {
+	if err := s.LoadStream(); err != nil {
+		return err
+	}
+	if !s.Loop || s.LoopEnd <= s.LoopStart || s.LoopEnd > s.Data.Len() {
+		return ErrNoLoop
+	}
+
+	length := ms * s.C5 / 1000
+	length = min(length, s.LoopStart, s.LoopEnd-s.LoopStart)
+	if length <= 0 {
+		return nil
+	}
+
+	tail := s.LoopEnd - length
+	lead := s.LoopStart - length
+	s.Data = s.Data.Clone()
+	for ch := range s.Data.Data {
+		for i := 0; i < length; i++ {
+			t := float64(i+1) / float64(length)
+			v := s.Data.at(ch, tail+i)*(1-t) + s.Data.at(ch, lead+i)*t
+			s.Data.set(ch, tail+i, v)
+		}
+	}
+
+	return nil
+}
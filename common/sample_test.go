@@ -0,0 +1,269 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package common
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSampleSlice(t *testing.T) {
+	s := Sample{
+		Name:             "test",
+		Loop:             true,
+		LoopStart:        4,
+		LoopEnd:          8,
+		Sustain:          true,
+		SustainLoopStart: 1,
+		SustainLoopEnd:   3,
+		Data: SampleData{
+			Channels: 1,
+			Bits:     8,
+			Data:     []any{[]int8{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}},
+		},
+	}
+
+	slice, warnings := s.Slice(2, 9)
+	assert.Equal(t, []string{"sustain loop 1-3 doesn't fit in frames 2-9 and was dropped"}, warnings)
+	assert.Equal(t, []any{[]int8{2, 3, 4, 5, 6, 7, 8}}, slice.Data.Data)
+	assert.True(t, slice.Loop)
+	assert.Equal(t, 2, slice.LoopStart)
+	assert.Equal(t, 6, slice.LoopEnd)
+	assert.False(t, slice.Sustain, "sustain loop crosses the start and should be dropped")
+	assert.Equal(t, 0, slice.SustainLoopEnd)
+
+	// The source is untouched.
+	slice.Data.Data[0].([]int8)[0] = 100
+	assert.Equal(t, int8(2), s.Data.Data[0].([]int8)[2])
+
+	parts, warnings := s.Split([]int{7, 3, 100, 3})
+	assert.Equal(t, []string{
+		"loop 4-8 doesn't fit in frames 0-3 and was dropped",
+		"loop 4-8 doesn't fit in frames 3-7 and was dropped",
+		"sustain loop 1-3 doesn't fit in frames 3-7 and was dropped",
+		"loop 4-8 doesn't fit in frames 7-10 and was dropped",
+		"sustain loop 1-3 doesn't fit in frames 7-10 and was dropped",
+	}, warnings)
+	assert.Len(t, parts, 3)
+	assert.Equal(t, []any{[]int8{0, 1, 2}}, parts[0].Data.Data)
+	assert.Equal(t, []any{[]int8{3, 4, 5, 6}}, parts[1].Data.Data)
+	assert.Equal(t, []any{[]int8{7, 8, 9}}, parts[2].Data.Data)
+	assert.True(t, parts[0].Sustain)
+	assert.False(t, parts[1].Loop)
+}
+
+func TestCrossfadeLoop(t *testing.T) {
+	s := Sample{
+		C5:        1000,
+		Loop:      true,
+		LoopStart: 4,
+		LoopEnd:   12,
+		Data: SampleData{
+			Channels: 1,
+			Bits:     8,
+			Data:     []any{[]int8{0, 10, 20, 30, 40, 40, 40, 40, 80, 80, 80, 80, 0}},
+		},
+	}
+
+	assert.NoError(t, s.CrossfadeLoop(4))
+	assert.Equal(t, []int8{0, 10, 20, 30, 40, 40, 40, 40, 60, 45, 35, 30, 0}, s.Data.Data[0])
+
+	s.Loop = false
+	assert.ErrorIs(t, s.CrossfadeLoop(4), ErrNoLoop)
+}
+
+func TestStereoPairs(t *testing.T) {
+	mono := func(name string, values ...float64) Sample {
+		sd, _ := FromFloat64([][]float64{values}, 16)
+		return Sample{Name: name, C5: 44100, S16: true, Data: sd}
+	}
+	samples := []Sample{
+		mono("Piano R", 0.5, 0.25),
+		mono("Kick", 1, 0),
+		mono("Piano L", -0.5, -0.25),
+		mono("pad_left", 0, 0),
+		mono("pad_right", 0, 0, 0), // Different length
+		mono("Strings(L)", 0),
+		mono("strings (r)", 0),
+		{DosFilename: "BASS_L.WAV", Data: mono("", 1).Data},
+		{DosFilename: "BASS_R.WAV", Data: mono("", -1).Data},
+	}
+	samples[7].C5, samples[8].C5 = 44100, 44100
+
+	pairs := FindStereoPairs(samples)
+	assert.Equal(t, []StereoPair{{Left: 2, Right: 0}, {Left: 5, Right: 6}, {Left: 7, Right: 8}}, pairs)
+
+	merged, err := MergeStereo(&samples[2], &samples[0])
+	assert.NoError(t, err)
+	assert.Equal(t, "Piano", merged.Name)
+	assert.True(t, merged.Stereo)
+	assert.Equal(t, int8(2), merged.Data.Channels)
+	assert.Equal(t, samples[2].Data.Data[0], merged.Data.Data[0])
+	assert.Equal(t, samples[0].Data.Data[0], merged.Data.Data[1])
+
+	_, err = MergeStereo(&samples[3], &samples[4])
+	assert.ErrorIs(t, err, ErrNotStereoPair)
+
+	left, right, err := merged.SplitStereo()
+	assert.NoError(t, err)
+	assert.Equal(t, "Piano L", left.Name)
+	assert.Equal(t, "Piano R", right.Name)
+	assert.False(t, right.Stereo)
+	assert.Equal(t, samples[0].Data, right.Data)
+
+	_, _, err = samples[1].SplitStereo()
+	assert.ErrorIs(t, err, ErrNotStereo)
+}
+
+func TestSampleStream(t *testing.T) {
+	raw := []byte{0x80, 0x81, 0x7F, 0x00, 0xFF, 0x10} // 3 frames, 2 channels, unsigned
+	ss := &SampleStream{Source: bytes.NewReader(raw), Frames: 3, Channels: 2, Bits: 8, Unsigned: true}
+
+	sd, err := ss.Read(1, 5)
+	assert.NoError(t, err)
+	assert.Equal(t, []any{[]int8{1, -1}, []int8{127, -112}}, sd.Data)
+
+	s := Sample{Stream: ss}
+	assert.True(t, s.HasData())
+	assert.NoError(t, s.LoadStream())
+	assert.Nil(t, s.Stream)
+	assert.Equal(t, []int8{0, 1, -1}, s.Data.Data[0])
+
+	ss = &SampleStream{Source: bytes.NewReader(raw), Frames: 2, Channels: 1, Bits: 16, Offset: 2}
+	s = Sample{Stream: ss}
+	assert.NoError(t, s.LoadStream())
+	assert.Equal(t, []int16{0x7F, 0x10FF}, s.Data.Data[0])
+
+	s = Sample{Stream: &SampleStream{Source: bytes.NewReader(raw), Frames: 10, Channels: 1, Bits: 8}}
+	assert.ErrorIs(t, s.LoadStream(), io.EOF)
+	assert.NotNil(t, s.Stream)
+
+	// Copies with the streams loaded, for exporters.
+	m := &Module{Samples: []Sample{{Name: "memory"}, {Stream: ss}}}
+	loaded, err := m.WithLoadedStreams()
+	assert.NoError(t, err)
+	assert.Equal(t, []int16{0x7F, 0x10FF}, loaded.Samples[1].Data.Data[0])
+	assert.Nil(t, loaded.Samples[1].Stream)
+	assert.Equal(t, ss, m.Samples[1].Stream)
+	assert.Zero(t, m.Samples[1].Data.Len())
+
+	m.Samples[1].Stream = nil
+	loaded, err = m.WithLoadedStreams()
+	assert.NoError(t, err)
+	assert.Same(t, m, loaded)
+
+	m.Samples[1] = s
+	_, err = m.WithLoadedStreams()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestStreamedSampleEdits(t *testing.T) {
+	raw := make([]byte, 2000) // 1000 frames, 8-bit stereo
+	for i := range raw {
+		raw[i] = byte(i)
+	}
+	streamed := func() Sample {
+		return Sample{
+			Name: "pad", C5: 1000, Loop: true, LoopStart: 100, LoopEnd: 400,
+			Stream: &SampleStream{Source: bytes.NewReader(raw), Frames: 1000, Channels: 2, Bits: 8},
+		}
+	}
+
+	s := streamed()
+	slice, warnings := s.Slice(0, 500)
+	assert.Empty(t, warnings)
+	assert.Nil(t, slice.Stream)
+	assert.Equal(t, 500, slice.Data.Len())
+	assert.Equal(t, int8(-24), slice.Data.Data[1].([]int8)[0], "frame 0 of the right channel is byte 1000")
+	assert.True(t, slice.Loop)
+	assert.Equal(t, 400, slice.LoopEnd)
+	assert.NotNil(t, s.Stream, "the source stays streamed")
+
+	parts, warnings := s.Split([]int{500})
+	assert.Len(t, warnings, 1)
+	assert.Len(t, parts, 2)
+	assert.Equal(t, int8(-12), parts[1].Data.Data[0].([]int8)[0])
+
+	left, right, err := s.SplitStereo()
+	assert.NoError(t, err)
+	assert.Equal(t, 1000, left.Data.Len())
+	assert.Equal(t, int8(-24), right.Data.Data[0].([]int8)[0])
+
+	assert.NoError(t, s.CrossfadeLoop(10))
+	assert.Nil(t, s.Stream)
+	assert.Equal(t, 1000, s.Data.Len())
+
+	// Unreadable streams.
+	s = streamed()
+	s.Stream.Frames = 2000
+	slice, warnings = s.Slice(0, 500)
+	assert.Len(t, warnings, 1)
+	assert.Nil(t, slice.Stream)
+	assert.Zero(t, slice.Data.Len())
+	assert.ErrorIs(t, s.CrossfadeLoop(10), io.EOF)
+	_, _, err = s.SplitStereo()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestSynthVoice(t *testing.T) {
+	tri := NewSynthVoice(&SynthPatch{}, 60, 64)
+	assert.Equal(t, []int8{0, 127, 0, -128}, tri.Waveform())
+	saw := NewSynthVoice(&SynthPatch{Performance: []SynthStep{{Waveform: SynthWaveSawtooth}}}, 60, 64)
+	assert.Equal(t, []int8{-128, -64, 0, 64}, saw.Waveform())
+	noise := NewSynthVoice(&SynthPatch{Performance: []SynthStep{{Waveform: SynthWaveNoise}}}, 60, 64)
+	assert.Len(t, noise.Waveform(), synthNoiseLength)
+
+	patch := &SynthPatch{
+		WaveLength:       2,
+		SquareLower:      8,
+		SquareUpper:      16,
+		SquareSpeed:      1,
+		FilterLower:      10,
+		FilterUpper:      12,
+		FilterSpeed:      3,
+		PerformanceSpeed: 2,
+		Performance: []SynthStep{
+			// Square wave, turn on square and filter modulation, and slide up.
+			{Waveform: SynthWaveSquare, Effects: [2]uint8{synthFxModulate, synthFxSlideUp}, Params: [2]uint8{0x11, 4}},
+			{Note: 12, Effects: [2]uint8{synthFxVolume}, Params: [2]uint8{32}},
+			{Effects: [2]uint8{synthFxJump}, Params: [2]uint8{1}},
+		},
+	}
+	v := NewSynthVoice(patch, 60, 48)
+
+	// Square width 8/64 of the 16-point cycle.
+	assert.Equal(t, []int8{-128, -128, 127, 127}, v.Waveform()[:4])
+	assert.Equal(t, 48, v.Volume())
+
+	// The square width moves between 1 and 2 (8 and 16 64ths) every tick, and the filter
+	// slides in from the middle toward its limits.
+	var squares, filters []int
+	for range 4 {
+		assert.True(t, v.Tick())
+		squares = append(squares, v.square.pos)
+		filters = append(filters, v.filter.pos)
+		if len(squares) == 1 {
+			assert.InDelta(t, 214.0/210, v.Pitch(), 1e-9, "slide by 4 periods")
+		}
+	}
+	assert.Equal(t, []int{2, 1, 2, 1}, squares)
+	assert.Equal(t, []int{31, 30, 29, 28}, filters)
+	assert.NotEqual(t, []int8{-128, -128, 127, 127}, v.Waveform()[:4], "should be filtered")
+
+	// The second step ran on tick 3: an octave up, keeping the slide.
+	assert.InDelta(t, 214.0/(107-8), v.Pitch(), 1e-9)
+	assert.Equal(t, 32, v.Volume())
+
+	// Tick 5 jumps back to the first step, which runs on tick 6 and toggles the
+	// modulation off.
+	v.Tick()
+	v.Tick()
+	assert.False(t, v.square.on)
+	assert.False(t, v.filter.on)
+	assert.InDelta(t, 214.0/(107-4), v.Pitch(), 1e-9, "the waveform resets the slide")
+}
@@ -0,0 +1,165 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package common
+
+import (
+	"errors"
+	"iter"
+	"math"
+)
+
+// Returned when a bit depth other than 8 or 16 is requested.
+var ErrInvalidBits = errors.New("invalid sample bit depth")
+
+// Number of frames in the sample data. A frame is one sample point for each channel.
+func (sd *SampleData) Len() int {
+	length := -1
+	for _, ch := range sd.Data {
+		n := 0
+		switch d := ch.(type) {
+		case []int8:
+			n = len(d)
+		case []int16:
+			n = len(d)
+		}
+		if length < 0 || n < length {
+			length = n
+		}
+	}
+	return max(length, 0)
+}
+
+// Read a single point from a channel, normalized to [-1, 1).
+func (sd *SampleData) at(channel int, index int) float64 {
+	switch d := sd.Data[channel].(type) {
+	case []int8:
+		return float64(d[index]) / 128.0
+	case []int16:
+		return float64(d[index]) / 32768.0
+	}
+	return 0
+}
+
+// Write a single normalized point to a channel, quantized to the data's bit depth.
+func (sd *SampleData) set(channel int, index int, v float64) {
+	switch d := sd.Data[channel].(type) {
+	case []int8:
+		d[index] = int8(quantize(v, 8))
+	case []int16:
+		d[index] = int16(quantize(v, 16))
+	}
+}
+
+// Iterate over the frames in the sample data. Each frame contains one value per channel,
+// normalized to [-1, 1) regardless of the bit depth. The frame slice is reused between
+// iterations, so copy it if it needs to be kept.
+func (sd *SampleData) Frames() iter.Seq2[int, []float64] {
+	return func(yield func(int, []float64) bool) {
+		length := sd.Len()
+		frame := make([]float64, len(sd.Data))
+		for i := 0; i < length; i++ {
+			for ch := range sd.Data {
+				frame[ch] = sd.at(ch, i)
+			}
+			if !yield(i, frame) {
+				return
+			}
+		}
+	}
+}
+
+// Convert the sample data into normalized float64 values, stored as [channel][frame].
+func (sd *SampleData) Float64() [][]float64 {
+	length := sd.Len()
+	result := make([][]float64, len(sd.Data))
+	for ch := range sd.Data {
+		result[ch] = make([]float64, length)
+		for i := 0; i < length; i++ {
+			result[ch][i] = sd.at(ch, i)
+		}
+	}
+	return result
+}
+
+// Quantize a normalized value into the integer range of the given bit depth.
+func quantize(v float64, bits int8) int {
+	scale := 128.0
+	if bits == 16 {
+		scale = 32768.0
+	}
+	q := math.Round(v * scale)
+	return int(math.Max(-scale, math.Min(scale-1, q)))
+}
+
+// Create sample data from normalized float64 values stored as [channel][frame]. bits
+// selects the target depth (8 or 16). Values outside of [-1, 1) are clipped. Channels are
+// truncated to the shortest one.
+func FromFloat64(data [][]float64, bits int8) (SampleData, error) {
+	if bits != 8 && bits != 16 {
+		return SampleData{}, ErrInvalidBits
+	}
+
+	length := -1
+	for _, ch := range data {
+		if length < 0 || len(ch) < length {
+			length = len(ch)
+		}
+	}
+	length = max(length, 0)
+
+	sd := SampleData{
+		Channels: int8(len(data)),
+		Bits:     bits,
+	}
+
+	for _, ch := range data {
+		if bits == 16 {
+			pcm := make([]int16, length)
+			for i := range pcm {
+				pcm[i] = int16(quantize(ch[i], bits))
+			}
+			sd.Data = append(sd.Data, pcm)
+		} else {
+			pcm := make([]int8, length)
+			for i := range pcm {
+				pcm[i] = int8(quantize(ch[i], bits))
+			}
+			sd.Data = append(sd.Data, pcm)
+		}
+	}
+
+	return sd, nil
+}
+
+// Multiply the sample data by a gain and return the result as new data with the same bit
+// depth. With dither, triangular noise of one step is added before requantizing, which
+// turns the rounding error of quiet or scaled-down 8-bit samples into a low noise floor
+// instead of distortion. The dither is seeded the same way every time, so the result is
+// deterministic.
+func (sd *SampleData) Scale(gain float64, dither bool) SampleData {
+	c := sd.Clone()
+	step := 1 / 128.0
+	if sd.Bits == 16 {
+		step = 1 / 32768.0
+	}
+
+	random := uint32(1)
+	uniform := func() float64 {
+		random = random*1103515245 + 12345
+		return float64(random>>8) / (1 << 24)
+	}
+
+	length := c.Len()
+	for ch := range c.Data {
+		for i := 0; i < length; i++ {
+			v := c.at(ch, i) * gain
+			if dither {
+				v += (uniform() - uniform()) * step
+			}
+			c.set(ch, i, v)
+		}
+	}
+	return c
+}
@@ -0,0 +1,126 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package common
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSampleFrames(t *testing.T) {
+	sd := SampleData{
+		Channels: 2,
+		Bits:     16,
+		Data: []any{
+			[]int16{0, 16384, -32768},
+			[]int16{32767, -16384, 0},
+		},
+	}
+
+	assert.Equal(t, 3, sd.Len())
+
+	var frames [][]float64
+	for _, frame := range sd.Frames() {
+		frames = append(frames, append([]float64{}, frame...))
+	}
+
+	assert.Equal(t, [][]float64{
+		{0, 32767.0 / 32768.0},
+		{0.5, -0.5},
+		{-1, 0},
+	}, frames)
+}
+
+func TestFromFloat64(t *testing.T) {
+	sd8 := SampleData{Channels: 1, Bits: 8, Data: []any{[]int8{-128, -1, 0, 1, 127}}}
+
+	sd, err := FromFloat64(sd8.Float64(), 8)
+	assert.NoError(t, err)
+	assert.Equal(t, sd8, sd)
+
+	sd, err = FromFloat64([][]float64{{-2, -1, 0.5, 1, 2}}, 16)
+	assert.NoError(t, err)
+	assert.Equal(t, []any{[]int16{-32768, -32768, 16384, 32767, 32767}}, sd.Data)
+
+	_, err = FromFloat64([][]float64{{0}}, 12)
+	assert.ErrorIs(t, err, ErrInvalidBits)
+}
+
+// A mono 16-bit sine wave.
+func sineData(length int, period float64) SampleData {
+	data := make([]float64, length)
+	for i := range data {
+		data[i] = 0.5 * math.Sin(2*math.Pi*float64(i)/period)
+	}
+	sd, _ := FromFloat64([][]float64{data}, 16)
+	return sd
+}
+
+// Count upward zero crossings, as a rough pitch measurement.
+func countCrossings(sd SampleData) int {
+	count := 0
+	prev := 0.0
+	for _, frame := range sd.Frames() {
+		if prev < 0 && frame[0] >= 0 {
+			count++
+		}
+		prev = frame[0]
+	}
+	return count
+}
+
+func TestTimeStretch(t *testing.T) {
+	sd := sineData(8000, 80)
+
+	stretched, err := sd.TimeStretch(2)
+	assert.NoError(t, err)
+	assert.Equal(t, 16000, stretched.Len())
+	assert.Equal(t, int8(16), stretched.Bits)
+	assert.InDelta(t, 200, countCrossings(stretched), 4, "pitch should be kept")
+
+	shifted, err := sd.PitchShift(12)
+	assert.NoError(t, err)
+	assert.Equal(t, 8000, shifted.Len())
+	assert.InDelta(t, 200, countCrossings(shifted), 4, "pitch should be doubled")
+
+	_, err = sd.TimeStretch(0)
+	assert.ErrorIs(t, err, ErrInvalidRatio)
+}
+
+func TestScale(t *testing.T) {
+	sd := SampleData{Channels: 1, Bits: 8, Data: []any{[]int8{-128, -64, 0, 64, 127}}}
+	scaled := sd.Scale(0.5, false)
+	assert.Equal(t, []int8{-64, -32, 0, 32, 64}, scaled.Data[0])
+	assert.Equal(t, []int8{-128, -64, 0, 64, 127}, sd.Data[0])
+
+	// Dither stays within a step and is the same every time.
+	dithered := sd.Scale(0.5, true)
+	for i, v := range dithered.Data[0].([]int8) {
+		assert.InDelta(t, scaled.Data[0].([]int8)[i], v, 1)
+	}
+	assert.Equal(t, dithered, sd.Scale(0.5, true))
+}
+
+func TestPeaks(t *testing.T) {
+	sd := SampleData{Channels: 2, Bits: 8, Data: []any{
+		[]int8{0, 64, -64, 32, 0, 0, 0, -128},
+		[]int8{0, 0, 0, -32, 0, 0, 0, 0},
+	}}
+	peaks := sd.Peaks(4)
+	assert.Equal(t, []Peak{{0, 0.5}, {-0.5, 0.25}, {0, 0}, {-1, 0}}, peaks)
+	assert.Same(t, &peaks[0], &sd.Peaks(4)[0])
+	assert.Len(t, sd.Peaks(2), 2)
+
+	// Edited data is a new slice, so it isn't served from the cache.
+	edited := sd.Clone()
+	edited.Data[0].([]int8)[0] = 127
+	assert.InDelta(t, 127.0/128, edited.Peaks(4)[0].Max, 1e-6)
+
+	// Frames are repeated when there are more buckets than frames.
+	assert.Equal(t, []Peak{{0, 0}, {0, 0}, {0.5, 0.5}, {0.5, 0.5}}, (&SampleData{Channels: 1, Bits: 8, Data: []any{[]int8{0, 64}}}).Peaks(4))
+	assert.Len(t, (&SampleData{}).Peaks(3), 3)
+}
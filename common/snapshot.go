@@ -0,0 +1,97 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package common
+
+import "slices"
+
+// A read-only view of a module at one point in time, made with Module.Freeze. A snapshot
+// can be read from any number of goroutines while the module it came from is edited, e.g.
+// so a player can keep rendering while an editor changes a working copy.
+type Snapshot struct {
+	module *Module
+}
+
+// Take a snapshot of the module. Everything is copied except the sample PCM, which is
+// shared. modlib never writes into PCM slices that are in use; operations like
+// Sample.CrossfadeLoop replace Sample.Data with new slices, and code that edits a module
+// with a live snapshot must do the same.
+func (m *Module) Freeze() *Snapshot {
+	return &Snapshot{module: m.clone((*SampleData).share)}
+}
+
+// A copy of the sample data that shares the PCM slices.
+func (sd *SampleData) share() SampleData {
+	c := *sd
+	c.Data = slices.Clone(sd.Data)
+	return c
+}
+
+// The frozen module, for functions that only read a module, like render.NewPlayer. It must
+// not be modified.
+func (s *Snapshot) Module() *Module {
+	return s.module
+}
+
+// An editable copy of the snapshot.
+func (s *Snapshot) Thaw() *Module {
+	return s.module.Clone()
+}
+
+// The title of the song.
+func (s *Snapshot) Title() string {
+	return s.module.Title
+}
+
+// Number of channels.
+func (s *Snapshot) Channels() int {
+	return int(s.module.Channels)
+}
+
+// A copy of the order list.
+func (s *Snapshot) Order() []int16 {
+	return slices.Clone(s.module.Order)
+}
+
+// Number of patterns.
+func (s *Snapshot) NumPatterns() int {
+	return len(s.module.Patterns)
+}
+
+// Number of samples.
+func (s *Snapshot) NumSamples() int {
+	return len(s.module.Samples)
+}
+
+// Number of instruments.
+func (s *Snapshot) NumInstruments() int {
+	return len(s.module.Instruments)
+}
+
+// Number of rows in a pattern.
+func (s *Snapshot) Rows(pattern int) int {
+	return len(s.module.Patterns[pattern].Rows)
+}
+
+// The entry at a pattern cell. Empty cells return an empty entry for the channel.
+func (s *Snapshot) Entry(pattern, row, channel int) PatternEntry {
+	return s.module.Patterns[pattern].Rows[row].Get(channel)
+}
+
+// A copy of a pattern.
+func (s *Snapshot) Pattern(index int) Pattern {
+	return s.module.Patterns[index].Clone()
+}
+
+// A copy of an instrument.
+func (s *Snapshot) Instrument(index int) Instrument {
+	return s.module.Instruments[index].Clone()
+}
+
+// A copy of a sample's settings. The PCM in Data is shared and must not be modified.
+func (s *Snapshot) Sample(index int) Sample {
+	sample := s.module.Samples[index]
+	sample.Data = sample.Data.share()
+	return sample
+}
@@ -0,0 +1,153 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package common
+
+import (
+	"errors"
+	"path"
+	"strings"
+)
+
+// Returned when two samples can't be merged into one stereo sample.
+var ErrNotStereoPair = errors.New("samples are not a stereo pair")
+
+// Returned when a stereo operation is given a mono sample.
+var ErrNotStereo = errors.New("sample is not stereo")
+
+// Two mono samples, by index, that hold the left and right channels of one sound.
+type StereoPair struct {
+	Left  int
+	Right int
+}
+
+// Name endings that mark the left and right channel of a pair, checked in order.
+var stereoMarkers = [][2]string{
+	{"left", "right"},
+	{"(l)", "(r)"},
+	{"[l]", "[r]"},
+	{"_l", "_r"},
+	{"-l", "-r"},
+	{".l", ".r"},
+	{" l", " r"},
+}
+
+// Split a name into its base and the channel it's marked with: 0 = left, 1 = right, -1 =
+// no marker. The base is lowercase without the marker and trailing separators.
+func stereoSide(name string) (string, int) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for _, marker := range stereoMarkers {
+		for side, suffix := range marker {
+			if base, ok := strings.CutSuffix(name, suffix); ok {
+				base = strings.TrimRight(base, " _-.")
+				if base != "" {
+					return base, side
+				}
+			}
+		}
+	}
+	return "", -1
+}
+
+// The name that identifies a sample for pairing: its name, or its file name without the
+// extension.
+func pairingName(s *Sample) string {
+	if strings.TrimSpace(s.Name) != "" {
+		return s.Name
+	}
+	return strings.TrimSuffix(s.DosFilename, path.Ext(s.DosFilename))
+}
+
+// True if two mono samples have the same format and settings, so they can be the
+// channels of one sample.
+func matchingChannels(a, b *Sample) bool {
+	return a.Data.Channels == 1 && b.Data.Channels == 1 &&
+		a.Data.Len() > 0 && a.Data.Len() == b.Data.Len() &&
+		a.Data.Bits == b.Data.Bits && a.C5 == b.C5 &&
+		a.Loop == b.Loop && a.LoopStart == b.LoopStart && a.LoopEnd == b.LoopEnd &&
+		a.Sustain == b.Sustain && a.SustainLoopStart == b.SustainLoopStart &&
+		a.SustainLoopEnd == b.SustainLoopEnd
+}
+
+// Find mono samples that look like the two channels of a stereo sound, stored separately
+// by trackers without stereo samples: names that differ only by a left/right marker, like
+// "Piano L" and "Piano R" or "pad_left" and "pad_right", and the same length, bit depth,
+// C5 speed and loops. Each sample is in at most one pair.
+func FindStereoPairs(samples []Sample) []StereoPair {
+	var pairs []StereoPair
+	used := make([]bool, len(samples))
+	for i := range samples {
+		base, side := stereoSide(pairingName(&samples[i]))
+		if side != 0 || used[i] {
+			continue
+		}
+		for j := range samples {
+			if used[j] || j == i {
+				continue
+			}
+			otherBase, otherSide := stereoSide(pairingName(&samples[j]))
+			if otherSide == 1 && otherBase == base && matchingChannels(&samples[i], &samples[j]) {
+				pairs = append(pairs, StereoPair{Left: i, Right: j})
+				used[i], used[j] = true, true
+				break
+			}
+		}
+	}
+	return pairs
+}
+
+// Merge the samples of a pair into one stereo sample. The settings are taken from the
+// left sample, and the channel marker is removed from the name.
+func MergeStereo(left, right *Sample) (Sample, error) {
+	if !matchingChannels(left, right) {
+		return Sample{}, ErrNotStereoPair
+	}
+
+	s := *left
+	s.Stereo = true
+	s.Data = SampleData{
+		Channels: 2,
+		Bits:     left.Data.Bits,
+		Data:     []any{left.Data.Clone().Data[0], right.Data.Clone().Data[0]},
+	}
+	if _, side := stereoSide(left.Name); side == 0 {
+		s.Name = trimStereoMarker(left.Name)
+	}
+	return s, nil
+}
+
+// Remove the channel marker from the end of a name, keeping its case.
+func trimStereoMarker(name string) string {
+	name = strings.TrimSpace(name)
+	lower := strings.ToLower(name)
+	for _, marker := range stereoMarkers {
+		for _, suffix := range marker {
+			if strings.HasSuffix(lower, suffix) {
+				return strings.TrimRight(name[:len(name)-len(suffix)], " _-.")
+			}
+		}
+	}
+	return name
+}
+
+// Split a stereo sample into two mono samples, e.g. for formats without stereo samples.
+// The names get " L" and " R" added. Streamed samples are read into memory first.
+func (s *Sample) SplitStereo() (left Sample, right Sample, err error) {
+	s, err = s.WithLoadedStream()
+	if err != nil {
+		return Sample{}, Sample{}, err
+	}
+	if s.Data.Channels != 2 || len(s.Data.Data) != 2 {
+		return Sample{}, Sample{}, ErrNotStereo
+	}
+
+	data := s.Data.Clone()
+	left, right = *s, *s
+	for i, side := range []*Sample{&left, &right} {
+		side.Stereo = false
+		side.Name = strings.TrimSpace(s.Name + [2]string{" L", " R"}[i])
+		side.Data = SampleData{Channels: 1, Bits: s.Data.Bits, Data: []any{data.Data[i]}}
+	}
+	return left, right, nil
+}
@@ -0,0 +1,106 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package common
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"slices"
+)
+
+// Uncompressed sample PCM in a file, for reading parts of a sample as they're played.
+// Channels are stored one after another, as in IT files. Source must stay open while the
+// sample is used.
+type SampleStream struct {
+	Source   io.ReaderAt
+	Offset   int64 // Start of the PCM in Source.
+	Frames   int
+	Channels int8
+	Bits     int8 // 8 or 16, little-endian.
+	Unsigned bool
+}
+
+// Read frames [start, start+count) of every channel. The range is clipped to the sample.
+func (ss *SampleStream) Read(start, count int) (SampleData, error) {
+	start = max(0, min(start, ss.Frames))
+	count = max(0, min(count, ss.Frames-start))
+	sd := SampleData{Channels: ss.Channels, Bits: ss.Bits}
+
+	width := int64(ss.Bits / 8)
+	for ch := range int64(ss.Channels) {
+		raw := make([]byte, int64(count)*width)
+		at := ss.Offset + (ch*int64(ss.Frames)+int64(start))*width
+		if _, err := ss.Source.ReadAt(raw, at); err != nil {
+			return SampleData{}, fmt.Errorf("reading sample stream at %d: %w", at, err)
+		}
+
+		if ss.Bits == 16 {
+			pcm := make([]int16, count)
+			for i := range pcm {
+				pcm[i] = int16(binary.LittleEndian.Uint16(raw[i*2:]))
+				if ss.Unsigned {
+					pcm[i] ^= -0x8000
+				}
+			}
+			sd.Data = append(sd.Data, pcm)
+		} else {
+			pcm := make([]int8, count)
+			for i := range pcm {
+				pcm[i] = int8(raw[i])
+				if ss.Unsigned {
+					pcm[i] ^= -0x80
+				}
+			}
+			sd.Data = append(sd.Data, pcm)
+		}
+	}
+	return sd, nil
+}
+
+// Read a streamed sample into Data, so it can be edited or saved like other samples.
+// Does nothing for samples that aren't streamed.
+func (s *Sample) LoadStream() error {
+	if s.Stream == nil {
+		return nil
+	}
+	sd, err := s.Stream.Read(0, s.Stream.Frames)
+	if err != nil {
+		return err
+	}
+	s.Data = sd
+	s.Stream = nil
+	return nil
+}
+
+// A copy of the sample with its PCM read into Data if it's streamed (see LoadStream), for
+// code that needs all of the PCM. s itself is returned if it isn't streamed.
+func (s *Sample) WithLoadedStream() (*Sample, error) {
+	if s.Stream == nil {
+		return s, nil
+	}
+	loaded := *s
+	if err := loaded.LoadStream(); err != nil {
+		return nil, err
+	}
+	return &loaded, nil
+}
+
+// A copy of the module with streamed samples read into memory, for exporters and analysis
+// that need all of the PCM. The copy shares everything but the Samples slice with m, and
+// m itself is returned if no samples are streamed.
+func (m *Module) WithLoadedStreams() (*Module, error) {
+	if !slices.ContainsFunc(m.Samples, func(s Sample) bool { return s.Stream != nil }) {
+		return m, nil
+	}
+	loaded := *m
+	loaded.Samples = slices.Clone(m.Samples)
+	for i := range loaded.Samples {
+		if err := loaded.Samples[i].LoadStream(); err != nil {
+			return nil, fmt.Errorf("sample %d: %w", i+1, err)
+		}
+	}
+	return &loaded, nil
+}
@@ -0,0 +1,165 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package common
+
+import (
+	"errors"
+	"math"
+)
+
+// Returned when a stretch or resample factor is not a positive number.
+var ErrInvalidRatio = errors.New("invalid ratio")
+
+const (
+	stretchWindow    = 1024                // Frames per WSOLA grain.
+	stretchHop       = stretchWindow / 2   // Synthesis hop; grains overlap by half.
+	stretchTolerance = stretchWindow / 4   // How far a grain may move to find a better match.
+	stretchCorrLen   = stretchWindow / 2   // Frames compared when aligning grains.
+	stretchMinWeight = 1e-6                // Below this the overlap-add weight is treated as 0.
+	stretchMaxRatio  = stretchWindow / 4.0 // Upper limit for ratios, to keep the hop nonzero.
+)
+
+// Read from a signal with zero padding outside of its bounds.
+func padded(x []float64, i int) float64 {
+	if i < 0 || i >= len(x) {
+		return 0
+	}
+	return x[i]
+}
+
+// Find the grain position within the tolerance of `nominal` that best continues the audio
+// at `natural`.
+func bestGrain(mono []float64, nominal int, natural int) int {
+	best := max(nominal, 0)
+	bestCorr := math.Inf(-1)
+	for pos := max(nominal-stretchTolerance, 0); pos <= nominal+stretchTolerance; pos++ {
+		if pos >= len(mono) {
+			break
+		}
+		corr := 0.0
+		for k := 0; k < stretchCorrLen; k++ {
+			corr += padded(mono, pos+k) * padded(mono, natural+k)
+		}
+		if corr > bestCorr {
+			bestCorr = corr
+			best = pos
+		}
+	}
+	return best
+}
+
+// Change the length of the audio without changing its pitch, using WSOLA (waveform
+// similarity overlap-add). ratio is the new length over the old length, so 2 makes the
+// sample twice as long. Grains are aligned on a mono mixdown so channels stay in phase. The
+// result has the same bit depth and channel count. Loop points are not part of SampleData,
+// so callers should scale them separately.
+func (sd *SampleData) TimeStretch(ratio float64) (SampleData, error) {
+	if !(ratio > 0) || ratio > stretchMaxRatio {
+		return SampleData{}, ErrInvalidRatio
+	}
+
+	length := sd.Len()
+	if length == 0 {
+		return *sd, nil
+	}
+
+	input := sd.Float64()
+	mono := make([]float64, length)
+	for _, ch := range input {
+		for i, v := range ch {
+			mono[i] += v / float64(len(input))
+		}
+	}
+
+	window := make([]float64, stretchWindow)
+	for i := range window {
+		window[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/stretchWindow)
+	}
+
+	outLength := int(math.Round(float64(length) * ratio))
+	output := make([][]float64, len(input))
+	for ch := range output {
+		output[ch] = make([]float64, outLength+stretchWindow)
+	}
+	weights := make([]float64, outLength+stretchWindow)
+
+	analysisHop := float64(stretchHop) / ratio
+	prevPos := 0
+	for k := 0; k*stretchHop < outLength; k++ {
+		pos := 0
+		if k > 0 {
+			nominal := int(math.Round(float64(k) * analysisHop))
+			pos = bestGrain(mono, nominal, prevPos+stretchHop)
+		}
+
+		outPos := k * stretchHop
+		for i, w := range window {
+			for ch := range input {
+				output[ch][outPos+i] += padded(input[ch], pos+i) * w
+			}
+			weights[outPos+i] += w
+		}
+		prevPos = pos
+	}
+
+	for ch := range output {
+		for i := 0; i < outLength; i++ {
+			if weights[i] > stretchMinWeight {
+				output[ch][i] /= weights[i]
+			}
+		}
+		output[ch] = output[ch][:outLength]
+	}
+
+	return FromFloat64(output, sd.Bits)
+}
+
+// Resample the audio with linear interpolation. factor is the new length over the old
+// length; playing the result at the same rate lowers the pitch when factor > 1.
+func (sd *SampleData) Resample(factor float64) (SampleData, error) {
+	if !(factor > 0) {
+		return SampleData{}, ErrInvalidRatio
+	}
+
+	length := sd.Len()
+	if length == 0 {
+		return *sd, nil
+	}
+
+	input := sd.Float64()
+	outLength := max(int(math.Round(float64(length)*factor)), 1)
+	output := make([][]float64, len(input))
+	for ch := range input {
+		output[ch] = make([]float64, outLength)
+		for i := range output[ch] {
+			pos := float64(i) / factor
+			index := int(pos)
+			frac := pos - float64(index)
+			a := padded(input[ch], index)
+			b := padded(input[ch], min(index+1, length-1))
+			output[ch][i] = a + (b-a)*frac
+		}
+	}
+
+	return FromFloat64(output, sd.Bits)
+}
+
+// Change the pitch of the audio by a number of semitones without changing its length. The
+// audio is time-stretched and then resampled back to the original length.
+func (sd *SampleData) PitchShift(semitones float64) (SampleData, error) {
+	factor := math.Pow(2, semitones/12)
+
+	stretched, err := sd.TimeStretch(factor)
+	if err != nil {
+		return SampleData{}, err
+	}
+
+	length := sd.Len()
+	if length == 0 {
+		return stretched, nil
+	}
+
+	return stretched.Resample(float64(length) / float64(stretched.Len()))
+}
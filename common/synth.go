@@ -0,0 +1,358 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package common
+
+import "math"
+
+/*
+SynthVoice plays a note of a synthesized instrument (SynthPatch) one tick at a time, the
+way the AHX replayer does. Every tick runs the performance list, moves the square and
+filter modulation and slides the pitch, and the result is one cycle of a waveform that is
+looped until the next tick.
+
+https://github.com/pete-gordon/hivelytracker/blob/master/Replayer_Windows/hvl_replay.c
+*/
+
+// Length of the looped noise waveform.
+const synthNoiseLength = 4096
+
+// Filter position that leaves the waveform unfiltered. Positions 1-31 are lowpass and
+// 33-63 are highpass, getting weaker toward the middle.
+const synthFilterOff = 32
+
+// Performance list effects.
+const (
+	synthFxFilter    = 0x0 // Set the filter position
+	synthFxSlideUp   = 0x1
+	synthFxSlideDown = 0x2
+	synthFxSquare    = 0x3 // Set the square width
+	synthFxModulate  = 0x4 // Toggle square (low nibble) and filter (high nibble) modulation
+	synthFxJump      = 0x5 // Jump to a step, 1 = first
+	synthFxVolume    = 0xC
+	synthFxSpeed     = 0xF
+)
+
+func iif[T any](cond bool, a, b T) T {
+	if cond {
+		return a
+	}
+	return b
+}
+
+// Amiga period of a note, 214 at C-5. Pitch slides in the performance list are in
+// periods.
+func synthPeriod(note int) float64 {
+	return 214 * math.Exp2(float64(60-min(max(note, 0), 119))/12)
+}
+
+// The square width or filter position moving back and forth between two limits.
+type synthModulation struct {
+	on        bool
+	init      bool // Just turned on: slide into the limits if outside of them.
+	slidingIn bool
+	pos       int
+	sign      int
+	wait      int
+	lower     int
+	upper     int
+}
+
+// Toggle the modulation. Modulation starts moving up, or down if down is set.
+func (m *synthModulation) toggle(down bool) {
+	m.on = !m.on
+	m.init = m.on
+	m.sign = 1
+	if down {
+		m.sign = -1
+	}
+}
+
+// Move the position by one step.
+func (m *synthModulation) step() {
+	if m.init {
+		m.init = false
+		if m.pos <= m.lower {
+			m.slidingIn, m.sign = true, 1
+		} else if m.pos >= m.upper {
+			m.slidingIn, m.sign = true, -1
+		}
+	}
+	if m.pos == m.lower || m.pos == m.upper {
+		if m.slidingIn {
+			m.slidingIn = false
+		} else {
+			m.sign = -m.sign
+		}
+	}
+	m.pos += m.sign
+}
+
+// Plays a synthesized instrument. Create it with NewSynthVoice when the note starts and
+// call Tick once per tick.
+type SynthVoice struct {
+	patch *SynthPatch
+	note  int // The played note, 0-119.
+
+	waveform   int16
+	waveLength int // 0-5
+	stepNote   int // From the performance list, relative to note unless fixed.
+	fixed      bool
+
+	step  int // Next performance list step.
+	wait  int // Ticks until it runs.
+	speed int
+
+	slideOn    bool
+	slideSpeed float64
+	slide      float64 // Periods added to the note's.
+
+	volume int
+
+	square synthModulation
+	filter synthModulation
+
+	cycle []int8
+}
+
+// Start a note. note is 0-119 (60 = C-5) and volume is the instrument volume, 0-64. The
+// first tick hasn't run yet, so Waveform has the waveform the patch starts with.
+func NewSynthVoice(patch *SynthPatch, note int, volume int) *SynthVoice {
+	v := &SynthVoice{
+		patch:      patch,
+		note:       note,
+		waveform:   SynthWaveTriangle,
+		waveLength: int(min(max(patch.WaveLength, 0), 5)),
+		speed:      int(patch.PerformanceSpeed),
+		volume:     volume,
+	}
+	for _, step := range patch.Performance {
+		if step.Waveform != SynthWaveKeep {
+			v.waveform = min(step.Waveform, SynthWaveNoise)
+			break
+		}
+	}
+
+	// Square positions are in steps of the cycle, which has 4<<waveLength points and
+	// 64ths of it at the longest.
+	shift := 5 - v.waveLength
+	v.square.lower = int(patch.SquareLower) >> shift
+	v.square.upper = int(patch.SquareUpper) >> shift
+	v.square.pos = v.square.lower
+	if v.square.pos == 0 {
+		v.square.pos = 32 >> shift
+	}
+	if v.square.lower > v.square.upper {
+		v.square.lower, v.square.upper = v.square.upper, v.square.lower
+	}
+
+	v.filter.lower = int(patch.FilterLower)
+	v.filter.upper = int(patch.FilterUpper)
+	if v.filter.lower > v.filter.upper {
+		v.filter.lower, v.filter.upper = v.filter.upper, v.filter.lower
+	}
+	v.filter.pos = synthFilterOff
+
+	v.render()
+	return v
+}
+
+// Run the next tick. Returns true if the waveform changed.
+func (v *SynthVoice) Tick() bool {
+	changed := false
+
+	if v.step < len(v.patch.Performance) {
+		v.wait--
+		if v.wait <= 0 {
+			changed = v.runStep() || changed
+		}
+	}
+
+	if v.slideOn {
+		v.slide -= v.slideSpeed
+	}
+
+	if v.square.on {
+		v.square.wait--
+		if v.square.wait <= 0 {
+			v.square.step()
+			v.square.wait = int(v.patch.SquareSpeed)
+			changed = true
+		}
+	}
+
+	if v.filter.on {
+		v.filter.wait--
+		if v.filter.wait <= 0 {
+			// Low speeds move more than one step per tick.
+			speed := int(v.patch.FilterSpeed)
+			for range iif(speed < 3, 5-speed, 1) {
+				v.filter.step()
+			}
+			v.filter.pos = min(max(v.filter.pos, 1), 63)
+			v.filter.wait = max(speed-3, 1)
+			changed = true
+		}
+	}
+
+	if changed {
+		v.render()
+	}
+	return changed
+}
+
+// Run the next performance list step. Returns true if the waveform needs to be rendered
+// again.
+func (v *SynthVoice) runStep() bool {
+	step := v.patch.Performance[v.step]
+	v.step++
+	v.wait = v.speed
+	changed := false
+
+	if step.Waveform != SynthWaveKeep {
+		v.waveform = min(step.Waveform, SynthWaveNoise)
+		v.slideSpeed, v.slide = 0, 0
+		changed = true
+	}
+	v.slideOn = false
+
+	for i, effect := range step.Effects {
+		param := int(step.Params[i])
+		switch effect {
+		case synthFxFilter:
+			if param != 0 {
+				v.filter.pos = min(param, 63)
+				changed = true
+			}
+		case synthFxSlideUp:
+			v.slideOn, v.slideSpeed = true, float64(param)
+		case synthFxSlideDown:
+			v.slideOn, v.slideSpeed = true, -float64(param)
+		case synthFxSquare:
+			v.square.pos = param >> (5 - v.waveLength)
+			changed = true
+		case synthFxModulate:
+			if param == 0 || param&0x0F != 0 {
+				v.square.toggle(param&0x0F == 0x0F)
+			}
+			if param&0xF0 != 0 {
+				v.filter.toggle(param&0xF0 == 0xF0)
+			}
+		case synthFxJump:
+			v.step = max(param-1, 0)
+			v.wait = 1
+		case synthFxVolume:
+			// Higher values set the volume of other voices, which isn't supported.
+			if param <= 64 {
+				v.volume = param
+			}
+		case synthFxSpeed:
+			v.speed, v.wait = param, param
+		}
+	}
+
+	if step.Note != 0 {
+		v.stepNote, v.fixed = int(step.Note), step.Fixed
+	}
+	return changed
+}
+
+// The current cycle of the waveform, or a longer looped stretch for noise.
+func (v *SynthVoice) Waveform() []int8 {
+	return v.cycle
+}
+
+// The playback rate relative to the played note's, from performance list notes and
+// slides.
+func (v *SynthVoice) Pitch() float64 {
+	note := v.note + v.stepNote
+	if v.fixed {
+		note = v.stepNote
+	}
+	period := max(synthPeriod(note)+v.slide, 1)
+	return synthPeriod(v.note) / period
+}
+
+// The note volume, 0-64. It starts as the instrument volume and can be changed by the
+// performance list.
+func (v *SynthVoice) Volume() int {
+	return v.volume
+}
+
+// Render the waveform with the current square width and filter.
+func (v *SynthVoice) render() {
+	length := 4 << v.waveLength
+	data := make([]int8, length)
+
+	switch v.waveform {
+	case SynthWaveSawtooth:
+		for i := range data {
+			data[i] = int8(-128 + 256*i/length)
+		}
+	case SynthWaveSquare:
+		width := v.square.pos << (5 - v.waveLength)
+		split := length * min(max(width, 1), 63) / 64
+		for i := range data {
+			data[i] = 127
+			if i < split {
+				data[i] = -128
+			}
+		}
+	case SynthWaveNoise:
+		data = make([]int8, synthNoiseLength)
+		state := uint32(0x41595321)
+		for i := range data {
+			state = state*1103515245 + 12345
+			data[i] = int8(state >> 16)
+		}
+	default:
+		// Triangle, starting at 0 and rising.
+		quarter := length / 4
+		for i := range data {
+			var value int
+			switch {
+			case i < quarter:
+				value = i * 128 / quarter
+			case i < quarter*3:
+				value = 128 - (i-quarter)*128/quarter
+			default:
+				value = (i-quarter*3)*128/quarter - 128
+			}
+			data[i] = int8(min(value, 127))
+		}
+	}
+
+	if v.filter.pos != synthFilterOff {
+		filterWaveform(data, v.filter.pos)
+	}
+	v.cycle = data
+}
+
+// Filter a looped waveform in place with AHX's state variable filter. Positions below
+// synthFilterOff are lowpass and the rest are highpass.
+func filterWaveform(data []int8, pos int) {
+	index := pos - 1
+	if pos > synthFilterOff {
+		index = pos - synthFilterOff - 1
+	}
+	frequency := float64(8+3*index) * 1.25 / 100
+
+	clip := func(x float64) float64 {
+		return min(max(x, -128), 127)
+	}
+	var low, band float64
+	output := make([]int8, len(data))
+	// The first pass settles the filter so the loop is seamless.
+	for pass := range 2 {
+		for i, x := range data {
+			high := clip(float64(x) - band - low)
+			band = clip(band + high*frequency)
+			low = clip(low + band*frequency)
+			if pass == 1 {
+				output[i] = int8(iif(pos < synthFilterOff, low, high))
+			}
+		}
+	}
+	copy(data, output)
+}
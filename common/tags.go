@@ -0,0 +1,86 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package common
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Tag names returned by Module.Tags. They're Vorbis comment field names, which music
+// library software reads, and which map directly to ID3 frames.
+const (
+	TagTitle       = "TITLE"       // ID3 TIT2
+	TagArtist      = "ARTIST"      // ID3 TPE1
+	TagComment     = "COMMENT"     // ID3 COMM: the song message.
+	TagDescription = "DESCRIPTION" // Instrument and sample names, one per line.
+	TagEncoder     = "ENCODER"     // ID3 TSSE: the tracker that wrote the file.
+	TagLength      = "LENGTH"      // ID3 TLEN: milliseconds, set by analysis.Tags.
+)
+
+// "by NAME" credits, ending at the end of the line or a separator.
+var artistPattern = regexp.MustCompile(`(?i)\b(?:composed|written|made|music)?\s*by\s*:?\s+([^\n,;:()\[\]<>]+)`)
+
+// Words that end a credit, like "by Someone in 1996".
+var artistEnd = regexp.MustCompile(`(?i)\s+(?:in|on|at|for|from|using|with|-|/\*)\s.*$`)
+
+// The module's text as tags for music library software. Tags without a value are left
+// out. LENGTH isn't included since it needs the song to be played; see analysis.Tags.
+//
+// ARTIST is a guess from the first "by NAME" credit in the song message or, failing
+// that, the instrument and sample names, where old trackers without messages kept it.
+func (m *Module) Tags() map[string]string {
+	tags := make(map[string]string)
+	set := func(key, value string) {
+		if value = strings.TrimSpace(value); value != "" {
+			tags[key] = value
+		}
+	}
+
+	set(TagTitle, m.Title)
+	message := strings.ReplaceAll(strings.ReplaceAll(m.Message, "\r\n", "\n"), "\r", "\n")
+	set(TagComment, message)
+
+	var names []string
+	addName := func(name string) {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	for i := range m.Instruments {
+		addName(m.Instruments[i].Name)
+	}
+	for i := range m.Samples {
+		addName(m.Samples[i].Name)
+	}
+	set(TagDescription, strings.Join(names, "\n"))
+
+	artist := guessArtist(message)
+	if artist == "" {
+		artist = guessArtist(strings.Join(names, "\n"))
+	}
+	set(TagArtist, artist)
+
+	switch d := m.Details.(type) {
+	case *ItDetails:
+		set(TagEncoder, d.Tracker)
+	case *S3mDetails:
+		set(TagEncoder, d.Tracker)
+	}
+	if tags[TagEncoder] == "Unknown" {
+		delete(tags, TagEncoder)
+	}
+	return tags
+}
+
+// Find the first "by NAME" credit in text.
+func guessArtist(text string) string {
+	match := artistPattern.FindStringSubmatch(text)
+	if match == nil {
+		return ""
+	}
+	name := artistEnd.ReplaceAllString(match[1], "")
+	return strings.Trim(name, " .!-_*=~")
+}
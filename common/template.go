@@ -0,0 +1,81 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package common
+
+import (
+	"regexp"
+	"slices"
+)
+
+// A starting point for new songs, like a studio kit: the channel layout, instruments,
+// samples and settings of Module are copied into every module made from it. Text can hold
+// placeholders like {{artist}}, which are filled in when a module is made.
+type Template struct {
+	Module *Module
+
+	// Rows of the empty pattern that new modules start with when Module has no patterns.
+	// 0 is 64 rows.
+	Rows int
+}
+
+// {{name}} in template text.
+var placeholderPattern = regexp.MustCompile(`\{\{\s*(\w+)\s*\}\}`)
+
+// Make a new module from the template. Placeholders in the title, message and channel,
+// instrument, sample and pattern names are replaced with values; placeholders without a
+// value are left as they are. Sample PCM is shared with the template.
+func (t *Template) New(values map[string]string) *Module {
+	m := t.Module.clone((*SampleData).share)
+	resolve := func(s *string) {
+		*s = placeholderPattern.ReplaceAllStringFunc(*s, func(p string) string {
+			if v, ok := values[placeholderPattern.FindStringSubmatch(p)[1]]; ok {
+				return v
+			}
+			return p
+		})
+	}
+	m.eachText(resolve)
+
+	if len(m.Patterns) == 0 {
+		rows := t.Rows
+		if rows <= 0 {
+			rows = 64
+		}
+		m.Patterns = []Pattern{{Channels: m.Channels, Rows: make([]PatternRow, rows)}}
+		m.Order = []int16{0}
+	}
+	return m
+}
+
+// The placeholder names used in the template's text, sorted, e.g. to ask the user for
+// their values.
+func (t *Template) Placeholders() []string {
+	var names []string
+	t.Module.eachText(func(s *string) {
+		for _, match := range placeholderPattern.FindAllStringSubmatch(*s, -1) {
+			names = append(names, match[1])
+		}
+	})
+	slices.Sort(names)
+	return slices.Compact(names)
+}
+
+// Call fn with each text field of the module that a template can have placeholders in.
+func (m *Module) eachText(fn func(s *string)) {
+	fn(&m.Title)
+	fn(&m.Message)
+	for i := range m.ChannelSettings {
+		fn(&m.ChannelSettings[i].Name)
+	}
+	for i := range m.Instruments {
+		fn(&m.Instruments[i].Name)
+	}
+	for i := range m.Samples {
+		fn(&m.Samples[i].Name)
+	}
+	for i := range m.Patterns {
+		fn(&m.Patterns[i].Name)
+	}
+}
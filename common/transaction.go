@@ -0,0 +1,197 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package common
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// Returned when a transaction is used after it was committed or rolled back.
+var ErrTransactionClosed = errors.New("transaction is closed")
+
+// Returned when an edit refers to something that doesn't exist in the module.
+var ErrOutOfRange = errors.New("index out of range")
+
+// A reversible change. Patches hold the old and new values of what they touched, so they
+// can be applied in either direction without snapshotting the module.
+type patch struct {
+	undo func(m *Module)
+	redo func(m *Module)
+}
+
+// A group of changes made to a module in a transaction. Undo and Redo must be called in
+// stack order with the other edits made to the same module.
+type Edit struct {
+	Name    string
+	patches []patch
+}
+
+// Revert the changes.
+func (e *Edit) Undo(m *Module) {
+	for i := len(e.patches) - 1; i >= 0; i-- {
+		e.patches[i].undo(m)
+	}
+}
+
+// Apply the changes again after Undo.
+func (e *Edit) Redo(m *Module) {
+	for _, p := range e.patches {
+		p.redo(m)
+	}
+}
+
+// Records changes to a module as they are made. Commit to get an Edit for the undo stack,
+// or Rollback to revert everything. Values passed in are stored as-is, so they shouldn't
+// be modified afterwards.
+type Transaction struct {
+	module *Module
+	edit   *Edit
+	closed bool
+}
+
+// Start recording changes to the module.
+func (m *Module) Begin(name string) *Transaction {
+	return &Transaction{module: m, edit: &Edit{Name: name}}
+}
+
+// Apply a patch and record it.
+func (tx *Transaction) apply(p patch) error {
+	if tx.closed {
+		return ErrTransactionClosed
+	}
+	p.redo(tx.module)
+	tx.edit.patches = append(tx.edit.patches, p)
+	return nil
+}
+
+// Make a custom change. do applies it and undo reverts it.
+func (tx *Transaction) Do(do func(m *Module), undo func(m *Module)) error {
+	return tx.apply(patch{undo: undo, redo: do})
+}
+
+// Set a pattern cell. Setting an empty entry clears the cell.
+func (tx *Transaction) SetEntry(pattern, row int, entry PatternEntry) error {
+	m := tx.module
+	if pattern < 0 || pattern >= len(m.Patterns) || row < 0 || row >= len(m.Patterns[pattern].Rows) {
+		return fmt.Errorf("%w: pattern %d row %d", ErrOutOfRange, pattern, row)
+	}
+	old := m.Patterns[pattern].Rows[row].Get(int(entry.Channel))
+	return tx.apply(patch{
+		undo: func(m *Module) { m.Patterns[pattern].Rows[row].Set(old) },
+		redo: func(m *Module) { m.Patterns[pattern].Rows[row].Set(entry) },
+	})
+}
+
+// Replace a pattern.
+func (tx *Transaction) SetPattern(index int, p Pattern) error {
+	m := tx.module
+	if index < 0 || index >= len(m.Patterns) {
+		return fmt.Errorf("%w: pattern %d", ErrOutOfRange, index)
+	}
+	old := m.Patterns[index]
+	return tx.apply(patch{
+		undo: func(m *Module) { m.Patterns[index] = old },
+		redo: func(m *Module) { m.Patterns[index] = p },
+	})
+}
+
+// Replace a sample.
+func (tx *Transaction) SetSample(index int, s Sample) error {
+	m := tx.module
+	if index < 0 || index >= len(m.Samples) {
+		return fmt.Errorf("%w: sample %d", ErrOutOfRange, index)
+	}
+	old := m.Samples[index]
+	return tx.apply(patch{
+		undo: func(m *Module) { m.Samples[index] = old },
+		redo: func(m *Module) { m.Samples[index] = s },
+	})
+}
+
+// Replace an instrument.
+func (tx *Transaction) SetInstrument(index int, ins Instrument) error {
+	m := tx.module
+	if index < 0 || index >= len(m.Instruments) {
+		return fmt.Errorf("%w: instrument %d", ErrOutOfRange, index)
+	}
+	old := m.Instruments[index]
+	return tx.apply(patch{
+		undo: func(m *Module) { m.Instruments[index] = old },
+		redo: func(m *Module) { m.Instruments[index] = ins },
+	})
+}
+
+// Replace the order list.
+func (tx *Transaction) SetOrder(order []int16) error {
+	old := tx.module.Order
+	order = slices.Clone(order)
+	return tx.apply(patch{
+		undo: func(m *Module) { m.Order = old },
+		redo: func(m *Module) { m.Order = order },
+	})
+}
+
+// Finish the transaction and return the recorded changes.
+func (tx *Transaction) Commit() (*Edit, error) {
+	if tx.closed {
+		return nil, ErrTransactionClosed
+	}
+	tx.closed = true
+	return tx.edit, nil
+}
+
+// Revert all changes made in the transaction.
+func (tx *Transaction) Rollback() error {
+	if tx.closed {
+		return ErrTransactionClosed
+	}
+	tx.closed = true
+	tx.edit.Undo(tx.module)
+	return nil
+}
+
+// An undo/redo stack of edits for one module.
+type History struct {
+	module *Module
+	done   []*Edit
+	undone []*Edit
+}
+
+// Create an empty history for the module.
+func NewHistory(m *Module) *History {
+	return &History{module: m}
+}
+
+// Add a committed edit. This clears the redo stack.
+func (h *History) Push(e *Edit) {
+	h.done = append(h.done, e)
+	h.undone = nil
+}
+
+// Revert the last edit. Returns nil if there is nothing to undo.
+func (h *History) Undo() *Edit {
+	if len(h.done) == 0 {
+		return nil
+	}
+	e := h.done[len(h.done)-1]
+	h.done = h.done[:len(h.done)-1]
+	e.Undo(h.module)
+	h.undone = append(h.undone, e)
+	return e
+}
+
+// Apply the last undone edit again. Returns nil if there is nothing to redo.
+func (h *History) Redo() *Edit {
+	if len(h.undone) == 0 {
+		return nil
+	}
+	e := h.undone[len(h.undone)-1]
+	h.undone = h.undone[:len(h.undone)-1]
+	e.Redo(h.module)
+	h.done = append(h.done, e)
+	return e
+}
@@ -0,0 +1,267 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package common
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Returned when a WAV file can't be read as a sample.
+var ErrInvalidWav = errors.New("invalid wav file")
+
+const (
+	wavFormatPcm        = 1
+	wavFormatFloat      = 3
+	wavFormatExtensible = 0xFFFE
+)
+
+// Size of the header written by WriteWavHeader.
+const WavHeaderSize = 44
+
+type wavFormat struct {
+	AudioFormat   uint16
+	Channels      uint16
+	SampleRate    uint32
+	ByteRate      uint32
+	BlockAlign    uint16
+	BitsPerSample uint16
+}
+
+// Header of the "smpl" chunk, followed by the loops.
+type wavSampler struct {
+	Manufacturer      uint32
+	Product           uint32
+	SamplePeriod      uint32
+	MidiUnityNote     uint32
+	MidiPitchFraction uint32
+	SmpteFormat       uint32
+	SmpteOffset       uint32
+	LoopCount         uint32
+	SamplerData       uint32
+}
+
+type wavLoop struct {
+	CuePointID uint32
+	Type       uint32 // 0 = forward, 1 = ping-pong
+	Start      uint32
+	End        uint32 // Inclusive
+	Fraction   uint32
+	PlayCount  uint32
+}
+
+func riffChunk(id string, data []byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(id)
+	binary.Write(&buf, binary.LittleEndian, uint32(len(data)))
+	buf.Write(data)
+	if len(data)%2 != 0 {
+		buf.WriteByte(0)
+	}
+	return buf.Bytes()
+}
+
+// Write the start of a PCM WAV file, up to the PCM: the RIFF header, the fmt chunk and the
+// header of a data chunk of dataBytes. trailing is the size of the chunks after the data,
+// for the RIFF size. Writers that don't know the sizes yet can write the header again
+// when they do, since its size is always WavHeaderSize.
+func WriteWavHeader(w io.Writer, rate, channels, bits, dataBytes, trailing int) error {
+	var header bytes.Buffer
+	header.WriteString("RIFF")
+	binary.Write(&header, binary.LittleEndian, uint32(WavHeaderSize-8+dataBytes+dataBytes%2+trailing))
+	header.WriteString("WAVE")
+	var format bytes.Buffer
+	binary.Write(&format, binary.LittleEndian, wavFormat{
+		AudioFormat:   wavFormatPcm,
+		Channels:      uint16(channels),
+		SampleRate:    uint32(rate),
+		ByteRate:      uint32(rate * channels * bits / 8),
+		BlockAlign:    uint16(channels * bits / 8),
+		BitsPerSample: uint16(bits),
+	})
+	header.Write(riffChunk("fmt ", format.Bytes()))
+	header.WriteString("data")
+	binary.Write(&header, binary.LittleEndian, uint32(dataBytes))
+	_, err := w.Write(header.Bytes())
+	return err
+}
+
+// Write a sample as a WAV file. 8-bit samples are written as unsigned 8-bit PCM, and the
+// loop is stored in a "smpl" chunk. If the sample has both loops, the sustain loop is
+// written (see ExportLoop).
+func WriteWav(w io.Writer, s *Sample) error {
+	s, err := s.WithLoadedStream()
+	if err != nil {
+		return err
+	}
+	channels := max(len(s.Data.Data), 1)
+	bits := 16
+	if s.Data.Bits == 8 {
+		bits = 8
+	}
+
+	var pcm bytes.Buffer
+	for _, frame := range s.Data.Frames() {
+		for _, v := range frame {
+			if bits == 8 {
+				pcm.WriteByte(byte(int8(math.Round(v*128))) ^ 0x80)
+			} else {
+				binary.Write(&pcm, binary.LittleEndian, int16(math.Round(v*32768)))
+			}
+		}
+	}
+
+	var smpl []byte
+	if start, end, pingpong, ok := s.ExportLoop(); ok {
+		var buf bytes.Buffer
+		binary.Write(&buf, binary.LittleEndian, wavSampler{
+			SamplePeriod:  uint32(1e9 / max(s.C5, 1)),
+			MidiUnityNote: 60,
+			LoopCount:     1,
+		})
+		loop := wavLoop{Start: uint32(start), End: uint32(end - 1)}
+		if pingpong {
+			loop.Type = 1
+		}
+		binary.Write(&buf, binary.LittleEndian, loop)
+		smpl = riffChunk("smpl", buf.Bytes())
+	}
+
+	if err := WriteWavHeader(w, max(s.C5, 1), channels, bits, pcm.Len(), len(smpl)); err != nil {
+		return err
+	}
+	if pcm.Len()%2 != 0 {
+		pcm.WriteByte(0)
+	}
+	if _, err := w.Write(pcm.Bytes()); err != nil {
+		return err
+	}
+	_, err = w.Write(smpl)
+	return err
+}
+
+// The loop to keep when exporting to formats with a single loop, like WAV or SFZ: the
+// sustain loop if there is one, otherwise the normal loop. end is exclusive.
+func (s *Sample) ExportLoop() (start, end int, pingpong, ok bool) {
+	switch {
+	case s.Sustain && s.SustainLoopEnd > s.SustainLoopStart:
+		return s.SustainLoopStart, s.SustainLoopEnd, s.PingPongSustain, true
+	case s.Loop && s.LoopEnd > s.LoopStart:
+		return s.LoopStart, s.LoopEnd, s.PingPong, true
+	}
+	return 0, 0, false, false
+}
+
+// Decode one PCM value to [-1, 1).
+func decodePcm(b []byte, format *wavFormat) float64 {
+	switch {
+	case format.AudioFormat == wavFormatFloat && len(b) == 4:
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+	case format.AudioFormat == wavFormatFloat && len(b) == 8:
+		return math.Float64frombits(binary.LittleEndian.Uint64(b))
+	case len(b) == 1:
+		return float64(int(b[0])-128) / 128
+	}
+	// Signed little-endian, any width. Only the top 16 bits matter.
+	v := int32(int8(b[len(b)-1])) << 8
+	if len(b) > 1 {
+		v |= int32(b[len(b)-2])
+	}
+	return float64(v) / 32768
+}
+
+// Read a WAV file as a sample. 8-bit and 16-bit PCM are kept at their depth, while 24-bit,
+// 32-bit and float data become 16-bit. The first loop in a "smpl" chunk becomes the
+// sample loop. Files with more than two channels are rejected.
+func ReadWav(r io.Reader) (Sample, error) {
+	var sample Sample
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return sample, err
+	}
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WAVE" {
+		return sample, fmt.Errorf("%w: missing RIFF/WAVE header", ErrInvalidWav)
+	}
+
+	var format *wavFormat
+	var pcm []byte
+	var loops []wavLoop
+	for data = data[12:]; len(data) >= 8; {
+		id := string(data[:4])
+		size := int(binary.LittleEndian.Uint32(data[4:]))
+		if size > len(data)-8 {
+			size = len(data) - 8 // Truncated files are common; take what's there.
+		}
+		body := data[8 : 8+size]
+		data = data[min(8+size+size%2, len(data)):]
+
+		switch id {
+		case "fmt ":
+			format = &wavFormat{}
+			if err := binary.Read(bytes.NewReader(body), binary.LittleEndian, format); err != nil {
+				return sample, fmt.Errorf("%w: short fmt chunk", ErrInvalidWav)
+			}
+			if format.AudioFormat == wavFormatExtensible && len(body) >= 26 {
+				format.AudioFormat = binary.LittleEndian.Uint16(body[24:])
+			}
+		case "data":
+			pcm = body
+		case "smpl":
+			var header wavSampler
+			reader := bytes.NewReader(body)
+			if binary.Read(reader, binary.LittleEndian, &header) == nil && header.LoopCount > 0 {
+				loops = make([]wavLoop, 1)
+				if binary.Read(reader, binary.LittleEndian, loops) != nil {
+					loops = nil
+				}
+			}
+		}
+	}
+
+	if format == nil || pcm == nil {
+		return sample, fmt.Errorf("%w: missing fmt or data chunk", ErrInvalidWav)
+	}
+	if format.AudioFormat != wavFormatPcm && format.AudioFormat != wavFormatFloat {
+		return sample, fmt.Errorf("%w: unsupported encoding %d", ErrInvalidWav, format.AudioFormat)
+	}
+	channels := int(format.Channels)
+	width := int(format.BitsPerSample+7) / 8
+	if channels < 1 || channels > 2 || width < 1 || width > 8 {
+		return sample, fmt.Errorf("%w: %d channels of %d-bit data", ErrInvalidWav, channels, format.BitsPerSample)
+	}
+
+	frames := len(pcm) / (channels * width)
+	float := make([][]float64, channels)
+	for ch := range float {
+		float[ch] = make([]float64, frames)
+		for i := range frames {
+			offset := (i*channels + ch) * width
+			float[ch][i] = decodePcm(pcm[offset:offset+width], format)
+		}
+	}
+
+	bits := int8(16)
+	if width == 1 {
+		bits = 8
+	}
+	sample.Data, _ = FromFloat64(float, bits)
+	sample.S16 = bits == 16
+	sample.Stereo = channels == 2
+	sample.C5 = int(format.SampleRate)
+	sample.GlobalVolume = 64
+	sample.DefaultVolume = 64
+
+	if len(loops) > 0 && int(loops[0].Start) < int(loops[0].End) && int(loops[0].End) < frames {
+		sample.Loop = true
+		sample.PingPong = loops[0].Type == 1
+		sample.LoopStart = int(loops[0].Start)
+		sample.LoopEnd = int(loops[0].End) + 1
+	}
+	return sample, nil
+}
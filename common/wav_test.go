@@ -0,0 +1,44 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package common
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWav(t *testing.T) {
+	for _, bits := range []int8{8, 16} {
+		for _, channels := range []int{1, 2} {
+			data := make([][]float64, channels)
+			for ch := range data {
+				data[ch] = make([]float64, 63) // Odd, to pad the 8-bit data chunk.
+				for i := range data[ch] {
+					data[ch][i] = float64(i%16-8) / 8 / float64(ch+1)
+				}
+			}
+			sd, err := FromFloat64(data, bits)
+			assert.NoError(t, err)
+			s := Sample{
+				GlobalVolume: 64, DefaultVolume: 64, C5: 22050,
+				S16: bits == 16, Stereo: channels == 2, Data: sd,
+				Loop: true, PingPong: true, LoopStart: 16, LoopEnd: 48,
+			}
+
+			var buf bytes.Buffer
+			assert.NoError(t, WriteWav(&buf, &s))
+			assert.EqualValues(t, buf.Len()-8, binary.LittleEndian.Uint32(buf.Bytes()[4:]), "RIFF size")
+			read, err := ReadWav(&buf)
+			assert.NoError(t, err)
+			assert.Equal(t, s, read)
+		}
+	}
+
+	_, err := ReadWav(bytes.NewReader([]byte("RIFF\x04\x00\x00\x00AIFF")))
+	assert.ErrorIs(t, err, ErrInvalidWav)
+}
@@ -0,0 +1,172 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package dsmmod
+
+import (
+	"strings"
+
+	"go.mukunda.com/modlib/common"
+	"go.mukunda.com/modlib/modmod"
+)
+
+const (
+	effectS = 19
+	effectX = 24
+)
+
+func (dsm *DsmModule) ToCommon() *common.Module {
+	m := new(common.Module)
+	m.Source = common.DsmSource
+	h := &dsm.Song
+
+	m.Title = strings.TrimRight(string(h.Title[:]), "\000")
+	m.StereoMixing = true
+	m.UseInstruments = false
+	m.LinearSlides = false
+
+	// DSM volumes are 0-64.
+	m.GlobalVolume = int16(min(h.GlobalVolume, 64)) * 2
+	m.MixingVolume = int16(h.MasterVolume & 127)
+	m.InitialSpeed = int16(h.InitialSpeed)
+	if m.InitialSpeed == 0 {
+		m.InitialSpeed = 6
+	}
+	m.InitialTempo = int16(h.InitialTempo)
+	if m.InitialTempo < 32 {
+		m.InitialTempo = 125
+	}
+	m.PanSeparation = 128
+
+	m.Channels = int16(min(h.ChannelCount, DsmMaxChannels))
+	for i := range int(m.Channels) {
+		cs := common.ChannelSetting{InitialVolume: 64, InitialPan: 32}
+		if pan := h.ChannelPan[i]; pan == DsmPanSurround {
+			cs.Surround = true
+		} else if pan <= 128 {
+			cs.InitialPan = int16(pan) / 2
+		}
+		m.ChannelSettings = append(m.ChannelSettings, cs)
+	}
+
+	for _, order := range h.Orders[:min(int(h.OrderCount), len(h.Orders))] {
+		m.Order = append(m.Order, int16(order))
+	}
+
+	for i := range dsm.Samples {
+		m.Samples = append(m.Samples, dsm.Samples[i].ToCommon())
+	}
+
+	for _, pattern := range dsm.Patterns {
+		m.Patterns = append(m.Patterns, pattern.ToCommon())
+	}
+
+	return m
+}
+
+func (s *DsmSample) ToCommon() common.Sample {
+	var cs common.Sample
+	h := &s.Header
+
+	cs.Name = strings.TrimRight(string(h.Name[:]), "\000")
+	cs.DosFilename = strings.TrimRight(string(h.DosFilename[:]), "\000")
+	cs.GlobalVolume = 64
+	cs.DefaultVolume = int16(min(h.Volume, 64))
+	cs.C5 = int(h.SampleRate)
+	cs.S16 = s.Bits == 16
+
+	cs.Loop = h.Flags&SampFlagLoop != 0 && h.LoopEnd > h.LoopStart
+	if cs.Loop {
+		cs.LoopStart = int(h.LoopStart)
+		cs.LoopEnd = int(h.LoopEnd)
+	}
+
+	cs.Data = common.SampleData{Channels: 1, Bits: int8(s.Bits), Data: []any{s.Data}}
+	return cs
+}
+
+// DSM notes are 1-108 starting at C-1.
+func translateNote(note uint8) uint8 {
+	if note == 0 || note > 108 {
+		return 0
+	}
+	return note + 12
+}
+
+func (dp *DsmPattern) ToCommon() common.Pattern {
+	var p common.Pattern
+
+	dataRead := 0
+	data := dp.Data
+
+	nextByte := func() byte {
+		if dataRead >= len(data) {
+			return 0
+		}
+
+		byt := data[dataRead]
+		dataRead++
+		return byt
+	}
+
+	channels := 0
+
+	for row := 0; row < DsmPatternRows; row++ {
+		patternRow := common.PatternRow{}
+		for {
+			what := nextByte()
+			if what == 0 {
+				break
+			}
+
+			entry := common.PatternEntry{}
+
+			channel := int(what & PmaskChannel)
+			entry.Channel = uint8(channel)
+			if channel >= channels {
+				channels = channel + 1
+			}
+
+			if what&PmaskNote != 0 {
+				entry.Note = translateNote(nextByte())
+			}
+
+			if what&PmaskIns != 0 {
+				entry.Instrument = int16(nextByte())
+			}
+
+			if what&PmaskVol != 0 {
+				entry.VolumeCommand = common.VcmdSetVolume
+				entry.VolumeParam = min(nextByte(), 64)
+			}
+
+			if what&PmaskEffect != 0 {
+				effect := nextByte()
+				param := nextByte()
+				translateEffect(&entry, effect, param)
+			}
+
+			patternRow.Entries = append(patternRow.Entries, entry)
+		}
+
+		p.Rows = append(p.Rows, patternRow)
+	}
+
+	p.Channels = int16(channels)
+
+	return p
+}
+
+// DSM uses ProTracker effects, except 8xx panning is 0-128 with 164 for surround.
+func translateEffect(entry *common.PatternEntry, effect, param uint8) {
+	if effect == 0x8 {
+		if param == DsmPanSurround {
+			entry.Effect, entry.EffectParam = effectS, 0x91
+		} else if param <= 128 {
+			entry.Effect, entry.EffectParam = effectX, uint8(min(int(param)*2, 255))
+		}
+		return
+	}
+	modmod.TranslateEffect(entry, effect, param)
+}
@@ -0,0 +1,251 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+/*
+This package is for working with DSIK (Digital Sound Interface Kit) DSM files directly.
+*/
+package dsmmod
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"go.mukunda.com/modlib/common"
+)
+
+// This is used to read DSM files.
+type DsmReader struct {
+	// Enable extra checks that will cause loading errors if incorrect or corrupted data is
+	// detected.
+	Strict bool
+	// Receives debug traces of the file structure, like offsets and chunk sizes, and of
+	// decisions made while loading. Optional.
+	Logger *slog.Logger
+}
+
+// Holds all components of a DSM file.
+type DsmModule struct {
+	Song     DsmSongHeader
+	Samples  []DsmSample
+	Patterns []DsmPattern
+}
+
+// The direct structure of the SONG chunk.
+type DsmSongHeader struct {
+	Title        [28]byte
+	Version      uint16
+	Flags        uint16
+	OrderPos     uint16
+	RestartPos   uint16
+	OrderCount   uint16
+	SampleCount  uint16
+	PatternCount uint16
+	ChannelCount uint16
+	GlobalVolume uint8 // 0-64
+	MasterVolume uint8
+	InitialSpeed uint8
+	InitialTempo uint8
+	ChannelPan   [16]uint8 // 0-128, 164 = surround
+	Orders       [128]uint8
+}
+
+// Sample flags.
+const (
+	SampFlagLoop   = 1
+	SampFlagSigned = 2
+	SampFlag16bit  = 4
+	SampFlagDelta  = 64
+)
+
+// The direct structure of a sample header, at the start of an INST chunk.
+type DsmSampleHeader struct {
+	DosFilename [13]byte
+	Flags       uint16
+	Volume      uint8 // 0-64
+	Length      uint32
+	LoopStart   uint32
+	LoopEnd     uint32
+	DataPointer uint32 // Used by DSIK during playback
+	SampleRate  uint32
+	Name        [28]byte
+}
+
+// Container for the header and data of a sample. Data is decoded into signed PCM.
+type DsmSample struct {
+	Header DsmSampleHeader
+	Bits   uint8
+
+	// Contains []int8 or []int16.
+	Data any
+}
+
+// Container for a pattern. DSM patterns always have 64 rows.
+type DsmPattern struct {
+	// Packed data
+	Data []byte
+}
+
+// Mask constants for the packed pattern data.
+const (
+	PmaskChannel = 15
+	PmaskEffect  = 16
+	PmaskVol     = 32
+	PmaskIns     = 64
+	PmaskNote    = 128
+)
+
+const (
+	DsmPatternRows  = 64
+	DsmPanSurround  = 164
+	DsmMaxChannels  = 16
+	dsmChunkHeader  = 8
+	dsmSampleHeader = 64
+)
+
+var ErrInvalidSource = errors.New("invalid/corrupted source")
+
+// Load a DSM file into memory.
+func LoadDsmFile(filename string) (*DsmModule, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	reader := DsmReader{}
+
+	return reader.ReadDsmModule(f)
+}
+
+// Load a DSM file into memory from the given stream. The file is a RIFF container with a
+// SONG chunk, followed by INST and PATT chunks in the order of their indexes.
+func (reader *DsmReader) ReadDsmModule(r io.Reader) (*DsmModule, error) {
+	file, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	br := bytes.NewReader(file)
+
+	var riff struct {
+		FileCode [4]byte
+		Size     uint32
+		Type     [4]byte
+	}
+	if err := binary.Read(br, binary.LittleEndian, &riff); err != nil {
+		return nil, err
+	}
+
+	if string(riff.FileCode[:]) != "RIFF" || string(riff.Type[:]) != "DSMF" {
+		return nil, fmt.Errorf("%w: expected 'RIFF' header with 'DSMF' type", ErrInvalidSource)
+	}
+
+	dsm := new(DsmModule)
+	hasSong := false
+	for {
+		var chunk struct {
+			ID   [4]byte
+			Size uint32
+		}
+		if err := binary.Read(br, binary.LittleEndian, &chunk); err == io.EOF {
+			break
+		} else if err != nil {
+			if reader.Strict {
+				return dsm, err
+			}
+			break
+		}
+
+		// Truncated chunks are dropped, and the sizes of corrupt ones would allocate too
+		// much.
+		if int64(chunk.Size) > int64(br.Len()) {
+			if reader.Strict {
+				return dsm, fmt.Errorf("%w: strict - %q chunk size %d is past the end of the file",
+					ErrInvalidSource, chunk.ID[:], chunk.Size)
+			}
+			break
+		}
+		data := make([]byte, chunk.Size)
+		io.ReadFull(br, data)
+
+		common.Debug(reader.Logger, "dsm chunk", "id", string(chunk.ID[:]), "size", chunk.Size)
+		switch string(chunk.ID[:]) {
+		case "SONG":
+			if err := binary.Read(bytes.NewReader(data), binary.LittleEndian, &dsm.Song); err != nil {
+				return dsm, fmt.Errorf("%w: short SONG chunk", ErrInvalidSource)
+			}
+			hasSong = true
+		case "INST":
+			sample, err := readDsmSample(data)
+			if err != nil {
+				return dsm, err
+			}
+			dsm.Samples = append(dsm.Samples, sample)
+		case "PATT":
+			// The data starts with its length.
+			dsm.Patterns = append(dsm.Patterns, DsmPattern{Data: data[min(2, len(data)):]})
+		}
+	}
+
+	if !hasSong {
+		return dsm, fmt.Errorf("%w: missing SONG chunk", ErrInvalidSource)
+	}
+
+	if reader.Strict {
+		if len(dsm.Samples) != int(dsm.Song.SampleCount) || len(dsm.Patterns) != int(dsm.Song.PatternCount) {
+			return dsm, fmt.Errorf("%w: strict - chunk count doesn't match the header", ErrInvalidSource)
+		}
+	}
+
+	return dsm, nil
+}
+
+// Read a sample header and its data from an INST chunk.
+func readDsmSample(data []byte) (DsmSample, error) {
+	var sample DsmSample
+	if err := binary.Read(bytes.NewReader(data), binary.LittleEndian, &sample.Header); err != nil {
+		return sample, fmt.Errorf("%w: short INST chunk", ErrInvalidSource)
+	}
+	h := &sample.Header
+	pcm := data[dsmSampleHeader:]
+
+	if h.Flags&SampFlag16bit != 0 {
+		sample.Bits = 16
+		length := min(int(h.Length), len(pcm)/2)
+		d := make([]int16, length)
+		for i := range d {
+			v := binary.LittleEndian.Uint16(pcm[i*2:])
+			if h.Flags&SampFlagSigned == 0 {
+				v ^= 0x8000
+			}
+			d[i] = int16(v)
+		}
+		sample.Data = d
+		return sample, nil
+	}
+
+	sample.Bits = 8
+	length := min(int(h.Length), len(pcm))
+	d := make([]int8, length)
+	var sum uint8
+	for i := range d {
+		v := pcm[i]
+		switch {
+		case h.Flags&SampFlagDelta != 0:
+			sum += v
+			v = sum
+		case h.Flags&SampFlagSigned != 0:
+		default:
+			v ^= 0x80
+		}
+		d[i] = int8(v)
+	}
+	sample.Data = d
+	return sample, nil
+}
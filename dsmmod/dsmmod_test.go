@@ -0,0 +1,121 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package dsmmod
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mukunda.com/modlib/common"
+)
+
+func writeChunk(buf *bytes.Buffer, id string, data ...any) {
+	var chunk bytes.Buffer
+	for _, d := range data {
+		binary.Write(&chunk, binary.LittleEndian, d)
+	}
+	buf.WriteString(id)
+	binary.Write(buf, binary.LittleEndian, uint32(chunk.Len()))
+	buf.Write(chunk.Bytes())
+}
+
+// Build a small DSM with two samples and one pattern.
+func buildTestDsm() []byte {
+	song := DsmSongHeader{
+		OrderCount:   2,
+		SampleCount:  2,
+		PatternCount: 1,
+		ChannelCount: 2,
+		GlobalVolume: 64,
+		MasterVolume: 48,
+		InitialSpeed: 6,
+		InitialTempo: 125,
+	}
+	copy(song.Title[:], "dsm test")
+	song.ChannelPan = [16]uint8{0, DsmPanSurround}
+	song.Orders[0] = 0
+	song.Orders[1] = 255
+
+	unsigned := DsmSampleHeader{Flags: SampFlagLoop, Volume: 64, Length: 4, LoopStart: 1, LoopEnd: 4, SampleRate: 8363}
+	copy(unsigned.Name[:], "unsigned")
+	delta := DsmSampleHeader{Flags: SampFlagDelta, Volume: 32, Length: 3, SampleRate: 22050}
+
+	pattern := []byte{
+		PmaskNote | PmaskIns | PmaskVol | PmaskEffect | 1, 49, 1, 40, 0x8, DsmPanSurround,
+		PmaskEffect, 0xC, 0x20,
+		0,
+	}
+	pattern = append(pattern, make([]byte, 63)...)
+
+	var body bytes.Buffer
+	body.WriteString("DSMF")
+	writeChunk(&body, "SONG", song)
+	writeChunk(&body, "INST", unsigned, []uint8{128, 129, 127, 0})
+	writeChunk(&body, "INST", delta, []uint8{5, 5, 251})
+	writeChunk(&body, "PATT", uint16(len(pattern)+2), pattern)
+
+	var buf bytes.Buffer
+	buf.WriteString("RIFF")
+	binary.Write(&buf, binary.LittleEndian, uint32(body.Len()))
+	buf.Write(body.Bytes())
+	return buf.Bytes()
+}
+
+func TestLoading(t *testing.T) {
+	reader := DsmReader{Strict: true}
+	dsm, err := reader.ReadDsmModule(bytes.NewReader(buildTestDsm()))
+	assert.NoError(t, err)
+
+	mod := dsm.ToCommon()
+	assert.Equal(t, common.DsmSource, mod.Source)
+	assert.Equal(t, "dsm test", mod.Title)
+	assert.EqualValues(t, 128, mod.GlobalVolume)
+	assert.EqualValues(t, 2, mod.Channels)
+	assert.EqualValues(t, 0, mod.ChannelSettings[0].InitialPan)
+	assert.True(t, mod.ChannelSettings[1].Surround)
+	assert.Equal(t, []int16{0, 255}, mod.Order)
+
+	assert.Equal(t, "unsigned", mod.Samples[0].Name)
+	assert.Equal(t, []any{[]int8{0, 1, -1, -128}}, mod.Samples[0].Data.Data)
+	assert.True(t, mod.Samples[0].Loop)
+	assert.Equal(t, 4, mod.Samples[0].LoopEnd)
+	assert.Equal(t, []any{[]int8{5, 10, 5}}, mod.Samples[1].Data.Data)
+	assert.Equal(t, 22050, mod.Samples[1].C5)
+
+	assert.Equal(t, []common.PatternEntry{
+		{Channel: 1, Note: 61, Instrument: 1, VolumeCommand: common.VcmdSetVolume, VolumeParam: 40, Effect: effectS, EffectParam: 0x91},
+		{Channel: 0, VolumeCommand: common.VcmdSetVolume, VolumeParam: 32},
+	}, mod.Patterns[0].Rows[0].Entries)
+	assert.Len(t, mod.Patterns[0].Rows, 64)
+}
+
+func TestInvalid(t *testing.T) {
+	reader := DsmReader{}
+	_, err := reader.ReadDsmModule(bytes.NewReader([]byte("RIFF\x04\x00\x00\x00WAVE")))
+	assert.ErrorIs(t, err, ErrInvalidSource)
+
+	_, err = reader.ReadDsmModule(bytes.NewReader([]byte("RIFF\x04\x00\x00\x00DSMF")))
+	assert.ErrorIs(t, err, ErrInvalidSource)
+}
+
+func TestOversizedChunk(t *testing.T) {
+	// The last chunk is PATT, with its size 8 bytes before its data.
+	data := buildTestDsm()
+	patt := bytes.LastIndex(data, []byte("PATT"))
+	binary.LittleEndian.PutUint32(data[patt+4:], 0xFFFFFFF0)
+
+	reader := DsmReader{Strict: true}
+	_, err := reader.ReadDsmModule(bytes.NewReader(data))
+	assert.ErrorIs(t, err, ErrInvalidSource)
+
+	// Without Strict, loading stops at the chunk.
+	reader.Strict = false
+	dsm, err := reader.ReadDsmModule(bytes.NewReader(data))
+	assert.NoError(t, err)
+	assert.Len(t, dsm.Samples, 2)
+	assert.Empty(t, dsm.Patterns)
+}
@@ -0,0 +1,73 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+//go:build js && wasm
+
+/*
+This program is a module player for the browser. It exposes two functions to JavaScript:
+
+	modlibLoad(bytes: Uint8Array) -> {title, sampleRate} or {error}
+	modlibRender(frames: number) -> [Float32Array, Float32Array] or null at the end
+
+The rendered channels can be copied into an AudioBuffer with copyToChannel. See
+index.html. Build with:
+
+	GOOS=js GOARCH=wasm go build -o modlib.wasm ./examples/wasm
+	cp "$(go env GOROOT)/lib/wasm/wasm_exec.js" examples/wasm/
+*/
+package main
+
+import (
+	"syscall/js"
+	"unsafe"
+
+	"go.mukunda.com/modlib"
+	"go.mukunda.com/modlib/render"
+)
+
+const sampleRate = 48000
+
+var player *render.Player
+
+// Copy float32 samples into a new Float32Array.
+func float32Array(samples []float32) js.Value {
+	bytes := unsafe.Slice((*byte)(unsafe.Pointer(unsafe.SliceData(samples))), len(samples)*4)
+	array := js.Global().Get("Uint8Array").New(len(bytes))
+	js.CopyBytesToJS(array, bytes)
+	return js.Global().Get("Float32Array").New(array.Get("buffer"))
+}
+
+func load(this js.Value, args []js.Value) any {
+	if len(args) < 1 {
+		return map[string]any{"error": "missing data"}
+	}
+	data := make([]byte, args[0].Get("length").Int())
+	js.CopyBytesToGo(data, args[0])
+
+	m, err := modlib.LoadModuleFromBytes(data)
+	if err != nil {
+		return map[string]any{"error": err.Error()}
+	}
+	player = render.NewPlayer(m, sampleRate)
+	return map[string]any{"title": m.Title, "sampleRate": sampleRate}
+}
+
+func renderFrames(this js.Value, args []js.Value) any {
+	if player == nil || len(args) < 1 {
+		return js.Null()
+	}
+	frames := args[0].Int()
+	left, right := make([]float32, frames), make([]float32, frames)
+	n := player.RenderPlanar([][]float32{left, right})
+	if n == 0 {
+		return js.Null()
+	}
+	return []any{float32Array(left[:n]), float32Array(right[:n])}
+}
+
+func main() {
+	js.Global().Set("modlibLoad", js.FuncOf(load))
+	js.Global().Set("modlibRender", js.FuncOf(renderFrames))
+	select {}
+}
@@ -10,14 +10,26 @@ import (
 	"go.mukunda.com/modlib"
 )
 
-func ExampleLoadModule() error {
+func ExampleLoadModule() {
 
 	// Load a module by filename.
 	mod, err := modlib.LoadModule("my_module.it")
 	if err != nil {
-		return err
+		fmt.Println("Error:", err)
+		return
+	}
+
+	fmt.Println("Title:", mod.Title)
+}
+
+func ExampleLoadModuleFromArchive() {
+
+	// Load the first module found in a zip file.
+	mod, err := modlib.LoadModuleFromArchive("my_module.zip", "")
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
 	}
 
 	fmt.Println("Title:", mod.Title)
-	return nil
 }
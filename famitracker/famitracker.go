@@ -0,0 +1,517 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+/*
+This package exports chiptune-style modules as FamiTracker text (the format of
+FamiTracker's "Export text" and "Import text"), playing them on the NES 2A03 channels.
+*/
+package famitracker
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+
+	"go.mukunda.com/modlib/common"
+)
+
+// Kinds of 2A03 channel, in the order of the FamiTracker channels.
+const (
+	kindNone = iota
+	kindPulse
+	kindTriangle
+	kindNoise
+)
+
+var kindNames = [...]string{"none", "pulse", "triangle", "noise"}
+
+// The 2A03 channels, with the DPCM channel last. It isn't used for notes.
+var channelKinds = [5]int{kindPulse, kindPulse, kindTriangle, kindNoise, kindNone}
+
+// FamiTracker limits.
+const (
+	maxRows     = 256
+	maxFrames   = 128
+	maxPatterns = 128
+	maxNote     = 95 // B-7
+)
+
+// How a sample plays on the 2A03.
+type waveform struct {
+	kind  int
+	duty  int     // Pulse duty setting, 0-2.
+	cycle float64 // Frames in one cycle of the waveform, for pulse and triangle.
+}
+
+// Write a module as FamiTracker text, to import into FamiTracker. This is best-effort:
+// the module's samples are analyzed, and short looped pulse waves, smooth looped waves
+// (played as triangle) and noise are mapped to 2A03 instruments. Each channel is given the
+// 2A03 channel that suits most of its notes, up to two pulse channels, one triangle and
+// one noise. The returned warnings list what couldn't be mapped: other samples, channels
+// left without a 2A03 channel, notes out of range and unsupported effects.
+func WriteText(w io.Writer, m *common.Module) ([]string, error) {
+	m, err := m.WithLoadedStreams()
+	if err != nil {
+		return nil, err
+	}
+	x := &exporter{module: m, effects: make(map[uint8]int)}
+	x.analyzeSamples()
+	x.assignChannels()
+
+	bw := bufio.NewWriter(w)
+	x.write(bw)
+	x.warnEffects()
+	return x.warnings, bw.Flush()
+}
+
+type exporter struct {
+	module   *common.Module
+	warnings []string
+
+	waves       []waveform // Indexed like Module.Samples.
+	instruments []int      // FamiTracker instrument of each sample, -1 = none.
+	sources     [5]int     // Module channel for each 2A03 channel, -1 = none.
+	effects     map[uint8]int
+	outOfRange  int
+	mismatched  int
+}
+
+func (x *exporter) warn(format string, args ...any) {
+	x.warnings = append(x.warnings, fmt.Sprintf(format, args...))
+}
+
+func (x *exporter) analyzeSamples() {
+	m := x.module
+	x.waves = make([]waveform, len(m.Samples))
+	x.instruments = make([]int, len(m.Samples))
+	next := 0
+	for i := range m.Samples {
+		x.waves[i] = analyze(&m.Samples[i])
+		x.instruments[i] = -1
+		if x.waves[i].kind != kindNone {
+			x.instruments[i] = next
+			next++
+		} else if m.Samples[i].HasData() {
+			x.warn("sample %d: not a pulse, triangle or noise waveform", i+1)
+		}
+	}
+}
+
+// Classify a sample by the shape of its loop, or the whole sample if it doesn't loop.
+func analyze(s *common.Sample) waveform {
+	if !s.HasData() {
+		return waveform{}
+	}
+	data := s.Data.Float64()[0]
+	looped := s.Loop && s.LoopEnd > s.LoopStart && s.LoopEnd <= len(data)
+	if looped {
+		data = data[s.LoopStart:s.LoopEnd]
+	}
+	lo, hi := slices.Min(data), slices.Max(data)
+	if hi-lo < 1.0/64 {
+		return waveform{}
+	}
+	mid := (lo + hi) / 2
+
+	// Count cycles by crossings of the middle, and the turns of the waveform.
+	crossings, nearEdge, high := 0, 0, 0
+	var turns []int
+	direction := 0
+	for i, v := range data {
+		prev := data[(i+len(data)-1)%len(data)]
+		if prev < mid && v >= mid {
+			crossings++
+		}
+		if d := v - prev; d != 0 {
+			dir := 1
+			if d < 0 {
+				dir = -1
+			}
+			if direction != 0 && dir != direction {
+				turns = append(turns, i)
+			}
+			direction = dir
+		}
+		if v-lo < (hi-lo)*0.15 || hi-v < (hi-lo)*0.15 {
+			nearEdge++
+		}
+		if v >= mid {
+			high++
+		}
+	}
+
+	if len(turns) > len(data)/4 {
+		return waveform{kind: kindNoise}
+	}
+	if !looped || crossings == 0 {
+		return waveform{}
+	}
+	cycle := float64(len(data)) / float64(crossings)
+	if nearEdge >= len(data)*9/10 {
+		duty := float64(high) / float64(len(data))
+		duty = min(duty, 1-duty)
+		setting := 2
+		if duty < 0.1875 {
+			setting = 0
+		} else if duty < 0.375 {
+			setting = 1
+		}
+		return waveform{kind: kindPulse, duty: setting, cycle: cycle}
+	}
+	if evenTurns(data, turns, cycle, hi-lo) {
+		return waveform{kind: kindTriangle, cycle: cycle}
+	}
+	return waveform{}
+}
+
+// True if the waveform swings across its whole range about every half cycle, so it only
+// rises and falls once per cycle, like a triangle or sine wave.
+func evenTurns(data []float64, turns []int, cycle, span float64) bool {
+	if len(turns) < 2 {
+		return false
+	}
+	for i, turn := range turns {
+		next := turns[(i+1)%len(turns)]
+		swing := math.Abs(data[next] - data[turn])
+		if next <= turn {
+			next += len(data)
+		}
+		half := float64(next-turn) / cycle
+		if half < 0.3 || half > 0.7 || swing < span*0.7 {
+			return false
+		}
+	}
+	return true
+}
+
+// Walk the notes of every pattern, with the sample each note plays.
+func (x *exporter) notes(yield func(channel int, e common.PatternEntry, sample int)) {
+	m := x.module
+	current := make(map[uint8]int16)
+	for i := range m.Patterns {
+		for _, row := range m.Patterns[i].Rows {
+			for _, e := range row.Entries {
+				if e.Instrument != 0 {
+					current[e.Channel] = e.Instrument
+				}
+				if e.Note >= 1 && e.Note <= 120 {
+					if sample, _ := x.noteSample(current[e.Channel], e.Note); sample >= 0 {
+						yield(int(e.Channel), e, sample)
+					}
+				}
+			}
+		}
+	}
+}
+
+// The sample index and played note for a note of an instrument, or -1 if there's none.
+func (x *exporter) noteSample(instrument int16, note uint8) (int, int) {
+	m := x.module
+	sample, played := int(instrument), int(note)
+	if m.UseInstruments {
+		if instrument < 1 || int(instrument) > len(m.Instruments) {
+			return -1, 0
+		}
+		entry := m.Instruments[instrument-1].Notemap[note-1]
+		sample, played = int(entry.Sample), int(entry.Note)+1
+	}
+	if sample < 1 || sample > len(m.Samples) {
+		return -1, 0
+	}
+	return sample - 1, played
+}
+
+// Give each 2A03 channel the module channel with the most notes of its kind.
+func (x *exporter) assignChannels() {
+	channels := max(int(x.module.Channels), 1)
+	counts := make([][4]int, channels)
+	x.notes(func(c int, _ common.PatternEntry, sample int) {
+		if c < channels {
+			counts[c][x.waves[sample].kind]++
+		}
+	})
+
+	type candidate struct{ channel, kind, notes int }
+	var candidates []candidate
+	for c := range counts {
+		best := candidate{channel: c}
+		for kind := kindPulse; kind <= kindNoise; kind++ {
+			if counts[c][kind] > best.notes {
+				best.kind, best.notes = kind, counts[c][kind]
+			}
+		}
+		if best.kind != kindNone {
+			candidates = append(candidates, best)
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].notes > candidates[j].notes })
+
+	for i := range x.sources {
+		x.sources[i] = -1
+	}
+	for _, cand := range candidates {
+		placed := false
+		for i, kind := range channelKinds {
+			if kind == cand.kind && x.sources[i] < 0 {
+				x.sources[i] = cand.channel
+				placed = true
+				break
+			}
+		}
+		if !placed {
+			x.warn("channel %d: no free %s channel, %d notes dropped", cand.channel+1, kindNames[cand.kind], cand.notes)
+		}
+	}
+
+	// Keep the module's channel order on the pulse channels.
+	if x.sources[0] > x.sources[1] && x.sources[1] >= 0 {
+		x.sources[0], x.sources[1] = x.sources[1], x.sources[0]
+	}
+}
+
+func (x *exporter) write(w *bufio.Writer) {
+	m := x.module
+	fmt.Fprintf(w, "# FamiTracker text export 0.4.2\n\n")
+	fmt.Fprintf(w, "# Song information\n")
+	fmt.Fprintf(w, "TITLE           %s\n", quote(m.Title, 31))
+	fmt.Fprintf(w, "AUTHOR          \"\"\n")
+	fmt.Fprintf(w, "COPYRIGHT       \"\"\n\n")
+	fmt.Fprintf(w, "# Song comment\n")
+	for _, line := range strings.Split(strings.ReplaceAll(m.Message, "\r", "\n"), "\n") {
+		fmt.Fprintf(w, "COMMENT %s\n", quote(line, 0))
+	}
+	fmt.Fprintf(w, "\n# Global settings\n")
+	fmt.Fprintf(w, "MACHINE         0\nFRAMERATE       0\nEXPANSION       0\nVIBRATO         1\nSPLIT           32\n\n")
+
+	// Pulse instruments select their duty with a one-step duty macro.
+	fmt.Fprintf(w, "# Macros\n")
+	for i, wave := range x.waves {
+		if wave.kind == kindPulse {
+			fmt.Fprintf(w, "MACRO       4 %3d  -1  -1   0 : %d\n", x.instruments[i], wave.duty)
+		}
+	}
+	fmt.Fprintf(w, "\n# DPCM samples\n\n")
+	fmt.Fprintf(w, "# Instruments\n")
+	for i, wave := range x.waves {
+		if wave.kind == kindNone {
+			continue
+		}
+		duty := -1
+		if wave.kind == kindPulse {
+			duty = x.instruments[i]
+		}
+		fmt.Fprintf(w, "INST2A03 %3d  -1  -1  -1  -1 %3d %s\n", x.instruments[i], duty, quote(m.Samples[i].Name, 127))
+	}
+
+	rows := 1
+	for i := range m.Patterns {
+		rows = max(rows, len(m.Patterns[i].Rows))
+	}
+	if rows > maxRows {
+		x.warn("patterns cut to %d rows", maxRows)
+		rows = maxRows
+	}
+	speed := min(max(int(m.InitialSpeed), 1), 31)
+	tempo := min(max(int(m.InitialTempo), 32), 255)
+
+	fmt.Fprintf(w, "\n# Tracks\n\n")
+	fmt.Fprintf(w, "TRACK %3d %3d %3d %s\n", rows, speed, tempo, quote(m.Title, 0))
+	fmt.Fprintf(w, "COLUMNS : 1 1 1 1 1\n\n")
+
+	var order []int
+	for _, index := range m.Order {
+		if index == 255 {
+			break
+		}
+		if int(index) < len(m.Patterns) {
+			order = append(order, int(index))
+		}
+	}
+	if len(order) > maxFrames {
+		x.warn("order list cut to %d frames", maxFrames)
+		order = order[:maxFrames]
+	}
+	if len(order) == 0 {
+		order = []int{0}
+	}
+	for frame, p := range order {
+		fmt.Fprintf(w, "ORDER %02X : %02X %02X %02X %02X %02X\n", frame, p, p, p, p, p)
+	}
+	fmt.Fprintf(w, "\n")
+
+	patterns := min(len(m.Patterns), maxPatterns)
+	if len(m.Patterns) > maxPatterns {
+		x.warn("only the first %d patterns exported", maxPatterns)
+	}
+	for p := range patterns {
+		x.writePattern(w, p, rows)
+	}
+	fmt.Fprintf(w, "# End of export\n")
+}
+
+func (x *exporter) writePattern(w *bufio.Writer, index int, rows int) {
+	pattern := &x.module.Patterns[index]
+	fmt.Fprintf(w, "PATTERN %02X\n", index)
+
+	// The instrument column is remembered so notes without one still find their sample.
+	current := make(map[uint8]int16)
+	for r := range rows {
+		cells := [5]string{}
+		for i := range cells {
+			cells[i] = "... .. . ..."
+		}
+		if r < len(pattern.Rows) {
+			for _, e := range pattern.Rows[r].Entries {
+				if e.Instrument != 0 {
+					current[e.Channel] = e.Instrument
+				}
+				for i, source := range x.sources {
+					if source == int(e.Channel) {
+						cells[i] = x.cell(channelKinds[i], e, current[e.Channel])
+					}
+				}
+			}
+		}
+		// Shorter patterns end with a skip on the unused DPCM channel.
+		if r == len(pattern.Rows)-1 && len(pattern.Rows) < rows {
+			cells[4] = "... .. . D00"
+		}
+		fmt.Fprintf(w, "ROW %02X : %s\n", r, strings.Join(cells[:], " : "))
+	}
+	fmt.Fprintf(w, "\n")
+}
+
+// Format a pattern entry for a 2A03 channel.
+func (x *exporter) cell(kind int, e common.PatternEntry, instrument int16) string {
+	note, inst, vol := "...", "..", "."
+	switch {
+	case e.Note >= 1 && e.Note <= 120:
+		sample, played := x.noteSample(instrument, e.Note)
+		if sample < 0 || x.waves[sample].kind != kind {
+			x.mismatched++
+			break
+		}
+		n, ok := x.note(kind, x.waves[sample], played, x.module.Samples[sample].C5)
+		if !ok {
+			x.outOfRange++
+			break
+		}
+		note = n
+		inst = fmt.Sprintf("%02X", x.instruments[sample])
+		volume := int(x.module.Samples[sample].DefaultVolume)
+		if e.VolumeCommand == common.VcmdSetVolume {
+			volume = int(e.VolumeParam)
+		}
+		vol = fmt.Sprintf("%X", (min(volume, 64)*15+32)/64)
+	case e.Note == common.NoteCut:
+		note = "---"
+	case e.Note == common.NoteFade || e.Note == common.NoteOff:
+		note = "==="
+	}
+	if vol == "." && e.VolumeCommand == common.VcmdSetVolume {
+		vol = fmt.Sprintf("%X", (min(int(e.VolumeParam), 64)*15+32)/64)
+	}
+	return fmt.Sprintf("%s %s %s %s", note, inst, vol, x.effect(e.Effect, e.EffectParam))
+}
+
+// The FamiTracker note for a module note that plays the sample at the given pitch.
+func (x *exporter) note(kind int, wave waveform, played int, c5 int) (string, bool) {
+	if kind == kindNoise {
+		// Spread C-2 to C-8 over the 16 noise pitches.
+		return fmt.Sprintf("%X-#", min(max((played-25)*15/72, 0), 15)), true
+	}
+	freq := float64(c5) / wave.cycle * math.Pow(2, float64(played-61)/12)
+	n := int(math.Round(57 + 12*math.Log2(freq/440)))
+	if kind == kindTriangle {
+		// The triangle plays an octave below the pulse channels.
+		n += 12
+	}
+	if n < 0 || n > maxNote {
+		return "", false
+	}
+	return fmt.Sprintf("%s%d", noteNames[n%12], n/12), true
+}
+
+var noteNames = [12]string{"C-", "C#", "D-", "D#", "E-", "F-", "F#", "G-", "G#", "A-", "A#", "B-"}
+
+// Convert an effect to a FamiTracker effect, or "..." if there's none.
+func (x *exporter) effect(effect, param uint8) string {
+	command := func(c byte, value uint8) string {
+		return fmt.Sprintf("%c%02X", c, value)
+	}
+	switch effect {
+	case 0:
+		return "..."
+	case 1: // Axx, speed
+		if param > 0 && param < 0x20 {
+			return command('F', param)
+		}
+	case 2: // Bxx, position jump
+		return command('B', param)
+	case 3: // Cxx, pattern break
+		return command('D', param)
+	case 4: // Dxy, volume slide
+		if param>>4 == 0 || param&15 == 0 {
+			return command('A', param)
+		}
+	case 5: // Exx, portamento down
+		if param < 0xE0 {
+			return command('2', param)
+		}
+	case 6: // Fxx, portamento up
+		if param < 0xE0 {
+			return command('1', param)
+		}
+	case 7: // Gxx, tone portamento
+		return command('3', param)
+	case 8: // Hxy, vibrato
+		return command('4', param)
+	case 10: // Jxy, arpeggio
+		return command('0', param)
+	case 18: // Rxy, tremolo
+		return command('7', param)
+	case 19: // Sxy
+		switch param >> 4 {
+		case 0xC:
+			return command('S', param&15)
+		case 0xD:
+			return command('G', param&15)
+		}
+	case 20: // Txx, tempo
+		if param >= 0x20 {
+			return command('F', param)
+		}
+	}
+	x.effects[effect]++
+	return "..."
+}
+
+func (x *exporter) warnEffects() {
+	effects := make([]int, 0, len(x.effects))
+	for effect := range x.effects {
+		effects = append(effects, int(effect))
+	}
+	sort.Ints(effects)
+	for _, effect := range effects {
+		x.warn("effect %c not mapped, dropped %d times", 'A'+effect-1, x.effects[uint8(effect)])
+	}
+	if x.mismatched > 0 {
+		x.warn("%d notes dropped, their sample doesn't suit the channel", x.mismatched)
+	}
+	if x.outOfRange > 0 {
+		x.warn("%d notes dropped, out of range", x.outOfRange)
+	}
+}
+
+// Quote a string for FamiTracker text, optionally limited to a length.
+func quote(s string, limit int) string {
+	if limit > 0 && len(s) > limit {
+		s = s[:limit]
+	}
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
@@ -0,0 +1,90 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package famitracker
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mukunda.com/modlib/common"
+)
+
+func looped(name string, data []float64) common.Sample {
+	sd, _ := common.FromFloat64([][]float64{data}, 8)
+	return common.Sample{
+		Name: name, DefaultVolume: 64, GlobalVolume: 64, C5: 8363,
+		Loop: true, LoopEnd: len(data), Data: sd,
+	}
+}
+
+func TestWriteText(t *testing.T) {
+	pulse := make([]float64, 32)
+	triangle := make([]float64, 32)
+	noise := make([]float64, 4000)
+	chord := make([]float64, 64)
+	for i := range pulse {
+		pulse[i] = -0.5
+		if i < 8 {
+			pulse[i] = 0.5
+		}
+		triangle[i] = 1 - math.Abs(float64(i)-16)/16 - 0.5
+	}
+	rng := rand.New(rand.NewSource(1))
+	for i := range noise {
+		noise[i] = rng.Float64() - 0.5
+	}
+	for i := range chord {
+		chord[i] = 0.4*math.Sin(float64(i)*2*math.Pi/64) + 0.4*math.Sin(float64(i)*2*math.Pi*5/64)
+	}
+
+	m := &common.Module{
+		Title:        "chip",
+		InitialSpeed: 6,
+		InitialTempo: 150,
+		Channels:     5,
+		Order:        []int16{0, 255},
+		Samples: []common.Sample{
+			looped("pulse", pulse), looped("triangle", triangle), looped("noise", noise), looped("chord", chord),
+		},
+		Patterns: []common.Pattern{{Rows: make([]common.PatternRow, 4)}},
+	}
+	m.Samples[2].Loop = false
+	p := &m.Patterns[0]
+	p.Rows[0].Set(common.PatternEntry{Channel: 0, Note: 61, Instrument: 1, Effect: 10, EffectParam: 0x37})
+	p.Rows[0].Set(common.PatternEntry{Channel: 1, Note: 61, Instrument: 2, VolumeCommand: common.VcmdSetVolume, VolumeParam: 32})
+	p.Rows[0].Set(common.PatternEntry{Channel: 2, Note: 61, Instrument: 3})
+	p.Rows[0].Set(common.PatternEntry{Channel: 3, Note: 61, Instrument: 1})
+	p.Rows[0].Set(common.PatternEntry{Channel: 4, Note: 61, Instrument: 1})
+	p.Rows[1].Set(common.PatternEntry{Channel: 4, Note: 61})
+	p.Rows[1].Set(common.PatternEntry{Channel: 0, Note: 255, Effect: 22, EffectParam: 0x40})
+	p.Rows[2].Set(common.PatternEntry{Channel: 3, Note: 61, Instrument: 4})
+
+	var buf bytes.Buffer
+	warnings, err := WriteText(&buf, m)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"sample 4: not a pulse, triangle or noise waveform",
+		"channel 4: no free pulse channel, 1 notes dropped",
+		"effect V not mapped, dropped 1 times",
+	}, warnings)
+
+	text := buf.String()
+	assert.Contains(t, text, `TITLE           "chip"`)
+	assert.Contains(t, text, "MACRO       4   0  -1  -1   0 : 1\n")
+	assert.Contains(t, text, `INST2A03   0  -1  -1  -1  -1   0 "pulse"`)
+	assert.Contains(t, text, `INST2A03   1  -1  -1  -1  -1  -1 "triangle"`)
+	assert.Contains(t, text, "TRACK   4   6 150 \"chip\"\n")
+	assert.Contains(t, text, "ORDER 00 : 00 00 00 00 00\n")
+
+	// 8363 Hz over 32 frames is C-4. The triangle is written an octave up, as it plays an
+	// octave below the pulse channels.
+	lines := strings.Split(text, "\n")
+	assert.Contains(t, lines, "ROW 00 : C-4 00 F 037 : C-4 00 F ... : C-5 01 8 ... : 7-# 02 F ... : ... .. . ...")
+	assert.Contains(t, lines, "ROW 01 : === .. . ... : C-4 00 F ... : ... .. . ... : ... .. . ... : ... .. . ...")
+}
@@ -0,0 +1,311 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+/*
+This package describes what each supported module format can store, for converters, lossiness
+checks, and documentation.
+*/
+package formats
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"go.mukunda.com/modlib/common"
+)
+
+// Capabilities of a module format.
+type Format struct {
+	Name       string
+	Source     common.ModuleSourceFormat
+	Extensions []string
+
+	MaxChannels    int
+	MaxSamples     int
+	MaxInstruments int // 0 = samples are played directly
+	MaxPatterns    int
+	MaxOrders      int
+	MinRows        int
+	MaxRows        int
+	MaxMessage     int // Longest song message in bytes, 0 = none
+
+	SampleBits    []int8
+	StereoSamples bool
+	SustainLoops  bool
+	PingPongLoops bool
+	FM            bool // AdLib instruments
+	Synth         bool // Waveform generator instruments (common.SynthPatch)
+
+	Envelopes      []common.EnvelopeType
+	Filters        bool   // Resonant filters on instruments
+	Effects        string // Effect letters that the format stores
+	VolumeCommands []int  // Vcmd*
+	NoteActions    []int  // common.NoteOff, NoteCut and NoteFade, if the format stores them
+}
+
+var It = &Format{
+	Name:       "Impulse Tracker",
+	Source:     common.ItSource,
+	Extensions: []string{"it"},
+
+	MaxChannels:    64,
+	MaxSamples:     99,
+	MaxInstruments: 99,
+	MaxPatterns:    200,
+	MaxOrders:      256,
+	MinRows:        1,
+	MaxRows:        200,
+	MaxMessage:     8000,
+
+	SampleBits:    []int8{8, 16},
+	StereoSamples: true,
+	SustainLoops:  true,
+	PingPongLoops: true,
+
+	Envelopes: []common.EnvelopeType{
+		common.EnvelopeTypeVolume, common.EnvelopeTypePanning,
+		common.EnvelopeTypePitch, common.EnvelopeTypeFilter,
+	},
+	Filters: true,
+	Effects: "ABCDEFGHIJKLMNOPQRSTUVWXYZ",
+	VolumeCommands: []int{
+		common.VcmdSetVolume, common.VcmdFineVolUp, common.VcmdFineVolDown,
+		common.VcmdVolSlideUp, common.VcmdVolSlideDown, common.VcmdPitchSlideDown,
+		common.VcmdPitchSlideUp, common.VcmdSetPan, common.VcmdPortaToNote,
+		common.VcmdVibratoDepth,
+	},
+	NoteActions: []int{common.NoteFade, common.NoteCut, common.NoteOff},
+}
+
+var S3m = &Format{
+	Name:       "Scream Tracker 3",
+	Source:     common.S3mSource,
+	Extensions: []string{"s3m"},
+
+	MaxChannels: 32,
+	MaxSamples:  99,
+	MaxPatterns: 100,
+	MaxOrders:   256,
+	MinRows:     64,
+	MaxRows:     64,
+
+	SampleBits:     []int8{8, 16},
+	FM:             true,
+	Effects:        "ABCDEFGHIJKLOQRSTUVX",
+	VolumeCommands: []int{common.VcmdSetVolume},
+	NoteActions:    []int{common.NoteCut},
+}
+
+var Stx = &Format{
+	Name:       "Scream Tracker Music Interface Kit",
+	Source:     common.StxSource,
+	Extensions: []string{"stx"},
+
+	MaxChannels: 4,
+	MaxSamples:  99,
+	MaxPatterns: 100,
+	MaxOrders:   256,
+	MinRows:     64,
+	MaxRows:     64,
+
+	SampleBits:     []int8{8},
+	Effects:        "ABCDEFGHIJKLOQRSTUV",
+	VolumeCommands: []int{common.VcmdSetVolume},
+	NoteActions:    []int{common.NoteCut},
+}
+
+var Dsm = &Format{
+	Name:       "Digital Sound Interface Kit",
+	Source:     common.DsmSource,
+	Extensions: []string{"dsm"},
+
+	MaxChannels: 16,
+	MaxSamples:  255,
+	MaxPatterns: 256,
+	MaxOrders:   128,
+	MinRows:     64,
+	MaxRows:     64,
+
+	SampleBits:     []int8{8, 16},
+	Effects:        "ABCDEFGHJKLOQRSTX",
+	VolumeCommands: []int{common.VcmdSetVolume},
+}
+
+var Ahx = &Format{
+	Name:       "Abyss' Highest eXperience",
+	Source:     common.AhxSource,
+	Extensions: []string{"ahx", "thx"},
+
+	// Instruments are synthesized; the samples are their approximations.
+	MaxChannels:    4,
+	MaxSamples:     63,
+	MaxInstruments: 63,
+	MaxPatterns:    999,
+	MaxOrders:      999,
+	MinRows:        1,
+	MaxRows:        64,
+
+	SampleBits:     []int8{8},
+	Synth:          true,
+	Envelopes:      []common.EnvelopeType{common.EnvelopeTypeVolume},
+	Effects:        "ABCDEFGLST",
+	VolumeCommands: []int{common.VcmdSetVolume},
+}
+
+// All formats with descriptors.
+var All = []*Format{It, S3m, Stx, Dsm, Ahx}
+
+// Find the descriptor for a source format. Returns nil if there isn't one.
+func ForSource(source common.ModuleSourceFormat) *Format {
+	for _, f := range All {
+		if f.Source == source {
+			return f
+		}
+	}
+	return nil
+}
+
+// Find the descriptor for a file extension, with or without the dot. Returns nil if
+// there isn't one.
+func ForExtension(ext string) *Format {
+	ext = strings.ToLower(strings.TrimPrefix(ext, "."))
+	for _, f := range All {
+		if slices.Contains(f.Extensions, ext) {
+			return f
+		}
+	}
+	return nil
+}
+
+// Effect letter for a common effect number, A = 1.
+func effectLetter(effect uint8) string {
+	if effect >= 1 && effect <= 26 {
+		return string(rune('A' + effect - 1))
+	}
+	return fmt.Sprintf("#%d", effect)
+}
+
+// List what would be lost or changed when storing the module in this format. Each kind
+// of problem is reported once.
+func (f *Format) Check(m *common.Module) []string {
+	var problems problemList
+
+	if int(m.Channels) > f.MaxChannels {
+		problems.add("%d channels, the format has %d", m.Channels, f.MaxChannels)
+	}
+	if len(m.Samples) > f.MaxSamples {
+		problems.add("%d samples, the format has %d", len(m.Samples), f.MaxSamples)
+	}
+	if m.UseInstruments && len(m.Instruments) > f.MaxInstruments {
+		if f.MaxInstruments == 0 {
+			problems.add("instruments aren't supported")
+		} else {
+			problems.add("%d instruments, the format has %d", len(m.Instruments), f.MaxInstruments)
+		}
+	}
+	if len(m.Patterns) > f.MaxPatterns {
+		problems.add("%d patterns, the format has %d", len(m.Patterns), f.MaxPatterns)
+	}
+	if len(m.Order) > f.MaxOrders {
+		problems.add("%d orders, the format has %d", len(m.Order), f.MaxOrders)
+	}
+	if len(m.Message) > f.MaxMessage {
+		problems.add("song message is %d bytes, the format allows %d", len(m.Message), f.MaxMessage)
+	}
+
+	f.checkSamples(m, &problems)
+	if m.UseInstruments {
+		f.checkInstruments(m, &problems)
+	}
+	f.checkPatterns(m, &problems)
+	return problems.list
+}
+
+// Problems found by a check, each reported once.
+type problemList struct {
+	list []string
+	seen map[string]bool
+}
+
+func (pl *problemList) add(format string, args ...any) {
+	message := fmt.Sprintf(format, args...)
+	if pl.seen == nil {
+		pl.seen = make(map[string]bool)
+	}
+	if !pl.seen[message] {
+		pl.seen[message] = true
+		pl.list = append(pl.list, message)
+	}
+}
+
+func (f *Format) checkSamples(m *common.Module, problems *problemList) {
+	for _, s := range m.Samples {
+		if s.Data.Len() > 0 && !slices.Contains(f.SampleBits, s.Data.Bits) {
+			problems.add("%d-bit samples aren't supported", s.Data.Bits)
+		}
+		if s.Data.Channels > 1 && !f.StereoSamples {
+			problems.add("stereo samples aren't supported")
+		}
+		if s.Sustain && !f.SustainLoops {
+			problems.add("sustain loops aren't supported")
+		}
+		if (s.Loop && s.PingPong || s.Sustain && s.PingPongSustain) && !f.PingPongLoops {
+			problems.add("ping-pong loops aren't supported")
+		}
+	}
+}
+
+func (f *Format) checkInstruments(m *common.Module, problems *problemList) {
+	for _, ins := range m.Instruments {
+		if ins.FM != nil && !f.FM {
+			problems.add("FM instruments aren't supported")
+		}
+		if ins.Synth != nil && !f.Synth {
+			problems.add("synthesized instruments aren't supported")
+		}
+		for _, env := range ins.Envelopes {
+			if env.Enabled && !slices.Contains(f.Envelopes, env.Type) {
+				problems.add("envelope type %d isn't supported", env.Type)
+			}
+		}
+		if (ins.FilterCutoff&128 != 0 || ins.FilterResonance&128 != 0) && !f.Filters {
+			problems.add("instrument filters aren't supported")
+		}
+	}
+}
+
+// Name of a note action for messages.
+func noteActionName(note uint8) string {
+	switch note {
+	case common.NoteFade:
+		return "note fade"
+	case common.NoteCut:
+		return "note cut"
+	case common.NoteOff:
+		return "note-off"
+	}
+	return fmt.Sprintf("note %d", note)
+}
+
+func (f *Format) checkPatterns(m *common.Module, problems *problemList) {
+	for _, p := range m.Patterns {
+		if len(p.Rows) < f.MinRows || len(p.Rows) > f.MaxRows {
+			problems.add("patterns must have %d to %d rows", f.MinRows, f.MaxRows)
+		}
+		for _, row := range p.Rows {
+			for _, e := range row.Entries {
+				if e.Effect != 0 && !strings.Contains(f.Effects, effectLetter(e.Effect)) {
+					problems.add("effect %s isn't supported", effectLetter(e.Effect))
+				}
+				if e.VolumeCommand != 0 && !slices.Contains(f.VolumeCommands, int(e.VolumeCommand)) {
+					problems.add("volume command %d isn't supported", e.VolumeCommand)
+				}
+				if e.Note > 120 && !slices.Contains(f.NoteActions, int(e.Note)) {
+					problems.add("%s notes aren't supported", noteActionName(e.Note))
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,57 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package formats
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mukunda.com/modlib/common"
+)
+
+func TestLookup(t *testing.T) {
+	assert.Equal(t, It, ForSource(common.ItSource))
+	assert.Equal(t, S3m, ForExtension(".S3M"))
+	assert.Nil(t, ForExtension("xyz"))
+	assert.Equal(t, Ahx, ForSource(common.AhxSource))
+}
+
+func TestCheck(t *testing.T) {
+	m := &common.Module{
+		Channels:       40,
+		UseInstruments: true,
+		Instruments: []common.Instrument{{
+			Envelopes: []common.Envelope{{Enabled: true, Type: common.EnvelopeTypeVolume}},
+		}},
+		Samples: []common.Sample{
+			{Sustain: true, Data: common.SampleData{Channels: 2, Bits: 16, Data: []any{[]int16{0}, []int16{0}}}},
+		},
+		Patterns: []common.Pattern{{Rows: make([]common.PatternRow, 64)}},
+	}
+	m.Patterns[0].Rows[0].Entries = []common.PatternEntry{
+		{Effect: 13, EffectParam: 0x40}, {Channel: 1, Effect: 13}, {Channel: 2, VolumeCommand: common.VcmdSetPan},
+	}
+
+	assert.Empty(t, It.Check(m))
+	assert.Equal(t, []string{
+		"40 channels, the format has 32",
+		"instruments aren't supported",
+		"stereo samples aren't supported",
+		"sustain loops aren't supported",
+		"envelope type 0 isn't supported",
+		"effect M isn't supported",
+		"volume command 8 isn't supported",
+	}, S3m.Check(m))
+
+	m.Patterns[0].Rows[1].Entries = []common.PatternEntry{{Note: common.NoteCut}, {Channel: 1, Note: common.NoteOff}}
+	assert.Empty(t, It.Check(m))
+	assert.Contains(t, S3m.Check(m), "note-off notes aren't supported")
+	assert.NotContains(t, S3m.Check(m), "note cut notes aren't supported")
+	assert.Contains(t, Dsm.Check(m), "note cut notes aren't supported")
+
+	m.Instruments[0].Synth = &common.SynthPatch{}
+	assert.Contains(t, It.Check(m), "synthesized instruments aren't supported")
+	assert.NotContains(t, Ahx.Check(m), "synthesized instruments aren't supported")
+}
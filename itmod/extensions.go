@@ -0,0 +1,159 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package itmod
+
+import (
+	"encoding/binary"
+	"io"
+	"strings"
+
+	"go.mukunda.com/modlib/common"
+)
+
+// Lengths of the fixed-size names in OpenMPT's name chunks.
+const (
+	patternNameLength = 32
+	channelNameLength = 20
+)
+
+// The MIDI configuration stored after the edit history when ItSpecialMidiConfig is set.
+// Each entry is a null-terminated macro string.
+type ItMidiConfig struct {
+	// Start, stop, tick, note on, note off, volume, pan, bank change, program change.
+	Global     [9][32]byte
+	Parametric [16][32]byte // SF0-SFF
+	Fixed      [128][32]byte
+}
+
+// Read the optional data that follows the pointer tables: the edit history, the MIDI
+// configuration, and the pattern and channel name chunks that OpenMPT and Schism Tracker
+// write. The stream must be positioned after the pattern pointer table.
+func (reader *ItReader) readExtensions(r io.ReadSeeker, itm *ItModule) error {
+	if itm.Header.Special&ItSpecialEditHistory != 0 {
+		var count uint16
+		if err := binary.Read(r, binary.LittleEndian, &count); err != nil {
+			return err
+		}
+		// Each entry is 8 bytes of timestamps, which aren't kept.
+		if _, err := r.Seek(int64(count)*8, io.SeekCurrent); err != nil {
+			return err
+		}
+	}
+
+	if itm.Header.Special&ItSpecialMidiConfig != 0 {
+		itm.MidiConfig = new(ItMidiConfig)
+		if err := binary.Read(r, binary.LittleEndian, itm.MidiConfig); err != nil {
+			return err
+		}
+	}
+
+	if data, ok := readChunk(r, "PNAM"); ok {
+		common.Debug(reader.Logger, "it chunk", "id", "PNAM", "size", len(data))
+		itm.PatternNames = splitNames(data, patternNameLength)
+	}
+
+	if data, ok := readChunk(r, "CNAM"); ok {
+		common.Debug(reader.Logger, "it chunk", "id", "CNAM", "size", len(data))
+		itm.ChannelNames = splitNames(data, channelNameLength)
+	}
+
+	return nil
+}
+
+// Read a chunk with a 4-byte ID and 32-bit length if it's next in the stream. If it isn't,
+// or its length runs past the end of the stream, the stream position is restored and
+// false is returned.
+func readChunk(r io.ReadSeeker, id string) ([]byte, bool) {
+	start, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, false
+	}
+	end, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, false
+	}
+	if _, err := r.Seek(start, io.SeekStart); err != nil {
+		return nil, false
+	}
+
+	var header struct {
+		ID     [4]byte
+		Length uint32
+	}
+	if err := binary.Read(r, binary.LittleEndian, &header); err == nil && string(header.ID[:]) == id &&
+		int64(header.Length) <= end-start-8 {
+		data := make([]byte, header.Length)
+		if _, err := io.ReadFull(r, data); err == nil {
+			return data, true
+		}
+	}
+
+	r.Seek(start, io.SeekStart)
+	return nil, false
+}
+
+// Split a chunk into null-padded names of a fixed size.
+func splitNames(data []byte, size int) []string {
+	var names []string
+	for i := 0; i+size <= len(data); i += size {
+		name, _, _ := strings.Cut(string(data[i:i+size]), "\000")
+		names = append(names, name)
+	}
+	return names
+}
+
+// The PNAM and CNAM chunks for the names of patterns (Pattern.Name) and channels
+// (ChannelSetting.Name), as OpenMPT writes them after the pattern pointer table. Names
+// are cut to the chunk's fixed size. A chunk ends at the last named entry and is left out
+// if there are no names, so nil is returned if nothing is named.
+func NameChunks(patterns []common.Pattern, channels []common.ChannelSetting) []byte {
+	var data []byte
+	writeChunk := func(id string, names []string, size int) {
+		count := 0
+		for i, name := range names {
+			if name != "" {
+				count = i + 1
+			}
+		}
+		if count == 0 {
+			return
+		}
+		data = append(data, id...)
+		data = binary.LittleEndian.AppendUint32(data, uint32(count*size))
+		for _, name := range names[:count] {
+			entry := make([]byte, size)
+			copy(entry, name)
+			data = append(data, entry...)
+		}
+	}
+
+	patternNames := make([]string, len(patterns))
+	for i := range patterns {
+		patternNames[i] = patterns[i].Name
+	}
+	channelNames := make([]string, len(channels))
+	for i := range channels {
+		channelNames[i] = channels[i].Name
+	}
+	writeChunk("PNAM", patternNames, patternNameLength)
+	writeChunk("CNAM", channelNames, channelNameLength)
+	return data
+}
+
+// Convert the macros used by Zxx effects.
+func (config *ItMidiConfig) ToCommon() common.MidiMacros {
+	var macros common.MidiMacros
+	macroString := func(data [32]byte) string {
+		macro, _, _ := strings.Cut(string(data[:]), "\000")
+		return macro
+	}
+	for i := range config.Parametric {
+		macros.Parametric[i] = macroString(config.Parametric[i])
+	}
+	for i := range config.Fixed {
+		macros.Fixed[i] = macroString(config.Fixed[i])
+	}
+	return macros
+}
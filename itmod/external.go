@@ -0,0 +1,145 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package itmod
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+
+	"go.mukunda.com/modlib/common"
+)
+
+// MPTM files are IT files with these cwtv values. Only they can have external samples.
+func isMptm(cwtv uint16) bool {
+	return cwtv >= 0x0889 && cwtv < 0x1000
+}
+
+// Read the path of an external sample, which is stored in place of the sample data, and
+// load the sample from SampleFS if it's set.
+func (reader *ItReader) readExternalSample(r io.Reader, its ItSample, quirks []Quirk) (ItSample, []Quirk, error) {
+	its.Channels = 1
+	if its.Header.Flags&SampFlagStereo != 0 {
+		its.Channels = 2
+	}
+	its.Bits = 8
+	if its.Header.Flags&SampFlag16bit != 0 {
+		its.Bits = 16
+	}
+
+	br := bufio.NewReader(r)
+	length, err := readVarInt(br)
+	if err != nil {
+		return its, quirks, err
+	}
+	if length > 4096 {
+		return its, quirks, fmt.Errorf("%w: external sample path too long", ErrInvalidSource)
+	}
+	name := make([]byte, length)
+	if _, err := io.ReadFull(br, name); err != nil {
+		return its, quirks, err
+	}
+	its.ExternalPath = strings.TrimRight(string(name), "\x00")
+
+	if reader.SampleFS == nil || its.ExternalPath == "" {
+		return its, quirks, nil
+	}
+	err = reader.loadExternalSample(&its)
+	if errors.Is(err, fs.ErrNotExist) {
+		err = nil
+	}
+	return its, quirks, err
+}
+
+// Read the file of an external sample into the sample. The header is updated to the
+// format and length of the file; the loops and other settings are kept.
+func (reader *ItReader) loadExternalSample(its *ItSample) error {
+	name, ok := externalFSPath(its.ExternalPath)
+	if !ok {
+		return fs.ErrNotExist
+	}
+	f, err := reader.SampleFS.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	wav, err := common.ReadWav(f)
+	if err != nil {
+		return fmt.Errorf("external sample %s: %w", its.ExternalPath, err)
+	}
+
+	its.Data = wav.Data.Data
+	its.Channels = uint8(wav.Data.Channels)
+	its.Bits = uint8(wav.Data.Bits)
+	its.Header.Length = uint32(wav.Data.Len())
+	its.Header.Flags &^= SampFlag16bit | SampFlagStereo
+	if its.Bits == 16 {
+		its.Header.Flags |= SampFlag16bit
+	}
+	if its.Channels == 2 {
+		its.Header.Flags |= SampFlagStereo
+	}
+	return nil
+}
+
+// Convert an external sample path, which OpenMPT writes with the separators of the system
+// it ran on, to an fs.FS path. Absolute paths can't be used with a file system and return
+// false.
+func externalFSPath(p string) (string, bool) {
+	p = strings.ReplaceAll(p, `\`, "/")
+	if strings.HasPrefix(p, "/") || strings.Contains(p, ":") {
+		return "", false
+	}
+	p = path.Clean(p)
+	return p, fs.ValidPath(p)
+}
+
+// Read an OpenMPT variable-length integer: 7 bits per byte, most significant first, with
+// the top bit set on all bytes but the last.
+func readVarInt(r io.ByteReader) (uint64, error) {
+	var value uint64
+	for i := 0; i < 10; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		value = value<<7 | uint64(b&0x7F)
+		if b&0x80 == 0 {
+			return value, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: invalid varint", ErrInvalidSource)
+}
+
+// Append an OpenMPT variable-length integer.
+func appendVarInt(b []byte, value uint64) []byte {
+	var groups []byte
+	for {
+		groups = append(groups, byte(value&0x7F))
+		value >>= 7
+		if value == 0 {
+			break
+		}
+	}
+	for i := len(groups) - 1; i >= 0; i-- {
+		g := groups[i]
+		if i > 0 {
+			g |= 0x80
+		}
+		b = append(b, g)
+	}
+	return b
+}
+
+// The sample data field of an external sample, as MPTM files store it: the length of the
+// path and the path.
+func ExternalSampleData(path string) []byte {
+	return append(appendVarInt(nil, uint64(len(path))), path...)
+}
@@ -0,0 +1,108 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package itmod
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Channel pan values at or above this mark a disabled channel.
+const channelDisabled = 128
+
+// Number of channels the header declares: up to the last one that isn't disabled. A
+// header with every channel disabled declares all 64.
+func (h *ItModuleHeader) DeclaredChannels() int {
+	for c := 63; c >= 0; c-- {
+		if h.ChannelPan[c] < channelDisabled {
+			return c + 1
+		}
+	}
+	return 64
+}
+
+// Find the entries of packed pattern data on channels at or past the limit. Returns the
+// distinct raw channel numbers (0-based, before IT's masking to 64 channels) and the
+// number of entries.
+func invalidChannels(data []byte, rows int, limit int) ([]int, int) {
+	var channels []int
+	count := 0
+	unpackChannels(data, rows, func(raw int) {
+		if raw >= limit {
+			count++
+			if !slices.Contains(channels, raw) {
+				channels = append(channels, raw)
+			}
+		}
+	})
+	slices.Sort(channels)
+	return channels, count
+}
+
+// Walk packed pattern data, calling fn with the raw channel of each entry.
+func unpackChannels(data []byte, rows int, fn func(raw int)) {
+	var lastMask [64]byte
+	pos := 0
+	next := func() byte {
+		if pos >= len(data) {
+			return 0
+		}
+		pos++
+		return data[pos-1]
+	}
+
+	for row := 0; row < rows && pos < len(data); row++ {
+		for {
+			channelSelect := next()
+			if channelSelect == 0 {
+				break
+			}
+			raw := int((channelSelect - 1) & 127)
+			channel := raw & 63
+			if channelSelect&0x80 != 0 {
+				lastMask[channel] = next()
+			}
+			mask := lastMask[channel]
+			fields := 0
+			for _, bit := range []byte{PmaskNote, PmaskIns, PmaskVol} {
+				if mask&bit != 0 {
+					fields++
+				}
+			}
+			if mask&PmaskEffect != 0 {
+				fields += 2
+			}
+			pos += fields
+			fn(raw)
+		}
+	}
+}
+
+// Check the patterns for entries on channels past the declared channel count. Impulse
+// Tracker folds channels over 64 onto the valid ones, and ignores disabled channels.
+func (itm *ItModule) checkChannels(drop bool) {
+	limit := itm.Header.DeclaredChannels()
+	for i := range itm.Patterns {
+		p := &itm.Patterns[i]
+		channels, count := invalidChannels(p.Data, int(p.Header.Rows), limit)
+		if count == 0 {
+			continue
+		}
+		p.InvalidChannels = channels
+
+		names := make([]string, len(channels))
+		for j, c := range channels {
+			names[j] = fmt.Sprint(c + 1)
+		}
+		action := "kept"
+		if drop {
+			p.channelLimit = limit
+			action = "dropped"
+		}
+		itm.Warnings = append(itm.Warnings, fmt.Sprintf("pattern %d: %d entries on invalid channels %s (%d declared), %s",
+			i, count, strings.Join(names, ","), limit, action))
+	}
+}
@@ -65,17 +65,42 @@ func (itm *ItModule) ToCommon() *common.Module {
 	// Compute number of channels.
 	channels := int16(0)
 
-	for _, pattern := range itm.Patterns {
-		p := pattern.ToCommon()
+	for i, pattern := range itm.Patterns {
+		p := pattern.toCommon(itm.arena)
+		if i < len(itm.PatternNames) {
+			p.Name = itm.PatternNames[i]
+		}
 		m.Patterns = append(m.Patterns, p)
 		channels = max(channels, int16(p.Channels))
 	}
 
 	m.Channels = channels
 	m.ChannelSettings = m.ChannelSettings[:channels]
+	for i := range m.ChannelSettings {
+		if i < len(itm.ChannelNames) {
+			m.ChannelSettings[i].Name = itm.ChannelNames[i]
+		}
+	}
 
 	m.Message = strings.TrimRight(string(itm.Message), "\000")
 
+	details := &common.ItDetails{
+		Cwtv:    itm.Header.Cwtv,
+		Cmwt:    itm.Header.Cmwt,
+		Flags:   itm.Header.Flags,
+		Special: itm.Header.Special,
+		Tracker: itm.Header.Tracker(),
+	}
+	for _, q := range itm.Quirks {
+		details.Quirks = append(details.Quirks, q.String())
+	}
+	details.Warnings = itm.Warnings
+	if itm.MidiConfig != nil {
+		macros := itm.MidiConfig.ToCommon()
+		details.Macros = &macros
+	}
+	m.Details = details
+
 	return m
 }
 
@@ -123,13 +148,15 @@ func (its *ItSample) ToCommon() common.Sample {
 	var s common.Sample
 	s.Name = strings.TrimRight(string(its.Header.Name[:]), "\000")
 	s.DosFilename = strings.TrimRight(string(its.Header.DosFilename[:]), "\000")
+	s.ExternalPath = its.ExternalPath
 
 	s.GlobalVolume = int16(its.Header.GlobalVolume)
 	s.DefaultVolume = int16(its.Header.DefaultVolume)
 	s.DefaultPanning = int16(its.Header.DefaultPanning)
 
-	s.S16 = (its.Header.Flags & SampFlag16bit) != 0
-	s.Stereo = (its.Header.Flags & SampFlagStereo) != 0
+	// The format as loaded, which can differ from the header flags for files with quirks.
+	s.S16 = its.Bits == 16
+	s.Stereo = its.Channels == 2
 	s.Loop = (its.Header.Flags & SampFlagLoop) != 0
 	s.Sustain = (its.Header.Flags & SampFlagSustain) != 0
 	s.PingPong = (its.Header.Flags & SampFlagPingPong) != 0
@@ -152,6 +179,7 @@ func (its *ItSample) ToCommon() common.Sample {
 		Bits:     int8(iif(s.S16, 16, 8)),
 		Data:     its.Data,
 	}
+	s.Stream = its.Stream
 
 	return s
 }
@@ -162,6 +190,7 @@ func translateEnvelope(itenv *ItEnvelope, index int) common.Envelope {
 	env.Enabled = (itenv.Flags & EnvFlagEnabled) != 0
 	env.Loop = (itenv.Flags & EnvFlagLoop) != 0
 	env.Sustain = (itenv.Flags & EnvFlagSustain) != 0
+	env.Carry = (itenv.Flags & EnvFlagCarry) != 0
 
 	env.LoopStart = int16(itenv.LoopStart)
 	env.LoopEnd = int16(itenv.LoopEnd)
@@ -192,134 +221,26 @@ func translateEnvelope(itenv *ItEnvelope, index int) common.Envelope {
 	return env
 }
 
-func translateNote(note uint8) uint8 {
-	if note <= 120 {
-		return note + 1 // Normal note, map to +1 so zero is "empty".
-	} else if note == 254 || note == 255 {
-		return note // Note Cut, Note Off
-	} else if note >= 120 {
-		return 253 // Fade out
-	} else {
-		return 0
-	}
-}
-
-func translatePatternVolume(vol uint8) (uint8, uint8) {
-	if vol <= 64 {
-		return 1, vol
-	} else if vol <= 74 {
-		return 2, vol - 65
-	} else if vol <= 84 {
-		return 3, vol - 75
-	} else if vol <= 94 {
-		return 4, vol - 85
-	} else if vol <= 104 {
-		return 5, vol - 95
-	} else if vol <= 114 {
-		return 6, vol - 105
-	} else if vol <= 124 {
-		return 7, vol - 125
-	} else if vol <= 127 {
-		return 0, 0
-	} else if vol <= 128 {
-		return 8, vol - 128
-	} else if vol <= 202 {
-		return 9, vol - 129
-	} else if vol <= 212 {
-		return 10, vol - 203
-	}
-	return 0, 0
+func (itp *ItPattern) ToCommon() common.Pattern {
+	return itp.toCommon(nil)
 }
 
-func (itp *ItPattern) ToCommon() common.Pattern {
+// Convert the pattern, allocating its entries from arena.
+func (itp *ItPattern) toCommon(arena *common.Arena) common.Pattern {
 	var p common.Pattern
-
-	// Unpack data
-	dataRead := 0
-	data := itp.Data
-
-	nextByte := func() byte {
-		if dataRead >= len(data) {
-			// Should we throw an error if the pattern data is corrupted?
-			return 0
-		}
-
-		byt := data[dataRead]
-		dataRead++
-		return byt
-	}
-
-	var lastMask [64]byte
-	var lastNote [64]byte
-	var lastIns [64]byte
-	var lastVol [64]byte
-	var lastEffect [64]byte
-	var lastEffectParam [64]byte
+	p.Rows = make([]common.PatternRow, 0, itp.Header.Rows)
 
 	channels := 0
-
-	for row := 0; row < int(itp.Header.Rows); row++ {
-		patternRow := common.PatternRow{}
-		for {
-			channelSelect := nextByte()
-			if channelSelect == 0 {
-				break
-			}
-
-			entry := common.PatternEntry{}
-
-			channel := int((channelSelect - 1) & 63)
-			entry.Channel = uint8(channel)
-			if channel >= channels {
-				channels = channel + 1
-			}
-
-			if channelSelect&0x80 != 0 {
-				lastMask[channel] = nextByte()
-			}
-			mask := lastMask[channel]
-
-			if mask&PmaskNote != 0 {
-				lastNote[channel] = nextByte()
-			}
-
-			if mask&(PmaskNote|PmaskLastNote) != 0 {
-				entry.Note = translateNote(lastNote[channel])
-			}
-
-			if mask&PmaskIns != 0 {
-				lastIns[channel] = nextByte()
-			}
-
-			if mask&(PmaskIns|PmaskLastIns) != 0 {
-				entry.Instrument = int16(lastIns[channel]) // add one here? or is 1 lowest?
-			}
-
-			if mask&PmaskVol != 0 {
-				lastVol[channel] = nextByte()
-			}
-
-			if mask&(PmaskVol|PmaskLastVol) != 0 {
-				entry.VolumeCommand, entry.VolumeParam = translatePatternVolume(lastVol[channel])
-			}
-
-			if mask&PmaskEffect != 0 {
-				lastEffect[channel] = nextByte()
-				lastEffectParam[channel] = nextByte()
-			}
-
-			if mask&(PmaskEffect|PmaskLastEffect) != 0 {
-				entry.Effect = lastEffect[channel]
-				entry.EffectParam = lastEffectParam[channel]
-			}
-
-			patternRow.Entries = append(patternRow.Entries, entry)
+	for _, row := range itp.Rows() {
+		for _, e := range row.Entries {
+			channels = max(channels, int(e.Channel)+1)
 		}
-
-		p.Rows = append(p.Rows, patternRow)
+		p.Rows = append(p.Rows, common.PatternRow{Entries: arena.Entries(row.Entries)})
 	}
 
 	p.Channels = int16(channels)
+	p.RowsPerBeat = int16(itp.RowsPerBeat)
+	p.RowsPerMeasure = int16(itp.RowsPerMeasure)
 
 	return p
 }
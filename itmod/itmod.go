@@ -12,7 +12,12 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"log/slog"
 	"os"
+	"slices"
+
+	"go.mukunda.com/modlib/common"
 )
 
 // This is used to read IT files.
@@ -20,8 +25,47 @@ type ItReader struct {
 	// Enable extra checks that will cause loading errors if incorrect or corrupted data is
 	// detected.
 	Strict bool
+
+	// Called as instruments, samples and patterns are read. Optional.
+	Progress common.ProgressFunc
+
+	// Where to find the WAV files of samples that MPTM files keep outside the module, with
+	// paths relative to the module. Samples that can't be found are left without data.
+	// Optional.
+	SampleFS fs.FS
+
+	// Drop pattern entries on channels past the channel count declared in the header,
+	// instead of keeping them. Impulse Tracker folds channels over 64 onto the valid
+	// ones. Either way, they're reported in ItModule.Warnings.
+	DropInvalidChannels bool
+
+	// How to decode compressed samples. The default detects IT 2.14 or 2.15 compression
+	// from each sample's flags; the others force one, for files that mark them wrongly.
+	Compression Compression
+	// Receives debug traces of the file structure, like offsets and chunk sizes, and of
+	// decisions made while loading. Optional.
+	Logger *slog.Logger
+
+	// Where to allocate the pattern entries of converted modules. Optional; see
+	// common.Arena.
+	Arena *common.Arena
+
+	// Uncompressed samples with more bytes of PCM than this are left in the file and
+	// streamed while they play (see common.SampleStream), when the stream is an
+	// io.ReaderAt like *os.File. The stream must then stay open while the module is used.
+	// 0 loads every sample.
+	StreamThreshold int
 }
 
+// Ways of decoding compressed samples.
+type Compression int
+
+const (
+	CompressionAuto  Compression = iota // IT 2.15 if the sample has SampConvDelta set.
+	CompressionIt214                    // IT 2.14 for all samples.
+	CompressionIt215                    // IT 2.15 for all samples.
+)
+
 // Holds all components of an IT file.
 type ItModule struct {
 	Header ItModuleHeader
@@ -31,6 +75,21 @@ type ItModule struct {
 	Samples     []ItSample
 	Patterns    []ItPattern
 	Message     []byte
+
+	// Embedded MIDI configuration, nil if the file doesn't have one.
+	MidiConfig *ItMidiConfig
+
+	// Names from OpenMPT's PNAM and CNAM extensions, when present.
+	PatternNames []string
+	ChannelNames []string
+
+	// Fixups that were applied while loading.
+	Quirks []Quirk
+
+	// Problems found in the data while loading, like pattern entries on invalid channels.
+	Warnings []string
+
+	arena *common.Arena // From ItReader.Arena
 }
 
 // The direct structure of the main IT file header.
@@ -76,7 +135,7 @@ type ItInstrument struct {
 	DuplicateCheckAction uint8
 
 	Fadeout         uint16
-	PPS             uint8
+	PPS             int8
 	PPC             uint8
 	GlobalVolume    uint8
 	DefaultPan      uint8
@@ -113,6 +172,7 @@ const (
 	EnvFlagEnabled = 1
 	EnvFlagLoop    = 2
 	EnvFlagSustain = 4
+	EnvFlagCarry   = 8
 	EnvFlagFilter  = 128
 )
 
@@ -154,6 +214,7 @@ const (
 	SampConvDelta     = 4
 	SampConvByteDelta = 8
 	SampConvTxWave    = 16
+	SampConvExternal  = 128 // The data is in another file (OpenMPT MPTM).
 )
 
 // File structure of an IT sample.
@@ -195,6 +256,14 @@ type ItSample struct {
 
 	// Contains [][]int16 or [][]int8 (Data[channel][sample])
 	Data []any
+
+	// For samples stored outside the module, the path of their file, relative to the
+	// module.
+	ExternalPath string
+
+	// Where to read the PCM of a streamed sample, which leaves Data empty. See
+	// ItReader.StreamThreshold.
+	Stream *common.SampleStream
 }
 
 // File structure of a pattern header.
@@ -223,6 +292,17 @@ type ItPattern struct {
 
 	// Packed data
 	Data []byte
+
+	// Raw channel numbers (0-based, before masking) of entries on channels past the
+	// channel count, for diagnostics. Set when loading.
+	InvalidChannels []int
+
+	// OpenMPT's time signature for the pattern, from the MPTM properties. 0 uses the
+	// module's pattern highlight.
+	RowsPerBeat    int
+	RowsPerMeasure int
+
+	channelLimit int // Entries at or past this channel are dropped when unpacking, 0 = none.
 }
 
 var ErrInvalidSource = errors.New("invalid/corrupted source")
@@ -242,6 +322,13 @@ func LoadITFile(filename string) (*ItModule, error) {
 	return reader.ReadItModule(f)
 }
 
+// Bits in the Special field of the header.
+const (
+	ItSpecialMessage     = 1
+	ItSpecialEditHistory = 2
+	ItSpecialMidiConfig  = 8
+)
+
 const (
 	ItFlagStereo              = 1
 	ItFlagMixing              = 2
@@ -256,7 +343,7 @@ const (
 
 // Load an IT file into memory from the given stream.
 func (reader *ItReader) ReadItModule(r io.ReadSeeker) (*ItModule, error) {
-	itm := new(ItModule)
+	itm := &ItModule{arena: reader.Arena}
 
 	var header ItModuleHeader
 	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
@@ -269,10 +356,9 @@ func (reader *ItReader) ReadItModule(r io.ReadSeeker) (*ItModule, error) {
 		return nil, fmt.Errorf("%w: expected 'IMPM' header", ErrInvalidSource)
 	}
 
-	if header.Cwtv < 0x0217 {
-		// TODO: more support for older versions
-		return nil, fmt.Errorf("%w: cwtv < 0x0217 (too old!)", ErrUnsupportedSource)
-	}
+	common.Debug(reader.Logger, "it header", "cwtv", fmt.Sprintf("%04X", header.Cwtv), "cmwt", fmt.Sprintf("%04X", header.Cmwt),
+		"tracker", header.Tracker(), "orders", header.OrderCount, "instruments", header.InstrumentCount,
+		"samples", header.SampleCount, "patterns", header.PatternCount)
 
 	orders := make([]uint8, header.OrderCount)
 	if err := binary.Read(r, binary.LittleEndian, &orders); err != nil {
@@ -297,7 +383,12 @@ func (reader *ItReader) ReadItModule(r io.ReadSeeker) (*ItModule, error) {
 		return itm, err
 	}
 
+	if err := reader.readExtensions(r, itm); err != nil {
+		return itm, err
+	}
+
 	for i := 0; i < int(header.InstrumentCount); i++ {
+		reader.Progress.Report(common.StageInstruments, i, int(header.InstrumentCount))
 		if instrTable[i] == 0 {
 			// is this possible?
 			itm.Instruments = append(itm.Instruments, ItInstrument{})
@@ -305,6 +396,17 @@ func (reader *ItReader) ReadItModule(r io.ReadSeeker) (*ItModule, error) {
 		}
 
 		r.Seek(int64(instrTable[i]), io.SeekStart)
+		common.Debug(reader.Logger, "it instrument", "index", i, "offset", instrTable[i], "old", header.Cmwt < 0x200)
+		if header.Cmwt < 0x200 {
+			old, err := reader.ReadItOldInstrument(r)
+			if err != nil {
+				return itm, err
+			}
+			itm.addQuirk(QuirkOldInstruments)
+			itm.Instruments = append(itm.Instruments, old.ToNewFormat())
+			continue
+		}
+
 		if ins, err := reader.ReadItInstrument(r); err != nil {
 			return itm, err
 		} else {
@@ -312,9 +414,10 @@ func (reader *ItReader) ReadItModule(r io.ReadSeeker) (*ItModule, error) {
 		}
 	}
 
-	it215 := header.Cmwt >= 0x215
+	reader.Progress.Report(common.StageInstruments, int(header.InstrumentCount), int(header.InstrumentCount))
 
 	for i := 0; i < int(header.SampleCount); i++ {
+		reader.Progress.Report(common.StageSamples, i, int(header.SampleCount))
 		if sampleTable[i] == 0 {
 			// unknown behavior
 			itm.Samples = append(itm.Samples, ItSample{})
@@ -322,14 +425,21 @@ func (reader *ItReader) ReadItModule(r io.ReadSeeker) (*ItModule, error) {
 		}
 
 		r.Seek(int64(sampleTable[i]), io.SeekStart)
-		if sample, err := reader.ReadItSample(r, it215); err != nil {
+		common.Debug(reader.Logger, "it sample", "index", i, "offset", sampleTable[i])
+		sample, quirks, err := reader.readItSample(r, reader.Compression, header.Cwtv)
+		if err != nil {
 			return itm, err
-		} else {
-			itm.Samples = append(itm.Samples, sample)
+		}
+		itm.Samples = append(itm.Samples, sample)
+		for _, q := range quirks {
+			itm.addQuirk(q)
 		}
 	}
 
+	reader.Progress.Report(common.StageSamples, int(header.SampleCount), int(header.SampleCount))
+
 	for i := 0; i < int(header.PatternCount); i++ {
+		reader.Progress.Report(common.StagePatterns, i, int(header.PatternCount))
 		if patternTable[i] == 0 {
 			// unknown behavior
 			itm.Patterns = append(itm.Patterns, ItPattern{})
@@ -344,6 +454,9 @@ func (reader *ItReader) ReadItModule(r io.ReadSeeker) (*ItModule, error) {
 		}
 	}
 
+	reader.Progress.Report(common.StagePatterns, int(header.PatternCount), int(header.PatternCount))
+	itm.checkChannels(reader.DropInvalidChannels)
+
 	if header.MessageLength != 0 {
 		r.Seek(int64(header.MessageOffset), io.SeekStart)
 		msg := make([]byte, header.MessageLength)
@@ -355,6 +468,13 @@ func (reader *ItReader) ReadItModule(r io.ReadSeeker) (*ItModule, error) {
 		itm.Message = msg
 	}
 
+	// The MPTM properties are optional, so the module is still usable without them.
+	if isMptm(header.Cwtv) {
+		if err := reader.readMptmProperties(r, itm); err != nil {
+			itm.Warnings = append(itm.Warnings, fmt.Sprintf("mptm properties: %v", err))
+		}
+	}
+
 	return itm, nil
 }
 
@@ -391,32 +511,82 @@ func readPcm[T int8 | int16](r io.ReadSeeker, length int, offset int) ([]T, erro
 
 // Read an IT sample from the stream. it215 affects the decompression parameters for compressed samples.
 func (reader *ItReader) ReadItSample(r io.ReadSeeker, it215 bool) (ItSample, error) {
+	its, _, err := reader.readItSample(r, iif(it215, CompressionIt215, CompressionIt214), 0x0214)
+	return its, err
+}
+
+// Read an IT sample from the stream, decoding compressed samples as set by
+// ItReader.Compression, like ReadItModule does.
+func (reader *ItReader) ReadItSampleAuto(r io.ReadSeeker) (ItSample, error) {
+	its, _, err := reader.readItSample(r, reader.Compression, 0x0214)
+	return its, err
+}
+
+// True if a compressed sample uses IT 2.15 compression. Impulse Tracker 2.15 and OpenMPT
+// mark it with the delta flag. The cmwt of the file only says which version is needed to
+// load it, and OpenMPT writes 0x0215 or later with either compression.
+func isIt215(header *ItSampleHeader, compression Compression) bool {
+	switch compression {
+	case CompressionIt214:
+		return false
+	case CompressionIt215:
+		return true
+	}
+	return header.Convert&SampConvDelta != 0
+}
+
+// Read an IT sample, applying fixups for files from the tracker version `cwtv`. Returns
+// the quirks that were applied.
+func (reader *ItReader) readItSample(r io.ReadSeeker, compression Compression, cwtv uint16) (ItSample, []Quirk, error) {
 	var header ItSampleHeader
 	var its ItSample
 	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
-		return its, err
+		return its, nil, err
 	}
 
 	its.Header = header
 	if string(header.FileCode[:]) != "IMPS" {
 		if reader.Strict {
-			return its, fmt.Errorf("%w: strict - expected 'IMPS' header", ErrInvalidSource)
+			return its, nil, fmt.Errorf("%w: strict - expected 'IMPS' header", ErrInvalidSource)
 		}
 	}
 
+	// Samples without the header flag or a data pointer are empty slots; there's nothing
+	// to read at offset 0.
+	if header.Flags&SampFlagHeader == 0 || header.SamplePointer == 0 {
+		common.Debug(reader.Logger, "it sample has no data", "flags", header.Flags, "pointer", header.SamplePointer)
+		return its, nil, nil
+	}
+
 	r.Seek(int64(header.SamplePointer), io.SeekStart)
 
-	if header.Convert&SampConvDelta != 0 {
-		// TODO: support this.
-		return its, fmt.Errorf("%w: delta-encoded samples not supported", ErrUnsupportedSource)
+	quirks := sampleQuirks(&header, cwtv)
+	common.Debug(reader.Logger, "it sample data", "pointer", header.SamplePointer, "length", header.Length,
+		"flags", header.Flags, "convert", header.Convert, "quirks", quirks)
+	if slices.Contains(quirks, QuirkModPlugAdpcm) {
+		d, err := readAdpcm(r, int(header.Length))
+		if err != nil {
+			return its, quirks, err
+		}
+		its.Channels = 1
+		its.Bits = 8
+		its.Data = append(its.Data, d)
+		return its, quirks, nil
 	}
 
-	//data := common.SampleData{}
+	if header.Convert&SampConvExternal != 0 && isMptm(cwtv) {
+		return reader.readExternalSample(r, its, quirks)
+	}
 
 	compressed := header.Flags&SampFlagCompressed != 0
+	if header.Convert&SampConvDelta != 0 && !compressed {
+		// TODO: support this.
+		return its, quirks, fmt.Errorf("%w: delta-encoded samples not supported", ErrUnsupportedSource)
+	}
+
 	signed := header.Convert&SampConvSigned != 0
 	bits16 := header.Flags&SampFlag16bit != 0
-	stereo := header.Flags&SampFlagStereo != 0
+	stereo := header.Flags&SampFlagStereo != 0 && !slices.Contains(quirks, QuirkIgnoreStereoFlag)
 	length := int(header.Length)
 
 	its.Channels = 1
@@ -439,20 +609,41 @@ func (reader *ItReader) ReadItSample(r io.ReadSeeker, it215 bool) (ItSample, err
 		}
 	}
 
+	if stream, ok := r.(io.ReaderAt); ok && reader.StreamThreshold > 0 && !compressed &&
+		!slices.Contains(quirks, QuirkDelta8In16) && length*int(its.Channels)*int(its.Bits/8) > reader.StreamThreshold {
+		common.Debug(reader.Logger, "it sample streamed", "pointer", header.SamplePointer, "length", length)
+		its.Stream = &common.SampleStream{
+			Source:   stream,
+			Offset:   int64(header.SamplePointer),
+			Frames:   length,
+			Channels: int8(its.Channels),
+			Bits:     int8(its.Bits),
+			Unsigned: !signed,
+		}
+		return its, quirks, nil
+	}
+
 	for ch := 0; ch < int(its.Channels); ch++ {
 		if !compressed {
 
-			if bits16 {
+			if bits16 && slices.Contains(quirks, QuirkDelta8In16) {
+				d, err := readDelta8In16(r, length)
+				if err != nil {
+					return its, quirks, err
+				}
+
+				its.Data = append(its.Data, d)
+			} else if bits16 {
 				d, err := readPcm[int16](r, length, offset)
 				if err != nil {
-					return its, err
+					return its, quirks, err
 				}
 
 				its.Data = append(its.Data, d)
 			} else {
 				d, err := readPcm[int8](r, length, offset)
 				if err != nil {
-					return its, err
+					return its, quirks, err
 				}
 
 				its.Data = append(its.Data, d)
@@ -460,12 +651,15 @@ func (reader *ItReader) ReadItSample(r io.ReadSeeker, it215 bool) (ItSample, err
 		} else {
 			decoder := ItSampleCodec{
 				Is16:  bits16,
-				It215: it215,
+				It215: isIt215(&header, compression),
+			}
+			if ch == 0 {
+				common.Debug(reader.Logger, "it sample compressed", "it215", decoder.It215, "compression", compression)
 			}
 
 			decoded, err := decoder.Decode(r, length)
 			if err != nil {
-				return its, err
+				return its, quirks, err
 			}
 
 			if bits16 {
@@ -480,7 +674,7 @@ func (reader *ItReader) ReadItSample(r io.ReadSeeker, it215 bool) (ItSample, err
 		}
 	}
 
-	return its, nil
+	return its, quirks, nil
 }
 
 // Read an IT pattern from the stream. The data is not unpacked.
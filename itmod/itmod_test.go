@@ -5,10 +5,17 @@
 package itmod
 
 import (
+	"bytes"
+	"encoding/binary"
 	"io"
+	"log/slog"
+	"math"
+	"math/rand"
 	"os"
 	"reflect"
+	"strings"
 	"testing"
+	"testing/fstest"
 
 	"github.com/stretchr/testify/assert"
 	"go.mukunda.com/modlib/common"
@@ -40,6 +47,7 @@ var itFixture1 = common.Module{
 	Message:                  "a test module\rline 2",
 	PatternHighlight_Beat:    4,
 	PatternHighlight_Measure: 16,
+	Details:                  &common.ItDetails{Cwtv: 0x5131, Cmwt: 0x214, Flags: 0x4d, Special: 0x7, Tracker: "OpenMPT"},
 	ChannelSettings: []common.ChannelSetting{
 		{Name: "", InitialVolume: 64, InitialPan: 32},
 		{Name: "", InitialVolume: 64, InitialPan: 32},
@@ -312,3 +320,814 @@ func TestLoading(t *testing.T) {
 
 	assert.Equal(t, rowsSnippet, mod.Patterns[0].Rows[13:18])
 }
+
+func TestOldInstrument(t *testing.T) {
+	old := ItOldInstrument{
+		FileCode:      [4]byte{'I', 'M', 'P', 'I'},
+		EnvelopeFlags: EnvFlagEnabled | EnvFlagSustain,
+		SustainStart:  1,
+		SustainEnd:    1,
+		Fadeout:       100,
+	}
+	nodes := []OldEnvelopeNode{{0, 64}, {10, 32}, {30, 64}, {50, 0}}
+	for i := range old.Nodes {
+		old.Nodes[i].Tick = oldEnvelopeEnd
+	}
+	copy(old.Nodes[:], nodes)
+	for i := 0; i < len(nodes)-1; i++ {
+		a, b := nodes[i], nodes[i+1]
+		for tick := int(a.Tick); tick <= int(b.Tick); tick++ {
+			t := float64(tick-int(a.Tick)) / float64(b.Tick-a.Tick)
+			old.VolumeTable[tick] = uint8(float64(a.Value) + (float64(b.Value)-float64(a.Value))*t + 0.5)
+		}
+	}
+
+	iti := old.ToNewFormat()
+	assert.EqualValues(t, 200, iti.Fadeout)
+	assert.EqualValues(t, 128, iti.GlobalVolume)
+
+	env := iti.Envelopes[0]
+	assert.EqualValues(t, EnvFlagEnabled|EnvFlagSustain, env.Flags)
+	assert.EqualValues(t, 4, env.NodeCount)
+	assert.Equal(t, []EnvelopeNode{{64, 0}, {32, 10}, {64, 30}, {0, 50}}, env.Nodes[:4])
+	assert.EqualValues(t, 1, env.SustainStart)
+	assert.EqualValues(t, 1, env.SustainEnd)
+
+	// A file from IT 1.xx is loaded with its instruments converted.
+	old.Name = [26]byte{'o', 'l', 'd'}
+	for i := range old.Notemap {
+		old.Notemap[i] = NotemapEntry{Note: uint8(i), Sample: 1}
+	}
+	file := itFile(ItModuleHeader{Cwtv: 0x0100, Cmwt: 0x0100, Flags: ItFlagInstruments}, []any{old},
+		[]ItSampleHeader{{Flags: SampFlagHeader, Convert: SampConvSigned, Length: 2}}, [][]byte{{1, 2}})
+	itm, err := (&ItReader{}).ReadItModule(file)
+	assert.NoError(t, err)
+	assert.Equal(t, []Quirk{QuirkOldInstruments}, itm.Quirks)
+	assert.Equal(t, old.ToNewFormat(), itm.Instruments[0])
+
+	m := itm.ToCommon()
+	assert.Equal(t, "old", m.Instruments[0].Name)
+	assert.EqualValues(t, 200, m.Instruments[0].Fadeout)
+	assert.EqualValues(t, 1, m.Instruments[0].Notemap[60].Sample)
+	assert.Equal(t, []int8{1, 2}, m.Samples[0].Data.Data[0])
+}
+
+// Encode a sample header followed by its data.
+func sampleFile(header ItSampleHeader, data []byte) *bytes.Reader {
+	var buf bytes.Buffer
+	header.FileCode = [4]byte{'I', 'M', 'P', 'S'}
+	header.SamplePointer = uint32(binary.Size(header))
+	binary.Write(&buf, binary.LittleEndian, header)
+	buf.Write(data)
+	return bytes.NewReader(buf.Bytes())
+}
+
+// Build an IT file with one order. Instruments are ItInstrument or ItOldInstrument, and
+// each sample's data is stored after its header. header.PatternCount patterns are listed,
+// all empty.
+func itFile(header ItModuleHeader, instruments []any, samples []ItSampleHeader, sampleData [][]byte) *bytes.Reader {
+	header.FileCode = [4]byte{'I', 'M', 'P', 'M'}
+	header.OrderCount = 1
+	header.InstrumentCount = uint16(len(instruments))
+	header.SampleCount = uint16(len(samples))
+
+	offset := binary.Size(header) + 1 + 4*(len(instruments)+len(samples)+int(header.PatternCount))
+	var table []uint32
+	for _, ins := range instruments {
+		table = append(table, uint32(offset))
+		offset += binary.Size(ins)
+	}
+	for i := range samples {
+		table = append(table, uint32(offset))
+		offset += binary.Size(samples[i])
+		samples[i].FileCode = [4]byte{'I', 'M', 'P', 'S'}
+		samples[i].SamplePointer = uint32(offset)
+		offset += len(sampleData[i])
+	}
+	table = append(table, make([]uint32, header.PatternCount)...)
+
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, header)
+	buf.WriteByte(255)
+	binary.Write(&buf, binary.LittleEndian, table)
+	for _, ins := range instruments {
+		binary.Write(&buf, binary.LittleEndian, ins)
+	}
+	for i := range samples {
+		binary.Write(&buf, binary.LittleEndian, samples[i])
+		buf.Write(sampleData[i])
+	}
+	return bytes.NewReader(buf.Bytes())
+}
+
+func TestQuirks(t *testing.T) {
+	reader := ItReader{}
+
+	// ModPlug ADPCM: delta table, then nibbles.
+	table := []byte{0, 1, 2, 0xFF, 10, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	adpcm := sampleFile(ItSampleHeader{Flags: SampFlagHeader, Convert: 0xFF, Length: 5},
+		append(table, 0x21, 0x43, 0x04))
+	its, quirks, err := reader.readItSample(adpcm, CompressionAuto, 0x0888)
+	assert.NoError(t, err)
+	assert.Equal(t, []Quirk{QuirkModPlugAdpcm}, quirks)
+	assert.Equal(t, []int8{1, 3, 2, 12, 22}, its.Data[0])
+
+	// 8-bit deltas forming 16-bit samples.
+	delta := sampleFile(ItSampleHeader{Flags: SampFlagHeader | SampFlag16bit, Convert: SampConvSigned | SampConvTxWave, Length: 2},
+		[]byte{0x34, 0xDE, 0xED, 0x00})
+	its, quirks, err = reader.readItSample(delta, CompressionAuto, 0x0214)
+	assert.NoError(t, err)
+	assert.Equal(t, []Quirk{QuirkDelta8In16}, quirks)
+	assert.Equal(t, []int16{0x1234, -1}, its.Data[0])
+
+	// Old IT versions left the stereo flag set on mono samples.
+	stereo := sampleFile(ItSampleHeader{Flags: SampFlagHeader | SampFlagStereo, Convert: SampConvSigned, Length: 2},
+		[]byte{1, 2})
+	its, quirks, err = reader.readItSample(stereo, CompressionAuto, 0x0200)
+	assert.NoError(t, err)
+	assert.Equal(t, []Quirk{QuirkIgnoreStereoFlag}, quirks)
+	assert.EqualValues(t, 1, its.Channels)
+	assert.Equal(t, "stereo flag ignored", quirks[0].String())
+
+	assert.Equal(t, TrackerSchism, (&ItModuleHeader{Cwtv: 0x1050}).Tracker())
+	assert.Equal(t, TrackerOpenMpt, (&ItModuleHeader{Cwtv: 0x5130}).Tracker())
+	assert.Equal(t, TrackerModPlug, (&ItModuleHeader{Cwtv: 0x0888, Cmwt: 0x0888}).Tracker())
+	assert.Equal(t, TrackerModPlug, (&ItModuleHeader{Cwtv: 0x0214, Cmwt: 0x0202}).Tracker())
+	assert.Equal(t, TrackerImpulse, (&ItModuleHeader{Cwtv: 0x0214, Cmwt: 0x0214}).Tracker())
+
+	itm, err := LoadITFile("test/reflection.it")
+	assert.NoError(t, err)
+	assert.Empty(t, itm.Quirks)
+
+	// Files from old versions of IT load with their quirks applied.
+	file := itFile(ItModuleHeader{Cwtv: 0x0200, Cmwt: 0x0200}, nil,
+		[]ItSampleHeader{{Flags: SampFlagHeader | SampFlagStereo, Convert: SampConvSigned, Length: 2}},
+		[][]byte{{1, 2}})
+	itm, err = (&ItReader{}).ReadItModule(file)
+	assert.NoError(t, err)
+	assert.Equal(t, []Quirk{QuirkIgnoreStereoFlag}, itm.Quirks)
+	assert.EqualValues(t, 1, itm.Samples[0].Channels)
+	assert.Equal(t, []int8{1, 2}, itm.Samples[0].Data[0])
+	s := itm.ToCommon().Samples[0]
+	assert.False(t, s.Stereo)
+	assert.False(t, s.S16)
+	assert.EqualValues(t, 1, s.Data.Channels)
+	assert.EqualValues(t, 8, s.Data.Bits)
+	assert.Equal(t, 2, s.Data.Len())
+
+	file = itFile(ItModuleHeader{Cwtv: 0x0214, Cmwt: 0x0202}, nil,
+		[]ItSampleHeader{{Flags: SampFlagHeader | SampFlag16bit, Convert: SampConvSigned | SampConvTxWave, Length: 2}},
+		[][]byte{{0x34, 0xDE, 0xED, 0x00}})
+	itm, err = (&ItReader{}).ReadItModule(file)
+	assert.NoError(t, err)
+	assert.Equal(t, []Quirk{QuirkDelta8In16}, itm.Quirks)
+	assert.Equal(t, TrackerModPlug, itm.ToCommon().Details.(*common.ItDetails).Tracker)
+	s = itm.ToCommon().Samples[0]
+	assert.True(t, s.S16)
+	assert.EqualValues(t, 16, s.Data.Bits)
+}
+
+func TestNameChunks(t *testing.T) {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, uint16(1))
+	buf.Write(make([]byte, 8))
+	buf.WriteString("PNAM")
+	binary.Write(&buf, binary.LittleEndian, uint32(patternNameLength*2))
+	buf.Write(append([]byte("Intro"), make([]byte, patternNameLength-5)...))
+	buf.Write(append([]byte("Verse"), make([]byte, patternNameLength-5)...))
+	buf.WriteString("CNAM")
+	binary.Write(&buf, binary.LittleEndian, uint32(channelNameLength))
+	buf.Write(append([]byte("Bass"), make([]byte, channelNameLength-4)...))
+	buf.WriteString("IMPI")
+
+	itm := &ItModule{}
+	itm.Header.Special = ItSpecialEditHistory
+	r := bytes.NewReader(buf.Bytes())
+	assert.NoError(t, (&ItReader{}).readExtensions(r, itm))
+	assert.Equal(t, []string{"Intro", "Verse"}, itm.PatternNames)
+	assert.Equal(t, []string{"Bass"}, itm.ChannelNames)
+
+	// The stream is left at the following data.
+	rest, _ := io.ReadAll(r)
+	assert.Equal(t, "IMPI", string(rest))
+
+	itm.Patterns = []ItPattern{{}, {}}
+	m := itm.ToCommon()
+	assert.Equal(t, "Verse", m.Patterns[1].Name)
+
+	// A chunk longer than the rest of the stream is skipped without reading it.
+	itm = &ItModule{}
+	r = bytes.NewReader([]byte{'P', 'N', 'A', 'M', 0xFF, 0xFF, 0xFF, 0x7F, 'I'})
+	assert.NoError(t, (&ItReader{}).readExtensions(r, itm))
+	assert.Nil(t, itm.PatternNames)
+	rest, _ = io.ReadAll(r)
+	assert.Equal(t, "PNAM", string(rest[:4]))
+}
+
+func TestNameChunkWriter(t *testing.T) {
+	patterns := []common.Pattern{{Name: "Intro"}, {}, {Name: "A name longer than thirty-two characters"}, {}}
+	channels := []common.ChannelSetting{{}, {Name: "Bass"}, {}}
+	data := NameChunks(patterns, channels)
+	assert.Len(t, data, 8+patternNameLength*3+8+channelNameLength*2)
+	assert.Nil(t, NameChunks(patterns[1:2], channels[:1]))
+	assert.Equal(t, "CNAM", string(NameChunks(nil, channels)[:4]))
+
+	itm := &ItModule{}
+	assert.NoError(t, (&ItReader{}).readExtensions(bytes.NewReader(data), itm))
+	assert.Equal(t, []string{"Intro", "", "A name longer than thirty-two ch"}, itm.PatternNames)
+	assert.Equal(t, []string{"", "Bass"}, itm.ChannelNames)
+
+	// The patterns are empty, so ToCommon has no channels to name.
+	itm.Patterns = make([]ItPattern, 4)
+	m := itm.ToCommon()
+	assert.Equal(t, data, NameChunks(m.Patterns, channels))
+}
+
+func TestMidiConfig(t *testing.T) {
+	var config ItMidiConfig
+	copy(config.Parametric[0][:], "F0F000z")
+	copy(config.Fixed[1][:], "F0F00110")
+
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, &config)
+	buf.WriteString("CNAM")
+	binary.Write(&buf, binary.LittleEndian, uint32(channelNameLength))
+	buf.Write(append([]byte("Lead"), make([]byte, channelNameLength-4)...))
+
+	itm := &ItModule{}
+	itm.Header.Special = ItSpecialMidiConfig
+	assert.NoError(t, (&ItReader{}).readExtensions(bytes.NewReader(buf.Bytes()), itm))
+	assert.Equal(t, &config, itm.MidiConfig)
+	assert.Equal(t, []string{"Lead"}, itm.ChannelNames)
+
+	m := itm.ToCommon()
+	macros := m.Details.(*common.ItDetails).Macros
+	assert.Equal(t, "F0F000z", macros.Parametric[0])
+	assert.Equal(t, "", macros.Parametric[1])
+	assert.Equal(t, "F0F00110", macros.Fixed[1])
+}
+
+func TestEstimateSize(t *testing.T) {
+	itm, err := LoadITFile("test/reflection.it")
+	assert.NoError(t, err)
+	m := itm.ToCommon()
+
+	report := EstimateSize(m)
+	for i := range itm.Patterns {
+		// The fixture was packed the same way.
+		assert.Equal(t, int(itm.Patterns[i].Header.DataLength)+8, report.Patterns[i])
+	}
+	assert.Equal(t, 192+len(m.Order)+4*(len(m.Instruments)+len(m.Samples)+len(m.Patterns)), report.Header)
+
+	total := report.Header + report.Message + report.Instruments
+	for _, size := range append(report.Samples, report.Patterns...) {
+		total += size
+	}
+	assert.Equal(t, total, report.Total)
+
+	data := make([]int16, 10000)
+	m = &common.Module{Samples: []common.Sample{{
+		Loop: true, LoopStart: 0, LoopEnd: 8000,
+		Data: common.SampleData{Channels: 1, Bits: 16, Data: []any{data}},
+	}}}
+	report = EstimateSize(m)
+	assert.Equal(t, 80+20000, report.Samples[0])
+	assert.Equal(t, SizeSuggestion{
+		Kind: SuggestTrimLoop, Sample: 0, Savings: 4000,
+		Message: "sample 1 has 2000 frames after the loop end that are never played",
+	}, report.Suggestions[0])
+	assert.Equal(t, SuggestTo8Bit, report.Suggestions[1].Kind)
+	assert.Equal(t, 8000, report.Suggestions[1].Savings)
+	assert.Equal(t, SuggestCompress, report.Suggestions[2].Kind)
+}
+
+func TestSampleCodecRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	data := make([]int16, 40000)
+	for i := range data {
+		// A quiet sine with noise and a few spikes, so every width is used.
+		v := 3000*math.Sin(float64(i)/20) + rng.NormFloat64()*50
+		if i%997 == 0 {
+			v = 32767
+		}
+		data[i] = int16(v)
+	}
+
+	for _, is16 := range []bool{false, true} {
+		for _, it215 := range []bool{false, true} {
+			var pcm bytes.Buffer
+			expected := make([]int16, len(data))
+			for i, v := range data {
+				expected[i] = v
+				if !is16 {
+					expected[i] = int16(int8(v >> 8))
+				}
+			}
+			if is16 {
+				binary.Write(&pcm, binary.LittleEndian, expected)
+			} else {
+				for _, v := range expected {
+					pcm.WriteByte(byte(v))
+				}
+			}
+
+			codec := ItSampleCodec{Is16: is16, It215: it215}
+			encoded, err := codec.Encode(&pcm, len(data))
+			assert.NoError(t, err)
+			assert.Less(t, len(encoded), len(data)*2)
+
+			decoded, err := codec.Decode(bytes.NewReader(encoded), len(data))
+			assert.NoError(t, err)
+			if !is16 {
+				for i := range decoded {
+					decoded[i] = int16(int8(decoded[i]))
+				}
+			}
+			assert.Equal(t, expected, decoded, "16-bit %v, IT215 %v", is16, it215)
+		}
+	}
+}
+
+func TestOversizedCompressedSample(t *testing.T) {
+	data, err := os.ReadFile("test/reflection.it")
+	assert.NoError(t, err)
+
+	// The compressed sample claims far more data than the file has, which must fail
+	// without allocating for the whole length.
+	header := bytes.Index(data, []byte("IMPS"))
+	assert.Greater(t, header, 0)
+	binary.LittleEndian.PutUint32(data[header+48:], 0xff000040) // Length
+	_, err = (&ItReader{}).ReadItModule(bytes.NewReader(data))
+	assert.ErrorIs(t, err, ErrEndOfStream)
+}
+
+func TestStorageCosts(t *testing.T) {
+	data := make([]int16, 10000)
+	for i := range data {
+		data[i] = int16(8000 * math.Sin(float64(i)/30))
+	}
+	sd := common.SampleData{Channels: 1, Bits: 16, Data: []any{data}}
+
+	costs := StorageCosts(&sd)
+	assert.Len(t, costs, 6)
+	assert.Equal(t, StorageCost{Storage: StoreUncompressed, Bits: 16, Bytes: 20000, SNR: math.Inf(1)}, costs[0])
+	assert.Equal(t, 10000, costs[3].Bytes)
+	assert.InDelta(t, 40, costs[3].SNR, 10)
+	// Smooth waves compress better with second-order deltas.
+	assert.Less(t, costs[2].Bytes, costs[1].Bytes)
+	assert.Less(t, costs[1].Bytes, costs[0].Bytes)
+
+	best, ok := ChooseStorage(&sd, StorageOptions{})
+	assert.True(t, ok)
+	assert.Equal(t, costs[2], best)
+
+	best, _ = ChooseStorage(&sd, StorageOptions{DownconvertThreshold: 30})
+	assert.Equal(t, costs[5], best)
+
+	best, _ = ChooseStorage(&sd, StorageOptions{Storage: []SampleStorage{StoreUncompressed}, DownconvertThreshold: 60})
+	assert.Equal(t, costs[0], best)
+}
+
+func TestExternalSample(t *testing.T) {
+	path := strings.Repeat("x", 120) + `\kick.wav`
+	data := ExternalSampleData(path)
+	assert.Equal(t, []byte{0x81, 0x01}, data[:2]) // 129
+
+	var wav bytes.Buffer
+	sd, _ := common.FromFloat64([][]float64{{0, 0.5, -0.5}}, 16)
+	assert.NoError(t, common.WriteWav(&wav, &common.Sample{C5: 8000, Data: sd}))
+	header := ItSampleHeader{Flags: SampFlagHeader | SampFlagLoop, Convert: SampConvSigned | SampConvExternal, LoopEnd: 2}
+
+	// Without a file system, only the path is read.
+	its, _, err := (&ItReader{}).readItSample(sampleFile(header, data), CompressionAuto, 0x0889)
+	assert.NoError(t, err)
+	assert.Equal(t, path, its.ExternalPath)
+	assert.Nil(t, its.Data)
+
+	reader := ItReader{SampleFS: fstest.MapFS{
+		strings.Repeat("x", 120) + "/kick.wav": &fstest.MapFile{Data: wav.Bytes()},
+	}}
+	its, _, err = reader.readItSample(sampleFile(header, data), CompressionAuto, 0x0889)
+	assert.NoError(t, err)
+	s := its.ToCommon()
+	assert.Equal(t, path, s.ExternalPath)
+	assert.True(t, s.S16)
+	assert.True(t, s.Loop)
+	assert.Equal(t, []int16{0, 16384, -16384}, s.Data.Data[0])
+
+	// Missing files are left empty.
+	its, _, err = reader.readItSample(sampleFile(header, ExternalSampleData("missing.wav")), CompressionAuto, 0x0889)
+	assert.NoError(t, err)
+	assert.Equal(t, "missing.wav", its.ExternalPath)
+	assert.Nil(t, its.Data)
+
+	// Other trackers don't have external samples.
+	header.Convert = SampConvSigned | SampConvExternal
+	header.Length = 2
+	its, _, err = reader.readItSample(sampleFile(header, []byte{1, 2}), CompressionAuto, 0x0214)
+	assert.NoError(t, err)
+	assert.Equal(t, "", its.ExternalPath)
+	assert.Equal(t, []int8{1, 2}, its.Data[0])
+}
+
+func TestMptmTimeSignatures(t *testing.T) {
+	// A container as OpenMPT writes it: header, entry data, then the map.
+	container := writeSsb(mptmPatternID, []ssbEntry{{"RPB.", []byte{3, 0, 0, 0}}})
+	assert.Equal(t, []byte{
+		'2', '2', '8', 4, 'm', 'p', 't', 'P',
+		0x1F,             // Header: variable IDs, map with positions and sizes, version
+		0x08, 0x00, 0x01, // Header data: flag byte 1
+		0x02, 0x0C, 0x5C, 0x04, // Version
+		0x01,       // Variable-length IDs
+		0x05, 0x00, // 1 entry
+		0x7F, 0, 0, 0, 0, 0, 0, 0, // Map at 31
+		3, 0, 0, 0, // RPB. = 3
+		0x08, 'R', 'P', 'B', '.', 0x6C, 0x10, // At 27, 4 bytes
+	}, container)
+	entries, err := readSsb(container, mptmPatternID)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]byte{"RPB.": {3, 0, 0, 0}}, entries)
+	_, err = readSsb(container, mptmPatternsID)
+	assert.ErrorIs(t, err, ErrInvalidSource)
+
+	patterns := []common.Pattern{{}, {RowsPerBeat: 3, RowsPerMeasure: 12}, {}}
+	properties := MptmProperties(patterns)
+	assert.Nil(t, MptmProperties(patterns[:1]))
+
+	// An MPTM file with the properties at the end.
+	file := itFile(ItModuleHeader{Cwtv: 0x0891, Cmwt: 0x0888, PatternCount: 3}, nil, nil, nil)
+	data, _ := io.ReadAll(file)
+	data = binary.LittleEndian.AppendUint32(append(data, properties...), uint32(len(data)))
+	itm, err := (&ItReader{}).ReadItModule(bytes.NewReader(data))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, itm.Patterns[1].RowsPerBeat)
+	assert.Equal(t, 12, itm.Patterns[1].RowsPerMeasure)
+	assert.Zero(t, itm.Patterns[0].RowsPerBeat)
+
+	m := itm.ToCommon()
+	assert.EqualValues(t, 3, m.Patterns[1].RowsPerBeat)
+	assert.EqualValues(t, 12, m.Patterns[1].RowsPerMeasure)
+	assert.Equal(t, properties, MptmProperties(m.Patterns))
+
+	// Broken properties are skipped with a warning.
+	data[len(data)-len(properties)-4+4] = 'X' // The container ID
+	itm, err = (&ItReader{}).ReadItModule(bytes.NewReader(data))
+	assert.NoError(t, err)
+	assert.Zero(t, itm.Patterns[1].RowsPerBeat)
+	assert.Len(t, itm.Warnings, 1)
+
+	// Files without properties still load.
+	data = binary.LittleEndian.AppendUint32(data[:len(data)-len(properties)-4], 0)
+	itm, err = (&ItReader{}).ReadItModule(bytes.NewReader(data))
+	assert.NoError(t, err)
+	assert.Zero(t, itm.Patterns[1].RowsPerBeat)
+}
+
+func TestInvalidChannels(t *testing.T) {
+	// Channel 2 with a note, channel 66 (folds onto 2) with a note, then channel 5 reusing
+	// its mask.
+	data := []byte{
+		0x82, PmaskNote, 60,
+		0x80 | 66, PmaskNote, 61,
+		0,
+		0x85, PmaskIns, 1,
+		0,
+	}
+	itm := &ItModule{Patterns: []ItPattern{{Header: ItPatternHeader{Rows: 2}, Data: data}}}
+	for c := range itm.Header.ChannelPan {
+		itm.Header.ChannelPan[c] = 32
+		if c >= 4 {
+			itm.Header.ChannelPan[c] |= channelDisabled
+		}
+	}
+	assert.Equal(t, 4, itm.Header.DeclaredChannels())
+
+	itm.checkChannels(false)
+	assert.Equal(t, []int{4, 65}, itm.Patterns[0].InvalidChannels)
+	assert.Equal(t, []string{"pattern 0: 2 entries on invalid channels 5,66 (4 declared), kept"}, itm.Warnings)
+	p := itm.Patterns[0].ToCommon()
+	assert.Len(t, p.Rows[0].Entries, 2)
+	assert.EqualValues(t, 1, p.Rows[0].Entries[1].Channel)
+	assert.EqualValues(t, 5, p.Channels)
+
+	itm.Warnings = nil
+	itm.checkChannels(true)
+	p = itm.Patterns[0].ToCommon()
+	assert.Equal(t, []common.PatternEntry{{Channel: 1, Note: 61}}, p.Rows[0].Entries)
+	assert.Empty(t, p.Rows[1].Entries)
+	assert.EqualValues(t, 2, p.Channels)
+}
+
+func TestEnvelopeCarry(t *testing.T) {
+	itenv := ItEnvelope{Flags: EnvFlagEnabled | EnvFlagCarry, NodeCount: 2}
+	itenv.Nodes[1] = EnvelopeNode{Y: 64, X: 10}
+	env := translateEnvelope(&itenv, 0)
+	assert.True(t, env.Carry)
+	assert.True(t, env.Enabled)
+	assert.False(t, env.Loop)
+
+	itenv.Flags = EnvFlagEnabled
+	assert.False(t, translateEnvelope(&itenv, 0).Carry)
+}
+
+func TestLibrary(t *testing.T) {
+	its := func(name string, data []byte) []byte {
+		header := ItSampleHeader{Flags: SampFlagHeader, Convert: SampConvSigned, Length: uint32(len(data)), C5: 8363, GlobalVolume: 64}
+		copy(header.Name[:], name)
+		b, _ := io.ReadAll(sampleFile(header, data))
+		return b
+	}
+
+	// An instrument with two samples, one for the lower half of the keyboard.
+	var iti bytes.Buffer
+	ins := ItInstrument{FileCode: [4]byte{'I', 'M', 'P', 'I'}, NumberOfSamples: 2, GlobalVolume: 128}
+	copy(ins.Name[:], "Piano")
+	for i := range ins.Notemap {
+		ins.Notemap[i] = NotemapEntry{Note: uint8(i), Sample: uint8(1 + i/60)}
+	}
+	binary.Write(&iti, binary.LittleEndian, ins)
+	iti.Write(make([]byte, itiHeaderSize-iti.Len()))
+	headerSize := binary.Size(ItSampleHeader{})
+	for i, name := range []string{"low", "high"} {
+		header := ItSampleHeader{FileCode: [4]byte{'I', 'M', 'P', 'S'}, Flags: SampFlagHeader, Convert: SampConvSigned, Length: 2}
+		copy(header.Name[:], name)
+		header.SamplePointer = uint32(itiHeaderSize + 2*headerSize + i*2)
+		binary.Write(&iti, binary.LittleEndian, header)
+	}
+	iti.Write([]byte{1, 2, 3, 4})
+
+	lib, err := (&ItReader{}).LoadLibrary(fstest.MapFS{
+		"drums/Kick.ITS":  &fstest.MapFile{Data: its("Kick", []byte{1, 2, 3})},
+		"drums/snare.its": &fstest.MapFile{Data: its("Snare", []byte{4})},
+		"keys/piano.iti":  &fstest.MapFile{Data: iti.Bytes()},
+		"broken.its":      &fstest.MapFile{Data: []byte("IMPS")},
+		"readme.txt":      &fstest.MapFile{Data: []byte("hi")},
+	})
+	assert.NoError(t, err)
+	assert.Len(t, lib.Samples, 2)
+	assert.Len(t, lib.Warnings, 1)
+	assert.Contains(t, lib.Warnings[0], "broken.its")
+	assert.Equal(t, "Kick", lib.Samples[0].Sample.Name)
+	assert.Equal(t, []int8{1, 2, 3}, lib.Samples[0].Sample.Data.Data[0])
+
+	assert.Len(t, lib.Instruments, 1)
+	piano := &lib.Instruments[0]
+	assert.Equal(t, "Piano", piano.Instrument.Name)
+	assert.Equal(t, "high", piano.Samples[1].Name)
+	assert.Equal(t, []int8{3, 4}, piano.Samples[1].Data.Data[0])
+
+	assert.Len(t, lib.FindSamples("DRUMS"), 2)
+	assert.Len(t, lib.FindSamples("snare"), 1)
+	assert.Len(t, lib.FindInstruments("pia"), 1)
+	assert.Empty(t, lib.FindInstruments("guitar"))
+
+	// Samples fill empty slots first, then go after the last sample.
+	m := &common.Module{Samples: []common.Sample{{Name: "one"}, {}, {Name: "three"}}}
+	n, err := lib.FindSamples("kick")[0].AddTo(m)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	n, err = piano.AddTo(m)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Len(t, m.Samples, 5)
+	assert.Equal(t, int16(4), m.Instruments[0].Notemap[0].Sample)
+	assert.Equal(t, int16(5), m.Instruments[0].Notemap[119].Sample)
+
+	m.Samples = make([]common.Sample, maxItSlots)
+	for i := range m.Samples {
+		m.Samples[i].Name = "used"
+	}
+	_, err = lib.Samples[0].AddTo(m)
+	assert.ErrorIs(t, err, ErrLibraryFull)
+}
+
+func TestNoteActions(t *testing.T) {
+	assert.EqualValues(t, 1, translateNote(0))
+	assert.EqualValues(t, 120, translateNote(119))
+	assert.EqualValues(t, common.NoteFade, translateNote(120))
+	assert.EqualValues(t, common.NoteFade, translateNote(253))
+	assert.EqualValues(t, common.NoteCut, translateNote(254))
+	assert.EqualValues(t, common.NoteOff, translateNote(255))
+}
+
+func TestTranslateRoundTrip(t *testing.T) {
+	for note := 0; note < 256; note++ {
+		n := translateNote(uint8(note))
+		it, ok := untranslateNote(n)
+		assert.True(t, ok)
+		assert.Equal(t, n, translateNote(it))
+	}
+	_, ok := untranslateNote(0)
+	assert.False(t, ok)
+	_, ok = untranslateNote(121)
+	assert.False(t, ok)
+
+	for vol := 0; vol < 256; vol++ {
+		command, param := translatePatternVolume(uint8(vol))
+		it, ok := untranslatePatternVolume(command, param)
+		if command == 0 {
+			assert.False(t, ok, vol)
+			continue
+		}
+		assert.True(t, ok, vol)
+		assert.EqualValues(t, vol, it)
+	}
+
+	check := func(vol, command, param uint8) {
+		c, p := translatePatternVolume(vol)
+		assert.Equal(t, [2]uint8{command, param}, [2]uint8{c, p}, vol)
+	}
+	check(64, common.VcmdSetVolume, 64)
+	check(124, common.VcmdPitchSlideUp, 9)
+	check(125, 0, 0)
+	check(128, common.VcmdSetPan, 0)
+	check(192, common.VcmdSetPan, 64)
+	check(193, common.VcmdPortaToNote, 0)
+	check(212, common.VcmdVibratoDepth, 9)
+	check(213, 0, 0)
+
+	_, ok = untranslatePatternVolume(common.VcmdFineVolUp, 10)
+	assert.False(t, ok)
+}
+
+func TestCompressionDetection(t *testing.T) {
+	data := make([]int16, 2000)
+	for i := range data {
+		data[i] = int16(8000 * math.Sin(float64(i)/10))
+	}
+	var pcm bytes.Buffer
+	binary.Write(&pcm, binary.LittleEndian, data)
+
+	for _, it215 := range []bool{false, true} {
+		codec := ItSampleCodec{Is16: true, It215: it215}
+		encoded, err := codec.Encode(bytes.NewReader(pcm.Bytes()), len(data))
+		assert.NoError(t, err)
+		header := ItSampleHeader{Flags: SampFlagHeader | SampFlag16bit | SampFlagCompressed, Convert: SampConvSigned, Length: uint32(len(data))}
+		if it215 {
+			header.Convert |= SampConvDelta
+		}
+
+		// Detected from the delta flag.
+		its, err := (&ItReader{}).ReadItSampleAuto(sampleFile(header, encoded))
+		assert.NoError(t, err)
+		assert.Equal(t, data, its.Data[0], "IT215 %v", it215)
+
+		// ReadItSample uses the compression it's given.
+		its, err = (&ItReader{}).ReadItSample(sampleFile(header, encoded), it215)
+		assert.NoError(t, err)
+		assert.Equal(t, data, its.Data[0], "IT215 %v", it215)
+		its, err = (&ItReader{}).ReadItSample(sampleFile(header, encoded), !it215)
+		assert.NoError(t, err)
+		assert.NotEqual(t, data, its.Data[0], "IT215 %v", it215)
+
+		// Files from IT 2.14 and 2.15 are detected the same way.
+		cwtv := iif[uint16](it215, 0x0215, 0x0214)
+		itm, err := (&ItReader{}).ReadItModule(itFile(ItModuleHeader{Cwtv: cwtv, Cmwt: cwtv}, nil,
+			[]ItSampleHeader{header}, [][]byte{encoded}))
+		assert.NoError(t, err)
+		assert.Equal(t, data, itm.Samples[0].Data[0], "IT215 %v", it215)
+
+		// A file that marks its samples wrongly can be loaded with an override.
+		header.Convert ^= SampConvDelta
+		compression := iif(it215, CompressionIt215, CompressionIt214)
+		its, err = (&ItReader{Compression: compression}).ReadItSampleAuto(sampleFile(header, encoded))
+		assert.NoError(t, err)
+		assert.Equal(t, data, its.Data[0], "IT215 %v", it215)
+
+		itm, err = (&ItReader{Compression: compression}).ReadItModule(itFile(ItModuleHeader{Cwtv: cwtv, Cmwt: cwtv}, nil,
+			[]ItSampleHeader{header}, [][]byte{encoded}))
+		assert.NoError(t, err)
+		assert.Equal(t, data, itm.Samples[0].Data[0], "IT215 %v", it215)
+
+		its, err = (&ItReader{}).ReadItSampleAuto(sampleFile(header, encoded))
+		assert.NoError(t, err)
+		assert.NotEqual(t, data, its.Data[0], "IT215 %v", it215)
+	}
+
+	// Uncompressed delta samples still aren't supported.
+	_, err := (&ItReader{}).ReadItSample(sampleFile(ItSampleHeader{Flags: SampFlagHeader, Convert: SampConvDelta, Length: 1}, []byte{1}), false)
+	assert.ErrorIs(t, err, ErrUnsupportedSource)
+}
+
+func TestEmptySampleSlots(t *testing.T) {
+	// Without the header flag, the pointer isn't followed even if it's set.
+	noFlag := sampleFile(ItSampleHeader{Convert: SampConvSigned, Length: 2}, []byte{1, 2})
+	its, err := (&ItReader{}).ReadItSample(noFlag, false)
+	assert.NoError(t, err)
+	assert.Nil(t, its.Data)
+	s := its.ToCommon()
+	assert.False(t, s.HasData())
+
+	// A zero pointer would read the start of the file.
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, ItSampleHeader{FileCode: [4]byte{'I', 'M', 'P', 'S'}, Flags: SampFlagHeader, Length: 4})
+	its, err = (&ItReader{}).ReadItSample(bytes.NewReader(buf.Bytes()), false)
+	assert.NoError(t, err)
+	assert.Nil(t, its.Data)
+
+	silence := sampleFile(ItSampleHeader{Flags: SampFlagHeader, Convert: SampConvSigned, Length: 2}, []byte{0, 0})
+	its, err = (&ItReader{}).ReadItSample(silence, false)
+	assert.NoError(t, err)
+	s = its.ToCommon()
+	assert.True(t, s.HasData())
+}
+
+func TestLogger(t *testing.T) {
+	f, err := os.Open("test/reflection.it")
+	assert.NoError(t, err)
+	defer f.Close()
+
+	var log bytes.Buffer
+	reader := ItReader{Logger: slog.New(slog.NewTextHandler(&log, &slog.HandlerOptions{Level: slog.LevelDebug}))}
+	_, err = reader.ReadItModule(f)
+	assert.NoError(t, err)
+	assert.Contains(t, log.String(), "msg=\"it header\"")
+	assert.Contains(t, log.String(), "msg=\"it sample\" index=0 offset=")
+}
+
+func TestArena(t *testing.T) {
+	data, err := os.ReadFile("test/reflection.it")
+	assert.NoError(t, err)
+	itm, err := (&ItReader{}).ReadItModule(bytes.NewReader(data))
+	assert.NoError(t, err)
+	expected := itm.ToCommon()
+
+	var arena common.Arena
+	for range 2 {
+		itm, err = (&ItReader{Arena: &arena}).ReadItModule(bytes.NewReader(data))
+		assert.NoError(t, err)
+		m := itm.ToCommon()
+		for i := range m.Patterns {
+			assert.True(t, expected.Patterns[i].Equal(&m.Patterns[i]))
+		}
+		arena.Reset()
+	}
+}
+
+func TestPatternDecoder(t *testing.T) {
+	itm, err := LoadITFile("test/reflection.it")
+	assert.NoError(t, err)
+
+	for i := range itm.Patterns {
+		itp := &itm.Patterns[i]
+		p := itp.ToCommon()
+		count := 0
+		for index, row := range itp.Rows() {
+			assert.Equal(t, count, index)
+			assert.Equal(t, len(p.Rows[index].Entries), len(row.Entries))
+			if len(row.Entries) > 0 {
+				assert.Equal(t, p.Rows[index].Entries, row.Entries)
+			}
+			count++
+		}
+		assert.Equal(t, len(p.Rows), count)
+	}
+
+	// Rows are decoded into the same buffer, so reading doesn't allocate once it's grown.
+	d := itm.Patterns[0].Decoder()
+	d.Next()
+	assert.Equal(t, 1, d.Row())
+	allocs := testing.AllocsPerRun(10, func() {
+		d.Reset()
+		for _, ok := d.Next(); ok; _, ok = d.Next() {
+		}
+	})
+	assert.Zero(t, allocs)
+	assert.Equal(t, int(itm.Patterns[0].Header.Rows), d.Row())
+
+	d.Reset()
+	row, ok := d.Next()
+	assert.True(t, ok)
+	assert.Equal(t, itm.Patterns[0].ToCommon().Rows[0].Entries, append([]common.PatternEntry(nil), row.Entries...))
+}
+
+func TestStreamedSamples(t *testing.T) {
+	var pcm bytes.Buffer
+	for i := range 2000 {
+		binary.Write(&pcm, binary.LittleEndian, uint16(i*30)) // Unsigned, left then right.
+	}
+	header := ItSampleHeader{Flags: SampFlagHeader | SampFlag16bit | SampFlagStereo, Length: 1000}
+
+	loaded, err := (&ItReader{}).ReadItSample(sampleFile(header, pcm.Bytes()), false)
+	assert.NoError(t, err)
+	assert.Nil(t, loaded.Stream)
+
+	// Samples over the threshold are left in the file.
+	reader := &ItReader{StreamThreshold: 1024}
+	its, err := reader.ReadItSample(sampleFile(header, pcm.Bytes()), false)
+	assert.NoError(t, err)
+	assert.Nil(t, its.Data)
+	s := its.ToCommon()
+	assert.True(t, s.HasData())
+	assert.Equal(t, 1000, s.Stream.Frames)
+
+	assert.NoError(t, s.LoadStream())
+	assert.Nil(t, s.Stream)
+	assert.Equal(t, loaded.Data, s.Data.Data)
+
+	// Smaller samples are loaded.
+	reader.StreamThreshold = 4000
+	its, _ = reader.ReadItSample(sampleFile(header, pcm.Bytes()), false)
+	assert.Nil(t, its.Stream)
+	assert.Equal(t, loaded.Data, its.Data)
+}
@@ -0,0 +1,214 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package itmod
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Instrument structure used by files with cmwt < 0x200. There's only a volume envelope,
+// stored both as a list of nodes and as a table with the value for each tick.
+type ItOldInstrument struct {
+	FileCode    [4]byte
+	DosFilename [12]byte
+
+	_ byte
+
+	EnvelopeFlags uint8 // EnvFlagEnabled, EnvFlagLoop, EnvFlagSustain
+	LoopStart     uint8 // Node numbers
+	LoopEnd       uint8
+	SustainStart  uint8
+	SustainEnd    uint8
+
+	_ [2]byte
+
+	Fadeout            uint16 // 0-128, half the scale of the new format
+	NewNoteAction      uint8
+	DuplicateCheckType uint8 // 0 = off, 1 = on
+	TrackerVersion     uint16
+	NumberOfSamples    uint8
+
+	_ byte
+
+	Name [26]byte
+
+	_ [6]byte
+
+	Notemap [120]NotemapEntry
+
+	// Volume (0-64) for each tick of the envelope.
+	VolumeTable [200]uint8
+
+	// Tick 0xFF marks the end of the list.
+	Nodes [25]OldEnvelopeNode
+}
+
+type OldEnvelopeNode struct {
+	Tick  uint8
+	Value uint8
+}
+
+// Marks the end of the node list in an old instrument.
+const oldEnvelopeEnd = 0xFF
+
+// Read out an old format IT instrument from the stream.
+func (reader *ItReader) ReadItOldInstrument(r io.Reader) (ItOldInstrument, error) {
+	var iti ItOldInstrument
+
+	if err := binary.Read(r, binary.LittleEndian, &iti); err != nil {
+		return iti, err
+	}
+
+	if string(iti.FileCode[:]) != "IMPI" {
+		if reader.Strict {
+			return iti, fmt.Errorf("%w: strict - expected 'IMPI' header", ErrInvalidSource)
+		}
+	}
+
+	return iti, nil
+}
+
+// Convert to the new instrument format. The volume envelope is rebuilt from the tick table
+// by simplifying the curve, keeping the nodes that the loop and sustain points refer to.
+// Panning and pitch envelopes are disabled.
+func (old *ItOldInstrument) ToNewFormat() ItInstrument {
+	iti := ItInstrument{
+		FileCode:      old.FileCode,
+		DosFilename:   old.DosFilename,
+		NewNoteAction: old.NewNoteAction,
+		Fadeout:       old.Fadeout * 2,
+		PPC:           60,
+		GlobalVolume:  128,
+		DefaultPan:    32 | 128,
+		MidiProgram:   0xFF,
+		MidiBank:      0xFFFF,
+
+		TrackerVersion:  old.TrackerVersion,
+		NumberOfSamples: old.NumberOfSamples,
+		Name:            old.Name,
+		Notemap:         old.Notemap,
+	}
+
+	if old.DuplicateCheckType != 0 {
+		// Old instruments could only cut duplicate notes.
+		iti.DuplicateCheckType = 1
+		iti.DuplicateCheckAction = 0
+	}
+
+	iti.Envelopes[0] = old.volumeEnvelope()
+	return iti
+}
+
+// The ticks of the listed envelope nodes.
+func (old *ItOldInstrument) nodeTicks() []int {
+	var ticks []int
+	for _, node := range old.Nodes {
+		if node.Tick == oldEnvelopeEnd || int(node.Tick) >= len(old.VolumeTable) {
+			break
+		}
+		if len(ticks) > 0 && int(node.Tick) <= ticks[len(ticks)-1] {
+			break
+		}
+		ticks = append(ticks, int(node.Tick))
+	}
+	return ticks
+}
+
+// Rebuild the volume envelope from the tick table.
+func (old *ItOldInstrument) volumeEnvelope() ItEnvelope {
+	env := ItEnvelope{Flags: old.EnvelopeFlags & (EnvFlagEnabled | EnvFlagLoop | EnvFlagSustain)}
+
+	ticks := old.nodeTicks()
+	length := len(old.VolumeTable)
+	if len(ticks) > 0 {
+		length = ticks[len(ticks)-1] + 1
+	} else {
+		// Without nodes, trim the flat tail of the table.
+		for length > 1 && old.VolumeTable[length-1] == old.VolumeTable[length-2] {
+			length--
+		}
+	}
+
+	keep := []int{0, length - 1}
+	for _, index := range []uint8{old.LoopStart, old.LoopEnd, old.SustainStart, old.SustainEnd} {
+		if int(index) < len(ticks) {
+			keep = append(keep, ticks[index])
+		}
+	}
+
+	points := simplifyEnvelope(old.VolumeTable[:length], keep, len(env.Nodes))
+	env.NodeCount = uint8(len(points))
+	for i, tick := range points {
+		env.Nodes[i] = EnvelopeNode{X: uint16(tick), Y: int8(min(old.VolumeTable[tick], 64))}
+	}
+
+	nodeIndex := func(index uint8) uint8 {
+		if int(index) >= len(ticks) {
+			return 0
+		}
+		for i, tick := range points {
+			if tick == ticks[index] {
+				return uint8(i)
+			}
+		}
+		return 0
+	}
+	env.LoopStart = nodeIndex(old.LoopStart)
+	env.LoopEnd = nodeIndex(old.LoopEnd)
+	env.SustainStart = nodeIndex(old.SustainStart)
+	env.SustainEnd = nodeIndex(old.SustainEnd)
+
+	return env
+}
+
+// Reduce a per-tick curve to a list of node ticks with the Ramer-Douglas-Peucker algorithm.
+// Ticks in `keep` are always included. The tolerance starts at one volume step, which
+// absorbs the rounding in the table, and grows until the nodes fit in maxNodes.
+func simplifyEnvelope(table []uint8, keep []int, maxNodes int) []int {
+	for tolerance := 1.0; ; tolerance *= 2 {
+		selected := make([]bool, len(table))
+		for _, tick := range keep {
+			selected[tick] = true
+		}
+
+		var split func(a, b int)
+		split = func(a, b int) {
+			worst, worstError := -1, tolerance
+			for i := a + 1; i < b; i++ {
+				line := float64(table[a]) + float64(int(table[b])-int(table[a]))*float64(i-a)/float64(b-a)
+				if e := math.Abs(float64(table[i]) - line); e > worstError {
+					worst, worstError = i, e
+				}
+			}
+			if worst >= 0 {
+				selected[worst] = true
+				split(a, worst)
+				split(worst, b)
+			}
+		}
+
+		// Simplify each span between the nodes that must be kept.
+		prev := 0
+		for i := 1; i < len(table); i++ {
+			if selected[i] {
+				split(prev, i)
+				prev = i
+			}
+		}
+
+		var points []int
+		for i, s := range selected {
+			if s {
+				points = append(points, i)
+			}
+		}
+		if len(points) <= maxNodes || tolerance > 64 {
+			return points[:min(len(points), maxNodes)]
+		}
+	}
+}
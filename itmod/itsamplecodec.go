@@ -8,6 +8,9 @@ import (
 	"encoding/binary"
 	"errors"
 	"io"
+	"sync"
+
+	"go.mukunda.com/modlib/bitio"
 )
 
 /*
@@ -27,6 +30,10 @@ type ItSampleCodec struct {
 
 var ErrDecodingError = errors.New("decoding error")
 
+// Errors from reading the bits of a compressed block.
+var ErrEndOfStream = bitio.ErrEndOfStream
+var ErrBadParam = bitio.ErrBadWidth
+
 type itSampleCodecParams struct {
 	lowerTab []int16
 	upperTab []int16
@@ -63,43 +70,54 @@ var itSampleCodecParams8 = itSampleCodecParams{
 // For 8-bit samples, the result needs to be converted. Each int16 contains only one 8-bit
 // sample.
 func (self *ItSampleCodec) Decode(r io.Reader, sampleLength int) ([]int16, error) {
-	totalData := []int16{}
+	// The length comes from the file, so only one block is allocated up front; a corrupt
+	// length runs out of blocks instead of memory.
+	totalData := make([]int16, 0, min(sampleLength, 32*1024))
 
-	remainingLength := sampleLength
-	for remainingLength > 0 {
-		chunk, err := self.decodeChunk(r, remainingLength)
+	for len(totalData) < sampleLength {
+		var err error
+		totalData, err = self.decodeChunk(r, sampleLength-len(totalData), totalData)
 		if err != nil {
 			return nil, err
 		}
-		totalData = append(totalData, chunk...)
-		remainingLength -= len(chunk)
 	}
 
 	return totalData, nil
 }
 
-func (*ItSampleCodec) getChunk(r io.Reader) (bitstream, error) {
+// Buffers for reading compressed blocks, which are up to 64 KiB. Loading many modules, as
+// the scanner does, would otherwise allocate one for every block.
+var chunkBuffers = sync.Pool{
+	New: func() any { return new([]byte) },
+}
+
+// Read a compressed block into buf, which is returned to chunkBuffers when the block is
+// decoded.
+func (*ItSampleCodec) getChunk(r io.Reader, buf *[]byte) (*bitio.Reader, error) {
 	// Read in a chunk.
 	var byteLength uint16
 	err := binary.Read(r, binary.LittleEndian, &byteLength)
 	if err != nil {
-		return bitstream{}, err
+		return nil, err
 	}
 
-	bytes := make([]byte, byteLength)
-	err = binary.Read(r, binary.LittleEndian, &bytes)
-	if err != nil {
-		return bitstream{}, err
+	if cap(*buf) < int(byteLength) {
+		*buf = make([]byte, 0xFFFF)
+	}
+	bytes := (*buf)[:byteLength]
+	if _, err := io.ReadFull(r, bytes); err != nil {
+		return nil, err
 	}
 
-	return createBitstream(bytes), nil
+	return bitio.NewReader(bytes), nil
 }
 
-func (c *ItSampleCodec) decodeChunk(r io.Reader, remainingLength int) ([]int16, error) {
-
-	var decoded []int16
+// Decode a compressed block, appending its samples to decoded.
+func (c *ItSampleCodec) decodeChunk(r io.Reader, remainingLength int, decoded []int16) ([]int16, error) {
+	buf := chunkBuffers.Get().(*[]byte)
+	defer chunkBuffers.Put(buf)
 
-	dataSource, err := c.getChunk(r)
+	dataSource, err := c.getChunk(r, buf)
 	if err != nil {
 		return nil, err
 	}
@@ -151,7 +169,7 @@ func (c *ItSampleCodec) decodeChunk(r io.Reader, remainingLength int) ([]int16,
 			return nil, ErrDecodingError
 		}
 
-		vu, err := dataSource.read(width)
+		vu, err := dataSource.Read(width)
 		if err != nil {
 			return nil, err
 		}
@@ -161,7 +179,7 @@ func (c *ItSampleCodec) decodeChunk(r io.Reader, remainingLength int) ([]int16,
 		if width <= 6 {
 			// Mode A: 1 to 6 bits
 			if v == topBit {
-				toWidth, err := dataSource.read(props.fetchA)
+				toWidth, err := dataSource.Read(props.fetchA)
 				if err != nil {
 					return nil, err
 				}
@@ -189,7 +207,176 @@ func (c *ItSampleCodec) decodeChunk(r io.Reader, remainingLength int) ([]int16,
 	return decoded, nil
 }
 
-// Todo: encoding.
-func (*ItSampleCodec) Encode(r io.Reader, sampleLength int) ([]byte, error) {
-	return nil, nil
+// Bits used by a width change from each width, 1-based.
+var itWidthChangeSize = []int{4, 5, 6, 7, 8, 9, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17}
+
+// Encodes a sample read from the stream, as signed 8-bit or little-endian 16-bit PCM.
+// sampleLength is measured in samples. The result is the compressed blocks, each with its
+// length in front, as stored in IT files.
+func (c *ItSampleCodec) Encode(r io.Reader, sampleLength int) ([]byte, error) {
+	data := make([]int16, sampleLength)
+	if c.Is16 {
+		if err := binary.Read(r, binary.LittleEndian, data); err != nil {
+			return nil, err
+		}
+	} else {
+		data8 := make([]int8, sampleLength)
+		if err := binary.Read(r, binary.LittleEndian, data8); err != nil {
+			return nil, err
+		}
+		for i, v := range data8 {
+			data[i] = int16(v)
+		}
+	}
+
+	maxBlockLength := 32 * 1024
+	if c.Is16 {
+		maxBlockLength /= 2
+	}
+
+	var encoded []byte
+	for len(data) > 0 {
+		length := min(len(data), maxBlockLength)
+		block := c.encodeChunk(data[:length])
+		encoded = binary.LittleEndian.AppendUint16(encoded, uint16(len(block)))
+		encoded = append(encoded, block...)
+		data = data[length:]
+	}
+	return encoded, nil
+}
+
+// Compress one block, choosing the bit width of each sample like OpenMPT does.
+func (c *ItSampleCodec) encodeChunk(samples []int16) []byte {
+	props := &itSampleCodecParams8
+	if c.Is16 {
+		props = &itSampleCodecParams16
+	}
+
+	// Wrap the deltas to the sample size, like the decoder does.
+	wrap := func(v int) int16 {
+		if c.Is16 {
+			return int16(v)
+		}
+		return int16(int8(v))
+	}
+	deltas := make([]int16, len(samples))
+	prev := 0
+	for i, v := range samples {
+		deltas[i] = wrap(int(v) - prev)
+		prev = int(v)
+	}
+	if c.It215 {
+		prev = 0
+		for i, v := range deltas {
+			deltas[i] = wrap(int(v) - prev)
+			prev = int(v)
+		}
+	}
+
+	e := &itSampleEncoder{
+		props:  props,
+		is16:   c.Is16,
+		data:   deltas,
+		widths: make([]int, len(deltas)),
+	}
+	e.squish(props.defWidth, props.defWidth, props.defWidth, props.defWidth-2, 0, len(deltas))
+
+	var out bitio.Writer
+	width := props.defWidth
+	for i, v := range deltas {
+		if e.widths[i] != width {
+			to := e.widths[i] - 1
+			if to > width-1 {
+				to--
+			}
+			topBit := 1 << (width - 1)
+			switch {
+			case width <= 6:
+				// Mode A: 1 to 6 bits
+				out.Write(width, uint32(topBit))
+				out.Write(props.fetchA, uint32(to))
+			case width < props.defWidth:
+				// Mode B: 7 to 8 / 16 bits
+				out.Write(width, uint32(topBit+props.lowerB+to))
+			default:
+				// Mode C: 9 / 17 bits
+				out.Write(width, uint32(topBit+e.widths[i]-1))
+			}
+			width = e.widths[i]
+		}
+		out.Write(width, uint32(int(v)&props.mask))
+	}
+	return out.Bytes()
+}
+
+type itSampleEncoder struct {
+	props  *itSampleCodecParams
+	is16   bool
+	data   []int16
+	widths []int // Bit width chosen for each sample.
+}
+
+func (e *itSampleEncoder) widthChangeSize(width int) int {
+	size := itWidthChangeSize[width-1]
+	if width <= 6 && e.is16 {
+		size++
+	}
+	return size
+}
+
+// Narrow the bit width of runs of samples that fit in fewer bits, where the width
+// changes cost less than they save. Ported from OpenMPT's SquishRecurse.
+func (e *itSampleEncoder) squish(sWidth, lWidth, rWidth, width, offset, length int) {
+	if width+1 < 1 {
+		for i := offset; i < offset+length; i++ {
+			e.widths[i] = sWidth
+		}
+		return
+	}
+
+	lower, upper := e.props.lowerTab[width], e.props.upperTab[width]
+	fits := func(i int) bool { return e.data[i] >= lower && e.data[i] <= upper }
+	end := offset + length
+	for i := offset; i < end; {
+		if !fits(i) {
+			e.widths[i] = sWidth
+			i++
+			continue
+		}
+
+		start := i
+		for i < end && fits(i) {
+			i++
+		}
+		blockLength := i - start
+		xlWidth, xrWidth := sWidth, sWidth
+		if start == offset {
+			xlWidth = lWidth
+		}
+		if i == end {
+			xrWidth = rWidth
+		}
+
+		wcsl := e.widthChangeSize(xlWidth)
+		wcss := e.widthChangeSize(sWidth)
+		wcsw := e.widthChangeSize(width + 1)
+		keepDown := wcsl + (width+1)*blockLength
+		levelLeft := wcsl + sWidth*blockLength
+		if xlWidth == sWidth {
+			levelLeft -= wcsl
+		}
+		if i != len(e.data) {
+			keepDown += wcsw
+			levelLeft += wcss
+			if xrWidth == sWidth {
+				levelLeft -= wcss
+			}
+		}
+
+		newWidth := sWidth
+		if keepDown <= levelLeft {
+			newWidth = width + 1
+		}
+		e.squish(newWidth, xlWidth, xrWidth, width-1, start, blockLength)
+	}
 }
@@ -0,0 +1,256 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package itmod
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+
+	"go.mukunda.com/modlib/common"
+)
+
+// Returned when a module has no room for another sample or instrument.
+var ErrLibraryFull = errors.New("no free sample or instrument slots")
+
+// Most samples or instruments an IT module can have.
+const maxItSlots = 99
+
+// Size of the instrument header in an ITI file. The sample headers follow it.
+const itiHeaderSize = 554
+
+// A sample from an .its file.
+type LibrarySample struct {
+	Path   string // Path of the file in the library.
+	Sample common.Sample
+}
+
+// An instrument from an .iti file, with its samples. The notemap refers to Samples,
+// starting at 1.
+type LibraryInstrument struct {
+	Path       string
+	Instrument common.Instrument
+	Samples    []common.Sample
+}
+
+// Samples and instruments loaded from .its and .iti files, such as a sample pack.
+type Library struct {
+	Samples     []LibrarySample
+	Instruments []LibraryInstrument
+
+	// Files that couldn't be loaded and were skipped.
+	Warnings []string
+}
+
+// Load every .its and .iti file in a file system into a library. Use os.DirFS for a
+// directory or zip.NewReader for an archive.
+func (reader *ItReader) LoadLibrary(fsys fs.FS) (*Library, error) {
+	lib := &Library{}
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		ext := strings.ToLower(path.Ext(name))
+		if d.IsDir() || (ext != ".its" && ext != ".iti") {
+			return nil
+		}
+
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+
+		if ext == ".its" {
+			s, err := reader.ReadSampleFile(bytes.NewReader(data))
+			if err != nil {
+				lib.Warnings = append(lib.Warnings, fmt.Sprintf("%s: %v", name, err))
+				return nil
+			}
+			lib.Samples = append(lib.Samples, LibrarySample{Path: name, Sample: s})
+			return nil
+		}
+
+		ins, samples, err := reader.ReadInstrumentFile(bytes.NewReader(data))
+		if err != nil {
+			lib.Warnings = append(lib.Warnings, fmt.Sprintf("%s: %v", name, err))
+			return nil
+		}
+		lib.Instruments = append(lib.Instruments, LibraryInstrument{Path: name, Instrument: ins, Samples: samples})
+		return nil
+	})
+	return lib, err
+}
+
+// Read an .its file: a sample header followed by its data.
+func (reader *ItReader) ReadSampleFile(r io.ReadSeeker) (common.Sample, error) {
+	its, err := reader.readSampleFile(r)
+	if err != nil {
+		return common.Sample{}, err
+	}
+	return its.ToCommon(), nil
+}
+
+// Read a sample header at the current position of an ITS or ITI file, and its data. The
+// sample pointer is from the start of the file.
+func (reader *ItReader) readSampleFile(r io.ReadSeeker) (ItSample, error) {
+	its, _, err := reader.readItSample(r, reader.Compression, 0x0214)
+	return its, err
+}
+
+// Read an .iti file: an instrument header followed by the headers and data of its
+// samples.
+func (reader *ItReader) ReadInstrumentFile(r io.ReadSeeker) (common.Instrument, []common.Sample, error) {
+	iti, err := reader.ReadItInstrument(r)
+	if err != nil {
+		return common.Instrument{}, nil, err
+	}
+
+	var samples []common.Sample
+	for i := 0; i < int(iti.NumberOfSamples); i++ {
+		offset := int64(itiHeaderSize + i*binary.Size(ItSampleHeader{}))
+		if _, err := r.Seek(offset, io.SeekStart); err != nil {
+			return common.Instrument{}, nil, err
+		}
+		its, err := reader.readSampleFile(r)
+		if err != nil {
+			return common.Instrument{}, nil, fmt.Errorf("sample %d: %w", i+1, err)
+		}
+		samples = append(samples, its.ToCommon())
+	}
+	return iti.ToCommon(), samples, nil
+}
+
+// Find the samples whose name or file name contains the query, ignoring case.
+func (lib *Library) FindSamples(query string) []*LibrarySample {
+	var result []*LibrarySample
+	for i := range lib.Samples {
+		s := &lib.Samples[i]
+		if matchesName(query, s.Path, s.Sample.Name, s.Sample.DosFilename) {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+// Find the instruments whose name or file name contains the query, ignoring case.
+func (lib *Library) FindInstruments(query string) []*LibraryInstrument {
+	var result []*LibraryInstrument
+	for i := range lib.Instruments {
+		ins := &lib.Instruments[i]
+		if matchesName(query, ins.Path, ins.Instrument.Name, ins.Instrument.DosFilename) {
+			result = append(result, ins)
+		}
+	}
+	return result
+}
+
+func matchesName(query string, names ...string) bool {
+	query = strings.ToLower(query)
+	return slices.ContainsFunc(names, func(name string) bool {
+		return strings.Contains(strings.ToLower(name), query)
+	})
+}
+
+// Add the sample to a module, in the first empty sample slot or after the last sample.
+// Returns the sample number (1-based).
+func (s *LibrarySample) AddTo(m *common.Module) (int, error) {
+	slots := freeSampleSlots(m, 1)
+	if slots == nil {
+		return 0, ErrLibraryFull
+	}
+	setSample(m, slots[0], cloneSample(s.Sample))
+	return slots[0] + 1, nil
+}
+
+// Add the instrument and its samples to a module, in the first empty slots or after the
+// last ones. The notemap is updated to the sample numbers in the module. Returns the
+// instrument number (1-based).
+func (ins *LibraryInstrument) AddTo(m *common.Module) (int, error) {
+	slot := -1
+	for i := range m.Instruments {
+		if emptyInstrument(&m.Instruments[i]) {
+			slot = i
+			break
+		}
+	}
+	if slot < 0 {
+		slot = len(m.Instruments)
+	}
+	sampleSlots := freeSampleSlots(m, len(ins.Samples))
+	if slot >= maxItSlots || (sampleSlots == nil && len(ins.Samples) > 0) {
+		return 0, ErrLibraryFull
+	}
+
+	for i, s := range ins.Samples {
+		setSample(m, sampleSlots[i], cloneSample(s))
+	}
+	added := ins.Instrument.Clone()
+	for i := range added.Notemap {
+		entry := &added.Notemap[i]
+		if entry.Sample >= 1 && int(entry.Sample) <= len(sampleSlots) {
+			entry.Sample = int16(sampleSlots[entry.Sample-1] + 1)
+		} else {
+			entry.Sample = 0
+		}
+	}
+
+	if slot == len(m.Instruments) {
+		m.Instruments = append(m.Instruments, added)
+	} else {
+		m.Instruments[slot] = added
+	}
+	return slot + 1, nil
+}
+
+// Find count sample slots (0-based) to put new samples in, reusing empty ones first.
+// Returns nil if there isn't enough room.
+func freeSampleSlots(m *common.Module, count int) []int {
+	var slots []int
+	for i := 0; i < maxItSlots && len(slots) < count; i++ {
+		if i >= len(m.Samples) || emptySample(&m.Samples[i]) {
+			slots = append(slots, i)
+		}
+	}
+	if len(slots) < count {
+		return nil
+	}
+	return slots
+}
+
+// Store a sample in a slot, growing the sample list if needed.
+func setSample(m *common.Module, slot int, s common.Sample) {
+	for len(m.Samples) <= slot {
+		m.Samples = append(m.Samples, common.Sample{})
+	}
+	m.Samples[slot] = s
+}
+
+// Copy a sample so the module doesn't share its data with the library.
+func cloneSample(s common.Sample) common.Sample {
+	s.Data = s.Data.Clone()
+	return s
+}
+
+func emptySample(s *common.Sample) bool {
+	return s.Name == "" && !s.HasData()
+}
+
+func emptyInstrument(ins *common.Instrument) bool {
+	if ins.Name != "" {
+		return false
+	}
+	for _, entry := range ins.Notemap {
+		if entry.Sample != 0 {
+			return false
+		}
+	}
+	return true
+}
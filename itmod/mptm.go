@@ -0,0 +1,374 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package itmod
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"go.mukunda.com/modlib/common"
+)
+
+/*
+MPTM files end with properties that don't fit in the IT format, like per-pattern time
+signatures, tunings and extra order lists. They're stored in OpenMPT's serialization
+containers, and the last four bytes of the file give where they start.
+
+A container starts with "228", its ID and a header, then the data of its entries, then a
+map giving the ID, position and size of each entry. Entries can be containers themselves.
+Integers in the header and map use OpenMPT's "adaptive" encoding, where the low bits of
+the first byte give the number of bytes.
+
+https://github.com/OpenMPT/openmpt/blob/master/common/serialization_utils.cpp
+*/
+
+// IDs of the MPTM containers.
+const (
+	mptmPropertiesID = "mptm"
+	mptmPatternsID   = "mptPc"
+	mptmPatternID    = "mptP"
+)
+
+// Version written in MPTM containers. OpenMPT only warns about versions newer than its
+// own.
+const mptmVersion = 0x01170300
+
+// Header bits of a container.
+const (
+	ssbIDSizeMask  = 3
+	ssbMapStartPos = 4
+	ssbMapSize     = 8
+	ssbVersion     = 16
+	ssbExtraHeader = 32
+	ssbMapDesc     = 128
+)
+
+// Reads a little-endian integer of 1, 2, 4 or 8 bytes, with the byte count in the low two
+// bits of the first byte.
+func readAdaptive64(r *bytes.Reader) (uint64, error) {
+	first, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	value := uint64(first >> 2)
+	for i := range int(1<<(first&3)) - 1 {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		value |= uint64(b) << (8*(i+1) - 2)
+	}
+	return value, nil
+}
+
+// Like readAdaptive64 with 1 to 4 bytes.
+func readAdaptive32(r *bytes.Reader) (uint64, error) {
+	first, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	value := uint64(first >> 2)
+	for i := range int(first & 3) {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		value |= uint64(b) << (8*(i+1) - 2)
+	}
+	return value, nil
+}
+
+// Like readAdaptive64 with 1 or 2 bytes and one bit for the size.
+func readAdaptive16(r *bytes.Reader) (uint64, error) {
+	first, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	value := uint64(first >> 1)
+	if first&1 != 0 {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		value |= uint64(b) << 7
+	}
+	return value, nil
+}
+
+// Append an adaptive integer (see readAdaptive64). size is the number of bytes to use, or
+// 0 for the fewest.
+func appendAdaptive64(b []byte, value uint64, size int) []byte {
+	if size == 0 {
+		switch {
+		case value < 1<<6:
+			size = 1
+		case value < 1<<14:
+			size = 2
+		case value < 1<<30:
+			size = 4
+		default:
+			size = 8
+		}
+	}
+	code := map[int]uint64{1: 0, 2: 1, 4: 2, 8: 3}[size]
+	value = value<<2 | code
+	for range size {
+		b = append(b, byte(value))
+		value >>= 8
+	}
+	return b
+}
+
+// Append a 1 or 2 byte adaptive integer (see readAdaptive16).
+func appendAdaptive16(b []byte, value uint64) []byte {
+	if value < 1<<7 {
+		return append(b, byte(value<<1))
+	}
+	return binary.LittleEndian.AppendUint16(b, uint16(value<<1|1))
+}
+
+// Read the entries of a container at the start of data, which must have the given ID. The
+// entries are returned by ID.
+func readSsb(data []byte, id string) (map[string][]byte, error) {
+	r := bytes.NewReader(data)
+	invalid := func(what string) error {
+		return fmt.Errorf("%w: mptm container %q: %s", ErrInvalidSource, id, what)
+	}
+
+	var magic [3]byte
+	if _, err := io.ReadFull(r, magic[:]); err != nil || string(magic[:]) != "228" {
+		return nil, invalid("bad start bytes")
+	}
+	idLength, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	storedID := make([]byte, idLength)
+	if _, err := io.ReadFull(r, storedID); err != nil {
+		return nil, err
+	}
+	if string(storedID) != id {
+		return nil, invalid(fmt.Sprintf("found %q", storedID))
+	}
+
+	header, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	idBytes := int(header & ssbIDSizeMask)
+	if idBytes == 3 {
+		idBytes = 4
+	}
+
+	flags := byte(0)
+	headerSize, err := readAdaptive32(r)
+	if err != nil {
+		return nil, err
+	}
+	if headerSize > uint64(r.Len()) {
+		return nil, invalid("header past end")
+	}
+	if headerSize >= 2 {
+		kind, _ := r.ReadByte()
+		skip := headerSize - 1
+		if kind == 0 {
+			flags, _ = r.ReadByte()
+			skip--
+		}
+		r.Seek(int64(skip), io.SeekCurrent)
+	}
+	if header&ssbVersion != 0 {
+		if _, err := readAdaptive64(r); err != nil {
+			return nil, err
+		}
+	}
+	if header&ssbExtraHeader != 0 {
+		size, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		r.Seek(int64(size), io.SeekCurrent)
+	}
+	variableIDs := false
+	if flags&1 != 0 {
+		n, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		variableIDs = n&1 != 0
+		idBytes = int(n >> 1)
+	}
+	if flags&6 != 0 {
+		return nil, invalid("fixed-size entries aren't supported")
+	}
+	if header&ssbMapStartPos == 0 || header&ssbMapSize == 0 {
+		return nil, invalid("map without positions and sizes isn't supported")
+	}
+
+	count, err := readAdaptive64(r)
+	if err != nil {
+		return nil, err
+	}
+	mapStart, err := readAdaptive64(r)
+	if err != nil {
+		return nil, err
+	}
+	if count > 16000 || mapStart > uint64(len(data)) {
+		return nil, invalid("bad map")
+	}
+
+	entries := make(map[string][]byte)
+	r = bytes.NewReader(data[mapStart:])
+	for range count {
+		size := uint64(idBytes)
+		if variableIDs {
+			if size, err = readAdaptive16(r); err != nil {
+				return nil, err
+			}
+		}
+		if size > uint64(r.Len()) {
+			return nil, invalid("entry ID past end")
+		}
+		entryID := make([]byte, size)
+		io.ReadFull(r, entryID)
+
+		position, err := readAdaptive64(r)
+		if err != nil {
+			return nil, err
+		}
+		length, err := readAdaptive64(r)
+		if err != nil {
+			return nil, err
+		}
+		if header&ssbMapDesc != 0 {
+			descLength, err := readAdaptive16(r)
+			if err != nil {
+				return nil, err
+			}
+			r.Seek(int64(descLength), io.SeekCurrent)
+		}
+		if position > uint64(len(data)) || length > uint64(len(data))-position {
+			return nil, invalid(fmt.Sprintf("entry %q past end", entryID))
+		}
+		entries[string(entryID)] = data[position : position+length]
+	}
+	return entries, nil
+}
+
+// An entry of a container being written.
+type ssbEntry struct {
+	id   string
+	data []byte
+}
+
+// Encode a container with OpenMPT's default settings: variable-length IDs, and the
+// position and size of each entry in the map.
+func writeSsb(id string, entries []ssbEntry) []byte {
+	b := []byte("228")
+	b = append(b, byte(len(id)))
+	b = append(b, id...)
+	b = append(b, 3|ssbMapStartPos|ssbMapSize|ssbVersion)
+	b = append(b, 2<<2, 0, 1) // Header data: the flag byte, with variable-length IDs.
+	b = appendAdaptive64(b, mptmVersion, 0)
+	b = append(b, 1) // Variable-length IDs.
+	b = appendAdaptive64(b, uint64(len(entries)), 2)
+	mapField := len(b)
+	b = appendAdaptive64(b, 0, 8)
+
+	var entryMap []byte
+	for _, e := range entries {
+		entryMap = appendAdaptive16(entryMap, uint64(len(e.id)))
+		entryMap = append(entryMap, e.id...)
+		entryMap = appendAdaptive64(entryMap, uint64(len(b)), 0)
+		entryMap = appendAdaptive64(entryMap, uint64(len(e.data)), 0)
+		b = append(b, e.data...)
+	}
+	appendAdaptive64(b[:mapField], uint64(len(b)), 8)
+	return append(b, entryMap...)
+}
+
+// Read the per-pattern time signatures from the MPTM properties at the end of the file.
+func (reader *ItReader) readMptmProperties(r io.ReadSeeker, itm *ItModule) error {
+	end, err := r.Seek(-4, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	var start uint32
+	if err := binary.Read(r, binary.LittleEndian, &start); err != nil {
+		return err
+	}
+	if int64(start) >= end || start < uint32(binary.Size(itm.Header)) {
+		common.Debug(reader.Logger, "mptm properties not found", "offset", start)
+		return nil
+	}
+
+	data := make([]byte, end-int64(start))
+	r.Seek(int64(start), io.SeekStart)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return err
+	}
+	if !bytes.HasPrefix(data, []byte("228")) {
+		common.Debug(reader.Logger, "mptm properties not found", "offset", start)
+		return nil
+	}
+	common.Debug(reader.Logger, "mptm properties", "offset", start, "size", len(data))
+
+	properties, err := readSsb(data, mptmPropertiesID)
+	if err != nil {
+		return err
+	}
+	patternData, ok := properties[mptmPatternsID]
+	if !ok {
+		return nil
+	}
+	patterns, err := readSsb(patternData, mptmPatternsID)
+	if err != nil {
+		return err
+	}
+	for i := range itm.Patterns {
+		var id [2]byte
+		binary.LittleEndian.PutUint16(id[:], uint16(i))
+		data, ok := patterns[string(id[:])]
+		if !ok {
+			continue
+		}
+		pattern, err := readSsb(data, mptmPatternID)
+		if err != nil {
+			return err
+		}
+		if rpb, rpm := pattern["RPB."], pattern["RPM."]; len(rpb) == 4 && len(rpm) == 4 {
+			itm.Patterns[i].RowsPerBeat = int(binary.LittleEndian.Uint32(rpb))
+			itm.Patterns[i].RowsPerMeasure = int(binary.LittleEndian.Uint32(rpm))
+		}
+	}
+	return nil
+}
+
+// The MPTM properties for the per-pattern time signatures (Pattern.RowsPerBeat and
+// RowsPerMeasure) of patterns, as OpenMPT stores them at the end of an MPTM file. The
+// file's last four bytes must then be the offset of this data. Patterns without a time
+// signature are left out, and nil is returned if none have one.
+func MptmProperties(patterns []common.Pattern) []byte {
+	var entries []ssbEntry
+	count := 0
+	for i, p := range patterns {
+		if p.RowsPerBeat <= 0 || p.RowsPerMeasure <= 0 {
+			continue
+		}
+		id := binary.LittleEndian.AppendUint16(nil, uint16(i))
+		entries = append(entries, ssbEntry{string(id), writeSsb(mptmPatternID, []ssbEntry{
+			{"RPB.", binary.LittleEndian.AppendUint32(nil, uint32(p.RowsPerBeat))},
+			{"RPM.", binary.LittleEndian.AppendUint32(nil, uint32(p.RowsPerMeasure))},
+		})})
+		count = i + 1
+	}
+	if count == 0 {
+		return nil
+	}
+	entries = append(entries, ssbEntry{"num", binary.LittleEndian.AppendUint16(nil, uint16(count))})
+	return writeSsb(mptmPropertiesID, []ssbEntry{{mptmPatternsID, writeSsb(mptmPatternsID, entries)}})
+}
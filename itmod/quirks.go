@@ -0,0 +1,140 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package itmod
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"slices"
+)
+
+// A fixup applied while loading a file from a tracker that deviates from the format. The
+// loader records which ones were applied in ItModule.Quirks.
+type Quirk int
+
+const (
+	// Instruments use the pre-2.00 layout (cmwt < 0x200) and were converted.
+	QuirkOldInstruments Quirk = iota + 1
+
+	// Versions of IT before 2.14 didn't clear the stereo flag when importing samples, so
+	// it's ignored in those files.
+	QuirkIgnoreStereoFlag
+
+	// MODPlugin stores 4-bit ADPCM samples, marked with Convert = 0xFF.
+	QuirkModPlugAdpcm
+
+	// 16-bit samples stored as 8-bit deltas (Convert bit 4), written by converters for
+	// PolyTracker modules.
+	QuirkDelta8In16
+)
+
+func (q Quirk) String() string {
+	switch q {
+	case QuirkOldInstruments:
+		return "old instrument format"
+	case QuirkIgnoreStereoFlag:
+		return "stereo flag ignored"
+	case QuirkModPlugAdpcm:
+		return "ModPlug ADPCM samples"
+	case QuirkDelta8In16:
+		return "16-bit samples stored as 8-bit deltas"
+	}
+	return fmt.Sprintf("Quirk(%d)", int(q))
+}
+
+// Tracker families identified by cwtv.
+const (
+	TrackerImpulse = "Impulse Tracker"
+	TrackerSchism  = "Schism Tracker"
+	TrackerOpenMpt = "OpenMPT"
+	TrackerModPlug = "ModPlug Tracker"
+	TrackerUnknown = "Unknown"
+)
+
+// Identify the tracker that wrote the file from the cwtv/cmwt fields, following the table
+// documented by OpenMPT.
+func (h *ItModuleHeader) Tracker() string {
+	switch {
+	case h.Cwtv>>12 == 0x1:
+		return TrackerSchism
+	case h.Cwtv>>12 == 0x5:
+		return TrackerOpenMpt
+	case h.Cwtv == 0x0888 || h.Cwtv == 0x0300:
+		return TrackerModPlug
+	case h.Cwtv == 0x0214 && h.Cmwt == 0x0202 && h.Reserved_MPT == 0:
+		// ModPlug Tracker 1.09 and older.
+		return TrackerModPlug
+	case h.Cwtv < 0x0300:
+		return TrackerImpulse
+	}
+	return TrackerUnknown
+}
+
+// Record a quirk, once.
+func (itm *ItModule) addQuirk(q Quirk) {
+	if !slices.Contains(itm.Quirks, q) {
+		itm.Quirks = append(itm.Quirks, q)
+	}
+}
+
+// Sample layout quirks, determined from the sample header and the tracker version.
+func sampleQuirks(header *ItSampleHeader, cwtv uint16) []Quirk {
+	var quirks []Quirk
+	if header.Flags&SampFlagStereo != 0 && cwtv < 0x0214 {
+		quirks = append(quirks, QuirkIgnoreStereoFlag)
+	}
+	if header.Flags&SampFlagCompressed == 0 {
+		bits16 := header.Flags&SampFlag16bit != 0
+		if !bits16 && header.Convert == 0xFF {
+			quirks = append(quirks, QuirkModPlugAdpcm)
+		} else if bits16 && header.Convert&SampConvTxWave != 0 {
+			quirks = append(quirks, QuirkDelta8In16)
+		}
+	}
+	return quirks
+}
+
+// Read a MODPlugin 4-bit ADPCM sample: a table of 16 deltas followed by two nibbles per
+// byte, low nibble first.
+func readAdpcm(r io.Reader, length int) ([]int8, error) {
+	var table [16]int8
+	if err := binary.Read(r, binary.LittleEndian, &table); err != nil {
+		return nil, err
+	}
+
+	packed := make([]byte, (length+1)/2)
+	if _, err := io.ReadFull(r, packed); err != nil {
+		return nil, err
+	}
+
+	data := make([]int8, length)
+	var value int8
+	for i := range data {
+		nibble := packed[i/2] >> (4 * (i & 1)) & 15
+		value += table[nibble]
+		data[i] = value
+	}
+	return data, nil
+}
+
+// Read a 16-bit sample stored as 8-bit deltas. The bytes are a running sum, and each pair
+// of sums forms a little-endian 16-bit sample.
+func readDelta8In16(r io.Reader, length int) ([]int16, error) {
+	packed := make([]byte, length*2)
+	if _, err := io.ReadFull(r, packed); err != nil {
+		return nil, err
+	}
+
+	data := make([]int16, length)
+	var sum byte
+	for i := range data {
+		sum += packed[i*2]
+		low := sum
+		sum += packed[i*2+1]
+		data[i] = int16(uint16(low) | uint16(sum)<<8)
+	}
+	return data, nil
+}
@@ -0,0 +1,138 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package itmod
+
+import (
+	"iter"
+
+	"go.mukunda.com/modlib/common"
+)
+
+// Unpacks the rows of a pattern one at a time, straight from the packed data, so a
+// pattern doesn't need to be converted as a whole to be read.
+type PatternDecoder struct {
+	pattern *ItPattern
+	pos     int // Read position in the packed data.
+	row     int // Next row.
+
+	lastMask        [64]byte
+	lastNote        [64]byte
+	lastIns         [64]byte
+	lastVol         [64]byte
+	lastEffect      [64]byte
+	lastEffectParam [64]byte
+
+	entries []common.PatternEntry
+}
+
+// Make a decoder that starts at the first row.
+func (itp *ItPattern) Decoder() *PatternDecoder {
+	return &PatternDecoder{pattern: itp}
+}
+
+// Iterate over the rows of the pattern. The entries of each row are only valid until the
+// next one; copy them to keep them.
+func (itp *ItPattern) Rows() iter.Seq2[int, common.PatternRow] {
+	return func(yield func(int, common.PatternRow) bool) {
+		d := itp.Decoder()
+		for {
+			index := d.row
+			row, ok := d.Next()
+			if !ok || !yield(index, row) {
+				return
+			}
+		}
+	}
+}
+
+// Index of the row that Next returns.
+func (d *PatternDecoder) Row() int {
+	return d.row
+}
+
+// Go back to the first row. Packed rows depend on the rows before them, so this is the
+// only way to move backward.
+func (d *PatternDecoder) Reset() {
+	*d = PatternDecoder{pattern: d.pattern, entries: d.entries}
+}
+
+// Unpack the next row. Its entries are only valid until the next call. Returns false
+// after the last row.
+func (d *PatternDecoder) Next() (common.PatternRow, bool) {
+	if d.row >= int(d.pattern.Header.Rows) {
+		return common.PatternRow{}, false
+	}
+	d.row++
+
+	d.entries = d.entries[:0]
+	for {
+		channelSelect := d.nextByte()
+		if channelSelect == 0 {
+			break
+		}
+
+		entry := common.PatternEntry{}
+
+		raw := int((channelSelect - 1) & 127)
+		channel := raw & 63
+		entry.Channel = uint8(channel)
+
+		if channelSelect&0x80 != 0 {
+			d.lastMask[channel] = d.nextByte()
+		}
+		mask := d.lastMask[channel]
+
+		if mask&PmaskNote != 0 {
+			d.lastNote[channel] = d.nextByte()
+		}
+
+		if mask&(PmaskNote|PmaskLastNote) != 0 {
+			entry.Note = translateNote(d.lastNote[channel])
+		}
+
+		if mask&PmaskIns != 0 {
+			d.lastIns[channel] = d.nextByte()
+		}
+
+		if mask&(PmaskIns|PmaskLastIns) != 0 {
+			entry.Instrument = int16(d.lastIns[channel])
+		}
+
+		if mask&PmaskVol != 0 {
+			d.lastVol[channel] = d.nextByte()
+		}
+
+		if mask&(PmaskVol|PmaskLastVol) != 0 {
+			entry.VolumeCommand, entry.VolumeParam = translatePatternVolume(d.lastVol[channel])
+		}
+
+		if mask&PmaskEffect != 0 {
+			d.lastEffect[channel] = d.nextByte()
+			d.lastEffectParam[channel] = d.nextByte()
+		}
+
+		if mask&(PmaskEffect|PmaskLastEffect) != 0 {
+			entry.Effect = d.lastEffect[channel]
+			entry.EffectParam = d.lastEffectParam[channel]
+		}
+
+		if limit := d.pattern.channelLimit; limit > 0 && raw >= limit {
+			continue
+		}
+		d.entries = append(d.entries, entry)
+	}
+
+	return common.PatternRow{Entries: d.entries}, true
+}
+
+// Read a byte of packed data. Truncated data reads as zeros, which ends the row.
+func (d *PatternDecoder) nextByte() byte {
+	data := d.pattern.Data
+	if d.pos >= len(data) {
+		return 0
+	}
+	d.pos++
+	return data[d.pos-1]
+}
@@ -0,0 +1,222 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package itmod
+
+import (
+	"fmt"
+
+	"go.mukunda.com/modlib/common"
+)
+
+// Sizes of the fixed structures in an IT file.
+const (
+	itHeaderSize        = 192
+	itInstrumentSize    = 554
+	itSampleHeaderSize  = 80
+	itPatternHeaderSize = 8
+)
+
+// Samples smaller than this aren't worth a suggestion.
+const sizeAdviceMinimum = 4096
+
+// Kinds of size suggestions.
+const (
+	SuggestCompress = "compress"  // Store the sample with IT215 compression.
+	SuggestTo8Bit   = "8-bit"     // Convert the 16-bit sample to 8-bit.
+	SuggestTrimLoop = "trim-loop" // Remove the data after the loop end.
+)
+
+// An action that would make the file smaller.
+type SizeSuggestion struct {
+	Kind    string // Suggest*
+	Sample  int    // Index of the sample
+	Savings int    // Estimated bytes saved
+	Message string
+}
+
+// Where the bytes go when a module is saved as an uncompressed IT file.
+type SizeReport struct {
+	Header      int // Header, order list, and offset tables
+	Message     int
+	Instruments int
+	Samples     []int // Header and data of each sample
+	Patterns    []int // Header and packed data of each pattern
+	Total       int
+
+	Suggestions []SizeSuggestion
+}
+
+// Estimate the size of the module as an uncompressed IT file and suggest ways to reduce it.
+// Streamed samples are read to estimate them; ones that can't be read count as empty.
+func EstimateSize(m *common.Module) SizeReport {
+	var report SizeReport
+	if loaded, err := m.WithLoadedStreams(); err == nil {
+		m = loaded
+	}
+
+	report.Header = itHeaderSize + len(m.Order) + 4*(len(m.Instruments)+len(m.Samples)+len(m.Patterns))
+	if len(m.Message) > 0 {
+		report.Message = len(m.Message) + 1
+	}
+	report.Instruments = itInstrumentSize * len(m.Instruments)
+	report.Total = report.Header + report.Message + report.Instruments
+
+	for i := range m.Samples {
+		size := itSampleHeaderSize + sampleDataSize(&m.Samples[i].Data, m.Samples[i].Data.Len())
+		report.Samples = append(report.Samples, size)
+		report.Total += size
+		report.Suggestions = append(report.Suggestions, sampleSuggestions(&m.Samples[i], i)...)
+	}
+
+	for i := range m.Patterns {
+		size := itPatternHeaderSize + packedPatternSize(&m.Patterns[i])
+		report.Patterns = append(report.Patterns, size)
+		report.Total += size
+	}
+
+	return report
+}
+
+// Bytes used by a number of frames of uncompressed PCM.
+func sampleDataSize(sd *common.SampleData, frames int) int {
+	return frames * int(sd.Channels) * int(sd.Bits) / 8
+}
+
+func sampleSuggestions(s *common.Sample, index int) []SizeSuggestion {
+	var suggestions []SizeSuggestion
+	size := sampleDataSize(&s.Data, s.Data.Len())
+	if size < sizeAdviceMinimum {
+		return nil
+	}
+
+	if s.Loop && !s.Sustain && s.LoopEnd < s.Data.Len() {
+		savings := sampleDataSize(&s.Data, s.Data.Len()-s.LoopEnd)
+		suggestions = append(suggestions, SizeSuggestion{
+			Kind: SuggestTrimLoop, Sample: index, Savings: savings,
+			Message: fmt.Sprintf("sample %d has %d frames after the loop end that are never played", index+1, s.Data.Len()-s.LoopEnd),
+		})
+		size -= savings
+	}
+
+	if s.Data.Bits == 16 {
+		suggestions = append(suggestions, SizeSuggestion{
+			Kind: SuggestTo8Bit, Sample: index, Savings: size / 2,
+			Message: fmt.Sprintf("sample %d is 16-bit", index+1),
+		})
+	}
+
+	if compressed := compressedSizeEstimate(&s.Data); compressed < size {
+		suggestions = append(suggestions, SizeSuggestion{
+			Kind: SuggestCompress, Sample: index, Savings: size - compressed,
+			Message: fmt.Sprintf("sample %d can be stored compressed", index+1),
+		})
+	}
+
+	return suggestions
+}
+
+// Rough size of the sample with IT215 compression: the bits needed for each delta, plus one
+// bit per sample for width changes.
+func compressedSizeEstimate(sd *common.SampleData) int {
+	bits := 0
+	for _, channel := range sd.Data {
+		switch data := channel.(type) {
+		case []int8:
+			bits += deltaBits(data)
+		case []int16:
+			bits += deltaBits(data)
+		}
+	}
+	return bits / 8
+}
+
+func deltaBits[T int8 | int16](data []T) int {
+	bits := 0
+	var prev, prevDelta T
+	for _, v := range data {
+		// IT215 compresses the second-order delta.
+		delta := v - prev
+		d := int(delta - prevDelta)
+		prev, prevDelta = v, delta
+		width := 1
+		for d < -1<<(width-1) || d >= 1<<(width-1) {
+			width++
+		}
+		bits += width + 1
+	}
+	return bits
+}
+
+// Size of the pattern in IT's packed format. Repeated values use the "last value" mask
+// bits, and the mask is only written when it changes. The initial values are zero, which
+// never match a present field.
+func packedPatternSize(p *common.Pattern) int {
+	type channelState struct {
+		mask   byte
+		note   uint8
+		ins    int16
+		vol    [2]uint8
+		effect [2]uint8
+	}
+	var state [64]channelState
+
+	size := 0
+	for _, row := range p.Rows {
+		for _, e := range row.Entries {
+			if e.Channel >= 64 || e.IsEmpty() {
+				continue
+			}
+			ch := &state[e.Channel]
+			var mask byte
+			fields := 0
+			if e.Note != 0 {
+				if e.Note == ch.note {
+					mask |= PmaskLastNote
+				} else {
+					mask |= PmaskNote
+					fields++
+				}
+				ch.note = e.Note
+			}
+			if e.Instrument != 0 {
+				if e.Instrument == ch.ins {
+					mask |= PmaskLastIns
+				} else {
+					mask |= PmaskIns
+					fields++
+				}
+				ch.ins = e.Instrument
+			}
+			if e.VolumeCommand != 0 {
+				vol := [2]uint8{e.VolumeCommand, e.VolumeParam}
+				if vol == ch.vol {
+					mask |= PmaskLastVol
+				} else {
+					mask |= PmaskVol
+					fields++
+				}
+				ch.vol = vol
+			}
+			if e.Effect != 0 || e.EffectParam != 0 {
+				effect := [2]uint8{e.Effect, e.EffectParam}
+				if effect == ch.effect {
+					mask |= PmaskLastEffect
+				} else {
+					mask |= PmaskEffect
+					fields += 2
+				}
+				ch.effect = effect
+			}
+
+			size += 1 + fields
+			if mask != ch.mask {
+				size++
+			}
+			ch.mask = mask
+		}
+		size++
+	}
+	return size
+}
@@ -0,0 +1,141 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package itmod
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"slices"
+
+	"go.mukunda.com/modlib/common"
+)
+
+// Ways of storing sample data in an IT file.
+type SampleStorage int
+
+const (
+	StoreUncompressed SampleStorage = iota
+	StoreIt214                      // Compressed, as IT 2.14 writes it.
+	StoreIt215                      // Compressed with second-order deltas, as IT 2.15 writes it.
+)
+
+// Settings for choosing how to store samples.
+type StorageOptions struct {
+	// Allowed ways of storing the data. Empty allows all.
+	Storage []SampleStorage
+
+	// Convert 16-bit samples to 8-bit when the signal-to-noise ratio of the conversion is
+	// at least this many dB. 0 never converts.
+	DownconvertThreshold float64
+}
+
+// The size and quality of one way of storing a sample.
+type StorageCost struct {
+	Storage SampleStorage
+	Bits    int // 8 or 16
+	Bytes   int // Size of the stored data.
+
+	// Signal-to-noise ratio of the stored data in dB, +Inf if it's stored without loss.
+	SNR float64
+}
+
+// Measure each way of storing the sample data: uncompressed, IT214 and IT215, at its own
+// bit depth and, for 16-bit samples, converted to 8-bit. Sizes are exact, from compressing
+// the data.
+func StorageCosts(sd *common.SampleData) []StorageCost {
+	if sd.Len() == 0 {
+		return nil
+	}
+	var costs []StorageCost
+	add := func(data [][]int16, bits int, snr float64) {
+		for _, storage := range []SampleStorage{StoreUncompressed, StoreIt214, StoreIt215} {
+			costs = append(costs, StorageCost{
+				Storage: storage,
+				Bits:    bits,
+				Bytes:   storedSize(data, bits, storage),
+				SNR:     snr,
+			})
+		}
+	}
+
+	original := make([][]int16, len(sd.Data))
+	for ch, channel := range sd.Data {
+		switch d := channel.(type) {
+		case []int8:
+			original[ch] = make([]int16, len(d))
+			for i, v := range d {
+				original[ch][i] = int16(v)
+			}
+		case []int16:
+			original[ch] = d
+		}
+	}
+
+	if sd.Bits != 16 {
+		add(original, 8, math.Inf(1))
+		return costs
+	}
+	add(original, 16, math.Inf(1))
+
+	var signal, noise float64
+	reduced := make([][]int16, len(original))
+	for ch, data := range original {
+		reduced[ch] = make([]int16, len(data))
+		for i, v := range data {
+			r := int16(min(math.Round(float64(v)/256), 127))
+			reduced[ch][i] = r
+			signal += float64(v) * float64(v)
+			noise += math.Pow(float64(v)-float64(r)*256, 2)
+		}
+	}
+	snr := math.Inf(1)
+	if noise > 0 {
+		snr = 10 * math.Log10(signal/noise)
+	}
+	add(reduced, 8, snr)
+	return costs
+}
+
+// Pick the smallest storage for the sample data that the options allow.
+func ChooseStorage(sd *common.SampleData, opts StorageOptions) (StorageCost, bool) {
+	var best StorageCost
+	found := false
+	for _, cost := range StorageCosts(sd) {
+		if len(opts.Storage) > 0 && !slices.Contains(opts.Storage, cost.Storage) {
+			continue
+		}
+		if cost.Bits < int(sd.Bits) && (opts.DownconvertThreshold <= 0 || cost.SNR < opts.DownconvertThreshold) {
+			continue
+		}
+		if !found || cost.Bytes < best.Bytes {
+			best, found = cost, true
+		}
+	}
+	return best, found
+}
+
+// Bytes used by the channels in a storage format.
+func storedSize(data [][]int16, bits int, storage SampleStorage) int {
+	size := 0
+	for _, channel := range data {
+		if storage == StoreUncompressed {
+			size += len(channel) * bits / 8
+			continue
+		}
+		var pcm bytes.Buffer
+		if bits == 16 {
+			binary.Write(&pcm, binary.LittleEndian, channel)
+		} else {
+			for _, v := range channel {
+				pcm.WriteByte(byte(v))
+			}
+		}
+		codec := ItSampleCodec{Is16: bits == 16, It215: storage == StoreIt215}
+		encoded, _ := codec.Encode(&pcm, len(channel))
+		size += len(encoded)
+	}
+	return size
+}
@@ -0,0 +1,83 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package itmod
+
+import "go.mukunda.com/modlib/common"
+
+// Note written for a note fade. Impulse Tracker shows it as ~~~.
+const itNoteFade = 246
+
+// IT volume column ranges: the first byte of each command and the largest parameter.
+var itVolumeRanges = []struct {
+	command  uint8
+	base     uint8
+	maxParam uint8
+}{
+	{common.VcmdSetVolume, 0, 64},
+	{common.VcmdFineVolUp, 65, 9},
+	{common.VcmdFineVolDown, 75, 9},
+	{common.VcmdVolSlideUp, 85, 9},
+	{common.VcmdVolSlideDown, 95, 9},
+	{common.VcmdPitchSlideDown, 105, 9},
+	{common.VcmdPitchSlideUp, 115, 9},
+	{common.VcmdSetPan, 128, 64},
+	{common.VcmdPortaToNote, 193, 9},
+	{common.VcmdVibratoDepth, 203, 9},
+}
+
+// IT notes are 0-119. 255 is note-off, 254 is note cut, and Impulse Tracker fades the
+// note for any other value.
+func translateNote(note uint8) uint8 {
+	switch {
+	case note < 120:
+		return note + 1 // Normal note, map to +1 so zero is "empty".
+	case note == 254:
+		return common.NoteCut
+	case note == 255:
+		return common.NoteOff
+	default:
+		return common.NoteFade
+	}
+}
+
+// Convert a common note to IT. Returns false for an empty note or one IT can't store.
+func untranslateNote(note uint8) (uint8, bool) {
+	switch {
+	case note >= 1 && note <= 120:
+		return note - 1, true
+	case note == common.NoteFade:
+		return itNoteFade, true
+	case note == common.NoteCut:
+		return 254, true
+	case note == common.NoteOff:
+		return 255, true
+	}
+	return 0, false
+}
+
+// Convert an IT volume column byte to a common volume command and parameter. Unused
+// values are an empty volume column.
+func translatePatternVolume(vol uint8) (uint8, uint8) {
+	for _, r := range itVolumeRanges {
+		if vol >= r.base && vol <= r.base+r.maxParam {
+			return r.command, vol - r.base
+		}
+	}
+	return 0, 0
+}
+
+// Convert a common volume command to an IT volume column byte. Returns false for an empty
+// volume column, or a command or parameter IT can't store.
+func untranslatePatternVolume(command, param uint8) (uint8, bool) {
+	for _, r := range itVolumeRanges {
+		if r.command == command {
+			if param > r.maxParam {
+				return 0, false
+			}
+			return r.base + param, true
+		}
+	}
+	return 0, false
+}
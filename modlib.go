@@ -10,15 +10,75 @@ package modlib
 import (
 	"errors"
 	"io"
+	"io/fs"
 	"os"
 
+	"go.mukunda.com/modlib/ahxmod"
+	"go.mukunda.com/modlib/charset"
+	"go.mukunda.com/modlib/dsmmod"
 	"go.mukunda.com/modlib/itmod"
+	"go.mukunda.com/modlib/s3mmod"
 )
 
 // Returned when the module format could not be detected.
 var ErrUnknownModuleFormat = errors.New("unknown or unsupported module format")
 
-// Load a module by filename.
+// Options for LoadModuleWithOptions.
+type LoadOptions struct {
+	// Code page of the text in the file, which is converted to UTF-8. nil uses the usual
+	// code page of the format: CP437 for DOS formats and ISO-8859-1 for Amiga formats.
+	Charset *charset.Charset
+
+	// Keep text byte for byte, like LoadModule does.
+	RawText bool
+
+	// Called as the parts of the module are read, for formats whose loaders report
+	// progress (IT and S3M).
+	Progress ProgressFunc
+
+	// Where to find samples that MPTM files keep in external files, usually the directory
+	// of the module. nil leaves those samples without data; see Sample.ExternalPath.
+	SampleFS fs.FS
+
+	// Drop IT pattern entries on channels past the channel count of the header, instead of
+	// keeping them like Impulse Tracker. Either way, they're listed in the warnings of
+	// ItDetails.
+	DropInvalidChannels bool
+
+	// Where to allocate pattern entries, for formats whose loaders support it (IT, S3M and
+	// STX). An arena that is Reset between loads saves allocations when loading many
+	// modules; see Arena. Optional.
+	Arena *Arena
+}
+
+// Load a module by filename with options.
+func LoadModuleWithOptions(filename string, options LoadOptions) (*Module, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return LoadModuleFromStreamWithOptions(file, options)
+}
+
+// Load a module from an open stream with options.
+func LoadModuleFromStreamWithOptions(r io.ReadSeeker, options LoadOptions) (*Module, error) {
+	m, err := loadModule(r, options)
+	if err != nil || options.RawText {
+		return m, err
+	}
+
+	cs := options.Charset
+	if cs == nil {
+		cs = charset.ForSource(m.Source)
+	}
+	cs.DecodeModule(m)
+	return m, nil
+}
+
+// Load a module by filename. Text is kept byte for byte; see LoadModuleWithOptions to
+// convert it to UTF-8.
 func LoadModule(filename string) (*Module, error) {
 	file, err := os.Open(filename)
 	if err != nil {
@@ -33,14 +93,27 @@ func LoadModule(filename string) (*Module, error) {
 
 // Load a module from an open stream. Seeking is required for module loading.
 func LoadModuleFromStream(r io.ReadSeeker) (*Module, error) {
-	signature := make([]byte, 4)
-	if _, err := io.ReadFull(r, signature); err != nil {
+	return loadModule(r, LoadOptions{})
+}
+
+func loadModule(r io.ReadSeeker, options LoadOptions) (*Module, error) {
+	// Large enough to hold the signatures of the supported formats and the ones detected
+	// as unsupported.
+	signature := make([]byte, 0x40)
+	n, err := io.ReadFull(r, signature)
+	if err != nil && err != io.ErrUnexpectedEOF {
 		return nil, err
 	}
+	signature = signature[:n]
 
-	if string(signature) == "IMPM" {
+	if len(signature) >= 4 && string(signature[:4]) == "IMPM" {
 		r.Seek(0, io.SeekStart)
-		reader := itmod.ItReader{}
+		reader := itmod.ItReader{
+			Progress:            options.Progress,
+			SampleFS:            options.SampleFS,
+			DropInvalidChannels: options.DropInvalidChannels,
+			Arena:               options.Arena,
+		}
 
 		mod, err := reader.ReadItModule(r)
 		if err != nil {
@@ -50,5 +123,53 @@ func LoadModuleFromStream(r io.ReadSeeker) (*Module, error) {
 		return mod.ToCommon(), nil
 	}
 
-	return nil, ErrUnknownModuleFormat
+	if len(signature) >= 0x30 && string(signature[0x2C:0x30]) == "SCRM" {
+		r.Seek(0, io.SeekStart)
+		reader := s3mmod.S3mReader{Progress: options.Progress, Arena: options.Arena}
+
+		mod, err := reader.ReadS3mModule(r)
+		if err != nil {
+			return nil, err
+		}
+
+		return mod.ToCommon(), nil
+	}
+
+	if len(signature) >= 0x40 && string(signature[0x3C:0x40]) == "SCRM" {
+		r.Seek(0, io.SeekStart)
+		reader := s3mmod.S3mReader{Arena: options.Arena}
+
+		mod, err := reader.ReadStxModule(r)
+		if err != nil {
+			return nil, err
+		}
+
+		return mod.ToCommon(), nil
+	}
+
+	if len(signature) >= 3 && string(signature[:3]) == "THX" {
+		r.Seek(0, io.SeekStart)
+		reader := ahxmod.AhxReader{}
+
+		mod, err := reader.ReadAhxModule(r)
+		if err != nil {
+			return nil, err
+		}
+
+		return mod.ToCommon(), nil
+	}
+
+	if len(signature) >= 12 && string(signature[:4]) == "RIFF" && string(signature[8:12]) == "DSMF" {
+		r.Seek(0, io.SeekStart)
+		reader := dsmmod.DsmReader{}
+
+		mod, err := reader.ReadDsmModule(r)
+		if err != nil {
+			return nil, err
+		}
+
+		return mod.ToCommon(), nil
+	}
+
+	return nil, unknownFormatError(r, signature)
 }
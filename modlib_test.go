@@ -5,9 +5,19 @@
 package modlib
 
 import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"go.mukunda.com/modlib/charset"
 )
 
 func TestLoadModule(t *testing.T) {
@@ -17,3 +27,153 @@ func TestLoadModule(t *testing.T) {
 
 	assert.Equal(t, "reflection", mod.Title)
 }
+
+func TestLoadModuleFromBytes(t *testing.T) {
+	source, err := os.ReadFile("itmod/test/reflection.it")
+	assert.NoError(t, err)
+
+	mod, err := LoadModuleFromBytes(source)
+	assert.NoError(t, err)
+	assert.Equal(t, "reflection", mod.Title)
+
+	_, err = LoadModuleFromBytes([]byte("nothing"))
+	assert.ErrorIs(t, err, ErrUnknownModuleFormat)
+}
+
+func TestLoadModuleWithOptions(t *testing.T) {
+	source, err := os.ReadFile("itmod/test/reflection.it")
+	assert.NoError(t, err)
+	copy(source[4:], "r\x82flexi\xF3n\x00")
+
+	mod, err := LoadModuleFromStreamWithOptions(bytes.NewReader(source), LoadOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "réflexi≤n", mod.Title)
+	charset.CP437.EncodeModule(mod)
+	assert.Equal(t, "r\x82flexi\xF3n", mod.Title)
+
+	mod, err = LoadModuleFromStreamWithOptions(bytes.NewReader(source), LoadOptions{Charset: charset.Latin1})
+	assert.NoError(t, err)
+	assert.Equal(t, "r\u0082flexión", mod.Title)
+
+	mod, err = LoadModuleFromStreamWithOptions(bytes.NewReader(source), LoadOptions{RawText: true})
+	assert.NoError(t, err)
+	assert.Equal(t, "r\x82flexi\xF3n", mod.Title)
+}
+
+func TestLoadProgress(t *testing.T) {
+	source, err := os.ReadFile("itmod/test/reflection.it")
+	assert.NoError(t, err)
+
+	last := map[string][2]int{}
+	var stages []string
+	_, err = LoadModuleFromStreamWithOptions(bytes.NewReader(source), LoadOptions{
+		Progress: func(stage string, done, total int) {
+			if _, ok := last[stage]; !ok {
+				stages = append(stages, stage)
+			}
+			assert.LessOrEqual(t, done, total)
+			last[stage] = [2]int{done, total}
+		},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{StageInstruments, StageSamples, StagePatterns}, stages)
+	for _, stage := range stages {
+		assert.Equal(t, last[stage][0], last[stage][1])
+		assert.Greater(t, last[stage][1], 0)
+	}
+}
+
+func TestUnsupportedFormats(t *testing.T) {
+	_, err := LoadModuleFromBytes([]byte("Extended Module: song\x1A"))
+	assert.ErrorIs(t, err, ErrUnsupportedSource)
+	assert.EqualError(t, err, "unsupported module format: detected FastTracker 2, not supported")
+
+	header := make([]byte, 0x40)
+	copy(header[0x2C:], "PTMF")
+	_, err = LoadModuleFromBytes(header)
+	assert.ErrorContains(t, err, "detected PolyTracker")
+
+	// MODs are found by modmod.Detect.
+	mod := make([]byte, 1084+1024)
+	copy(mod[1080:], "M.K.")
+	mod[950] = 1 // Song length
+	_, err = LoadModuleFromBytes(mod)
+	assert.ErrorContains(t, err, "detected ProTracker MOD")
+
+	_, err = LoadModuleFromBytes(make([]byte, 16))
+	assert.ErrorIs(t, err, ErrUnknownModuleFormat)
+}
+
+func TestLoadModuleFromArchive(t *testing.T) {
+	dir := t.TempDir()
+	source, err := os.ReadFile("itmod/test/reflection.it")
+	assert.NoError(t, err)
+
+	zipPath := filepath.Join(dir, "songs.zip")
+	var zipData bytes.Buffer
+	zw := zip.NewWriter(&zipData)
+	w, _ := zw.Create("readme.txt")
+	w.Write([]byte("not a module"))
+	w, _ = zw.Create("songs/reflection.it")
+	w.Write(source)
+	assert.NoError(t, zw.Close())
+	assert.NoError(t, os.WriteFile(zipPath, zipData.Bytes(), 0o644))
+
+	mod, err := LoadModuleFromArchive(zipPath, "")
+	assert.NoError(t, err)
+	assert.Equal(t, "reflection", mod.Title)
+
+	mod, err = LoadModuleFromArchive(zipPath, "songs/reflection.it")
+	assert.NoError(t, err)
+	assert.Equal(t, "reflection", mod.Title)
+
+	_, err = LoadModuleFromArchive(zipPath, "readme.txt")
+	assert.ErrorIs(t, err, ErrUnknownModuleFormat)
+	_, err = LoadModuleFromArchive(zipPath, "missing.it")
+	assert.ErrorIs(t, err, ErrEntryNotFound)
+
+	gzPath := filepath.Join(dir, "reflection.it.gz")
+	var gzData bytes.Buffer
+	gw := gzip.NewWriter(&gzData)
+	gw.Write(source)
+	assert.NoError(t, gw.Close())
+	assert.NoError(t, os.WriteFile(gzPath, gzData.Bytes(), 0o644))
+
+	mod, err = LoadModuleFromArchive(gzPath, "")
+	assert.NoError(t, err)
+	assert.Equal(t, "reflection", mod.Title)
+
+	mod, err = LoadModuleFromArchive("itmod/test/reflection.it", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "reflection", mod.Title)
+}
+
+func TestLoadModuleFromURL(t *testing.T) {
+	source, err := os.ReadFile("itmod/test/reflection.it")
+	assert.NoError(t, err)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/reflection.it":
+			w.Write(source)
+		case "/large.it":
+			w.Header().Set("Content-Length", strconv.Itoa(MaxModuleSize+1))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	mod, err := LoadModuleFromURL(context.Background(), server.URL+"/reflection.it")
+	assert.NoError(t, err)
+	assert.Equal(t, "reflection", mod.Title)
+
+	_, err = LoadModuleFromURL(context.Background(), server.URL+"/missing.it")
+	assert.ErrorIs(t, err, ErrFetchFailed)
+
+	_, err = LoadModuleFromURL(context.Background(), server.URL+"/large.it")
+	assert.ErrorIs(t, err, ErrModuleTooLarge)
+
+	_, err = LoadModuleFromReader(bytes.NewReader([]byte("not a module")), -1)
+	assert.ErrorIs(t, err, ErrUnknownModuleFormat)
+}
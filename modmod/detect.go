@@ -0,0 +1,207 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+/*
+This package is for working with Amiga MOD files directly. So far it only identifies the
+layout of a file, which is the tricky part of loading MODs.
+*/
+package modmod
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"strconv"
+)
+
+// Returned when the data doesn't look like any MOD variant.
+var ErrNotMod = errors.New("not a MOD file")
+
+// MOD variants that need different handling.
+const (
+	VariantProTracker   = "ProTracker"   // 31 samples with a signature at 1080
+	VariantWow          = "Mod's Grave"  // .wow: 8 channels behind an "M.K." signature
+	VariantSoundtracker = "Soundtracker" // 15 samples and no signature
+)
+
+const (
+	headerSize      = 1084 // 31-sample header, including the signature
+	headerSize15    = 600  // 15-sample header
+	sampleInfoSize  = 30
+	patternRows     = 64
+	cellSize        = 4
+	maxPatterns15   = 64
+	maxSampleWords  = 32768
+	titleLength     = 20
+	signatureOffset = 1080
+)
+
+// How a MOD file is laid out.
+type Layout struct {
+	Variant   string // Variant*
+	Signature string // Empty for Soundtracker modules
+	Channels  int
+	Samples   int
+}
+
+// Offset of the pattern data.
+func (l *Layout) PatternOffset() int64 {
+	if l.Samples == 15 {
+		return headerSize15
+	}
+	return headerSize
+}
+
+// Channel counts for the known signatures.
+func signatureChannels(sig string) int {
+	switch sig {
+	case "M.K.", "M!K!", "M&K!", "FLT4", "N.T.":
+		return 4
+	case "FLT8", "CD81", "OKTA", "OCTA":
+		return 8
+	}
+	if len(sig) == 4 && sig[1:] == "CHN" && sig[0] >= '1' && sig[0] <= '9' {
+		return int(sig[0] - '0')
+	}
+	if len(sig) == 4 && (sig[2:] == "CH" || sig[2:] == "CN") {
+		if n, err := strconv.Atoi(sig[:2]); err == nil && n >= 10 && n <= 32 {
+			return n
+		}
+	}
+	return 0
+}
+
+type sampleInfo struct {
+	Name       [22]byte
+	Length     uint16 // In words
+	Finetune   uint8
+	Volume     uint8
+	LoopStart  uint16
+	LoopLength uint16
+}
+
+// Read the sample headers and order list of a module with the given number of samples.
+// Returns the headers, the orders in the song, and the number of patterns stored.
+func readHeader(r io.ReadSeeker, samples int) ([]sampleInfo, []uint8, int, error) {
+	if _, err := r.Seek(titleLength, io.SeekStart); err != nil {
+		return nil, nil, 0, err
+	}
+	infos := make([]sampleInfo, samples)
+	if err := binary.Read(r, binary.BigEndian, infos); err != nil {
+		return nil, nil, 0, err
+	}
+	var song struct {
+		Length  uint8
+		Restart uint8
+		Orders  [128]uint8
+	}
+	if err := binary.Read(r, binary.BigEndian, &song); err != nil {
+		return nil, nil, 0, err
+	}
+
+	patterns := 0
+	for _, o := range song.Orders {
+		patterns = max(patterns, int(o)+1)
+	}
+	return infos, song.Orders[:min(int(song.Length), 128)], patterns, nil
+}
+
+func sampleBytes(infos []sampleInfo) int64 {
+	total := int64(0)
+	for _, s := range infos {
+		total += int64(s.Length) * 2
+	}
+	return total
+}
+
+// Identify the MOD variant. Files with a known signature are 31-sample modules; "M.K."
+// files whose size only works out with 8 channels are Mod's Grave .wow files. Files
+// without a signature are accepted as 15-sample Soundtracker modules if their header
+// passes sanity checks.
+func Detect(r io.ReadSeeker) (Layout, error) {
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return Layout{}, err
+	}
+
+	if size >= headerSize {
+		sig := make([]byte, 4)
+		if _, err := r.Seek(signatureOffset, io.SeekStart); err != nil {
+			return Layout{}, err
+		}
+		if _, err := io.ReadFull(r, sig); err != nil {
+			return Layout{}, err
+		}
+
+		if channels := signatureChannels(string(sig)); channels > 0 {
+			layout := Layout{Variant: VariantProTracker, Signature: string(sig), Channels: channels, Samples: 31}
+			if string(sig) == "M.K." && isWow(r, size) {
+				layout.Variant = VariantWow
+				layout.Channels = 8
+			}
+			return layout, nil
+		}
+	}
+
+	if isSoundtracker(r, size) {
+		return Layout{Variant: VariantSoundtracker, Channels: 4, Samples: 15}, nil
+	}
+	return Layout{}, ErrNotMod
+}
+
+// Mod's Grave writes 8-channel patterns with an "M.K." signature. The file size is exact
+// for 8 channels.
+func isWow(r io.ReadSeeker, size int64) bool {
+	infos, _, patterns, err := readHeader(r, 31)
+	if err != nil {
+		return false
+	}
+	samples := sampleBytes(infos)
+	size4 := headerSize + int64(patterns)*patternRows*4*cellSize + samples
+	size8 := headerSize + int64(patterns)*patternRows*8*cellSize + samples
+	return size == size8 && size != size4
+}
+
+// Sanity checks for a 15-sample module, which has no signature.
+func isSoundtracker(r io.ReadSeeker, size int64) bool {
+	if size < headerSize15 {
+		return false
+	}
+
+	title := make([]byte, titleLength)
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return false
+	}
+	if _, err := io.ReadFull(r, title); err != nil || !isText(title) {
+		return false
+	}
+
+	infos, orders, patterns, err := readHeader(r, 15)
+	if err != nil || len(orders) == 0 || patterns > maxPatterns15 {
+		return false
+	}
+
+	for _, s := range infos {
+		if !isText(s.Name[:]) || s.Volume > 64 || s.Finetune != 0 || int(s.Length) > maxSampleWords {
+			return false
+		}
+		if s.Length > 0 && int(s.LoopStart)/2+int(s.LoopLength) > int(s.Length)+1 {
+			// Soundtracker loop starts are in bytes.
+			return false
+		}
+	}
+
+	// The samples may be truncated, but the patterns must be there.
+	return size >= headerSize15+int64(patterns)*patternRows*4*cellSize
+}
+
+// True if the bytes look like a name: printable characters padded with zeros.
+func isText(b []byte) bool {
+	for _, c := range b {
+		if c != 0 && (c < 0x20 || c > 0x7E) {
+			return false
+		}
+	}
+	return true
+}
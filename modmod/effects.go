@@ -0,0 +1,148 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package modmod
+
+import "go.mukunda.com/modlib/common"
+
+// Effect letters in the common model, A = 1.
+const (
+	effectA = 1
+	effectB = 2
+	effectC = 3
+	effectD = 4
+	effectE = 5
+	effectF = 6
+	effectG = 7
+	effectH = 8
+	effectJ = 10
+	effectK = 11
+	effectL = 12
+	effectO = 15
+	effectQ = 17
+	effectR = 18
+	effectS = 19
+	effectT = 20
+	effectX = 24
+)
+
+// Set the effect of a pattern entry from a ProTracker effect (0-F) and parameter. Set
+// volume (Cxx) goes into the volume column. Effects with no equivalent are dropped.
+// ProTracker slides have no memory, so slides with a zero parameter are dropped too,
+// since IT would repeat the last slide.
+func TranslateEffect(e *common.PatternEntry, effect, param uint8) {
+	x, y := param>>4, param&15
+	set := func(effect, param uint8) {
+		e.Effect, e.EffectParam = effect, param
+	}
+
+	if param == 0 {
+		switch effect {
+		case 0x1, 0x2, 0xA:
+			return
+		case 0x5:
+			set(effectG, 0)
+			return
+		case 0x6:
+			set(effectH, 0)
+			return
+		}
+	}
+
+	switch effect {
+	case 0x0:
+		if param != 0 {
+			set(effectJ, param)
+		}
+	case 0x1:
+		// Keep it out of IT's fine slide range.
+		set(effectF, min(param, 0xDF))
+	case 0x2:
+		set(effectE, min(param, 0xDF))
+	case 0x3:
+		set(effectG, param)
+	case 0x4:
+		set(effectH, param)
+	case 0x5:
+		set(effectL, volumeSlide(param))
+	case 0x6:
+		set(effectK, volumeSlide(param))
+	case 0x7:
+		set(effectR, param)
+	case 0x8:
+		set(effectX, param)
+	case 0x9:
+		set(effectO, param)
+	case 0xA:
+		set(effectD, volumeSlide(param))
+	case 0xB:
+		set(effectB, param)
+	case 0xC:
+		e.VolumeCommand, e.VolumeParam = common.VcmdSetVolume, min(param, 64)
+	case 0xD:
+		// The row is decimal.
+		set(effectC, x*10+y)
+	case 0xE:
+		translateExtended(e, x, y)
+	case 0xF:
+		if param == 0 {
+			return
+		} else if param < 0x20 {
+			set(effectA, param)
+		} else {
+			set(effectT, param)
+		}
+	}
+}
+
+// ProTracker slides up if both nibbles are set, where IT would treat it as a fine slide.
+func volumeSlide(param uint8) uint8 {
+	if param&0xF0 != 0 {
+		return param & 0xF0
+	}
+	return param
+}
+
+// Translate the Exy effects.
+func translateExtended(e *common.PatternEntry, x, y uint8) {
+	set := func(effect, param uint8) {
+		e.Effect, e.EffectParam = effect, param
+	}
+
+	if y == 0 && (x == 0x1 || x == 0x2 || x == 0xA || x == 0xB) {
+		// Fine slides by 0 do nothing in ProTracker, but would recall memory in IT.
+		return
+	}
+
+	switch x {
+	case 0x1:
+		set(effectF, 0xF0|y)
+	case 0x2:
+		set(effectE, 0xF0|y)
+	case 0x3:
+		set(effectS, 0x10|y) // Glissando
+	case 0x4:
+		set(effectS, 0x30|y) // Vibrato waveform
+	case 0x5:
+		set(effectS, 0x20|y) // Finetune
+	case 0x6:
+		set(effectS, 0xB0|y) // Pattern loop
+	case 0x7:
+		set(effectS, 0x40|y) // Tremolo waveform
+	case 0x8:
+		set(effectS, 0x80|y) // Panning
+	case 0x9:
+		set(effectQ, y)
+	case 0xA:
+		set(effectD, y<<4|0xF)
+	case 0xB:
+		set(effectD, 0xF0|y)
+	case 0xC:
+		set(effectS, 0xC0|y)
+	case 0xD:
+		set(effectS, 0xD0|y)
+	case 0xE:
+		set(effectS, 0xE0|y)
+	}
+}
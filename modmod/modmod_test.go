@@ -0,0 +1,93 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package modmod
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mukunda.com/modlib/common"
+)
+
+// Build a module with one pattern and one sample of sampleWords words.
+func buildMod(samples int, signature string, channels int, sampleWords int) []byte {
+	var buf bytes.Buffer
+	title := [20]byte{}
+	copy(title[:], "test song")
+	buf.Write(title[:])
+
+	infos := make([]sampleInfo, samples)
+	copy(infos[0].Name[:], "sample")
+	infos[0].Length = uint16(sampleWords)
+	infos[0].Volume = 64
+	infos[0].LoopLength = 1
+	binary.Write(&buf, binary.BigEndian, infos)
+
+	buf.WriteByte(1)   // Song length
+	buf.WriteByte(120) // Restart
+	buf.Write(make([]byte, 128))
+	buf.WriteString(signature)
+
+	buf.Write(make([]byte, patternRows*channels*cellSize))
+	buf.Write(make([]byte, sampleWords*2))
+	return buf.Bytes()
+}
+
+func TestDetect(t *testing.T) {
+	layout, err := Detect(bytes.NewReader(buildMod(31, "M.K.", 4, 100)))
+	assert.NoError(t, err)
+	assert.Equal(t, Layout{Variant: VariantProTracker, Signature: "M.K.", Channels: 4, Samples: 31}, layout)
+	assert.EqualValues(t, 1084, layout.PatternOffset())
+
+	layout, err = Detect(bytes.NewReader(buildMod(31, "12CH", 12, 100)))
+	assert.NoError(t, err)
+	assert.Equal(t, 12, layout.Channels)
+
+	layout, err = Detect(bytes.NewReader(buildMod(31, "M.K.", 8, 100)))
+	assert.NoError(t, err)
+	assert.Equal(t, Layout{Variant: VariantWow, Signature: "M.K.", Channels: 8, Samples: 31}, layout)
+
+	layout, err = Detect(bytes.NewReader(buildMod(15, "", 4, 100)))
+	assert.NoError(t, err)
+	assert.Equal(t, Layout{Variant: VariantSoundtracker, Channels: 4, Samples: 15}, layout)
+	assert.EqualValues(t, 600, layout.PatternOffset())
+
+	junk := buildMod(15, "", 4, 100)
+	junk[20+25] = 99 // Volume of the first sample
+	_, err = Detect(bytes.NewReader(junk))
+	assert.ErrorIs(t, err, ErrNotMod)
+
+	_, err = Detect(bytes.NewReader(bytes.Repeat([]byte{0xFF}, 2000)))
+	assert.ErrorIs(t, err, ErrNotMod)
+}
+
+func TestTranslateEffect(t *testing.T) {
+	tests := []struct {
+		effect, param uint8
+		expected      common.PatternEntry
+	}{
+		{0x0, 0x37, common.PatternEntry{Effect: effectJ, EffectParam: 0x37}},
+		{0x0, 0x00, common.PatternEntry{}},
+		{0x1, 0xF0, common.PatternEntry{Effect: effectF, EffectParam: 0xDF}},
+		{0x1, 0x00, common.PatternEntry{}},
+		{0x5, 0x00, common.PatternEntry{Effect: effectG}},
+		{0xA, 0x12, common.PatternEntry{Effect: effectD, EffectParam: 0x10}},
+		{0xC, 0x50, common.PatternEntry{VolumeCommand: common.VcmdSetVolume, VolumeParam: 64}},
+		{0xD, 0x16, common.PatternEntry{Effect: effectC, EffectParam: 16}},
+		{0xE, 0x13, common.PatternEntry{Effect: effectF, EffectParam: 0xF3}},
+		{0xE, 0xA0, common.PatternEntry{}},
+		{0xE, 0xA2, common.PatternEntry{Effect: effectD, EffectParam: 0x2F}},
+		{0xE, 0x64, common.PatternEntry{Effect: effectS, EffectParam: 0xB4}},
+		{0xF, 0x06, common.PatternEntry{Effect: effectA, EffectParam: 6}},
+		{0xF, 0x7D, common.PatternEntry{Effect: effectT, EffectParam: 0x7D}},
+	}
+	for _, test := range tests {
+		var e common.PatternEntry
+		TranslateEffect(&e, test.effect, test.param)
+		assert.Equal(t, test.expected, e, "%X%02X", test.effect, test.param)
+	}
+}
@@ -0,0 +1,54 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package modpb
+
+import "go.mukunda.com/modlib/common"
+
+// Pattern and sample banks are parts of a module saved on their own, for template and
+// library workflows. They're Module messages with only some fields set, so Unmarshal and
+// other languages read them as modules too. Use Module.GraftPatterns and
+// Module.GraftSamples to add them to a module.
+
+// Encode patterns without the rest of a module. Only the patterns field is written.
+func MarshalPatterns(patterns []common.Pattern) []byte {
+	var e encoder
+	for i := range patterns {
+		e.message(22, func(e *encoder) { marshalPattern(e, &patterns[i]) })
+	}
+	e.int(24, SchemaVersion)
+	return e.buf
+}
+
+// Decode the patterns of a pattern bank, or of a whole module.
+func UnmarshalPatterns(data []byte) ([]common.Pattern, error) {
+	m, err := Unmarshal(data)
+	if err != nil {
+		return nil, err
+	}
+	return m.Patterns, nil
+}
+
+// Encode instruments and samples without the rest of a module. Only the instruments and
+// samples fields are written; notemaps refer to the samples by their index in the bank.
+func MarshalSamples(instruments []common.Instrument, samples []common.Sample, options MarshalOptions) []byte {
+	var e encoder
+	for i := range instruments {
+		e.message(20, func(e *encoder) { marshalInstrument(e, &instruments[i]) })
+	}
+	for i := range samples {
+		e.message(21, func(e *encoder) { marshalSample(e, &samples[i], options) })
+	}
+	e.int(24, SchemaVersion)
+	return e.buf
+}
+
+// Decode the instruments and samples of a sample bank, or of a whole module.
+func UnmarshalSamples(data []byte) ([]common.Instrument, []common.Sample, error) {
+	m, err := Unmarshal(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	return m.Instruments, m.Samples, nil
+}
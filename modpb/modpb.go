@@ -0,0 +1,824 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+/*
+This package encodes common.Module with protocol buffers, following the schema in
+modlib.proto, so modules can be passed between processes and languages without the
+ambiguity of the []any sample data.
+*/
+package modpb
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"go.mukunda.com/modlib/common"
+)
+
+type MarshalOptions struct {
+	// Leave out sample PCM, e.g. to send a module's structure to an editor UI. The
+	// channel count and bit depth are kept.
+	OmitSampleData bool
+}
+
+// Encode a module. Streamed samples are read from their source; ones that can't be read
+// are saved without PCM.
+func Marshal(m *common.Module, options MarshalOptions) []byte {
+	var e encoder
+	e.int(1, int64(m.Source))
+	e.string(2, m.Title)
+	e.int(3, int64(m.GlobalVolume))
+	e.int(4, int64(m.MixingVolume))
+	e.int(5, int64(m.InitialSpeed))
+	e.int(6, int64(m.InitialTempo))
+	e.int(7, int64(m.PanSeparation))
+	e.int(8, int64(m.PitchWheelDepth))
+	e.bool(9, m.StereoMixing)
+	e.bool(10, m.UseInstruments)
+	e.bool(11, m.LinearSlides)
+	e.bool(12, m.OldEffects)
+	e.bool(13, m.LinkEFG)
+	e.int(14, int64(m.Channels))
+	e.string(15, m.Message)
+	e.int(16, int64(m.PatternHighlight_Beat))
+	e.int(17, int64(m.PatternHighlight_Measure))
+	for i := range m.ChannelSettings {
+		e.message(18, func(e *encoder) { marshalChannelSetting(e, &m.ChannelSettings[i]) })
+	}
+	order := make([]int64, len(m.Order))
+	for i, v := range m.Order {
+		order[i] = int64(v)
+	}
+	e.packed(19, order)
+	for i := range m.Instruments {
+		e.message(20, func(e *encoder) { marshalInstrument(e, &m.Instruments[i]) })
+	}
+	for i := range m.Samples {
+		e.message(21, func(e *encoder) { marshalSample(e, &m.Samples[i], options) })
+	}
+	for i := range m.Patterns {
+		e.message(22, func(e *encoder) { marshalPattern(e, &m.Patterns[i]) })
+	}
+	if m.Details != nil {
+		e.message(23, func(e *encoder) { marshalDetails(e, m.Details) })
+	}
+	e.int(24, SchemaVersion)
+	return e.buf
+}
+
+func marshalChannelSetting(e *encoder, cs *common.ChannelSetting) {
+	e.string(1, cs.Name)
+	e.int(2, int64(cs.InitialVolume))
+	e.int(3, int64(cs.InitialPan))
+	e.bool(4, cs.Mute)
+	e.bool(5, cs.Surround)
+}
+
+func marshalInstrument(e *encoder, ins *common.Instrument) {
+	e.string(1, ins.Name)
+	e.string(2, ins.DosFilename)
+	e.int(3, int64(ins.NewNoteAction))
+	e.int(4, int64(ins.DuplicateCheckType))
+	e.int(5, int64(ins.DuplicateCheckAction))
+	e.int(6, int64(ins.Fadeout))
+	e.int(7, int64(ins.PitchPanSeparation))
+	e.int(8, int64(ins.PitchPanCenter))
+	e.int(9, int64(ins.GlobalVolume))
+	e.int(10, int64(ins.DefaultPan))
+	e.bool(11, ins.DefaultPanEnabled)
+	e.int(12, int64(ins.RandomVolumeVariation))
+	e.int(13, int64(ins.RandomPanVariation))
+	e.int(14, int64(ins.FilterCutoff))
+	e.int(15, int64(ins.FilterResonance))
+	e.int(16, int64(ins.MidiChannel))
+	e.int(17, int64(ins.MidiProgram))
+	e.int(18, int64(ins.MidiBank))
+	notemap := make([]int64, 0, len(ins.Notemap)*2)
+	for _, entry := range ins.Notemap {
+		notemap = append(notemap, int64(entry.Note), int64(entry.Sample))
+	}
+	e.packed(19, notemap)
+	for i := range ins.Envelopes {
+		e.message(20, func(e *encoder) { marshalEnvelope(e, &ins.Envelopes[i]) })
+	}
+	if ins.FM != nil {
+		e.message(21, func(e *encoder) { marshalFM(e, ins.FM) })
+	}
+	if ins.Synth != nil {
+		e.message(22, func(e *encoder) { marshalSynth(e, ins.Synth) })
+	}
+}
+
+func marshalEnvelope(e *encoder, env *common.Envelope) {
+	e.bool(1, env.Enabled)
+	e.bool(2, env.Loop)
+	e.bool(3, env.Sustain)
+	e.int(4, int64(env.Type))
+	e.int(5, int64(env.LoopStart))
+	e.int(6, int64(env.LoopEnd))
+	e.int(7, int64(env.SustainStart))
+	e.int(8, int64(env.SustainEnd))
+	nodes := make([]int64, 0, len(env.Nodes)*2)
+	for _, node := range env.Nodes {
+		nodes = append(nodes, int64(node.X), int64(node.Y))
+	}
+	e.packed(9, nodes)
+	e.bool(10, env.Carry)
+}
+
+func marshalFMOperator(e *encoder, op *common.FMOperator) {
+	e.int(1, int64(op.Characteristic))
+	e.int(2, int64(op.ScalingLevel))
+	e.int(3, int64(op.AttackDecay))
+	e.int(4, int64(op.SustainRelease))
+	e.int(5, int64(op.Waveform))
+}
+
+func marshalFM(e *encoder, fm *common.FMPatch) {
+	e.int(1, int64(fm.Kind))
+	e.message(2, func(e *encoder) { marshalFMOperator(e, &fm.Modulator) })
+	e.message(3, func(e *encoder) { marshalFMOperator(e, &fm.Carrier) })
+	e.int(4, int64(fm.FeedbackConnection))
+}
+
+func marshalSynth(e *encoder, s *common.SynthPatch) {
+	for i, v := range []int16{
+		s.WaveLength, s.AttackTicks, s.AttackVolume, s.DecayTicks, s.DecayVolume,
+		s.SustainTicks, s.ReleaseTicks, s.ReleaseVolume, s.FilterLower, s.FilterUpper,
+		s.FilterSpeed, s.SquareLower, s.SquareUpper, s.SquareSpeed, s.VibratoDelay,
+		s.VibratoDepth, s.VibratoSpeed,
+	} {
+		e.int(i+1, int64(v))
+	}
+	e.bool(18, s.HardCutRelease)
+	e.int(19, int64(s.HardCutReleaseTicks))
+	e.int(20, int64(s.PerformanceSpeed))
+	for _, step := range s.Performance {
+		e.message(21, func(e *encoder) {
+			e.int(1, int64(step.Note))
+			e.bool(2, step.Fixed)
+			e.int(3, int64(step.Waveform))
+			e.packed(4, []int64{int64(step.Effects[0]), int64(step.Effects[1])})
+			e.packed(5, []int64{int64(step.Params[0]), int64(step.Params[1])})
+		})
+	}
+}
+
+func marshalSample(e *encoder, s *common.Sample, options MarshalOptions) {
+	e.string(1, s.Name)
+	e.string(2, s.DosFilename)
+	e.int(3, int64(s.GlobalVolume))
+	e.int(4, int64(s.DefaultVolume))
+	e.int(5, int64(s.DefaultPanning))
+	e.bool(6, s.S16)
+	e.bool(7, s.Stereo)
+	e.bool(8, s.Loop)
+	e.bool(9, s.Sustain)
+	e.bool(10, s.PingPong)
+	e.bool(11, s.PingPongSustain)
+	e.int(12, int64(s.LoopStart))
+	e.int(13, int64(s.LoopEnd))
+	e.int(14, int64(s.SustainLoopStart))
+	e.int(15, int64(s.SustainLoopEnd))
+	e.int(16, int64(s.C5))
+	e.int(17, int64(s.VibratoSpeed))
+	e.int(18, int64(s.VibratoDepth))
+	e.int(19, int64(s.VibratoSweep))
+	e.int(20, int64(s.VibratoWaveform))
+	e.message(21, func(e *encoder) {
+		e.int(1, int64(s.Data.Channels))
+		e.int(2, int64(s.Data.Bits))
+		if options.OmitSampleData {
+			return
+		}
+		data := &s.Data
+		if loaded, err := s.WithLoadedStream(); err == nil {
+			data = &loaded.Data
+		}
+		for _, ch := range data.Data {
+			var pcm []byte
+			switch d := ch.(type) {
+			case []int8:
+				pcm = make([]byte, len(d))
+				for i, v := range d {
+					pcm[i] = byte(v)
+				}
+			case []int16:
+				pcm = make([]byte, len(d)*2)
+				for i, v := range d {
+					binary.LittleEndian.PutUint16(pcm[i*2:], uint16(v))
+				}
+			}
+			// Written even if empty, to keep the channel count.
+			e.lengthDelimited(3, pcm)
+		}
+	})
+	e.string(22, s.ExternalPath)
+}
+
+func marshalPattern(e *encoder, p *common.Pattern) {
+	e.string(1, p.Name)
+	e.int(2, int64(p.Channels))
+	e.int(3, int64(len(p.Rows)))
+	for row := range p.Rows {
+		for _, entry := range p.Rows[row].Entries {
+			e.message(4, func(e *encoder) {
+				e.int(1, int64(row))
+				e.int(2, int64(entry.Channel))
+				e.int(3, int64(entry.Note))
+				e.int(4, int64(entry.Instrument))
+				e.int(5, int64(entry.VolumeCommand))
+				e.int(6, int64(entry.VolumeParam))
+				e.int(7, int64(entry.Effect))
+				e.int(8, int64(entry.EffectParam))
+			})
+		}
+	}
+	e.int(5, int64(p.RowsPerBeat))
+	e.int(6, int64(p.RowsPerMeasure))
+}
+
+func marshalDetails(e *encoder, details common.SourceDetails) {
+	switch d := details.(type) {
+	case *common.ItDetails:
+		e.message(1, func(e *encoder) {
+			e.int(1, int64(d.Cwtv))
+			e.int(2, int64(d.Cmwt))
+			e.int(3, int64(d.Flags))
+			e.int(4, int64(d.Special))
+			for _, q := range d.Quirks {
+				e.lengthDelimited(5, []byte(q))
+			}
+			if d.Tracker != "" {
+				e.lengthDelimited(7, []byte(d.Tracker))
+			}
+			if d.Macros != nil {
+				e.message(6, func(e *encoder) {
+					for _, macro := range d.Macros.Parametric {
+						e.lengthDelimited(1, []byte(macro))
+					}
+					for _, macro := range d.Macros.Fixed {
+						e.lengthDelimited(2, []byte(macro))
+					}
+				})
+			}
+		})
+	case *common.S3mDetails:
+		e.message(2, func(e *encoder) {
+			e.int(1, int64(d.Cwtv))
+			e.int(2, int64(d.Ffi))
+			e.int(3, int64(d.Flags))
+			e.int(4, int64(d.Special))
+			e.int(5, int64(d.UltraClick))
+			e.int(6, int64(d.DefaultPan))
+			if d.Tracker != "" {
+				e.lengthDelimited(7, []byte(d.Tracker))
+			}
+		})
+	}
+}
+
+// Decode a module. Fields that aren't in the schema are ignored, so data from a newer
+// schema version decodes as far as this version understands it. Data from older versions
+// is migrated to the current one.
+func Unmarshal(data []byte) (*common.Module, error) {
+	m := &common.Module{}
+	version := 0
+	err := parse(data, func(f *field) error {
+		switch f.number {
+		case 1:
+			m.Source = common.ModuleSourceFormat(f.int16())
+		case 2:
+			m.Title = string(f.data)
+		case 3:
+			m.GlobalVolume = f.int16()
+		case 4:
+			m.MixingVolume = f.int16()
+		case 5:
+			m.InitialSpeed = f.int16()
+		case 6:
+			m.InitialTempo = f.int16()
+		case 7:
+			m.PanSeparation = f.int16()
+		case 8:
+			m.PitchWheelDepth = f.int16()
+		case 9:
+			m.StereoMixing = f.bool()
+		case 10:
+			m.UseInstruments = f.bool()
+		case 11:
+			m.LinearSlides = f.bool()
+		case 12:
+			m.OldEffects = f.bool()
+		case 13:
+			m.LinkEFG = f.bool()
+		case 14:
+			m.Channels = f.int16()
+		case 15:
+			m.Message = string(f.data)
+		case 16:
+			m.PatternHighlight_Beat = f.int16()
+		case 17:
+			m.PatternHighlight_Measure = f.int16()
+		case 18:
+			var cs common.ChannelSetting
+			if err := unmarshalChannelSetting(f.data, &cs); err != nil {
+				return err
+			}
+			m.ChannelSettings = append(m.ChannelSettings, cs)
+		case 19:
+			values, err := f.ints()
+			if err != nil {
+				return err
+			}
+			for _, v := range values {
+				m.Order = append(m.Order, int16(v))
+			}
+		case 20:
+			var ins common.Instrument
+			if err := unmarshalInstrument(f.data, &ins); err != nil {
+				return err
+			}
+			m.Instruments = append(m.Instruments, ins)
+		case 21:
+			var s common.Sample
+			if err := unmarshalSample(f.data, &s); err != nil {
+				return err
+			}
+			m.Samples = append(m.Samples, s)
+		case 22:
+			var p common.Pattern
+			if err := unmarshalPattern(f.data, &p); err != nil {
+				return err
+			}
+			m.Patterns = append(m.Patterns, p)
+		case 23:
+			details, err := unmarshalDetails(f.data)
+			if err != nil {
+				return err
+			}
+			m.Details = details
+		case 24:
+			version = int(f.int32())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	migrate(m, version)
+	return m, nil
+}
+
+func unmarshalChannelSetting(data []byte, cs *common.ChannelSetting) error {
+	return parse(data, func(f *field) error {
+		switch f.number {
+		case 1:
+			cs.Name = string(f.data)
+		case 2:
+			cs.InitialVolume = f.int16()
+		case 3:
+			cs.InitialPan = f.int16()
+		case 4:
+			cs.Mute = f.bool()
+		case 5:
+			cs.Surround = f.bool()
+		}
+		return nil
+	})
+}
+
+func unmarshalInstrument(data []byte, ins *common.Instrument) error {
+	var notemap []int64
+	err := parse(data, func(f *field) error {
+		switch f.number {
+		case 1:
+			ins.Name = string(f.data)
+		case 2:
+			ins.DosFilename = string(f.data)
+		case 3:
+			ins.NewNoteAction = f.int16()
+		case 4:
+			ins.DuplicateCheckType = f.int16()
+		case 5:
+			ins.DuplicateCheckAction = f.int16()
+		case 6:
+			ins.Fadeout = f.int16()
+		case 7:
+			ins.PitchPanSeparation = f.int16()
+		case 8:
+			ins.PitchPanCenter = f.int16()
+		case 9:
+			ins.GlobalVolume = f.int16()
+		case 10:
+			ins.DefaultPan = f.int16()
+		case 11:
+			ins.DefaultPanEnabled = f.bool()
+		case 12:
+			ins.RandomVolumeVariation = f.int16()
+		case 13:
+			ins.RandomPanVariation = f.int16()
+		case 14:
+			ins.FilterCutoff = f.int16()
+		case 15:
+			ins.FilterResonance = f.int16()
+		case 16:
+			ins.MidiChannel = f.int16()
+		case 17:
+			ins.MidiProgram = f.int16()
+		case 18:
+			ins.MidiBank = f.uint16()
+		case 19:
+			values, err := f.ints()
+			if err != nil {
+				return err
+			}
+			notemap = append(notemap, values...)
+		case 20:
+			var env common.Envelope
+			if err := unmarshalEnvelope(f.data, &env); err != nil {
+				return err
+			}
+			ins.Envelopes = append(ins.Envelopes, env)
+		case 21:
+			ins.FM = &common.FMPatch{}
+			if err := unmarshalFM(f.data, ins.FM); err != nil {
+				return err
+			}
+		case 22:
+			ins.Synth = &common.SynthPatch{}
+			if err := unmarshalSynth(f.data, ins.Synth); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(notemap) > len(ins.Notemap)*2 {
+		return fmt.Errorf("%w: notemap has %d values", ErrInvalidData, len(notemap))
+	}
+	for i := 0; i+1 < len(notemap); i += 2 {
+		ins.Notemap[i/2] = common.NotemapEntry{Note: int16(notemap[i]), Sample: int16(notemap[i+1])}
+	}
+	return nil
+}
+
+func unmarshalEnvelope(data []byte, env *common.Envelope) error {
+	return parse(data, func(f *field) error {
+		switch f.number {
+		case 1:
+			env.Enabled = f.bool()
+		case 2:
+			env.Loop = f.bool()
+		case 3:
+			env.Sustain = f.bool()
+		case 4:
+			env.Type = common.EnvelopeType(f.int16())
+		case 5:
+			env.LoopStart = f.int16()
+		case 6:
+			env.LoopEnd = f.int16()
+		case 7:
+			env.SustainStart = f.int16()
+		case 8:
+			env.SustainEnd = f.int16()
+		case 9:
+			values, err := f.ints()
+			if err != nil {
+				return err
+			}
+			for i := 0; i+1 < len(values); i += 2 {
+				env.Nodes = append(env.Nodes, common.EnvelopeNode{X: int16(values[i]), Y: int16(values[i+1])})
+			}
+		case 10:
+			env.Carry = f.bool()
+		}
+		return nil
+	})
+}
+
+func unmarshalFMOperator(data []byte, op *common.FMOperator) error {
+	return parse(data, func(f *field) error {
+		switch f.number {
+		case 1:
+			op.Characteristic = f.uint8()
+		case 2:
+			op.ScalingLevel = f.uint8()
+		case 3:
+			op.AttackDecay = f.uint8()
+		case 4:
+			op.SustainRelease = f.uint8()
+		case 5:
+			op.Waveform = f.uint8()
+		}
+		return nil
+	})
+}
+
+func unmarshalFM(data []byte, fm *common.FMPatch) error {
+	return parse(data, func(f *field) error {
+		switch f.number {
+		case 1:
+			fm.Kind = f.int16()
+		case 2:
+			return unmarshalFMOperator(f.data, &fm.Modulator)
+		case 3:
+			return unmarshalFMOperator(f.data, &fm.Carrier)
+		case 4:
+			fm.FeedbackConnection = f.uint8()
+		}
+		return nil
+	})
+}
+
+func unmarshalSynth(data []byte, s *common.SynthPatch) error {
+	fields := []*int16{
+		&s.WaveLength, &s.AttackTicks, &s.AttackVolume, &s.DecayTicks, &s.DecayVolume,
+		&s.SustainTicks, &s.ReleaseTicks, &s.ReleaseVolume, &s.FilterLower, &s.FilterUpper,
+		&s.FilterSpeed, &s.SquareLower, &s.SquareUpper, &s.SquareSpeed, &s.VibratoDelay,
+		&s.VibratoDepth, &s.VibratoSpeed,
+	}
+	return parse(data, func(f *field) error {
+		switch {
+		case f.number >= 1 && f.number <= len(fields):
+			*fields[f.number-1] = f.int16()
+		case f.number == 18:
+			s.HardCutRelease = f.bool()
+		case f.number == 19:
+			s.HardCutReleaseTicks = f.int16()
+		case f.number == 20:
+			s.PerformanceSpeed = f.int16()
+		case f.number == 21:
+			var step common.SynthStep
+			if err := unmarshalSynthStep(f.data, &step); err != nil {
+				return err
+			}
+			s.Performance = append(s.Performance, step)
+		}
+		return nil
+	})
+}
+
+func unmarshalSynthStep(data []byte, step *common.SynthStep) error {
+	return parse(data, func(f *field) error {
+		switch f.number {
+		case 1:
+			step.Note = f.int16()
+		case 2:
+			step.Fixed = f.bool()
+		case 3:
+			step.Waveform = f.int16()
+		case 4, 5:
+			values, err := f.ints()
+			if err != nil {
+				return err
+			}
+			target := &step.Effects
+			if f.number == 5 {
+				target = &step.Params
+			}
+			for i := 0; i < len(values) && i < len(target); i++ {
+				target[i] = uint8(values[i])
+			}
+		}
+		return nil
+	})
+}
+
+func unmarshalSample(data []byte, s *common.Sample) error {
+	return parse(data, func(f *field) error {
+		switch f.number {
+		case 1:
+			s.Name = string(f.data)
+		case 2:
+			s.DosFilename = string(f.data)
+		case 3:
+			s.GlobalVolume = f.int16()
+		case 4:
+			s.DefaultVolume = f.int16()
+		case 5:
+			s.DefaultPanning = f.int16()
+		case 6:
+			s.S16 = f.bool()
+		case 7:
+			s.Stereo = f.bool()
+		case 8:
+			s.Loop = f.bool()
+		case 9:
+			s.Sustain = f.bool()
+		case 10:
+			s.PingPong = f.bool()
+		case 11:
+			s.PingPongSustain = f.bool()
+		case 12:
+			s.LoopStart = int(f.int32())
+		case 13:
+			s.LoopEnd = int(f.int32())
+		case 14:
+			s.SustainLoopStart = int(f.int32())
+		case 15:
+			s.SustainLoopEnd = int(f.int32())
+		case 16:
+			s.C5 = int(f.int32())
+		case 17:
+			s.VibratoSpeed = f.int16()
+		case 18:
+			s.VibratoDepth = f.int16()
+		case 19:
+			s.VibratoSweep = f.int16()
+		case 20:
+			s.VibratoWaveform = f.int16()
+		case 21:
+			return unmarshalSampleData(f.data, &s.Data)
+		case 22:
+			s.ExternalPath = string(f.data)
+		}
+		return nil
+	})
+}
+
+func unmarshalSampleData(data []byte, sd *common.SampleData) error {
+	var pcm [][]byte
+	err := parse(data, func(f *field) error {
+		switch f.number {
+		case 1:
+			sd.Channels = int8(f.value)
+		case 2:
+			sd.Bits = int8(f.value)
+		case 3:
+			pcm = append(pcm, f.data)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, b := range pcm {
+		switch sd.Bits {
+		case 8:
+			d := make([]int8, len(b))
+			for i, v := range b {
+				d[i] = int8(v)
+			}
+			sd.Data = append(sd.Data, d)
+		case 16:
+			if len(b)%2 != 0 {
+				return fmt.Errorf("%w: odd length 16-bit sample data", ErrInvalidData)
+			}
+			d := make([]int16, len(b)/2)
+			for i := range d {
+				d[i] = int16(binary.LittleEndian.Uint16(b[i*2:]))
+			}
+			sd.Data = append(sd.Data, d)
+		default:
+			return fmt.Errorf("%w: %d-bit sample data", ErrInvalidData, sd.Bits)
+		}
+	}
+	return nil
+}
+
+func unmarshalPattern(data []byte, p *common.Pattern) error {
+	var entries []struct {
+		row   int
+		entry common.PatternEntry
+	}
+	rows := 0
+	err := parse(data, func(f *field) error {
+		switch f.number {
+		case 1:
+			p.Name = string(f.data)
+		case 2:
+			p.Channels = f.int16()
+		case 3:
+			rows = int(f.int32())
+		case 4:
+			var row int
+			var entry common.PatternEntry
+			err := parse(f.data, func(f *field) error {
+				switch f.number {
+				case 1:
+					row = int(f.int32())
+				case 2:
+					entry.Channel = f.uint8()
+				case 3:
+					entry.Note = f.uint8()
+				case 4:
+					entry.Instrument = f.int16()
+				case 5:
+					entry.VolumeCommand = f.uint8()
+				case 6:
+					entry.VolumeParam = f.uint8()
+				case 7:
+					entry.Effect = f.uint8()
+				case 8:
+					entry.EffectParam = f.uint8()
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			entries = append(entries, struct {
+				row   int
+				entry common.PatternEntry
+			}{row, entry})
+		case 5:
+			p.RowsPerBeat = f.int16()
+		case 6:
+			p.RowsPerMeasure = f.int16()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if rows < 0 || rows > maxRows {
+		return fmt.Errorf("%w: pattern has %d rows", ErrInvalidData, rows)
+	}
+	p.Rows = make([]common.PatternRow, rows)
+	for _, e := range entries {
+		if e.row < 0 || e.row >= rows {
+			return fmt.Errorf("%w: entry in row %d of %d", ErrInvalidData, e.row, rows)
+		}
+		p.Rows[e.row].Entries = append(p.Rows[e.row].Entries, e.entry)
+	}
+	return nil
+}
+
+// Sanity limit for pattern lengths, so bad data can't allocate huge patterns.
+const maxRows = 1 << 16
+
+func unmarshalDetails(data []byte) (common.SourceDetails, error) {
+	var details common.SourceDetails
+	err := parse(data, func(f *field) error {
+		switch f.number {
+		case 1:
+			d := &common.ItDetails{}
+			details = d
+			return parse(f.data, func(f *field) error {
+				switch f.number {
+				case 1:
+					d.Cwtv = f.uint16()
+				case 2:
+					d.Cmwt = f.uint16()
+				case 3:
+					d.Flags = f.uint16()
+				case 4:
+					d.Special = f.uint16()
+				case 5:
+					d.Quirks = append(d.Quirks, string(f.data))
+				case 6:
+					d.Macros = &common.MidiMacros{}
+					return unmarshalMacros(f.data, d.Macros)
+				case 7:
+					d.Tracker = string(f.data)
+				}
+				return nil
+			})
+		case 2:
+			d := &common.S3mDetails{}
+			details = d
+			return parse(f.data, func(f *field) error {
+				switch f.number {
+				case 1:
+					d.Cwtv = f.uint16()
+				case 2:
+					d.Ffi = f.uint16()
+				case 3:
+					d.Flags = f.uint16()
+				case 4:
+					d.Special = f.uint16()
+				case 5:
+					d.UltraClick = f.uint8()
+				case 6:
+					d.DefaultPan = f.uint8()
+				case 7:
+					d.Tracker = string(f.data)
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	return details, err
+}
+
+func unmarshalMacros(data []byte, macros *common.MidiMacros) error {
+	parametric, fixed := 0, 0
+	return parse(data, func(f *field) error {
+		switch {
+		case f.number == 1 && parametric < len(macros.Parametric):
+			macros.Parametric[parametric] = string(f.data)
+			parametric++
+		case f.number == 2 && fixed < len(macros.Fixed):
+			macros.Fixed[fixed] = string(f.data)
+			fixed++
+		}
+		return nil
+	})
+}
@@ -0,0 +1,193 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package modpb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mukunda.com/modlib/common"
+	"go.mukunda.com/modlib/itmod"
+)
+
+// Empty and nil slices encode the same way, so compare with nil slices everywhere.
+func normalize(m *common.Module) {
+	if len(m.ChannelSettings) == 0 {
+		m.ChannelSettings = nil
+	}
+	for i := range m.Instruments {
+		ins := &m.Instruments[i]
+		if len(ins.Envelopes) == 0 {
+			ins.Envelopes = nil
+		}
+		for j := range ins.Envelopes {
+			if len(ins.Envelopes[j].Nodes) == 0 {
+				ins.Envelopes[j].Nodes = nil
+			}
+		}
+	}
+	for i := range m.Patterns {
+		for j := range m.Patterns[i].Rows {
+			if len(m.Patterns[i].Rows[j].Entries) == 0 {
+				m.Patterns[i].Rows[j].Entries = nil
+			}
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	itm, err := itmod.LoadITFile("../itmod/test/reflection.it")
+	assert.NoError(t, err)
+	m := itm.ToCommon()
+	m.Instruments[0].FM = &common.FMPatch{Kind: common.FMSnareDrum, Carrier: common.FMOperator{Waveform: 2}}
+	m.Instruments[0].Synth = &common.SynthPatch{
+		WaveLength: 3, AttackVolume: -1, HardCutRelease: true,
+		Performance: []common.SynthStep{{Note: -12, Fixed: true, Effects: [2]uint8{1, 2}, Params: [2]uint8{0, 255}}},
+	}
+	m.Patterns[0].RowsPerBeat = 3
+	m.Instruments[0].Envelopes[0].Carry = true
+	m.Details.(*common.ItDetails).Macros = &common.MidiMacros{}
+	m.Details.(*common.ItDetails).Macros.Fixed[3] = "F0F00118"
+	normalize(m)
+
+	decoded, err := Unmarshal(Marshal(m, MarshalOptions{}))
+	assert.NoError(t, err)
+	normalize(decoded)
+	assert.Equal(t, m, decoded)
+
+	m.Details = &common.S3mDetails{Cwtv: 0x1320, Ffi: 2, DefaultPan: 0xFC, Tracker: "Scream Tracker 3"}
+	decoded, err = Unmarshal(Marshal(m, MarshalOptions{}))
+	assert.NoError(t, err)
+	assert.Equal(t, m.Details, decoded.Details)
+}
+
+func TestOmitSampleData(t *testing.T) {
+	itm, err := itmod.LoadITFile("../itmod/test/reflection.it")
+	assert.NoError(t, err)
+	m := itm.ToCommon()
+
+	full := Marshal(m, MarshalOptions{})
+	small := Marshal(m, MarshalOptions{OmitSampleData: true})
+	assert.Less(t, len(small), len(full))
+
+	decoded, err := Unmarshal(small)
+	assert.NoError(t, err)
+	assert.Len(t, decoded.Samples, len(m.Samples))
+	for i, s := range decoded.Samples {
+		assert.Equal(t, m.Samples[i].Data.Bits, s.Data.Bits)
+		assert.Equal(t, m.Samples[i].Data.Channels, s.Data.Channels)
+		assert.Empty(t, s.Data.Data)
+		assert.Equal(t, m.Samples[i].LoopEnd, s.LoopEnd)
+	}
+}
+
+func TestInvalidData(t *testing.T) {
+	m := &common.Module{Title: "test", Patterns: []common.Pattern{{Rows: make([]common.PatternRow, 4)}}}
+	m.Patterns[0].Rows[2].Set(common.PatternEntry{Channel: 1, Note: 60})
+	data := Marshal(m, MarshalOptions{})
+
+	// Cut inside of the pattern message.
+	_, err := Unmarshal(data[:len(data)-1])
+	assert.ErrorIs(t, err, ErrInvalidData)
+
+	// Entry outside of the pattern.
+	var e encoder
+	e.message(22, func(e *encoder) {
+		e.int(3, 4)
+		e.message(4, func(e *encoder) { e.int(1, 4) })
+	})
+	_, err = Unmarshal(e.buf)
+	assert.ErrorIs(t, err, ErrInvalidData)
+
+	// Unknown fields are skipped.
+	e = encoder{}
+	e.int(100, 5)
+	e.string(2, "title")
+	decoded, err := Unmarshal(e.buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "title", decoded.Title)
+}
+
+func TestSchemaVersion(t *testing.T) {
+	m := &common.Module{Title: "versioned"}
+	data := Marshal(m, MarshalOptions{})
+	version, err := Version(data)
+	assert.NoError(t, err)
+	assert.Equal(t, SchemaVersion, version)
+
+	// Data from before versioning is version 1.
+	var e encoder
+	e.string(2, "old")
+	version, err = Version(e.buf)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, version)
+
+	// Fields from a newer schema are ignored.
+	newer := append([]byte(nil), data...)
+	var extra encoder
+	extra.int(24, SchemaVersion+1)
+	extra.string(99, "future")
+	newer = append(newer, extra.buf...)
+	decoded, err := Unmarshal(newer)
+	assert.NoError(t, err)
+	assert.Equal(t, "versioned", decoded.Title)
+
+	// Current data doesn't need migrating.
+	migrated, err := Migrate(data)
+	assert.NoError(t, err)
+	assert.Equal(t, data, migrated)
+
+	// Version 1 data is brought up to date.
+	migrated, err = Migrate(e.buf)
+	assert.NoError(t, err)
+	version, _ = Version(migrated)
+	assert.Equal(t, SchemaVersion, version)
+}
+
+func TestExternalPath(t *testing.T) {
+	sd, _ := common.FromFloat64([][]float64{{0, 0.5}}, 16)
+	m := &common.Module{Samples: []common.Sample{
+		{Name: "found", ExternalPath: `samples\kick.wav`, Data: sd},
+		{Name: "missing", ExternalPath: "snare.wav", Data: common.SampleData{Channels: 1, Bits: 16}},
+	}}
+
+	decoded, err := Unmarshal(Marshal(m, MarshalOptions{}))
+	assert.NoError(t, err)
+	assert.Equal(t, `samples\kick.wav`, decoded.Samples[0].ExternalPath)
+	assert.Equal(t, sd.Data, decoded.Samples[0].Data.Data)
+	assert.Equal(t, "snare.wav", decoded.Samples[1].ExternalPath)
+	assert.Zero(t, decoded.Samples[1].Data.Len())
+
+	_, samples, err := UnmarshalSamples(MarshalSamples(nil, m.Samples, MarshalOptions{OmitSampleData: true}))
+	assert.NoError(t, err)
+	assert.Equal(t, "snare.wav", samples[1].ExternalPath)
+}
+
+func TestBanks(t *testing.T) {
+	itm, err := itmod.LoadITFile("../itmod/test/reflection.it")
+	assert.NoError(t, err)
+	m := itm.ToCommon()
+	normalize(m)
+
+	patterns, err := UnmarshalPatterns(MarshalPatterns(m.Patterns))
+	assert.NoError(t, err)
+	assert.Equal(t, m.Patterns, patterns)
+
+	bank := MarshalSamples(m.Instruments, m.Samples, MarshalOptions{})
+	assert.Less(t, len(bank), len(Marshal(m, MarshalOptions{})))
+	instruments, samples, err := UnmarshalSamples(bank)
+	assert.NoError(t, err)
+	assert.Equal(t, m.Samples, samples)
+	assert.Len(t, instruments, len(m.Instruments))
+
+	// A bank reads as a module with only those parts.
+	decoded, err := Unmarshal(bank)
+	assert.NoError(t, err)
+	assert.Empty(t, decoded.Patterns)
+	assert.Len(t, decoded.Samples, len(m.Samples))
+
+	_, _, err = UnmarshalSamples(bank[:len(bank)-4])
+	assert.ErrorIs(t, err, ErrInvalidData)
+}
@@ -0,0 +1,61 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package modpb
+
+import "go.mukunda.com/modlib/common"
+
+// Version of the schema that Marshal writes. It goes up when a change to common.Module
+// changes the meaning of encoded data, e.g. a field that is replaced by another, and a
+// migration is added so older data still decodes correctly. Adding a field doesn't need a
+// new version: decoders that don't know it ignore it.
+//
+// Versions:
+//   - 1: The first versioned schema.
+//   - 2: Samples can refer to external files (Sample.external_path), and then may have no
+//     PCM.
+const SchemaVersion = 2
+
+// Functions that bring a decoded module from one schema version to the next, keyed by the
+// version they migrate from.
+var migrations = map[int]func(m *common.Module){}
+
+// Read the schema version of encoded data without decoding it. Data from before versioning
+// reports 1.
+func Version(data []byte) (int, error) {
+	version := 0
+	err := parse(data, func(f *field) error {
+		if f.number == 24 {
+			version = int(f.int32())
+		}
+		return nil
+	})
+	return max(version, 1), err
+}
+
+// Apply the migrations from a version to the current one. Newer versions are left alone.
+func migrate(m *common.Module, version int) {
+	for v := max(version, 1); v < SchemaVersion; v++ {
+		if fn := migrations[v]; fn != nil {
+			fn(m)
+		}
+	}
+}
+
+// Re-encode data from an older schema version with the current one. Data that is already
+// current is returned as is.
+func Migrate(data []byte) ([]byte, error) {
+	version, err := Version(data)
+	if err != nil {
+		return nil, err
+	}
+	if version >= SchemaVersion {
+		return data, nil
+	}
+	m, err := Unmarshal(data)
+	if err != nil {
+		return nil, err
+	}
+	return Marshal(m, MarshalOptions{}), nil
+}
@@ -0,0 +1,157 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package modpb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Returned for data that isn't a valid encoding of the schema.
+var ErrInvalidData = errors.New("invalid protobuf data")
+
+// Wire types.
+const (
+	wireVarint = 0
+	wireBytes  = 2
+)
+
+// Builds a message. Zero values are skipped, as proto3 does.
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) tag(field int, wire int) {
+	e.buf = binary.AppendUvarint(e.buf, uint64(field)<<3|uint64(wire))
+}
+
+// Any integer field. Negative int32 values are sign-extended to 64 bits, as protobuf
+// requires.
+func (e *encoder) int(field int, v int64) {
+	if v != 0 {
+		e.tag(field, wireVarint)
+		e.buf = binary.AppendUvarint(e.buf, uint64(v))
+	}
+}
+
+func (e *encoder) bool(field int, v bool) {
+	if v {
+		e.int(field, 1)
+	}
+}
+
+func (e *encoder) bytes(field int, b []byte) {
+	if len(b) > 0 {
+		e.lengthDelimited(field, b)
+	}
+}
+
+func (e *encoder) string(field int, s string) {
+	e.bytes(field, []byte(s))
+}
+
+func (e *encoder) lengthDelimited(field int, b []byte) {
+	e.tag(field, wireBytes)
+	e.buf = binary.AppendUvarint(e.buf, uint64(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+// A nested message. It's written even if empty, so its presence is kept.
+func (e *encoder) message(field int, build func(e *encoder)) {
+	var nested encoder
+	build(&nested)
+	e.lengthDelimited(field, nested.buf)
+}
+
+// A packed repeated integer field.
+func (e *encoder) packed(field int, values []int64) {
+	if len(values) == 0 {
+		return
+	}
+	var packed []byte
+	for _, v := range values {
+		packed = binary.AppendUvarint(packed, uint64(v))
+	}
+	e.lengthDelimited(field, packed)
+}
+
+// A decoded field. For varints, value is set; for length-delimited fields, data is.
+type field struct {
+	number int
+	wire   int
+	value  uint64
+	data   []byte
+}
+
+func (f *field) int32() int32   { return int32(f.value) }
+func (f *field) int16() int16   { return int16(int32(f.value)) }
+func (f *field) uint8() uint8   { return uint8(f.value) }
+func (f *field) uint16() uint16 { return uint16(f.value) }
+func (f *field) bool() bool     { return f.value != 0 }
+
+// Values of a repeated integer field, packed or not.
+func (f *field) ints() ([]int64, error) {
+	if f.wire == wireVarint {
+		return []int64{int64(f.value)}, nil
+	}
+	var values []int64
+	for data := f.data; len(data) > 0; {
+		v, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, fmt.Errorf("%w: bad packed field %d", ErrInvalidData, f.number)
+		}
+		values = append(values, int64(v))
+		data = data[n:]
+	}
+	return values, nil
+}
+
+// Call fn for each field in a message. Fields with unsupported wire types (fixed-width
+// numbers aren't used by the schema) are skipped.
+func parse(data []byte, fn func(f *field) error) error {
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return fmt.Errorf("%w: bad field key", ErrInvalidData)
+		}
+		data = data[n:]
+		f := field{number: int(key >> 3), wire: int(key & 7)}
+
+		switch f.wire {
+		case wireVarint:
+			if f.value, n = binary.Uvarint(data); n <= 0 {
+				return fmt.Errorf("%w: bad varint in field %d", ErrInvalidData, f.number)
+			}
+			data = data[n:]
+		case wireBytes:
+			length, n := binary.Uvarint(data)
+			if n <= 0 || length > uint64(len(data)-n) {
+				return fmt.Errorf("%w: bad length in field %d", ErrInvalidData, f.number)
+			}
+			f.data = data[n : n+int(length)]
+			data = data[n+int(length):]
+		case 1: // 64-bit
+			if len(data) < 8 {
+				return fmt.Errorf("%w: truncated field %d", ErrInvalidData, f.number)
+			}
+			data = data[8:]
+			continue
+		case 5: // 32-bit
+			if len(data) < 4 {
+				return fmt.Errorf("%w: truncated field %d", ErrInvalidData, f.number)
+			}
+			data = data[4:]
+			continue
+		default:
+			return fmt.Errorf("%w: unsupported wire type %d", ErrInvalidData, f.wire)
+		}
+
+		if err := fn(&f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
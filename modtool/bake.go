@@ -0,0 +1,240 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package modtool
+
+import (
+	"fmt"
+
+	"go.mukunda.com/modlib/common"
+)
+
+// Rewrite a module to play at speed 1, so every tick gets its own row, and turn effects
+// that act within a row into explicit rows, e.g. for exporting to MIDI or to players that
+// lack the effects. Arpeggios (Jxy) and retriggers (Qxy) become notes on the ticks where
+// they change the pitch or restart the sample, note delays and cuts move to the row of
+// their tick, and slides become fine slides on each tick. Notes written for arpeggios
+// restart the sample, unlike the effect, and the base note is written again after them.
+//
+// The timing follows Requantize, and its warnings are included. Effects that can't work
+// with one tick per row, like vibrato and tone portamento, are left in place with a
+// warning. Effect state starts fresh in each pattern.
+func BakeEffects(m *common.Module) ([]string, error) {
+	speed := max(int(m.InitialSpeed), 1)
+	warnings, err := Requantize(m, 1, max(int(m.InitialTempo), 32))
+	if err != nil {
+		return nil, err
+	}
+
+	b := &baker{speed: speed, warnings: warnings}
+	for i := range m.Patterns {
+		p := &m.Patterns[i]
+		channels := max(int(m.Channels), int(p.Channels))
+		for c := range channels {
+			b.bakeChannel(i, p, c)
+		}
+	}
+	return b.warnings, nil
+}
+
+type baker struct {
+	speed    int // Ticks in each of the original rows.
+	warnings []string
+}
+
+// Effect state of a channel while baking.
+type bakeState struct {
+	note        uint8 // Last note played, 0 = none.
+	arpeggiated bool  // The last arpeggio row left the note shifted from note.
+	memory      map[uint8]uint8
+	retrigCount int
+}
+
+// Effects that share their parameter memory, mapped to the effect that holds it.
+var bakeMemoryKey = map[uint8]uint8{
+	effectD: effectD, effectK: effectD, effectL: effectD,
+	effectE: effectE, effectF: effectE,
+	effectJ: effectJ, effectN: effectN, effectP: effectP, effectQ: effectQ, effectW: effectW,
+}
+
+func (b *baker) bakeChannel(pattern int, p *common.Pattern, channel int) {
+	state := bakeState{memory: make(map[uint8]uint8)}
+	for r := range p.Rows {
+		e := p.Rows[r].Get(channel)
+		if e.IsEmpty() && !state.arpeggiated {
+			continue
+		}
+		tick := r % b.speed
+		warn := func(format string, args ...any) {
+			where := fmt.Sprintf("pattern %d row %d channel %d: ", pattern, r, channel+1)
+			b.warnings = append(b.warnings, where+fmt.Sprintf(format, args...))
+		}
+
+		// The arpeggio ended on a shifted note, so go back to the base note.
+		if state.arpeggiated && e.Effect != effectJ {
+			state.arpeggiated = false
+			if e.Note == 0 {
+				e.Note = state.note
+			}
+		}
+
+		if key, ok := bakeMemoryKey[e.Effect]; ok {
+			if e.EffectParam != 0 {
+				state.memory[key] = e.EffectParam
+			}
+			e.EffectParam = state.memory[key]
+		}
+
+		switch {
+		case e.Note > 0 && e.Note <= 120:
+			state.note = e.Note
+		case e.Note == 254:
+			state.note = 0
+		}
+
+		switch e.Effect {
+		case effectJ:
+			semitones := uint8(0)
+			switch tick % 3 {
+			case 1:
+				semitones = e.EffectParam >> 4
+			case 2:
+				semitones = e.EffectParam & 15
+			}
+			base := state.note
+			if e.Note > 0 && e.Note <= 120 {
+				base = e.Note
+			}
+			if base == 0 {
+				warn("arpeggio without a note dropped")
+			} else if e.Note <= 120 {
+				e.Note = min(base+semitones, 120)
+				state.arpeggiated = e.Note != base
+			}
+			e.Effect, e.EffectParam = 0, 0
+
+		case effectQ:
+			// Retriggers count the ticks after the first one of the original row.
+			interval := int(e.EffectParam & 15)
+			if tick > 0 && interval > 0 {
+				state.retrigCount++
+				if state.retrigCount >= interval {
+					state.retrigCount = 0
+					if x := e.EffectParam >> 4; x != 0 && x != 8 {
+						warn("retrigger volume change not baked")
+					}
+					if e.Note == 0 && state.note != 0 {
+						e.Note = state.note
+					}
+				}
+			}
+			e.Effect, e.EffectParam = 0, 0
+
+		case effectS:
+			if e.EffectParam == effectSNoteCut<<4 && e.Note == 0 {
+				e.Note = 254
+				e.Effect, e.EffectParam = 0, 0
+				state.note = 0
+			}
+
+		case effectD, effectN, effectP, effectW:
+			if !fineVolumeSlide(e.EffectParam) {
+				e.EffectParam = b.fineVolumeSlide(e.EffectParam, tick, warn)
+				if e.EffectParam == 0 {
+					e.Effect = 0
+				}
+			}
+
+		case effectE, effectF:
+			if !finePortamento(e.EffectParam) {
+				switch {
+				case tick == 0:
+					// Regular slides don't act on the first tick.
+					e.Effect, e.EffectParam = 0, 0
+				case e.EffectParam > 15:
+					warn("portamento too fast for a fine slide")
+					e.EffectParam = 0xFF
+				default:
+					e.EffectParam = 0xF0 | e.EffectParam
+				}
+			}
+
+		case effectG, effectH, effectI, effectK, effectL, effectR, effectU, effectY:
+			if tick == 1 {
+				warn("effect %c doesn't work with one tick per row", 'A'+e.Effect-1)
+			}
+
+		case effectT:
+			if e.EffectParam < 0x20 && tick == 1 {
+				warn("tempo slide doesn't work with one tick per row")
+			}
+		}
+
+		b.bakeVolume(&e, tick, warn)
+		p.Rows[r].Set(e)
+	}
+
+	if state.arpeggiated {
+		b.warnings = append(b.warnings, fmt.Sprintf(
+			"pattern %d channel %d: arpeggio at the end of the pattern leaves a shifted note", pattern, channel+1))
+	}
+}
+
+// Convert a regular Dxy-style slide to the fine slide for one tick. Returns 0 on the first
+// tick, where regular slides don't act.
+func (b *baker) fineVolumeSlide(param uint8, tick int, warn func(string, ...any)) uint8 {
+	x, y := param>>4, param&15
+	switch {
+	case tick == 0 || param == 0:
+		return 0
+	case y == 0:
+		return x<<4 | 0xF
+	case x == 0 && y == 15:
+		// DFF is a fine slide up.
+		warn("slide too fast for a fine slide")
+		return 0xFE
+	case x == 0:
+		return 0xF0 | y
+	}
+	warn("invalid slide %02X", param)
+	return param
+}
+
+// Convert volume column slides to fine slides.
+func (b *baker) bakeVolume(e *common.PatternEntry, tick int, warn func(string, ...any)) {
+	switch e.VolumeCommand {
+	case common.VcmdVolSlideUp, common.VcmdVolSlideDown:
+		if tick == 0 {
+			e.VolumeCommand, e.VolumeParam = 0, 0
+		} else if e.VolumeCommand == common.VcmdVolSlideUp {
+			e.VolumeCommand = common.VcmdFineVolUp
+		} else {
+			e.VolumeCommand = common.VcmdFineVolDown
+		}
+
+	case common.VcmdPitchSlideDown, common.VcmdPitchSlideUp:
+		// The volume column has no fine pitch slides, so they go to the effect column.
+		effect := uint8(effectE)
+		if e.VolumeCommand == common.VcmdPitchSlideUp {
+			effect = effectF
+		}
+		amount := e.VolumeParam * 4
+		e.VolumeCommand, e.VolumeParam = 0, 0
+		switch {
+		case tick == 0 || amount == 0:
+		case e.Effect != 0 || e.EffectParam != 0:
+			warn("volume column pitch slide dropped")
+		case amount > 15:
+			warn("portamento too fast for a fine slide")
+			e.Effect, e.EffectParam = effect, 0xFF
+		default:
+			e.Effect, e.EffectParam = effect, 0xF0|amount
+		}
+
+	case common.VcmdPortaToNote, common.VcmdVibratoDepth:
+		if tick == 1 {
+			warn("volume column effect doesn't work with one tick per row")
+		}
+	}
+}
@@ -0,0 +1,152 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package modtool
+
+import (
+	"errors"
+	"fmt"
+
+	"go.mukunda.com/modlib/common"
+)
+
+// Most channels that a pattern entry can address.
+const MaxChannels = 64
+
+// Returned when a channel index is outside of the module.
+var ErrInvalidChannel = errors.New("invalid channel")
+
+// Move channels to new positions. mapping[i] is the new index of channel i, or -1 to
+// delete it. The channel settings and pattern entries are moved together, and channels
+// left without a source get default settings.
+func RemapChannels(m *common.Module, mapping []int) error {
+	channels := int(m.Channels)
+	if len(mapping) != channels {
+		return fmt.Errorf("%w: %d entries for %d channels", ErrInvalidMapping, len(mapping), channels)
+	}
+
+	count := 0
+	used := make(map[int]bool)
+	for _, target := range mapping {
+		if target < 0 {
+			continue
+		}
+		if target >= MaxChannels {
+			return fmt.Errorf("%w: %d", ErrInvalidChannel, target)
+		}
+		if used[target] {
+			return fmt.Errorf("%w: channel %d is mapped twice", ErrInvalidMapping, target)
+		}
+		used[target] = true
+		count = max(count, target+1)
+	}
+
+	settings := make([]common.ChannelSetting, count)
+	for i := range settings {
+		settings[i] = common.ChannelSetting{InitialVolume: 64, InitialPan: 32}
+	}
+	for i, target := range mapping {
+		if target >= 0 && i < len(m.ChannelSettings) {
+			settings[target] = m.ChannelSettings[i]
+		}
+	}
+	m.ChannelSettings = settings
+	m.Channels = int16(count)
+
+	for i := range m.Patterns {
+		p := &m.Patterns[i]
+		p.Channels = 0
+		for j := range p.Rows {
+			var entries []common.PatternEntry
+			for _, e := range p.Rows[j].Entries {
+				if int(e.Channel) >= channels || mapping[e.Channel] < 0 {
+					continue
+				}
+				e.Channel = uint8(mapping[e.Channel])
+				p.Channels = max(p.Channels, int16(e.Channel)+1)
+				entries = append(entries, e)
+			}
+			p.Rows[j].Entries = entries
+		}
+	}
+	return nil
+}
+
+// A mapping that leaves all channels in place.
+func identityMapping(channels int) []int {
+	mapping := make([]int, channels)
+	for i := range mapping {
+		mapping[i] = i
+	}
+	return mapping
+}
+
+func checkChannel(m *common.Module, channel int) error {
+	if channel < 0 || channel >= int(m.Channels) {
+		return fmt.Errorf("%w: %d", ErrInvalidChannel, channel)
+	}
+	return nil
+}
+
+// Exchange two channels.
+func SwapChannels(m *common.Module, a, b int) error {
+	if err := errors.Join(checkChannel(m, a), checkChannel(m, b)); err != nil {
+		return err
+	}
+	mapping := identityMapping(int(m.Channels))
+	mapping[a], mapping[b] = b, a
+	return RemapChannels(m, mapping)
+}
+
+// Move a channel to a new position, shifting the channels in between.
+func MoveChannel(m *common.Module, from, to int) error {
+	if err := errors.Join(checkChannel(m, from), checkChannel(m, to)); err != nil {
+		return err
+	}
+	mapping := identityMapping(int(m.Channels))
+	for i := min(from, to); i <= max(from, to); i++ {
+		if from < to {
+			mapping[i] = i - 1
+		} else {
+			mapping[i] = i + 1
+		}
+	}
+	mapping[from] = to
+	return RemapChannels(m, mapping)
+}
+
+// Insert an empty channel before the given index. index may be the channel count to
+// append a channel.
+func InsertChannel(m *common.Module, index int) error {
+	if index < 0 || index > int(m.Channels) || int(m.Channels) >= MaxChannels {
+		return fmt.Errorf("%w: %d", ErrInvalidChannel, index)
+	}
+	mapping := identityMapping(int(m.Channels))
+	for i := index; i < len(mapping); i++ {
+		mapping[i] = i + 1
+	}
+	if index == int(m.Channels) {
+		// Nothing moves, so the new channel has to be added explicitly.
+		if err := RemapChannels(m, mapping); err != nil {
+			return err
+		}
+		m.ChannelSettings = append(m.ChannelSettings, common.ChannelSetting{InitialVolume: 64, InitialPan: 32})
+		m.Channels++
+		return nil
+	}
+	return RemapChannels(m, mapping)
+}
+
+// Delete a channel and its pattern data, shifting the following channels down.
+func DeleteChannel(m *common.Module, index int) error {
+	if err := checkChannel(m, index); err != nil {
+		return err
+	}
+	mapping := identityMapping(int(m.Channels))
+	mapping[index] = -1
+	for i := index + 1; i < len(mapping); i++ {
+		mapping[i] = i - 1
+	}
+	return RemapChannels(m, mapping)
+}
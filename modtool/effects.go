@@ -0,0 +1,52 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package modtool
+
+// Effect letters in the common model, A = 1.
+const (
+	effectA = 1
+	effectB = 2
+	effectC = 3
+	effectD = 4
+	effectE = 5
+	effectF = 6
+	effectG = 7
+	effectH = 8
+	effectI = 9
+	effectJ = 10
+	effectK = 11
+	effectL = 12
+	effectN = 14
+	effectP = 16
+	effectQ = 17
+	effectR = 18
+	effectS = 19
+	effectT = 20
+	effectU = 21
+	effectV = 22
+	effectW = 23
+	effectY = 25
+)
+
+// Sxy subcommands, in the high nibble.
+const (
+	effectSTickDelay = 0x6
+	effectSLoop      = 0xB
+	effectSNoteCut   = 0xC
+	effectSNoteDelay = 0xD
+	effectSRowDelay  = 0xE
+)
+
+// True if a Dxy-style slide (D, K, L, N, P, W) is a fine slide, which happens once per row
+// instead of on every tick.
+func fineVolumeSlide(param uint8) bool {
+	x, y := param>>4, param&15
+	return (y == 15 && x != 0) || (x == 15 && y != 0)
+}
+
+// True if an Exx/Fxx portamento is a fine or extra fine slide.
+func finePortamento(param uint8) bool {
+	return param >= 0xE0
+}
@@ -0,0 +1,180 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package modtool
+
+import (
+	"fmt"
+
+	"go.mukunda.com/modlib/common"
+)
+
+// Volume layers for BakeGain to move into the sample PCM.
+type GainOptions struct {
+	SampleVolume     bool // Sample.GlobalVolume
+	InstrumentVolume bool // Instrument.GlobalVolume
+	GlobalVolume     bool // Module.GlobalVolume
+	DefaultVolume    bool // Sample.DefaultVolume
+
+	// Add dither when requantizing the scaled PCM.
+	Dither bool
+}
+
+// Scale the sample PCM by the selected volume layers and set those layers to full, for
+// exporting to targets that lack them, like MOD or plain WAV stems. The volume model
+// itself is unchanged; clone the module first to keep the original.
+//
+// A layer is skipped with a warning where baking it would change playback: instrument
+// volume for samples shared by instruments with different volumes, and global volume in
+// songs that change it with Vxx or Wxx. Baking default volume also scales volumes set in
+// the patterns, which a warning points out.
+//
+// Streamed samples are read into memory first, and an error is returned, with nothing
+// baked, if one can't be read.
+func BakeGain(m *common.Module, opts GainOptions) ([]string, error) {
+	for i := range m.Samples {
+		if err := m.Samples[i].LoadStream(); err != nil {
+			return nil, fmt.Errorf("sample %d: %w", i+1, err)
+		}
+	}
+
+	var warnings []string
+	gains := make([]float64, len(m.Samples))
+	for i := range gains {
+		gains[i] = 1
+	}
+
+	if opts.SampleVolume {
+		for i := range m.Samples {
+			s := &m.Samples[i]
+			gains[i] *= float64(s.GlobalVolume) / 64
+			s.GlobalVolume = 64
+		}
+	}
+
+	if opts.DefaultVolume {
+		for i := range m.Samples {
+			s := &m.Samples[i]
+			gains[i] *= float64(s.DefaultVolume) / 64
+			s.DefaultVolume = 64
+		}
+		if patternsSetVolume(m) {
+			warnings = append(warnings, "default volume baked, but the patterns set volumes that are now scaled by it")
+		}
+	}
+
+	if opts.InstrumentVolume && m.UseInstruments {
+		warnings = append(warnings, bakeInstrumentVolume(m, gains)...)
+	}
+
+	if opts.GlobalVolume {
+		if patternsUseEffect(m, effectV, effectW) {
+			warnings = append(warnings, "global volume not baked: the song changes it with Vxx or Wxx")
+		} else {
+			for i := range gains {
+				gains[i] *= float64(m.GlobalVolume) / 128
+			}
+			m.GlobalVolume = 128
+		}
+	}
+
+	for i := range m.Samples {
+		if gains[i] != 1 {
+			m.Samples[i].Data = m.Samples[i].Data.Scale(gains[i], opts.Dither)
+		}
+	}
+	return warnings, nil
+}
+
+// Move instrument volumes into the gains of their samples. Instruments that share samples
+// are baked together, and only if they all have the same volume.
+func bakeInstrumentVolume(m *common.Module, gains []float64) []string {
+	var warnings []string
+	group := make([]int, len(m.Instruments)) // Group number of each instrument, 0 = none yet.
+	sampleGroup := make([]int, len(m.Samples))
+	groups := 0
+
+	for start := range m.Instruments {
+		if group[start] != 0 {
+			continue
+		}
+		groups++
+		members := []int{start}
+		group[start] = groups
+		var samples []int
+		for i := 0; i < len(members); i++ {
+			for _, entry := range m.Instruments[members[i]].Notemap {
+				s := int(entry.Sample) - 1
+				if s < 0 || s >= len(m.Samples) || sampleGroup[s] == groups {
+					continue
+				}
+				sampleGroup[s] = groups
+				samples = append(samples, s)
+				// Add the other instruments that use the sample.
+				for j := range m.Instruments {
+					if group[j] == 0 && usesSample(&m.Instruments[j], s+1) {
+						group[j] = groups
+						members = append(members, j)
+					}
+				}
+			}
+		}
+
+		volume := m.Instruments[start].GlobalVolume
+		uniform := true
+		for _, i := range members {
+			uniform = uniform && m.Instruments[i].GlobalVolume == volume
+		}
+		if !uniform {
+			warnings = append(warnings, fmt.Sprintf("instrument %d: global volume not baked, its samples are shared with instruments of other volumes", start+1))
+			continue
+		}
+		for _, s := range samples {
+			gains[s] *= float64(volume) / 128
+		}
+		for _, i := range members {
+			m.Instruments[i].GlobalVolume = 128
+		}
+	}
+	return warnings
+}
+
+func usesSample(ins *common.Instrument, sample int) bool {
+	for _, entry := range ins.Notemap {
+		if int(entry.Sample) == sample {
+			return true
+		}
+	}
+	return false
+}
+
+// True if any pattern has one of the effects.
+func patternsUseEffect(m *common.Module, effects ...uint8) bool {
+	for i := range m.Patterns {
+		for _, row := range m.Patterns[i].Rows {
+			for _, e := range row.Entries {
+				for _, effect := range effects {
+					if e.Effect == effect {
+						return true
+					}
+				}
+			}
+		}
+	}
+	return false
+}
+
+// True if any pattern sets or slides the note volume.
+func patternsSetVolume(m *common.Module) bool {
+	for i := range m.Patterns {
+		for _, row := range m.Patterns[i].Rows {
+			for _, e := range row.Entries {
+				if e.VolumeCommand >= common.VcmdSetVolume && e.VolumeCommand <= common.VcmdVolSlideDown {
+					return true
+				}
+			}
+		}
+	}
+	return patternsUseEffect(m, effectD, effectK, effectL)
+}
@@ -0,0 +1,407 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package modtool
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mukunda.com/modlib/common"
+	"go.mukunda.com/modlib/render"
+)
+
+func TestRemapSamples(t *testing.T) {
+	m := &common.Module{
+		UseInstruments: true,
+		Samples:        []common.Sample{{Name: "a"}, {Name: "b"}, {Name: "c"}},
+		Instruments:    []common.Instrument{{}},
+		Patterns: []common.Pattern{{Rows: []common.PatternRow{
+			{Entries: []common.PatternEntry{{Note: 61, Instrument: 1}}},
+		}}},
+	}
+	m.Instruments[0].Notemap[0].Sample = 1
+	m.Instruments[0].Notemap[1].Sample = 2
+	m.Instruments[0].Notemap[2].Sample = 3
+
+	assert.NoError(t, RemapSamples(m, []int{1, -1, 0}))
+	assert.Equal(t, []common.Sample{{Name: "c"}, {Name: "a"}}, m.Samples)
+	assert.EqualValues(t, 2, m.Instruments[0].Notemap[0].Sample)
+	assert.EqualValues(t, 0, m.Instruments[0].Notemap[1].Sample)
+	assert.EqualValues(t, 1, m.Instruments[0].Notemap[2].Sample)
+
+	// The pattern refers to instruments, so it's unchanged.
+	assert.EqualValues(t, 1, m.Patterns[0].Rows[0].Entries[0].Instrument)
+
+	assert.ErrorIs(t, RemapSamples(m, []int{0}), ErrInvalidMapping)
+	assert.ErrorIs(t, RemapSamples(m, []int{0, 0}), ErrInvalidMapping)
+}
+
+func TestRemapInstruments(t *testing.T) {
+	m := &common.Module{
+		UseInstruments: true,
+		Instruments:    []common.Instrument{{Name: "a"}, {Name: "b"}},
+		Patterns: []common.Pattern{{Rows: []common.PatternRow{
+			{Entries: []common.PatternEntry{{Note: 61, Instrument: 1}, {Channel: 1, Instrument: 2}}},
+		}}},
+	}
+
+	assert.NoError(t, RemapInstruments(m, []int{2, 0}))
+	assert.Equal(t, []common.Instrument{{Name: "b"}, {}, {Name: "a"}}, m.Instruments)
+	assert.EqualValues(t, 3, m.Patterns[0].Rows[0].Entries[0].Instrument)
+	assert.EqualValues(t, 1, m.Patterns[0].Rows[0].Entries[1].Instrument)
+
+	// Without instruments, sample remapping updates the patterns.
+	m.UseInstruments = false
+	m.Samples = []common.Sample{{Name: "x"}, {Name: "y"}, {Name: "z"}}
+	assert.NoError(t, RemapSamples(m, []int{-1, 1, 2}))
+	assert.EqualValues(t, 0, m.Patterns[0].Rows[0].Entries[1].Instrument)
+	assert.EqualValues(t, 3, m.Patterns[0].Rows[0].Entries[0].Instrument)
+}
+
+func channelTestModule() *common.Module {
+	return &common.Module{
+		Channels: 3,
+		ChannelSettings: []common.ChannelSetting{
+			{Name: "a", InitialVolume: 64}, {Name: "b", InitialVolume: 64}, {Name: "c", InitialVolume: 64},
+		},
+		Patterns: []common.Pattern{{Channels: 3, Rows: []common.PatternRow{
+			{Entries: []common.PatternEntry{{Channel: 0, Note: 1}, {Channel: 1, Note: 2}, {Channel: 2, Note: 3}}},
+		}}},
+	}
+}
+
+// The note in each channel of the first row, and the channel names.
+func channelLayout(m *common.Module) ([]uint8, []string) {
+	notes := make([]uint8, m.Channels)
+	for _, e := range m.Patterns[0].Rows[0].Entries {
+		notes[e.Channel] = e.Note
+	}
+	var names []string
+	for _, s := range m.ChannelSettings {
+		names = append(names, s.Name)
+	}
+	return notes, names
+}
+
+func TestChannelOperations(t *testing.T) {
+	m := channelTestModule()
+	assert.NoError(t, SwapChannels(m, 0, 2))
+	notes, names := channelLayout(m)
+	assert.Equal(t, []uint8{3, 2, 1}, notes)
+	assert.Equal(t, []string{"c", "b", "a"}, names)
+
+	m = channelTestModule()
+	assert.NoError(t, MoveChannel(m, 0, 2))
+	notes, names = channelLayout(m)
+	assert.Equal(t, []uint8{2, 3, 1}, notes)
+	assert.Equal(t, []string{"b", "c", "a"}, names)
+
+	assert.NoError(t, MoveChannel(m, 2, 0))
+	notes, _ = channelLayout(m)
+	assert.Equal(t, []uint8{1, 2, 3}, notes)
+
+	assert.NoError(t, InsertChannel(m, 1))
+	notes, names = channelLayout(m)
+	assert.Equal(t, []uint8{1, 0, 2, 3}, notes)
+	assert.Equal(t, []string{"a", "", "b", "c"}, names)
+	assert.EqualValues(t, 64, m.ChannelSettings[1].InitialVolume)
+	assert.EqualValues(t, 4, m.Patterns[0].Channels)
+
+	assert.NoError(t, InsertChannel(m, 4))
+	assert.EqualValues(t, 5, m.Channels)
+	assert.Len(t, m.ChannelSettings, 5)
+
+	m = channelTestModule()
+	assert.NoError(t, DeleteChannel(m, 0))
+	notes, names = channelLayout(m)
+	assert.Equal(t, []uint8{2, 3}, notes)
+	assert.Equal(t, []string{"b", "c"}, names)
+	assert.EqualValues(t, 2, m.Patterns[0].Channels)
+
+	assert.ErrorIs(t, DeleteChannel(m, 2), ErrInvalidChannel)
+	assert.ErrorIs(t, SwapChannels(m, 0, -1), ErrInvalidChannel)
+}
+
+func TestRequantize(t *testing.T) {
+	newModule := func() *common.Module {
+		rows := make([]common.PatternRow, 8)
+		rows[0].Entries = []common.PatternEntry{{Channel: 0, Note: 61, Instrument: 1, Effect: effectD, EffectParam: 0x04}}
+		rows[1].Entries = []common.PatternEntry{{Channel: 0, Note: 63, Instrument: 1, Effect: effectS, EffectParam: 0xD3}}
+		rows[2].Entries = []common.PatternEntry{{Channel: 1, Effect: effectA, EffectParam: 4}}
+		rows[3].Entries = []common.PatternEntry{{Channel: 1, Effect: effectC, EffectParam: 2}}
+		return &common.Module{
+			InitialSpeed: 6,
+			InitialTempo: 125,
+			Channels:     2,
+			Order:        []int16{0, 0},
+			Patterns:     []common.Pattern{{Channels: 2, Rows: rows}},
+		}
+	}
+	duration := func(m *common.Module) float64 {
+		return render.NewStream(m).DurationSeconds()
+	}
+
+	// Doubling the rows.
+	m := newModule()
+	original := duration(m)
+	warnings, err := Requantize(m, 3, 125)
+	assert.NoError(t, err)
+	assert.Empty(t, warnings)
+	assert.EqualValues(t, 3, m.InitialSpeed)
+	rows := m.Patterns[0].Rows
+	assert.Len(t, rows, 16)
+	assert.Equal(t, common.PatternEntry{Note: 61, Instrument: 1, Effect: effectD, EffectParam: 0x04}, rows[0].Get(0))
+	assert.Equal(t, common.PatternEntry{Effect: effectD, EffectParam: 0x04}, rows[1].Get(0))
+	assert.Equal(t, common.PatternEntry{}, rows[2].Get(0))
+	assert.Equal(t, common.PatternEntry{Note: 63, Instrument: 1}, rows[3].Get(0))
+	assert.Equal(t, common.PatternEntry{Channel: 1, Effect: effectA, EffectParam: 2}, rows[4].Get(1))
+	assert.Equal(t, common.PatternEntry{Channel: 1, Effect: effectC, EffectParam: 4}, rows[7].Get(1))
+	assert.InDelta(t, original, duration(m), 1e-9)
+
+	// And merging them again.
+	warnings, err = Requantize(m, 6, 125)
+	assert.NoError(t, err)
+	assert.Empty(t, warnings)
+	assert.Equal(t, newModule(), m)
+
+	// Doubling the ticks.
+	m = newModule()
+	warnings, err = Requantize(m, 12, 250)
+	assert.NoError(t, err)
+	assert.Empty(t, warnings)
+	rows = m.Patterns[0].Rows
+	assert.Len(t, rows, 8)
+	assert.EqualValues(t, 0x02, rows[0].Get(0).EffectParam)
+	assert.EqualValues(t, 0xD6, rows[1].Get(0).EffectParam)
+	assert.EqualValues(t, 8, rows[2].Get(1).EffectParam)
+	assert.InDelta(t, original, duration(m), 1e-9)
+
+	// A slide that can't be halved exactly.
+	m = newModule()
+	m.Patterns[0].Rows[0].Entries[0].EffectParam = 0x03
+	warnings, err = Requantize(m, 12, 250)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"pattern 0 row 0 channel 1: slide rounded"}, warnings)
+
+	_, err = Requantize(newModule(), 5, 125)
+	assert.ErrorIs(t, err, ErrInvalidTiming)
+	_, err = Requantize(newModule(), 6, 300)
+	assert.ErrorIs(t, err, ErrInvalidTiming)
+}
+
+func TestReduceChannels(t *testing.T) {
+	rows := make([]common.PatternRow, 8)
+	rows[0].Entries = []common.PatternEntry{{Channel: 0, Note: 61, Instrument: 1}, {Channel: 1, Note: 65, Instrument: 1}}
+	rows[1].Entries = []common.PatternEntry{{Channel: 0, Note: 254}}
+	rows[2].Entries = []common.PatternEntry{{Channel: 2, Note: 63, Instrument: 1}}
+	rows[3].Entries = []common.PatternEntry{{Channel: 2, Note: 254}}
+	rows[4].Entries = []common.PatternEntry{{Channel: 3, Note: 68, Instrument: 1}}
+	m := &common.Module{
+		Channels:        4,
+		ChannelSettings: make([]common.ChannelSetting, 4),
+		Patterns:        []common.Pattern{{Channels: 4, Rows: rows}},
+	}
+
+	// Channels 3 and 4 fit after the note cut in channel 1.
+	warnings, err := ReduceChannels(m, 2)
+	assert.NoError(t, err)
+	assert.Empty(t, warnings)
+	assert.EqualValues(t, 2, m.Channels)
+	assert.Len(t, m.ChannelSettings, 2)
+	assert.EqualValues(t, 61, rows[0].Get(0).Note)
+	assert.EqualValues(t, 65, rows[0].Get(1).Note)
+	assert.EqualValues(t, 63, rows[2].Get(0).Note)
+	assert.EqualValues(t, 254, rows[3].Get(0).Note)
+	assert.EqualValues(t, 68, rows[4].Get(0).Note)
+
+	// Channel 2 can't be merged without dropping a note.
+	warnings, err = ReduceChannels(m, 1)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, m.Channels)
+	assert.Equal(t, []string{"pattern 0 row 0 channel 2: entry dropped"}, warnings)
+
+	_, err = ReduceChannels(m, 0)
+	assert.ErrorIs(t, err, ErrInvalidChannel)
+}
+
+func TestHumanizeQuantize(t *testing.T) {
+	newModule := func() *common.Module {
+		rows := make([]common.PatternRow, 16)
+		for i := range rows {
+			rows[i].Entries = []common.PatternEntry{{Channel: 0, Note: 61, Instrument: 1}}
+		}
+		rows[15].Entries[0].Effect, rows[15].Entries[0].EffectParam = effectA, 3
+		return &common.Module{
+			InitialSpeed: 6,
+			Channels:     1,
+			Order:        []int16{0},
+			Patterns:     []common.Pattern{{Channels: 1, Rows: rows}},
+		}
+	}
+
+	m := newModule()
+	count := Humanize(m, 8, 1)
+	assert.Greater(t, count, 0)
+	for _, row := range m.Patterns[0].Rows[:15] {
+		e := row.Get(0)
+		if e.Effect != 0 {
+			assert.EqualValues(t, effectS, e.Effect)
+			assert.LessOrEqual(t, e.EffectParam, uint8(0xD5))
+		}
+	}
+	assert.EqualValues(t, effectA, m.Patterns[0].Rows[15].Get(0).Effect)
+
+	again := newModule()
+	Humanize(again, 8, 1)
+	assert.Equal(t, m, again)
+
+	m = newModule()
+	rows := m.Patterns[0].Rows
+	rows[0].Entries = []common.PatternEntry{{Channel: 0, Note: 61, Instrument: 1, Effect: effectS, EffectParam: 0xD2}}
+	rows[1].Entries = []common.PatternEntry{{Channel: 0, Note: 62, Instrument: 1, Effect: effectS, EffectParam: 0xD4}}
+	rows[2].Entries = nil
+	assert.Equal(t, 2, Quantize(m))
+	assert.Equal(t, common.PatternEntry{Note: 61, Instrument: 1}, rows[0].Get(0))
+	assert.Nil(t, rows[1].Entries)
+	assert.Equal(t, common.PatternEntry{Note: 62, Instrument: 1}, rows[2].Get(0))
+}
+
+func TestBakeEffects(t *testing.T) {
+	rows := make([]common.PatternRow, 5)
+	rows[0].Entries = []common.PatternEntry{{Channel: 0, Note: 61, Instrument: 1, Effect: effectJ, EffectParam: 0x37}}
+	rows[1].Entries = []common.PatternEntry{{Channel: 0, Note: 65, Effect: effectQ, EffectParam: 0x01}}
+	rows[2].Entries = []common.PatternEntry{{Channel: 0, Effect: effectD, EffectParam: 0x04}}
+	rows[3].Entries = []common.PatternEntry{{Channel: 0, Note: 61, Effect: effectS, EffectParam: 0xD1}}
+	rows[4].Entries = []common.PatternEntry{{Channel: 0, Effect: effectS, EffectParam: 0xC2}}
+	m := &common.Module{
+		InitialSpeed: 3,
+		InitialTempo: 125,
+		Channels:     1,
+		Order:        []int16{0},
+		Patterns:     []common.Pattern{{Channels: 1, Rows: rows}},
+	}
+
+	warnings, err := BakeEffects(m)
+	assert.NoError(t, err)
+	assert.Empty(t, warnings)
+	assert.EqualValues(t, 1, m.InitialSpeed)
+
+	var notes []uint8
+	var effects []uint8
+	for _, row := range m.Patterns[0].Rows {
+		e := row.Get(0)
+		notes = append(notes, e.Note)
+		effects = append(effects, e.EffectParam)
+	}
+	assert.Equal(t, []uint8{61, 64, 68, 65, 65, 65, 0, 0, 0, 0, 61, 0, 0, 0, 254}, notes)
+	assert.Equal(t, []uint8{0, 0, 0, 0, 0, 0, 0, 0xF4, 0xF4, 0, 0, 0, 0, 0, 0}, effects)
+}
+
+func TestBakeArpeggioEnd(t *testing.T) {
+	bake := func(rows []common.PatternRow) ([]uint8, []string) {
+		m := &common.Module{
+			InitialSpeed: 3,
+			InitialTempo: 125,
+			Channels:     1,
+			Order:        []int16{0},
+			Patterns:     []common.Pattern{{Channels: 1, Rows: rows}},
+		}
+		warnings, err := BakeEffects(m)
+		assert.NoError(t, err)
+		var notes []uint8
+		for _, row := range m.Patterns[0].Rows {
+			notes = append(notes, row.Get(0).Note)
+		}
+		return notes, warnings
+	}
+
+	// The row after the arpeggio plays the base note again.
+	rows := make([]common.PatternRow, 2)
+	rows[0].Entries = []common.PatternEntry{{Channel: 0, Note: 60, Instrument: 1, Effect: effectJ, EffectParam: 0x47}}
+	notes, warnings := bake(rows)
+	assert.Equal(t, []uint8{60, 64, 67, 60, 0, 0}, notes)
+	assert.Empty(t, warnings)
+
+	// Other effects on the next row keep going, with the base note.
+	rows = make([]common.PatternRow, 2)
+	rows[0].Entries = []common.PatternEntry{{Channel: 0, Note: 60, Instrument: 1, Effect: effectJ, EffectParam: 0x47}}
+	rows[1].Entries = []common.PatternEntry{{Channel: 0, Effect: effectD, EffectParam: 0x04}}
+	notes, _ = bake(rows)
+	assert.Equal(t, []uint8{60, 64, 67, 60, 0, 0}, notes)
+
+	// J40 ends on the base note, so nothing is added.
+	rows = make([]common.PatternRow, 2)
+	rows[0].Entries = []common.PatternEntry{{Channel: 0, Note: 60, Instrument: 1, Effect: effectJ, EffectParam: 0x40}}
+	notes, _ = bake(rows)
+	assert.Equal(t, []uint8{60, 64, 60, 0, 0, 0}, notes)
+
+	// An arpeggio on the last row can't be fixed in the pattern.
+	rows = make([]common.PatternRow, 1)
+	rows[0].Entries = []common.PatternEntry{{Channel: 0, Note: 60, Instrument: 1, Effect: effectJ, EffectParam: 0x47}}
+	_, warnings = bake(rows)
+	assert.Equal(t, []string{"pattern 0 channel 1: arpeggio at the end of the pattern leaves a shifted note"}, warnings)
+}
+
+func TestBakeGain(t *testing.T) {
+	pcm := func() common.SampleData {
+		return common.SampleData{Channels: 1, Bits: 16, Data: []any{[]int16{16384, -16384}}}
+	}
+	m := &common.Module{
+		GlobalVolume:   64,
+		UseInstruments: true,
+		Samples: []common.Sample{
+			{GlobalVolume: 64, DefaultVolume: 64, Data: pcm()},
+			{GlobalVolume: 32, DefaultVolume: 64, Data: pcm()},
+			{GlobalVolume: 64, DefaultVolume: 64, Data: pcm()},
+		},
+		Instruments: make([]common.Instrument, 5),
+		Patterns: []common.Pattern{{Rows: []common.PatternRow{
+			{Entries: []common.PatternEntry{{Note: 61, Instrument: 1, VolumeCommand: common.VcmdSetVolume, VolumeParam: 32}}},
+		}}},
+	}
+	samples := []int16{1, 1, 2, 3, 3}
+	volumes := []int16{64, 64, 32, 100, 50}
+	for i := range m.Instruments {
+		m.Instruments[i].GlobalVolume = volumes[i]
+		m.Instruments[i].Notemap[60].Sample = samples[i]
+	}
+
+	warnings, err := BakeGain(m, GainOptions{SampleVolume: true, InstrumentVolume: true, GlobalVolume: true})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"instrument 4: global volume not baked, its samples are shared with instruments of other volumes"}, warnings)
+	assert.Equal(t, []int16{4096, -4096}, m.Samples[0].Data.Data[0])
+	assert.Equal(t, []int16{1024, -1024}, m.Samples[1].Data.Data[0])
+	assert.Equal(t, []int16{8192, -8192}, m.Samples[2].Data.Data[0])
+	assert.EqualValues(t, 64, m.Samples[1].GlobalVolume)
+	assert.EqualValues(t, 128, m.GlobalVolume)
+	for i, expected := range []int16{128, 128, 128, 100, 50} {
+		assert.EqualValues(t, expected, m.Instruments[i].GlobalVolume)
+	}
+
+	// The song sets volumes, and now changes the global volume.
+	m.Patterns[0].Rows[0].Entries[0].Effect = effectV
+	m.GlobalVolume = 64
+	warnings, err = BakeGain(m, GainOptions{DefaultVolume: true, GlobalVolume: true})
+	assert.NoError(t, err)
+	assert.Len(t, warnings, 2)
+	assert.EqualValues(t, 64, m.GlobalVolume)
+
+	// Streamed samples are loaded and scaled.
+	raw := []byte{0x00, 0x40, 0x00, 0xC0}
+	stream := &common.SampleStream{Source: bytes.NewReader(raw), Frames: 2, Channels: 1, Bits: 16}
+	m = &common.Module{GlobalVolume: 128, Samples: []common.Sample{{GlobalVolume: 32, S16: true, Stream: stream}}}
+	_, err = BakeGain(m, GainOptions{SampleVolume: true})
+	assert.NoError(t, err)
+	assert.Nil(t, m.Samples[0].Stream)
+	assert.Equal(t, []int16{8192, -8192}, m.Samples[0].Data.Data[0])
+
+	stream.Frames = 4
+	m.Samples[0] = common.Sample{GlobalVolume: 32, Stream: stream}
+	_, err = BakeGain(m, GainOptions{SampleVolume: true})
+	assert.ErrorIs(t, err, io.EOF)
+	assert.EqualValues(t, 32, m.Samples[0].GlobalVolume)
+}
@@ -0,0 +1,170 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package modtool
+
+import (
+	"fmt"
+	"math/bits"
+	"slices"
+
+	"go.mukunda.com/modlib/common"
+)
+
+// Merge channels until the module has at most target channels, e.g. 4 for exporting to
+// MOD. The least used channels are merged first, into channels whose notes never overlap
+// with theirs, so the song plays the same. A note is taken to sound until the next note in
+// its channel or the end of the pattern.
+//
+// When no clean merge is left, a channel is merged into the one it conflicts with least,
+// and its entries that land on busy rows are dropped. A warning is returned for each
+// dropped entry, and for each merge of channels with different initial volume or panning.
+// Merged channels also share their effect memory.
+func ReduceChannels(m *common.Module, target int) ([]string, error) {
+	if target < 1 {
+		return nil, fmt.Errorf("%w: target %d", ErrInvalidChannel, target)
+	}
+
+	var warnings []string
+	channels := int(m.Channels)
+	mapping := identityMapping(channels)
+	live := make([]bool, channels)
+	for i := range live {
+		live[i] = true
+	}
+
+	busy := make([][]uint64, channels)
+	usage := make([]int, channels)
+	for i := range busy {
+		busy[i] = busyRows(m, i)
+	}
+	for i := range m.Patterns {
+		for _, row := range m.Patterns[i].Rows {
+			for _, e := range row.Entries {
+				if int(e.Channel) < channels {
+					usage[e.Channel]++
+				}
+			}
+		}
+	}
+
+	for remaining := channels; remaining > target; remaining-- {
+		// Find the cheapest merge, preferring to move the least used channel.
+		from, into, cost := -1, -1, -1
+		for _, a := range liveChannels(live) {
+			for _, b := range liveChannels(live) {
+				if a == b {
+					continue
+				}
+				c := overlap(busy[a], busy[b])
+				if cost < 0 || c < cost || (c == cost && usage[a] < usage[from]) {
+					from, into, cost = a, b, c
+				}
+			}
+		}
+
+		warnings = append(warnings, mergeChannel(m, from, into, busy[into])...)
+		live[from] = false
+		mapping[from] = -1
+		busy[into] = busyRows(m, into)
+		usage[into] += usage[from]
+	}
+
+	next := 0
+	for i := range mapping {
+		if mapping[i] >= 0 {
+			mapping[i] = next
+			next++
+		}
+	}
+	if err := RemapChannels(m, mapping); err != nil {
+		return nil, err
+	}
+	return warnings, nil
+}
+
+func liveChannels(live []bool) []int {
+	var channels []int
+	for i, ok := range live {
+		if ok {
+			channels = append(channels, i)
+		}
+	}
+	return channels
+}
+
+// The rows where a channel is in use: rows with an entry, and rows where a note is still
+// sounding. The result is a bitset over the rows of all patterns in sequence.
+func busyRows(m *common.Module, channel int) []uint64 {
+	var busy []uint64
+	index := 0
+	for i := range m.Patterns {
+		sounding := false
+		for _, row := range m.Patterns[i].Rows {
+			if index/64 >= len(busy) {
+				busy = append(busy, 0)
+			}
+			e := row.Get(channel)
+			if e.Note > 0 && e.Note <= 120 {
+				sounding = true
+			}
+			if sounding || !e.IsEmpty() {
+				busy[index/64] |= 1 << (index % 64)
+			}
+			if e.Note > 120 {
+				sounding = false
+			}
+			index++
+		}
+	}
+	return busy
+}
+
+// Whether a row is set in a bitset from busyRows.
+func rowBusy(busy []uint64, index int) bool {
+	return busy[index/64]&(1<<(index%64)) != 0
+}
+
+// Count the rows where both channels are busy.
+func overlap(a, b []uint64) int {
+	count := 0
+	for i := range a {
+		count += bits.OnesCount64(a[i] & b[i])
+	}
+	return count
+}
+
+// Move the entries of one channel into another, dropping the ones that conflict.
+func mergeChannel(m *common.Module, from, into int, busy []uint64) []string {
+	var warnings []string
+	if from < len(m.ChannelSettings) && into < len(m.ChannelSettings) {
+		a, b := &m.ChannelSettings[from], &m.ChannelSettings[into]
+		if a.InitialVolume != b.InitialVolume || a.InitialPan != b.InitialPan || a.Surround != b.Surround {
+			warnings = append(warnings, fmt.Sprintf("channel %d merged into channel %d with different volume or panning", from+1, into+1))
+		}
+	}
+
+	index := 0
+	for i := range m.Patterns {
+		p := &m.Patterns[i]
+		for row := range p.Rows {
+			at := index
+			index++
+			e := p.Rows[row].Get(from)
+			if e.IsEmpty() {
+				continue
+			}
+			p.Rows[row].Entries = slices.DeleteFunc(p.Rows[row].Entries, func(e common.PatternEntry) bool {
+				return int(e.Channel) == from
+			})
+			if rowBusy(busy, at) {
+				warnings = append(warnings, fmt.Sprintf("pattern %d row %d channel %d: entry dropped", i, row, from+1))
+				continue
+			}
+			e.Channel = uint8(into)
+			p.Rows[row].Set(e)
+		}
+	}
+	return warnings
+}
@@ -0,0 +1,118 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+/*
+This package contains editing operations that change a whole module at once, keeping all of
+the references between its parts consistent.
+*/
+package modtool
+
+import (
+	"errors"
+	"fmt"
+
+	"go.mukunda.com/modlib/common"
+)
+
+// Returned when a remapping table is malformed.
+var ErrInvalidMapping = errors.New("invalid mapping")
+
+// Check a mapping and build the 1-based lookup used for references. mapping[i] is the new
+// zero-based index of item i, or -1 to remove it. Returns the new item count.
+func buildLookup(mapping []int, count int) ([]int16, int, error) {
+	if len(mapping) != count {
+		return nil, 0, fmt.Errorf("%w: %d entries for %d items", ErrInvalidMapping, len(mapping), count)
+	}
+
+	lookup := make([]int16, count+1)
+	used := make(map[int]bool)
+	newCount := 0
+	for i, target := range mapping {
+		if target < 0 {
+			continue
+		}
+		if used[target] {
+			return nil, 0, fmt.Errorf("%w: %d is mapped twice", ErrInvalidMapping, target)
+		}
+		used[target] = true
+		lookup[i+1] = int16(target + 1)
+		newCount = max(newCount, target+1)
+	}
+	return lookup, newCount, nil
+}
+
+// Translate a 1-based reference. References to missing or removed items become 0.
+func remapReference(lookup []int16, ref int16) int16 {
+	if ref < 1 || int(ref) >= len(lookup) {
+		return 0
+	}
+	return lookup[ref]
+}
+
+// Move samples to new positions. mapping[i] is the new zero-based index of sample i, or
+// -1 to remove it. Gaps in the new list are filled with empty samples. Instrument notemaps
+// are updated, and so are the pattern instrument columns when the module doesn't use
+// instruments.
+func RemapSamples(m *common.Module, mapping []int) error {
+	lookup, count, err := buildLookup(mapping, len(m.Samples))
+	if err != nil {
+		return err
+	}
+
+	samples := make([]common.Sample, count)
+	for i, target := range mapping {
+		if target >= 0 {
+			samples[target] = m.Samples[i]
+		}
+	}
+	m.Samples = samples
+
+	for i := range m.Instruments {
+		notemap := &m.Instruments[i].Notemap
+		for j := range notemap {
+			notemap[j].Sample = remapReference(lookup, notemap[j].Sample)
+		}
+	}
+
+	if !m.UseInstruments {
+		remapPatternInstruments(m, lookup)
+	}
+	return nil
+}
+
+// Move instruments to new positions. mapping[i] is the new zero-based index of instrument
+// i, or -1 to remove it. Gaps in the new list are filled with empty instruments. Pattern
+// instrument columns are updated when the module uses instruments.
+func RemapInstruments(m *common.Module, mapping []int) error {
+	lookup, count, err := buildLookup(mapping, len(m.Instruments))
+	if err != nil {
+		return err
+	}
+
+	instruments := make([]common.Instrument, count)
+	for i, target := range mapping {
+		if target >= 0 {
+			instruments[target] = m.Instruments[i]
+		}
+	}
+	m.Instruments = instruments
+
+	if m.UseInstruments {
+		remapPatternInstruments(m, lookup)
+	}
+	return nil
+}
+
+func remapPatternInstruments(m *common.Module, lookup []int16) {
+	for i := range m.Patterns {
+		for j := range m.Patterns[i].Rows {
+			entries := m.Patterns[i].Rows[j].Entries
+			for k := range entries {
+				if entries[k].Instrument != 0 {
+					entries[k].Instrument = remapReference(lookup, entries[k].Instrument)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,474 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package modtool
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"go.mukunda.com/modlib/common"
+)
+
+// Returned when a module can't be converted to the requested speed and tempo.
+var ErrInvalidTiming = errors.New("invalid timing")
+
+// Change the initial speed and tempo of a module while keeping the time per row. Ticks
+// follow the new tempo, and if that makes the new row a different length, the patterns
+// are expanded or shrunk to match: speed 6/tempo 125 can become speed 3/tempo 125 by
+// doubling the rows, or speed 12/tempo 250 by doubling the ticks. The old row must be a
+// whole multiple or fraction of the new one.
+//
+// Effects are rewritten to keep their timing: speed and tempo changes, note delays and
+// cuts, slide rates, pattern breaks and row delays. Continuous effects like slides and
+// vibrato are repeated on the rows that expanding inserts. Per-tick effects can't always
+// be converted exactly, so a warning is returned for each change that alters playback.
+func Requantize(m *common.Module, speed, tempo int) ([]string, error) {
+	if speed < 1 || speed > 255 || tempo < 32 || tempo > 255 {
+		return nil, fmt.Errorf("%w: speed %d tempo %d", ErrInvalidTiming, speed, tempo)
+	}
+
+	oldSpeed := max(int(m.InitialSpeed), 1)
+	oldTempo := max(int(m.InitialTempo), 32)
+	q := &requantizer{
+		m:        m,
+		oldTempo: oldTempo,
+		newTempo: tempo,
+		expand:   1,
+		shrink:   1,
+		speeds:   rowSpeeds(m),
+	}
+
+	// Rows of the new timing in one old row.
+	num, den := oldSpeed*tempo, oldTempo*speed
+	switch {
+	case num%den == 0:
+		q.expand = num / den
+	case den%num == 0:
+		q.shrink = den / num
+	default:
+		return nil, fmt.Errorf("%w: speed %d tempo %d doesn't divide rows of speed %d tempo %d",
+			ErrInvalidTiming, speed, tempo, oldSpeed, oldTempo)
+	}
+
+	for i := range m.Patterns {
+		q.pattern = i
+		if q.shrink > 1 {
+			q.shrinkPattern(&m.Patterns[i])
+		} else {
+			q.expandPattern(&m.Patterns[i])
+		}
+	}
+
+	m.InitialSpeed = int16(speed)
+	m.InitialTempo = int16(tempo)
+	m.PatternHighlight_Beat = q.scaleRows(m.PatternHighlight_Beat)
+	m.PatternHighlight_Measure = q.scaleRows(m.PatternHighlight_Measure)
+	return q.warnings, nil
+}
+
+type requantizer struct {
+	m        *common.Module
+	oldTempo int
+	newTempo int
+	expand   int // New rows for each old row.
+	shrink   int // Old rows merged into each new row.
+	speeds   map[int][]int
+
+	// Location of the entry being converted, for warnings.
+	pattern int
+	row     int
+	channel int
+
+	warnings []string
+}
+
+// Find the speed of each pattern row by following the order list once, ignoring jumps.
+// Patterns keep the speeds from the first time they're played.
+func rowSpeeds(m *common.Module) map[int][]int {
+	speeds := make(map[int][]int)
+	speed := max(int(m.InitialSpeed), 1)
+	for _, order := range m.Order {
+		pattern := int(order)
+		if pattern >= len(m.Patterns) || pattern >= common.OrderSkip {
+			continue
+		}
+		_, seen := speeds[pattern]
+		rows := make([]int, len(m.Patterns[pattern].Rows))
+		for i, row := range m.Patterns[pattern].Rows {
+			for _, e := range row.Entries {
+				if e.Effect == effectA && e.EffectParam > 0 {
+					speed = int(e.EffectParam)
+				}
+			}
+			rows[i] = speed
+		}
+		if !seen {
+			speeds[pattern] = rows
+		}
+	}
+	return speeds
+}
+
+// The speed that a row of the current pattern plays at.
+func (q *requantizer) speedAt(row int) int {
+	if speeds, ok := q.speeds[q.pattern]; ok && row < len(speeds) {
+		return speeds[row]
+	}
+	return max(int(q.m.InitialSpeed), 1)
+}
+
+func (q *requantizer) warn(format string, args ...any) {
+	where := fmt.Sprintf("pattern %d row %d channel %d: ", q.pattern, q.row, q.channel+1)
+	q.warnings = append(q.warnings, where+fmt.Sprintf(format, args...))
+}
+
+// Multiply by num/den, rounding and warning if the result isn't exact.
+func (q *requantizer) scale(x, num, den int, what string) int {
+	if x*num%den != 0 {
+		q.warn("%s rounded", what)
+	}
+	return int(math.Round(float64(x*num) / float64(den)))
+}
+
+// Convert a number of ticks to the new tick length.
+func (q *requantizer) ticks(x int, what string) int {
+	return q.scale(x, q.newTempo, q.oldTempo, what)
+}
+
+// Convert a per-tick rate to the new tick length. Rates don't round down to 0, which would
+// recall the effect memory.
+func (q *requantizer) rate(x int, what string) int {
+	if x == 0 {
+		return 0
+	}
+	return max(q.scale(x, q.oldTempo, q.newTempo, what), 1)
+}
+
+// Keep a value in range, warning if it doesn't fit.
+func (q *requantizer) clamp(x, low, high int, what string) int {
+	if x < low || x > high {
+		q.warn("%s out of range", what)
+	}
+	return min(max(x, low), high)
+}
+
+// Convert a number of rows, like a highlight interval.
+func (q *requantizer) scaleRows(rows int16) int16 {
+	if rows <= 0 {
+		return rows
+	}
+	return int16(max(int(rows)*q.expand/q.shrink, 1))
+}
+
+// The length of a new row in ticks, for an old row played at the given speed.
+func (q *requantizer) newRowTicks(speed int) int {
+	ticks := float64(speed*q.newTempo*q.shrink) / float64(q.oldTempo*q.expand)
+	return max(int(math.Round(ticks)), 1)
+}
+
+// True if the effect runs on every tick of a row, so it has to be repeated on rows that
+// are inserted after it.
+func continuousEffect(e common.PatternEntry) bool {
+	switch e.Effect {
+	case effectD, effectK, effectL, effectN, effectP, effectW:
+		return !fineVolumeSlide(e.EffectParam)
+	case effectE, effectF:
+		return !finePortamento(e.EffectParam)
+	case effectG, effectH, effectI, effectJ, effectQ, effectR, effectU, effectY:
+		return true
+	case effectT:
+		return e.EffectParam < 0x20
+	}
+	return false
+}
+
+// True if the volume column command runs on every tick of a row.
+func continuousVolume(e common.PatternEntry) bool {
+	switch e.VolumeCommand {
+	case common.VcmdVolSlideUp, common.VcmdVolSlideDown, common.VcmdPitchSlideDown,
+		common.VcmdPitchSlideUp, common.VcmdPortaToNote, common.VcmdVibratoDepth:
+		return true
+	}
+	return false
+}
+
+// True if the effect affects the whole song rather than its channel, so it can be moved to
+// another channel if its own is taken.
+func globalEffect(e common.PatternEntry) bool {
+	switch e.Effect {
+	case effectA, effectB, effectC, effectT, effectV, effectW:
+		return true
+	case effectS:
+		return e.EffectParam>>4 == effectSRowDelay || e.EffectParam>>4 == effectSTickDelay
+	}
+	return false
+}
+
+// Convert the rates and tick counts of an entry's effect and volume command. Effects that
+// depend on the row layout are left for the caller.
+func (q *requantizer) convertEntry(e *common.PatternEntry) {
+	q.convertVolume(e)
+
+	param := int(e.EffectParam)
+	x, y := param>>4, param&15
+	switch e.Effect {
+	case effectA:
+		if param > 0 {
+			speed := q.scale(param, q.newTempo*q.shrink, q.oldTempo*q.expand, "speed")
+			param = q.clamp(speed, 1, 255, "speed")
+		}
+	case effectT:
+		if param >= 0x20 {
+			param = q.clamp(q.ticks(param, "tempo"), 0x20, 255, "tempo")
+		}
+	case effectD, effectK, effectL, effectN, effectP, effectW:
+		if !fineVolumeSlide(e.EffectParam) {
+			x = q.clamp(q.rate(x, "slide"), 0, 15, "slide")
+			y = q.clamp(q.rate(y, "slide"), 0, 15, "slide")
+			param = x<<4 | y
+		}
+	case effectE, effectF:
+		if !finePortamento(e.EffectParam) {
+			param = q.clamp(q.rate(param, "portamento"), 0, 0xDF, "portamento")
+		}
+	case effectG:
+		param = q.clamp(q.rate(param, "portamento"), 0, 255, "portamento")
+	case effectH, effectU, effectR, effectY:
+		x = q.clamp(q.rate(x, "speed"), 0, 15, "speed")
+		param = x<<4 | y
+	case effectI:
+		x = q.clamp(q.ticks(x, "tremor"), 0, 15, "tremor")
+		y = q.clamp(q.ticks(y, "tremor"), 0, 15, "tremor")
+		param = x<<4 | y
+	case effectJ:
+		if q.newTempo != q.oldTempo {
+			q.warn("arpeggio speed changed")
+		}
+	case effectQ:
+		y = q.clamp(q.ticks(y, "retrigger"), 1, 15, "retrigger")
+		param = x<<4 | y
+	case effectS:
+		if x == effectSTickDelay {
+			param = x<<4 | q.clamp(q.ticks(y, "tick delay"), 0, 15, "tick delay")
+		}
+	}
+	e.EffectParam = uint8(param)
+}
+
+func (q *requantizer) convertVolume(e *common.PatternEntry) {
+	param := int(e.VolumeParam)
+	switch e.VolumeCommand {
+	case common.VcmdVolSlideUp, common.VcmdVolSlideDown, common.VcmdPitchSlideDown,
+		common.VcmdPitchSlideUp:
+		param = q.clamp(q.rate(param, "volume column slide"), 0, 9, "volume column slide")
+	case common.VcmdPortaToNote:
+		table := common.VolumeColumnPortaTable
+		if param > 0 && param < len(table) {
+			speed := q.rate(int(table[param]), "volume column portamento")
+			nearest := 1
+			for i := 2; i < len(table); i++ {
+				if abs(int(table[i])-speed) < abs(int(table[nearest])-speed) {
+					nearest = i
+				}
+			}
+			if int(table[nearest]) != speed {
+				q.warn("volume column portamento rounded")
+			}
+			param = nearest
+		}
+	}
+	e.VolumeParam = uint8(param)
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// Put an effect into a row. If the channel already has an effect, a global effect goes to
+// another channel with a free effect column; anything else is dropped.
+func (q *requantizer) placeEffect(row *common.PatternRow, channel uint8, effect, param uint8, global bool) {
+	channels := []int{int(channel)}
+	if global {
+		for c := range max(int(q.m.Channels), int(channel)+1) {
+			if c != int(channel) {
+				channels = append(channels, c)
+			}
+		}
+	}
+	for _, c := range channels {
+		dest := row.Get(c)
+		if dest.Effect == 0 && dest.EffectParam == 0 {
+			dest.Effect, dest.EffectParam = effect, param
+			row.Set(dest)
+			return
+		}
+	}
+	q.warn("effect %c%02X dropped", 'A'+effect-1, param)
+}
+
+// Move a tick position into the row that contains it. Returns the row offset in the group
+// and the tick within that row.
+func (q *requantizer) splitTick(tick, rowTicks int) (int, int) {
+	offset := min(tick/rowTicks, q.expand-1)
+	return offset, tick - offset*rowTicks
+}
+
+// An effect moved to another row of the group, placed after the row's own entries.
+type movedEffect struct {
+	row     int
+	channel uint8
+	effect  uint8
+	param   uint8
+	global  bool
+}
+
+// Spread each row over q.expand rows.
+func (q *requantizer) expandPattern(p *common.Pattern) {
+	rows := make([]common.PatternRow, len(p.Rows)*q.expand)
+	for i, row := range p.Rows {
+		q.row = i
+		rowTicks := q.newRowTicks(q.speedAt(i))
+		group := rows[i*q.expand : (i+1)*q.expand]
+		last := len(group) - 1
+
+		var moved []movedEffect
+		for _, e := range row.Entries {
+			q.channel = int(e.Channel)
+			q.convertEntry(&e)
+
+			first := 0
+			x := int(e.EffectParam & 15)
+			move := func(row int, param int, global bool) {
+				moved = append(moved, movedEffect{row, e.Channel, e.Effect, uint8(param), global})
+				e.Effect, e.EffectParam = 0, 0
+			}
+			switch {
+			case e.Effect == effectB:
+				move(last, int(e.EffectParam), true)
+			case e.Effect == effectC:
+				move(last, q.clamp(int(e.EffectParam)*q.expand, 0, 255, "break row"), true)
+			case e.Effect == effectS && e.EffectParam>>4 == effectSLoop && x > 0:
+				move(last, int(e.EffectParam), false)
+			case e.Effect == effectS && e.EffectParam>>4 == effectSRowDelay:
+				// The delay repeats the last row, which is 1/expand of the old row.
+				move(last, effectSRowDelay<<4|q.clamp(x*q.expand, 0, 15, "row delay"), true)
+			case e.Effect == effectS && e.EffectParam>>4 == effectSNoteCut:
+				offset, tick := q.splitTick(q.ticks(x, "note cut"), rowTicks)
+				move(offset, effectSNoteCut<<4|q.clamp(tick, 0, 15, "note cut"), false)
+			case e.Effect == effectS && e.EffectParam>>4 == effectSNoteDelay:
+				offset, tick := q.splitTick(q.ticks(x, "note delay"), rowTicks)
+				e.EffectParam = uint8(effectSNoteDelay<<4 | q.clamp(tick, 0, 15, "note delay"))
+				if tick == 0 {
+					e.Effect, e.EffectParam = 0, 0
+				}
+				first = offset
+			}
+
+			group[first].Set(e)
+			for k := first + 1; k < q.expand; k++ {
+				repeat := common.PatternEntry{Channel: e.Channel}
+				if continuousVolume(e) {
+					repeat.VolumeCommand, repeat.VolumeParam = e.VolumeCommand, e.VolumeParam
+				}
+				if continuousEffect(e) {
+					repeat.Effect, repeat.EffectParam = e.Effect, e.EffectParam
+				}
+				group[k].Set(repeat)
+			}
+		}
+
+		for _, m := range moved {
+			q.placeEffect(&group[m.row], m.channel, m.effect, m.param, m.global)
+		}
+	}
+	p.Rows = rows
+	p.RowsPerBeat = q.scaleRows(p.RowsPerBeat)
+	p.RowsPerMeasure = q.scaleRows(p.RowsPerMeasure)
+}
+
+// Merge each q.shrink rows into one. Notes from the merged rows are delayed with SDx to
+// keep their timing.
+func (q *requantizer) shrinkPattern(p *common.Pattern) {
+	rows := make([]common.PatternRow, (len(p.Rows)+q.shrink-1)/q.shrink)
+	for g := range rows {
+		dest := &rows[g]
+		offset := 0 // Start of the old row in the new row, in new ticks.
+		for k := 0; k < q.shrink && g*q.shrink+k < len(p.Rows); k++ {
+			q.row = g*q.shrink + k
+			for _, e := range p.Rows[q.row].Entries {
+				q.channel = int(e.Channel)
+				q.convertEntry(&e)
+				q.mergeShrunk(dest, e, offset)
+			}
+			offset += q.ticks(q.speedAt(q.row), "row length")
+		}
+	}
+	p.Rows = rows
+	p.RowsPerBeat = q.scaleRows(p.RowsPerBeat)
+	p.RowsPerMeasure = q.scaleRows(p.RowsPerMeasure)
+}
+
+// Add an entry from an old row that starts offset ticks into the new row.
+func (q *requantizer) mergeShrunk(dest *common.PatternRow, e common.PatternEntry, offset int) {
+	// Continuous effects repeated from the start of the row, as written by expanding, are
+	// already covered.
+	cell := dest.Get(int(e.Channel))
+	if e.Note == 0 && e.Instrument == 0 && continuousVolume(e) &&
+		e.VolumeCommand == cell.VolumeCommand && e.VolumeParam == cell.VolumeParam {
+		e.VolumeCommand, e.VolumeParam = 0, 0
+	}
+	if continuousEffect(e) && e.Effect == cell.Effect && e.EffectParam == cell.EffectParam {
+		e.Effect, e.EffectParam = 0, 0
+	}
+
+	x := int(e.EffectParam & 15)
+	delay := offset
+	switch {
+	case e.Effect == effectC:
+		if int(e.EffectParam)%q.shrink != 0 {
+			q.warn("break row rounded")
+		}
+		e.EffectParam /= uint8(q.shrink)
+	case e.Effect == effectS && e.EffectParam>>4 == effectSRowDelay:
+		repeats := q.scale(x, 1, q.shrink, "row delay")
+		e.EffectParam = uint8(effectSRowDelay<<4 | repeats)
+	case e.Effect == effectS && e.EffectParam>>4 == effectSNoteCut:
+		tick := q.clamp(offset+q.ticks(x, "note cut"), 0, 15, "note cut")
+		e.EffectParam = uint8(effectSNoteCut<<4 | tick)
+	case e.Effect == effectS && e.EffectParam>>4 == effectSNoteDelay:
+		delay += q.ticks(x, "note delay")
+		e.Effect, e.EffectParam = 0, 0
+	case e.Effect == effectS && e.EffectParam>>4 == effectSLoop && x == 0 && offset > 0:
+		q.warn("loop start moved to the start of the row")
+	case e.Effect != 0 && offset > 0 && e.Effect != effectB && !(e.Effect == effectS &&
+		e.EffectParam>>4 == effectSLoop):
+		q.warn("effect moved to the start of the row")
+	}
+
+	if e.Note != 0 || e.Instrument != 0 || e.VolumeCommand != 0 {
+		free := cell.Note == 0 && cell.Instrument == 0 && cell.VolumeCommand == 0
+		switch {
+		case !free:
+			q.warn("note dropped")
+		case delay > 0 && (cell.Effect != 0 || cell.EffectParam != 0 || delay > 15):
+			q.warn("note delay doesn't fit, note dropped")
+		default:
+			cell.Note, cell.Instrument = e.Note, e.Instrument
+			cell.VolumeCommand, cell.VolumeParam = e.VolumeCommand, e.VolumeParam
+			if delay > 0 {
+				cell.Effect, cell.EffectParam = effectS, uint8(effectSNoteDelay<<4|delay)
+			}
+		}
+		dest.Set(cell)
+	}
+
+	if e.Effect != 0 || e.EffectParam != 0 {
+		q.placeEffect(dest, e.Channel, e.Effect, e.EffectParam, globalEffect(e))
+	}
+}
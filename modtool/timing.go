@@ -0,0 +1,98 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package modtool
+
+import "go.mukunda.com/modlib/common"
+
+// Delay notes by a random number of ticks, 0 to maxTicks, with SDx so the song sounds less
+// mechanical. Only notes with an empty effect column are changed, and delays are kept
+// shorter than the row, using the speed that the row plays at. The same seed gives the
+// same result. Returns the number of notes that were delayed.
+func Humanize(m *common.Module, maxTicks int, seed uint32) int {
+	maxTicks = min(maxTicks, 15)
+	if maxTicks <= 0 {
+		return 0
+	}
+
+	speeds := rowSpeeds(m)
+	random := seed
+	count := 0
+	for i := range m.Patterns {
+		p := &m.Patterns[i]
+		for row := range p.Rows {
+			speed := max(int(m.InitialSpeed), 1)
+			if s, ok := speeds[i]; ok {
+				speed = s[row]
+			}
+			limit := min(maxTicks, speed-1)
+
+			for j := range p.Rows[row].Entries {
+				e := &p.Rows[row].Entries[j]
+				if e.Note == 0 || e.Note > 120 || e.Effect != 0 || e.EffectParam != 0 {
+					continue
+				}
+				random = random*1103515245 + 12345
+				delay := int(random>>16) % (limit + 1)
+				if delay > 0 {
+					e.Effect, e.EffectParam = effectS, uint8(effectSNoteDelay<<4|delay)
+					count++
+				}
+			}
+		}
+	}
+	return count
+}
+
+// Remove SDx note delays, snapping notes to the nearest row. Notes delayed by at least half
+// of the row move to the next row if their channel is free there; otherwise they play at
+// the start of their own row. Returns the number of notes that were changed.
+func Quantize(m *common.Module) int {
+	speeds := rowSpeeds(m)
+	count := 0
+	for i := range m.Patterns {
+		p := &m.Patterns[i]
+		for row := range p.Rows {
+			speed := max(int(m.InitialSpeed), 1)
+			if s, ok := speeds[i]; ok {
+				speed = s[row]
+			}
+
+			for j := range p.Rows[row].Entries {
+				e := &p.Rows[row].Entries[j]
+				if e.Effect != effectS || e.EffectParam>>4 != effectSNoteDelay {
+					continue
+				}
+				delay := int(e.EffectParam & 15)
+				e.Effect, e.EffectParam = 0, 0
+				count++
+
+				if delay*2 < speed || row+1 >= len(p.Rows) {
+					continue
+				}
+				next := p.Rows[row+1].Get(int(e.Channel))
+				if next.Note != 0 || next.Instrument != 0 || next.VolumeCommand != 0 {
+					continue
+				}
+				next.Note, next.Instrument = e.Note, e.Instrument
+				next.VolumeCommand, next.VolumeParam = e.VolumeCommand, e.VolumeParam
+				p.Rows[row+1].Set(next)
+				e.Note, e.Instrument, e.VolumeCommand, e.VolumeParam = 0, 0, 0, 0
+			}
+			p.Rows[row].Entries = removeEmpty(p.Rows[row].Entries)
+		}
+	}
+	return count
+}
+
+// Remove empty entries from a row.
+func removeEmpty(entries []common.PatternEntry) []common.PatternEntry {
+	var result []common.PatternEntry
+	for _, e := range entries {
+		if !e.IsEmpty() {
+			result = append(result, e)
+		}
+	}
+	return result
+}
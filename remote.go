@@ -0,0 +1,56 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package modlib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Returned when a module can't be fetched from a URL.
+var ErrFetchFailed = errors.New("failed to fetch module")
+
+// Load a module from a stream that can't seek, such as a network response. size is the
+// expected length, or -1 if unknown. The stream is buffered in memory, up to
+// MaxModuleSize. Zip and gzip archives are unpacked.
+func LoadModuleFromReader(r io.Reader, size int64) (*Module, error) {
+	return LoadModuleFromReaderWithOptions(r, size, rawOptions)
+}
+
+// Load a module from a stream that can't seek, with options.
+func LoadModuleFromReaderWithOptions(r io.Reader, size int64, options LoadOptions) (*Module, error) {
+	if size > MaxModuleSize {
+		return nil, ErrModuleTooLarge
+	}
+
+	data, err := readLimited(r)
+	if err != nil {
+		return nil, err
+	}
+	return loadFromMemory(data, options)
+}
+
+// Download and load a module with http.DefaultClient.
+func LoadModuleFromURL(ctx context.Context, url string) (*Module, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %s", ErrFetchFailed, resp.Status)
+	}
+
+	return LoadModuleFromReader(resp.Body, resp.ContentLength)
+}
@@ -0,0 +1,48 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package render
+
+import "go.mukunda.com/modlib/common"
+
+// Steps in one cycle of the sample vibrato waveform.
+const autoVibratoCycle = 256
+
+// Sample auto-vibrato state of a voice.
+type autoVibrato struct {
+	position    int    // 0-255
+	depth       int    // Current depth in 1/256 units, ramped up by the sweep.
+	randomState uint32 // For the random waveform.
+}
+
+// The sample vibrato waveform at the current position, -64 to 64.
+func (av *autoVibrato) wave(waveform int16) int {
+	pos := av.position & (autoVibratoCycle - 1)
+	switch waveform {
+	case common.SampleVibratoWaveformRamp:
+		return 64 - pos/2
+	case common.SampleVibratoWaveformSquare:
+		if pos < autoVibratoCycle/2 {
+			return 64
+		}
+		return -64
+	case common.SampleVibratoWaveformRandom:
+		av.randomState = av.randomState*1103515245 + 12345
+		return int(av.randomState>>16)%129 - 64
+	}
+	return int(sineTable[pos/4])
+}
+
+// Advance the vibrato by one tick and return the pitch change in 1/64 semitone units. Like
+// IT, the depth starts at 0 and rises by VibratoSweep/256 each tick until it reaches
+// VibratoDepth; at full depth 64 the pitch swings by a semitone either way.
+func (av *autoVibrato) tick(s *common.Sample) float64 {
+	if s.VibratoDepth == 0 {
+		return 0
+	}
+	av.depth = min(av.depth+int(s.VibratoSweep), int(s.VibratoDepth)*256)
+	delta := av.wave(s.VibratoWaveform) * av.depth / (256 * 64)
+	av.position += int(s.VibratoSpeed)
+	return float64(delta)
+}
@@ -0,0 +1,163 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package render
+
+import "go.mukunda.com/modlib/common"
+
+// Effect behavior that differs between trackers. The effect engine follows these flags
+// instead of reading the module directly, so the same code plays IT, XM and ProTracker
+// semantics, and the renderer, Ticks, MidiEvents and Seek all step through the song the
+// same way.
+type EffectBehavior struct {
+	// Pitch slides in 1/64 semitone steps instead of Amiga periods.
+	LinearSlides bool
+
+	// IT's "old effects": vibrato is twice as deep and starts on the tick after the note,
+	// tremor lengths aren't incremented, and Oxx past the end of a sample plays its last
+	// frame instead of being ignored.
+	OldEffects bool
+
+	// G shares its memory with E and F.
+	LinkEFG bool
+
+	// Slides with a 00 parameter (D, K, L, E, F, N, P, W) continue with the last nonzero
+	// parameter. ProTracker has no memory for these; 00 stops the slide.
+	SlideMemory bool
+
+	// E and F share one parameter memory, as in IT and S3M. XM remembers them separately.
+	SharedPitchSlideMemory bool
+
+	// Volume slides also apply on the first tick of the row, like Scream Tracker 3.00 and
+	// S3Ms with the fast volume slides flag.
+	FastVolumeSlides bool
+
+	// Amiga slides stop at ProTracker's period range, B-3 to C-1 in its octave numbering.
+	AmigaPeriodLimits bool
+
+	// Oxx past the end of the sample stops the note, as in FT2 and ST3.
+	OffsetPastEndStops bool
+
+	// One pattern loop start and count for all channels, as in ST3. Other trackers keep
+	// them per channel.
+	SharedPatternLoop bool
+
+	// The pattern loop start stays where it is after a loop finishes and when the pattern
+	// changes, as in ProTracker and FT2. IT and ST3 move it to the row after the loop, and
+	// back to the first row in a new pattern.
+	KeepLoopStart bool
+
+	// Each repeat of a delayed row (SEx) runs the row's first-tick effects again and
+	// replays delayed notes, as in IT and ST3. Otherwise the repeats only continue the
+	// effects of the other ticks, as in ProTracker and FT2.
+	RowDelayRepeatsRow bool
+}
+
+// Playback compatibility profiles, which emulate the quirks of the tracker that a module
+// was written with.
+type Compatibility int
+
+const (
+	// IT semantics with the module's flags. This is the default.
+	CompatDefault Compatibility = iota
+
+	// ProTracker 1.x to 3.x. Their playback differs in details that the player doesn't
+	// model, so they share one profile: Amiga slides within the period limits, and no
+	// memory for slides.
+	CompatProTracker
+
+	// Scream Tracker 3, with and without fast volume slides.
+	CompatScreamTracker3
+	CompatScreamTracker3FastSlides
+
+	// FastTracker 2: separate memory for E and F, and the module's slide mode.
+	CompatFastTracker2
+)
+
+// The effect behavior of a profile for a module.
+func (c Compatibility) Behavior(m *common.Module) EffectBehavior {
+	b := DefaultEffectBehavior(m)
+	switch c {
+	case CompatProTracker:
+		b = EffectBehavior{OldEffects: true, AmigaPeriodLimits: true, KeepLoopStart: true}
+	case CompatScreamTracker3, CompatScreamTracker3FastSlides:
+		b = EffectBehavior{
+			OldEffects:             true,
+			SlideMemory:            true,
+			SharedPitchSlideMemory: true,
+			FastVolumeSlides:       c == CompatScreamTracker3FastSlides,
+			OffsetPastEndStops:     true,
+			SharedPatternLoop:      true,
+			RowDelayRepeatsRow:     true,
+		}
+	case CompatFastTracker2:
+		b = EffectBehavior{
+			LinearSlides:       m.LinearSlides,
+			OldEffects:         true,
+			SlideMemory:        true,
+			OffsetPastEndStops: true,
+			KeepLoopStart:      true,
+		}
+	}
+	return b
+}
+
+// Use a compatibility profile. This resets the player to the start of the song.
+func (p *Player) SetCompatibility(c Compatibility) {
+	p.SetEffectBehavior(c.Behavior(p.module))
+}
+
+// IT behavior with the module's flags, which is what the player uses by default. S3Ms
+// that ask for fast volume slides get them, and S3Ms share one pattern loop between
+// channels.
+func DefaultEffectBehavior(m *common.Module) EffectBehavior {
+	b := EffectBehavior{
+		LinearSlides:           m.LinearSlides,
+		OldEffects:             m.OldEffects,
+		LinkEFG:                m.LinkEFG,
+		SlideMemory:            true,
+		SharedPitchSlideMemory: true,
+		RowDelayRepeatsRow:     true,
+	}
+	if d, ok := m.Details.(*common.S3mDetails); ok {
+		b.FastVolumeSlides = d.Flags&s3mFastVolumeSlides != 0 || d.Cwtv == s3mVersion300
+		b.SharedPatternLoop = true
+	}
+	return b
+}
+
+// From the S3M header: the fast volume slides flag, and Scream Tracker 3.00, which always
+// used fast slides.
+const (
+	s3mFastVolumeSlides = 64
+	s3mVersion300       = 0x1300
+)
+
+// Change the effect behavior. This resets the player to the start of the song.
+func (p *Player) SetEffectBehavior(behavior EffectBehavior) {
+	p.behavior = behavior
+	p.reset()
+}
+
+// The effect behavior in use.
+func (p *Player) EffectBehavior() EffectBehavior {
+	return p.behavior
+}
+
+// Remember a slide parameter, following SlideMemory.
+func (p *Player) rememberSlide(mem *uint8, param uint8) uint8 {
+	if !p.behavior.SlideMemory {
+		*mem = param
+		return param
+	}
+	return remember(mem, param)
+}
+
+// The memory slot of an E (down) or F (up) slide.
+func (p *Player) pitchSlideMemory(ch *channel, up bool) *uint8 {
+	if up && !p.behavior.SharedPitchSlideMemory {
+		return &ch.mem.pitchSlideUp
+	}
+	return &ch.mem.pitchSlide
+}
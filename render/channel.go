@@ -0,0 +1,341 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package render
+
+import (
+	"math"
+
+	"go.mukunda.com/modlib/common"
+)
+
+// Pattern note values with special meaning.
+const (
+	noteFade = common.NoteFade
+	noteCut  = common.NoteCut
+	noteOff  = common.NoteOff
+)
+
+// Effect parameter memory. IT remembers the last nonzero parameter of most effects per
+// channel.
+type effectMemory struct {
+	volumeSlide        uint8 // D, K, L
+	pitchSlide         uint8 // E, and F when shared
+	pitchSlideUp       uint8 // F when E and F are remembered separately
+	portamento         uint8 // G
+	vibrato            uint8 // H, U
+	tremolo            uint8 // R
+	offset             uint8 // O
+	tremor             uint8 // I
+	arpeggio           uint8 // J
+	channelVolumeSlide uint8 // N
+	panSlide           uint8 // P
+	retrigger          uint8 // Q
+	globalVolumeSlide  uint8 // W
+	panbrello          uint8 // Y
+	tempo              uint8 // T
+	special            uint8 // S
+	volumeColumn       uint8 // Volume column slides
+}
+
+// Pattern loop (SBx) state.
+type patternLoop struct {
+	row   int // Loop start (SB0)
+	count int // Remaining loop iterations, -1 = not looping
+}
+
+// Playback state of a tracker channel.
+type channel struct {
+	index int
+	voice voice
+
+	// Current row data.
+	entry common.PatternEntry
+
+	instrumentNumber int // Last instrument column value (1-based)
+	instrument       *common.Instrument
+	sample           *common.Sample
+	note             int // Note being played, after the notemap (0-119)
+
+	volume        int // 0-64
+	channelVolume int // 0-64
+	pan           int // 0-64
+	surround      bool
+	muted         bool
+
+	frequency   float64 // Playback rate after slides.
+	portaTarget float64 // Target rate for tone portamento.
+
+	// Temporary modifiers from vibrato, tremolo, etc., reset each tick.
+	volumeDelta     int
+	panDelta        int
+	frequencyFactor float64
+	tremorMute      bool
+
+	mem effectMemory
+
+	vibratoPos    int
+	tremoloPos    int
+	panbrelloPos  int
+	vibratoWave   int
+	tremoloWave   int
+	panbrelloWave int
+	tremorCount   int
+	retrigCount   int
+
+	noteDelay  int // Ticks until a delayed note is played (SDx), -1 = none
+	noteCut    int // Tick to cut the note (SCx), -1 = none
+	highOffset int // SAx
+
+	loop patternLoop
+
+	activeMacro int // SFx
+	midi        midiChannelState
+
+	panSwing int // Random pan offset for the current note
+
+	cutoff    int // Filter cutoff, 0-127
+	resonance int // Filter resonance, 0-127
+}
+
+// Initialize a channel to its power-on state.
+func (ch *channel) reset(index int) {
+	*ch = channel{
+		index:           index,
+		channelVolume:   64,
+		pan:             32,
+		frequencyFactor: 1,
+		cutoff:          filterOpen,
+		noteDelay:       -1,
+		noteCut:         -1,
+		loop:            patternLoop{count: -1},
+	}
+	ch.midi.reset()
+}
+
+// Handle the start of a row: notes, instruments, volume column, and first-tick effects.
+// Repeats of a delayed row only run the effects, and replay delayed notes.
+func (p *Player) startRow(ch *channel, repeat bool) {
+	ch.noteDelay = -1
+	if !repeat {
+		ch.noteCut = -1
+	}
+	ch.frequencyFactor = 1
+	ch.volumeDelta = 0
+	ch.panDelta = 0
+	ch.tremorMute = false
+
+	e := &ch.entry
+	if e.Effect == effectS && (e.EffectParam>>4) == 0xD {
+		delay := int(e.EffectParam & 15)
+		if delay == 0 {
+			delay = 1
+		}
+		ch.noteDelay = delay
+		return
+	}
+
+	if repeat {
+		p.volumeColumnFirstTick(ch)
+		p.effectFirstTick(ch)
+		return
+	}
+	p.playEntry(ch)
+}
+
+// Apply the note and instrument of the current entry, then the first-tick effects.
+func (p *Player) playEntry(ch *channel) {
+	e := &ch.entry
+	porta := e.Effect == effectG || e.Effect == effectL || e.VolumeCommand == common.VcmdPortaToNote
+	started := false
+
+	if e.Instrument != 0 {
+		ch.instrumentNumber = int(e.Instrument)
+	}
+
+	switch {
+	case e.Note >= 1 && e.Note <= 120:
+		started = p.noteOn(ch, int(e.Note)-1, porta)
+	case e.Note == noteOff:
+		ch.voice.release()
+		p.midiNoteOff(ch)
+	case e.Note == noteCut:
+		ch.voice.active = false
+		p.midiNoteOff(ch)
+	case e.Note == noteFade:
+		ch.voice.fading = true
+	default:
+		if e.Instrument != 0 {
+			// An instrument without a note resets the volume.
+			if sample := p.lookupSample(ch.instrumentNumber, ch.note); sample != nil {
+				ch.volume = int(sample.DefaultVolume)
+			}
+		}
+	}
+
+	p.volumeColumnFirstTick(ch)
+
+	if started {
+		p.midiNoteOn(ch, int(e.Note)-1)
+		p.hookNote(ch, int(e.Note)-1)
+	}
+
+	p.effectFirstTick(ch)
+}
+
+// Find the sample that plays for an instrument number and note, following the notemap in
+// instrument mode.
+func (p *Player) lookupSample(instrument int, note int) *common.Sample {
+	sampleNumber := instrument
+	if p.module.UseInstruments {
+		if instrument < 1 || instrument > len(p.module.Instruments) {
+			return nil
+		}
+		sampleNumber = int(p.module.Instruments[instrument-1].Notemap[note].Sample)
+	}
+
+	if sampleNumber < 1 || sampleNumber > len(p.module.Samples) {
+		return nil
+	}
+	return &p.module.Samples[sampleNumber-1]
+}
+
+// Start a new note (0-119) on a channel, or set the portamento target. Returns true if a
+// new note was started.
+func (p *Player) noteOn(ch *channel, note int, porta bool) bool {
+	var ins *common.Instrument
+	mapped := note
+	sampleNumber := ch.instrumentNumber
+
+	if p.module.UseInstruments {
+		if ch.instrumentNumber < 1 || ch.instrumentNumber > len(p.module.Instruments) {
+			return false
+		}
+		ins = &p.module.Instruments[ch.instrumentNumber-1]
+		entry := ins.Notemap[note]
+		mapped = int(entry.Note)
+		sampleNumber = int(entry.Sample)
+	}
+
+	var sample *common.Sample
+	if sampleNumber >= 1 && sampleNumber <= len(p.module.Samples) {
+		sample = &p.module.Samples[sampleNumber-1]
+	}
+
+	if porta && ch.voice.active {
+		if sample != nil {
+			ch.portaTarget = noteFrequency(sample.C5, mapped)
+		}
+		return false
+	}
+
+	p.newNoteAction(ch, ins, sample, mapped)
+	ch.instrument = ins
+	ch.note = mapped
+
+	if sample == nil {
+		// Instruments without samples can still drive MIDI.
+		ch.voice.active = false
+		return ins != nil
+	}
+
+	ch.sample = sample
+	prev := ch.voice
+	ch.voice.trigger(ins, sample, p.samples[sampleNumber-1], mapped)
+	ch.voice.carryEnvelopes(&prev)
+	if sample.VibratoWaveform == common.SampleVibratoWaveformRandom {
+		ch.voice.autoVibrato.randomState = uint32(p.random() * (1 << 24))
+	}
+	p.applySwing(ch, ins)
+	if ins != nil {
+		// The instrument's filter settings carry over to the channel.
+		if ins.FilterCutoff&128 != 0 {
+			ch.cutoff = ch.voice.cutoff
+		}
+		if ins.FilterResonance&128 != 0 {
+			ch.resonance = ch.voice.resonance
+		}
+	}
+	ch.frequency = ch.voice.frequency
+	ch.portaTarget = ch.frequency
+
+	if ch.entry.Instrument != 0 {
+		ch.volume = int(sample.DefaultVolume)
+	}
+
+	if ins != nil && ins.DefaultPanEnabled {
+		ch.pan = int(ins.DefaultPan)
+		ch.surround = false
+	}
+	if sample.DefaultPanning&128 != 0 {
+		ch.pan = int(sample.DefaultPanning & 127)
+		ch.surround = false
+	}
+
+	if ins != nil && ins.PitchPanSeparation != 0 {
+		// Uses the note that was played, before the notemap.
+		separation := (note - int(ins.PitchPanCenter)) * int(ins.PitchPanSeparation) / 8
+		ch.pan = max(0, min(ch.pan+separation, 64))
+	}
+
+	if ch.vibratoWave < 4 {
+		ch.vibratoPos = 0
+	}
+	if ch.tremoloWave < 4 {
+		ch.tremoloPos = 0
+	}
+	ch.tremorCount = 0
+
+	return true
+}
+
+// Pick random volume and pan offsets for a new note from the instrument's swing settings,
+// like IT: the volume swing is a percentage of the sample's global volume, and the pan
+// swing is a pan offset of up to RandomPanVariation in either direction.
+func (p *Player) applySwing(ch *channel, ins *common.Instrument) {
+	ch.panSwing = 0
+	if ins == nil {
+		return
+	}
+
+	if ins.RandomVolumeVariation > 0 {
+		delta := p.random()*2 - 1
+		swing := int(math.Floor(delta * float64(ch.sample.GlobalVolume) * float64(ins.RandomVolumeVariation) / 100))
+		ch.voice.volumeSwing = swing
+	}
+
+	if ins.RandomPanVariation > 0 {
+		delta := p.random()*2 - 1
+		ch.panSwing = int(math.Floor(delta * float64(ins.RandomPanVariation)))
+	}
+}
+
+// Copy the channel state into its voice and advance the voice by one tick.
+func (p *Player) updateVoice(ch *channel) {
+	v := &ch.voice
+	if !v.active {
+		return
+	}
+
+	volume := max(0, min(ch.volume+ch.volumeDelta, 64))
+	if ch.tremorMute {
+		volume = 0
+	}
+
+	override := &p.tickOverrides[ch.index]
+	v.volume = float64(volume)
+	v.pan = float64(max(0, min(ch.pan+ch.panDelta+ch.panSwing, 64)))
+	if override.pan >= 0 {
+		v.pan = float64(override.pan)
+	}
+	v.frequency = ch.frequency * ch.frequencyFactor
+	v.cutoff = ch.cutoff
+	v.resonance = ch.resonance
+	v.gain = float64(ch.channelVolume) / 64 *
+		float64(p.globalVolume) / 128 *
+		float64(p.module.MixingVolume) / 128 *
+		override.volume
+
+	v.updateTick(p.rate, p.stereoMix())
+}
@@ -0,0 +1,73 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package render
+
+import "math"
+
+// What happens to output beyond full scale. Mixing is done in float64, so nothing is lost
+// until the final output.
+type ClipMode int
+
+const (
+	// Integer output is clamped at full scale. Float output is left as-is.
+	ClipHard ClipMode = iota
+
+	// Levels above softClipKnee are compressed smoothly so they approach full scale but
+	// never pass it, for all output formats.
+	ClipSoft
+)
+
+// Level where the soft clipper starts compressing.
+const softClipKnee = 0.75
+
+// Output level statistics, measured before clipping.
+type ClipStats struct {
+	Peak           float64 // Highest absolute sample value, 1 = full scale
+	ClippedSamples int     // Samples beyond full scale
+	ClipEvents     int     // Runs of consecutive clipped samples
+}
+
+// dB between the peak and full scale. Negative if the output clipped, and +Inf for
+// silence.
+func (s ClipStats) Headroom() float64 {
+	return -20 * math.Log10(s.Peak)
+}
+
+// True if any sample went beyond full scale.
+func (s ClipStats) Clipped() bool {
+	return s.ClippedSamples > 0
+}
+
+// Set how output beyond full scale is handled. The default is ClipHard.
+func (p *Player) SetClipMode(mode ClipMode) {
+	p.clipMode = mode
+}
+
+// Output level statistics since the start of the song.
+func (p *Player) ClipStats() ClipStats {
+	return p.clipStats
+}
+
+// Update the statistics with an output sample and apply the clip mode.
+func (p *Player) clip(v float64) float64 {
+	level := math.Abs(v)
+	p.clipStats.Peak = max(p.clipStats.Peak, level)
+	if level > 1 {
+		if !p.clipping {
+			p.clipStats.ClipEvents++
+		}
+		p.clipStats.ClippedSamples++
+	}
+	p.clipping = level > 1
+
+	if p.clipMode == ClipSoft && level > softClipKnee {
+		// x/(1+x) curve above the knee, which meets the straight line below it with the
+		// same slope.
+		x := (level - softClipKnee) / (1 - softClipKnee)
+		level = softClipKnee + float64((1-softClipKnee)*x)/(1+x)
+		return math.Copysign(level, v)
+	}
+	return v
+}
@@ -0,0 +1,541 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package render
+
+import (
+	"math"
+
+	"go.mukunda.com/modlib/common"
+)
+
+// Effect numbers, A = 1.
+const (
+	effectA = 1 + iota
+	effectB
+	effectC
+	effectD
+	effectE
+	effectF
+	effectG
+	effectH
+	effectI
+	effectJ
+	effectK
+	effectL
+	effectM
+	effectN
+	effectO
+	effectP
+	effectQ
+	effectR
+	effectS
+	effectT
+	effectU
+	effectV
+	effectW
+	effectX
+	effectY
+	effectZ
+)
+
+// Amiga period of C-5 at 8363 Hz in quarter-period units, multiplied by its frequency.
+const amigaConstant = 428 * 4 * 8363
+
+// ProTracker's period range in quarter periods.
+const (
+	amigaPeriodMin = 113 * 4
+	amigaPeriodMax = 856 * 4
+)
+
+// Volume change for each Qxy retrigger x value, as [add, multiply numerator, divisor].
+var retriggerVolumeTable = [16][3]int{
+	{0, 1, 1}, {-1, 1, 1}, {-2, 1, 1}, {-4, 1, 1},
+	{-8, 1, 1}, {-16, 1, 1}, {0, 2, 3}, {0, 1, 2},
+	{0, 1, 1}, {1, 1, 1}, {2, 1, 1}, {4, 1, 1},
+	{8, 1, 1}, {16, 1, 1}, {0, 3, 2}, {0, 2, 1},
+}
+
+// Use the parameter if it's nonzero, otherwise the remembered one.
+func remember(mem *uint8, param uint8) uint8 {
+	if param != 0 {
+		*mem = param
+	}
+	return *mem
+}
+
+// Remember each nibble of the parameter separately, for effects like vibrato where speed
+// and depth have their own memory.
+func rememberNibbles(mem *uint8, param uint8) uint8 {
+	if param&0xF0 != 0 {
+		*mem = (*mem & 0x0F) | (param & 0xF0)
+	}
+	if param&0x0F != 0 {
+		*mem = (*mem & 0xF0) | (param & 0x0F)
+	}
+	return *mem
+}
+
+// The change from a D/N/W style slide parameter. Fine slides (DxF, DFx) apply on the first
+// tick only, normal slides on the other ticks.
+func volumeSlideAmount(param uint8, firstTick bool) int {
+	hi, lo := int(param>>4), int(param&15)
+	switch {
+	case lo == 0x0F && hi != 0:
+		if firstTick {
+			return hi
+		}
+	case hi == 0x0F && lo != 0:
+		if firstTick {
+			return -lo
+		}
+	case lo == 0:
+		if !firstTick {
+			return hi
+		}
+	case hi == 0:
+		if !firstTick {
+			return -lo
+		}
+	}
+	return 0
+}
+
+// The size of an E/F pitch slide in 1/64 semitone units. EFx and EEx (fine and extra
+// fine) apply on the first tick only.
+func pitchSlideAmount(param uint8, firstTick bool) int {
+	switch {
+	case param >= 0xF0:
+		if firstTick {
+			return int(param&15) * 4
+		}
+	case param >= 0xE0:
+		if firstTick {
+			return int(param & 15)
+		}
+	default:
+		if !firstTick {
+			return int(param) * 4
+		}
+	}
+	return 0
+}
+
+// Slide a frequency by 1/64 semitone units with linear slides, or by quarter periods with
+// Amiga slides. Positive amounts raise the pitch.
+func (p *Player) slideFrequency(freq float64, amount int) float64 {
+	if freq <= 0 {
+		return freq
+	}
+	if p.behavior.LinearSlides {
+		return freq * exp2(float64(amount)/768)
+	}
+	period := max(amigaConstant/freq-float64(amount), 1)
+	if p.behavior.AmigaPeriodLimits {
+		period = max(amigaPeriodMin, min(period, amigaPeriodMax))
+	}
+	return amigaConstant / period
+}
+
+// Sample a modulation waveform at a position (64 steps per cycle). The result is -1 to 1.
+func (p *Player) waveform(wave int, pos int) float64 {
+	pos &= 63
+	switch wave & 3 {
+	case 1: // Ramp down
+		return 1 - float64(pos)/32
+	case 2: // Square
+		if pos < 32 {
+			return 1
+		}
+		return -1
+	case 3: // Random
+		return p.random()*2 - 1
+	}
+	return float64(sineTable[pos]) / 64
+}
+
+// Set the seed for random effects: the random waveform and instrument volume and pan
+// swing. Renders with the same seed are identical; the default seed is 0. This resets the
+// player to the start of the song.
+func (p *Player) SetRandomSeed(seed uint32) {
+	p.randomSeed = seed
+	p.reset()
+}
+
+// Deterministic pseudo-random number in [0, 1), so renders are repeatable.
+func (p *Player) random() float64 {
+	p.randomState = p.randomState*1103515245 + 12345
+	return float64(p.randomState>>8) / float64(1<<24)
+}
+
+// Move the channel frequency toward the portamento target.
+func (p *Player) portamento(ch *channel, speed uint8) {
+	if ch.portaTarget <= 0 || ch.frequency == ch.portaTarget {
+		return
+	}
+	amount := int(speed) * 4
+	if ch.frequency < ch.portaTarget {
+		ch.frequency = min(p.slideFrequency(ch.frequency, amount), ch.portaTarget)
+	} else {
+		ch.frequency = max(p.slideFrequency(ch.frequency, -amount), ch.portaTarget)
+	}
+}
+
+// Apply vibrato to the frequency for this tick. fine selects U (4x finer) instead of H.
+func (p *Player) vibrato(ch *channel, param uint8, fine bool) {
+	speed := int(param >> 4)
+	depth := float64(param & 15)
+	if !fine {
+		depth *= 4
+	}
+	if p.behavior.OldEffects {
+		depth *= 2
+	}
+	wave := p.waveform(ch.vibratoWave, ch.vibratoPos)
+	ch.frequencyFactor = exp2(wave * depth / 768)
+	ch.vibratoPos += speed
+}
+
+func (p *Player) tremolo(ch *channel, param uint8) {
+	speed := int(param >> 4)
+	depth := float64(param & 15)
+	ch.volumeDelta = int(math.Round(p.waveform(ch.tremoloWave, ch.tremoloPos) * depth * 4))
+	ch.tremoloPos += speed
+}
+
+func (p *Player) panbrello(ch *channel, param uint8) {
+	speed := int(param >> 4)
+	depth := float64(param & 15)
+	ch.panDelta = int(math.Round(p.waveform(ch.panbrelloWave, ch.panbrelloPos) * depth * 2))
+	ch.panbrelloPos += speed
+}
+
+func (p *Player) volumeSlide(ch *channel, param uint8, firstTick bool) {
+	amount := volumeSlideAmount(param, firstTick)
+	if firstTick && p.behavior.FastVolumeSlides {
+		amount += volumeSlideAmount(param, false)
+	}
+	ch.volume = max(0, min(ch.volume+amount, 64))
+}
+
+func (p *Player) pitchSlide(ch *channel, param uint8, up bool, firstTick bool) {
+	amount := pitchSlideAmount(param, firstTick)
+	if !up {
+		amount = -amount
+	}
+	ch.frequency = p.slideFrequency(ch.frequency, amount)
+}
+
+// Restart the sample and apply the Qxy volume change.
+func (p *Player) retrigger(ch *channel, param uint8) {
+	interval := int(param & 15)
+	if interval == 0 {
+		return
+	}
+	ch.retrigCount++
+	if ch.retrigCount < interval {
+		return
+	}
+	ch.retrigCount = 0
+
+	change := retriggerVolumeTable[param>>4]
+	ch.volume = max(0, min((ch.volume+change[0])*change[1]/change[2], 64))
+	ch.voice.restart()
+}
+
+func (p *Player) tremor(ch *channel, param uint8) {
+	on := int(param >> 4)
+	off := int(param & 15)
+	if !p.behavior.OldEffects {
+		on++
+		off++
+	}
+	ch.tremorCount %= on + off
+	ch.tremorMute = ch.tremorCount >= on
+	ch.tremorCount++
+}
+
+// Handle a pattern loop (SBx). Repeats of a delayed row don't count as loop iterations.
+func (p *Player) patternLoop(ch *channel, count int) {
+	if p.rowRepeat > 0 {
+		return
+	}
+	loop := &ch.loop
+	if p.behavior.SharedPatternLoop {
+		loop = &p.sharedLoop
+	}
+
+	if count == 0 {
+		loop.row = p.row
+		return
+	}
+
+	if loop.count < 0 {
+		loop.count = count
+	} else {
+		loop.count--
+	}
+
+	if loop.count > 0 {
+		p.loopJump = true
+		p.loopJumpRow = loop.row
+	} else {
+		loop.count = -1
+		if !p.behavior.KeepLoopStart {
+			loop.row = p.row + 1
+		}
+	}
+}
+
+// Set the sample position for Oxx. SAy supplies bits 16-19 of the offset. An offset at or
+// past the end of the sample, or of its loop, is ignored in IT, moves to the end with
+// OldEffects, and stops the note with OffsetPastEndStops.
+func (p *Player) sampleOffset(ch *channel, param uint8) {
+	offset := int(remember(&ch.mem.offset, param))*256 + ch.highOffset*65536
+	v := &ch.voice
+	if !v.active || ch.entry.Note < 1 || ch.entry.Note > 120 {
+		return
+	}
+
+	_, end, _, _ := v.loopRegion()
+	switch {
+	case offset < end:
+		v.position = float64(offset)
+	case p.behavior.OffsetPastEndStops:
+		v.active = false
+	case p.behavior.OldEffects:
+		v.position = float64(end - 1)
+	}
+}
+
+// Handle the volume column on the first tick of a row.
+func (p *Player) volumeColumnFirstTick(ch *channel) {
+	e := &ch.entry
+	param := e.VolumeParam
+	switch e.VolumeCommand {
+	case common.VcmdSetVolume:
+		ch.volume = int(min(param, 64))
+	case common.VcmdFineVolUp:
+		ch.volume = min(ch.volume+int(remember(&ch.mem.volumeColumn, param)), 64)
+	case common.VcmdFineVolDown:
+		ch.volume = max(ch.volume-int(remember(&ch.mem.volumeColumn, param)), 0)
+	case common.VcmdVolSlideUp, common.VcmdVolSlideDown:
+		remember(&ch.mem.volumeColumn, param)
+	case common.VcmdPitchSlideDown, common.VcmdPitchSlideUp:
+		if param != 0 {
+			ch.mem.pitchSlide = param * 4
+		}
+	case common.VcmdSetPan:
+		ch.pan = int(min(param, 64))
+		ch.surround = false
+	case common.VcmdPortaToNote:
+		if int(param) < len(common.VolumeColumnPortaTable) && param != 0 {
+			ch.mem.portamento = common.VolumeColumnPortaTable[param]
+		}
+	case common.VcmdVibratoDepth:
+		rememberNibbles(&ch.mem.vibrato, param)
+		if !p.behavior.OldEffects {
+			p.vibrato(ch, ch.mem.vibrato, false)
+		}
+	}
+}
+
+// Handle the volume column on the ticks after the first.
+func (p *Player) volumeColumnTick(ch *channel) {
+	e := &ch.entry
+	switch e.VolumeCommand {
+	case common.VcmdVolSlideUp:
+		ch.volume = min(ch.volume+int(ch.mem.volumeColumn), 64)
+	case common.VcmdVolSlideDown:
+		ch.volume = max(ch.volume-int(ch.mem.volumeColumn), 0)
+	case common.VcmdPitchSlideDown:
+		p.pitchSlide(ch, ch.mem.pitchSlide, false, false)
+	case common.VcmdPitchSlideUp:
+		p.pitchSlide(ch, ch.mem.pitchSlide, true, false)
+	case common.VcmdPortaToNote:
+		p.portamento(ch, ch.mem.portamento)
+	case common.VcmdVibratoDepth:
+		p.vibrato(ch, ch.mem.vibrato, false)
+	}
+}
+
+// Handle the effect column on the first tick of a row.
+func (p *Player) effectFirstTick(ch *channel) {
+	e := &ch.entry
+	param := e.EffectParam
+	linkEFG := p.behavior.LinkEFG
+
+	switch e.Effect {
+	case effectA:
+		if param != 0 {
+			p.speed = int(param)
+		}
+	case effectB:
+		p.jumpOrder = int(param)
+	case effectC:
+		p.breakRow = int(param)
+	case effectD:
+		p.volumeSlide(ch, p.rememberSlide(&ch.mem.volumeSlide, param), true)
+	case effectE, effectF:
+		param = p.rememberSlide(p.pitchSlideMemory(ch, e.Effect == effectF), param)
+		if linkEFG {
+			ch.mem.portamento = param
+		}
+		p.pitchSlide(ch, param, e.Effect == effectF, true)
+	case effectG:
+		remember(&ch.mem.portamento, param)
+		if linkEFG {
+			ch.mem.pitchSlide = ch.mem.portamento
+			ch.mem.pitchSlideUp = ch.mem.portamento
+		}
+	case effectH, effectU:
+		rememberNibbles(&ch.mem.vibrato, param)
+		if !p.behavior.OldEffects {
+			p.vibrato(ch, ch.mem.vibrato, e.Effect == effectU)
+		}
+	case effectI:
+		remember(&ch.mem.tremor, param)
+	case effectJ:
+		remember(&ch.mem.arpeggio, param)
+	case effectK, effectL:
+		p.volumeSlide(ch, p.rememberSlide(&ch.mem.volumeSlide, param), true)
+	case effectM:
+		ch.channelVolume = int(min(param, 64))
+	case effectN:
+		param = p.rememberSlide(&ch.mem.channelVolumeSlide, param)
+		ch.channelVolume = max(0, min(ch.channelVolume+volumeSlideAmount(param, true), 64))
+	case effectO:
+		p.sampleOffset(ch, param)
+	case effectP:
+		param = p.rememberSlide(&ch.mem.panSlide, param)
+		ch.pan = max(0, min(ch.pan-volumeSlideAmount(param, true), 64))
+	case effectQ:
+		remember(&ch.mem.retrigger, param)
+	case effectR:
+		rememberNibbles(&ch.mem.tremolo, param)
+	case effectS:
+		p.specialEffect(ch, remember(&ch.mem.special, param))
+	case effectT:
+		if param >= 0x20 {
+			p.tempo = int(param)
+		} else {
+			remember(&ch.mem.tempo, param)
+		}
+	case effectV:
+		p.globalVolume = int(min(param, 128))
+	case effectW:
+		param = p.rememberSlide(&ch.mem.globalVolumeSlide, param)
+		p.globalVolume = max(0, min(p.globalVolume+volumeSlideAmount(param, true), 128))
+	case effectX:
+		ch.pan = min((int(param)+2)/4, 64)
+		ch.surround = false
+	case effectY:
+		rememberNibbles(&ch.mem.panbrello, param)
+	case effectZ:
+		p.midiMacro(ch, param)
+	}
+}
+
+// Handle the S effects on the first tick.
+func (p *Player) specialEffect(ch *channel, param uint8) {
+	x := int(param & 15)
+	switch param >> 4 {
+	case 0x3:
+		ch.vibratoWave = x
+	case 0x4:
+		ch.tremoloWave = x
+	case 0x5:
+		ch.panbrelloWave = x
+	case 0x6:
+		p.tickDelay += x
+	case 0x8:
+		ch.pan = (x*64 + 7) / 15
+		ch.surround = false
+	case 0x9:
+		if x == 1 {
+			ch.surround = true
+		}
+	case 0xA:
+		ch.highOffset = x
+	case 0xB:
+		p.patternLoop(ch, x)
+	case 0xC:
+		ch.noteCut = max(x, 1)
+	case 0xE:
+		if p.rowDelay == 0 {
+			p.rowDelay = x
+		}
+	case 0xF:
+		ch.activeMacro = x
+	}
+}
+
+// Update effects on the ticks after the first.
+func (p *Player) updateEffects(ch *channel) {
+	if ch.noteDelay >= 0 {
+		if p.tick == ch.noteDelay && (p.rowRepeat == 0 || p.behavior.RowDelayRepeatsRow) {
+			ch.noteDelay = -1
+			p.playEntry(ch)
+		}
+		return
+	}
+
+	if p.tick == ch.noteCut && p.rowRepeat == 0 {
+		ch.voice.active = false
+		p.midiNoteOff(ch)
+	}
+
+	p.volumeColumnTick(ch)
+
+	e := &ch.entry
+	switch e.Effect {
+	case effectD:
+		p.volumeSlide(ch, ch.mem.volumeSlide, false)
+	case effectE:
+		p.pitchSlide(ch, ch.mem.pitchSlide, false, false)
+	case effectF:
+		p.pitchSlide(ch, *p.pitchSlideMemory(ch, true), true, false)
+	case effectG:
+		p.portamento(ch, ch.mem.portamento)
+	case effectH:
+		p.vibrato(ch, ch.mem.vibrato, false)
+	case effectU:
+		p.vibrato(ch, ch.mem.vibrato, true)
+	case effectI:
+		p.tremor(ch, ch.mem.tremor)
+	case effectJ:
+		semitones := 0
+		switch p.tick % 3 {
+		case 1:
+			semitones = int(ch.mem.arpeggio >> 4)
+		case 2:
+			semitones = int(ch.mem.arpeggio & 15)
+		}
+		ch.frequencyFactor = exp2(float64(semitones) / 12)
+	case effectK:
+		p.vibrato(ch, ch.mem.vibrato, false)
+		p.volumeSlide(ch, ch.mem.volumeSlide, false)
+	case effectL:
+		p.portamento(ch, ch.mem.portamento)
+		p.volumeSlide(ch, ch.mem.volumeSlide, false)
+	case effectN:
+		ch.channelVolume = max(0, min(ch.channelVolume+volumeSlideAmount(ch.mem.channelVolumeSlide, false), 64))
+	case effectP:
+		ch.pan = max(0, min(ch.pan-volumeSlideAmount(ch.mem.panSlide, false), 64))
+	case effectQ:
+		p.retrigger(ch, ch.mem.retrigger)
+	case effectR:
+		p.tremolo(ch, ch.mem.tremolo)
+	case effectT:
+		if param := ch.mem.tempo; param < 0x10 {
+			p.tempo = max(p.tempo-int(param), 32)
+		} else if param < 0x20 {
+			p.tempo = min(p.tempo+int(param&15), 255)
+		}
+	case effectW:
+		p.globalVolume = max(0, min(p.globalVolume+volumeSlideAmount(ch.mem.globalVolumeSlide, false), 128))
+	case effectY:
+		p.panbrello(ch, ch.mem.panbrello)
+	}
+}
@@ -0,0 +1,76 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package render
+
+import "go.mukunda.com/modlib/common"
+
+// Playback state of an instrument envelope. Positions are measured in ticks.
+type envelopeState struct {
+	env   *common.Envelope
+	tick  int
+	ended bool // Set when the position reaches the last node and there is no loop.
+}
+
+func newEnvelopeState(env *common.Envelope) envelopeState {
+	return envelopeState{env: env}
+}
+
+// True if the envelope exists, is enabled, and has nodes to read.
+func (e *envelopeState) active() bool {
+	return e.env != nil && e.env.Enabled && len(e.env.Nodes) > 0
+}
+
+// Get the X position of a node, clamping the index to the node list.
+func (e *envelopeState) nodeX(index int16) int {
+	nodes := e.env.Nodes
+	return int(nodes[max(0, min(int(index), len(nodes)-1))].X)
+}
+
+// The envelope value at the current position, linearly interpolated between nodes.
+func (e *envelopeState) value() float64 {
+	nodes := e.env.Nodes
+	if e.tick <= int(nodes[0].X) {
+		return float64(nodes[0].Y)
+	}
+
+	for i := 1; i < len(nodes); i++ {
+		b := nodes[i]
+		if e.tick > int(b.X) {
+			continue
+		}
+		a := nodes[i-1]
+		if b.X == a.X {
+			return float64(b.Y)
+		}
+		t := float64(e.tick-int(a.X)) / float64(b.X-a.X)
+		return float64(a.Y) + float64((float64(b.Y)-float64(a.Y))*t)
+	}
+
+	return float64(nodes[len(nodes)-1].Y)
+}
+
+// Move the envelope forward by one tick. The sustain loop only applies while the note is
+// held.
+func (e *envelopeState) advance(keyOn bool) {
+	if !e.active() || e.ended {
+		return
+	}
+
+	e.tick++
+
+	env := e.env
+	if env.Sustain && keyOn {
+		if end := e.nodeX(env.SustainEnd); e.tick > end {
+			e.tick = e.nodeX(env.SustainStart)
+		}
+	} else if env.Loop {
+		if end := e.nodeX(env.LoopEnd); e.tick > end {
+			e.tick = e.nodeX(env.LoopStart)
+		}
+	} else if last := int(env.Nodes[len(env.Nodes)-1].X); e.tick >= last {
+		e.tick = last
+		e.ended = true
+	}
+}
@@ -0,0 +1,66 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package render
+
+import "math"
+
+// Cutoff and resonance values (0-127) where IT's filter has no effect.
+const (
+	filterOpen       = 127
+	filterNoResonant = 0
+)
+
+// Filter envelope modifier when there's no filter envelope. Envelope values (-32 to 32)
+// are scaled by 8, so a filter envelope at its maximum is the same as no envelope.
+const filterNoModifier = 256
+
+// log2(10), for computing powers of 10 with exp2.
+const log2Of10 = 3.321928094887362
+
+// IT's resonant low-pass filter, a two-pole IIR filter using the same coefficients as
+// Impulse Tracker (as documented by OpenMPT and Schism Tracker).
+type filter struct {
+	enabled bool
+
+	gain     float64
+	feedback [2]float64
+
+	// Last two outputs for each sample channel.
+	history [2][2]float64
+}
+
+// Compute the coefficients. cutoff and resonance are 0-127, and modifier is from the filter
+// envelope (0-512, 256 = no change).
+func (f *filter) setup(cutoff int, resonance int, modifier int, rate int) {
+	exponent := 0.25 + float64(cutoff*(modifier+256))/(24*512)
+	frequency := int(110 * exp2(exponent))
+	frequency = max(120, min(frequency, 20000, rate/2))
+
+	damping := exp2(-float64(resonance) * (24.0 / 128.0) / 20 * log2Of10)
+	r := float64(rate) / (float64(frequency) * 2 * math.Pi)
+	d := float64(damping*r) + damping - 1
+	e := r * r
+
+	f.enabled = true
+	f.gain = 1 / (1 + d + e)
+	f.feedback[0] = (d + e + e) / (1 + d + e)
+	f.feedback[1] = -e / (1 + d + e)
+}
+
+// Clear the filter history, e.g. for a new note.
+func (f *filter) reset() {
+	f.history = [2][2]float64{}
+}
+
+// Filter one sample of a sample channel.
+func (f *filter) process(channel int, x float64) float64 {
+	h := &f.history[channel]
+	y := float64(x*f.gain) + float64(h[0]*f.feedback[0]) + float64(h[1]*f.feedback[1])
+	// Limit the output so extreme resonance can't run away.
+	y = max(-2, min(y, 2))
+	h[1] = h[0]
+	h[0] = y
+	return y
+}
@@ -0,0 +1,57 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package render
+
+// Callbacks for following playback, e.g. for visualizers. Any of them may be nil. They are
+// called during Render (or MidiEvents) on the same goroutine, when the tick that causes
+// them is processed, which is slightly ahead of when the audio is heard if it's buffered.
+// They aren't called while seeking.
+type PlayerHooks struct {
+	// A new row starts playing.
+	OnRow func(order, row int)
+
+	// A note (0-119) is triggered on a channel with an instrument number (or sample number
+	// in sample mode).
+	OnNote func(channel, note, instrument int)
+
+	// The song moves to a different order list entry. This is also called for the first
+	// pattern.
+	OnPatternChange func(order, pattern int)
+
+	// The song has ended.
+	OnSongEnd func()
+}
+
+// Set the playback callbacks, replacing any previous ones.
+func (p *Player) SetHooks(hooks PlayerHooks) {
+	p.hooks = hooks
+}
+
+func (p *Player) hookRow(pattern int) {
+	if p.seeking {
+		return
+	}
+	if p.order != p.lastOrder && p.hooks.OnPatternChange != nil {
+		p.hooks.OnPatternChange(p.order, pattern)
+	}
+	p.lastOrder = p.order
+	if p.hooks.OnRow != nil {
+		p.hooks.OnRow(p.order, p.row)
+	}
+}
+
+func (p *Player) hookNote(ch *channel, note int) {
+	if p.seeking || p.hooks.OnNote == nil {
+		return
+	}
+	p.hooks.OnNote(ch.index, note, ch.instrumentNumber)
+}
+
+func (p *Player) hookSongEnd() {
+	if p.seeking || p.hooks.OnSongEnd == nil {
+		return
+	}
+	p.hooks.OnSongEnd()
+}
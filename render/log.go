@@ -0,0 +1,27 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package render
+
+import (
+	"fmt"
+	"log/slog"
+
+	"go.mukunda.com/modlib/common"
+)
+
+// Send debug traces of playback decisions to logger: the effect behavior in use, order
+// jumps, pattern breaks and loops, the end of the song, and voices stolen at the voice
+// limit. nil turns them off.
+func (p *Player) SetLogger(logger *slog.Logger) {
+	p.logger = logger
+	common.Debug(logger, "effect behavior", "behavior", fmt.Sprintf("%+v", p.behavior))
+}
+
+// Log a debug trace, except while seeking, which replays the song silently.
+func (p *Player) debug(msg string, args ...any) {
+	if !p.seeking {
+		common.Debug(p.logger, msg, args...)
+	}
+}
@@ -0,0 +1,90 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package render
+
+import (
+	"io"
+
+	"go.mukunda.com/modlib/common"
+)
+
+// Where a song loops, in frames from the start of the song.
+type LoopPoint struct {
+	Start int // First frame of the looped section
+	End   int // Frame where playback goes back to Start
+
+	// Position of the first row of the looped section.
+	Order int
+	Row   int
+}
+
+// The loop point, once playback has reached the end of the song for the first time. A
+// song that plays to the end of the order list loops to the start.
+func (p *Player) LoopPoint() (LoopPoint, bool) {
+	if p.loop == nil {
+		return LoopPoint{}, false
+	}
+	return *p.loop, true
+}
+
+// Remember where the song goes back to the first time it reaches its end.
+func (p *Player) recordLoop() {
+	if p.loop != nil {
+		return
+	}
+
+	order, row := p.order, p.row
+	defer func() { p.order, p.row = order, row }()
+	if _, ok := p.resolveOrder(); !ok {
+		p.order, p.row = 0, 0
+		p.resolveOrder()
+	}
+	if start, ok := p.visited[[2]int{p.order, p.row}]; ok {
+		p.loop = &LoopPoint{Start: start, End: p.framesPlayed, Order: p.order, Row: p.row}
+	}
+}
+
+// Render a song as stereo intro and loop sections for seamless looping, as game engines
+// do: the intro is played once, then the loop repeats. The loop is taken from the second
+// pass through the looped section, so notes ringing past the loop point carry into its
+// start as they do when the song loops. Returns the loop point of the first pass.
+func RenderLoop(m *common.Module, rate int) (intro []float32, loop []float32, point LoopPoint) {
+	p := NewPlayer(m, rate)
+	p.SetPlaybackOptions(PlaybackOptions{Loops: 1})
+
+	var audio []float32
+	p.RenderChunks(4096, func(chunk []float32) bool {
+		audio = append(audio, chunk...)
+		return true
+	})
+
+	point, _ = p.LoopPoint()
+	return audio[:point.Start*2], audio[point.End*2:], point
+}
+
+// Write the sections from RenderLoop as two 16-bit WAV files. Returns the loop point.
+func WriteLoopWavs(m *common.Module, rate int, intro io.WriteSeeker, loop io.WriteSeeker) (LoopPoint, error) {
+	introAudio, loopAudio, point := RenderLoop(m, rate)
+	for _, section := range []struct {
+		w     io.WriteSeeker
+		audio []float32
+	}{{intro, introAudio}, {loop, loopAudio}} {
+		ww, err := NewWavWriter(section.w, rate, 2)
+		if err != nil {
+			return point, err
+		}
+		samples := make([]int16, len(section.audio))
+		for i, v := range section.audio {
+			samples[i] = int16(toInteger(float64(v), int16Max))
+		}
+		if err := ww.WriteInt16(samples); err != nil {
+			return point, err
+		}
+		if err := ww.Close(); err != nil {
+			return point, err
+		}
+	}
+	return point, nil
+}
@@ -0,0 +1,38 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package render
+
+import "math"
+
+// Rendering must produce the same bits on every platform. IEEE 754 rounds the basic
+// operations identically everywhere, but the math package may use assembly with different
+// rounding on some architectures, and Go may fuse a multiply and an add into one FMA
+// instruction. Functions that aren't exact are implemented here with basic operations, and
+// products that are added to something are wrapped in float64() to prevent fusion.
+
+// IT's vibrato/tremolo/panbrello sine table, 64 steps from -64 to 64.
+var sineTable = [64]int8{
+	0, 6, 12, 19, 24, 30, 36, 41, 45, 49, 53, 56, 59, 61, 63, 64,
+	64, 64, 63, 61, 59, 56, 53, 49, 45, 41, 36, 30, 24, 19, 12, 6,
+	0, -6, -12, -19, -24, -30, -36, -41, -45, -49, -53, -56, -59, -61, -63, -64,
+	-64, -64, -63, -61, -59, -56, -53, -49, -45, -41, -36, -30, -24, -19, -12, -6,
+}
+
+// Terms of the series in exp2; enough for full precision over [0, ln 2).
+const exp2Terms = 18
+
+// 2^x. The fraction is computed with a Taylor series of e^(f ln 2), and the integer part is
+// applied exactly with Ldexp.
+func exp2(x float64) float64 {
+	whole := math.Floor(x)
+	y := float64(x-whole) * math.Ln2
+
+	sum, term := 1.0, 1.0
+	for n := 1; n <= exp2Terms; n++ {
+		term = float64(term*y) / float64(n)
+		sum += term
+	}
+	return math.Ldexp(sum, int(whole))
+}
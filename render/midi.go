@@ -0,0 +1,256 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package render
+
+import (
+	"fmt"
+	"iter"
+	"time"
+
+	"go.mukunda.com/modlib/common"
+)
+
+// A timed MIDI message produced by the player in MIDI mode.
+type MidiEvent struct {
+	Time    time.Duration // From the start of the song.
+	Message []byte
+}
+
+// MIDI macro configuration for Zxx effects. Macros are strings of hex digits and
+// variables, using the IT conventions: "c" is the MIDI channel (a single nibble), "n" the
+// note, "v" the velocity, "u" the channel volume, "x" and "y" the pan, "p" the program,
+// "a" and "b" the high and low bytes of the bank, "h" the tracker channel, and "z" the Zxx
+// parameter.
+type MidiMacros = common.MidiMacros
+
+// IT's default macro configuration: SF0 controls the filter cutoff, and Z80-Z8F set the
+// filter resonance.
+func DefaultMidiMacros() MidiMacros {
+	var macros MidiMacros
+	macros.Parametric[0] = "F0F000z"
+	for i := 0; i < 16; i++ {
+		macros.Fixed[i] = fmt.Sprintf("F0F001%02X", i*8)
+	}
+	return macros
+}
+
+// MIDI output state for the whole player.
+type midiState struct {
+	events   []MidiEvent
+	time     time.Duration
+	programs [16]int // Last program sent on each MIDI channel, -1 = none.
+	banks    [16]int // Last bank sent on each MIDI channel, -1 = none.
+}
+
+func newMidiState() *midiState {
+	m := &midiState{}
+	for i := range m.programs {
+		m.programs[i] = -1
+		m.banks[i] = -1
+	}
+	return m
+}
+
+// MIDI output state for a tracker channel.
+type midiChannelState struct {
+	channel int // MIDI channel of the sounding note, -1 = none.
+	note    int
+}
+
+func (m *midiChannelState) reset() {
+	m.channel = -1
+	m.note = 0
+}
+
+func (p *Player) sendMidi(message ...byte) {
+	p.midi.events = append(p.midi.events, MidiEvent{Time: p.midi.time, Message: message})
+}
+
+// The MIDI channel that a tracker channel sends to. IT MIDI channels are 1-16, or 17 to
+// map tracker channels onto MIDI channels.
+func (p *Player) midiChannelFor(ch *channel) int {
+	if ch.instrument != nil && ch.instrument.MidiChannel >= 1 && ch.instrument.MidiChannel <= 16 {
+		return int(ch.instrument.MidiChannel) - 1
+	}
+	return ch.index % 16
+}
+
+// Send a note-on for the channel's instrument if it has a MIDI channel. note is the
+// pattern note (0-119).
+func (p *Player) midiNoteOn(ch *channel, note int) {
+	if p.midi == nil {
+		return
+	}
+	p.midiNoteOff(ch)
+
+	ins := ch.instrument
+	if ins == nil || ins.MidiChannel < 1 || ins.MidiChannel > 17 {
+		return
+	}
+	mc := p.midiChannelFor(ch)
+
+	if bank := int(ins.MidiBank); bank != 0xFFFF && p.midi.banks[mc] != bank {
+		p.midi.banks[mc] = bank
+		p.sendMidi(0xB0|byte(mc), 0, byte(bank>>7)&0x7F)
+		p.sendMidi(0xB0|byte(mc), 32, byte(bank)&0x7F)
+	}
+
+	if program := int(ins.MidiProgram); program < 128 && p.midi.programs[mc] != program {
+		p.midi.programs[mc] = program
+		p.sendMidi(0xC0|byte(mc), byte(program))
+	}
+
+	midiNote := min(note, 127)
+	velocity := min(ch.volume*2, 127)
+	p.sendMidi(0x90|byte(mc), byte(midiNote), byte(velocity))
+	ch.midi.channel = mc
+	ch.midi.note = midiNote
+}
+
+// Release the MIDI note playing on a channel.
+func (p *Player) midiNoteOff(ch *channel) {
+	if p.midi == nil || ch.midi.channel < 0 {
+		return
+	}
+	p.sendMidi(0x80|byte(ch.midi.channel), byte(ch.midi.note), 0)
+	ch.midi.reset()
+}
+
+// Release all MIDI notes when the song ends.
+func (p *Player) midiEndOfSong() {
+	for i := range p.channels {
+		p.midiNoteOff(&p.channels[i])
+	}
+}
+
+// Expand a macro string into bytes.
+func (p *Player) expandMacro(ch *channel, macro string, param uint8) []byte {
+	var result []byte
+	nibbles := 0
+	value := byte(0)
+
+	pushNibble := func(n byte) {
+		value = value<<4 | n
+		nibbles++
+		if nibbles == 2 {
+			result = append(result, value)
+			nibbles = 0
+			value = 0
+		}
+	}
+
+	for _, c := range macro {
+		switch {
+		case c >= '0' && c <= '9':
+			pushNibble(byte(c - '0'))
+		case c >= 'A' && c <= 'F':
+			pushNibble(byte(c-'A') + 10)
+		case c == 'c':
+			pushNibble(byte(p.midiChannelFor(ch)))
+		case c == 'n':
+			result = append(result, byte(min(ch.note, 127)))
+		case c == 'v':
+			result = append(result, byte(min(ch.volume*2, 127)))
+		case c == 'u':
+			result = append(result, byte(min(ch.channelVolume*2, 127)))
+		case c == 'x' || c == 'y':
+			result = append(result, byte(min(ch.pan*2, 127)))
+		case c == 'p':
+			result = append(result, byte(p.midiProgram(ch)))
+		case c == 'a':
+			result = append(result, byte(p.midiBank(ch)>>7)&0x7F)
+		case c == 'b':
+			result = append(result, byte(p.midiBank(ch))&0x7F)
+		case c == 'h':
+			result = append(result, byte(ch.index)&0x7F)
+		case c == 'z':
+			result = append(result, param&0x7F)
+		}
+	}
+
+	return result
+}
+
+// The instrument's MIDI program and bank for macros, 0 if it has none.
+func (p *Player) midiProgram(ch *channel) int {
+	if ch.instrument == nil || ch.instrument.MidiProgram < 0 || ch.instrument.MidiProgram > 127 {
+		return 0
+	}
+	return int(ch.instrument.MidiProgram)
+}
+
+func (p *Player) midiBank(ch *channel) int {
+	if ch.instrument == nil || ch.instrument.MidiBank == 0xFFFF {
+		return 0
+	}
+	return int(ch.instrument.MidiBank)
+}
+
+// Set the macros used by Zxx. The default is the module's embedded configuration if it has
+// one, otherwise DefaultMidiMacros.
+func (p *Player) SetMidiMacros(macros MidiMacros) {
+	p.macros = macros
+}
+
+// Handle a Zxx effect. Internal messages (F0 F0 ...) control the filter, and the rest are
+// sent to MIDI in MIDI mode.
+func (p *Player) midiMacro(ch *channel, param uint8) {
+	var macro string
+	if param < 0x80 {
+		macro = p.macros.Parametric[ch.activeMacro]
+	} else {
+		macro = p.macros.Fixed[param-0x80]
+	}
+
+	message := p.expandMacro(ch, macro, param)
+	if len(message) >= 2 && message[0] == 0xF0 && message[1] == 0xF0 {
+		p.internalMacro(ch, message[2:])
+		return
+	}
+	if p.midi == nil || len(message) == 0 {
+		return
+	}
+	p.sendMidi(message...)
+}
+
+// Handle an internal macro message: F0 F0 00 xx sets the filter cutoff and F0 F0 01 xx sets
+// the resonance.
+func (p *Player) internalMacro(ch *channel, data []byte) {
+	if len(data) < 2 {
+		return
+	}
+	switch data[0] {
+	case 0:
+		ch.cutoff = int(data[1] & 0x7F)
+	case 1:
+		ch.resonance = int(data[1] & 0x7F)
+	}
+}
+
+// Play the song in MIDI mode: instead of audio, produce the MIDI messages for instruments
+// that have a MIDI channel set, and for Zxx macros. Events are timed from the start of the
+// song. This consumes the player, so use a new one for each pass. Loops and MaxDuration
+// from the playback options apply; the fadeout doesn't.
+func (p *Player) MidiEvents() iter.Seq[MidiEvent] {
+	return func(yield func(MidiEvent) bool) {
+		p.midi = newMidiState()
+		defer func() { p.midi = nil }()
+
+		for !p.ended {
+			if limit := p.options.MaxDuration; limit > 0 && p.midi.time >= limit {
+				p.stop()
+			} else {
+				p.processTick()
+			}
+			for _, event := range p.midi.events {
+				if !yield(event) {
+					return
+				}
+			}
+			p.midi.events = p.midi.events[:0]
+			p.midi.time += time.Duration(2.5 / float64(p.tempo) * float64(time.Second))
+		}
+	}
+}
@@ -0,0 +1,159 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package render
+
+import "go.mukunda.com/modlib/common"
+
+// Voices that can play at once, like IT's virtual channels: the notes on the channels
+// plus the notes left playing in the background by new note actions.
+const DefaultVoiceLimit = 256
+
+// A note that was moved off its channel by a new note action. It keeps the mixing
+// parameters it had, but still runs its envelopes and fadeout.
+type backgroundVoice struct {
+	voice
+	channel       int // Channel it came from, for muting and stems.
+	note          int // Note after the notemap, for duplicate checks.
+	channelVolume int
+}
+
+// Set the number of voices that can play at once, including background voices from new
+// note actions. When a new note needs a voice past the limit, the quietest background
+// voice is stopped. n <= 0 uses DefaultVoiceLimit. Lower limits are faster with dense
+// modules.
+func (p *Player) SetVoiceLimit(n int) {
+	p.voiceLimit = n
+}
+
+// Number of voices that are currently playing, including background voices.
+func (p *Player) ActiveVoices() int {
+	count := 0
+	for i := range p.background {
+		if p.background[i].active {
+			count++
+		}
+	}
+	for i := range p.channels {
+		if p.channels[i].voice.active {
+			count++
+		}
+	}
+	return count
+}
+
+// Apply the new note action of the note playing on a channel, before a new note replaces
+// it. Notes that don't cut are moved to the background. Duplicate checks of the new
+// instrument apply first, to the channel's note and its background voices.
+func (p *Player) newNoteAction(ch *channel, ins *common.Instrument, sample *common.Sample, note int) {
+	v := &ch.voice
+	duplicateCheck(v, ch.note, ins, sample, note)
+	for i := range p.background {
+		if bg := &p.background[i]; bg.channel == ch.index {
+			duplicateCheck(&bg.voice, bg.note, ins, sample, note)
+		}
+	}
+
+	if !v.active || v.instrument == nil || v.instrument.NewNoteAction == common.NnaNoteCut {
+		return
+	}
+
+	switch v.instrument.NewNoteAction {
+	case common.NnaNoteOff:
+		v.release()
+	case common.NnaFade:
+		v.fading = true
+	}
+
+	bg := backgroundVoice{voice: *v, channel: ch.index, note: ch.note, channelVolume: ch.channelVolume}
+	p.pruneBackground()
+	if len(p.background) == 0 || p.ActiveVoices() < p.maxVoices() {
+		p.background = append(p.background, bg)
+		return
+	}
+
+	// Steal the quietest background voice.
+	quietest := 0
+	for i := range p.background {
+		if p.background[i].loudness() < p.background[quietest].loudness() {
+			quietest = i
+		}
+	}
+	if bg.loudness() > p.background[quietest].loudness() {
+		p.debug("voice stolen", "channel", p.background[quietest].channel, "for_channel", ch.index)
+		p.background[quietest] = bg
+	} else {
+		p.debug("voice dropped at the limit", "channel", ch.index)
+	}
+}
+
+// Apply the duplicate check action of a new note's instrument to a voice playing the
+// same instrument, if it matches by the instrument's duplicate check type.
+func duplicateCheck(v *voice, voiceNote int, ins *common.Instrument, sample *common.Sample, note int) {
+	if !v.active || ins == nil || v.instrument != ins {
+		return
+	}
+	switch ins.DuplicateCheckType {
+	case common.DctNote:
+		if voiceNote != note {
+			return
+		}
+	case common.DctSample:
+		if v.sample != sample {
+			return
+		}
+	case common.DctInstrument:
+	default:
+		return
+	}
+
+	switch ins.DuplicateCheckAction {
+	case common.DcaNoteCut:
+		v.active = false
+	case common.DcaNoteOff:
+		v.release()
+	case common.DcaFade:
+		v.fading = true
+	}
+}
+
+// The voice limit in use.
+func (p *Player) maxVoices() int {
+	if p.voiceLimit <= 0 {
+		return DefaultVoiceLimit
+	}
+	return p.voiceLimit
+}
+
+// Volume of a voice as of its last tick.
+func (v *voice) loudness() float64 {
+	return v.mixLeft + v.mixRight
+}
+
+// Remove background voices that have stopped.
+func (p *Player) pruneBackground() {
+	n := 0
+	for _, bg := range p.background {
+		if bg.active {
+			p.background[n] = bg
+			n++
+		}
+	}
+	clear(p.background[n:])
+	p.background = p.background[:n]
+}
+
+// Advance the background voices by one tick. The global volume and the volume override
+// of their channel still apply to them.
+func (p *Player) updateBackground() {
+	p.pruneBackground()
+	for i := range p.background {
+		bg := &p.background[i]
+		bg.gain = float64(bg.channelVolume) / 64 *
+			float64(p.globalVolume) / 128 *
+			float64(p.module.MixingVolume) / 128 *
+			p.tickOverrides[bg.channel].volume
+		bg.updateTick(p.rate, p.stereoMix())
+	}
+}
@@ -0,0 +1,109 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package render
+
+import (
+	"math"
+	"time"
+)
+
+// Controls how long the player plays a song. The zero value plays the song once.
+type PlaybackOptions struct {
+	// Number of extra times to play the song. The song loops where it would otherwise end:
+	// when it jumps back to a row that was already played, or when it reaches the end of
+	// the order list, which restarts from the first order. -1 loops forever.
+	Loops int
+
+	// After the last loop, keep playing for this long while fading out. 0 stops at the end
+	// of the song.
+	Fadeout time.Duration
+
+	// Stop after this much audio has been rendered, including the fadeout. 0 = no limit.
+	MaxDuration time.Duration
+}
+
+// Set the playback options. This resets the player to the start of the song.
+func (p *Player) SetPlaybackOptions(opts PlaybackOptions) {
+	p.options = opts
+	p.progressTotal = -1
+	p.reset()
+}
+
+// Convert a duration to frames at the output rate.
+func (p *Player) durationFrames(d time.Duration) int {
+	return int(math.Round(d.Seconds() * float64(p.rate)))
+}
+
+// Called when the song reaches its end. Returns true if playback continues with another
+// loop or the fadeout.
+func (p *Player) loopSong() bool {
+	p.recordLoop()
+
+	switch {
+	case p.loopsLeft != 0:
+		if p.loopsLeft > 0 {
+			p.loopsLeft--
+		}
+	case p.options.Fadeout > 0 && p.fadeTotal == 0:
+		p.fadeTotal = max(p.durationFrames(p.options.Fadeout), 1)
+		p.fadeLeft = p.fadeTotal
+	default:
+		return false
+	}
+
+	clear(p.visited)
+	if _, ok := p.resolveOrder(); !ok {
+		p.order = 0
+		p.row = 0
+	}
+	return true
+}
+
+// End playback.
+func (p *Player) stop() {
+	p.ended = true
+	p.midiEndOfSong()
+	p.hookSongEnd()
+}
+
+// Limit a number of frames to mix so the fadeout or the duration limit isn't passed.
+func (p *Player) framesUntilStop(frames int) int {
+	if p.fadeTotal > 0 {
+		frames = min(frames, p.fadeLeft)
+	}
+	if p.options.MaxDuration > 0 {
+		frames = min(frames, p.durationFrames(p.options.MaxDuration)-p.framesPlayed)
+	}
+	return max(frames, 0)
+}
+
+// Apply the fadeout to frames that were just mixed, and stop when the fadeout or the
+// duration limit ends.
+func (p *Player) finishFrames(mix []float64, offset int, frames int) {
+	p.framesPlayed += frames
+
+	if p.fadeTotal > 0 {
+		buffers := [][]float64{mix}
+		if p.stems != nil {
+			buffers = p.stems.buffers
+		}
+		for i := 0; i < frames; i++ {
+			gain := float64(p.fadeLeft-i) / float64(p.fadeTotal)
+			for _, buffer := range buffers {
+				buffer[(offset+i)*2] *= gain
+				buffer[(offset+i)*2+1] *= gain
+			}
+		}
+		p.fadeLeft -= frames
+		if p.fadeLeft <= 0 {
+			p.stop()
+			return
+		}
+	}
+
+	if p.options.MaxDuration > 0 && p.framesPlayed >= p.durationFrames(p.options.MaxDuration) {
+		p.stop()
+	}
+}
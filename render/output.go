@@ -0,0 +1,118 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package render
+
+import "math"
+
+// Channel layout of rendered output.
+type ChannelLayout int
+
+const (
+	// Interleaved left/right frames.
+	LayoutStereo ChannelLayout = iota
+
+	// One sample per frame, the average of left and right.
+	LayoutMono
+)
+
+// Number of samples in each frame of the layout.
+func (layout ChannelLayout) Channels() int {
+	if layout == LayoutMono {
+		return 1
+	}
+	return 2
+}
+
+// Range limits for integer output.
+const (
+	int16Max = 32767
+	int24Max = 8388607
+)
+
+// Set the channel layout used by the Render functions. The default is stereo.
+func (p *Player) SetChannelLayout(layout ChannelLayout) {
+	p.layout = layout
+}
+
+// Apply the channel layout and clip mode to a stereo mix, calling write for each output
+// sample.
+func (p *Player) writeOutput(mix []float64, frames int, write func(index int, v float64)) {
+	if p.layout == LayoutMono {
+		for i := 0; i < frames; i++ {
+			write(i, p.clip((mix[i*2]+mix[i*2+1])/2))
+		}
+		return
+	}
+	for i := 0; i < frames*2; i++ {
+		write(i, p.clip(mix[i]))
+	}
+}
+
+// Clip a sample to [-1, 1] and scale it to an integer range.
+func toInteger(v float64, limit int) int {
+	v = max(-1, min(v, 1))
+	return int(math.Round(v * float64(limit)))
+}
+
+// Render float32 audio in the current channel layout. Returns the number of frames
+// written, which is less than requested once the song ends.
+func (p *Player) Render(out []float32) int {
+	mix, frames := p.renderMix(len(out) / p.layout.Channels())
+	p.writeOutput(mix, frames, func(i int, v float64) {
+		out[i] = float32(v)
+	})
+	return frames
+}
+
+// Render signed 16-bit audio in the current channel layout. Returns the number of frames
+// written.
+func (p *Player) RenderInt16(out []int16) int {
+	mix, frames := p.renderMix(len(out) / p.layout.Channels())
+	p.writeOutput(mix, frames, func(i int, v float64) {
+		out[i] = int16(toInteger(v, int16Max))
+	})
+	return frames
+}
+
+// Render signed 24-bit audio in the current channel layout, packed into 3 little-endian
+// bytes per sample. Returns the number of frames written.
+func (p *Player) RenderInt24(out []byte) int {
+	mix, frames := p.renderMix(len(out) / 3 / p.layout.Channels())
+	p.writeOutput(mix, frames, func(i int, v float64) {
+		s := toInteger(v, int24Max)
+		out[i*3] = byte(s)
+		out[i*3+1] = byte(s >> 8)
+		out[i*3+2] = byte(s >> 16)
+	})
+	return frames
+}
+
+// Render float32 audio with each channel of the layout in its own slice, like Web Audio's
+// AudioBuffer. All slices must be the same length. Returns the number of frames written.
+func (p *Player) RenderPlanar(out [][]float32) int {
+	count := p.layout.Channels()
+	if len(out) < count {
+		return 0
+	}
+	mix, frames := p.renderMix(len(out[0]))
+	p.writeOutput(mix, frames, func(i int, v float64) {
+		out[i%count][i/count] = float32(v)
+	})
+	return frames
+}
+
+// Render the song in chunks of up to `frames` frames, passing each chunk of float32 audio
+// in the current channel layout to fn until the song ends or fn returns false. The chunk
+// is reused, so fn must copy what it keeps. This suits push-based outputs like a Web Audio
+// worklet or a network stream.
+func (p *Player) RenderChunks(frames int, fn func(chunk []float32) bool) {
+	buffer := make([]float32, frames*p.layout.Channels())
+	for {
+		n := p.Render(buffer)
+		if n == 0 || !fn(buffer[:n*p.layout.Channels()]) {
+			return
+		}
+	}
+}
@@ -0,0 +1,81 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package render
+
+// User overrides for a channel, e.g. from mixer controls in a player UI.
+type channelOverride struct {
+	mute   bool
+	solo   bool
+	volume float64 // Multiplier, 1 = unchanged
+	pan    int     // 0-64, -1 = not overridden
+}
+
+func defaultOverride() channelOverride {
+	return channelOverride{volume: 1, pan: -1}
+}
+
+// Change a channel's override while holding the lock. Invalid channels are ignored.
+func (p *Player) setOverride(channel int, set func(o *channelOverride)) {
+	p.overrideLock.Lock()
+	defer p.overrideLock.Unlock()
+	if channel >= 0 && channel < len(p.overrides) {
+		set(&p.overrides[channel])
+	}
+}
+
+// Mute or unmute a channel. This is separate from the mute flag in the module's channel
+// settings; a channel plays only if neither mutes it. Like the other channel overrides,
+// it's safe to call from another goroutine while rendering, and it takes effect on the
+// next tick. Overrides are kept when the player is reset or seeks.
+func (p *Player) SetChannelMute(channel int, mute bool) {
+	p.setOverride(channel, func(o *channelOverride) { o.mute = mute })
+}
+
+// Solo or unsolo a channel. While any channel is soloed, only soloed channels are heard.
+func (p *Player) SetChannelSolo(channel int, solo bool) {
+	p.setOverride(channel, func(o *channelOverride) { o.solo = solo })
+}
+
+// Scale a channel's output volume. 1 plays the channel as the song sets it.
+func (p *Player) SetChannelVolume(channel int, volume float64) {
+	p.setOverride(channel, func(o *channelOverride) { o.volume = max(volume, 0) })
+}
+
+// Force a channel's panning (0-64), ignoring pan changes from the song. -1 restores the
+// song's panning.
+func (p *Player) SetChannelPan(channel int, pan int) {
+	p.setOverride(channel, func(o *channelOverride) {
+		o.pan = -1
+		if pan >= 0 {
+			o.pan = min(pan, 64)
+		}
+	})
+}
+
+// Remove all mute, solo, volume, and pan overrides.
+func (p *Player) ClearChannelOverrides() {
+	p.overrideLock.Lock()
+	defer p.overrideLock.Unlock()
+	for i := range p.overrides {
+		p.overrides[i] = defaultOverride()
+	}
+}
+
+// Copy the overrides for the rendering thread, once per tick.
+func (p *Player) loadOverrides() {
+	p.overrideLock.Lock()
+	defer p.overrideLock.Unlock()
+	copy(p.tickOverrides, p.overrides)
+	p.soloActive = false
+	for _, o := range p.overrides {
+		p.soloActive = p.soloActive || o.solo
+	}
+}
+
+// True if a channel is silenced by the module settings or the overrides.
+func (p *Player) channelSilenced(ch *channel) bool {
+	o := &p.tickOverrides[ch.index]
+	return ch.muted || o.mute || (p.soloActive && !o.solo)
+}
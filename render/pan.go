@@ -0,0 +1,64 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package render
+
+import "math"
+
+// How a pan position is turned into left and right gains.
+type PanLaw int
+
+const (
+	// Gains change linearly with the pan, like Impulse Tracker and OpenMPT's defaults. A
+	// centered sound is -6 dB on each side.
+	PanLawLinear PanLaw = iota
+
+	// Constant power: the gains are the square roots of the linear ones, so a centered
+	// sound is -3 dB on each side and is as loud as a hard-panned one.
+	PanLawConstantPower
+)
+
+// Full stereo separation, as in the IT header.
+const maxSeparation = 128
+
+// Stereo settings passed to voices.
+type stereoMix struct {
+	separation int // 0-128
+	law        PanLaw
+}
+
+var fullStereo = stereoMix{separation: maxSeparation}
+
+// Set the pan law. The default is PanLawLinear.
+func (p *Player) SetPanLaw(law PanLaw) {
+	p.panLaw = law
+}
+
+// Set the stereo separation from 0 (mono) to 128 (full). -1 uses the module's
+// PanSeparation, which is the default.
+func (p *Player) SetStereoSeparation(separation int) {
+	p.separation = max(-1, min(separation, maxSeparation))
+}
+
+func (p *Player) stereoMix() stereoMix {
+	separation := p.separation
+	if separation < 0 {
+		separation = max(0, min(int(p.module.PanSeparation), maxSeparation))
+	}
+	return stereoMix{separation: separation, law: p.panLaw}
+}
+
+// Left and right gains for a pan position (0-64). The separation narrows the pan toward
+// the center.
+func (s stereoMix) gains(pan float64) (left, right float64) {
+	if s.separation != maxSeparation {
+		pan = 32 + float64((pan-32)*float64(s.separation))/maxSeparation
+	}
+	left, right = (64-pan)/64, pan/64
+	if s.law == PanLawConstantPower {
+		// Sqrt is correctly rounded everywhere, so this is deterministic.
+		left, right = math.Sqrt(left), math.Sqrt(right)
+	}
+	return
+}
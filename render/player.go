@@ -0,0 +1,455 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package render
+
+import (
+	"log/slog"
+	"sync"
+	"sync/atomic"
+
+	"go.mukunda.com/modlib/common"
+)
+
+// Rows in a pattern that is referenced by the order list but doesn't exist.
+const emptyPatternRows = 64
+
+// Plays a module. Create it with NewPlayer and pull audio with Render. The song plays
+// through the order list once; playback ends when the end of the order list is reached or
+// the song jumps back to a row that has already been played.
+//
+// RenderInt16 and RenderInt24 pull audio in integer formats. Use SetPlaybackOptions to
+// loop the song or limit its length.
+type Player struct {
+	module   *common.Module
+	behavior EffectBehavior
+	rate     int
+	samples  []*samplePCM // Float PCM for each sample
+
+	channels []channel
+
+	// Notes left playing by new note actions.
+	background []backgroundVoice
+	voiceLimit int // 0 = DefaultVoiceLimit
+
+	// Position of the row being played.
+	order int
+	row   int
+	tick  int
+
+	// Position of the row that was last entered, while order/row point at the next one.
+	rowOrder int
+	rowIndex int
+
+	speed        int
+	tempo        int
+	globalVolume int // 0-128
+
+	rowDelay     int  // Extra repeats of the current row (SEx)
+	rowRepeat    int  // Current repeat of a delayed row
+	tickDelay    int  // Extra ticks added to the current row (S6x)
+	jumpOrder    int  // Pending order jump (Bxx), -1 = none
+	breakRow     int  // Pending pattern break (Cxx), -1 = none
+	loopJump     bool // The pending jump is a pattern loop (SBx)
+	loopJumpRow  int
+	sharedLoop   patternLoop // Pattern loop state when it isn't kept per channel.
+	ended        bool
+	visited      map[[2]int]int // Frame where each played row started
+	tickFraction float64        // Leftover fraction of a frame from the tick timing.
+	tickFrames   int            // Frames left in the current tick.
+
+	// Set while producing MIDI events instead of audio.
+	midi   *midiState
+	macros MidiMacros
+
+	randomSeed  uint32
+	randomState uint32
+
+	layout     ChannelLayout
+	panLaw     PanLaw
+	separation int // -1 = the module's PanSeparation
+	mixBuffer  []float64
+	clipMode   ClipMode
+	clipStats  ClipStats
+	clipping   bool // The last output sample was clipped.
+	stems      *stemRouter
+
+	options      PlaybackOptions
+	loopsLeft    int // Remaining loops, -1 = forever.
+	fadeTotal    int // Length of the fadeout in frames, 0 = not fading.
+	fadeLeft     int
+	framesPlayed int
+	loop         *LoopPoint // Set when the song first reaches its end.
+
+	logger        *slog.Logger
+	progress      common.ProgressFunc
+	progressTotal int // Estimated frames for the progress callback, -1 = not computed yet.
+
+	overrideLock  sync.Mutex
+	overrides     []channelOverride // Set by the user, guarded by overrideLock.
+	tickOverrides []channelOverride // Copy used while rendering a tick.
+	soloActive    bool
+
+	scopeLock     sync.Mutex
+	scopes        []channelScope // Guarded by scopeLock.
+	scopesEnabled atomic.Bool
+	scopeBuffer   []float64
+
+	hooks     PlayerHooks
+	lastOrder int // Order of the previous row, for OnPatternChange.
+	seeking   bool
+}
+
+// Create a player for a module. rate is the output sample rate.
+func NewPlayer(m *common.Module, rate int) *Player {
+	p := &Player{
+		module:   m,
+		behavior: DefaultEffectBehavior(m),
+		rate:     rate,
+		macros:   DefaultMidiMacros(),
+
+		separation:    -1,
+		progressTotal: -1,
+	}
+
+	if details, ok := m.Details.(*common.ItDetails); ok && details.Macros != nil {
+		p.macros = *details.Macros
+	}
+
+	for i := range m.Samples {
+		p.samples = append(p.samples, newSamplePCM(&m.Samples[i]))
+	}
+
+	p.reset()
+
+	p.overrides = make([]channelOverride, len(p.channels))
+	for i := range p.overrides {
+		p.overrides[i] = defaultOverride()
+	}
+	p.tickOverrides = make([]channelOverride, len(p.channels))
+	p.loadOverrides()
+	return p
+}
+
+// Put the song and all channels back to their initial state.
+func (p *Player) reset() {
+	m := p.module
+	p.order = 0
+	p.row = 0
+	p.tick = 0
+	p.speed = max(int(m.InitialSpeed), 1)
+	p.tempo = max(int(m.InitialTempo), 32)
+	p.globalVolume = int(m.GlobalVolume)
+	p.rowDelay = 0
+	p.rowRepeat = 0
+	p.tickDelay = 0
+	p.jumpOrder = -1
+	p.breakRow = -1
+	p.loopJump = false
+	p.sharedLoop = patternLoop{count: -1}
+	p.ended = false
+	p.visited = make(map[[2]int]int)
+	p.loop = nil
+	p.tickFraction = 0
+	p.tickFrames = 0
+	p.randomState = p.randomSeed
+	p.lastOrder = -1
+	p.loopsLeft = p.options.Loops
+	p.fadeTotal = 0
+	p.fadeLeft = 0
+	p.framesPlayed = 0
+	p.clipStats = ClipStats{}
+	p.clipping = false
+
+	p.background = nil
+	p.channels = make([]channel, max(int(m.Channels), len(m.ChannelSettings)))
+	for i := range p.channels {
+		ch := &p.channels[i]
+		ch.reset(i)
+		if i < len(m.ChannelSettings) {
+			setting := &m.ChannelSettings[i]
+			ch.channelVolume = int(setting.InitialVolume)
+			ch.pan = int(setting.InitialPan)
+			ch.surround = setting.Surround
+			ch.muted = setting.Mute
+		}
+	}
+}
+
+// True once the song has finished.
+func (p *Player) Ended() bool {
+	return p.ended
+}
+
+// The sample rate that the player renders at.
+func (p *Player) SampleRate() int {
+	return p.rate
+}
+
+// Rows in a pattern, including patterns missing from the module.
+func (p *Player) patternRows(pattern int) []common.PatternRow {
+	if pattern < len(p.module.Patterns) {
+		return p.module.Patterns[pattern].Rows
+	}
+	return nil
+}
+
+// Number of rows in a pattern.
+func (p *Player) patternLength(pattern int) int {
+	if pattern < len(p.module.Patterns) {
+		return len(p.module.Patterns[pattern].Rows)
+	}
+	return emptyPatternRows
+}
+
+// Skip "+++" entries and find the pattern at the current order. Returns false at the end of
+// the song.
+func (p *Player) resolveOrder() (int, bool) {
+	for p.order < len(p.module.Order) {
+		pattern := int(p.module.Order[p.order])
+		if pattern == common.OrderEnd {
+			return 0, false
+		}
+		if pattern != common.OrderSkip {
+			return pattern, true
+		}
+		p.order++
+		p.row = 0
+	}
+	return 0, false
+}
+
+// Start playing the row at the current position. Returns false if the song has ended.
+func (p *Player) enterRow() bool {
+	pattern, ok := p.resolveOrder()
+	if !ok {
+		p.debug("song end", "order", p.order, "reason", "end of order list")
+		return false
+	}
+
+	if p.row >= p.patternLength(pattern) {
+		p.row = 0
+	}
+
+	position := [2]int{p.order, p.row}
+	if _, ok := p.visited[position]; ok {
+		p.debug("song end", "order", p.order, "row", p.row, "reason", "row already played")
+		return false
+	}
+	p.visited[position] = p.framesPlayed
+	p.rowOrder, p.rowIndex = p.order, p.row
+	p.hookRow(pattern)
+
+	var entries []common.PatternEntry
+	if rows := p.patternRows(pattern); p.row < len(rows) {
+		entries = rows[p.row].Entries
+	}
+
+	for i := range p.channels {
+		p.channels[i].entry = common.PatternEntry{Channel: uint8(i)}
+	}
+	for _, entry := range entries {
+		if int(entry.Channel) < len(p.channels) {
+			p.channels[entry.Channel].entry = entry
+		}
+	}
+
+	for i := range p.channels {
+		p.startRow(&p.channels[i], false)
+	}
+
+	return true
+}
+
+// Move to the next row, following any jumps from the row that just finished.
+func (p *Player) advanceRow() {
+	if p.loopJump {
+		// Rows inside of the loop are played again.
+		for row := p.loopJumpRow; row <= p.row; row++ {
+			delete(p.visited, [2]int{p.order, row})
+		}
+		p.debug("pattern loop", "order", p.order, "from", p.row, "to", p.loopJumpRow)
+		p.row = p.loopJumpRow
+		p.loopJump = false
+		p.jumpOrder = -1
+		p.breakRow = -1
+		return
+	}
+
+	if p.jumpOrder >= 0 || p.breakRow >= 0 {
+		p.debug("jump", "order", p.order, "row", p.row, "jump_order", p.jumpOrder, "break_row", p.breakRow)
+		if p.jumpOrder >= 0 {
+			p.order = p.jumpOrder
+		} else {
+			p.order++
+		}
+		p.row = max(p.breakRow, 0)
+		p.jumpOrder = -1
+		p.breakRow = -1
+		p.resetPatternLoops()
+		return
+	}
+
+	p.row++
+	pattern, ok := p.resolveOrder()
+	if ok && p.row >= p.patternLength(pattern) {
+		p.order++
+		p.row = 0
+		p.resetPatternLoops()
+	}
+}
+
+// Move the pattern loop starts back to the first row when a new pattern starts, unless
+// the tracker keeps them.
+func (p *Player) resetPatternLoops() {
+	if p.behavior.KeepLoopStart {
+		return
+	}
+	p.sharedLoop = patternLoop{count: -1}
+	for i := range p.channels {
+		p.channels[i].loop = patternLoop{count: -1}
+	}
+}
+
+// Process one tick of the song: read the pattern row on the first tick, update effects,
+// and prepare voices for mixing.
+func (p *Player) processTick() {
+	if p.ended {
+		return
+	}
+
+	if p.tick == 0 && p.rowRepeat == 0 {
+		if !p.enterRow() && !(p.loopSong() && p.enterRow()) {
+			p.stop()
+			return
+		}
+	} else if p.tick == 0 && p.behavior.RowDelayRepeatsRow {
+		for i := range p.channels {
+			p.startRow(&p.channels[i], true)
+		}
+	} else {
+		for i := range p.channels {
+			p.updateEffects(&p.channels[i])
+		}
+	}
+
+	p.loadOverrides()
+	for i := range p.channels {
+		p.updateVoice(&p.channels[i])
+	}
+	p.updateBackground()
+
+	p.tick++
+	if p.tick >= p.speed+p.tickDelay {
+		p.tick = 0
+		if p.rowRepeat < p.rowDelay {
+			p.rowRepeat++
+		} else {
+			p.rowRepeat = 0
+			p.rowDelay = 0
+			p.tickDelay = 0
+			p.advanceRow()
+		}
+	}
+}
+
+// Mix all voices into the mixing buffer, starting at the given frame. When rendering stems,
+// each channel goes to its own buffer instead.
+func (p *Player) mixVoices(mix []float64, offset int, frames int) {
+	scoping := p.scopesEnabled.Load()
+	if scoping && cap(p.scopeBuffer) < frames*2 {
+		p.scopeBuffer = make([]float64, frames*2)
+	}
+
+	for i := range p.channels {
+		ch := &p.channels[i]
+		if p.channelSilenced(ch) {
+			if scoping && !p.seeking {
+				p.recordScope(ch.index, nil, frames)
+			}
+			continue
+		}
+
+		out := mix
+		if p.stems != nil {
+			if out = p.stems.buffer(ch); out == nil {
+				continue
+			}
+		}
+
+		if p.seeking {
+			p.mixChannel(ch, nil, frames)
+			continue
+		}
+
+		if !scoping {
+			p.mixChannel(ch, out[offset*2:], frames)
+			continue
+		}
+
+		// Mix separately to capture the channel's output.
+		buffer := p.scopeBuffer[:frames*2]
+		clear(buffer)
+		p.mixChannel(ch, buffer, frames)
+		for j, v := range buffer {
+			out[offset*2+j] += v
+		}
+		p.recordScope(ch.index, buffer, frames)
+	}
+}
+
+// Mix the voice of a channel and its background voices.
+func (p *Player) mixChannel(ch *channel, out []float64, frames int) {
+	ch.voice.mix(out, frames)
+	for i := range p.background {
+		if bg := &p.background[i]; bg.channel == ch.index {
+			bg.mix(out, frames)
+		}
+	}
+}
+
+// Start the next tick and compute its length in frames.
+func (p *Player) startTick() {
+	p.processTick()
+	if p.ended {
+		return
+	}
+	p.tickFraction += framesPerTick(p.rate, p.tempo)
+	p.tickFrames = int(p.tickFraction)
+	p.tickFraction -= float64(p.tickFrames)
+}
+
+// Run the song for up to the given number of frames, mixing into a stereo buffer. Returns
+// the mix and the number of frames produced, which is less than requested once the song
+// ends.
+func (p *Player) renderMix(frames int) ([]float64, int) {
+	if cap(p.mixBuffer) < frames*2 {
+		p.mixBuffer = make([]float64, frames*2)
+	}
+	mix := p.mixBuffer[:frames*2]
+	clear(mix)
+
+	done := 0
+	for done < frames {
+		if p.tickFrames == 0 {
+			p.startTick()
+			if p.ended {
+				break
+			}
+			continue
+		}
+
+		n := p.framesUntilStop(min(frames-done, p.tickFrames))
+		p.mixVoices(mix, done, n)
+		p.tickFrames -= n
+		p.finishFrames(mix, done, n)
+		done += n
+		if p.ended {
+			break
+		}
+	}
+
+	p.reportProgress()
+	return mix, done
+}
@@ -0,0 +1,61 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package render
+
+import (
+	"math"
+
+	"go.mukunda.com/modlib/common"
+)
+
+// Longest release tail rendered after a preview note is let go, in seconds.
+const previewMaxRelease = 5.0
+
+// Render a single note (0-119, 60 = C-5) of an instrument. The note is held for
+// durationSec and released on the next tick, so the sustain loops end and the fadeout and
+// envelopes can play out, up to a few seconds. ins may be nil to play the sample alone. The note is
+// passed through the instrument's notemap for the pitch, but the sample is used as given.
+//
+// The result is stereo interleaved float32 at DefaultSampleRate, timed with
+// DefaultTempo. FM (AdLib) instruments aren't synthesized yet and render as silence.
+func InstrumentPreview(ins *common.Instrument, sample *common.Sample, note int, durationSec float64) []float32 {
+	if ins != nil && ins.FM != nil {
+		return make([]float32, int(math.Round(durationSec*DefaultSampleRate))*2)
+	}
+
+	if ins != nil && note >= 0 && note < len(ins.Notemap) {
+		note = int(ins.Notemap[note].Note)
+	}
+
+	rate := DefaultSampleRate
+	tickLength := framesPerTick(rate, DefaultTempo)
+	holdFrames := int(math.Round(durationSec * float64(rate)))
+	maxFrames := holdFrames + int(previewMaxRelease*float64(rate))
+
+	var v voice
+	v.trigger(ins, sample, newSamplePCM(sample), note)
+
+	var mix []float64
+	tickPos := 0.0
+	for frame := 0; frame < maxFrames && v.active; {
+		if frame >= holdFrames && v.keyOn {
+			v.release()
+		}
+
+		v.updateTick(rate, fullStereo)
+
+		tickPos += tickLength
+		end := min(int(tickPos), maxFrames)
+		mix = append(mix, make([]float64, (end-frame)*2)...)
+		v.mix(mix[frame*2:], end-frame)
+		frame = end
+	}
+
+	if len(mix) < holdFrames*2 {
+		mix = append(mix, make([]float64, holdFrames*2-len(mix))...)
+	}
+
+	return toFloat32(mix)
+}
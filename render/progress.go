@@ -0,0 +1,78 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package render
+
+import (
+	"math"
+
+	"go.mukunda.com/modlib/common"
+)
+
+// Report rendering progress to fn as frames rendered out of the expected total, under
+// common.StageRender. The total is estimated by simulating the song with the current
+// playback options; it's 0 when the song loops forever without a MaxDuration. nil removes
+// the callback.
+func (p *Player) SetProgress(fn common.ProgressFunc) {
+	p.progress = fn
+	p.progressTotal = -1
+}
+
+// Estimate the number of frames the song will render with the current settings.
+func (p *Player) estimateFrames() int {
+	if p.options.Loops < 0 && p.options.MaxDuration <= 0 {
+		return 0
+	}
+
+	sim := &Player{
+		module:     p.module,
+		behavior:   p.behavior,
+		rate:       p.rate,
+		samples:    p.samples,
+		macros:     p.macros,
+		separation: p.separation,
+		randomSeed: p.randomSeed,
+		options:    p.options,
+	}
+	sim.options.Fadeout = 0
+	sim.reset()
+	sim.overrides = make([]channelOverride, len(sim.channels))
+	for i := range sim.overrides {
+		sim.overrides[i] = defaultOverride()
+	}
+	sim.tickOverrides = make([]channelOverride, len(sim.channels))
+
+	seconds := 0.0
+	for tick := range sim.Ticks() {
+		seconds += 2.5 / float64(tick.Tempo)
+	}
+
+	frames := int(math.Round(seconds * float64(p.rate)))
+	if p.options.Fadeout > 0 {
+		frames += max(p.durationFrames(p.options.Fadeout), 1)
+	}
+	if p.options.MaxDuration > 0 {
+		frames = min(frames, p.durationFrames(p.options.MaxDuration))
+	}
+	return frames
+}
+
+// Report progress after rendering. The last report has done == total.
+func (p *Player) reportProgress() {
+	if p.progress == nil || p.seeking {
+		return
+	}
+	if p.progressTotal < 0 {
+		p.progressTotal = p.estimateFrames()
+	}
+
+	total := p.progressTotal
+	if total > 0 && p.framesPlayed > total {
+		total = p.framesPlayed
+	}
+	if p.ended {
+		total = p.framesPlayed
+	}
+	p.progress.Report(common.StageRender, p.framesPlayed, total)
+}
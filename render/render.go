@@ -0,0 +1,32 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+/*
+This package renders modules and instruments into PCM audio. Playback follows Impulse
+Tracker semantics, since the common model is based on IT.
+
+Rendering is deterministic: the same module and settings produce bit-identical output on
+every platform.
+*/
+package render
+
+// Output sample rate used when one isn't specified.
+const DefaultSampleRate = 44100
+
+// Tempo (BPM) used when timing isn't driven by a song.
+const DefaultTempo = 125
+
+// Number of output frames in one tick. IT ticks last 2.5/tempo seconds.
+func framesPerTick(rate int, tempo int) float64 {
+	return float64(rate) * 2.5 / float64(tempo)
+}
+
+// Convert a float64 mixing buffer into float32 output.
+func toFloat32(mix []float64) []float32 {
+	out := make([]float32, len(mix))
+	for i, v := range mix {
+		out[i] = float32(v)
+	}
+	return out
+}
@@ -0,0 +1,1259 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package render
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mukunda.com/modlib/common"
+	"go.mukunda.com/modlib/itmod"
+)
+
+// A looped 8-bit square wave sample.
+func squareSample() common.Sample {
+	pcm := make([]int8, 64)
+	for i := range pcm {
+		pcm[i] = 64
+		if i >= 32 {
+			pcm[i] = -64
+		}
+	}
+
+	return common.Sample{
+		Name:          "square",
+		GlobalVolume:  64,
+		DefaultVolume: 64,
+		C5:            8363,
+		Loop:          true,
+		LoopStart:     0,
+		LoopEnd:       64,
+		Data:          common.SampleData{Channels: 1, Bits: 8, Data: []any{pcm}},
+	}
+}
+
+func fadeInstrument() common.Instrument {
+	ins := common.Instrument{
+		GlobalVolume: 128,
+		Fadeout:      64,
+	}
+	for i := range ins.Notemap {
+		ins.Notemap[i] = common.NotemapEntry{Note: int16(i), Sample: 1}
+	}
+	return ins
+}
+
+func TestInstrumentPreview(t *testing.T) {
+	sample := squareSample()
+	ins := fadeInstrument()
+
+	pcm := InstrumentPreview(&ins, &sample, 60, 0.5)
+
+	holdFrames := DefaultSampleRate / 2
+	assert.Greater(t, len(pcm), holdFrames*2, "release tail should be rendered")
+	assert.Less(t, len(pcm), holdFrames*2+DefaultSampleRate, "fadeout should end the note")
+
+	// Centered mono sample at full volume.
+	assert.InDelta(t, 0.25, pcm[0], 1e-6)
+	assert.InDelta(t, 0.25, pcm[1], 1e-6)
+
+	// Without an instrument, the looping sample plays until the release limit.
+	pcm = InstrumentPreview(nil, &sample, 60, 0.1)
+	assert.Equal(t, int(DefaultSampleRate*(0.1+previewMaxRelease))*2, len(pcm))
+}
+
+func TestSynthInstrument(t *testing.T) {
+	sample := squareSample()
+	ins := fadeInstrument()
+	ins.Synth = &common.SynthPatch{
+		WaveLength:       5,
+		PerformanceSpeed: 1,
+		Performance: []common.SynthStep{
+			{Waveform: common.SynthWaveSquare},
+			{Note: 12},
+		},
+	}
+
+	pcm := InstrumentPreview(&ins, &sample, 84, 0.1)
+	tick := int(framesPerTick(DefaultSampleRate, DefaultTempo))
+
+	// The synth's square wave plays instead of the sample, starting low at full volume.
+	assert.InDelta(t, -0.5, pcm[0], 1e-6)
+
+	// Each tick runs a step: the second raises the note an octave.
+	crossings := func(frames []float32) int {
+		n := 0
+		for i := 2; i < len(frames); i += 2 {
+			if frames[i-2] < 0 != (frames[i] < 0) {
+				n++
+			}
+		}
+		return n
+	}
+	first := crossings(pcm[:tick*2])
+	second := crossings(pcm[tick*2 : tick*4])
+	assert.Greater(t, first, 4)
+	assert.InDelta(t, first*2, second, 2)
+}
+
+// A module with one looped sample and a single pattern.
+func testModule(rows []common.PatternRow) *common.Module {
+	m := &common.Module{
+		Source:         common.ItSource,
+		GlobalVolume:   128,
+		MixingVolume:   128,
+		InitialSpeed:   6,
+		InitialTempo:   125,
+		PanSeparation:  128,
+		StereoMixing:   true,
+		UseInstruments: true,
+		LinearSlides:   true,
+		Channels:       2,
+		ChannelSettings: []common.ChannelSetting{
+			{InitialVolume: 64, InitialPan: 32},
+			{InitialVolume: 64, InitialPan: 32},
+		},
+		Order:       []int16{0},
+		Instruments: []common.Instrument{fadeInstrument()},
+		Samples:     []common.Sample{squareSample()},
+		Patterns:    []common.Pattern{{Channels: 2, Rows: rows}},
+	}
+	return m
+}
+
+// Render a whole song, up to a limit.
+func renderAll(p *Player, maxFrames int) []float32 {
+	var result []float32
+	buffer := make([]float32, 1024*2)
+	for len(result) < maxFrames*2 {
+		n := p.Render(buffer)
+		result = append(result, buffer[:n*2]...)
+		if n < 1024 {
+			break
+		}
+	}
+	return result
+}
+
+func TestPlayer(t *testing.T) {
+	rows := make([]common.PatternRow, 4)
+	rows[0].Entries = []common.PatternEntry{{Channel: 0, Note: 61, Instrument: 1}}
+	rows[2].Entries = []common.PatternEntry{{Channel: 0, Note: noteCut}}
+	m := testModule(rows)
+
+	p := NewPlayer(m, DefaultSampleRate)
+	pcm := renderAll(p, DefaultSampleRate*10)
+
+	// 4 rows of 6 ticks at 125 BPM.
+	assert.Equal(t, 4*6*int(framesPerTick(DefaultSampleRate, 125))*2, len(pcm))
+	assert.True(t, p.Ended())
+
+	rowFrames := 6 * int(framesPerTick(DefaultSampleRate, 125))
+	assert.NotZero(t, pcm[2])
+	assert.Zero(t, pcm[rowFrames*2*3], "note should be cut on row 2")
+}
+
+func TestPlayerJumpLoop(t *testing.T) {
+	rows := make([]common.PatternRow, 64)
+	rows[1].Entries = []common.PatternEntry{{Channel: 0, Effect: effectB, EffectParam: 0}}
+	m := testModule(rows)
+
+	// The jump back to the start is detected as the end of the song.
+	p := NewPlayer(m, DefaultSampleRate)
+	pcm := renderAll(p, DefaultSampleRate*10)
+	assert.Equal(t, 2*6*int(framesPerTick(DefaultSampleRate, 125))*2, len(pcm))
+}
+
+func TestMidiEvents(t *testing.T) {
+	rows := make([]common.PatternRow, 4)
+	rows[0].Entries = []common.PatternEntry{{Channel: 1, Note: 61, Instrument: 1, VolumeCommand: common.VcmdSetVolume, VolumeParam: 32}}
+	rows[1].Entries = []common.PatternEntry{{Channel: 1, Effect: effectZ, EffectParam: 0x10}}
+	rows[2].Entries = []common.PatternEntry{{Channel: 1, Note: noteOff}}
+	m := testModule(rows)
+	m.Instruments[0].MidiChannel = 3
+	m.Instruments[0].MidiProgram = 5
+	m.Instruments[0].MidiBank = 0xFFFF
+
+	p := NewPlayer(m, DefaultSampleRate)
+	var events []MidiEvent
+	for event := range p.MidiEvents() {
+		events = append(events, event)
+	}
+
+	assert.Equal(t, []MidiEvent{
+		{Time: 0, Message: []byte{0xC2, 5}},
+		{Time: 0, Message: []byte{0x92, 60, 64}},
+		{Time: 240 * time.Millisecond, Message: []byte{0x82, 60, 0}},
+	}, events)
+
+	// Macros embedded in the module replace the defaults.
+	macros := DefaultMidiMacros()
+	macros.Parametric[0] = "Bc07z"
+	macros.Parametric[1] = "F0F001z"
+	m.Details = &common.ItDetails{Macros: &macros}
+	rows[2].Entries[0].Effect, rows[2].Entries[0].EffectParam = effectS, 0xF1
+	rows[3].Entries = []common.PatternEntry{{Channel: 1, Effect: effectZ, EffectParam: 0x7F}}
+	p = NewPlayer(m, DefaultSampleRate)
+	events = nil
+	for event := range p.MidiEvents() {
+		events = append(events, event)
+	}
+	assert.Equal(t, MidiEvent{Time: 120 * time.Millisecond, Message: []byte{0xB2, 7, 0x10}}, events[2])
+	assert.Len(t, events, 4)
+
+	p = NewPlayer(m, DefaultSampleRate)
+	renderAll(p, DefaultSampleRate)
+	assert.Equal(t, 0x7F, p.channels[1].resonance)
+}
+
+func TestOutputFormats(t *testing.T) {
+	rows := make([]common.PatternRow, 2)
+	rows[0].Entries = []common.PatternEntry{{Channel: 0, Note: 61, Instrument: 1}}
+
+	reference := make([]float32, 1000*2)
+	NewPlayer(testModule(rows), DefaultSampleRate).Render(reference)
+
+	ints := make([]int16, 1000*2)
+	assert.Equal(t, 1000, NewPlayer(testModule(rows), DefaultSampleRate).RenderInt16(ints))
+
+	packed := make([]byte, 1000*3)
+	p := NewPlayer(testModule(rows), DefaultSampleRate)
+	p.SetChannelLayout(LayoutMono)
+	assert.Equal(t, 1000, p.RenderInt24(packed))
+
+	for i := 0; i < 1000; i++ {
+		assert.Equal(t, int16(math.Round(float64(reference[i*2])*32767)), ints[i*2])
+
+		mono := (float64(reference[i*2]) + float64(reference[i*2+1])) / 2
+		s24 := int32(uint32(packed[i*3])<<8|uint32(packed[i*3+1])<<16|uint32(packed[i*3+2])<<24) >> 8
+		assert.InDelta(t, mono*8388607, float64(s24), 1)
+	}
+
+	planar := [][]float32{make([]float32, 1000), make([]float32, 1000)}
+	assert.Equal(t, 1000, NewPlayer(testModule(rows), DefaultSampleRate).RenderPlanar(planar))
+	for i := 0; i < 1000; i++ {
+		assert.Equal(t, reference[i*2], planar[0][i])
+		assert.Equal(t, reference[i*2+1], planar[1][i])
+	}
+
+	var chunked []float32
+	NewPlayer(testModule(rows), DefaultSampleRate).RenderChunks(300, func(chunk []float32) bool {
+		assert.LessOrEqual(t, len(chunk), 600)
+		chunked = append(chunked, chunk...)
+		return len(chunked) < len(reference)
+	})
+	assert.Equal(t, reference, chunked[:len(reference)])
+}
+
+func TestStems(t *testing.T) {
+	rows := make([]common.PatternRow, 4)
+	rows[0].Entries = []common.PatternEntry{
+		{Channel: 0, Note: 61, Instrument: 1},
+		{Channel: 1, Note: 73, Instrument: 1},
+	}
+	m := testModule(rows)
+	m.Channels = 3
+	m.ChannelSettings = append(m.ChannelSettings, common.ChannelSetting{InitialVolume: 64, InitialPan: 32})
+
+	dir := t.TempDir()
+	paths, err := Stems(m, StemOptions{Directory: dir, Prefix: "song-"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		filepath.Join(dir, "song-channel01.wav"),
+		filepath.Join(dir, "song-channel02.wav"),
+	}, paths, "the silent third channel should be skipped")
+
+	data, err := os.ReadFile(paths[0])
+	assert.NoError(t, err)
+	frames := 4 * 6 * int(framesPerTick(DefaultSampleRate, 125))
+	assert.Equal(t, common.WavHeaderSize+frames*4, len(data))
+	assert.Equal(t, uint32(frames*4), binary.LittleEndian.Uint32(data[40:]))
+
+	paths, err = Stems(m, StemOptions{Directory: dir, ByInstrument: true})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(dir, "instrument01.wav")}, paths)
+}
+
+func TestSeek(t *testing.T) {
+	rows := make([]common.PatternRow, 8)
+	rows[0].Entries = []common.PatternEntry{{Channel: 0, Note: 61, Instrument: 1, Effect: effectA, EffectParam: 3}}
+	rows[1].Entries = []common.PatternEntry{{Channel: 0, Effect: effectD, EffectParam: 0x04}}
+	rows[3].Entries = []common.PatternEntry{{Channel: 1, Note: 49, Instrument: 1}}
+	m := testModule(rows)
+
+	full := renderAll(NewPlayer(m, DefaultSampleRate), DefaultSampleRate*10)
+
+	p := NewPlayer(m, DefaultSampleRate)
+	assert.NoError(t, p.Seek(0, 4))
+	order, row := p.Position()
+	assert.Equal(t, []int{0, 4}, []int{order, row})
+
+	tail := renderAll(p, DefaultSampleRate*10)
+	rowFrames := 3 * int(framesPerTick(DefaultSampleRate, 125))
+	assert.Equal(t, full[len(full)-4*rowFrames*2:], tail, "seeking should match playing through")
+
+	assert.ErrorIs(t, p.Seek(0, 9), ErrPositionNotFound)
+	order, row = p.Position()
+	assert.Equal(t, []int{0, 0}, []int{order, row})
+}
+
+func TestHooks(t *testing.T) {
+	rows := make([]common.PatternRow, 3)
+	rows[1].Entries = []common.PatternEntry{{Channel: 1, Note: 61, Instrument: 1}}
+	m := testModule(rows)
+	m.Order = []int16{0, 254, 0}
+
+	var events []string
+	p := NewPlayer(m, DefaultSampleRate)
+	p.SetHooks(PlayerHooks{
+		OnRow:           func(order, row int) { events = append(events, fmt.Sprint("row ", order, row)) },
+		OnNote:          func(ch, note, ins int) { events = append(events, fmt.Sprint("note ", ch, note, ins)) },
+		OnPatternChange: func(order, pattern int) { events = append(events, fmt.Sprint("pattern ", order, pattern)) },
+		OnSongEnd:       func() { events = append(events, "end") },
+	})
+	renderAll(p, DefaultSampleRate*10)
+
+	assert.Equal(t, []string{
+		"pattern 0 0", "row 0 0", "row 0 1", "note 1 60 1", "row 0 2",
+		"pattern 2 0", "row 2 0", "row 2 1", "note 1 60 1", "row 2 2",
+		"end",
+	}, events)
+}
+
+func TestPlaybackOptions(t *testing.T) {
+	rows := make([]common.PatternRow, 4)
+	rows[0].Entries = []common.PatternEntry{{Channel: 0, Note: 61, Instrument: 1}}
+	songFrames := 4 * 6 * 882
+
+	p := NewPlayer(testModule(rows), DefaultSampleRate)
+	assert.Len(t, renderAll(p, DefaultSampleRate*10), songFrames*2)
+
+	p.SetPlaybackOptions(PlaybackOptions{Loops: 2})
+	assert.Len(t, renderAll(p, DefaultSampleRate*10), songFrames*3*2)
+
+	p.SetPlaybackOptions(PlaybackOptions{Loops: 1, Fadeout: 100 * time.Millisecond})
+	var ended int
+	p.SetHooks(PlayerHooks{OnSongEnd: func() { ended++ }})
+	out := renderAll(p, DefaultSampleRate*10)
+	assert.Len(t, out, (songFrames*2+4410)*2)
+	assert.Equal(t, 1, ended)
+	peak := func(s []float32) float32 {
+		var result float32
+		for _, v := range s {
+			result = max(result, v, -v)
+		}
+		return result
+	}
+	assert.Greater(t, peak(out[songFrames*4:songFrames*4+200]), float32(0.1))
+	assert.Less(t, peak(out[len(out)-200:]), float32(0.01))
+
+	p.SetPlaybackOptions(PlaybackOptions{Loops: -1, MaxDuration: 250 * time.Millisecond})
+	assert.Len(t, renderAll(p, DefaultSampleRate*10), 11025*2)
+	assert.True(t, p.Ended())
+}
+
+func TestChannelOverrides(t *testing.T) {
+	rows := make([]common.PatternRow, 4)
+	rows[0].Entries = []common.PatternEntry{
+		{Channel: 0, Note: 61, Instrument: 1},
+		{Channel: 1, Note: 61, Instrument: 1},
+	}
+	m := testModule(rows)
+
+	levels := func(p *Player) (left, right float32) {
+		for i, v := range renderAll(p, 2000) {
+			if i%2 == 0 {
+				left = max(left, v)
+			} else {
+				right = max(right, v)
+			}
+		}
+		return
+	}
+
+	p := NewPlayer(m, DefaultSampleRate)
+	bothLeft, bothRight := levels(p)
+	assert.InDelta(t, bothLeft, bothRight, 0.0001)
+
+	p = NewPlayer(m, DefaultSampleRate)
+	p.SetChannelMute(0, true)
+	left, _ := levels(p)
+	assert.InDelta(t, bothLeft/2, left, 0.0001)
+
+	p = NewPlayer(m, DefaultSampleRate)
+	p.SetChannelSolo(1, true)
+	p.SetChannelPan(1, 0)
+	left, right := levels(p)
+	assert.InDelta(t, bothLeft, left, 0.0001)
+	assert.Zero(t, right)
+
+	p = NewPlayer(m, DefaultSampleRate)
+	p.SetChannelVolume(0, 0)
+	p.SetChannelVolume(1, 0.5)
+	left, _ = levels(p)
+	assert.InDelta(t, bothLeft/4, left, 0.0001)
+
+	p.ClearChannelOverrides()
+	p.SetChannelMute(5, true) // Ignored
+	left, _ = levels(p)
+	assert.InDelta(t, bothLeft, left, 0.0001)
+
+	// Overrides can be changed while rendering.
+	p = NewPlayer(m, DefaultSampleRate)
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 1000; i++ {
+			p.SetChannelSolo(i%2, i%3 == 0)
+		}
+		done <- true
+	}()
+	renderAll(p, DefaultSampleRate)
+	<-done
+}
+
+func TestChannelScope(t *testing.T) {
+	rows := make([]common.PatternRow, 4)
+	rows[0].Entries = []common.PatternEntry{{Channel: 1, Note: 61, Instrument: 1}}
+	p := NewPlayer(testModule(rows), DefaultSampleRate)
+
+	assert.Empty(t, p.ChannelScope(1, 100).Samples)
+
+	out := make([]float32, 1000*2)
+	p.Render(out)
+
+	scope := p.ChannelScope(1, 100)
+	assert.Len(t, scope.Samples, 100)
+	assert.InDelta(t, (out[1998]+out[1999])/2, scope.Samples[99], 0.0001)
+	assert.Greater(t, scope.Peak, 0.1)
+	// A square wave's RMS equals its peak.
+	assert.InDelta(t, scope.Peak, scope.RMS, 0.01)
+
+	silent := p.ChannelScope(0, 5000)
+	assert.Len(t, silent.Samples, 1000)
+	assert.Zero(t, silent.Peak)
+	assert.Zero(t, silent.RMS)
+
+	assert.Empty(t, p.ChannelScope(9, 100).Samples)
+}
+
+func TestExp2(t *testing.T) {
+	for x := -100.0; x <= 100; x += 0.37 {
+		assert.InEpsilon(t, math.Exp2(x), exp2(x), 1e-14)
+	}
+	assert.Equal(t, 1.0, exp2(0))
+	assert.Equal(t, 0.5, exp2(-1))
+	assert.Equal(t, 1024.0, exp2(10))
+}
+
+// Hash of a full render in 16-bit stereo.
+func renderHash(p *Player) string {
+	hash := sha256.New()
+	buffer := make([]int16, 4096*2)
+	for !p.Ended() {
+		n := p.RenderInt16(buffer)
+		binary.Write(hash, binary.LittleEndian, buffer[:n*2])
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// Renders must be identical on every platform. If a change to the mixer alters the output
+// on purpose, update these hashes after listening to the result.
+func TestGoldenRender(t *testing.T) {
+	it, err := itmod.LoadITFile("../itmod/test/reflection.it")
+	assert.NoError(t, err)
+	m := it.ToCommon()
+	assert.Equal(t, "ece2576a80d0983b92328baf787fef68d1953893d430284f011d08707300458f", renderHash(NewPlayer(m, DefaultSampleRate)))
+	assert.Equal(t, "75c0a52b2fb549c57c67bcc2a90c1e64c60a16e3602576dec0efbeeb1efa5463", renderHash(NewPlayer(m, 48000)))
+
+	rows := make([]common.PatternRow, 16)
+	rows[0].Entries = []common.PatternEntry{
+		{Channel: 0, Note: 61, Instrument: 1, Effect: effectH, EffectParam: 0x48},
+		{Channel: 1, Note: 68, Instrument: 1, Effect: effectY, EffectParam: 0x3F},
+	}
+	rows[4].Entries = []common.PatternEntry{{Channel: 0, Effect: effectR, EffectParam: 0x86}}
+	rows[8].Entries = []common.PatternEntry{{Channel: 1, Note: 73, Effect: effectG, EffectParam: 0x10}}
+	rows[12].Entries = []common.PatternEntry{{Channel: 0, Note: noteOff}}
+	assert.Equal(t, "c10aa28f65baee6a1e5b4eec5b2b72ede978450de58d7f88f87457bbdf40fdad", renderHash(NewPlayer(testModule(rows), DefaultSampleRate)))
+}
+
+func TestStream(t *testing.T) {
+	rows := make([]common.PatternRow, 4)
+	rows[0].Entries = []common.PatternEntry{{Channel: 0, Note: 61, Instrument: 1}}
+	s := NewStream(testModule(rows))
+
+	assert.InDelta(t, 0.48, s.DurationSeconds(), 1e-9)
+
+	buffer := make([]float32, 4800*2)
+	assert.Equal(t, 4410, s.ReadInterleavedStereo(44100, 4410, buffer))
+	assert.InDelta(t, 0.1, s.PositionSeconds(), 1e-9)
+
+	// Changing the rate continues from the same position.
+	buffer16 := make([]int16, 4800*2)
+	assert.Equal(t, 4800, s.ReadInterleavedStereoInt16(48000, 4800, buffer16))
+	assert.InDelta(t, 0.2, s.PositionSeconds(), 1e-9)
+
+	total := 0.2
+	for {
+		n := s.ReadInterleavedStereo(48000, 4800, buffer)
+		if n == 0 {
+			break
+		}
+		total += float64(n) / 48000
+	}
+	assert.InDelta(t, 0.48, total, 1e-9)
+
+	assert.InDelta(t, 0.3, s.SetPositionSeconds(0.3), 1e-9)
+	s.SetRepeatCount(1)
+	assert.Equal(t, 1, s.RepeatCount())
+	for s.ReadInterleavedStereo(48000, 4800, buffer) > 0 {
+	}
+	assert.InDelta(t, 0.96, s.PositionSeconds(), 1e-9)
+
+	s.SetRepeatCount(0)
+	assert.InDelta(t, 0.48, s.SetPositionSeconds(10), 1e-9)
+}
+
+func TestFilter(t *testing.T) {
+	rms := func(m *common.Module) float64 {
+		out := renderAll(NewPlayer(m, DefaultSampleRate), 4000)
+		sum := 0.0
+		for _, v := range out {
+			sum += float64(v) * float64(v)
+		}
+		return math.Sqrt(sum / float64(len(out)))
+	}
+
+	rows := make([]common.PatternRow, 4)
+	rows[0].Entries = []common.PatternEntry{{Channel: 0, Note: 97, Instrument: 1}}
+	open := rms(testModule(rows))
+
+	// Z00 sets the cutoff to 0 with the default macros.
+	rows[0].Entries[0].Effect = effectZ
+	rows[0].Entries[0].EffectParam = 0x00
+	closed := rms(testModule(rows))
+	assert.Less(t, closed, open*0.5)
+
+	// Instrument filter settings apply when bit 7 is set.
+	rows[0].Entries[0].Effect = 0
+	m := testModule(rows)
+	m.Instruments[0].FilterCutoff = 128 | 20
+	assert.Less(t, rms(m), open*0.5)
+	m.Instruments[0].FilterCutoff = 20
+	assert.InDelta(t, open, rms(m), 1e-9)
+
+	// A filter envelope at its maximum is the same as no envelope.
+	m.Instruments[0].FilterCutoff = 128 | 20
+	filtered := rms(m)
+	m.Instruments[0].Envelopes = append(m.Instruments[0].Envelopes, common.Envelope{
+		Enabled: true,
+		Type:    common.EnvelopeTypeFilter,
+		Nodes:   []common.EnvelopeNode{{X: 0, Y: 32}, {X: 100, Y: 32}},
+	})
+	assert.InDelta(t, filtered, rms(m), 1e-9)
+}
+
+func TestSwing(t *testing.T) {
+	rows := make([]common.PatternRow, 8)
+	for i := range rows {
+		rows[i].Entries = []common.PatternEntry{{Channel: 0, Note: 61, Instrument: 1}}
+	}
+	m := testModule(rows)
+	m.Instruments[0].RandomVolumeVariation = 50
+	m.Instruments[0].RandomPanVariation = 32
+
+	render := func(seed uint32) []float32 {
+		p := NewPlayer(m, DefaultSampleRate)
+		p.SetRandomSeed(seed)
+		return renderAll(p, DefaultSampleRate*10)
+	}
+
+	a := render(1)
+	assert.Equal(t, a, render(1), "the same seed should give the same render")
+	assert.NotEqual(t, a, render(2))
+
+	// Each note gets a different volume and pan.
+	rowFrames := 6 * 882
+	levels := map[[2]float32]bool{}
+	for row := 0; row < 8; row++ {
+		i := (row*rowFrames + 10) * 2
+		levels[[2]float32{a[i], a[i+1]}] = true
+	}
+	assert.Greater(t, len(levels), 4)
+}
+
+func TestPitchPanSeparation(t *testing.T) {
+	rows := make([]common.PatternRow, 2)
+	rows[0].Entries = []common.PatternEntry{
+		{Channel: 0, Note: 73, Instrument: 1}, // 12 semitones above the center
+		{Channel: 1, Note: 49, Instrument: 1}, // 12 below
+	}
+	m := testModule(rows)
+	m.Instruments[0].PitchPanSeparation = 16
+	m.Instruments[0].PitchPanCenter = 60
+
+	p := NewPlayer(m, DefaultSampleRate)
+	renderAll(p, 100)
+	assert.Equal(t, 32+24, p.channels[0].pan)
+	assert.Equal(t, 32-24, p.channels[1].pan)
+	assert.InDelta(t, 56.0, p.channels[0].voice.pan, 0)
+
+	// Compare renders against notes played at the pan positions that OpenMPT documents for
+	// IT: "with PPS = 16 / PPC = C-5, E-6 will pan hard right (and D#6 will not)"
+	// (CSoundFile::ProcessPitchPanSeparation). Its pan range is 0-256, moved by
+	// (note - PPC) * PPS / 2, which is 62/64 for D#6.
+	play := func(note uint8, pan int16, pps int16) []float32 {
+		rows := make([]common.PatternRow, 2)
+		rows[0].Entries = []common.PatternEntry{{Channel: 0, Note: note, Instrument: 1}}
+		m := testModule(rows)
+		m.ChannelSettings[0].InitialPan = pan
+		m.Instruments[0].PitchPanSeparation = pps
+		m.Instruments[0].PitchPanCenter = 60
+		return renderAll(NewPlayer(m, DefaultSampleRate), DefaultSampleRate)
+	}
+	for _, ref := range []struct {
+		note uint8
+		pps  int16
+		pan  int16
+	}{
+		{77, 16, 64}, // E-6 (pattern notes, 61 = C-5)
+		{76, 16, 62}, // D#6
+		{61, 16, 32}, // C-5
+		{49, 16, 8},  // C-4
+		{49, -16, 56},
+		{41, -32, 64}, // Clamped
+	} {
+		expected := play(ref.note, ref.pan, 0)
+		actual := play(ref.note, 32, ref.pps)
+		assert.InDeltaSlice(t, expected, actual, 1e-6, "note %d, PPS %d", ref.note, ref.pps)
+	}
+
+	// Hard right leaves nothing on the left.
+	hard := play(77, 32, 16)
+	assert.Zero(t, hard[0])
+	assert.NotZero(t, hard[1])
+	assert.NotZero(t, play(76, 32, 16)[0])
+}
+
+func TestAutoVibrato(t *testing.T) {
+	s := common.Sample{
+		VibratoSpeed:    64,
+		VibratoDepth:    32,
+		VibratoSweep:    4096,
+		VibratoWaveform: common.SampleVibratoWaveformSquare,
+	}
+
+	// The depth ramps up by the sweep each tick: 16, then the full 32.
+	var av autoVibrato
+	assert.Equal(t, []float64{16, 32, -32, -32, 32}, []float64{av.tick(&s), av.tick(&s), av.tick(&s), av.tick(&s), av.tick(&s)})
+
+	s.VibratoWaveform = common.SampleVibratoWaveformRamp
+	av = autoVibrato{depth: 32 * 256}
+	assert.Equal(t, []float64{32, 16, 0, -16}, []float64{av.tick(&s), av.tick(&s), av.tick(&s), av.tick(&s)})
+
+	s.VibratoWaveform = common.SampleVibratoWaveformSine
+	av = autoVibrato{depth: 32 * 256}
+	assert.Equal(t, []float64{0, 32, 0, -32}, []float64{av.tick(&s), av.tick(&s), av.tick(&s), av.tick(&s)})
+
+	s.VibratoWaveform = common.SampleVibratoWaveformRandom
+	av = autoVibrato{depth: 32 * 256}
+	for i := 0; i < 100; i++ {
+		v := av.tick(&s)
+		assert.True(t, v >= -32 && v <= 32)
+	}
+
+	// No depth, no vibrato.
+	s.VibratoDepth = 0
+	assert.Zero(t, av.tick(&s))
+}
+
+func TestPanLaw(t *testing.T) {
+	rows := make([]common.PatternRow, 4)
+	rows[0].Entries = []common.PatternEntry{{Channel: 0, Note: 61, Instrument: 1}}
+	m := testModule(rows)
+
+	levels := func(p *Player) (left, right float64) {
+		for i, v := range renderAll(p, 2000) {
+			if i%2 == 0 {
+				left = max(left, float64(v))
+			} else {
+				right = max(right, float64(v))
+			}
+		}
+		return
+	}
+
+	center, _ := levels(NewPlayer(m, DefaultSampleRate))
+	assert.Greater(t, center, 0.01)
+
+	p := NewPlayer(m, DefaultSampleRate)
+	p.SetPanLaw(PanLawConstantPower)
+	left, right := levels(p)
+	assert.InDelta(t, center*math.Sqrt2, left, 1e-4)
+	assert.InDelta(t, left, right, 1e-6)
+
+	// Hard left, narrowed by half.
+	m.ChannelSettings[0].InitialPan = 0
+	left, right = levels(NewPlayer(m, DefaultSampleRate))
+	assert.InDelta(t, center*2, left, 1e-4)
+	assert.Zero(t, right)
+
+	m.PanSeparation = 64
+	left, right = levels(NewPlayer(m, DefaultSampleRate))
+	assert.InDelta(t, center*1.5, left, 1e-4)
+	assert.InDelta(t, center*0.5, right, 1e-4)
+
+	p = NewPlayer(m, DefaultSampleRate)
+	p.SetStereoSeparation(0)
+	left, right = levels(p)
+	assert.InDelta(t, center, left, 1e-4)
+	assert.InDelta(t, center, right, 1e-4)
+}
+
+func TestLoopPoint(t *testing.T) {
+	rows := make([]common.PatternRow, 4)
+	rows[0].Entries = []common.PatternEntry{{Channel: 0, Note: 61, Instrument: 1}}
+	m := testModule(rows)
+	loopRows := make([]common.PatternRow, 4)
+	loopRows[0].Entries = []common.PatternEntry{{Channel: 0, Note: 73, Instrument: 1}}
+	loopRows[3].Entries = []common.PatternEntry{{Channel: 0, Effect: effectB, EffectParam: 1}}
+	m.Patterns = append(m.Patterns, common.Pattern{Channels: 2, Rows: loopRows})
+	m.Order = []int16{0, 1}
+
+	// 4 rows at speed 6, 882 frames per tick.
+	const patternFrames = 4 * 6 * 882
+
+	p := NewPlayer(m, DefaultSampleRate)
+	_, ok := p.LoopPoint()
+	assert.False(t, ok)
+	assert.Len(t, renderAll(p, DefaultSampleRate*10), patternFrames*2*2)
+	point, ok := p.LoopPoint()
+	assert.True(t, ok)
+	assert.Equal(t, LoopPoint{Start: patternFrames, End: patternFrames * 2, Order: 1, Row: 0}, point)
+
+	intro, loop, point := RenderLoop(m, DefaultSampleRate)
+	assert.Equal(t, patternFrames, point.Start)
+	assert.Len(t, intro, patternFrames*2)
+	assert.Len(t, loop, patternFrames*2)
+
+	// Without a jump, the song loops to the start.
+	m.Patterns[1].Rows[3].Entries = nil
+	intro, loop, point = RenderLoop(m, DefaultSampleRate)
+	assert.Equal(t, LoopPoint{Start: 0, End: patternFrames * 2}, point)
+	assert.Empty(t, intro)
+	assert.Len(t, loop, patternFrames*2*2)
+
+	dir := t.TempDir()
+	introFile, err := os.Create(filepath.Join(dir, "intro.wav"))
+	assert.NoError(t, err)
+	defer introFile.Close()
+	loopFile, err := os.Create(filepath.Join(dir, "loop.wav"))
+	assert.NoError(t, err)
+	defer loopFile.Close()
+	_, err = WriteLoopWavs(m, DefaultSampleRate, introFile, loopFile)
+	assert.NoError(t, err)
+	info, err := loopFile.Stat()
+	assert.NoError(t, err)
+	assert.EqualValues(t, common.WavHeaderSize+patternFrames*2*2*2, info.Size())
+}
+
+func TestClipping(t *testing.T) {
+	rows := make([]common.PatternRow, 4)
+	rows[0].Entries = []common.PatternEntry{{Channel: 0, Note: 61, Instrument: 1}}
+	m := testModule(rows)
+
+	p := NewPlayer(m, DefaultSampleRate)
+	renderAll(p, DefaultSampleRate)
+	stats := p.ClipStats()
+	assert.False(t, stats.Clipped())
+	assert.Greater(t, stats.Peak, 0.1)
+	assert.Greater(t, stats.Headroom(), 0.0)
+
+	m.MixingVolume = 128 * 8
+	p = NewPlayer(m, DefaultSampleRate)
+	audio := renderAll(p, DefaultSampleRate)
+	stats = p.ClipStats()
+	assert.True(t, stats.Clipped())
+	assert.Less(t, stats.Headroom(), 0.0)
+	assert.Greater(t, stats.ClipEvents, 0)
+	assert.GreaterOrEqual(t, stats.ClippedSamples, stats.ClipEvents)
+	peak := float32(0)
+	for _, v := range audio {
+		peak = max(peak, v, -v)
+	}
+	assert.InDelta(t, stats.Peak, peak, 1e-6)
+
+	p = NewPlayer(m, DefaultSampleRate)
+	p.SetClipMode(ClipSoft)
+	audio = renderAll(p, DefaultSampleRate)
+	assert.Equal(t, stats, p.ClipStats())
+	for _, v := range audio {
+		assert.LessOrEqual(t, max(v, -v), float32(1))
+	}
+}
+
+func TestEffectBehavior(t *testing.T) {
+	rows := make([]common.PatternRow, 4)
+	rows[0].Entries = []common.PatternEntry{
+		{Channel: 0, Note: 61, Instrument: 1, VolumeCommand: common.VcmdSetVolume, VolumeParam: 32, Effect: effectD, EffectParam: 0x01},
+		{Channel: 1, Note: 61, Instrument: 1, Effect: effectE, EffectParam: 0x01},
+	}
+	rows[1].Entries = []common.PatternEntry{
+		{Channel: 0, Effect: effectD},
+		{Channel: 1, Effect: effectF},
+	}
+	m := testModule(rows)
+	m.OldEffects = true
+
+	assert.Equal(t, EffectBehavior{
+		LinearSlides: true, OldEffects: true, SlideMemory: true, SharedPitchSlideMemory: true,
+		RowDelayRepeatsRow: true,
+	}, NewPlayer(m, DefaultSampleRate).EffectBehavior())
+
+	// Play the first two rows and return the volume of channel 0 and the frequency change
+	// of channel 1 over the second row.
+	run := func(behavior EffectBehavior) (int, float64) {
+		p := NewPlayer(m, DefaultSampleRate)
+		p.SetEffectBehavior(behavior)
+		var before float64
+		for info := range p.Ticks() {
+			if info.Row == 1 && info.Tick == 0 {
+				before = p.channels[1].frequency
+			}
+			if info.Row == 1 && info.Tick == 5 {
+				break
+			}
+		}
+		return p.channels[0].volume, p.channels[1].frequency / before
+	}
+
+	volume, change := run(DefaultEffectBehavior(m))
+	assert.Equal(t, 32-10, volume)
+	assert.InDelta(t, exp2(5*4.0/768), change, 1e-12)
+
+	volume, change = run(EffectBehavior{LinearSlides: true})
+	assert.Equal(t, 32-5, volume)
+	assert.Equal(t, 1.0, change)
+
+	volume, change = run(EffectBehavior{LinearSlides: true, SlideMemory: true})
+	assert.Equal(t, 32-10, volume)
+	assert.Equal(t, 1.0, change)
+
+	volume, _ = run(CompatScreamTracker3FastSlides.Behavior(m))
+	assert.Equal(t, 32-12, volume)
+	volume, _ = run(CompatProTracker.Behavior(m))
+	assert.Equal(t, 32-5, volume)
+
+	m.Details = &common.S3mDetails{Flags: 64}
+	assert.True(t, DefaultEffectBehavior(m).FastVolumeSlides)
+	assert.Equal(t, DefaultEffectBehavior(m), CompatDefault.Behavior(m))
+
+	// ProTracker stops slides at its highest note.
+	rows[1].Entries[1] = common.PatternEntry{Channel: 1, Effect: effectF, EffectParam: 0xDF}
+	p := NewPlayer(m, DefaultSampleRate)
+	p.SetCompatibility(CompatProTracker)
+	for range p.Ticks() {
+	}
+	assert.InDelta(t, float64(amigaConstant)/amigaPeriodMin, p.channels[1].frequency, 1e-9)
+}
+
+func TestSampleOffset(t *testing.T) {
+	rows := make([]common.PatternRow, 2)
+	m := testModule(rows)
+	data, _ := common.FromFloat64([][]float64{make([]float64, 70000)}, 8)
+	m.Samples[0] = common.Sample{GlobalVolume: 64, DefaultVolume: 64, C5: 8363, Data: data}
+
+	// Play one row with an offset and return the channel's voice afterward.
+	play := func(param uint8, behavior EffectBehavior) voice {
+		rows[0].Entries = []common.PatternEntry{{Channel: 0, Note: 61, Instrument: 1, Effect: effectO, EffectParam: param}}
+		p := NewPlayer(m, DefaultSampleRate)
+		p.SetEffectBehavior(behavior)
+		for range p.Ticks() {
+			break
+		}
+		return p.channels[0].voice
+	}
+	it := DefaultEffectBehavior(m)
+	old := it
+	old.OldEffects = true
+
+	assert.Equal(t, 0x12*256.0, play(0x12, it).position)
+
+	// Past the end of the loop.
+	m.Samples[0].Loop, m.Samples[0].LoopStart, m.Samples[0].LoopEnd = true, 100, 500
+	v := play(0x02, it)
+	assert.True(t, v.active)
+	assert.Equal(t, 0.0, v.position)
+	assert.Equal(t, 499.0, play(0x02, old).position)
+	assert.False(t, play(0x02, CompatFastTracker2.Behavior(m)).active)
+	assert.Equal(t, 256.0, play(0x01, CompatFastTracker2.Behavior(m)).position)
+
+	// SA1 adds 65536 to the following offsets, and O00 reuses the last parameter.
+	m.Samples[0].Loop = false
+	rows[1].Entries = []common.PatternEntry{{Channel: 0, Note: 61, Instrument: 1, Effect: effectO}}
+	rows[0].Entries = []common.PatternEntry{{Channel: 0, Effect: effectS, EffectParam: 0xA1}}
+	p := NewPlayer(m, DefaultSampleRate)
+	p.channels[0].mem.offset = 0x10
+	for info := range p.Ticks() {
+		if info.Row == 1 {
+			break
+		}
+	}
+	assert.Equal(t, 65536+0x10*256.0, p.channels[0].voice.position)
+
+	// Past the end of the sample.
+	p = NewPlayer(m, DefaultSampleRate)
+	p.channels[0].mem.offset = 0x20
+	for info := range p.Ticks() {
+		if info.Row == 1 {
+			break
+		}
+	}
+	assert.Equal(t, 0.0, p.channels[0].voice.position)
+}
+
+func TestProgress(t *testing.T) {
+	rows := make([]common.PatternRow, 4)
+	rows[0].Entries = []common.PatternEntry{{Channel: 0, Note: 61, Instrument: 1}}
+	songFrames := 4 * 6 * 882
+
+	p := NewPlayer(testModule(rows), DefaultSampleRate)
+	p.SetPlaybackOptions(PlaybackOptions{Loops: 1, Fadeout: 100 * time.Millisecond})
+	var reports [][2]int
+	p.SetProgress(func(stage string, done, total int) {
+		assert.Equal(t, common.StageRender, stage)
+		reports = append(reports, [2]int{done, total})
+	})
+	out := renderAll(p, DefaultSampleRate*10)
+
+	total := songFrames*2 + 4410
+	assert.Len(t, out, total*2)
+	assert.Equal(t, [2]int{1024, total}, reports[0])
+	assert.Equal(t, [2]int{total, total}, reports[len(reports)-1])
+
+	// Looping forever has no known length.
+	reports = nil
+	p.SetPlaybackOptions(PlaybackOptions{Loops: -1})
+	p.Render(make([]float32, 2048))
+	assert.Equal(t, [2]int{1024, 0}, reports[0])
+}
+
+func TestEnvelopeCarry(t *testing.T) {
+	sample := squareSample()
+	data := newSamplePCM(&sample)
+	ins := fadeInstrument()
+	ins.Envelopes = []common.Envelope{{
+		Enabled: true,
+		Type:    common.EnvelopeTypeVolume,
+		Nodes:   []common.EnvelopeNode{{X: 0, Y: 0}, {X: 100, Y: 64}},
+	}}
+
+	play := func(prevIns *common.Instrument) int {
+		var v voice
+		v.trigger(prevIns, &sample, data, 60)
+		for i := 0; i < 10; i++ {
+			v.volumeEnv.advance(true)
+		}
+		prev := v
+		v.trigger(&ins, &sample, data, 60)
+		v.carryEnvelopes(&prev)
+		return v.volumeEnv.tick
+	}
+
+	assert.Equal(t, 0, play(&ins))
+	ins.Envelopes[0].Carry = true
+	assert.Equal(t, 10, play(&ins))
+
+	// Another instrument restarts the envelope.
+	other := ins
+	assert.Equal(t, 0, play(&other))
+}
+
+func TestNewNoteActions(t *testing.T) {
+	rows := make([]common.PatternRow, 8)
+	for i := 0; i < 4; i++ {
+		rows[i].Entries = []common.PatternEntry{{Channel: 0, Note: uint8(61 + i), Instrument: 1}}
+	}
+	voicesAfter := func(m *common.Module, limit int) int {
+		p := NewPlayer(m, DefaultSampleRate)
+		p.SetVoiceLimit(limit)
+		for i := 0; i < 4*6; i++ {
+			p.processTick()
+		}
+		return p.ActiveVoices()
+	}
+
+	m := testModule(rows)
+	assert.Equal(t, 1, voicesAfter(m, 0))
+
+	m.Instruments[0].NewNoteAction = common.NnaContinue
+	assert.Equal(t, 4, voicesAfter(m, 0))
+	assert.Equal(t, 2, voicesAfter(m, 2))
+
+	// A duplicate note check cuts the background notes that match the new note.
+	rows[1].Entries[0].Note = 61
+	m.Instruments[0].DuplicateCheckType = common.DctNote
+	assert.Equal(t, 3, voicesAfter(m, 0))
+	m.Instruments[0].DuplicateCheckType = common.DctInstrument
+	assert.Equal(t, 1, voicesAfter(m, 0))
+}
+
+func TestPatternLoopAndRowDelay(t *testing.T) {
+	rowsPlayed := func(m *common.Module, behavior EffectBehavior) int {
+		p := NewPlayer(m, DefaultSampleRate)
+		p.SetEffectBehavior(behavior)
+		count := 0
+		for info := range p.Ticks() {
+			if info.Tick == 0 && info.Repeat == 0 {
+				count++
+			}
+		}
+		return count
+	}
+	loop := func(param uint8) common.PatternEntry {
+		return common.PatternEntry{Channel: 0, Effect: effectS, EffectParam: 0xB0 | param}
+	}
+
+	// The loop start is per channel, except in ST3.
+	rows := make([]common.PatternRow, 4)
+	rows[1].Entries = []common.PatternEntry{{Channel: 1, Effect: effectS, EffectParam: 0xB0}}
+	rows[2].Entries = []common.PatternEntry{loop(1)}
+	m := testModule(rows)
+	assert.Equal(t, 7, rowsPlayed(m, DefaultEffectBehavior(m)))
+	assert.Equal(t, 6, rowsPlayed(m, CompatScreamTracker3.Behavior(m)))
+
+	// A new pattern moves the loop start back to its first row, except in ProTracker and
+	// FT2.
+	first := make([]common.PatternRow, 4)
+	first[1].Entries = []common.PatternEntry{loop(0)}
+	second := make([]common.PatternRow, 4)
+	second[1].Entries = []common.PatternEntry{loop(1)}
+	m = testModule(first)
+	m.Patterns = append(m.Patterns, common.Pattern{Channels: 2, Rows: second})
+	m.Order = []int16{0, 1}
+	assert.Equal(t, 10, rowsPlayed(m, DefaultEffectBehavior(m)))
+	assert.Equal(t, 9, rowsPlayed(m, CompatFastTracker2.Behavior(m)))
+
+	// IT repeats fine slides with each repeat of a delayed row.
+	rows = make([]common.PatternRow, 2)
+	rows[0].Entries = []common.PatternEntry{
+		{Channel: 0, Note: 61, Instrument: 1, Effect: effectD, EffectParam: 0xF1},
+		{Channel: 1, Effect: effectS, EffectParam: 0xE2},
+	}
+	m = testModule(rows)
+	volume := func(behavior EffectBehavior) (int, int) {
+		p := NewPlayer(m, DefaultSampleRate)
+		p.SetEffectBehavior(behavior)
+		ticks := 0
+		for info := range p.Ticks() {
+			if info.Row == 0 {
+				ticks++
+			}
+		}
+		return p.channels[0].volume, ticks
+	}
+	v, ticks := volume(DefaultEffectBehavior(m))
+	assert.Equal(t, 61, v)
+	assert.Equal(t, 18, ticks)
+	v, ticks = volume(CompatProTracker.Behavior(m))
+	assert.Equal(t, 63, v)
+	assert.Equal(t, 18, ticks)
+}
+
+func TestLogger(t *testing.T) {
+	rows := make([]common.PatternRow, 64)
+	rows[1].Entries = []common.PatternEntry{{Channel: 0, Effect: effectB, EffectParam: 0}}
+
+	var log bytes.Buffer
+	p := NewPlayer(testModule(rows), DefaultSampleRate)
+	p.SetLogger(slog.New(slog.NewTextHandler(&log, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	renderAll(p, DefaultSampleRate*10)
+	assert.Contains(t, log.String(), "msg=\"effect behavior\"")
+	assert.Contains(t, log.String(), "msg=jump order=0 row=1 jump_order=0 break_row=-1")
+	assert.Contains(t, log.String(), "msg=\"song end\" order=0 row=0 reason=\"row already played\"")
+
+	// Info level leaves out the traces.
+	log.Reset()
+	p = NewPlayer(testModule(rows), DefaultSampleRate)
+	p.SetLogger(slog.New(slog.NewTextHandler(&log, nil)))
+	renderAll(p, DefaultSampleRate*10)
+	assert.Empty(t, log.String())
+}
+
+// A playback timing case recreated from the OpenMPT test suite, which documents how the
+// original trackers sequence rows. rows is the expected trace from traceTiming.
+type timingVector struct {
+	name   string
+	module *common.Module
+	compat []Compatibility // Profiles the case applies to, nil for all.
+	rows   string
+	tempo  []int // Tempo of each tick, when checked.
+}
+
+// Step through a module and trace its rows: each row played as order.row, followed by
+// its tick count when that isn't the speed, e.g. "0.1x12" for a delayed row at speed 6.
+func traceTiming(m *common.Module, c Compatibility) (string, []int) {
+	p := NewPlayer(m, DefaultSampleRate)
+	p.SetCompatibility(c)
+	p.SetPlaybackOptions(PlaybackOptions{MaxDuration: 10 * time.Second})
+
+	var rows []string
+	var tempo []int
+	ticks, speed := 0, 0
+	endRow := func() {
+		if len(rows) > 0 && ticks != speed {
+			rows[len(rows)-1] += fmt.Sprintf("x%d", ticks)
+		}
+	}
+	for info := range p.Ticks() {
+		if info.Tick == 0 && info.Repeat == 0 {
+			endRow()
+			rows = append(rows, fmt.Sprintf("%d.%d", info.Order, info.Row))
+			ticks, speed = 0, info.Speed
+		}
+		ticks++
+		tempo = append(tempo, info.Tempo)
+	}
+	endRow()
+	return strings.Join(rows, " "), tempo
+}
+
+func TestTimingVectors(t *testing.T) {
+	// A module with patterns of the given lengths, played in order. Entries are given as
+	// pattern, row, channel, effect and parameter.
+	build := func(lengths []int, cells ...[5]int) *common.Module {
+		m := testModule(make([]common.PatternRow, lengths[0]))
+		m.Order = []int16{0}
+		for i, n := range lengths[1:] {
+			m.Patterns = append(m.Patterns, common.Pattern{Channels: 2, Rows: make([]common.PatternRow, n)})
+			m.Order = append(m.Order, int16(i+1))
+		}
+		for _, c := range cells {
+			m.Patterns[c[0]].Rows[c[1]].Set(common.PatternEntry{Channel: uint8(c[2]), Effect: uint8(c[3]), EffectParam: uint8(c[4])})
+		}
+		return m
+	}
+	slowTempo := build([]int{2}, [5]int{0, 0, 0, effectT, 0x0F})
+	slowTempo.InitialTempo = 40
+
+	vectors := []timingVector{
+		{name: "SEx repeats the row x more times",
+			module: build([]int{2}, [5]int{0, 0, 0, effectS, 0xE2}),
+			rows:   "0.0x18 0.1"},
+		{name: "SEx counts rows at the current speed",
+			module: build([]int{2}, [5]int{0, 0, 0, effectA, 2}, [5]int{0, 0, 1, effectS, 0xE1}),
+			rows:   "0.0x4 0.1"},
+		{name: "S6x adds x ticks to the row",
+			module: build([]int{2}, [5]int{0, 0, 0, effectS, 0x62}),
+			compat: []Compatibility{CompatDefault},
+			rows:   "0.0x8 0.1"},
+		{name: "the first SEx on a row is used",
+			module: build([]int{2}, [5]int{0, 0, 0, effectS, 0xE1}, [5]int{0, 0, 1, effectS, 0xE3}),
+			compat: []Compatibility{CompatDefault},
+			rows:   "0.0x12 0.1"},
+		{name: "A00 is ignored",
+			module: build([]int{2}, [5]int{0, 0, 0, effectA, 0}),
+			rows:   "0.0 0.1"},
+		{name: "Bxx and Cxx on one row go to that row of that order",
+			module: build([]int{2, 4}, [5]int{0, 0, 0, effectB, 1}, [5]int{0, 0, 1, effectC, 2}),
+			rows:   "0.0 1.2 1.3"},
+		{name: "Cxx past the end of the next pattern goes to its first row",
+			module: build([]int{2, 4}, [5]int{0, 0, 0, effectC, 9}),
+			rows:   "0.0 1.0 1.1 1.2 1.3"},
+		{name: "Bxx to a row already played ends the song",
+			module: build([]int{2, 2}, [5]int{1, 1, 0, effectB, 0}),
+			rows:   "0.0 0.1 1.0 1.1"},
+		{name: "SBx plays the loop x more times",
+			module: build([]int{3}, [5]int{0, 0, 0, effectS, 0xB0}, [5]int{0, 1, 0, effectS, 0xB2}),
+			rows:   "0.0 0.1 0.0 0.1 0.0 0.1 0.2"},
+		{name: "SEx delays the row on each pass of a loop",
+			module: build([]int{3}, [5]int{0, 1, 0, effectS, 0xB1}, [5]int{0, 1, 1, effectS, 0xE1}),
+			rows:   "0.0 0.1x12 0.0 0.1x12 0.2"},
+		{name: "SB0 sets the loop start of its own channel",
+			module: build([]int{4}, [5]int{0, 1, 1, effectS, 0xB0}, [5]int{0, 2, 0, effectS, 0xB1}),
+			compat: []Compatibility{CompatDefault, CompatFastTracker2, CompatProTracker},
+			rows:   "0.0 0.1 0.2 0.0 0.1 0.2 0.3"},
+		{name: "SB0 sets the loop start of all channels in ST3",
+			module: build([]int{4}, [5]int{0, 1, 1, effectS, 0xB0}, [5]int{0, 2, 0, effectS, 0xB1}),
+			compat: []Compatibility{CompatScreamTracker3},
+			rows:   "0.0 0.1 0.2 0.1 0.2 0.3"},
+		{name: "T0x slides the tempo down on every tick but the first",
+			module: build([]int{2}, [5]int{0, 0, 0, effectT, 0x05}),
+			rows:   "0.0 0.1",
+			tempo:  []int{125, 120, 115, 110, 105, 100, 100, 100, 100, 100, 100, 100}},
+		{name: "T1x slides the tempo up",
+			module: build([]int{1}, [5]int{0, 0, 0, effectT, 0x15}),
+			rows:   "0.0",
+			tempo:  []int{125, 130, 135, 140, 145, 150}},
+		{name: "tempo slides stop at 32",
+			module: slowTempo,
+			rows:   "0.0 0.1",
+			tempo:  []int{40, 32, 32, 32, 32, 32, 32, 32, 32, 32, 32, 32}},
+	}
+
+	all := []Compatibility{CompatDefault, CompatProTracker, CompatScreamTracker3, CompatFastTracker2}
+	for _, v := range vectors {
+		compat := v.compat
+		if compat == nil {
+			compat = all
+		}
+		for _, c := range compat {
+			rows, tempo := traceTiming(v.module, c)
+			assert.Equal(t, v.rows, rows, "%s (profile %d)", v.name, c)
+			if v.tempo != nil {
+				assert.Equal(t, v.tempo, tempo, "%s (profile %d)", v.name, c)
+			}
+		}
+	}
+}
+
+// Fails every read.
+type failingReader struct{}
+
+func (failingReader) ReadAt([]byte, int64) (int, error) { return 0, io.ErrUnexpectedEOF }
+
+func TestStreamedSample(t *testing.T) {
+	// A long sample, so playback crosses several stream chunks.
+	frames := streamChunkFrames*3 + 100
+	pcm := make([]int16, frames)
+	raw := make([]byte, frames*2)
+	for i := range pcm {
+		pcm[i] = int16(20000 * math.Sin(float64(i)/7) * math.Sin(float64(i)/5000))
+		binary.LittleEndian.PutUint16(raw[i*2:], uint16(pcm[i]))
+	}
+
+	rows := make([]common.PatternRow, 32)
+	rows[0].Entries = []common.PatternEntry{{Channel: 0, Note: 84, Instrument: 1}}
+	m := testModule(rows)
+	m.Samples[0] = common.Sample{
+		GlobalVolume: 64, DefaultVolume: 64, C5: 44100, S16: true,
+		Loop: true, LoopStart: streamChunkFrames * 2, LoopEnd: streamChunkFrames*2 + 5000,
+		Data: common.SampleData{Channels: 1, Bits: 16, Data: []any{pcm}},
+	}
+	expected := renderAll(NewPlayer(m, DefaultSampleRate), 1<<22)
+
+	m.Samples[0].Data = common.SampleData{}
+	m.Samples[0].Stream = &common.SampleStream{Source: bytes.NewReader(raw), Frames: frames, Channels: 1, Bits: 16}
+	p := NewPlayer(m, DefaultSampleRate)
+	assert.Equal(t, expected, renderAll(p, 1<<22))
+	assert.NoError(t, p.StreamError())
+
+	// Unreadable parts play as silence.
+	m.Samples[0].Stream = &common.SampleStream{Source: failingReader{}, Frames: frames, Channels: 1, Bits: 16}
+	p = NewPlayer(m, DefaultSampleRate)
+	renderAll(p, 1<<22)
+	assert.ErrorIs(t, p.StreamError(), io.ErrUnexpectedEOF)
+}
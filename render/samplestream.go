@@ -0,0 +1,188 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package render
+
+import (
+	"cmp"
+	"sync"
+
+	"go.mukunda.com/modlib/common"
+)
+
+const (
+	// Frames read from a streamed sample at a time.
+	streamChunkFrames = 1 << 16
+
+	// Chunks of each streamed sample kept in memory.
+	streamCacheChunks = 8
+)
+
+// The float PCM of a sample, in memory or streamed from its file.
+type samplePCM struct {
+	data   [][]float64 // [channel][frame]
+	stream *pcmStream
+}
+
+// Convert a sample's PCM for playback. Streamed samples (common.Sample.Stream) have their
+// start and loops loaded now, and the rest is read as it plays.
+func newSamplePCM(s *common.Sample) *samplePCM {
+	if s.Stream != nil {
+		return &samplePCM{stream: newPCMStream(s)}
+	}
+	return &samplePCM{data: s.Data.Float64()}
+}
+
+// Number of frames.
+func (pcm *samplePCM) frames() int {
+	switch {
+	case pcm == nil:
+		return 0
+	case pcm.stream != nil:
+		return pcm.stream.source.Frames
+	case len(pcm.data) == 0:
+		return 0
+	}
+	return len(pcm.data[0])
+}
+
+// Number of channels.
+func (pcm *samplePCM) channels() int {
+	if pcm.stream != nil {
+		return int(pcm.stream.source.Channels)
+	}
+	return len(pcm.data)
+}
+
+// A range of frames of a sample's PCM.
+type pcmBlock struct {
+	start int
+	data  [][]float64 // [channel][frame], -1 to 1
+}
+
+// True if the block has the frame.
+func (b *pcmBlock) has(frame int) bool {
+	return b.data != nil && frame >= b.start && frame-b.start < len(b.data[0])
+}
+
+// The block of PCM with the frame, which is read from one at a time while playing. For
+// samples in memory, that's the whole sample.
+func (pcm *samplePCM) block(frame int) pcmBlock {
+	if pcm.stream != nil {
+		return pcm.stream.block(frame)
+	}
+	return pcmBlock{data: pcm.data}
+}
+
+// Reads a streamed sample in chunks. The start of the sample and its loops are loaded
+// up front, since notes start there and loops are played over and over; other chunks are
+// read when they're first played, and the chunk after each one is read ahead in the
+// background.
+type pcmStream struct {
+	source  *common.SampleStream
+	regions []pcmBlock // Loaded up front.
+
+	lock   sync.Mutex
+	chunks map[int]*pcmChunk
+	loaded []int // Chunk indexes in the order they were loaded, for evicting old ones.
+	err    error // First read error.
+}
+
+type pcmChunk struct {
+	data  [][]float64 // Silence if the chunk couldn't be read.
+	ready chan struct{}
+}
+
+func newPCMStream(s *common.Sample) *pcmStream {
+	ps := &pcmStream{source: s.Stream, chunks: make(map[int]*pcmChunk)}
+	ps.loadRegion(0, streamChunkFrames)
+	if s.Loop {
+		ps.loadRegion(s.LoopStart, s.LoopEnd-s.LoopStart)
+	}
+	if s.Sustain {
+		ps.loadRegion(s.SustainLoopStart, s.SustainLoopEnd-s.SustainLoopStart)
+	}
+	return ps
+}
+
+func (ps *pcmStream) loadRegion(start, count int) {
+	sd, err := ps.source.Read(start, count)
+	if err != nil {
+		ps.err = err
+		return
+	}
+	if sd.Len() > 0 {
+		ps.regions = append(ps.regions, pcmBlock{start: start, data: sd.Float64()})
+	}
+}
+
+func (ps *pcmStream) block(frame int) pcmBlock {
+	for _, r := range ps.regions {
+		if r.has(frame) {
+			return r
+		}
+	}
+
+	index := frame / streamChunkFrames
+	ps.lock.Lock()
+	chunk := ps.chunk(index)
+	if index+1 < (ps.source.Frames+streamChunkFrames-1)/streamChunkFrames {
+		ps.chunk(index + 1)
+	}
+	ps.lock.Unlock()
+
+	<-chunk.ready
+	return pcmBlock{start: index * streamChunkFrames, data: chunk.data}
+}
+
+// Get a chunk, starting to read it if it isn't loaded. Must be called with the lock held.
+func (ps *pcmStream) chunk(index int) *pcmChunk {
+	if c, ok := ps.chunks[index]; ok {
+		return c
+	}
+
+	if len(ps.loaded) >= streamCacheChunks {
+		delete(ps.chunks, ps.loaded[0])
+		ps.loaded = ps.loaded[1:]
+	}
+	c := &pcmChunk{ready: make(chan struct{})}
+	ps.chunks[index] = c
+	ps.loaded = append(ps.loaded, index)
+
+	go func() {
+		defer close(c.ready)
+		sd, err := ps.source.Read(index*streamChunkFrames, streamChunkFrames)
+		if err != nil {
+			ps.lock.Lock()
+			ps.err = cmp.Or(ps.err, err)
+			ps.lock.Unlock()
+
+			frames := min(streamChunkFrames, ps.source.Frames-index*streamChunkFrames)
+			c.data = make([][]float64, ps.source.Channels)
+			for ch := range c.data {
+				c.data[ch] = make([]float64, frames)
+			}
+			return
+		}
+		c.data = sd.Float64()
+	}()
+	return c
+}
+
+// The first error from reading streamed samples. Parts of samples that couldn't be read
+// play as silence.
+func (p *Player) StreamError() error {
+	for _, pcm := range p.samples {
+		if pcm.stream == nil {
+			continue
+		}
+		pcm.stream.lock.Lock()
+		err := pcm.stream.err
+		pcm.stream.lock.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package render
+
+import "math"
+
+// Frames of history kept for each channel's scope.
+const scopeHistory = 8192
+
+// Recent output of a channel, for oscilloscope and VU meter displays.
+type Scope struct {
+	// Mono samples, oldest first.
+	Samples []float32
+
+	// Largest absolute sample value in the window.
+	Peak float64
+
+	// Root mean square level of the window.
+	RMS float64
+}
+
+// Ring buffer of a channel's recent output.
+type channelScope struct {
+	history []float32
+	next    int // Index of the oldest sample, where the next one is written.
+	filled  int
+}
+
+// Get the last `frames` frames that a channel contributed to the mix, up to 8192, along with
+// their levels. The first call starts recording, so it returns an empty window; after that
+// it's safe to call from another goroutine while rendering, e.g. from a UI timer. Muted
+// channels record silence.
+func (p *Player) ChannelScope(channel int, frames int) Scope {
+	p.scopeLock.Lock()
+	defer p.scopeLock.Unlock()
+
+	if p.scopes == nil {
+		p.scopes = make([]channelScope, len(p.channels))
+		for i := range p.scopes {
+			p.scopes[i].history = make([]float32, scopeHistory)
+		}
+		p.scopesEnabled.Store(true)
+	}
+
+	if channel < 0 || channel >= len(p.scopes) {
+		return Scope{}
+	}
+
+	cs := &p.scopes[channel]
+	frames = max(0, min(frames, cs.filled))
+	scope := Scope{Samples: make([]float32, frames)}
+
+	start := cs.next - frames + scopeHistory
+	sum := 0.0
+	for i := range scope.Samples {
+		v := cs.history[(start+i)%scopeHistory]
+		scope.Samples[i] = v
+		scope.Peak = max(scope.Peak, math.Abs(float64(v)))
+		sum += float64(float64(v) * float64(v))
+	}
+	if frames > 0 {
+		scope.RMS = math.Sqrt(sum / float64(frames))
+	}
+
+	return scope
+}
+
+// Add a channel's stereo output to its scope, or silence if out is nil.
+func (p *Player) recordScope(channel int, out []float64, frames int) {
+	p.scopeLock.Lock()
+	defer p.scopeLock.Unlock()
+
+	cs := &p.scopes[channel]
+	for i := 0; i < frames; i++ {
+		var v float32
+		if out != nil {
+			v = float32((out[i*2] + out[i*2+1]) / 2)
+		}
+		cs.history[cs.next] = v
+		cs.next = (cs.next + 1) % scopeHistory
+	}
+	cs.filled = min(cs.filled+frames, scopeHistory)
+}
@@ -0,0 +1,73 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package render
+
+import "errors"
+
+// Returned by Seek when the song never reaches the requested position.
+var ErrPositionNotFound = errors.New("position is not reached by the song")
+
+// The order and row that will be played next (or is playing).
+func (p *Player) Position() (order int, row int) {
+	return p.order, p.row
+}
+
+// True if the next tick starts the row at the given position.
+func (p *Player) atRow(order, row int) bool {
+	if p.tick != 0 || p.rowRepeat != 0 || p.tickFrames != 0 {
+		return false
+	}
+	if _, ok := p.resolveOrder(); !ok {
+		return false
+	}
+	return p.order == order && p.row == row
+}
+
+// Jump to a song position by playing the song from the start without mixing audio. Speed,
+// tempo, volumes, effect memory, and envelopes are carried along as if the song had been
+// played, and sample positions are advanced so notes that are still ringing continue
+// from the right place. If the song ends before the position is reached, the player is
+// reset to the start and ErrPositionNotFound is returned.
+func (p *Player) Seek(order, row int) error {
+	p.reset()
+	p.seeking = true
+	defer func() { p.seeking = false }()
+
+	for !p.ended {
+		if p.atRow(order, row) {
+			return nil
+		}
+
+		if p.tickFrames == 0 {
+			p.startTick()
+			continue
+		}
+
+		for i := range p.channels {
+			p.mixChannel(&p.channels[i], nil, p.tickFrames)
+		}
+		p.tickFrames = 0
+	}
+
+	p.reset()
+	return ErrPositionNotFound
+}
+
+// Advance the song by a number of frames without mixing. Returns the number of frames
+// skipped, which is less than requested if the song ends.
+func (p *Player) skip(frames int) int {
+	p.seeking = true
+	defer func() { p.seeking = false }()
+
+	done := 0
+	for done < frames {
+		_, n := p.renderMix(min(frames-done, stemBlockFrames))
+		done += n
+		if p.ended {
+			break
+		}
+	}
+	return done
+}
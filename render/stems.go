@@ -0,0 +1,151 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package render
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"go.mukunda.com/modlib/common"
+)
+
+// Options for Stems.
+type StemOptions struct {
+	// Output sample rate. DefaultSampleRate is used if 0.
+	SampleRate int
+
+	// Make one stem per instrument (or per sample, when the module doesn't use
+	// instruments) instead of one per channel.
+	ByInstrument bool
+
+	// Directory to write the WAV files to.
+	Directory string
+
+	// Prepended to each filename, e.g. the song name.
+	Prefix string
+
+	// Loop count, fadeout, and length limit.
+	Playback PlaybackOptions
+}
+
+// Frames rendered per block when writing stems.
+const stemBlockFrames = 4096
+
+// Routes channels into separate mixing buffers.
+type stemRouter struct {
+	buffers [][]float64
+	key     func(ch *channel) int
+	silent  []bool
+}
+
+// The mixing buffer for a channel's stem, or nil if it doesn't belong to one.
+func (sr *stemRouter) buffer(ch *channel) []float64 {
+	key := sr.key(ch)
+	if key < 0 || key >= len(sr.buffers) {
+		return nil
+	}
+	return sr.buffers[key]
+}
+
+// Render a module into separate stereo 16-bit WAV files, one for each channel or each
+// instrument, in a single pass through the song. Stems that stay silent for the whole song
+// aren't kept. Returns the paths of the files written.
+func Stems(m *common.Module, opts StemOptions) ([]string, error) {
+	rate := opts.SampleRate
+	if rate == 0 {
+		rate = DefaultSampleRate
+	}
+
+	p := NewPlayer(m, rate)
+	p.SetPlaybackOptions(opts.Playback)
+
+	count := len(p.channels)
+	kind := "channel"
+	key := func(ch *channel) int { return ch.index }
+	if opts.ByInstrument {
+		kind = "instrument"
+		count = len(m.Instruments)
+		if !m.UseInstruments {
+			kind = "sample"
+			count = len(m.Samples)
+		}
+		key = func(ch *channel) int { return ch.instrumentNumber - 1 }
+	}
+
+	router := &stemRouter{key: key}
+	var paths []string
+	var files []*os.File
+	var writers []*WavWriter
+
+	cleanup := func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}
+
+	for i := 0; i < count; i++ {
+		path := filepath.Join(opts.Directory, fmt.Sprintf("%s%s%02d.wav", opts.Prefix, kind, i+1))
+		f, err := os.Create(path)
+		if err != nil {
+			cleanup()
+			return nil, err
+		}
+		files = append(files, f)
+
+		ww, err := NewWavWriter(f, rate, 2)
+		if err != nil {
+			cleanup()
+			return nil, err
+		}
+		writers = append(writers, ww)
+		paths = append(paths, path)
+		router.buffers = append(router.buffers, make([]float64, stemBlockFrames*2))
+		router.silent = append(router.silent, true)
+	}
+
+	p.stems = router
+	block := make([]int16, stemBlockFrames*2)
+
+	for !p.ended {
+		for _, buffer := range router.buffers {
+			clear(buffer)
+		}
+
+		_, frames := p.renderMix(stemBlockFrames)
+
+		for i, buffer := range router.buffers {
+			for j := 0; j < frames*2; j++ {
+				block[j] = int16(toInteger(buffer[j], int16Max))
+				if block[j] != 0 {
+					router.silent[i] = false
+				}
+			}
+			if err := writers[i].WriteInt16(block[:frames*2]); err != nil {
+				cleanup()
+				return nil, err
+			}
+		}
+	}
+
+	var written []string
+	for i, f := range files {
+		err := writers[i].Close()
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if router.silent[i] {
+			os.Remove(paths[i])
+		} else {
+			written = append(written, paths[i])
+		}
+	}
+
+	return written, nil
+}
@@ -0,0 +1,106 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package render
+
+import (
+	"math"
+
+	"go.mukunda.com/modlib/common"
+)
+
+// A wrapper around Player with the same semantics as libopenmpt's module interface, to
+// ease porting code that uses libopenmpt through cgo. The sample rate is passed with each
+// read and may change between reads, positions are in seconds, and the repeat count
+// works like libopenmpt's: 0 plays once, -1 loops forever.
+type Stream struct {
+	module   *common.Module
+	player   *Player
+	repeat   int
+	position float64 // Seconds
+	duration float64 // Seconds, -1 = not computed yet
+}
+
+// Create a stream for a module.
+func NewStream(m *common.Module) *Stream {
+	return &Stream{
+		module:   m,
+		duration: -1,
+	}
+}
+
+// Get a player for the sample rate, replacing the current one if the rate changed.
+func (s *Stream) playerFor(sampleRate int) *Player {
+	if s.player == nil || s.player.rate != sampleRate {
+		position := s.position
+		s.player = NewPlayer(s.module, sampleRate)
+		s.player.SetPlaybackOptions(PlaybackOptions{Loops: s.repeat})
+		s.position = 0
+		if position > 0 {
+			s.SetPositionSeconds(position)
+		}
+	}
+	return s.player
+}
+
+// Render up to count stereo frames of float audio into buffer, which must hold at least
+// count*2 samples. Returns the number of frames rendered; 0 means the song has ended.
+func (s *Stream) ReadInterleavedStereo(sampleRate int, count int, buffer []float32) int {
+	frames := s.playerFor(sampleRate).Render(buffer[:count*2])
+	s.position += float64(frames) / float64(sampleRate)
+	return frames
+}
+
+// Like ReadInterleavedStereo, but for 16-bit output.
+func (s *Stream) ReadInterleavedStereoInt16(sampleRate int, count int, buffer []int16) int {
+	frames := s.playerFor(sampleRate).RenderInt16(buffer[:count*2])
+	s.position += float64(frames) / float64(sampleRate)
+	return frames
+}
+
+// The current playback position in seconds.
+func (s *Stream) PositionSeconds() float64 {
+	return s.position
+}
+
+// Jump to a position in seconds by playing the song from the start without mixing. Returns
+// the new position, which is the end of the song if the song is shorter.
+func (s *Stream) SetPositionSeconds(seconds float64) float64 {
+	rate := DefaultSampleRate
+	if s.player != nil {
+		rate = s.player.rate
+	}
+	p := s.playerFor(rate)
+	p.reset()
+
+	frames := p.skip(int(math.Round(max(seconds, 0) * float64(rate))))
+	s.position = float64(frames) / float64(rate)
+	return s.position
+}
+
+// The length of one pass through the song in seconds, not counting repeats.
+func (s *Stream) DurationSeconds() float64 {
+	if s.duration < 0 {
+		s.duration = 0
+		for tick := range NewPlayer(s.module, DefaultSampleRate).Ticks() {
+			s.duration += 2.5 / float64(tick.Tempo)
+		}
+	}
+	return s.duration
+}
+
+// Set how many extra times the song plays: 0 plays once, -1 loops forever. This takes
+// effect without restarting playback.
+func (s *Stream) SetRepeatCount(count int) {
+	s.repeat = count
+	if p := s.player; p != nil {
+		p.options.Loops = count
+		p.loopsLeft = count
+	}
+}
+
+// The repeat count set with SetRepeatCount.
+func (s *Stream) RepeatCount() int {
+	return s.repeat
+}
@@ -0,0 +1,68 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package render
+
+import (
+	"iter"
+	"math"
+	"time"
+)
+
+// The state of the song during one tick.
+type TickInfo struct {
+	Time time.Duration // Start of the tick, from the start of the song.
+
+	// Position of the row being played.
+	Order int
+	Row   int
+	Tick  int
+
+	// Repeat of a delayed row (SEx), 0 on the first pass.
+	Repeat int
+
+	Speed        int
+	Tempo        int
+	GlobalVolume int // 0-128
+}
+
+// Step through the song tick by tick without mixing any audio, e.g. to analyze its timing.
+// Loops and MaxDuration from the playback options apply. This consumes the player, so use
+// a new one for each pass.
+func (p *Player) Ticks() iter.Seq[TickInfo] {
+	return func(yield func(TickInfo) bool) {
+		p.seeking = true
+		defer func() { p.seeking = false }()
+
+		seconds := 0.0
+		for !p.ended {
+			now := time.Duration(math.Round(seconds * float64(time.Second)))
+			if limit := p.options.MaxDuration; limit > 0 && now >= limit {
+				p.stop()
+				break
+			}
+
+			tick, repeat := p.tick, p.rowRepeat
+			p.processTick()
+			if p.ended {
+				break
+			}
+
+			info := TickInfo{
+				Time:         now,
+				Order:        p.rowOrder,
+				Row:          p.rowIndex,
+				Tick:         tick,
+				Repeat:       repeat,
+				Speed:        p.speed,
+				Tempo:        p.tempo,
+				GlobalVolume: p.globalVolume,
+			}
+			if !yield(info) {
+				return
+			}
+			seconds += 2.5 / float64(p.tempo)
+		}
+	}
+}
@@ -0,0 +1,347 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package render
+
+import (
+	"math"
+
+	"go.mukunda.com/modlib/common"
+)
+
+// Fadeout volume at the start of a note. Instrument Fadeout is subtracted from this each
+// tick once the note is fading.
+const fadeMax = 1024
+
+// A single playing sample, driven by an instrument.
+type voice struct {
+	sample     *common.Sample
+	pcm        *samplePCM
+	block      pcmBlock // The block of pcm last read from.
+	instrument *common.Instrument
+
+	// Runs synthesized instruments, which play its waveform instead of the sample.
+	synth *common.SynthVoice
+
+	active bool
+	keyOn  bool
+	fading bool
+	fade   int // 0-1024
+
+	position  float64 // Current frame in the sample.
+	direction float64 // 1 = forward, -1 = backward (ping-pong loops).
+
+	// Playback rate in frames per second, before envelopes.
+	frequency float64
+
+	// Note volume (0-64) and pan (0-64) before envelopes.
+	volume float64
+	pan    float64
+
+	// Extra volume scale, e.g. from the channel and global volume.
+	gain float64
+
+	// Random offset to the sample global volume (0-64) from the instrument's volume swing.
+	volumeSwing int
+
+	volumeEnv envelopeState
+	panEnv    envelopeState
+	pitchEnv  envelopeState
+	filterEnv envelopeState
+
+	autoVibrato autoVibrato
+
+	// Filter settings from the channel, 0-127.
+	cutoff    int
+	resonance int
+	filter    filter
+
+	// Computed by updateTick for the mixer.
+	mixStep  float64
+	mixLeft  float64
+	mixRight float64
+}
+
+// Frequency of a note (0-119, 60 = C-5) for a sample with the given C5 speed.
+func noteFrequency(c5 int, note int) float64 {
+	return float64(c5) * exp2(float64(note-60)/12)
+}
+
+// Start a note on the voice. pcm is the float PCM of the sample.
+func (v *voice) trigger(ins *common.Instrument, sample *common.Sample, pcm *samplePCM, note int) {
+	*v = voice{
+		sample:     sample,
+		pcm:        pcm,
+		instrument: ins,
+		active:     pcm.frames() > 0,
+		keyOn:      true,
+		fade:       fadeMax,
+		direction:  1,
+		frequency:  noteFrequency(sample.C5, note),
+		volume:     float64(sample.DefaultVolume),
+		pan:        32,
+		gain:       1,
+		cutoff:     filterOpen,
+	}
+
+	if sample.DefaultPanning&128 != 0 {
+		v.pan = float64(sample.DefaultPanning & 127)
+	}
+
+	if ins == nil {
+		return
+	}
+
+	if ins.DefaultPanEnabled {
+		v.pan = float64(ins.DefaultPan)
+	}
+
+	if ins.Synth != nil {
+		v.synth = common.NewSynthVoice(ins.Synth, note, int(sample.GlobalVolume))
+		v.loadSynthWaveform()
+	}
+
+	// Bit 7 enables the instrument's initial filter settings.
+	if ins.FilterCutoff&128 != 0 {
+		v.cutoff = int(ins.FilterCutoff & 127)
+	}
+	if ins.FilterResonance&128 != 0 {
+		v.resonance = int(ins.FilterResonance & 127)
+	}
+
+	for i := range ins.Envelopes {
+		env := &ins.Envelopes[i]
+		switch env.Type {
+		case common.EnvelopeTypeVolume:
+			v.volumeEnv = newEnvelopeState(env)
+		case common.EnvelopeTypePanning:
+			v.panEnv = newEnvelopeState(env)
+		case common.EnvelopeTypePitch:
+			v.pitchEnv = newEnvelopeState(env)
+		case common.EnvelopeTypeFilter:
+			v.filterEnv = newEnvelopeState(env)
+		}
+	}
+}
+
+// Release the note. Sustain loops end, and the note starts fading unless the volume
+// envelope will handle it.
+func (v *voice) release() {
+	v.keyOn = false
+	if !v.volumeEnv.active() || v.volumeEnv.env.Loop {
+		v.fading = true
+	}
+}
+
+// Play the sample again from the start, keeping the envelopes.
+func (v *voice) restart() {
+	if v.sample == nil || v.pcm.frames() == 0 {
+		return
+	}
+	v.active = true
+	v.position = 0
+	v.direction = 1
+}
+
+// Keep the positions of carried envelopes from the note this voice replaces, when both
+// play the same instrument.
+func (v *voice) carryEnvelopes(prev *voice) {
+	if v.instrument == nil || prev.instrument != v.instrument || !prev.active {
+		return
+	}
+	for _, pair := range [][2]*envelopeState{
+		{&v.volumeEnv, &prev.volumeEnv},
+		{&v.panEnv, &prev.panEnv},
+		{&v.pitchEnv, &prev.pitchEnv},
+		{&v.filterEnv, &prev.filterEnv},
+	} {
+		if pair[0].env != nil && pair[0].env.Carry {
+			*pair[0] = *pair[1]
+		}
+	}
+}
+
+// Compute the mixing parameters for the next tick and advance envelopes and fadeout.
+func (v *voice) updateTick(rate int, stereo stereoMix) {
+	if !v.active {
+		return
+	}
+
+	sampleVolume := int(v.sample.GlobalVolume)
+	frequency := v.frequency
+	if v.synth != nil {
+		if v.synth.Tick() {
+			v.loadSynthWaveform()
+		}
+		sampleVolume = v.synth.Volume()
+		frequency *= v.synth.Pitch()
+	}
+
+	sampleVolume = max(0, min(sampleVolume+v.volumeSwing, 64))
+	volume := v.volume / 64 * float64(sampleVolume) / 64 * v.gain
+	pan := v.pan
+
+	if v.instrument != nil {
+		volume *= float64(v.instrument.GlobalVolume) / 128
+	}
+
+	if v.volumeEnv.active() {
+		envVolume := v.volumeEnv.value()
+		volume *= envVolume / 64
+		if v.volumeEnv.ended {
+			if envVolume == 0 {
+				v.active = false
+				return
+			}
+			v.fading = true
+		}
+	}
+
+	if v.panEnv.active() {
+		pan += v.panEnv.value() * (32 - math.Abs(pan-32)) / 32
+	}
+
+	if v.pitchEnv.active() {
+		// Pitch envelope units are half-semitones.
+		frequency *= exp2(v.pitchEnv.value() / 24)
+	}
+
+	if vibrato := v.autoVibrato.tick(v.sample); vibrato != 0 {
+		frequency *= exp2(vibrato / 768)
+	}
+
+	volume *= float64(v.fade) / fadeMax
+
+	modifier := filterNoModifier
+	if v.filterEnv.active() {
+		modifier = int(v.filterEnv.value() * 8)
+	}
+	if v.cutoff < filterOpen || v.resonance > filterNoResonant || v.filterEnv.active() {
+		v.filter.setup(v.cutoff, v.resonance, modifier, rate)
+	} else {
+		v.filter.enabled = false
+	}
+
+	v.mixStep = frequency / float64(rate)
+	left, right := stereo.gains(pan)
+	v.mixLeft = volume * left
+	v.mixRight = volume * right
+
+	v.volumeEnv.advance(v.keyOn)
+	v.panEnv.advance(v.keyOn)
+	v.pitchEnv.advance(v.keyOn)
+	v.filterEnv.advance(v.keyOn)
+
+	if v.fading && v.instrument != nil {
+		v.fade -= int(v.instrument.Fadeout)
+		if v.fade <= 0 {
+			v.fade = 0
+			v.active = false
+		}
+	}
+}
+
+// Play the synth's current waveform, keeping the position in the cycle.
+func (v *voice) loadSynthWaveform() {
+	pcm := common.SampleData{Channels: 1, Bits: 8, Data: []any{v.synth.Waveform()}}
+	v.pcm = &samplePCM{data: pcm.Float64()}
+	v.block = pcmBlock{}
+	v.position = math.Mod(v.position, float64(pcm.Len()))
+}
+
+// The loop region that is currently in effect, if any.
+func (v *voice) loopRegion() (start, end int, pingpong, ok bool) {
+	s := v.sample
+	length := v.pcm.frames()
+	if v.synth != nil {
+		return 0, length, false, true
+	}
+	if v.keyOn && s.Sustain && s.SustainLoopStart < s.SustainLoopEnd && s.SustainLoopEnd <= length {
+		return s.SustainLoopStart, s.SustainLoopEnd, s.PingPongSustain, true
+	}
+	if s.Loop && s.LoopStart < s.LoopEnd && s.LoopEnd <= length {
+		return s.LoopStart, s.LoopEnd, s.PingPong, true
+	}
+	return 0, length, false, false
+}
+
+// Read a point with linear interpolation. The point after the loop end is taken from the
+// loop start so the seam is interpolated correctly.
+func (v *voice) read(channel int, loopStart, loopEnd int, looping bool) float64 {
+	length := v.pcm.frames()
+	index := int(v.position)
+	frac := v.position - float64(index)
+	next := index + 1
+	if next >= loopEnd {
+		if looping && v.direction > 0 {
+			next = loopStart
+		} else {
+			next = index
+		}
+	}
+	a := v.at(channel, min(index, length-1))
+	b := v.at(channel, min(next, length-1))
+	return a + float64((b-a)*frac)
+}
+
+// A point of the sample, -1 to 1.
+func (v *voice) at(channel, frame int) float64 {
+	if !v.block.has(frame) {
+		v.block = v.pcm.block(frame)
+	}
+	return v.block.data[channel][frame-v.block.start]
+}
+
+// Read the next point of a sample channel, through the filter if it's enabled.
+func (v *voice) sampleAt(channel int, loopStart, loopEnd int, looping bool) float64 {
+	s := v.read(channel, loopStart, loopEnd, looping)
+	if v.filter.enabled {
+		s = v.filter.process(channel, s)
+	}
+	return s
+}
+
+// Mix frames of the voice into a stereo interleaved buffer. If out is nil, the voice is
+// only advanced.
+func (v *voice) mix(out []float64, frames int) {
+	for i := 0; i < frames && v.active; i++ {
+		loopStart, loopEnd, pingpong, looping := v.loopRegion()
+
+		if out != nil {
+			if v.pcm.channels() > 1 {
+				out[i*2] += float64(v.sampleAt(0, loopStart, loopEnd, looping) * v.mixLeft * 2)
+				out[i*2+1] += float64(v.sampleAt(1, loopStart, loopEnd, looping) * v.mixRight * 2)
+			} else {
+				s := v.sampleAt(0, loopStart, loopEnd, looping)
+				out[i*2] += float64(s * v.mixLeft)
+				out[i*2+1] += float64(s * v.mixRight)
+			}
+		}
+
+		v.position += v.mixStep * v.direction
+
+		if !looping {
+			if v.position >= float64(loopEnd) {
+				v.active = false
+			}
+			continue
+		}
+
+		length := float64(loopEnd - loopStart)
+		if pingpong {
+			if v.position >= float64(loopEnd) {
+				v.position = float64(loopEnd) - (v.position - float64(loopEnd)) - 1
+				v.direction = -1
+			} else if v.direction < 0 && v.position < float64(loopStart) {
+				v.position = float64(loopStart) + (float64(loopStart) - v.position)
+				v.direction = 1
+			}
+			v.position = max(float64(loopStart), min(v.position, float64(loopEnd)-1))
+		} else {
+			for v.position >= float64(loopEnd) {
+				v.position -= length
+			}
+		}
+	}
+}
@@ -0,0 +1,56 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package render
+
+import (
+	"encoding/binary"
+	"io"
+
+	"go.mukunda.com/modlib/common"
+)
+
+// Writes 16-bit PCM WAV files. The sizes in the header are filled in by Close, so the
+// destination must be seekable.
+type WavWriter struct {
+	w         io.WriteSeeker
+	rate      int
+	channels  int
+	dataBytes int
+}
+
+// Start a WAV file with the given sample rate and channel count.
+func NewWavWriter(w io.WriteSeeker, rate int, channels int) (*WavWriter, error) {
+	ww := &WavWriter{w: w, rate: rate, channels: channels}
+	if err := ww.writeHeader(); err != nil {
+		return nil, err
+	}
+	return ww, nil
+}
+
+func (ww *WavWriter) writeHeader() error {
+	return common.WriteWavHeader(ww.w, ww.rate, ww.channels, 16, ww.dataBytes, 0)
+}
+
+// Append interleaved samples.
+func (ww *WavWriter) WriteInt16(samples []int16) error {
+	if err := binary.Write(ww.w, binary.LittleEndian, samples); err != nil {
+		return err
+	}
+	ww.dataBytes += len(samples) * 2
+	return nil
+}
+
+// Finish the file by writing the final sizes into the header. This doesn't close the
+// underlying writer.
+func (ww *WavWriter) Close() error {
+	if _, err := ww.w.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := ww.writeHeader(); err != nil {
+		return err
+	}
+	_, err := ww.w.Seek(0, io.SeekEnd)
+	return err
+}
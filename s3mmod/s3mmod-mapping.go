@@ -0,0 +1,300 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package s3mmod
+
+import (
+	"strings"
+
+	"go.mukunda.com/modlib/common"
+)
+
+func iif[T any](cond bool, a, b T) T {
+	if cond {
+		return a
+	} else {
+		return b
+	}
+}
+
+// Effect letters, numbered from A = 1 like the common model.
+const (
+	effectA = 1
+	effectC = 3
+	effectV = 22
+	effectX = 24
+)
+
+func (s3m *S3mModule) ToCommon() *common.Module {
+	m := new(common.Module)
+	m.Source = common.S3mSource
+	m.Details = &common.S3mDetails{
+		Cwtv:       s3m.Header.Cwtv,
+		Ffi:        s3m.Header.Ffi,
+		Flags:      s3m.Header.Flags,
+		Special:    s3m.Header.Special,
+		UltraClick: s3m.Header.UltraClick,
+		DefaultPan: s3m.Header.DefaultPan,
+		Tracker:    s3m.Header.Tracker(),
+	}
+
+	m.Title = strings.TrimRight(string(s3m.Header.Title[:]), "\000")
+
+	m.StereoMixing = s3m.Header.MasterVolume&128 != 0
+	m.UseInstruments = false
+	m.LinearSlides = false
+
+	// S3M volumes are 0-64.
+	m.GlobalVolume = int16(min(s3m.Header.GlobalVolume, 64)) * 2
+	m.MixingVolume = int16(s3m.Header.MasterVolume & 127)
+	m.InitialSpeed = int16(s3m.Header.InitialSpeed)
+	m.InitialTempo = int16(s3m.Header.InitialTempo)
+	m.PanSeparation = 128
+
+	channels := int16(0)
+	for i, setting := range s3m.Header.ChannelSettings {
+		cs := common.ChannelSetting{InitialVolume: 64, InitialPan: 32}
+		if setting != S3mChannelUnset {
+			channels = int16(i + 1)
+			cs.Mute = setting&128 != 0
+			if m.StereoMixing {
+				cs.InitialPan = iif(setting&127 < 8, int16(13), 51)
+			}
+		}
+
+		pan := s3m.ChannelPan[i]
+		if s3m.Header.DefaultPan == 252 && pan&0x20 != 0 {
+			cs.InitialPan = int16(pan&15) * 64 / 15
+		}
+
+		m.ChannelSettings = append(m.ChannelSettings, cs)
+	}
+
+	for _, order := range s3m.Orders {
+		m.Order = append(m.Order, int16(order))
+	}
+
+	for i := range s3m.Instruments {
+		ins := &s3m.Instruments[i]
+		m.Samples = append(m.Samples, ins.ToCommon())
+		m.Instruments = append(m.Instruments, ins.ToCommonInstrument(i+1))
+	}
+
+	for _, pattern := range s3m.Patterns {
+		p := pattern.toCommon(s3m.arena)
+		if s3m.Stx {
+			stxEffects(&p)
+		}
+		m.Patterns = append(m.Patterns, p)
+		channels = max(channels, p.Channels)
+	}
+
+	m.Channels = channels
+	m.ChannelSettings = m.ChannelSettings[:channels]
+
+	if s3m.Stx {
+		m.Source = common.StxSource
+		m.Details = nil
+	}
+
+	return m
+}
+
+// Convert the sample part of an instrument. AdLib instruments produce a sample without
+// data, carrying the name and volume.
+func (ins *S3mInstrument) ToCommon() common.Sample {
+	var s common.Sample
+	h := &ins.Header
+
+	s.Name = strings.TrimRight(string(h.Name[:]), "\000")
+	s.DosFilename = strings.TrimRight(string(h.DosFilename[:]), "\000")
+
+	s.GlobalVolume = 64
+	s.DefaultVolume = int16(min(h.Volume, 64))
+	s.C5 = int(h.C2Spd)
+
+	if h.Type != InsTypeSample {
+		return s
+	}
+
+	s.S16 = h.Flags&SampFlag16bit != 0
+	s.Stereo = h.Flags&SampFlagStereo != 0
+	s.Loop = h.Flags&SampFlagLoop != 0
+	if s.Loop {
+		s.LoopStart = int(h.LoopStart())
+		s.LoopEnd = int(h.LoopEnd())
+	}
+
+	s.Data = common.SampleData{
+		Channels: int8(iif(s.Stereo, 2, 1)),
+		Bits:     int8(iif(s.S16, 16, 8)),
+		Data:     ins.Data,
+	}
+
+	return s
+}
+
+// Create an instrument that plays the sample at the given index (1-based) across the
+// whole note range. S3M has no instruments in the IT sense, but AdLib patches are kept
+// here.
+func (ins *S3mInstrument) ToCommonInstrument(sampleIndex int) common.Instrument {
+	var ci common.Instrument
+	h := &ins.Header
+
+	ci.Name = strings.TrimRight(string(h.Name[:]), "\000")
+	ci.DosFilename = strings.TrimRight(string(h.DosFilename[:]), "\000")
+	ci.GlobalVolume = 128
+	ci.DefaultPan = 32
+
+	for i := range ci.Notemap {
+		ci.Notemap[i] = common.NotemapEntry{Note: int16(i), Sample: int16(sampleIndex)}
+	}
+
+	if h.Type >= InsTypeAdlib && h.Type <= InsTypeHiHat {
+		ci.FM = ins.fmPatch()
+	}
+
+	return ci
+}
+
+// Decode the OPL registers of an AdLib instrument.
+func (ins *S3mInstrument) fmPatch() *common.FMPatch {
+	p := ins.Header.Params
+	return &common.FMPatch{
+		Kind: int16(ins.Header.Type - InsTypeAdlib),
+		Modulator: common.FMOperator{
+			Characteristic: p[0],
+			ScalingLevel:   p[2],
+			AttackDecay:    p[4],
+			SustainRelease: p[6],
+			Waveform:       p[8],
+		},
+		Carrier: common.FMOperator{
+			Characteristic: p[1],
+			ScalingLevel:   p[3],
+			AttackDecay:    p[5],
+			SustainRelease: p[7],
+			Waveform:       p[9],
+		},
+		FeedbackConnection: p[10],
+	}
+}
+
+// S3M notes are octave in the high nibble and semitone in the low nibble. S3M's C-4
+// plays at C2Spd, which corresponds to C-5 in the common model.
+func translateNote(note uint8) uint8 {
+	if note == S3mNoteEmpty {
+		return 0
+	} else if note == S3mNoteCut {
+		return common.NoteCut
+	}
+
+	n := int(note>>4)*12 + int(note&15) + 12
+	if note&15 > 11 || n > 119 {
+		return 0
+	}
+	return uint8(n + 1)
+}
+
+// Convert effect parameters where S3M differs from IT.
+func translateEffect(effect, param uint8) (uint8, uint8) {
+	switch effect {
+	case effectC:
+		// Pattern break row is decimal.
+		return effect, (param>>4)*10 + param&15
+	case effectV:
+		// Global volume is 0-64.
+		return effect, uint8(min(int(param)*2, 128))
+	case effectX:
+		// Panning is 0-128, with 164 for surround.
+		if param == 164 {
+			return 19, 0x91 // S91
+		}
+		return effect, uint8(min(int(param)*2, 255))
+	}
+	return effect, param
+}
+
+func (s3p *S3mPattern) ToCommon() common.Pattern {
+	return s3p.toCommon(nil)
+}
+
+// Convert the pattern, allocating its entries from arena.
+func (s3p *S3mPattern) toCommon(arena *common.Arena) common.Pattern {
+	var p common.Pattern
+	p.Rows = make([]common.PatternRow, 0, S3mPatternRows)
+
+	dataRead := 0
+	data := s3p.Data
+
+	nextByte := func() byte {
+		if dataRead >= len(data) {
+			return 0
+		}
+
+		byt := data[dataRead]
+		dataRead++
+		return byt
+	}
+
+	channels := 0
+	var entries []common.PatternEntry
+
+	for row := 0; row < S3mPatternRows; row++ {
+		entries = entries[:0]
+		for {
+			what := nextByte()
+			if what == 0 {
+				break
+			}
+
+			entry := common.PatternEntry{}
+
+			channel := int(what & PmaskChannel)
+			entry.Channel = uint8(channel)
+			if channel >= channels {
+				channels = channel + 1
+			}
+
+			if what&PmaskNoteIns != 0 {
+				entry.Note = translateNote(nextByte())
+				entry.Instrument = int16(nextByte())
+			}
+
+			if what&PmaskVol != 0 {
+				entry.VolumeCommand = 1
+				entry.VolumeParam = min(nextByte(), 64)
+			}
+
+			if what&PmaskEffect != 0 {
+				effect := nextByte()
+				param := nextByte()
+				entry.Effect, entry.EffectParam = translateEffect(effect, param)
+			}
+
+			entries = append(entries, entry)
+		}
+
+		p.Rows = append(p.Rows, common.PatternRow{Entries: arena.Entries(entries)})
+	}
+
+	p.Channels = int16(channels)
+
+	return p
+}
+
+// STX patterns use STM's speed command, with the speed in the high nibble.
+func stxEffects(p *common.Pattern) {
+	for _, row := range p.Rows {
+		for i := range row.Entries {
+			e := &row.Entries[i]
+			if e.Effect == effectA {
+				e.EffectParam >>= 4
+				if e.EffectParam == 0 {
+					e.Effect = 0
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,432 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+/*
+This package is for working with Scream Tracker 3 files directly.
+*/
+package s3mmod
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"go.mukunda.com/modlib/common"
+)
+
+// This is used to read S3M files.
+type S3mReader struct {
+	// Enable extra checks that will cause loading errors if incorrect or corrupted data is
+	// detected.
+	Strict bool
+
+	// Called as instruments (with their samples) and patterns are read. Optional.
+	Progress common.ProgressFunc
+	// Receives debug traces of the file structure, like offsets and chunk sizes, and of
+	// decisions made while loading. Optional.
+	Logger *slog.Logger
+
+	// Where to allocate the pattern entries of converted modules. Optional; see
+	// common.Arena.
+	Arena *common.Arena
+}
+
+// Holds all components of an S3M file.
+type S3mModule struct {
+	Header S3mModuleHeader
+
+	Orders      []uint8
+	Instruments []S3mInstrument
+	Patterns    []S3mPattern
+
+	// Channel pan table, only valid if Header.DefaultPan is 252.
+	ChannelPan [32]uint8
+
+	// Loaded from an STX file. The header was converted, and Axx stores the speed in the
+	// high nibble like STM.
+	Stx bool
+
+	arena *common.Arena // From S3mReader.Arena
+}
+
+// The direct structure of the main S3M file header.
+type S3mModuleHeader struct {
+	Title           [28]byte
+	Eof             uint8
+	Type            uint8
+	_               uint16
+	OrderCount      uint16
+	InstrumentCount uint16
+	PatternCount    uint16
+	Flags           uint16
+	Cwtv            uint16
+	Ffi             uint16 // Sample format, 1 = signed, 2 = unsigned
+	FileCode        [4]byte
+	GlobalVolume    uint8
+	InitialSpeed    uint8
+	InitialTempo    uint8
+	MasterVolume    uint8 // Bit 7 = stereo
+	UltraClick      uint8
+	DefaultPan      uint8 // 252 = read channel pan table
+	_               [8]byte
+	Special         uint16
+
+	ChannelSettings [32]uint8
+}
+
+// Identify the tracker that wrote the file from the high nibble of cwtv, following the
+// table documented by OpenMPT.
+func (h *S3mModuleHeader) Tracker() string {
+	switch h.Cwtv >> 12 {
+	case 0x1:
+		return "Scream Tracker 3"
+	case 0x2:
+		return "Imago Orpheus"
+	case 0x3:
+		return "Impulse Tracker"
+	case 0x4:
+		return "Schism Tracker"
+	case 0x5:
+		return "OpenMPT"
+	case 0x6:
+		return "BeRoTracker"
+	case 0x7:
+		return "CreamTracker"
+	}
+	return "Unknown"
+}
+
+// Header flags.
+const (
+	S3mFlagST2Vibrato      = 1
+	S3mFlagST2Tempo        = 2
+	S3mFlagAmigaSlides     = 4
+	S3mFlagVolOptimization = 8
+	S3mFlagAmigaLimits     = 16
+	S3mFlagSBFilter        = 32
+	S3mFlagFastVolSlides   = 64
+	S3mFlagSpecial         = 128
+)
+
+// Instrument types.
+const (
+	InsTypeEmpty     = 0
+	InsTypeSample    = 1
+	InsTypeAdlib     = 2
+	InsTypeBassDrum  = 3
+	InsTypeSnareDrum = 4
+	InsTypeTom       = 5
+	InsTypeCymbal    = 6
+	InsTypeHiHat     = 7
+)
+
+// Sample flags.
+const (
+	SampFlagLoop   = 1
+	SampFlagStereo = 2
+	SampFlag16bit  = 4
+)
+
+// The direct structure of an S3M instrument header. Sample and AdLib instruments share
+// the layout, with Params holding different data for each.
+type S3mInstrumentHeader struct {
+	Type        uint8
+	DosFilename [12]byte
+	MemSeg      [3]byte
+
+	// Length, LoopStart, LoopEnd (uint32 each) for samples. OPL registers for AdLib
+	// instruments.
+	Params [12]byte
+
+	Volume uint8
+	_      byte
+	Pack   uint8
+	Flags  uint8
+	C2Spd  uint32
+	_      [12]byte
+	Name   [28]byte
+
+	FileCode [4]byte
+}
+
+// Length of the sample in frames.
+func (h *S3mInstrumentHeader) Length() uint32 {
+	return binary.LittleEndian.Uint32(h.Params[0:])
+}
+
+// Start of the sample loop.
+func (h *S3mInstrumentHeader) LoopStart() uint32 {
+	return binary.LittleEndian.Uint32(h.Params[4:])
+}
+
+// End of the sample loop.
+func (h *S3mInstrumentHeader) LoopEnd() uint32 {
+	return binary.LittleEndian.Uint32(h.Params[8:])
+}
+
+// File offset of the sample data.
+func (h *S3mInstrumentHeader) SamplePointer() int64 {
+	return (int64(h.MemSeg[0])<<16 | int64(h.MemSeg[1]) | int64(h.MemSeg[2])<<8) * 16
+}
+
+// Container for the header and data of an instrument. Sample data is decoded into signed
+// PCM.
+type S3mInstrument struct {
+	Header   S3mInstrumentHeader
+	Channels uint8
+	Bits     uint8
+
+	// Contains [][]int16 or [][]int8 (Data[channel][sample])
+	Data []any
+}
+
+// Container for a pattern. S3M patterns always have 64 rows.
+type S3mPattern struct {
+	// Packed data
+	Data []byte
+}
+
+// Mask constants for the packed pattern data.
+const (
+	PmaskChannel = 31
+	PmaskNoteIns = 32
+	PmaskVol     = 64
+	PmaskEffect  = 128
+)
+
+const (
+	S3mPatternRows  = 64
+	S3mNoteEmpty    = 255
+	S3mNoteCut      = 254
+	S3mChannelUnset = 255
+)
+
+var ErrInvalidSource = errors.New("invalid/corrupted source")
+var ErrUnsupportedSource = errors.New("unsupported source")
+
+// Load an S3M file into memory.
+func LoadS3mFile(filename string) (*S3mModule, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	reader := S3mReader{}
+
+	return reader.ReadS3mModule(f)
+}
+
+// Load an S3M file into memory from the given stream.
+func (reader *S3mReader) ReadS3mModule(r io.ReadSeeker) (*S3mModule, error) {
+	s3m := &S3mModule{arena: reader.Arena}
+
+	var header S3mModuleHeader
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return nil, err
+	}
+
+	s3m.Header = header
+
+	if string(header.FileCode[:]) != "SCRM" {
+		return nil, fmt.Errorf("%w: expected 'SCRM' signature", ErrInvalidSource)
+	}
+
+	if reader.Strict && header.Type != 16 {
+		return nil, fmt.Errorf("%w: strict - expected module type 16", ErrInvalidSource)
+	}
+
+	orders := make([]uint8, header.OrderCount)
+	if err := binary.Read(r, binary.LittleEndian, &orders); err != nil {
+		return s3m, err
+	}
+
+	s3m.Orders = orders
+
+	instrTable := make([]uint16, header.InstrumentCount)
+	patternTable := make([]uint16, header.PatternCount)
+
+	if err := binary.Read(r, binary.LittleEndian, &instrTable); err != nil {
+		return s3m, err
+	}
+
+	if err := binary.Read(r, binary.LittleEndian, &patternTable); err != nil {
+		return s3m, err
+	}
+
+	if header.DefaultPan == 252 {
+		if err := binary.Read(r, binary.LittleEndian, &s3m.ChannelPan); err != nil {
+			return s3m, err
+		}
+	}
+
+	signed := header.Ffi == 1
+	common.Debug(reader.Logger, "s3m header", "cwtv", fmt.Sprintf("%04X", header.Cwtv), "ffi", header.Ffi,
+		"orders", header.OrderCount, "instruments", header.InstrumentCount, "patterns", header.PatternCount,
+		"channel_pan", header.DefaultPan == 252)
+
+	for i := 0; i < int(header.InstrumentCount); i++ {
+		reader.Progress.Report(common.StageInstruments, i, int(header.InstrumentCount))
+		if instrTable[i] == 0 {
+			s3m.Instruments = append(s3m.Instruments, S3mInstrument{})
+			continue
+		}
+
+		r.Seek(int64(instrTable[i])*16, io.SeekStart)
+		common.Debug(reader.Logger, "s3m instrument", "index", i, "offset", int(instrTable[i])*16)
+		if ins, err := reader.ReadS3mInstrument(r, signed); err != nil {
+			return s3m, err
+		} else {
+			s3m.Instruments = append(s3m.Instruments, ins)
+		}
+	}
+
+	reader.Progress.Report(common.StageInstruments, int(header.InstrumentCount), int(header.InstrumentCount))
+
+	for i := 0; i < int(header.PatternCount); i++ {
+		reader.Progress.Report(common.StagePatterns, i, int(header.PatternCount))
+		if patternTable[i] == 0 {
+			s3m.Patterns = append(s3m.Patterns, S3mPattern{})
+			continue
+		}
+
+		r.Seek(int64(patternTable[i])*16, io.SeekStart)
+		common.Debug(reader.Logger, "s3m pattern", "index", i, "offset", int(patternTable[i])*16)
+		if pattern, err := reader.readS3mPattern(r); err != nil {
+			return s3m, err
+		} else {
+			s3m.Patterns = append(s3m.Patterns, pattern)
+		}
+	}
+	reader.Progress.Report(common.StagePatterns, int(header.PatternCount), int(header.PatternCount))
+
+	return s3m, nil
+}
+
+// Read raw PCM data with an offset applied (for adding sign to samples). The length comes
+// from the sample header, so it's checked against the rest of the file before allocating.
+func readPcm[T int8 | int16](r io.ReadSeeker, length int, offset int) ([]T, error) {
+	pos, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	end, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.Seek(pos, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if size := int64(length) * int64(binary.Size(T(0))); size > end-pos {
+		return nil, fmt.Errorf("%w: sample data needs %d bytes, %d are left in the file",
+			ErrInvalidSource, size, max(end-pos, 0))
+	}
+
+	data := make([]T, length)
+	if err := binary.Read(r, binary.LittleEndian, &data); err != nil {
+		return nil, err
+	}
+	if offset != 0 {
+		for i := 0; i < len(data); i++ {
+			data[i] += T(offset)
+		}
+	}
+	return data, nil
+}
+
+// Read an S3M instrument and its sample data from the stream. signed selects the sample
+// encoding (from the module header).
+func (reader *S3mReader) ReadS3mInstrument(r io.ReadSeeker, signed bool) (S3mInstrument, error) {
+	var header S3mInstrumentHeader
+	var ins S3mInstrument
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return ins, err
+	}
+
+	ins.Header = header
+
+	if header.Type != InsTypeSample {
+		if reader.Strict && header.Type >= InsTypeAdlib && string(header.FileCode[:]) != "SCRI" {
+			return ins, fmt.Errorf("%w: strict - expected 'SCRI' header", ErrInvalidSource)
+		}
+		return ins, nil
+	}
+
+	if string(header.FileCode[:]) != "SCRS" {
+		if reader.Strict {
+			return ins, fmt.Errorf("%w: strict - expected 'SCRS' header", ErrInvalidSource)
+		}
+	}
+
+	if header.Pack != 0 {
+		return ins, fmt.Errorf("%w: packed samples not supported", ErrUnsupportedSource)
+	}
+
+	bits16 := header.Flags&SampFlag16bit != 0
+	stereo := header.Flags&SampFlagStereo != 0
+	length := int(header.Length())
+
+	ins.Channels = 1
+	if stereo {
+		ins.Channels = 2
+	}
+
+	ins.Bits = 8
+	if bits16 {
+		ins.Bits = 16
+	}
+
+	offset := 0
+	if !signed {
+		if bits16 {
+			offset = -32768
+		} else {
+			offset = -128
+		}
+	}
+
+	r.Seek(header.SamplePointer(), io.SeekStart)
+
+	// Stereo samples store the whole left channel, followed by the right channel.
+	for ch := 0; ch < int(ins.Channels); ch++ {
+		if bits16 {
+			d, err := readPcm[int16](r, length, offset)
+			if err != nil {
+				return ins, err
+			}
+			ins.Data = append(ins.Data, d)
+		} else {
+			d, err := readPcm[int8](r, length, offset)
+			if err != nil {
+				return ins, err
+			}
+			ins.Data = append(ins.Data, d)
+		}
+	}
+
+	return ins, nil
+}
+
+// Read an S3M pattern from the stream. The data is not unpacked.
+func (reader *S3mReader) readS3mPattern(r io.Reader) (S3mPattern, error) {
+	var s3p S3mPattern
+	var length uint16
+	if err := binary.Read(r, binary.LittleEndian, &length); err != nil {
+		return s3p, err
+	}
+
+	// The length includes the length field.
+	data := make([]byte, max(int(length)-2, 0))
+	if err := binary.Read(r, binary.LittleEndian, &data); err != nil {
+		return s3p, err
+	}
+
+	s3p.Data = data
+
+	return s3p, nil
+}
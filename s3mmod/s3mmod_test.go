@@ -0,0 +1,230 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package s3mmod
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mukunda.com/modlib/common"
+)
+
+// Write a structure into a buffer at the given offset, growing it as needed.
+func writeAt(buf []byte, offset int, data any) []byte {
+	var b bytes.Buffer
+	binary.Write(&b, binary.LittleEndian, data)
+	if need := offset + b.Len(); need > len(buf) {
+		buf = append(buf, make([]byte, need-len(buf))...)
+	}
+	copy(buf[offset:], b.Bytes())
+	return buf
+}
+
+// Build a small S3M with one sample, one AdLib instrument, and one pattern.
+func buildTestS3m() []byte {
+	header := S3mModuleHeader{
+		Eof:             0x1A,
+		Type:            16,
+		OrderCount:      2,
+		InstrumentCount: 2,
+		PatternCount:    1,
+		Cwtv:            0x1320,
+		Ffi:             2,
+		GlobalVolume:    64,
+		InitialSpeed:    6,
+		InitialTempo:    125,
+		MasterVolume:    0x80 | 48,
+		DefaultPan:      0,
+	}
+	copy(header.Title[:], "s3m test")
+	copy(header.FileCode[:], "SCRM")
+	for i := range header.ChannelSettings {
+		header.ChannelSettings[i] = S3mChannelUnset
+	}
+	header.ChannelSettings[0] = 0
+	header.ChannelSettings[1] = 8
+
+	const (
+		sampleOffset  = 112
+		adlibOffset   = 192
+		patternOffset = 272
+		dataOffset    = 416
+	)
+
+	sample := S3mInstrumentHeader{
+		Type:   InsTypeSample,
+		Volume: 48,
+		Flags:  SampFlagLoop,
+		C2Spd:  8363,
+		MemSeg: [3]byte{0, dataOffset / 16, 0},
+	}
+	copy(sample.Name[:], "sample")
+	copy(sample.FileCode[:], "SCRS")
+	binary.LittleEndian.PutUint32(sample.Params[0:], 8)
+	binary.LittleEndian.PutUint32(sample.Params[4:], 2)
+	binary.LittleEndian.PutUint32(sample.Params[8:], 8)
+
+	adlib := S3mInstrumentHeader{
+		Type:   InsTypeAdlib,
+		Volume: 63,
+		C2Spd:  8363,
+		Params: [12]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 0},
+	}
+	copy(adlib.Name[:], "fm")
+	copy(adlib.FileCode[:], "SCRI")
+
+	pattern := []byte{PmaskNoteIns | PmaskVol | PmaskEffect, 0x40, 1, 32, effectC, 0x12, 0}
+	pattern = append(pattern, make([]byte, 63)...)
+
+	buf := writeAt(nil, 0, header)
+	buf = writeAt(buf, 0x60, []uint8{0, 255})
+	buf = writeAt(buf, 0x62, []uint16{sampleOffset / 16, adlibOffset / 16})
+	buf = writeAt(buf, 0x66, []uint16{patternOffset / 16})
+	buf = writeAt(buf, sampleOffset, sample)
+	buf = writeAt(buf, adlibOffset, adlib)
+	buf = writeAt(buf, patternOffset, uint16(len(pattern)+2))
+	buf = writeAt(buf, patternOffset+2, pattern)
+	buf = writeAt(buf, dataOffset, []uint8{128, 129, 130, 131, 127, 126, 125, 124})
+	return buf
+}
+
+func TestLoading(t *testing.T) {
+	reader := S3mReader{Strict: true}
+	s3m, err := reader.ReadS3mModule(bytes.NewReader(buildTestS3m()))
+	assert.NoError(t, err)
+
+	mod := s3m.ToCommon()
+	assert.Equal(t, common.S3mSource, mod.Source)
+	assert.Equal(t, "s3m test", mod.Title)
+	assert.Equal(t, int16(128), mod.GlobalVolume)
+	assert.Equal(t, int16(2), mod.Channels)
+	assert.Equal(t, []int16{13, 51}, []int16{mod.ChannelSettings[0].InitialPan, mod.ChannelSettings[1].InitialPan})
+	assert.Equal(t, []int16{0, 255}, mod.Order)
+
+	details, ok := mod.Details.(*common.S3mDetails)
+	assert.True(t, ok)
+	assert.Equal(t, common.S3mSource, details.SourceFormat())
+	assert.Equal(t, s3m.Header.Cwtv, details.Cwtv)
+	assert.Equal(t, s3m.Header.Ffi, details.Ffi)
+	assert.Equal(t, "Scream Tracker 3", details.Tracker)
+
+	assert.Len(t, mod.Samples, 2)
+	assert.Equal(t, []any{[]int8{0, 1, 2, 3, -1, -2, -3, -4}}, mod.Samples[0].Data.Data)
+	assert.True(t, mod.Samples[0].Loop)
+	assert.Equal(t, 2, mod.Samples[0].LoopStart)
+	assert.Nil(t, mod.Instruments[0].FM)
+
+	assert.Equal(t, "fm", mod.Instruments[1].Name)
+	assert.Equal(t, &common.FMPatch{
+		Kind:               common.FMMelodic,
+		Modulator:          common.FMOperator{Characteristic: 1, ScalingLevel: 3, AttackDecay: 5, SustainRelease: 7, Waveform: 9},
+		Carrier:            common.FMOperator{Characteristic: 2, ScalingLevel: 4, AttackDecay: 6, SustainRelease: 8, Waveform: 10},
+		FeedbackConnection: 11,
+	}, mod.Instruments[1].FM)
+	assert.Equal(t, int16(2), mod.Instruments[1].Notemap[60].Sample)
+
+	assert.Len(t, mod.Patterns[0].Rows, 64)
+	assert.Equal(t, []common.PatternEntry{{
+		Channel:       0,
+		Note:          1 + 12*5, // C-5
+		Instrument:    1,
+		VolumeCommand: 1,
+		VolumeParam:   32,
+		Effect:        effectC,
+		EffectParam:   12,
+	}}, mod.Patterns[0].Rows[0].Entries)
+}
+
+// Build a small STX with one sample and one pattern.
+func buildTestStx() []byte {
+	const (
+		patternTable = 64
+		sampleTable  = 80
+		channelTable = 96
+		sampleOffset = 176
+		patternOff   = 256
+		dataOffset   = 400
+	)
+
+	header := StxModuleHeader{
+		PatternTable: patternTable / 16,
+		SampleTable:  sampleTable / 16,
+		ChannelTable: channelTable / 16,
+		GlobalVolume: 64,
+		InitialTempo: 0x60,
+		PatternCount: 1,
+		SampleCount:  1,
+		OrderCount:   2,
+	}
+	copy(header.Title[:], "stx test")
+	copy(header.TrackerName[:], "!Scream!")
+	copy(header.FileCode[:], "SCRM")
+
+	sample := S3mInstrumentHeader{
+		Type:   InsTypeSample,
+		Volume: 64,
+		C2Spd:  8363,
+		MemSeg: [3]byte{0, dataOffset / 16, 0},
+	}
+	copy(sample.FileCode[:], "SCRS")
+	binary.LittleEndian.PutUint32(sample.Params[0:], 4)
+
+	pattern := []byte{PmaskNoteIns | PmaskEffect | 1, 0x40, 1, effectA, 0x30, 0}
+	pattern = append(pattern, make([]byte, 63)...)
+
+	buf := writeAt(nil, 0, header)
+	buf = writeAt(buf, patternTable, []uint16{patternOff / 16})
+	buf = writeAt(buf, sampleTable, []uint16{sampleOffset / 16})
+	buf = writeAt(buf, channelTable+32, []uint8{0, 0, 0, 0, 0, 255, 0, 0, 0, 0})
+	buf = writeAt(buf, sampleOffset, sample)
+	buf = writeAt(buf, patternOff, uint16(len(pattern)+2))
+	buf = writeAt(buf, patternOff+2, pattern)
+	buf = writeAt(buf, dataOffset, []int8{0, 10, 20, 30})
+	return buf
+}
+
+func TestOversizedSample(t *testing.T) {
+	// Sample length 0x7FFFFFFF, in the sample header at 112.
+	data := buildTestS3m()
+	binary.LittleEndian.PutUint32(data[112+16:], 0x7FFFFFFF)
+
+	reader := S3mReader{}
+	_, err := reader.ReadS3mModule(bytes.NewReader(data))
+	assert.ErrorIs(t, err, ErrInvalidSource)
+
+	// Data cut short by one byte.
+	data = buildTestS3m()
+	_, err = reader.ReadS3mModule(bytes.NewReader(data[:len(data)-1]))
+	assert.ErrorIs(t, err, ErrInvalidSource)
+}
+
+func TestStx(t *testing.T) {
+	reader := S3mReader{Strict: true}
+	stx, err := reader.ReadStxModule(bytes.NewReader(buildTestStx()))
+	assert.NoError(t, err)
+
+	mod := stx.ToCommon()
+	assert.Equal(t, common.StxSource, mod.Source)
+	assert.Nil(t, mod.Details)
+	assert.Equal(t, "stx test", mod.Title)
+	assert.EqualValues(t, 6, mod.InitialSpeed)
+	assert.Equal(t, []int16{0, 255}, mod.Order)
+	assert.EqualValues(t, 4, mod.Channels)
+	assert.Equal(t, []any{[]int8{0, 10, 20, 30}}, mod.Samples[0].Data.Data)
+
+	entry := mod.Patterns[0].Rows[0].Entries[0]
+	assert.EqualValues(t, 1, entry.Channel)
+	assert.EqualValues(t, 61, entry.Note)
+	assert.EqualValues(t, effectA, entry.Effect)
+	assert.EqualValues(t, 3, entry.EffectParam)
+}
+
+func TestNoteActions(t *testing.T) {
+	assert.EqualValues(t, 0, translateNote(S3mNoteEmpty))
+	assert.EqualValues(t, common.NoteCut, translateNote(S3mNoteCut))
+	assert.EqualValues(t, 61, translateNote(0x40))
+}
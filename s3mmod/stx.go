@@ -0,0 +1,124 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package s3mmod
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// The direct structure of an STX (STMIK) file header. STX files use S3M samples and
+// patterns with an STM-like header.
+type StxModuleHeader struct {
+	Title        [20]byte
+	TrackerName  [8]byte
+	PatternSize  uint16
+	_            uint16
+	PatternTable uint16 // Paragraph offsets
+	SampleTable  uint16
+	ChannelTable uint16
+	_            uint32
+	GlobalVolume uint8
+	InitialTempo uint8 // Speed in the high nibble
+	_            uint32
+	PatternCount uint16
+	SampleCount  uint16
+	OrderCount   uint16
+	_            [6]byte
+	FileCode     [4]byte
+}
+
+// STX files always have four channels, panned like Scream Tracker's defaults.
+var stxChannelSettings = [4]uint8{0, 8, 9, 1}
+
+// Orders are stored after the channel table, in 5-byte entries where only the first byte
+// is used.
+const (
+	stxChannelTableSize = 32
+	stxOrderSize        = 5
+)
+
+// Load an STX file. It's converted into an S3mModule with Stx set, so the S3M mapping can
+// be used.
+func (reader *S3mReader) ReadStxModule(r io.ReadSeeker) (*S3mModule, error) {
+	var header StxModuleHeader
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return nil, err
+	}
+
+	if string(header.FileCode[:]) != "SCRM" {
+		return nil, fmt.Errorf("%w: expected 'SCRM' signature", ErrInvalidSource)
+	}
+
+	s3m := &S3mModule{Stx: true, arena: reader.Arena}
+	h := &s3m.Header
+	copy(h.Title[:], header.Title[:])
+	h.Type = 16
+	h.OrderCount = header.OrderCount
+	h.InstrumentCount = header.SampleCount
+	h.PatternCount = header.PatternCount
+	h.Ffi = 1
+	h.GlobalVolume = header.GlobalVolume
+	h.InitialSpeed = max(header.InitialTempo>>4, 1)
+	h.InitialTempo = 125
+	h.MasterVolume = 0x80 | 48
+	copy(h.FileCode[:], "SCRM")
+	for i := range h.ChannelSettings {
+		h.ChannelSettings[i] = S3mChannelUnset
+	}
+	copy(h.ChannelSettings[:], stxChannelSettings[:])
+
+	r.Seek(int64(header.ChannelTable)*16+stxChannelTableSize, io.SeekStart)
+	orders := make([]byte, int(header.OrderCount)*stxOrderSize)
+	if err := binary.Read(r, binary.LittleEndian, orders); err != nil {
+		return s3m, err
+	}
+	for i := range int(header.OrderCount) {
+		s3m.Orders = append(s3m.Orders, orders[i*stxOrderSize])
+	}
+
+	sampleTable := make([]uint16, header.SampleCount)
+	r.Seek(int64(header.SampleTable)*16, io.SeekStart)
+	if err := binary.Read(r, binary.LittleEndian, sampleTable); err != nil {
+		return s3m, err
+	}
+
+	patternTable := make([]uint16, header.PatternCount)
+	r.Seek(int64(header.PatternTable)*16, io.SeekStart)
+	if err := binary.Read(r, binary.LittleEndian, patternTable); err != nil {
+		return s3m, err
+	}
+
+	for _, pointer := range sampleTable {
+		if pointer == 0 {
+			s3m.Instruments = append(s3m.Instruments, S3mInstrument{})
+			continue
+		}
+
+		r.Seek(int64(pointer)*16, io.SeekStart)
+		ins, err := reader.ReadS3mInstrument(r, true)
+		if err != nil {
+			return s3m, err
+		}
+		s3m.Instruments = append(s3m.Instruments, ins)
+	}
+
+	for _, pointer := range patternTable {
+		if pointer == 0 {
+			s3m.Patterns = append(s3m.Patterns, S3mPattern{})
+			continue
+		}
+
+		r.Seek(int64(pointer)*16, io.SeekStart)
+		pattern, err := reader.readS3mPattern(r)
+		if err != nil {
+			return s3m, err
+		}
+		s3m.Patterns = append(s3m.Patterns, pattern)
+	}
+
+	return s3m, nil
+}
@@ -0,0 +1,107 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package scanner
+
+import (
+	"slices"
+	"strings"
+)
+
+// Similarity at which FindDuplicates considers two modules copies: the same pattern data
+// and at least half of the samples in common.
+const DefaultDuplicateThreshold = 0.75
+
+// Modules that are likely copies of each other.
+type DuplicateGroup struct {
+	Paths []string // Sorted
+
+	// Lowest similarity between two linked members. 1 means the content is identical and
+	// only the metadata or container differs.
+	Similarity float64
+}
+
+// Group scan results by content. Results with errors are ignored. Modules are linked when
+// their fingerprints are at least `threshold` similar, and linked modules form a group, so
+// a member may be less similar to members other than the one it was linked through. Only
+// modules that share the song or a sample are compared, so this scales to large
+// collections. Groups are sorted by their first path.
+func FindDuplicates(results []ScanResult, threshold float64) []DuplicateGroup {
+	// Index the modules by each of their hashes to find candidates.
+	candidates := make(map[uint64][]int)
+	for i := range results {
+		if results[i].Err != nil {
+			continue
+		}
+		fp := &results[i].Metadata.Fingerprint
+		candidates[fp.Song] = append(candidates[fp.Song], i)
+		for _, h := range slices.Compact(slices.Clone(fp.Samples)) {
+			candidates[h] = append(candidates[h], i)
+		}
+	}
+
+	parent := make([]int, len(results))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	lowest := make(map[int]float64) // By root
+	compared := make(map[[2]int]bool)
+	for _, list := range candidates {
+		for a := 0; a < len(list); a++ {
+			for b := a + 1; b < len(list); b++ {
+				pair := [2]int{list[a], list[b]}
+				if compared[pair] {
+					continue
+				}
+				compared[pair] = true
+
+				score := results[pair[0]].Metadata.Fingerprint.Similarity(&results[pair[1]].Metadata.Fingerprint)
+				if score < threshold {
+					continue
+				}
+				ra, rb := find(pair[0]), find(pair[1])
+				if ra == rb {
+					continue
+				}
+				low := score
+				for _, r := range []int{ra, rb} {
+					if s, ok := lowest[r]; ok {
+						low = min(low, s)
+					}
+				}
+				delete(lowest, ra)
+				delete(lowest, rb)
+				parent[rb] = ra
+				lowest[ra] = low
+			}
+		}
+	}
+
+	// Every root in lowest has at least two members.
+	members := make(map[int][]string)
+	for i := range results {
+		root := find(i)
+		if _, ok := lowest[root]; ok {
+			members[root] = append(members[root], results[i].Path)
+		}
+	}
+
+	var groups []DuplicateGroup
+	for root, paths := range members {
+		slices.Sort(paths)
+		groups = append(groups, DuplicateGroup{Paths: paths, Similarity: lowest[root]})
+	}
+	slices.SortFunc(groups, func(a, b DuplicateGroup) int {
+		return strings.Compare(a.Paths[0], b.Paths[0])
+	})
+	return groups
+}
@@ -0,0 +1,184 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+/*
+This package scans collections of module files, loading them concurrently.
+*/
+package scanner
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"runtime"
+	"slices"
+	"sync"
+
+	"go.mukunda.com/modlib"
+	"go.mukunda.com/modlib/analysis"
+	"go.mukunda.com/modlib/common"
+)
+
+type Options struct {
+	// Number of files loaded at once. 0 = runtime.NumCPU().
+	Workers int
+
+	// Report files that aren't recognized as modules. They're skipped by default.
+	IncludeUnknown bool
+}
+
+// Summary of a module found by the scanner.
+type Metadata struct {
+	Title       string
+	Source      common.ModuleSourceFormat
+	Channels    int
+	Orders      int
+	Patterns    int
+	Instruments int
+	Samples     int
+	SampleBytes int // Size of the decoded PCM data
+	Message     string
+
+	// Content hashes for finding duplicates with FindDuplicates.
+	Fingerprint analysis.Fingerprint
+}
+
+// The result of loading one file. If Err is set, the other fields besides Path are empty.
+type ScanResult struct {
+	Path     string
+	Metadata Metadata
+	Warnings []string // Non-fatal problems found while loading
+	Err      error
+}
+
+// Scan the files under root in fsys. Results are streamed in no particular order, and the
+// channel is closed when the scan is finished or the context is canceled. Zip and gzip
+// archives are loaded like module files.
+func Scan(ctx context.Context, fsys fs.FS, root string, options Options) <-chan ScanResult {
+	workers := options.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	paths := make(chan string)
+	results := make(chan ScanResult)
+
+	send := func(result ScanResult) bool {
+		select {
+		case results <- result:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	go func() {
+		defer close(paths)
+		fs.WalkDir(fsys, root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if !send(ScanResult{Path: path, Err: err}) {
+					return fs.SkipAll
+				}
+				return nil
+			}
+			if d.IsDir() {
+				return nil
+			}
+			select {
+			case paths <- path:
+				return nil
+			case <-ctx.Done():
+				return fs.SkipAll
+			}
+		})
+	}()
+
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Each worker reuses the pattern memory of the modules it has summarized.
+			var arena common.Arena
+			for path := range paths {
+				result := scanFile(fsys, path, &arena)
+				arena.Reset()
+				if errors.Is(result.Err, modlib.ErrUnknownModuleFormat) && !options.IncludeUnknown {
+					continue
+				}
+				if !send(result) {
+					// Drain the walker so it can exit.
+					for range paths {
+					}
+					return
+				}
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	return results
+}
+
+// Scan a directory on disk.
+func ScanDir(ctx context.Context, dir string, options Options) <-chan ScanResult {
+	return Scan(ctx, os.DirFS(dir), ".", options)
+}
+
+func scanFile(fsys fs.FS, path string, arena *common.Arena) ScanResult {
+	result := ScanResult{Path: path}
+
+	file, err := fsys.Open(path)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	defer file.Close()
+
+	size := int64(-1)
+	if info, err := file.Stat(); err == nil {
+		size = info.Size()
+	}
+
+	m, err := modlib.LoadModuleFromReaderWithOptions(file, size, modlib.LoadOptions{RawText: true, Arena: arena})
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	result.Metadata = summarize(m)
+	result.Warnings = warnings(m)
+	return result
+}
+
+func summarize(m *common.Module) Metadata {
+	md := Metadata{
+		Title:       m.Title,
+		Source:      m.Source,
+		Channels:    int(m.Channels),
+		Orders:      len(m.Order),
+		Patterns:    len(m.Patterns),
+		Instruments: len(m.Instruments),
+		Samples:     len(m.Samples),
+		Message:     m.Message,
+		Fingerprint: analysis.ComputeFingerprint(m),
+	}
+	for i := range m.Samples {
+		data := &m.Samples[i].Data
+		md.SampleBytes += data.Len() * int(data.Channels) * int(data.Bits) / 8
+	}
+	return md
+}
+
+func warnings(m *common.Module) []string {
+	if details, ok := m.Details.(*common.ItDetails); ok {
+		return append(slices.Clone(details.Quirks), details.Warnings...)
+	}
+	return nil
+}
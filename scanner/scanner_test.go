@@ -0,0 +1,109 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package scanner
+
+import (
+	"context"
+	"os"
+	"slices"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"go.mukunda.com/modlib/analysis"
+	"go.mukunda.com/modlib/common"
+)
+
+func TestScan(t *testing.T) {
+	source, err := os.ReadFile("../itmod/test/reflection.it")
+	assert.NoError(t, err)
+
+	fsys := fstest.MapFS{
+		"a/reflection.it":   {Data: source},
+		"a/b/reflection.it": {Data: source},
+		"a/readme.txt":      {Data: []byte("hello")},
+		"broken.it":         {Data: source[:300]},
+	}
+
+	var paths []string
+	for result := range Scan(context.Background(), fsys, ".", Options{Workers: 2}) {
+		paths = append(paths, result.Path)
+		if result.Path == "broken.it" {
+			assert.Error(t, result.Err)
+			continue
+		}
+		assert.NoError(t, result.Err)
+		assert.Equal(t, "reflection", result.Metadata.Title)
+		assert.Equal(t, common.ItSource, result.Metadata.Source)
+		assert.Positive(t, result.Metadata.SampleBytes)
+	}
+	slices.Sort(paths)
+	assert.Equal(t, []string{"a/b/reflection.it", "a/reflection.it", "broken.it"}, paths)
+
+	var unknown int
+	for result := range Scan(context.Background(), fsys, "a", Options{IncludeUnknown: true}) {
+		if result.Err != nil {
+			unknown++
+		}
+	}
+	assert.Equal(t, 1, unknown)
+}
+
+func TestScanCancel(t *testing.T) {
+	source, err := os.ReadFile("../itmod/test/reflection.it")
+	assert.NoError(t, err)
+
+	fsys := fstest.MapFS{}
+	for _, name := range []string{"1.it", "2.it", "3.it", "4.it"} {
+		fsys[name] = &fstest.MapFile{Data: source}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	results := Scan(ctx, fsys, ".", Options{Workers: 1})
+	<-results
+	cancel()
+	for range results {
+	}
+}
+
+func TestFindDuplicates(t *testing.T) {
+	source, err := os.ReadFile("../itmod/test/reflection.it")
+	assert.NoError(t, err)
+	retitled := slices.Clone(source)
+	copy(retitled[4:], "another title")
+
+	fsys := fstest.MapFS{
+		"reflection.it":   {Data: source},
+		"repack/copy.it":  {Data: retitled},
+		"broken.it":       {Data: source[:300]},
+		"unrelated/b.it":  {Data: source},
+		"unrelated/c.mod": {Data: []byte("not a module")},
+	}
+	var results []ScanResult
+	for result := range Scan(context.Background(), fsys, ".", Options{}) {
+		results = append(results, result)
+	}
+
+	assert.Equal(t, []DuplicateGroup{{
+		Paths:      []string{"reflection.it", "repack/copy.it", "unrelated/b.it"},
+		Similarity: 1,
+	}}, FindDuplicates(results, DefaultDuplicateThreshold))
+
+	// Linked groups, built from fingerprints directly.
+	results = []ScanResult{
+		{Path: "a", Metadata: Metadata{Fingerprint: analysis.Fingerprint{Song: 1, Samples: []uint64{1, 2}}}},
+		{Path: "b", Metadata: Metadata{Fingerprint: analysis.Fingerprint{Song: 1, Samples: []uint64{1, 2, 3}}}},
+		{Path: "c", Metadata: Metadata{Fingerprint: analysis.Fingerprint{Song: 1, Samples: []uint64{1, 2, 3, 4}}}},
+		{Path: "d", Metadata: Metadata{Fingerprint: analysis.Fingerprint{Song: 2, Samples: []uint64{1, 2}}}},
+		{Path: "e", Metadata: Metadata{Fingerprint: analysis.Fingerprint{Song: 5, Samples: []uint64{9}}}},
+		{Path: "f", Metadata: Metadata{Fingerprint: analysis.Fingerprint{Song: 5, Samples: []uint64{9}}}},
+		{Path: "g", Err: os.ErrNotExist},
+	}
+	groups := FindDuplicates(results, 0.8)
+	assert.Equal(t, []DuplicateGroup{
+		{Paths: []string{"a", "b", "c"}, Similarity: 0.5 + 0.5*float64(2)/3}, // a-b; a and c are only linked through b.,
+		{Paths: []string{"e", "f"}, Similarity: 1},
+	}, groups)
+}
@@ -0,0 +1,160 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package service
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+)
+
+// JSON-RPC 2.0 error codes.
+const (
+	codeParseError     = -32700
+	codeInvalidRequest = -32600
+	codeMethodNotFound = -32601
+	codeInvalidParams  = -32602
+	codeServerError    = -32000 // Errors from the operation itself
+)
+
+// Largest request accepted over HTTP or a stream, enough for a module in base64.
+const maxRequestSize = 512 << 20
+
+type paramsError struct{ err error }
+
+func (e *paramsError) Error() string { return "invalid params: " + e.err.Error() }
+
+type methodError struct{ method string }
+
+func (e *methodError) Error() string { return "method not found: " + e.method }
+
+type rpcRequest struct {
+	JsonRpc string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+	ID      json.RawMessage `json:"id"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type rpcResponse struct {
+	JsonRpc string          `json:"jsonrpc"`
+	Result  any             `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+// Run one JSON-RPC request. Returns nil for notifications, which get no response.
+func (s *Service) handle(request rpcRequest) *rpcResponse {
+	response := &rpcResponse{JsonRpc: "2.0", ID: request.ID}
+	if request.ID == nil {
+		response.ID = json.RawMessage("null")
+	}
+
+	if request.JsonRpc != "2.0" || request.Method == "" {
+		response.Error = &rpcError{codeInvalidRequest, "invalid request"}
+		return response
+	}
+
+	result, err := s.Call(request.Method, request.Params)
+	var pe *paramsError
+	var me *methodError
+	switch {
+	case errors.As(err, &pe):
+		response.Error = &rpcError{codeInvalidParams, err.Error()}
+	case errors.As(err, &me):
+		response.Error = &rpcError{codeMethodNotFound, err.Error()}
+	case err != nil:
+		response.Error = &rpcError{codeServerError, err.Error()}
+	case result == nil:
+		response.Result = struct{}{}
+	default:
+		response.Result = result
+	}
+
+	if request.ID == nil {
+		return nil
+	}
+	return response
+}
+
+// Run a JSON-RPC message, which is a single request or a batch. Returns nil if there is
+// nothing to send back.
+func (s *Service) handleMessage(message []byte) any {
+	var batch []rpcRequest
+	if err := json.Unmarshal(message, &batch); err == nil {
+		if len(batch) == 0 {
+			return &rpcResponse{JsonRpc: "2.0", ID: json.RawMessage("null"),
+				Error: &rpcError{codeInvalidRequest, "empty batch"}}
+		}
+		var responses []*rpcResponse
+		for _, request := range batch {
+			if response := s.handle(request); response != nil {
+				responses = append(responses, response)
+			}
+		}
+		if len(responses) == 0 {
+			return nil
+		}
+		return responses
+	}
+
+	var request rpcRequest
+	if err := json.Unmarshal(message, &request); err != nil {
+		return &rpcResponse{JsonRpc: "2.0", ID: json.RawMessage("null"),
+			Error: &rpcError{codeParseError, err.Error()}}
+	}
+	if response := s.handle(request); response != nil {
+		return response
+	}
+	return nil
+}
+
+// Serve JSON-RPC requests POSTed over HTTP.
+func (s *Service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	message, err := io.ReadAll(io.LimitReader(r.Body, maxRequestSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response := s.handleMessage(message)
+	if response == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Serve newline-delimited JSON-RPC messages from r, writing responses to w, until r is
+// exhausted. This suits a child process talking over stdin and stdout. Requests are run
+// one at a time.
+func (s *Service) ServeStream(r io.Reader, w io.Writer) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxRequestSize)
+	encoder := json.NewEncoder(w)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		if response := s.handleMessage(scanner.Bytes()); response != nil {
+			if err := encoder.Encode(response); err != nil {
+				return err
+			}
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,412 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+/*
+This package exposes modlib over JSON-RPC 2.0, so programs in other languages can load,
+inspect, convert and render modules by running modlib as a backend, over HTTP or over a
+pipe such as a child process's stdin and stdout.
+*/
+package service
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.mukunda.com/modlib"
+	"go.mukunda.com/modlib/charset"
+	"go.mukunda.com/modlib/common"
+	"go.mukunda.com/modlib/render"
+	"go.mukunda.com/modlib/sf2"
+)
+
+// Returned when a request names a module that isn't loaded.
+var ErrUnknownModule = errors.New("unknown module id")
+
+// Returned for a conversion or output format that isn't supported.
+var ErrUnsupportedFormat = errors.New("unsupported format")
+
+// Limits for a service. Zero values use the defaults.
+type Options struct {
+	MaxModules     int           // Modules kept loaded at once. Default 16.
+	MaxRenderTime  time.Duration // Longest audio a render request can produce. Default 10 minutes.
+	MaxRenderBytes int           // Largest output a render request can produce. Default 256 MiB.
+}
+
+// Sample rates accepted by render.
+const (
+	minSampleRate = 8000
+	maxSampleRate = 192000
+)
+
+// Holds loaded modules and runs requests on them. It's safe for concurrent use.
+type Service struct {
+	options Options
+
+	lock    sync.Mutex
+	modules map[string]*common.Module
+	order   []string // IDs from oldest to newest, for eviction.
+	nextID  int
+}
+
+// Create a service.
+func New(options Options) *Service {
+	if options.MaxModules <= 0 {
+		options.MaxModules = 16
+	}
+	if options.MaxRenderTime <= 0 {
+		options.MaxRenderTime = 10 * time.Minute
+	}
+	if options.MaxRenderBytes <= 0 {
+		options.MaxRenderBytes = 256 << 20
+	}
+	return &Service{options: options, modules: make(map[string]*common.Module)}
+}
+
+// Parameters for "load".
+type LoadParams struct {
+	Data    []byte `json:"data"`    // Module file, base64 in JSON. Zip and gzip archives are accepted.
+	RawText bool   `json:"rawText"` // Keep text byte for byte instead of converting it to UTF-8.
+}
+
+// Result of "load".
+type LoadResult struct {
+	ID   string `json:"id"`
+	Info Info   `json:"info"`
+}
+
+// Parameters for "inspect" and "unload".
+type IDParams struct {
+	ID string `json:"id"`
+}
+
+// A sample in Info.
+type SampleInfo struct {
+	Name     string  `json:"name"`
+	Length   int     `json:"length"` // Frames
+	Bits     int     `json:"bits"`
+	Stereo   bool    `json:"stereo"`
+	Loop     bool    `json:"loop"`
+	C5       int     `json:"c5"`
+	Duration float64 `json:"duration"` // Seconds at the C5 speed
+}
+
+// An instrument in Info.
+type InstrumentInfo struct {
+	Name string `json:"name"`
+}
+
+// Summary of a loaded module.
+type Info struct {
+	Title       string           `json:"title"`
+	Format      string           `json:"format"` // File extension of the source format, e.g. "it"
+	Channels    int              `json:"channels"`
+	Orders      int              `json:"orders"`
+	Patterns    int              `json:"patterns"`
+	Speed       int              `json:"speed"`
+	Tempo       int              `json:"tempo"`
+	Message     string           `json:"message"`
+	Duration    float64          `json:"duration"` // Seconds, for one pass through the song
+	Samples     []SampleInfo     `json:"samples"`
+	Instruments []InstrumentInfo `json:"instruments"`
+}
+
+// Parameters for "convert".
+type ConvertParams struct {
+	ID     string `json:"id"`
+	Format string `json:"format"` // "sf2"
+}
+
+// Parameters for "render".
+type RenderParams struct {
+	ID         string  `json:"id"`
+	SampleRate int     `json:"sampleRate"` // 8000 to 192000, default 44100
+	Format     string  `json:"format"`     // "wav" (default) or "pcm16", raw interleaved little-endian samples
+	Mono       bool    `json:"mono"`       // Render one channel instead of stereo
+	Loops      int     `json:"loops"`      // Extra times to play the song
+	Fadeout    float64 `json:"fadeout"`    // Seconds
+	MaxSeconds float64 `json:"maxSeconds"` // 0 = the service limit
+}
+
+// File data returned by convert and render, base64 in JSON.
+type DataResult struct {
+	Data []byte `json:"data"`
+}
+
+var sourceNames = map[common.ModuleSourceFormat]string{
+	common.ModSource: "mod",
+	common.S3mSource: "s3m",
+	common.XmSource:  "xm",
+	common.ItSource:  "it",
+	common.DsmSource: "dsm",
+	common.StxSource: "stx",
+	common.AhxSource: "ahx",
+}
+
+func (s *Service) module(id string) (*common.Module, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	m, ok := s.modules[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownModule, id)
+	}
+	return m, nil
+}
+
+// Load a module and keep it for later requests. The oldest module is unloaded when the
+// limit is reached.
+func (s *Service) Load(params LoadParams) (LoadResult, error) {
+	m, err := modlib.LoadModuleFromReader(bytes.NewReader(params.Data), int64(len(params.Data)))
+	if err != nil {
+		return LoadResult{}, err
+	}
+	if !params.RawText {
+		charset.ForSource(m.Source).DecodeModule(m)
+	}
+
+	s.lock.Lock()
+	s.nextID++
+	id := strconv.Itoa(s.nextID)
+	s.modules[id] = m
+	s.order = append(s.order, id)
+	for len(s.order) > s.options.MaxModules {
+		delete(s.modules, s.order[0])
+		s.order = s.order[1:]
+	}
+	s.lock.Unlock()
+
+	return LoadResult{ID: id, Info: inspect(m)}, nil
+}
+
+// Release a loaded module.
+func (s *Service) Unload(params IDParams) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.modules[params.ID]; !ok {
+		return fmt.Errorf("%w: %q", ErrUnknownModule, params.ID)
+	}
+	delete(s.modules, params.ID)
+	for i, id := range s.order {
+		if id == params.ID {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// Describe a loaded module.
+func (s *Service) Inspect(params IDParams) (Info, error) {
+	m, err := s.module(params.ID)
+	if err != nil {
+		return Info{}, err
+	}
+	return inspect(m), nil
+}
+
+func inspect(m *common.Module) Info {
+	info := Info{
+		Title:    m.Title,
+		Format:   sourceNames[m.Source],
+		Channels: int(m.Channels),
+		Orders:   len(m.Order),
+		Patterns: len(m.Patterns),
+		Speed:    int(m.InitialSpeed),
+		Tempo:    int(m.InitialTempo),
+		Message:  m.Message,
+	}
+	for tick := range render.NewPlayer(m, render.DefaultSampleRate).Ticks() {
+		info.Duration += 2.5 / float64(tick.Tempo)
+	}
+	for i := range m.Samples {
+		s := &m.Samples[i]
+		si := SampleInfo{
+			Name:   s.Name,
+			Length: s.Data.Len(),
+			Bits:   int(s.Data.Bits),
+			Stereo: len(s.Data.Data) > 1,
+			Loop:   s.Loop || s.Sustain,
+			C5:     s.C5,
+		}
+		if s.C5 > 0 {
+			si.Duration = float64(si.Length) / float64(s.C5)
+		}
+		info.Samples = append(info.Samples, si)
+	}
+	for i := range m.Instruments {
+		info.Instruments = append(info.Instruments, InstrumentInfo{Name: m.Instruments[i].Name})
+	}
+	return info
+}
+
+// Convert a loaded module to another format.
+func (s *Service) Convert(params ConvertParams) (DataResult, error) {
+	m, err := s.module(params.ID)
+	if err != nil {
+		return DataResult{}, err
+	}
+
+	var buf bytes.Buffer
+	switch params.Format {
+	case "sf2":
+		err = sf2.Write(&buf, m)
+	default:
+		err = fmt.Errorf("%w: %q", ErrUnsupportedFormat, params.Format)
+	}
+	return DataResult{Data: buf.Bytes()}, err
+}
+
+// An in-memory io.WriteSeeker for the WAV writer.
+type memoryFile struct {
+	data []byte
+	pos  int
+}
+
+func (f *memoryFile) Write(p []byte) (int, error) {
+	if end := f.pos + len(p); end > len(f.data) {
+		f.data = append(f.data, make([]byte, end-len(f.data))...)
+	}
+	n := copy(f.data[f.pos:], p)
+	f.pos += n
+	return n, nil
+}
+
+func (f *memoryFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+		f.pos = int(offset)
+	case io.SeekCurrent:
+		f.pos += int(offset)
+	case io.SeekEnd:
+		f.pos = len(f.data) + int(offset)
+	}
+	return int64(f.pos), nil
+}
+
+// Render a loaded module to audio.
+func (s *Service) Render(params RenderParams) (DataResult, error) {
+	m, err := s.module(params.ID)
+	if err != nil {
+		return DataResult{}, err
+	}
+	if params.Format == "" {
+		params.Format = "wav"
+	}
+	if params.Format != "wav" && params.Format != "pcm16" {
+		return DataResult{}, fmt.Errorf("%w: %q", ErrUnsupportedFormat, params.Format)
+	}
+	rate := params.SampleRate
+	if rate == 0 {
+		rate = render.DefaultSampleRate
+	}
+	if rate < minSampleRate || rate > maxSampleRate {
+		return DataResult{}, &paramsError{fmt.Errorf("sample rate %d is outside %d-%d", rate, minSampleRate, maxSampleRate)}
+	}
+	layout := render.LayoutStereo
+	if params.Mono {
+		layout = render.LayoutMono
+	}
+
+	// The output is also limited in size, which is shorter than the time limit at high
+	// rates.
+	limit := s.options.MaxRenderTime
+	if requested := time.Duration(params.MaxSeconds * float64(time.Second)); requested > 0 {
+		limit = min(limit, requested)
+	}
+	frameBytes := 2 * layout.Channels()
+	limit = min(limit, time.Duration(s.options.MaxRenderBytes/frameBytes)*time.Second/time.Duration(rate))
+
+	player := render.NewPlayer(m, rate)
+	player.SetPlaybackOptions(render.PlaybackOptions{
+		Loops:       max(params.Loops, 0),
+		Fadeout:     time.Duration(params.Fadeout * float64(time.Second)),
+		MaxDuration: limit,
+	})
+	player.SetChannelLayout(layout)
+
+	out := &memoryFile{}
+	var wav *render.WavWriter
+	if params.Format == "wav" {
+		if wav, err = render.NewWavWriter(out, rate, layout.Channels()); err != nil {
+			return DataResult{}, err
+		}
+	}
+
+	buffer := make([]int16, 4096*layout.Channels())
+	for {
+		frames := player.RenderInt16(buffer)
+		if frames == 0 {
+			break
+		}
+		samples := buffer[:frames*layout.Channels()]
+		if wav != nil {
+			err = wav.WriteInt16(samples)
+		} else {
+			err = binary.Write(out, binary.LittleEndian, samples)
+		}
+		if err != nil {
+			return DataResult{}, err
+		}
+	}
+	if wav != nil {
+		if err := wav.Close(); err != nil {
+			return DataResult{}, err
+		}
+	}
+	return DataResult{Data: out.data}, nil
+}
+
+// Run a method by name with JSON parameters, returning a value to encode as the result.
+// Methods are "load", "unload", "inspect", "convert" and "render".
+func (s *Service) Call(method string, params json.RawMessage) (any, error) {
+	decode := func(v any) error {
+		if len(params) == 0 {
+			return nil
+		}
+		if err := json.Unmarshal(params, v); err != nil {
+			return &paramsError{err}
+		}
+		return nil
+	}
+
+	switch method {
+	case "load":
+		var p LoadParams
+		if err := decode(&p); err != nil {
+			return nil, err
+		}
+		return s.Load(p)
+	case "unload":
+		var p IDParams
+		if err := decode(&p); err != nil {
+			return nil, err
+		}
+		return nil, s.Unload(p)
+	case "inspect":
+		var p IDParams
+		if err := decode(&p); err != nil {
+			return nil, err
+		}
+		return s.Inspect(p)
+	case "convert":
+		var p ConvertParams
+		if err := decode(&p); err != nil {
+			return nil, err
+		}
+		return s.Convert(p)
+	case "render":
+		var p RenderParams
+		if err := decode(&p); err != nil {
+			return nil, err
+		}
+		return s.Render(p)
+	}
+	return nil, &methodError{method}
+}
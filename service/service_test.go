@@ -0,0 +1,128 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestService(t *testing.T) {
+	source, err := os.ReadFile("../itmod/test/reflection.it")
+	assert.NoError(t, err)
+
+	s := New(Options{MaxModules: 1})
+	loaded, err := s.Load(LoadParams{Data: source})
+	assert.NoError(t, err)
+	assert.Equal(t, "reflection", loaded.Info.Title)
+	assert.Equal(t, "it", loaded.Info.Format)
+	assert.Positive(t, loaded.Info.Duration)
+	assert.NotEmpty(t, loaded.Info.Samples)
+
+	info, err := s.Inspect(IDParams{ID: loaded.ID})
+	assert.NoError(t, err)
+	assert.Equal(t, loaded.Info, info)
+
+	converted, err := s.Convert(ConvertParams{ID: loaded.ID, Format: "sf2"})
+	assert.NoError(t, err)
+	assert.Equal(t, "sfbk", string(converted.Data[8:12]))
+	_, err = s.Convert(ConvertParams{ID: loaded.ID, Format: "xm"})
+	assert.ErrorIs(t, err, ErrUnsupportedFormat)
+
+	wav, err := s.Render(RenderParams{ID: loaded.ID, SampleRate: 8000, MaxSeconds: 0.5})
+	assert.NoError(t, err)
+	assert.Equal(t, "RIFF", string(wav.Data[:4]))
+	assert.Len(t, wav.Data, 44+4000*2*2)
+
+	pcm, err := s.Render(RenderParams{ID: loaded.ID, SampleRate: 8000, MaxSeconds: 0.5, Format: "pcm16", Mono: true})
+	assert.NoError(t, err)
+	assert.Len(t, pcm.Data, 4000*2)
+
+	var pe *paramsError
+	_, err = s.Render(RenderParams{ID: loaded.ID, SampleRate: 1e9})
+	assert.ErrorAs(t, err, &pe)
+	_, err = s.Render(RenderParams{ID: loaded.ID, SampleRate: -1})
+	assert.ErrorAs(t, err, &pe)
+
+	// The output size is limited too.
+	small := New(Options{MaxRenderBytes: 8000})
+	smallLoaded, err := small.Load(LoadParams{Data: source})
+	assert.NoError(t, err)
+	pcm, err = small.Render(RenderParams{ID: smallLoaded.ID, SampleRate: 8000, Format: "pcm16"})
+	assert.NoError(t, err)
+	assert.Len(t, pcm.Data, 8000)
+
+	// Loading another module evicts the first.
+	second, err := s.Load(LoadParams{Data: source})
+	assert.NoError(t, err)
+	_, err = s.Inspect(IDParams{ID: loaded.ID})
+	assert.ErrorIs(t, err, ErrUnknownModule)
+	assert.NoError(t, s.Unload(IDParams{ID: second.ID}))
+	assert.ErrorIs(t, s.Unload(IDParams{ID: second.ID}), ErrUnknownModule)
+}
+
+func TestJsonRpc(t *testing.T) {
+	source, err := os.ReadFile("../itmod/test/reflection.it")
+	assert.NoError(t, err)
+	server := httptest.NewServer(New(Options{}))
+	defer server.Close()
+
+	call := func(body string) map[string]any {
+		response, err := http.Post(server.URL, "application/json", strings.NewReader(body))
+		assert.NoError(t, err)
+		defer response.Body.Close()
+		var result map[string]any
+		assert.NoError(t, json.NewDecoder(response.Body).Decode(&result))
+		return result
+	}
+
+	params, _ := json.Marshal(LoadParams{Data: source})
+	result := call(`{"jsonrpc":"2.0","id":1,"method":"load","params":` + string(params) + `}`)
+	assert.EqualValues(t, 1, result["id"])
+	loaded := result["result"].(map[string]any)
+	assert.Equal(t, "reflection", loaded["info"].(map[string]any)["title"])
+
+	result = call(`{"jsonrpc":"2.0","id":"a","method":"inspect","params":{"id":"` + loaded["id"].(string) + `"}}`)
+	assert.Equal(t, "reflection", result["result"].(map[string]any)["title"])
+
+	result = call(`{"jsonrpc":"2.0","id":2,"method":"inspect","params":{"id":"nope"}}`)
+	assert.EqualValues(t, codeServerError, result["error"].(map[string]any)["code"])
+	result = call(`{"jsonrpc":"2.0","id":3,"method":"explode"}`)
+	assert.EqualValues(t, codeMethodNotFound, result["error"].(map[string]any)["code"])
+	result = call(`{"jsonrpc":"2.0","id":4,"method":"inspect","params":[1]}`)
+	assert.EqualValues(t, codeInvalidParams, result["error"].(map[string]any)["code"])
+	result = call(`{`)
+	assert.EqualValues(t, codeParseError, result["error"].(map[string]any)["code"])
+
+	response, err := http.Get(server.URL)
+	assert.NoError(t, err)
+	response.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, response.StatusCode)
+}
+
+func TestServeStream(t *testing.T) {
+	input := strings.Join([]string{
+		`[{"jsonrpc":"2.0","id":1,"method":"inspect","params":{"id":"1"}},` +
+			`{"jsonrpc":"2.0","method":"unload","params":{"id":"1"}}]`,
+		``,
+		`{"jsonrpc":"2.0","method":"unload"}`,
+		`{"jsonrpc":"2.0","id":2,"method":"unload","params":{"id":"1"}}`,
+	}, "\n")
+
+	var output bytes.Buffer
+	assert.NoError(t, New(Options{}).ServeStream(strings.NewReader(input), &output))
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	assert.Len(t, lines, 2)
+	assert.Contains(t, lines[0], `"id":1`)
+	assert.Contains(t, lines[1], `"id":2`)
+	assert.Contains(t, lines[1], "unknown module id")
+}
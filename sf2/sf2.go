@@ -0,0 +1,323 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+/*
+This package exports module instruments as a SoundFont 2 (.sf2) file, so they can be played
+with any SoundFont synthesizer.
+*/
+package sf2
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+
+	"go.mukunda.com/modlib/common"
+)
+
+// Returned when the module has nothing to export.
+var ErrNoInstruments = errors.New("no instruments with sample data")
+
+// SoundFont generator numbers.
+const (
+	genPan                = 17
+	genInstrument         = 41
+	genKeyRange           = 43
+	genInitialAttenuation = 48
+	genCoarseTune         = 51
+	genSampleModes        = 54
+	genSampleID           = 53
+)
+
+// Sample modes.
+const (
+	modeNoLoop      = 0
+	modeLoop        = 1
+	modeLoopSustain = 3 // Loop while the key is held, then play to the end.
+)
+
+// Zero samples written after each sample, as the specification requires.
+const samplePadding = 46
+
+// The key that plays a sample at its C5 speed. Module note C-5 is MIDI middle C.
+const rootKey = 60
+
+type generator struct {
+	Oper   uint16
+	Amount uint16
+}
+
+type bag struct {
+	GenIndex uint16
+	ModIndex uint16
+}
+
+type presetHeader struct {
+	Name       [20]byte
+	Preset     uint16
+	Bank       uint16
+	BagIndex   uint16
+	Library    uint32
+	Genre      uint32
+	Morphology uint32
+}
+
+type instrumentHeader struct {
+	Name     [20]byte
+	BagIndex uint16
+}
+
+type sampleHeader struct {
+	Name       [20]byte
+	Start      uint32
+	End        uint32
+	LoopStart  uint32
+	LoopEnd    uint32
+	SampleRate uint32
+	RootKey    uint8
+	Correction int8
+	Link       uint16
+	Type       uint16 // 1 = mono
+}
+
+type modulator [10]byte
+
+// The notemap zones that play samples with data.
+func notemapZones(ins *common.Instrument, samples []common.Sample) []common.KeyZone {
+	var zones []common.KeyZone
+	for _, z := range ins.KeyZones() {
+		if z.Sample <= len(samples) && samples[z.Sample-1].HasData() {
+			zones = append(zones, z)
+		}
+	}
+	return zones
+}
+
+// Volume in centibels of attenuation.
+func attenuation(gain float64) uint16 {
+	if gain <= 0 {
+		return 1440
+	}
+	return uint16(min(max(math.Round(-200*math.Log10(gain)), 0), 1440))
+}
+
+func name20(name string) [20]byte {
+	var b [20]byte
+	copy(b[:19], name)
+	return b
+}
+
+// An SF2 file being built.
+type builder struct {
+	module *common.Module
+
+	pcm        []int16
+	shdr       []sampleHeader
+	sampleSlot map[int]int // Module sample index to shdr index
+
+	inst []instrumentHeader
+	ibag []bag
+	igen []generator
+
+	phdr []presetHeader
+	pbag []bag
+	pgen []generator
+}
+
+// Add a sample's PCM and header, once.
+func (b *builder) addSample(index int) int {
+	if slot, ok := b.sampleSlot[index]; ok {
+		return slot
+	}
+	s := &b.module.Samples[index]
+
+	// Mix down to mono 16-bit.
+	channels := s.Data.Float64()
+	mono := make([]float64, s.Data.Len())
+	for _, ch := range channels {
+		for i, v := range ch {
+			mono[i] += v / float64(len(channels))
+		}
+	}
+	data, _ := common.FromFloat64([][]float64{mono}, 16)
+
+	start := uint32(len(b.pcm))
+	b.pcm = append(b.pcm, data.Data[0].([]int16)...)
+	header := sampleHeader{
+		Name:       name20(s.Name),
+		Start:      start,
+		End:        uint32(len(b.pcm)),
+		SampleRate: uint32(max(s.C5, 1)),
+		RootKey:    rootKey,
+		Type:       1,
+	}
+	if s.Sustain {
+		header.LoopStart, header.LoopEnd = start+uint32(s.SustainLoopStart), start+uint32(s.SustainLoopEnd)
+	} else if s.Loop {
+		header.LoopStart, header.LoopEnd = start+uint32(s.LoopStart), start+uint32(s.LoopEnd)
+	}
+	b.pcm = append(b.pcm, make([]int16, samplePadding)...)
+
+	b.sampleSlot[index] = len(b.shdr)
+	b.shdr = append(b.shdr, header)
+	return len(b.shdr) - 1
+}
+
+// Add an instrument and a preset that plays it.
+func (b *builder) addInstrument(ins *common.Instrument, name string) {
+	zones := notemapZones(ins, b.module.Samples)
+	if len(zones) == 0 {
+		return
+	}
+
+	b.inst = append(b.inst, instrumentHeader{Name: name20(name), BagIndex: uint16(len(b.ibag))})
+	for _, z := range zones {
+		s := &b.module.Samples[z.Sample-1]
+		b.ibag = append(b.ibag, bag{GenIndex: uint16(len(b.igen))})
+
+		gain := float64(s.GlobalVolume) / 64 * float64(s.DefaultVolume) / 64 * float64(ins.GlobalVolume) / 128
+		mode := uint16(modeNoLoop)
+		if s.Sustain {
+			mode = modeLoopSustain
+		} else if s.Loop {
+			mode = modeLoop
+		}
+
+		b.igen = append(b.igen,
+			generator{genKeyRange, uint16(z.High)<<8 | uint16(z.Low)},
+			generator{genInitialAttenuation, attenuation(gain)},
+			generator{genCoarseTune, uint16(int16(z.Transpose))},
+			generator{genSampleModes, mode},
+		)
+		if ins.DefaultPanEnabled {
+			pan := (int(ins.DefaultPan) - 32) * 500 / 32
+			b.igen = append(b.igen, generator{genPan, uint16(int16(pan))})
+		}
+		b.igen = append(b.igen, generator{genSampleID, uint16(b.addSample(z.Sample - 1))})
+	}
+
+	b.phdr = append(b.phdr, presetHeader{
+		Name:     name20(name),
+		Preset:   uint16(len(b.phdr)),
+		BagIndex: uint16(len(b.pbag)),
+	})
+	b.pbag = append(b.pbag, bag{GenIndex: uint16(len(b.pgen))})
+	b.pgen = append(b.pgen, generator{genInstrument, uint16(len(b.inst) - 1)})
+}
+
+// Write the module's instruments as a SoundFont. Each instrument becomes a preset in bank
+// 0, numbered in order. Modules without instruments export each sample across the whole
+// keyboard instead. Notemaps become key zones, loops are kept (sustain loops loop while
+// the key is held), and volumes become attenuation. Stereo samples are mixed to mono,
+// and envelopes aren't converted.
+func Write(w io.Writer, m *common.Module) error {
+	return WriteWithProgress(w, m, nil)
+}
+
+// Write with progress reported for each instrument (or each sample if the module doesn't
+// use instruments) as its samples are converted.
+func WriteWithProgress(w io.Writer, m *common.Module, progress common.ProgressFunc) error {
+	m, err := m.WithLoadedStreams()
+	if err != nil {
+		return err
+	}
+	b := &builder{module: m, sampleSlot: make(map[int]int)}
+
+	if m.UseInstruments {
+		for i := range m.Instruments {
+			progress.Report(common.StageInstruments, i, len(m.Instruments))
+			b.addInstrument(&m.Instruments[i], m.Instruments[i].Name)
+		}
+		progress.Report(common.StageInstruments, len(m.Instruments), len(m.Instruments))
+	} else {
+		for i := range m.Samples {
+			progress.Report(common.StageSamples, i, len(m.Samples))
+			ins := common.Instrument{GlobalVolume: 128}
+			for key := range ins.Notemap {
+				ins.Notemap[key] = common.NotemapEntry{Note: int16(key), Sample: int16(i + 1)}
+			}
+			b.addInstrument(&ins, m.Samples[i].Name)
+		}
+		progress.Report(common.StageSamples, len(m.Samples), len(m.Samples))
+	}
+
+	if len(b.phdr) == 0 {
+		return ErrNoInstruments
+	}
+	if len(b.pcm) > math.MaxUint32/2 {
+		return errors.New("sample data too large")
+	}
+
+	// Terminal records.
+	b.phdr = append(b.phdr, presetHeader{Name: name20("EOP"), BagIndex: uint16(len(b.pbag))})
+	b.pbag = append(b.pbag, bag{GenIndex: uint16(len(b.pgen))})
+	b.pgen = append(b.pgen, generator{})
+	b.inst = append(b.inst, instrumentHeader{Name: name20("EOI"), BagIndex: uint16(len(b.ibag))})
+	b.ibag = append(b.ibag, bag{GenIndex: uint16(len(b.igen))})
+	b.igen = append(b.igen, generator{})
+	b.shdr = append(b.shdr, sampleHeader{Name: name20("EOS")})
+
+	title := m.Title
+	if title == "" {
+		title = "modlib export"
+	}
+
+	info := list("INFO",
+		chunk("ifil", []uint16{2, 1}),
+		chunk("isng", zstr("EMU8000")),
+		chunk("INAM", zstr(title)),
+	)
+	sdta := list("sdta", chunk("smpl", b.pcm))
+	pdta := list("pdta",
+		chunk("phdr", b.phdr),
+		chunk("pbag", b.pbag),
+		chunk("pmod", []modulator{{}}),
+		chunk("pgen", b.pgen),
+		chunk("inst", b.inst),
+		chunk("ibag", b.ibag),
+		chunk("imod", []modulator{{}}),
+		chunk("igen", b.igen),
+		chunk("shdr", b.shdr),
+	)
+
+	body := append([]byte("sfbk"), info...)
+	body = append(body, sdta...)
+	body = append(body, pdta...)
+	_, err = w.Write(chunk("RIFF", body))
+	return err
+}
+
+// A zero-terminated string padded to an even length.
+func zstr(s string) []byte {
+	b := append([]byte(s), 0)
+	if len(b)%2 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// Encode a RIFF chunk.
+func chunk(id string, data any) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(id)
+	var body bytes.Buffer
+	if raw, ok := data.([]byte); ok {
+		body.Write(raw)
+	} else {
+		binary.Write(&body, binary.LittleEndian, data)
+	}
+	binary.Write(&buf, binary.LittleEndian, uint32(body.Len()))
+	buf.Write(body.Bytes())
+	if body.Len()%2 != 0 {
+		buf.WriteByte(0)
+	}
+	return buf.Bytes()
+}
+
+// Encode a LIST chunk.
+func list(kind string, chunks ...[]byte) []byte {
+	return chunk("LIST", append([]byte(kind), bytes.Join(chunks, nil)...))
+}
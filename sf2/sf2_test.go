@@ -0,0 +1,160 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package sf2
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mukunda.com/modlib/common"
+	"go.mukunda.com/modlib/itmod"
+)
+
+// Split a RIFF body into its chunks.
+func readChunks(t *testing.T, data []byte) map[string][]byte {
+	chunks := make(map[string][]byte)
+	for len(data) >= 8 {
+		id := string(data[:4])
+		size := int(binary.LittleEndian.Uint32(data[4:]))
+		if !assert.LessOrEqual(t, 8+size, len(data)) {
+			break
+		}
+		body := data[8 : 8+size]
+		if id == "LIST" {
+			for k, v := range readChunks(t, body[4:]) {
+				chunks[k] = v
+			}
+		} else {
+			chunks[id] = body
+		}
+		data = data[8+size+size%2:]
+	}
+	return chunks
+}
+
+func readRecords[T any](t *testing.T, data []byte, size int) []T {
+	records := make([]T, len(data)/size)
+	assert.Zero(t, len(data)%size)
+	assert.NoError(t, binary.Read(bytes.NewReader(data), binary.LittleEndian, records))
+	return records
+}
+
+func TestWriteSamples(t *testing.T) {
+	data, _ := common.FromFloat64([][]float64{make([]float64, 100), make([]float64, 100)}, 8)
+	m := &common.Module{
+		Title: "test",
+		Samples: []common.Sample{
+			{Name: "empty"},
+			{Name: "looped", GlobalVolume: 64, DefaultVolume: 32, C5: 22050, Loop: true, LoopStart: 10, LoopEnd: 90, Data: data},
+		},
+	}
+
+	var buf bytes.Buffer
+	assert.NoError(t, Write(&buf, m))
+	assert.Equal(t, "RIFF", buf.String()[:4])
+	assert.Equal(t, "sfbk", buf.String()[8:12])
+
+	chunks := readChunks(t, buf.Bytes()[12:])
+	assert.Equal(t, []byte{2, 0, 1, 0}, chunks["ifil"])
+	assert.Equal(t, "test\x00\x00", string(chunks["INAM"]))
+	assert.Len(t, chunks["smpl"], (100+samplePadding)*2)
+
+	presets := readRecords[presetHeader](t, chunks["phdr"], 38)
+	assert.Len(t, presets, 2)
+	assert.Equal(t, "EOP", string(bytes.TrimRight(presets[1].Name[:], "\x00")))
+
+	samples := readRecords[sampleHeader](t, chunks["shdr"], 46)
+	assert.Len(t, samples, 2)
+	assert.Equal(t, sampleHeader{
+		Name: name20("looped"), End: 100, LoopStart: 10, LoopEnd: 90, SampleRate: 22050,
+		RootKey: 60, Type: 1,
+	}, samples[0])
+
+	gens := readRecords[generator](t, chunks["igen"], 4)
+	assert.Equal(t, []generator{
+		{genKeyRange, 119 << 8},
+		{genInitialAttenuation, 60}, // Half volume is 6 dB.
+		{genCoarseTune, 0},
+		{genSampleModes, modeLoop},
+		{genSampleID, 0},
+		{},
+	}, gens)
+
+	// Streamed samples are read for the export.
+	streamed := *m
+	streamed.Samples = []common.Sample{m.Samples[0], m.Samples[1]}
+	streamed.Samples[1].Data = common.SampleData{Channels: 2, Bits: 8}
+	streamed.Samples[1].Stream = &common.SampleStream{Source: bytes.NewReader(make([]byte, 200)), Frames: 100, Channels: 2, Bits: 8}
+	var streamedBuf bytes.Buffer
+	assert.NoError(t, Write(&streamedBuf, &streamed))
+	assert.Equal(t, buf.Bytes(), streamedBuf.Bytes())
+
+	streamed.Samples[1].Stream.Frames = 1000
+	assert.Error(t, Write(&streamedBuf, &streamed))
+
+	assert.ErrorIs(t, Write(&buf, &common.Module{}), ErrNoInstruments)
+}
+
+func TestWriteInstruments(t *testing.T) {
+	data, _ := common.FromFloat64([][]float64{make([]float64, 10)}, 16)
+	m := &common.Module{
+		UseInstruments: true,
+		Samples: []common.Sample{
+			{GlobalVolume: 64, DefaultVolume: 64, C5: 8363, Data: data},
+			{GlobalVolume: 64, DefaultVolume: 64, C5: 8363, Sustain: true, SustainLoopEnd: 5, Data: data},
+		},
+		Instruments: []common.Instrument{{Name: "split", GlobalVolume: 128}},
+	}
+	notemap := &m.Instruments[0].Notemap
+	for key := range notemap {
+		notemap[key] = common.NotemapEntry{Note: int16(key), Sample: 1}
+		if key >= 60 {
+			notemap[key] = common.NotemapEntry{Note: int16(key) + 12, Sample: 2}
+		}
+	}
+	notemap[100].Sample = 0
+
+	var buf bytes.Buffer
+	assert.NoError(t, Write(&buf, m))
+	chunks := readChunks(t, buf.Bytes()[12:])
+
+	gens := readRecords[generator](t, chunks["igen"], 4)
+	assert.Equal(t, []generator{
+		{genKeyRange, 59 << 8},
+		{genInitialAttenuation, 0},
+		{genCoarseTune, 0},
+		{genSampleModes, modeNoLoop},
+		{genSampleID, 0},
+		{genKeyRange, 99<<8 | 60},
+		{genInitialAttenuation, 0},
+		{genCoarseTune, 12},
+		{genSampleModes, modeLoopSustain},
+		{genSampleID, 1},
+		{genKeyRange, 119<<8 | 101},
+		{genInitialAttenuation, 0},
+		{genCoarseTune, 12},
+		{genSampleModes, modeLoopSustain},
+		{genSampleID, 1}, // Shared with the zone above.
+		{},
+	}, gens)
+	assert.Len(t, readRecords[sampleHeader](t, chunks["shdr"], 46), 3)
+	assert.Len(t, readRecords[bag](t, chunks["ibag"], 4), 4)
+}
+
+func TestWriteModule(t *testing.T) {
+	it, err := itmod.LoadITFile("../itmod/test/reflection.it")
+	assert.NoError(t, err)
+	m := it.ToCommon()
+
+	var buf bytes.Buffer
+	assert.NoError(t, Write(&buf, m))
+	chunks := readChunks(t, buf.Bytes()[12:])
+	for _, id := range []string{"phdr", "pbag", "pmod", "pgen", "inst", "ibag", "imod", "igen", "shdr"} {
+		assert.Contains(t, chunks, id)
+	}
+	assert.Equal(t, int(binary.LittleEndian.Uint32(buf.Bytes()[4:]))+8, buf.Len())
+}
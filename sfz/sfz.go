@@ -0,0 +1,409 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+/*
+This package converts instruments to and from SFZ, a text format that maps WAV files to key
+ranges and is read by most modern samplers.
+*/
+package sfz
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"go.mukunda.com/modlib/common"
+)
+
+// Returned for SFZ text that can't be parsed.
+var ErrInvalidSfz = errors.New("invalid sfz file")
+
+// The key that plays a sample at its C5 speed. Module note C-5 is MIDI middle C.
+const rootKey = 60
+
+// Volume in decibels.
+func decibels(gain float64) float64 {
+	if gain <= 0 {
+		return -144
+	}
+	return math.Round(200*math.Log10(gain)) / 10
+}
+
+// Write the SFZ text for an instrument. samplePath gives the file name to reference for a
+// zero-based sample index. The instrument's global volume goes in the <global> header,
+// and each notemap zone becomes a region. Envelopes and other instrument settings aren't
+// exported.
+func Write(w io.Writer, ins *common.Instrument, samples []common.Sample, samplePath func(int) string) error {
+	bw := bufio.NewWriter(w)
+	if ins.Name != "" {
+		fmt.Fprintf(bw, "// %s\n", strings.ReplaceAll(ins.Name, "\n", " "))
+	}
+	fmt.Fprintf(bw, "<global> volume=%g", decibels(float64(ins.GlobalVolume)/128))
+	if ins.DefaultPanEnabled {
+		fmt.Fprintf(bw, " pan=%d", (int(ins.DefaultPan)-32)*100/32)
+	}
+	fmt.Fprintln(bw)
+
+	for _, z := range ins.KeyZones() {
+		if z.Sample > len(samples) {
+			continue
+		}
+		s := &samples[z.Sample-1]
+		fmt.Fprintf(bw, "<region> lokey=%d hikey=%d pitch_keycenter=%d", z.Low, z.High, rootKey)
+		if z.Transpose != 0 {
+			fmt.Fprintf(bw, " transpose=%d", z.Transpose)
+		}
+		fmt.Fprintf(bw, " volume=%g", decibels(float64(s.GlobalVolume)/64*float64(s.DefaultVolume)/64))
+		if start, end, _, ok := s.ExportLoop(); ok {
+			mode := "loop_continuous"
+			if s.Sustain && s.SustainLoopEnd > s.SustainLoopStart {
+				mode = "loop_sustain"
+			}
+			fmt.Fprintf(bw, " loop_mode=%s loop_start=%d loop_end=%d", mode, start, end-1)
+		}
+		// The sample path must be last since it can contain spaces.
+		fmt.Fprintf(bw, " sample=%s\n", samplePath(z.Sample-1))
+	}
+	return bw.Flush()
+}
+
+// Write an instrument to a directory as <name>.sfz, with its samples as WAV files in a
+// "samples" subdirectory. Returns the path of the SFZ file.
+func Export(dir string, m *common.Module, instrument int) (string, error) {
+	ins := &m.Instruments[instrument]
+	name := fmt.Sprintf("%02d", instrument+1)
+	if ins.Name != "" {
+		name = sanitize(name + " " + ins.Name)
+	}
+
+	if err := os.MkdirAll(filepath.Join(dir, "samples"), 0o755); err != nil {
+		return "", err
+	}
+	samplePath := func(index int) string {
+		return fmt.Sprintf("samples/%02d.wav", index+1)
+	}
+	for _, z := range ins.KeyZones() {
+		if z.Sample > len(m.Samples) {
+			continue
+		}
+		if err := writeWavFile(filepath.Join(dir, samplePath(z.Sample-1)), &m.Samples[z.Sample-1]); err != nil {
+			return "", err
+		}
+	}
+
+	sfzPath := filepath.Join(dir, name+".sfz")
+	f, err := os.Create(sfzPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if err := Write(f, ins, m.Samples, samplePath); err != nil {
+		return "", err
+	}
+	return sfzPath, f.Close()
+}
+
+func writeWavFile(name string, s *common.Sample) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := common.WriteWav(f, s); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// Replace characters that aren't safe in file names.
+func sanitize(name string) string {
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\:*?"<>|`, r) || r < 32 {
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(name))
+}
+
+// Opcodes in effect for a region, inherited from <global>, <master> and <group>.
+type opcodes map[string]string
+
+// Read the header and opcode tokens from SFZ text, stripping comments.
+func tokenize(text string) []string {
+	var tokens []string
+	for len(text) > 0 {
+		switch {
+		case strings.HasPrefix(text, "//"):
+			end := strings.IndexByte(text, '\n')
+			if end < 0 {
+				end = len(text)
+			}
+			text = text[end:]
+		case strings.HasPrefix(text, "/*"):
+			end := strings.Index(text, "*/")
+			if end < 0 {
+				end = len(text) - 2
+			}
+			text = text[end+2:]
+		case text[0] == ' ' || text[0] == '\t' || text[0] == '\r' || text[0] == '\n':
+			text = text[1:]
+		default:
+			end := strings.IndexAny(text, " \t\r\n")
+			if end < 0 {
+				end = len(text)
+			}
+			tokens = append(tokens, text[:end])
+			text = text[end:]
+		}
+	}
+	return tokens
+}
+
+// Parse SFZ text into regions. Sample paths can contain spaces, so a value continues until
+// the next token that looks like an opcode or header.
+func parse(text string) ([]opcodes, error) {
+	var regions []opcodes
+	scopes := map[string]opcodes{"global": {}, "master": {}, "group": {}}
+	var current opcodes
+
+	tokens := tokenize(text)
+	for i := 0; i < len(tokens); i++ {
+		token := tokens[i]
+		if strings.HasPrefix(token, "<") {
+			header := strings.Trim(token, "<>")
+			switch header {
+			case "region":
+				current = opcodes{}
+				for _, scope := range []string{"global", "master", "group"} {
+					for k, v := range scopes[scope] {
+						current[k] = v
+					}
+				}
+				regions = append(regions, current)
+			case "global", "master", "group":
+				// A new outer scope resets the ones inside it.
+				switch header {
+				case "global":
+					scopes["master"] = opcodes{}
+					fallthrough
+				case "master":
+					scopes["group"] = opcodes{}
+				}
+				current = opcodes{}
+				scopes[header] = current
+			default:
+				current = opcodes{} // <control>, <curve>, etc. are read but not used.
+				if header == "control" {
+					scopes["control"] = current
+				}
+			}
+			continue
+		}
+
+		key, value, ok := strings.Cut(token, "=")
+		if !ok {
+			return nil, fmt.Errorf("%w: unexpected %q", ErrInvalidSfz, token)
+		}
+		for i+1 < len(tokens) && !strings.HasPrefix(tokens[i+1], "<") && !strings.Contains(tokens[i+1], "=") {
+			i++
+			value += " " + tokens[i]
+		}
+		if current == nil {
+			return nil, fmt.Errorf("%w: opcode %q outside of a header", ErrInvalidSfz, key)
+		}
+		current[key] = value
+	}
+
+	if control, ok := scopes["control"]; ok {
+		for _, r := range regions {
+			if _, ok := r["default_path"]; !ok && control["default_path"] != "" {
+				r["default_path"] = control["default_path"]
+			}
+		}
+	}
+	return regions, nil
+}
+
+var noteNames = map[byte]int{'c': 0, 'd': 2, 'e': 4, 'f': 5, 'g': 7, 'a': 9, 'b': 11}
+
+// Parse a key number or a note name like "c#4", where c4 is 60.
+func parseKey(value string) (int, error) {
+	if n, err := strconv.Atoi(value); err == nil {
+		return n, nil
+	}
+	value = strings.ToLower(value)
+	if len(value) < 2 {
+		return 0, fmt.Errorf("%w: bad key %q", ErrInvalidSfz, value)
+	}
+	semitone, ok := noteNames[value[0]]
+	if !ok {
+		return 0, fmt.Errorf("%w: bad key %q", ErrInvalidSfz, value)
+	}
+	value = value[1:]
+	switch value[0] {
+	case '#':
+		semitone++
+		value = value[1:]
+	case 'b':
+		semitone--
+		value = value[1:]
+	}
+	octave, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("%w: bad key %q", ErrInvalidSfz, value)
+	}
+	return (octave+1)*12 + semitone, nil
+}
+
+func (r opcodes) key(name string, fallback int) (int, error) {
+	if value, ok := r[name]; ok {
+		return parseKey(value)
+	}
+	return fallback, nil
+}
+
+func (r opcodes) float(name string, fallback float64) float64 {
+	if value, err := strconv.ParseFloat(r[name], 64); err == nil {
+		return value
+	}
+	return fallback
+}
+
+// Read an SFZ file and the WAV files it references from fsys. Sample paths are relative
+// to the SFZ file. Each distinct WAV file becomes a sample, and the regions build the
+// notemap. Where regions overlap, such as velocity layers, the first one is mapped.
+// Envelopes and other performance settings aren't imported.
+func Read(fsys fs.FS, name string) (common.Instrument, []common.Sample, error) {
+	ins := common.Instrument{GlobalVolume: 128}
+	text, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return ins, nil, err
+	}
+	regions, err := parse(string(text))
+	if err != nil {
+		return ins, nil, err
+	}
+
+	base := path.Base(name)
+	ins.Name = strings.TrimSuffix(base, path.Ext(base))
+
+	var samples []common.Sample
+	sampleIndex := make(map[string]int)
+	mapped := [120]bool{}
+
+	for _, r := range regions {
+		file := r["sample"]
+		if file == "" || strings.HasPrefix(file, "*") {
+			continue // Generated waveforms like *sine aren't supported.
+		}
+		file = path.Join(path.Dir(name), strings.ReplaceAll(r["default_path"]+file, `\`, "/"))
+
+		index, ok := sampleIndex[file]
+		if !ok {
+			f, err := fsys.Open(file)
+			if err != nil {
+				return ins, nil, err
+			}
+			sample, err := common.ReadWav(f)
+			f.Close()
+			if err != nil {
+				return ins, nil, fmt.Errorf("%s: %w", file, err)
+			}
+			sample.Name = path.Base(file)
+			applyRegion(&sample, r)
+			samples = append(samples, sample)
+			index = len(samples) - 1
+			sampleIndex[file] = index
+		}
+
+		center, err := r.key("pitch_keycenter", rootKey)
+		if err != nil {
+			return ins, nil, err
+		}
+		low, err := r.key("lokey", 0)
+		if err != nil {
+			return ins, nil, err
+		}
+		high, err := r.key("hikey", 127)
+		if err != nil {
+			return ins, nil, err
+		}
+		if value, ok := r["key"]; ok {
+			if low, err = parseKey(value); err != nil {
+				return ins, nil, err
+			}
+			high = low
+			if _, ok := r["pitch_keycenter"]; !ok {
+				center = low
+			}
+		}
+		transpose := int(r.float("transpose", 0)) + rootKey - center
+
+		for key := max(low, 0); key <= min(high, len(ins.Notemap)-1); key++ {
+			if mapped[key] {
+				continue
+			}
+			mapped[key] = true
+			note := max(0, min(key+transpose, 119))
+			ins.Notemap[key] = common.NotemapEntry{Note: int16(note), Sample: int16(index + 1)}
+		}
+
+		if pan, ok := r["pan"]; ok && !ins.DefaultPanEnabled {
+			if value, err := strconv.ParseFloat(pan, 64); err == nil {
+				ins.DefaultPanEnabled = true
+				ins.DefaultPan = int16(max(0, min(math.Round(32+value*32/100), 64)))
+			}
+		}
+	}
+
+	if len(samples) == 0 {
+		return ins, nil, fmt.Errorf("%w: no regions with samples", ErrInvalidSfz)
+	}
+
+	// Unmapped keys keep their note so the notemap stays playable if edited.
+	for key := range ins.Notemap {
+		if !mapped[key] {
+			ins.Notemap[key].Note = int16(key)
+		}
+	}
+	return ins, samples, nil
+}
+
+// Apply region volume and loop settings to a newly loaded sample.
+func applyRegion(s *common.Sample, r opcodes) {
+	gain := math.Pow(10, r.float("volume", 0)/20)
+	s.DefaultVolume = int16(max(0, min(math.Round(gain*64), 64)))
+
+	length := s.Data.Len()
+	start := int(r.float("loop_start", float64(s.LoopStart)))
+	end := int(r.float("loop_end", float64(s.LoopEnd-1))) + 1
+	valid := start >= 0 && start < end && end <= length
+
+	switch r["loop_mode"] {
+	case "no_loop", "one_shot":
+		s.Loop = false
+	case "loop_continuous":
+		s.Loop = valid
+	case "loop_sustain":
+		s.Loop = false
+		s.Sustain = valid
+		s.PingPongSustain = s.PingPong
+		s.PingPong = false
+	}
+	if s.Loop {
+		s.LoopStart, s.LoopEnd = start, end
+	}
+	if s.Sustain {
+		s.SustainLoopStart, s.SustainLoopEnd = start, end
+		s.LoopStart, s.LoopEnd = 0, 0
+	}
+}
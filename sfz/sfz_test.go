@@ -0,0 +1,113 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package sfz
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"go.mukunda.com/modlib/common"
+)
+
+func testSample(t *testing.T, bits int8, channels int) common.Sample {
+	data := make([][]float64, channels)
+	for ch := range data {
+		data[ch] = make([]float64, 64)
+		for i := range data[ch] {
+			data[ch][i] = float64(i%16-8) / 8 / float64(ch+1)
+		}
+	}
+	sd, err := common.FromFloat64(data, bits)
+	assert.NoError(t, err)
+	return common.Sample{
+		GlobalVolume: 64, DefaultVolume: 64, C5: 22050,
+		S16: bits == 16, Stereo: channels == 2, Data: sd,
+	}
+}
+
+func TestExportRead(t *testing.T) {
+	m := &common.Module{
+		UseInstruments: true,
+		Samples:        []common.Sample{testSample(t, 16, 1), testSample(t, 8, 1)},
+		Instruments:    []common.Instrument{{Name: "Bass/Lead", GlobalVolume: 128, DefaultPan: 48, DefaultPanEnabled: true}},
+	}
+	m.Samples[0].DefaultVolume = 32
+	m.Samples[1].Sustain, m.Samples[1].SustainLoopStart, m.Samples[1].SustainLoopEnd = true, 8, 40
+	for key := range m.Instruments[0].Notemap {
+		entry := common.NotemapEntry{Note: int16(key), Sample: 1}
+		if key >= 48 && key < 100 {
+			entry = common.NotemapEntry{Note: int16(key + 12), Sample: 2}
+		}
+		m.Instruments[0].Notemap[key] = entry
+	}
+
+	dir := t.TempDir()
+	sfzPath, err := Export(dir, m, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "01 Bass_Lead.sfz"), sfzPath)
+	text, _ := os.ReadFile(sfzPath)
+	assert.Equal(t, "// Bass/Lead\n"+
+		"<global> volume=0 pan=50\n"+
+		"<region> lokey=0 hikey=47 pitch_keycenter=60 volume=-6 sample=samples/01.wav\n"+
+		"<region> lokey=48 hikey=99 pitch_keycenter=60 transpose=12 volume=0 loop_mode=loop_sustain loop_start=8 loop_end=39 sample=samples/02.wav\n"+
+		"<region> lokey=100 hikey=119 pitch_keycenter=60 volume=-6 sample=samples/01.wav\n",
+		string(text))
+
+	ins, samples, err := Read(os.DirFS(dir), filepath.Base(sfzPath))
+	assert.NoError(t, err)
+	assert.Equal(t, m.Instruments[0].Notemap, ins.Notemap)
+	assert.Equal(t, int16(48), ins.DefaultPan)
+	assert.Len(t, samples, 2)
+	assert.Equal(t, int16(32), samples[0].DefaultVolume)
+	assert.True(t, samples[1].Sustain)
+	assert.False(t, samples[1].Loop)
+	assert.Equal(t, [2]int{8, 40}, [2]int{samples[1].SustainLoopStart, samples[1].SustainLoopEnd})
+	assert.Equal(t, m.Samples[1].Data, samples[1].Data)
+}
+
+func TestRead(t *testing.T) {
+	var wav bytes.Buffer
+	s := testSample(t, 16, 1)
+	assert.NoError(t, common.WriteWav(&wav, &s))
+
+	fsys := fstest.MapFS{
+		"kits/piano.sfz": {Data: []byte(`
+			/* A test
+			   instrument */
+			<control> default_path=wavs/
+			<group> pitch_keycenter=c4 // Middle C
+			<region> sample=low note.wav lokey=c3 hikey=b3
+			<region> sample=high.wav key=C#4 loop_mode=loop_continuous loop_start=4 loop_end=11
+			<group>
+			<region> sample=high.wav lokey=62 hikey=200 transpose=-1 pan=-100
+			<region> sample=*sine lokey=0 hikey=127
+		`)},
+		"kits/wavs/low note.wav": {Data: wav.Bytes()},
+		"kits/wavs/high.wav":     {Data: wav.Bytes()},
+	}
+
+	ins, samples, err := Read(fsys, "kits/piano.sfz")
+	assert.NoError(t, err)
+	assert.Equal(t, "piano", ins.Name)
+	assert.Len(t, samples, 2)
+	assert.Equal(t, "low note.wav", samples[0].Name)
+	assert.True(t, samples[1].Loop)
+	assert.Equal(t, [2]int{4, 12}, [2]int{samples[1].LoopStart, samples[1].LoopEnd})
+
+	assert.Equal(t, common.NotemapEntry{Note: 47, Sample: 0}, ins.Notemap[47])
+	assert.Equal(t, common.NotemapEntry{Note: 48, Sample: 1}, ins.Notemap[48])
+	assert.Equal(t, common.NotemapEntry{Note: 61, Sample: 2}, ins.Notemap[61])
+	assert.Equal(t, common.NotemapEntry{Note: 61, Sample: 2}, ins.Notemap[62])
+	assert.Equal(t, common.NotemapEntry{Note: 118, Sample: 2}, ins.Notemap[119])
+	assert.True(t, ins.DefaultPanEnabled)
+	assert.Equal(t, int16(0), ins.DefaultPan)
+
+	_, _, err = Read(fstest.MapFS{"a.sfz": {Data: []byte("lokey=1")}}, "a.sfz")
+	assert.ErrorIs(t, err, ErrInvalidSfz)
+}
@@ -0,0 +1,88 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package modlib
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"go.mukunda.com/modlib/modmod"
+)
+
+// Returned instead of ErrUnknownModuleFormat when the file is a module format that
+// modlib recognizes but can't load yet. The error names the detected format.
+var ErrUnsupportedSource = errors.New("unsupported module format")
+
+// A format recognized by a signature in its header.
+type formatSignature struct {
+	name   string
+	offset int
+	magic  string
+}
+
+// Signatures of formats that can't be loaded, following the checks of OpenMPT and
+// libmagic. Only signatures long enough not to match by accident are listed.
+var unsupportedSignatures = []formatSignature{
+	{"FastTracker 2", 0, "Extended Module: "},
+	{"MultiTracker", 0, "MTM\x10"},
+	{"OctaMED", 0, "MMD0"},
+	{"OctaMED", 0, "MMD1"},
+	{"OctaMED", 0, "MMD2"},
+	{"OctaMED", 0, "MMD3"},
+	{"DIGI Booster", 0, "DIGI Booster module\x00"},
+	{"DigiBooster Pro", 0, "DBM0"},
+	{"Oktalyzer", 0, "OKTASONG"},
+	{"Farandole Composer", 0, "FAR\xFE"},
+	{"UltraTracker", 0, "MAS_UTrack_V00"},
+	{"Extreme's Tracker", 0, "Extreme"},
+	{"Velvet Studio", 0, "AMShdr\x1A"},
+	{"Digitrakker", 0, "DMDL"},
+	{"Epic MegaGames MASI", 0, "PSM "},
+	{"Epic MegaGames MASI (old)", 0, "PSM\xFE"},
+	{"MadTracker 2", 0, "MT20"},
+	{"PolyTracker", 0x2C, "PTMF"},
+	{"Imago Orpheus", 0x3C, "IM10"},
+	{"ASYLUM Music Format", 0, "ASYLUM Music Format V1.0\x00"},
+	{"X-Tracker", 0, "DDMF"},
+	{"General Digital Music", 0, "GDM\xFE"},
+	{"MO3", 0, "MO3"},
+	{"Galaxy Sound System", 0, "MUSE\xDE\xAD\xBE\xAF"},
+	{"Galaxy Sound System", 0, "MUSE\xDE\xAD\xBA\xBE"},
+	{"Scream Tracker 2", 0x14, "!Scream!\x1A"},
+	{"Scream Tracker 2", 0x14, "BMOD2STM\x1A"},
+	{"SoundFX", 0x3C, "SONG"},
+	{"Digital Tracker", 0, "D.T."},
+	{"Symphonie", 0, "SymM"},
+	{"Unreal Music Package", 0, "\xC1\x83\x2A\x9E"},
+	{"FamiTracker", 0, "FamiTracker Module"},
+	{"Renoise", 0, "RNS0"},
+}
+
+// Identify a format that can't be loaded from the start of the file. MOD files are
+// detected with modmod.Detect, which needs to seek around the file. Returns an empty
+// string if nothing matches.
+func detectUnsupported(r io.ReadSeeker, header []byte) string {
+	for _, sig := range unsupportedSignatures {
+		end := sig.offset + len(sig.magic)
+		if end <= len(header) && bytes.Equal(header[sig.offset:end], []byte(sig.magic)) {
+			return sig.name
+		}
+	}
+
+	if layout, err := modmod.Detect(r); err == nil {
+		return layout.Variant + " MOD"
+	}
+	return ""
+}
+
+// The error for a file that no loader accepted.
+func unknownFormatError(r io.ReadSeeker, header []byte) error {
+	if name := detectUnsupported(r, header); name != "" {
+		return fmt.Errorf("%w: detected %s, not supported", ErrUnsupportedSource, name)
+	}
+	return ErrUnknownModuleFormat
+}
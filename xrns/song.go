@@ -0,0 +1,144 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package xrns
+
+import "encoding/xml"
+
+// The parts of Song.xml that are written. Renoise fills in defaults for the rest.
+type song struct {
+	XMLName        xml.Name `xml:"RenoiseSong"`
+	DocVersion     int      `xml:"doc_version,attr"`
+	GlobalSongData globalSongData
+	Instruments    []instrument    `xml:"Instruments>Instrument"`
+	Tracks         tracks          `xml:"Tracks"`
+	Patterns       []pattern       `xml:"PatternPool>Patterns>Pattern"`
+	Sequence       []sequenceEntry `xml:"PatternSequence>SequenceEntries>SequenceEntry"`
+}
+
+type globalSongData struct {
+	BeatsPerMin  float64
+	LinesPerBeat int
+	TicksPerLine int
+	SongName     string
+	SongComments []string `xml:"SongComments>SongComment"`
+}
+
+type instrument struct {
+	Name            string
+	SampleGenerator sampleGenerator
+}
+
+type sampleGenerator struct {
+	Samples        []sample        `xml:"Samples>Sample"`
+	ModulationSets []modulationSet `xml:"ModulationSets>ModulationSet"`
+}
+
+type sample struct {
+	Name          string
+	Volume        float64
+	Panning       float64
+	Transpose     int
+	LoopMode      string
+	LoopRelease   bool
+	LoopStart     int
+	LoopEnd       int
+	NewNoteAction string
+	Mapping       mapping
+}
+
+type mapping struct {
+	Layer               string
+	BaseNote            int
+	NoteStart           int
+	NoteEnd             int
+	MapKeyToPitch       bool
+	VelocityStart       int
+	VelocityEnd         int
+	MapVelocityToVolume bool
+}
+
+type modulationSet struct {
+	Name    string
+	Devices []envelopeDevice `xml:"Devices>SampleEnvelopeModulationDevice"`
+}
+
+type envelopeDevice struct {
+	IsActive        parameter
+	Target          string
+	Operator        string
+	Bipolar         bool
+	SustainIsActive bool
+	SustainPos      int
+	LoopMode        string
+	LoopStart       int
+	LoopEnd         int
+	Length          int
+	Points          []string `xml:"Points>Point"` // "x,y,curve"
+}
+
+type parameter struct {
+	Value float64
+}
+
+type tracks struct {
+	Tracks []track `xml:"SequencerTrack"`
+	Master track   `xml:"SequencerMasterTrack"`
+}
+
+type track struct {
+	Type                         string `xml:"type,attr"`
+	Name                         string
+	State                        string `xml:",omitempty"`
+	NumberOfVisibleNoteColumns   int    `xml:",omitempty"`
+	NumberOfVisibleEffectColumns int    `xml:",omitempty"`
+	VolumeColumnIsVisible        bool
+	PanningColumnIsVisible       bool
+	DelayColumnIsVisible         bool
+	Devices                      []trackDevice `xml:"FilterDevices>Devices>SequencerTrackDevice"`
+}
+
+type trackDevice struct {
+	Panning parameter
+	Volume  parameter
+}
+
+type pattern struct {
+	Name          string `xml:",omitempty"`
+	NumberOfLines int
+	Tracks        patternTracks `xml:"Tracks"`
+}
+
+type patternTracks struct {
+	Tracks []patternTrack `xml:"PatternTrack"`
+	Master patternTrack   `xml:"PatternMasterTrack"`
+}
+
+type patternTrack struct {
+	Type  string `xml:"type,attr"`
+	Lines []line `xml:"Lines>Line"`
+}
+
+type line struct {
+	Index         int            `xml:"index,attr"`
+	NoteColumns   []noteColumn   `xml:"NoteColumns>NoteColumn"`
+	EffectColumns []effectColumn `xml:"EffectColumns>EffectColumn"`
+}
+
+type noteColumn struct {
+	Note       string `xml:",omitempty"`
+	Instrument string `xml:",omitempty"`
+	Volume     string `xml:",omitempty"`
+	Panning    string `xml:",omitempty"`
+	Delay      string `xml:",omitempty"`
+}
+
+type effectColumn struct {
+	Number string
+	Value  string
+}
+
+type sequenceEntry struct {
+	Pattern int
+}
@@ -0,0 +1,469 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+/*
+This package exports modules as Renoise songs (.xrns), a zip file holding the song in
+Song.xml and the samples as separate audio files.
+*/
+package xrns
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+
+	"go.mukunda.com/modlib/common"
+)
+
+// The Song.xml document version written. Renoise upgrades older documents on load.
+const docVersion = 63
+
+// Note names, indexed by semitone.
+var noteNames = [12]string{"C-", "C#", "D-", "D#", "E-", "F-", "F#", "G-", "G#", "A-", "A#", "B-"}
+
+// The Renoise note that plays a sample at its sample rate. Module note C-5 plays a sample
+// at its C5 speed, and keeps its name in Renoise.
+const baseNote = 60
+
+// Write the module as a Renoise song. Each channel becomes a track with one note column,
+// and each instrument becomes a Renoise instrument with a key zone for every run of keys
+// in its notemap that plays one sample at one transpose. Modules without instruments get
+// an instrument for each sample. Volume, panning and pitch envelopes become envelope
+// modulation, timed in milliseconds at the initial tempo.
+//
+// Renoise times lines with BPM and lines per beat rather than ticks, so speed and tempo
+// changes are written as BPM changes. Effects without a Renoise command, like position
+// jumps, pattern loops and global volume, are dropped, and the volume column keeps only
+// volumes and panning.
+func Write(w io.Writer, m *common.Module) error {
+	m, err := m.WithLoadedStreams()
+	if err != nil {
+		return err
+	}
+	e := &exporter{module: m, zip: zip.NewWriter(w)}
+	s := e.song()
+
+	f, err := e.zip.Create("Song.xml")
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(f, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(f)
+	enc.Indent("", "  ")
+	if err := enc.Encode(s); err != nil {
+		return err
+	}
+
+	for _, file := range e.files {
+		f, err := e.zip.Create(file.path)
+		if err != nil {
+			return err
+		}
+		if err := common.WriteWav(f, &m.Samples[file.sample]); err != nil {
+			return err
+		}
+	}
+	return e.zip.Close()
+}
+
+// A sample file to add to the zip.
+type sampleFile struct {
+	path   string
+	sample int
+}
+
+type exporter struct {
+	module *common.Module
+	zip    *zip.Writer
+	files  []sampleFile
+
+	linesPerBeat int
+	effectCols   []int // Effect columns needed in each track.
+}
+
+// Timing state while converting patterns in order.
+type timing struct {
+	speed int
+	tempo int
+}
+
+func (e *exporter) song() *song {
+	m := e.module
+	e.linesPerBeat = int(m.PatternHighlight_Beat)
+	if e.linesPerBeat < 1 || e.linesPerBeat > 256 {
+		e.linesPerBeat = 4
+	}
+	start := timing{speed: max(int(m.InitialSpeed), 1), tempo: max(int(m.InitialTempo), 32)}
+	channels := max(int(m.Channels), 1)
+	e.effectCols = make([]int, channels)
+
+	s := &song{
+		DocVersion: docVersion,
+		GlobalSongData: globalSongData{
+			BeatsPerMin:  e.bpm(start),
+			LinesPerBeat: e.linesPerBeat,
+			TicksPerLine: start.speed,
+			SongName:     m.Title,
+		},
+	}
+	if m.Message != "" {
+		s.GlobalSongData.SongComments = strings.Split(strings.ReplaceAll(m.Message, "\r", "\n"), "\n")
+	}
+
+	if m.UseInstruments {
+		for i := range m.Instruments {
+			s.Instruments = append(s.Instruments, e.instrument(i, &m.Instruments[i]))
+		}
+	} else {
+		for i := range m.Samples {
+			ins := common.Instrument{Name: m.Samples[i].Name, GlobalVolume: 128}
+			for key := range ins.Notemap {
+				ins.Notemap[key] = common.NotemapEntry{Note: int16(key), Sample: int16(i + 1)}
+			}
+			s.Instruments = append(s.Instruments, e.instrument(i, &ins))
+		}
+	}
+
+	// Patterns are converted in order, so speed and tempo changes see the timing of the
+	// song at that point.
+	s.Patterns = make([]pattern, len(m.Patterns))
+	done := make([]bool, len(m.Patterns))
+	t := start
+	for _, index := range m.Order {
+		if int(index) >= len(m.Patterns) {
+			continue
+		}
+		s.Sequence = append(s.Sequence, sequenceEntry{Pattern: int(index)})
+		if !done[index] {
+			done[index] = true
+			s.Patterns[index] = e.pattern(&m.Patterns[index], channels, &t)
+		}
+	}
+	for i := range m.Patterns {
+		if !done[i] {
+			t := start
+			s.Patterns[i] = e.pattern(&m.Patterns[i], channels, &t)
+		}
+	}
+	if len(s.Sequence) == 0 {
+		// Renoise songs have at least one pattern in the sequence.
+		if len(s.Patterns) == 0 {
+			s.Patterns = append(s.Patterns, e.pattern(&common.Pattern{Rows: make([]common.PatternRow, 64)}, channels, &t))
+		}
+		s.Sequence = append(s.Sequence, sequenceEntry{Pattern: 0})
+	}
+
+	for c := range channels {
+		s.Tracks.Tracks = append(s.Tracks.Tracks, e.track(c))
+	}
+	s.Tracks.Master = track{Type: "SequencerMasterTrack", Name: "Master"}
+	return s
+}
+
+// The BPM that gives lines the duration of rows at the speed and tempo.
+func (e *exporter) bpm(t timing) float64 {
+	return 24 * float64(t.tempo) / float64(t.speed*e.linesPerBeat)
+}
+
+func (e *exporter) track(c int) track {
+	t := track{
+		Type:                         "SequencerTrack",
+		Name:                         fmt.Sprintf("Track %02d", c+1),
+		State:                        "Active",
+		NumberOfVisibleNoteColumns:   1,
+		NumberOfVisibleEffectColumns: max(e.effectCols[c], 1),
+		VolumeColumnIsVisible:        true,
+		PanningColumnIsVisible:       true,
+		DelayColumnIsVisible:         true,
+	}
+	device := trackDevice{Volume: parameter{1}, Panning: parameter{0.5}}
+	if c < len(e.module.ChannelSettings) {
+		cs := &e.module.ChannelSettings[c]
+		if cs.Name != "" {
+			t.Name = cs.Name
+		}
+		if cs.Mute {
+			t.State = "Muted"
+		}
+		device.Volume.Value = float64(cs.InitialVolume) / 64
+		device.Panning.Value = float64(cs.InitialPan) / 64
+	}
+	t.Devices = []trackDevice{device}
+	return t
+}
+
+func (e *exporter) pattern(p *common.Pattern, channels int, t *timing) pattern {
+	result := pattern{Name: p.Name, NumberOfLines: max(len(p.Rows), 1)}
+	result.Tracks.Tracks = make([]patternTrack, channels)
+	for c := range result.Tracks.Tracks {
+		result.Tracks.Tracks[c].Type = "PatternTrack"
+	}
+	result.Tracks.Master.Type = "PatternMasterTrack"
+
+	for r := range p.Rows {
+		for _, entry := range p.Rows[r].Entries {
+			c := int(entry.Channel)
+			if c >= channels {
+				continue
+			}
+			l := e.line(entry, t)
+			if l == nil {
+				continue
+			}
+			l.Index = r
+			e.effectCols[c] = max(e.effectCols[c], len(l.EffectColumns))
+			track := &result.Tracks.Tracks[c]
+			track.Lines = append(track.Lines, *l)
+		}
+	}
+	return result
+}
+
+// Convert a pattern entry. Returns nil if nothing is left of it.
+func (e *exporter) line(entry common.PatternEntry, t *timing) *line {
+	var nc noteColumn
+	switch {
+	case entry.Note >= 1 && entry.Note <= 120:
+		n := int(entry.Note) - 1
+		nc.Note = fmt.Sprintf("%s%d", noteNames[n%12], n/12)
+	case entry.Note >= common.NoteFade:
+		nc.Note = "OFF"
+	}
+	if entry.Instrument > 0 {
+		nc.Instrument = fmt.Sprintf("%02X", entry.Instrument-1)
+	}
+	switch entry.VolumeCommand {
+	case common.VcmdSetVolume:
+		nc.Volume = fmt.Sprintf("%02X", min(int(entry.VolumeParam), 64)*2)
+	case common.VcmdSetPan:
+		nc.Panning = fmt.Sprintf("%02X", min(int(entry.VolumeParam), 64)*2)
+	}
+
+	effects := e.effect(entry.Effect, entry.EffectParam, t, &nc)
+
+	l := &line{EffectColumns: effects}
+	if nc != (noteColumn{}) {
+		l.NoteColumns = []noteColumn{nc}
+	}
+	if l.NoteColumns == nil && l.EffectColumns == nil {
+		return nil
+	}
+	return l
+}
+
+// Renoise commands for an effect. Note delays go in the delay column of the note.
+func (e *exporter) effect(effect, param uint8, t *timing, nc *noteColumn) []effectColumn {
+	command := func(number string, value int) []effectColumn {
+		return []effectColumn{{Number: number, Value: fmt.Sprintf("%02X", min(max(value, 0), 255))}}
+	}
+	x, y := int(param>>4), int(param&15)
+
+	switch effect {
+	case 1: // Axx, set speed
+		if param == 0 {
+			return nil
+		}
+		t.speed = int(param)
+		return append(command("ZK", int(param)), command("ZT", int(e.bpm(*t)+0.5))...)
+	case 3: // Cxx, pattern break
+		return command("ZB", int(param))
+	case 4: // Dxy, volume slide
+		switch {
+		case y == 0 && x != 0:
+			return command("0I", x*2)
+		case x == 0 && y != 0:
+			return command("0O", y*2)
+		}
+	case 5: // Exx, portamento down
+		if x < 0xE {
+			return command("0D", int(param))
+		}
+	case 6: // Fxx, portamento up
+		if x < 0xE {
+			return command("0U", int(param))
+		}
+	case 7: // Gxx, tone portamento
+		return command("0G", int(param))
+	case 8: // Hxy, vibrato
+		return command("0V", int(param))
+	case 10: // Jxy, arpeggio
+		return command("0A", int(param))
+	case 13: // Mxx, channel volume
+		return command("0L", min(int(param), 64)*3)
+	case 17: // Qxy, retrigger
+		return command("0R", int(param))
+	case 18: // Rxy, tremolo
+		return command("0T", int(param))
+	case 19: // Sxy
+		switch x {
+		case 0xC:
+			return command("0C", y)
+		case 0xD:
+			nc.Delay = fmt.Sprintf("%02X", min(y*256/t.speed, 255))
+		case 0xE:
+			return command("ZD", y)
+		}
+	case 20: // Txx, tempo
+		if param >= 0x20 {
+			t.tempo = int(param)
+			return command("ZT", int(e.bpm(*t)+0.5))
+		}
+	case 24: // Xxx, panning
+		return command("0P", int(param))
+	}
+	return nil
+}
+
+// Convert an instrument and add its sample files.
+func (e *exporter) instrument(index int, ins *common.Instrument) instrument {
+	m := e.module
+	result := instrument{Name: ins.Name}
+	folder := fmt.Sprintf("SampleData/Instrument%02d (%s)", index, fileName(ins.Name))
+
+	nna := "Cut"
+	switch ins.NewNoteAction {
+	case common.NnaContinue:
+		nna = "Sustain"
+	case common.NnaNoteOff, common.NnaFade:
+		nna = "NoteOff"
+	}
+
+	for key := 0; key < len(ins.Notemap); {
+		entry := ins.Notemap[key]
+		end := key + 1
+		for end < len(ins.Notemap) && ins.Notemap[end].Sample == entry.Sample &&
+			int(ins.Notemap[end].Note)-end == int(entry.Note)-key {
+			end++
+		}
+		start := key
+		key = end
+
+		if entry.Sample < 1 || int(entry.Sample) > len(m.Samples) {
+			continue
+		}
+		s := &m.Samples[entry.Sample-1]
+		if !s.HasData() {
+			continue
+		}
+
+		number := len(result.SampleGenerator.Samples)
+		name := s.Name
+		e.files = append(e.files, sampleFile{
+			path:   fmt.Sprintf("%s/Sample%02d (%s).wav", folder, number, fileName(name)),
+			sample: int(entry.Sample) - 1,
+		})
+
+		pan := 0.5
+		if s.DefaultPanning&128 != 0 {
+			pan = float64(s.DefaultPanning&127) / 64
+		}
+		if ins.DefaultPanEnabled {
+			pan = float64(ins.DefaultPan) / 64
+		}
+
+		rs := sample{
+			Name:          name,
+			Volume:        float64(s.GlobalVolume) / 64 * float64(s.DefaultVolume) / 64 * float64(ins.GlobalVolume) / 128,
+			Panning:       min(max(pan, 0), 1),
+			Transpose:     int(entry.Note) - start,
+			LoopMode:      "Off",
+			NewNoteAction: nna,
+			Mapping: mapping{
+				Layer:               "Note-On",
+				BaseNote:            baseNote,
+				NoteStart:           start,
+				NoteEnd:             end - 1,
+				MapKeyToPitch:       true,
+				VelocityEnd:         127,
+				MapVelocityToVolume: true,
+			},
+		}
+		switch {
+		case s.Sustain && s.SustainLoopEnd > s.SustainLoopStart:
+			rs.LoopMode = loopMode(s.PingPongSustain)
+			rs.LoopStart, rs.LoopEnd = s.SustainLoopStart, s.SustainLoopEnd-1
+			rs.LoopRelease = true
+		case s.Loop && s.LoopEnd > s.LoopStart:
+			rs.LoopMode = loopMode(s.PingPong)
+			rs.LoopStart, rs.LoopEnd = s.LoopStart, s.LoopEnd-1
+		}
+		result.SampleGenerator.Samples = append(result.SampleGenerator.Samples, rs)
+	}
+
+	set := modulationSet{Name: ins.Name}
+	for _, env := range ins.Envelopes {
+		if device, ok := e.envelope(&env); ok {
+			set.Devices = append(set.Devices, device)
+		}
+	}
+	result.SampleGenerator.ModulationSets = []modulationSet{set}
+	return result
+}
+
+func loopMode(pingpong bool) string {
+	if pingpong {
+		return "PingPong"
+	}
+	return "Forward"
+}
+
+// Convert an envelope to envelope modulation. Ticks become milliseconds at the initial
+// tempo.
+func (e *exporter) envelope(env *common.Envelope) (envelopeDevice, bool) {
+	if !env.Enabled || len(env.Nodes) == 0 {
+		return envelopeDevice{}, false
+	}
+	device := envelopeDevice{IsActive: parameter{1}, Operator: "Multiply"}
+	scale := func(y int16) float64 { return (float64(y) + 32) / 64 }
+	switch env.Type {
+	case common.EnvelopeTypeVolume:
+		device.Target = "Volume"
+		scale = func(y int16) float64 { return float64(y) / 64 }
+	case common.EnvelopeTypePanning:
+		device.Target = "Panning"
+		device.Bipolar = true
+		device.Operator = "Add"
+	case common.EnvelopeTypePitch:
+		device.Target = "Pitch"
+		device.Bipolar = true
+		device.Operator = "Add"
+	default:
+		return envelopeDevice{}, false
+	}
+
+	ms := func(x int16) int {
+		return int(float64(x)*2500/float64(max(e.module.InitialTempo, 32)) + 0.5)
+	}
+	node := func(i int16) int {
+		return ms(env.Nodes[min(max(int(i), 0), len(env.Nodes)-1)].X)
+	}
+	for _, n := range env.Nodes {
+		device.Points = append(device.Points, fmt.Sprintf("%d,%g,0.0", ms(n.X), min(max(scale(n.Y), 0), 1)))
+	}
+	device.Length = ms(env.Nodes[len(env.Nodes)-1].X) + 1
+	device.LoopMode = "Off"
+	if env.Loop {
+		device.LoopMode = "Forward"
+		device.LoopStart, device.LoopEnd = node(env.LoopStart), node(env.LoopEnd)
+	}
+	if env.Sustain {
+		device.SustainIsActive = true
+		device.SustainPos = node(env.SustainStart)
+	}
+	return device, true
+}
+
+// Replace characters that aren't safe in zip paths.
+func fileName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\:*?"<>|`, r) || r < 32 {
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(name))
+}
@@ -0,0 +1,136 @@
+// modlib
+// (C) 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package xrns
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mukunda.com/modlib/common"
+	"go.mukunda.com/modlib/itmod"
+)
+
+// Write a module and read back Song.xml and the list of files.
+func export(t *testing.T, m *common.Module) (*song, []string) {
+	var buf bytes.Buffer
+	assert.NoError(t, Write(&buf, m))
+
+	z, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	var files []string
+	s := &song{}
+	for _, f := range z.File {
+		files = append(files, f.Name)
+		if f.Name == "Song.xml" {
+			r, err := f.Open()
+			assert.NoError(t, err)
+			data, err := io.ReadAll(r)
+			assert.NoError(t, err)
+			assert.NoError(t, xml.Unmarshal(data, s))
+		}
+	}
+	return s, files
+}
+
+func TestWrite(t *testing.T) {
+	data, _ := common.FromFloat64([][]float64{make([]float64, 100)}, 16)
+	m := &common.Module{
+		Title:          "test",
+		InitialSpeed:   6,
+		InitialTempo:   125,
+		Channels:       2,
+		UseInstruments: true,
+		Order:          []int16{0, 0},
+		Samples: []common.Sample{
+			{Name: "kick", GlobalVolume: 64, DefaultVolume: 64, DefaultPanning: 128 | 16, C5: 8363, Loop: true, LoopStart: 10, LoopEnd: 90, Data: data},
+		},
+		Instruments: []common.Instrument{{
+			Name:         "drum",
+			GlobalVolume: 64,
+			Envelopes: []common.Envelope{{
+				Enabled: true, Type: common.EnvelopeTypeVolume, Sustain: true, SustainStart: 1,
+				Nodes: []common.EnvelopeNode{{X: 0, Y: 64}, {X: 50, Y: 32}, {X: 100, Y: 0}},
+			}},
+		}},
+		Patterns: []common.Pattern{{Rows: make([]common.PatternRow, 4)}},
+	}
+	for key := range m.Instruments[0].Notemap {
+		m.Instruments[0].Notemap[key] = common.NotemapEntry{Note: int16(key), Sample: 1}
+	}
+	m.Instruments[0].Notemap[0].Note = 12
+	p := &m.Patterns[0]
+	p.Rows[0].Set(common.PatternEntry{Channel: 0, Note: 61, Instrument: 1, VolumeCommand: common.VcmdSetVolume, VolumeParam: 32})
+	p.Rows[1].Set(common.PatternEntry{Channel: 1, Effect: 1, EffectParam: 3})
+	p.Rows[2].Set(common.PatternEntry{Channel: 0, Note: 255, Effect: 19, EffectParam: 0xD1})
+	p.Rows[3].Set(common.PatternEntry{Channel: 0, Effect: 10, EffectParam: 0x37})
+
+	s, files := export(t, m)
+	assert.Equal(t, []string{"Song.xml",
+		"SampleData/Instrument00 (drum)/Sample00 (kick).wav",
+		"SampleData/Instrument00 (drum)/Sample01 (kick).wav",
+	}, files)
+
+	assert.Equal(t, 125.0, s.GlobalSongData.BeatsPerMin)
+	assert.Equal(t, 4, s.GlobalSongData.LinesPerBeat)
+	assert.Equal(t, 6, s.GlobalSongData.TicksPerLine)
+	assert.Equal(t, "test", s.GlobalSongData.SongName)
+	assert.Equal(t, []sequenceEntry{{0}, {0}}, s.Sequence)
+	assert.Len(t, s.Tracks.Tracks, 2)
+	assert.Equal(t, 2, s.Tracks.Tracks[1].NumberOfVisibleEffectColumns)
+
+	// The first key is transposed, so it gets its own zone.
+	samples := s.Instruments[0].SampleGenerator.Samples
+	assert.Len(t, samples, 2)
+	assert.Equal(t, mapping{Layer: "Note-On", BaseNote: 60, NoteStart: 0, NoteEnd: 0, MapKeyToPitch: true, VelocityEnd: 127, MapVelocityToVolume: true}, samples[0].Mapping)
+	assert.Equal(t, 12, samples[0].Transpose)
+	assert.Equal(t, 1, samples[1].Mapping.NoteStart)
+	assert.Equal(t, 119, samples[1].Mapping.NoteEnd)
+	assert.Equal(t, 0, samples[1].Transpose)
+	assert.Equal(t, 0.5, samples[1].Volume)
+	assert.Equal(t, 0.25, samples[1].Panning)
+	assert.Equal(t, "Forward", samples[1].LoopMode)
+	assert.Equal(t, 89, samples[1].LoopEnd)
+
+	devices := s.Instruments[0].SampleGenerator.ModulationSets[0].Devices
+	assert.Len(t, devices, 1)
+	assert.Equal(t, "Volume", devices[0].Target)
+	assert.Equal(t, []string{"0,1,0.0", "1000,0.5,0.0", "2000,0,0.0"}, devices[0].Points)
+	assert.Equal(t, 1000, devices[0].SustainPos)
+
+	lines := s.Patterns[0].Tracks.Tracks[0].Lines
+	assert.Len(t, lines, 3)
+	assert.Equal(t, []noteColumn{{Note: "C-5", Instrument: "00", Volume: "40"}}, lines[0].NoteColumns)
+	assert.Equal(t, []noteColumn{{Note: "OFF", Delay: "55"}}, lines[1].NoteColumns)
+	assert.Equal(t, []effectColumn{{"0A", "37"}}, lines[2].EffectColumns)
+
+	// Speed 3 at tempo 125 needs twice the BPM.
+	lines = s.Patterns[0].Tracks.Tracks[1].Lines
+	assert.Equal(t, 1, lines[0].Index)
+	assert.Equal(t, []effectColumn{{"ZK", "03"}, {"ZT", "FA"}}, lines[0].EffectColumns)
+}
+
+func TestWriteIt(t *testing.T) {
+	it, err := itmod.LoadITFile("../itmod/test/reflection.it")
+	if !assert.NoError(t, err) {
+		return
+	}
+	m := it.ToCommon()
+
+	s, files := export(t, m)
+	assert.Len(t, s.Patterns, len(m.Patterns))
+	assert.Len(t, s.Tracks.Tracks, int(m.Channels))
+	assert.Len(t, s.Instruments, len(m.Instruments))
+	zones := 0
+	for _, ins := range s.Instruments {
+		zones += len(ins.SampleGenerator.Samples)
+	}
+	assert.Equal(t, zones+1, len(files))
+}